@@ -9,6 +9,7 @@ import (
 	"github.com/grafana/grafana/pkg/services/dashboards"
 	"github.com/grafana/grafana/pkg/services/datasources"
 	"github.com/grafana/grafana/pkg/services/org"
+	secretsKV "github.com/grafana/grafana/pkg/services/secrets/kvstore"
 	"github.com/grafana/grafana/pkg/services/serviceaccounts"
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/grafana/grafana/pkg/tsdb/grafanads"
@@ -26,6 +27,9 @@ const (
 	ActionOrgsQuotasWrite      = "orgs.quotas:write"
 	ActionOrgsDelete           = "orgs:delete"
 	ActionOrgsCreate           = "orgs:create"
+
+	ActionKVStoreRead       = "kvstore:read"
+	ActionKVStoreValuesRead = "kvstore.values:read"
 )
 
 // API related scopes
@@ -146,6 +150,14 @@ func (hs *HTTPServer) declareFixedRoles() error {
 					Action: datasources.ActionDelete,
 					Scope:  datasources.ScopeAll,
 				},
+				{
+					Action: secretsKV.ActionSecretsWrite,
+					Scope:  secretsKV.ScopeSecret(secretsKV.DataSourceSecretType, "*"),
+				},
+				{
+					Action: secretsKV.ActionSecretsDelete,
+					Scope:  secretsKV.ScopeSecret(secretsKV.DataSourceSecretType, "*"),
+				},
 			}),
 		},
 		Grants: []string{string(org.RoleAdmin)},
@@ -192,6 +204,7 @@ func (hs *HTTPServer) declareFixedRoles() error {
 			Permissions: ac.ConcatPermissions(apikeyReaderRole.Role.Permissions, []ac.Permission{
 				{
 					Action: ac.ActionAPIKeyCreate,
+					Scope:  ac.ScopeAPIKeysAll,
 				},
 				{
 					Action: ac.ActionAPIKeyDelete,
@@ -202,6 +215,22 @@ func (hs *HTTPServer) declareFixedRoles() error {
 		Grants: []string{string(org.RoleAdmin)},
 	}
 
+	apikeyApproverRole := ac.RoleRegistration{
+		Role: ac.RoleDTO{
+			Name:        "fixed:apikeys:approver",
+			DisplayName: "APIKeys approver",
+			Description: "Gives access to approve or reject api keys created by users who cannot approve them.",
+			Group:       "API Keys",
+			Permissions: []ac.Permission{
+				{
+					Action: ac.ActionAPIKeyApprove,
+					Scope:  ac.ScopeAPIKeysAll,
+				},
+			},
+		},
+		Grants: []string{string(org.RoleAdmin)},
+	}
+
 	orgReaderRole := ac.RoleRegistration{
 		Role: ac.RoleDTO{
 			Name:        "fixed:organization:reader",
@@ -419,13 +448,40 @@ func (hs *HTTPServer) declareFixedRoles() error {
 		Grants: []string{"Admin"},
 	}
 
+	kvstoreReaderRole := ac.RoleRegistration{
+		Role: ac.RoleDTO{
+			Name:        "fixed:kvstore:reader",
+			DisplayName: "Key/value store reader",
+			Description: "List the namespaces and the keys of the key/value store.",
+			Group:       "Key/value store",
+			Permissions: []ac.Permission{
+				{Action: ActionKVStoreRead},
+			},
+		},
+		Grants: []string{ac.RoleGrafanaAdmin},
+	}
+
+	kvstoreValuesReaderRole := ac.RoleRegistration{
+		Role: ac.RoleDTO{
+			Name:        "fixed:kvstore.values:reader",
+			DisplayName: "Key/value store values reader",
+			Description: "List the keys of the key/value store and read their values, including the decrypted values of the sensitive namespaces.",
+			Group:       "Key/value store",
+			Permissions: ac.ConcatPermissions(kvstoreReaderRole.Role.Permissions, []ac.Permission{
+				{Action: ActionKVStoreValuesRead},
+			}),
+		},
+		Grants: []string{ac.RoleGrafanaAdmin},
+	}
+
 	return hs.AccessControl.DeclareFixedRoles(
 		provisioningWriterRole, datasourcesReaderRole, builtInDatasourceReader, datasourcesWriterRole,
 		datasourcesIdReaderRole, orgReaderRole, orgWriterRole,
 		orgMaintainerRole, teamsCreatorRole, teamsWriterRole, datasourcesExplorerRole,
 		annotationsReaderRole, dashboardAnnotationsWriterRole, annotationsWriterRole,
 		dashboardsCreatorRole, dashboardsReaderRole, dashboardsWriterRole,
-		foldersCreatorRole, foldersReaderRole, foldersWriterRole, apikeyReaderRole, apikeyWriterRole,
+		foldersCreatorRole, foldersReaderRole, foldersWriterRole, apikeyReaderRole, apikeyWriterRole, apikeyApproverRole,
+		kvstoreReaderRole, kvstoreValuesReaderRole,
 	)
 }
 
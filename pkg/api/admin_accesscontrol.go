@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana/pkg/api/response"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/accesscontrol/decisionlog"
+)
+
+// maxAccessControlDecisionsPerPage is the largest page of the access control decisions returned at once
+const maxAccessControlDecisionsPerPage = 1000
+
+func (hs *HTTPServer) AdminSearchAccessControlDecisions(c *models.ReqContext) response.Response {
+	if hs.accessControlDecisions.IsDisabled() {
+		return response.Error(http.StatusNotFound, "Access control decision log is not enabled", nil)
+	}
+
+	query := decisionlog.Query{
+		Action:  c.Query("action"),
+		Page:    c.QueryInt("page"),
+		PerPage: c.QueryInt("perpage"),
+	}
+	if c.Query("orgId") != "" {
+		orgId := c.QueryInt64("orgId")
+		query.OrgId = &orgId
+	}
+	if c.Query("userId") != "" {
+		userId := c.QueryInt64("userId")
+		query.UserId = &userId
+	}
+	if c.Query("allowed") != "" {
+		allowed := c.QueryBool("allowed")
+		query.Allowed = &allowed
+	}
+	if from := c.QueryInt64("from"); from > 0 {
+		query.From = time.UnixMilli(from)
+	}
+	if to := c.QueryInt64("to"); to > 0 {
+		query.To = time.UnixMilli(to)
+	}
+	if query.PerPage > maxAccessControlDecisionsPerPage {
+		query.PerPage = maxAccessControlDecisionsPerPage
+	}
+
+	result, err := hs.accessControlDecisions.Search(c.Req.Context(), query)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to search the access control decisions", err)
+	}
+
+	return response.JSON(http.StatusOK, result)
+}
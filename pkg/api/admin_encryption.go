@@ -1,10 +1,15 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/grafana/grafana/pkg/api/dtos"
 	"github.com/grafana/grafana/pkg/api/response"
 	"github.com/grafana/grafana/pkg/models"
+	secretsKV "github.com/grafana/grafana/pkg/services/secrets/kvstore"
+	"github.com/grafana/grafana/pkg/web"
 )
 
 func (hs *HTTPServer) AdminRotateDataEncryptionKeys(c *models.ReqContext) response.Response {
@@ -48,3 +53,211 @@ func (hs *HTTPServer) AdminRollbackSecrets(c *models.ReqContext) response.Respon
 
 	return response.Respond(http.StatusOK, "Secrets rolled back successfully")
 }
+
+// AdminGetDataKeysUsage returns the data keys along with the number of secrets encrypted with each of them
+func (hs *HTTPServer) AdminGetDataKeysUsage(c *models.ReqContext) response.Response {
+	usage, err := hs.secretsMigrator.DataKeyUsage(c.Req.Context())
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to get the data keys usage", err)
+	}
+
+	return response.JSON(http.StatusOK, usage)
+}
+
+func (hs *HTTPServer) AdminGetSecretsPluginMigrationStatus(c *models.ReqContext) response.Response {
+	if hs.pluginSecretMigration == nil {
+		return response.Error(http.StatusNotFound, "Secrets plugin migration not available", nil)
+	}
+
+	return response.JSON(http.StatusOK, hs.pluginSecretMigration.Status())
+}
+
+// maxSecretsAuditPerPage is the largest page of the secrets audit trail returned at once
+const maxSecretsAuditPerPage = 1000
+
+func (hs *HTTPServer) AdminSearchSecretsAudit(c *models.ReqContext) response.Response {
+	if hs.secretsAudit.IsDisabled() {
+		return response.Error(http.StatusNotFound, "Secrets audit is not enabled", nil)
+	}
+
+	query := secretsKV.AuditQuery{
+		Namespace: c.Query("namespace"),
+		Type:      c.Query("type"),
+		Operation: c.Query("operation"),
+		Page:      c.QueryInt("page"),
+		PerPage:   c.QueryInt("perpage"),
+	}
+	if c.Query("orgId") != "" {
+		orgId := c.QueryInt64("orgId")
+		query.OrgId = &orgId
+	}
+	if from := c.QueryInt64("from"); from > 0 {
+		query.From = time.UnixMilli(from)
+	}
+	if to := c.QueryInt64("to"); to > 0 {
+		query.To = time.UnixMilli(to)
+	}
+	if query.PerPage > maxSecretsAuditPerPage {
+		query.PerPage = maxSecretsAuditPerPage
+	}
+
+	result, err := hs.secretsAudit.Search(c.Req.Context(), query)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to search the secrets audit", err)
+	}
+
+	return response.JSON(http.StatusOK, result)
+}
+
+func (hs *HTTPServer) AdminExportSecrets(c *models.ReqContext) response.Response {
+	cmd := dtos.ExportSecretsCmd{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	bundle, err := hs.secretsExport.Export(c.Req.Context(), secretsKV.SecretsExportOptions{
+		Passphrase:  cmd.Passphrase,
+		KeyProvider: cmd.KeyProvider,
+		OrgIds:      cmd.OrgIds,
+	})
+	if err != nil {
+		if errors.Is(err, secretsKV.ErrSecretsBundleKey) || errors.Is(err, secretsKV.ErrUnknownKeyProvider) {
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to export secrets", err)
+	}
+
+	return response.Respond(http.StatusOK, bundle).SetHeader("Content-Type", "application/json")
+}
+
+func (hs *HTTPServer) AdminImportSecrets(c *models.ReqContext) response.Response {
+	cmd := dtos.ImportSecretsCmd{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	result, err := hs.secretsExport.Import(c.Req.Context(), cmd.Bundle, secretsKV.SecretsImportOptions{
+		Passphrase: cmd.Passphrase,
+		OrgMapping: cmd.OrgMapping,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, secretsKV.ErrInvalidSecretsBundle), errors.Is(err, secretsKV.ErrSecretsBundleDecryption),
+			errors.Is(err, secretsKV.ErrSecretsBundleKey), errors.Is(err, secretsKV.ErrUnknownKeyProvider),
+			errors.Is(err, secretsKV.ErrSecretsBundleOrgMissing):
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to import secrets", err)
+	}
+
+	return response.JSON(http.StatusOK, result)
+}
+
+// AdminGetSecretsPluginQuarantine returns the quarantine of the secrets manager plugin, with http status code 404
+// when the plugin is not quarantined
+func (hs *HTTPServer) AdminGetSecretsPluginQuarantine(c *models.ReqContext) response.Response {
+	quarantine, err := hs.secretsPluginQuarantine.Get(c.Req.Context())
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to get the secrets manager plugin quarantine", err)
+	}
+	if quarantine == nil {
+		return response.Error(http.StatusNotFound, "Secrets manager plugin is not quarantined", nil)
+	}
+
+	return response.JSON(http.StatusOK, quarantine)
+}
+
+// AdminLiftSecretsPluginQuarantine lifts the quarantine of the secrets manager plugin, once it was fixed
+func (hs *HTTPServer) AdminLiftSecretsPluginQuarantine(c *models.ReqContext) response.Response {
+	if err := hs.secretsPluginQuarantine.Lift(c.Req.Context()); err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to lift the secrets manager plugin quarantine", err)
+	}
+
+	return response.Success("Secrets manager plugin quarantine lifted")
+}
+
+// AdminReloadSecretsPlugin restarts the secrets manager plugin with the current plugin settings
+func (hs *HTTPServer) AdminReloadSecretsPlugin(c *models.ReqContext) response.Response {
+	if err := hs.secretsPluginReload.Reload(c.Req.Context()); err != nil {
+		switch {
+		case errors.Is(err, secretsKV.ErrSecretsPluginNotReloadable):
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		case errors.Is(err, secretsKV.ErrSecretsPluginReloadInProgress):
+			return response.Error(http.StatusConflict, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to reload the secrets manager plugin", err)
+	}
+
+	return response.Success("Secrets manager plugin reloaded")
+}
+
+// AdminGetSecretsRoutes returns the backends of the secrets and how the secrets are routed to them
+func (hs *HTTPServer) AdminGetSecretsRoutes(c *models.ReqContext) response.Response {
+	routes, err := hs.secretsRouting.Routes(c.Req.Context())
+	if err != nil {
+		if errors.Is(err, secretsKV.ErrSecretsRoutingDisabled) {
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to get the secrets routes", err)
+	}
+
+	return response.JSON(http.StatusOK, routes)
+}
+
+// AdminMoveSecretsNamespace moves the secrets of a namespace to another backend of the routing
+func (hs *HTTPServer) AdminMoveSecretsNamespace(c *models.ReqContext) response.Response {
+	cmd := dtos.MoveSecretsNamespaceCmd{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	result, err := hs.secretsRouting.Move(c.Req.Context(), cmd.Namespace, cmd.Backend)
+	if err != nil {
+		switch {
+		case errors.Is(err, secretsKV.ErrSecretsRoutingDisabled), errors.Is(err, secretsKV.ErrSecretsBackendNotRouted),
+			errors.Is(err, secretsKV.ErrInvalidSecretsNamespace):
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		case errors.Is(err, secretsKV.ErrSecretsNamespaceMoving):
+			return response.Error(http.StatusConflict, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to move the secrets of the namespace", err)
+	}
+
+	return response.JSON(http.StatusOK, result)
+}
+
+func (hs *HTTPServer) AdminCheckSecretsConsistency(c *models.ReqContext) response.Response {
+	return hs.checkSecretsConsistency(c, false)
+}
+
+// AdminPruneSecrets deletes the secrets of the secrets store that no data source references
+func (hs *HTTPServer) AdminPruneSecrets(c *models.ReqContext) response.Response {
+	return hs.checkSecretsConsistency(c, true)
+}
+
+func (hs *HTTPServer) checkSecretsConsistency(c *models.ReqContext, prune bool) response.Response {
+	report, err := hs.secretsConsistency.Check(c.Req.Context(), prune)
+	if err != nil {
+		if errors.Is(err, secretsKV.ErrKeyListingNotSupported) {
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to check the consistency of the secrets", err)
+	}
+
+	return response.JSON(http.StatusOK, report)
+}
+
+func (hs *HTTPServer) AdminGetSecretsMirrorDivergence(c *models.ReqContext) response.Response {
+	report, err := hs.secretsConsistency.CheckMirror(c.Req.Context())
+	if err != nil {
+		switch {
+		case errors.Is(err, secretsKV.ErrSecretsMirrorDisabled):
+			return response.Error(http.StatusNotFound, "Secrets are not mirrored", err)
+		case errors.Is(err, secretsKV.ErrKeyListingNotSupported):
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to compare the mirrored secrets", err)
+	}
+
+	return response.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/grafana/grafana/pkg/api/response"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/models"
+	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/web"
+)
+
+// AdminListKVStoreNamespaces lists the namespaces of the kvstore with their number of keys
+func (hs *HTTPServer) AdminListKVStoreNamespaces(c *models.ReqContext) response.Response {
+	namespaces, err := kvstore.ListNamespaces(c.Req.Context(), hs.kvStore)
+	if err != nil {
+		if errors.Is(err, kvstore.ErrInspectionNotSupported) {
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to list the kvstore namespaces", err)
+	}
+
+	return response.JSON(http.StatusOK, namespaces)
+}
+
+// AdminDumpKVStoreNamespace dumps the items of a kvstore namespace, for all the organizations unless `orgId` is set.
+// The values are redacted unless `showValues` is set and the user has the `kvstore.values:read` permission.
+func (hs *HTTPServer) AdminDumpKVStoreNamespace(c *models.ReqContext) response.Response {
+	namespace := web.Params(c.Req)[":namespace"]
+	orgID := c.QueryInt64("orgId")
+	if orgID == 0 {
+		orgID = kvstore.AllOrganizations
+	}
+
+	showValues := c.QueryBool("showValues")
+	if showValues && !hs.AccessControl.IsDisabled() {
+		allowed, err := hs.AccessControl.Evaluate(c.Req.Context(), c.SignedInUser, ac.EvalPermission(ActionKVStoreValuesRead))
+		if err != nil {
+			return response.Error(http.StatusInternalServerError, "Failed to check the permissions", err)
+		}
+		if !allowed {
+			return response.Error(http.StatusForbidden, "Permission denied to read the kvstore values", nil)
+		}
+	}
+
+	items, err := kvstore.DumpNamespace(c.Req.Context(), hs.kvStore, orgID, namespace, c.Query("prefix"), showValues)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to dump the kvstore namespace", err)
+	}
+
+	return response.JSON(http.StatusOK, items)
+}
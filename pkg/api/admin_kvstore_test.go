@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestAdminKVStore_AccessControl(t *testing.T) {
+	tests := []struct {
+		accessControlTestCase
+		expectedBody string
+	}{
+		{
+			accessControlTestCase: accessControlTestCase{
+				expectedCode: http.StatusOK,
+				desc:         "AdminListKVStoreNamespaces should return 200 for user with correct permissions",
+				url:          "/api/admin/kvstore/namespaces",
+				method:       http.MethodGet,
+				permissions:  []accesscontrol.Permission{{Action: ActionKVStoreRead}},
+			},
+			expectedBody: `[{"namespace":"plugin.settings","backend":"sql","sensitive":false,"keys":1,"bytes":9}]`,
+		},
+		{
+			accessControlTestCase: accessControlTestCase{
+				expectedCode: http.StatusForbidden,
+				desc:         "AdminListKVStoreNamespaces should return 403 for user without required permissions",
+				url:          "/api/admin/kvstore/namespaces",
+				method:       http.MethodGet,
+				permissions:  []accesscontrol.Permission{{Action: "wrong"}},
+			},
+		},
+		{
+			accessControlTestCase: accessControlTestCase{
+				expectedCode: http.StatusOK,
+				desc:         "AdminDumpKVStoreNamespace should redact the values",
+				url:          "/api/admin/kvstore/namespaces/plugin.settings/items",
+				method:       http.MethodGet,
+				permissions:  []accesscontrol.Permission{{Action: ActionKVStoreRead}},
+			},
+			expectedBody: `[{"orgId":1,"key":"fatal","value":"[REDACTED]"}]`,
+		},
+		{
+			accessControlTestCase: accessControlTestCase{
+				expectedCode: http.StatusForbidden,
+				desc:         "AdminDumpKVStoreNamespace should return 403 for values without required permissions",
+				url:          "/api/admin/kvstore/namespaces/plugin.settings/items?showValues=true",
+				method:       http.MethodGet,
+				permissions:  []accesscontrol.Permission{{Action: ActionKVStoreRead}},
+			},
+		},
+		{
+			accessControlTestCase: accessControlTestCase{
+				expectedCode: http.StatusOK,
+				desc:         "AdminDumpKVStoreNamespace should return the values for user with correct permissions",
+				url:          "/api/admin/kvstore/namespaces/plugin.settings/items?showValues=true&orgId=1",
+				method:       http.MethodGet,
+				permissions:  []accesscontrol.Permission{{Action: ActionKVStoreRead}, {Action: ActionKVStoreValuesRead}},
+			},
+			expectedBody: `[{"orgId":1,"key":"fatal","value":"true"}]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := setting.NewCfg()
+			sc, hs := setupAccessControlScenarioContext(t, cfg, test.url, test.permissions)
+			sc.resp = httptest.NewRecorder()
+			hs.kvStore = kvstore.ProvideService(sqlstore.InitTestDB(t))
+			require.NoError(t, hs.kvStore.Set(context.Background(), 1, "plugin.settings", "fatal", "true"))
+
+			var err error
+			sc.req, err = http.NewRequest(test.method, test.url, nil)
+			require.NoError(t, err)
+
+			sc.exec()
+			assert.Equal(t, test.expectedCode, sc.resp.Code)
+			if test.expectedBody != "" {
+				assert.JSONEq(t, test.expectedBody, sc.resp.Body.String())
+			}
+		})
+	}
+}
@@ -178,6 +178,9 @@ func (hs *HTTPServer) registerRoutes() {
 	r.Post("/api/user/password/send-reset-email", routing.Wrap(hs.SendResetPasswordEmail))
 	r.Post("/api/user/password/reset", routing.Wrap(hs.ResetPassword))
 
+	// leaked api keys found by GitHub secret scanning, the reports are signed
+	r.Post("/api/secret-scanning/github", routing.Wrap(hs.GitHubSecretScanning))
+
 	// dashboard snapshots
 	r.Get("/dashboard/snapshot/*", reqNoAuth, hs.Index)
 	r.Get("/dashboard/snapshots/", reqSignedIn, hs.Index)
@@ -331,7 +334,19 @@ func (hs *HTTPServer) registerRoutes() {
 			apikeyIDScope := ac.Scope("apikeys", "id", ac.Parameter(":id"))
 			keysRoute.Get("/", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyRead)), routing.Wrap(hs.GetAPIKeys))
 			keysRoute.Post("/", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyCreate)), quota("api_key"), routing.Wrap(hs.AddAPIKey))
+			keysRoute.Delete("/", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyDelete, ac.ScopeAPIKeysAll)), routing.Wrap(hs.DeleteAPIKeys))
+			keysRoute.Post("/introspect", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyRead)), routing.Wrap(hs.IntrospectAPIKey))
+			keysRoute.Get("/policy", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyRead)), routing.Wrap(hs.GetAPIKeyNamingPolicy))
+			keysRoute.Get("/summary", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyRead, ac.ScopeAPIKeysAll)), routing.Wrap(hs.GetAPIKeySummary))
+			keysRoute.Put("/policy", authorize(reqOrgAdmin, ac.EvalAll(ac.EvalPermission(ac.ActionAPIKeyCreate), ac.EvalPermission(ac.ActionAPIKeyDelete, ac.ScopeAPIKeysAll))), routing.Wrap(hs.SetAPIKeyNamingPolicy))
 			keysRoute.Delete("/:id", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyDelete, apikeyIDScope)), routing.Wrap(hs.DeleteAPIKey))
+			keysRoute.Patch("/:id", authorize(reqOrgAdmin, ac.EvalAll(ac.EvalPermission(ac.ActionAPIKeyCreate), ac.EvalPermission(ac.ActionAPIKeyDelete, apikeyIDScope))), routing.Wrap(hs.UpdateAPIKey))
+			keysRoute.Post("/:id/rotate", authorize(reqOrgAdmin, ac.EvalAll(ac.EvalPermission(ac.ActionAPIKeyCreate), ac.EvalPermission(ac.ActionAPIKeyDelete, apikeyIDScope))), routing.Wrap(hs.RotateAPIKey))
+			keysRoute.Post("/:id/secondary/promote", authorize(reqOrgAdmin, ac.EvalAll(ac.EvalPermission(ac.ActionAPIKeyCreate), ac.EvalPermission(ac.ActionAPIKeyDelete, apikeyIDScope))), routing.Wrap(hs.PromoteAPIKeySecondary))
+			keysRoute.Delete("/:id/secondary", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyDelete, apikeyIDScope)), routing.Wrap(hs.RevokeAPIKeySecondary))
+			keysRoute.Post("/:id/approve", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyApprove, apikeyIDScope)), routing.Wrap(hs.ApproveAPIKey))
+			keysRoute.Get("/:id/stats", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyRead, apikeyIDScope)), routing.Wrap(hs.GetAPIKeyStats))
+			keysRoute.Post("/:id/reject", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyApprove, apikeyIDScope)), routing.Wrap(hs.RejectAPIKey))
 		})
 
 		// Preferences
@@ -574,6 +589,11 @@ func (hs *HTTPServer) registerRoutes() {
 		})
 	}, reqSignedIn)
 
+	// health of the secrets backend
+	r.Get("/api/health/secrets", reqGrafanaAdmin, routing.Wrap(hs.SecretsHealth))
+	// health of the database connection pool
+	r.Get("/api/health/database", reqGrafanaAdmin, routing.Wrap(hs.DatabaseConnectionPoolHealth))
+
 	// admin api
 	r.Group("/api/admin", func(adminRoute routing.RouteRegister) {
 		adminRoute.Get("/settings", authorize(reqGrafanaAdmin, ac.EvalPermission(ac.ActionSettingsRead)), routing.Wrap(hs.AdminGetSettings))
@@ -596,10 +616,28 @@ func (hs *HTTPServer) registerRoutes() {
 			adminRoute.Get("/export/options", reqGrafanaAdmin, routing.Wrap(hs.ExportService.HandleGetOptions))
 		}
 
+		adminRoute.Post("/api-keys/leaks", reqGrafanaAdmin, routing.Wrap(hs.AdminReportLeakedAPIKeys))
 		adminRoute.Post("/encryption/rotate-data-keys", reqGrafanaAdmin, routing.Wrap(hs.AdminRotateDataEncryptionKeys))
 		adminRoute.Post("/encryption/reencrypt-data-keys", reqGrafanaAdmin, routing.Wrap(hs.AdminReEncryptEncryptionKeys))
 		adminRoute.Post("/encryption/reencrypt-secrets", reqGrafanaAdmin, routing.Wrap(hs.AdminReEncryptSecrets))
 		adminRoute.Post("/encryption/rollback-secrets", reqGrafanaAdmin, routing.Wrap(hs.AdminRollbackSecrets))
+		adminRoute.Get("/encryption/data-keys/usage", reqGrafanaAdmin, routing.Wrap(hs.AdminGetDataKeysUsage))
+		adminRoute.Get("/secrets/plugin-migration", reqGrafanaAdmin, routing.Wrap(hs.AdminGetSecretsPluginMigrationStatus))
+		adminRoute.Get("/secrets/plugin/quarantine", reqGrafanaAdmin, routing.Wrap(hs.AdminGetSecretsPluginQuarantine))
+		adminRoute.Delete("/secrets/plugin/quarantine", reqGrafanaAdmin, routing.Wrap(hs.AdminLiftSecretsPluginQuarantine))
+		adminRoute.Post("/secrets/plugin/reload", reqGrafanaAdmin, routing.Wrap(hs.AdminReloadSecretsPlugin))
+		adminRoute.Get("/secrets/routes", reqGrafanaAdmin, routing.Wrap(hs.AdminGetSecretsRoutes))
+		adminRoute.Post("/secrets/routes/move", reqGrafanaAdmin, routing.Wrap(hs.AdminMoveSecretsNamespace))
+		adminRoute.Get("/secrets/audit", reqGrafanaAdmin, routing.Wrap(hs.AdminSearchSecretsAudit))
+		adminRoute.Post("/secrets/export", reqGrafanaAdmin, routing.Wrap(hs.AdminExportSecrets))
+		adminRoute.Post("/secrets/import", reqGrafanaAdmin, routing.Wrap(hs.AdminImportSecrets))
+		adminRoute.Get("/secrets/consistency", reqGrafanaAdmin, routing.Wrap(hs.AdminCheckSecretsConsistency))
+		adminRoute.Post("/secrets/consistency/prune", reqGrafanaAdmin, routing.Wrap(hs.AdminPruneSecrets))
+		adminRoute.Get("/secrets/mirror", reqGrafanaAdmin, routing.Wrap(hs.AdminGetSecretsMirrorDivergence))
+		adminRoute.Get("/access-control/decisions", reqGrafanaAdmin, routing.Wrap(hs.AdminSearchAccessControlDecisions))
+
+		adminRoute.Get("/kvstore/namespaces", authorize(reqGrafanaAdmin, ac.EvalPermission(ActionKVStoreRead)), routing.Wrap(hs.AdminListKVStoreNamespaces))
+		adminRoute.Get("/kvstore/namespaces/:namespace/items", authorize(reqGrafanaAdmin, ac.EvalPermission(ActionKVStoreRead)), routing.Wrap(hs.AdminDumpKVStoreNamespace))
 
 		adminRoute.Post("/provisioning/dashboards/reload", authorize(reqGrafanaAdmin, ac.EvalPermission(ActionProvisioningReload, ScopeProvisionersDashboards)), routing.Wrap(hs.AdminProvisioningReloadDashboards))
 		adminRoute.Post("/provisioning/plugins/reload", authorize(reqGrafanaAdmin, ac.EvalPermission(ActionProvisioningReload, ScopeProvisionersPlugins)), routing.Wrap(hs.AdminProvisioningReloadPlugins))
@@ -4,13 +4,17 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana/pkg/api/dtos"
 	"github.com/grafana/grafana/pkg/api/response"
 	"github.com/grafana/grafana/pkg/components/apikeygen"
 	"github.com/grafana/grafana/pkg/models"
+	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/util"
 	"github.com/grafana/grafana/pkg/web"
 )
 
@@ -27,7 +31,18 @@ import (
 // 404: notFoundError
 // 500: internalServerError
 func (hs *HTTPServer) GetAPIKeys(c *models.ReqContext) response.Response {
-	query := apikey.GetApiKeysQuery{OrgId: c.OrgID, User: c.SignedInUser, IncludeExpired: c.QueryBool("includeExpired")}
+	query := apikey.GetApiKeysQuery{OrgId: c.OrgID, User: c.SignedInUser, IncludeExpired: c.QueryBool("includeExpired"), Query: c.Query("query")}
+
+	for _, selector := range c.QueryStrings("label") {
+		parts := strings.SplitN(selector, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return response.Error(http.StatusBadRequest, "label selectors must be in the name=value format", nil)
+		}
+		if query.Labels == nil {
+			query.Labels = map[string]string{}
+		}
+		query.Labels[parts[0]] = parts[1]
+	}
 
 	if err := hs.apiKeyService.GetAPIKeys(c.Req.Context(), &query); err != nil {
 		return response.Error(500, "Failed to list api keys", err)
@@ -42,11 +57,24 @@ func (hs *HTTPServer) GetAPIKeys(c *models.ReqContext) response.Response {
 			v := time.Unix(*t.Expires, 0)
 			expiration = &v
 		}
+		var allowedIPRanges []string
+		if t.AllowedIPRanges != "" {
+			allowedIPRanges = strings.Split(t.AllowedIPRanges, ",")
+		}
 		result[i] = &dtos.ApiKeyDTO{
-			Id:         t.Id,
-			Name:       t.Name,
-			Role:       t.Role,
-			Expiration: expiration,
+			Id:              t.Id,
+			Name:            t.Name,
+			Role:            t.Role,
+			Expiration:      expiration,
+			AllowedIPRanges: allowedIPRanges,
+			DatasourceUIDs:  t.AllowedDatasources(),
+			OwnerTeamId:     t.OwnerTeamId,
+			AccessWindows:   t.AccessWindows,
+			Labels:          t.Labels,
+			RateLimitRPS:    t.RateLimitRPS,
+			Status:          keyStatus(t),
+			Type:            keyType(t),
+			HasSecondary:    t.SecondaryKey != nil,
 		}
 	}
 
@@ -76,7 +104,7 @@ func (hs *HTTPServer) DeleteAPIKey(c *models.ReqContext) response.Response {
 		return response.Error(http.StatusBadRequest, "id is invalid", err)
 	}
 
-	cmd := &apikey.DeleteCommand{Id: id, OrgId: c.OrgID}
+	cmd := &apikey.DeleteCommand{Id: id, OrgId: c.OrgID, ActorId: c.UserID}
 	err = hs.apiKeyService.DeleteApiKey(c.Req.Context(), cmd)
 	if err != nil {
 		var status int
@@ -91,6 +119,47 @@ func (hs *HTTPServer) DeleteAPIKey(c *models.ReqContext) response.Response {
 	return response.Success("API key deleted")
 }
 
+// swagger:route DELETE /auth/keys api_keys deleteAPIkeys
+//
+// Delete API keys matching a filter.
+//
+// Deletes all the API keys of the organization matching every given filter. At least one filter is required.
+//
+// Responses:
+// 200: deleteAPIkeysResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) DeleteAPIKeys(c *models.ReqContext) response.Response {
+	cmd := &apikey.DeleteByFilterCommand{
+		OrgId:      c.OrgID,
+		NamePrefix: c.Query("namePrefix"),
+		CreatedBy:  c.QueryInt64("createdBy"),
+		ActorId:    c.UserID,
+	}
+
+	if lastUsedBefore := c.Query("lastUsedBefore"); lastUsedBefore != "" {
+		t, err := time.Parse(time.RFC3339, lastUsedBefore)
+		if err != nil {
+			return response.Error(http.StatusBadRequest, "lastUsedBefore must be a RFC3339 date", err)
+		}
+		cmd.LastUsedBefore = &t
+	}
+
+	if err := hs.apiKeyService.DeleteByFilter(c.Req.Context(), cmd); err != nil {
+		if errors.Is(err, apikey.ErrEmptyFilter) {
+			return response.Error(http.StatusBadRequest, err.Error(), nil)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to delete API keys", err)
+	}
+
+	return response.JSON(http.StatusOK, util.DynMap{
+		"message": "API keys deleted",
+		"count":   cmd.Result,
+	})
+}
+
 // swagger:route POST /auth/keys api_keys addAPIkey
 //
 // Creates an API key.
@@ -126,32 +195,530 @@ func (hs *HTTPServer) AddAPIKey(c *models.ReqContext) response.Response {
 	}
 
 	cmd.OrgId = c.OrgID
+	cmd.CreatedBy = c.UserID
+
+	// replacing an existing key requires the same permissions as rotating it
+	var approveScopes []string
+	if cmd.Upsert {
+		query := apikey.GetByNameQuery{OrgId: cmd.OrgId, KeyName: cmd.Name}
+		err := hs.apiKeyService.GetApiKeyByName(c.Req.Context(), &query)
+		if err != nil && !errors.Is(err, apikey.ErrInvalid) {
+			return response.Error(http.StatusInternalServerError, "Failed to get API key", err)
+		}
+		if err == nil {
+			if !c.OrgRole.Includes(query.Result.Role) {
+				return response.Error(http.StatusForbidden, "Cannot replace a key with a role higher than user's role", nil)
+			}
+			if !hs.AccessControl.IsDisabled() {
+				scope := ac.Scope("apikeys", "id", strconv.FormatInt(query.Result.Id, 10))
+				approveScopes = []string{scope}
+				canDelete, err := hs.AccessControl.Evaluate(c.Req.Context(), c.SignedInUser, ac.EvalPermission(ac.ActionAPIKeyDelete, scope))
+				if err != nil {
+					return response.Error(http.StatusInternalServerError, "Failed to evaluate permissions", err)
+				}
+				if !canDelete {
+					return response.Error(http.StatusForbidden, "Not allowed to replace the existing API key", nil)
+				}
+			}
+		}
+	}
+
+	// the admins of a team can only create the keys of their team
+	if !hs.AccessControl.IsDisabled() {
+		scope := ac.ScopeAPIKeysAll
+		if cmd.OwnerTeamId != 0 {
+			scope = apikey.ScopeTeam(cmd.OwnerTeamId)
+		}
+		canCreate, err := hs.AccessControl.Evaluate(c.Req.Context(), c.SignedInUser, ac.EvalPermission(ac.ActionAPIKeyCreate, scope))
+		if err != nil {
+			return response.Error(http.StatusInternalServerError, "Failed to evaluate permissions", err)
+		}
+		if !canCreate {
+			return response.Error(http.StatusForbidden, "Not allowed to create API keys for this owner", nil)
+		}
+	}
+
+	canApprove, err := hs.canApproveAPIKey(c, approveScopes...)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to evaluate permissions", err)
+	}
+	cmd.Pending = !canApprove
 
 	newKeyInfo, err := apikeygen.New(cmd.OrgId, cmd.Name)
 	if err != nil {
 		return response.Error(500, "Generating API key failed", err)
 	}
 
-	cmd.Key = newKeyInfo.HashedKey
+	cmd.Secret = newKeyInfo.Secret
+	cmd.Fingerprint = apikey.LeakHash(newKeyInfo.ClientSecret)
+	if cmd.Type == apikey.TypeSigning {
+		cmd.SigningSecret = newKeyInfo.ClientSecret
+	}
 	if err := hs.apiKeyService.AddAPIKey(c.Req.Context(), &cmd); err != nil {
-		if errors.Is(err, apikey.ErrInvalidExpiration) {
+		if errors.Is(err, apikey.ErrInvalidExpiration) || errors.Is(err, apikey.ErrInvalidIPRange) ||
+			errors.Is(err, apikey.ErrInvalidLabel) || errors.Is(err, apikey.ErrInvalidRateLimit) ||
+			errors.Is(err, apikey.ErrInvalidType) || errors.Is(err, apikey.ErrNamingPolicy) ||
+			errors.Is(err, apikey.ErrInvalidDatasource) || errors.Is(err, apikey.ErrTeamNotFound) ||
+			errors.Is(err, apikey.ErrInvalidAccessWindow) {
 			return response.Error(400, err.Error(), nil)
 		}
 		if errors.Is(err, apikey.ErrDuplicate) {
 			return response.Error(409, err.Error(), nil)
 		}
+		if errors.Is(err, apikey.ErrQuotaReached) {
+			return response.Error(http.StatusForbidden, err.Error(), nil)
+		}
 		return response.Error(500, "Failed to add API Key", err)
 	}
 
 	result := &dtos.NewApiKeyResult{
-		ID:   cmd.Result.Id,
-		Name: cmd.Result.Name,
-		Key:  newKeyInfo.ClientSecret,
+		ID:     cmd.Result.Id,
+		Name:   cmd.Result.Name,
+		Key:    newKeyInfo.ClientSecret,
+		Status: keyStatus(cmd.Result),
+		Type:   keyType(cmd.Result),
 	}
 
 	return response.JSON(http.StatusOK, result)
 }
 
+// canApproveAPIKey returns whether the keys created, replaced or rotated by
+// the user are active right away. The others wait for the approval of an
+// organization admin, since their secrets could be used otherwise. scopes are
+// those of the key when it already exists.
+func (hs *HTTPServer) canApproveAPIKey(c *models.ReqContext, scopes ...string) (bool, error) {
+	if hs.AccessControl.IsDisabled() {
+		return true, nil
+	}
+	return hs.AccessControl.Evaluate(c.Req.Context(), c.SignedInUser, ac.EvalPermission(ac.ActionAPIKeyApprove, scopes...))
+}
+
+// swagger:route POST /auth/keys/{id}/approve api_keys approveAPIkey
+//
+// Approve API key.
+//
+// Activates an API key waiting for approval.
+//
+// Responses:
+// 200: okResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) ApproveAPIKey(c *models.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "id is invalid", err)
+	}
+
+	cmd := &apikey.ApproveCommand{Id: id, OrgId: c.OrgID}
+	if err := hs.apiKeyService.ApproveAPIKey(c.Req.Context(), cmd); err != nil {
+		if errors.Is(err, apikey.ErrNotFound) {
+			return response.Error(http.StatusNotFound, "Pending API key not found", err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to approve API key", err)
+	}
+
+	return response.Success("API key approved")
+}
+
+// swagger:route POST /auth/keys/{id}/reject api_keys rejectAPIkey
+//
+// Reject API key.
+//
+// Deletes an API key waiting for approval.
+//
+// Responses:
+// 200: okResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) RejectAPIKey(c *models.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "id is invalid", err)
+	}
+
+	cmd := &apikey.RejectCommand{Id: id, OrgId: c.OrgID, ActorId: c.UserID}
+	if err := hs.apiKeyService.RejectAPIKey(c.Req.Context(), cmd); err != nil {
+		if errors.Is(err, apikey.ErrNotFound) {
+			return response.Error(http.StatusNotFound, "Pending API key not found", err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to reject API key", err)
+	}
+
+	return response.Success("API key rejected")
+}
+
+// swagger:route GET /auth/keys/{id}/stats api_keys getAPIkeyStats
+//
+// Get API key usage statistics.
+//
+// Returns the number of requests authenticated with the API key over the last 24 hours, 7 days and 30 days, and the routes it called the most.
+//
+// Responses:
+// 200: getAPIkeyStatsResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetAPIKeyStats(c *models.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "id is invalid", err)
+	}
+
+	query := &apikey.GetUsageStatsQuery{ApiKeyId: id, OrgId: c.OrgID}
+	if err := hs.apiKeyService.GetUsageStats(c.Req.Context(), query); err != nil {
+		if errors.Is(err, apikey.ErrNotFound) {
+			return response.Error(http.StatusNotFound, "API key not found", err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to get API key usage statistics", err)
+	}
+
+	return response.JSON(http.StatusOK, query.Result)
+}
+
+// swagger:route GET /auth/keys/summary api_keys getAPIkeySummary
+//
+// Get the API key summary.
+//
+// Returns the number of API keys of the organization by status: active, pending, expired, expiring soon and never used.
+//
+// Responses:
+// 200: getAPIkeySummaryResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) GetAPIKeySummary(c *models.ReqContext) response.Response {
+	query := &apikey.GetSummaryQuery{OrgId: c.OrgID}
+	if err := hs.apiKeyService.GetSummary(c.Req.Context(), query); err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to get API key summary", err)
+	}
+
+	return response.JSON(http.StatusOK, query.Result)
+}
+
+// swagger:route GET /auth/keys/policy api_keys getAPIkeyNamingPolicy
+//
+// Get the API key naming policy.
+//
+// Returns the naming policy the names of new API keys of the organization must follow.
+//
+// Responses:
+// 200: getAPIkeyNamingPolicyResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) GetAPIKeyNamingPolicy(c *models.ReqContext) response.Response {
+	query := &apikey.GetNamingPolicyQuery{OrgId: c.OrgID}
+	if err := hs.apiKeyService.GetNamingPolicy(c.Req.Context(), query); err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to get API key naming policy", err)
+	}
+	return response.JSON(http.StatusOK, query.Result)
+}
+
+// swagger:route PUT /auth/keys/policy api_keys setAPIkeyNamingPolicy
+//
+// Set the API key naming policy.
+//
+// Replaces the naming policy the names of new API keys of the organization must follow. An empty policy removes it. Existing API keys are not affected.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) SetAPIKeyNamingPolicy(c *models.ReqContext) response.Response {
+	cmd := apikey.SetNamingPolicyCommand{OrgId: c.OrgID}
+	if err := web.Bind(c.Req, &cmd.Policy); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	if err := hs.apiKeyService.SetNamingPolicy(c.Req.Context(), &cmd); err != nil {
+		if errors.Is(err, apikey.ErrInvalidPolicy) {
+			return response.Error(http.StatusBadRequest, err.Error(), nil)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to set API key naming policy", err)
+	}
+	return response.Success("API key naming policy updated")
+}
+
+// swagger:route POST /auth/keys/introspect api_keys introspectAPIkey
+//
+// Introspect API key.
+//
+// Describes the API key or service account token sent in the body, without its secret, so that gateways can validate keys. Keys that are invalid, inactive or belong to another organization are only reported as inactive.
+//
+// Responses:
+// 200: introspectAPIkeyResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) IntrospectAPIKey(c *models.ReqContext) response.Response {
+	query := apikey.IntrospectQuery{}
+	if err := web.Bind(c.Req, &query); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	if query.Key == "" {
+		return response.Error(http.StatusBadRequest, "key is required", nil)
+	}
+	query.OrgId = c.OrgID
+
+	if err := hs.apiKeyService.IntrospectAPIKey(c.Req.Context(), &query); err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to introspect API key", err)
+	}
+	if !query.Active {
+		return response.JSON(http.StatusOK, &dtos.ApiKeyIntrospection{Active: false})
+	}
+
+	key := query.Result
+	signedInUser := &user.SignedInUser{OrgID: key.OrgId, OrgRole: key.Role, ApiKeyID: key.Id}
+	if key.ServiceAccountId != nil && *key.ServiceAccountId > 0 {
+		userQuery := user.GetSignedInUserQuery{UserID: *key.ServiceAccountId, OrgID: key.OrgId}
+		serviceAccount, err := hs.userService.GetSignedInUserWithCacheCtx(c.Req.Context(), &userQuery)
+		if err != nil {
+			return response.Error(http.StatusInternalServerError, "Failed to get the service account of the token", err)
+		}
+		// tokens of disabled service accounts cannot authenticate requests
+		if serviceAccount.IsDisabled {
+			return response.JSON(http.StatusOK, &dtos.ApiKeyIntrospection{Active: false})
+		}
+		signedInUser = serviceAccount
+	}
+
+	permissions, err := hs.AccessControl.GetUserPermissions(c.Req.Context(), signedInUser, ac.Options{ReloadCache: false})
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to get the permissions of the API key", err)
+	}
+
+	result := &dtos.ApiKeyIntrospection{
+		Active:           true,
+		Id:               key.Id,
+		Name:             key.Name,
+		OrgId:            key.OrgId,
+		Role:             signedInUser.OrgRole,
+		ServiceAccountId: key.ServiceAccountId,
+		Type:             keyType(key),
+		LastUsedAt:       key.LastUsedAt,
+		Labels:           key.Labels,
+		DatasourceUIDs:   key.AllowedDatasources(),
+		Permissions:      ac.GroupScopesByAction(permissions),
+	}
+	if key.Expires != nil {
+		expiration := time.Unix(*key.Expires, 0)
+		result.Expiration = &expiration
+	}
+	return response.JSON(http.StatusOK, result)
+}
+
+// keyStatus returns the status of the key shown by the API, keys created
+// before the approval workflow have none.
+func keyStatus(key *apikey.APIKey) string {
+	if key.IsPending() {
+		return apikey.StatusPending
+	}
+	return apikey.StatusActive
+}
+
+// keyType returns the type of the key shown by the API, keys created before
+// signing keys have none.
+func keyType(key *apikey.APIKey) string {
+	if key.IsSigning() {
+		return apikey.TypeSigning
+	}
+	return apikey.TypeBearer
+}
+
+// swagger:route PATCH /auth/keys/{id} api_keys updateAPIkey
+//
+// Update API key.
+//
+// Updates the networks the API key can be used from and its rate limit.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) UpdateAPIKey(c *models.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "id is invalid", err)
+	}
+
+	cmd := apikey.UpdateCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.Id = id
+	cmd.OrgId = c.OrgID
+
+	if err := hs.apiKeyService.UpdateAPIKey(c.Req.Context(), &cmd); err != nil {
+		if errors.Is(err, apikey.ErrInvalidIPRange) || errors.Is(err, apikey.ErrInvalidRateLimit) ||
+			errors.Is(err, apikey.ErrInvalidDatasource) || errors.Is(err, apikey.ErrInvalidAccessWindow) {
+			return response.Error(http.StatusBadRequest, err.Error(), nil)
+		}
+		if errors.Is(err, apikey.ErrNotFound) {
+			return response.Error(http.StatusNotFound, "API key not found", err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to update API key", err)
+	}
+
+	return response.Success("API key updated")
+}
+
+// swagger:route POST /auth/keys/{id}/rotate api_keys rotateAPIkey
+//
+// Rotates an API key.
+//
+// Generates a new secret for the API key. The previous secret stays valid for the requested grace period. When secondary is set, the new secret is stored next to the current one until it is promoted or revoked. Keys rotated by users who cannot approve them wait for approval again.
+//
+// Responses:
+// 200: postAPIkeyResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) RotateAPIKey(c *models.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "id is invalid", err)
+	}
+
+	cmd := apikey.RotateCommand{}
+	if c.Req.ContentLength > 0 {
+		if err := web.Bind(c.Req, &cmd); err != nil {
+			return response.Error(http.StatusBadRequest, "bad request data", err)
+		}
+	}
+	cmd.Id = id
+	cmd.OrgId = c.OrgID
+	cmd.ActorId = c.UserID
+
+	query := apikey.GetByIDQuery{ApiKeyId: id}
+	if err := hs.apiKeyService.GetApiKeyById(c.Req.Context(), &query); err != nil {
+		if errors.Is(err, apikey.ErrInvalid) {
+			return response.Error(http.StatusNotFound, "API key not found", err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to get API key", err)
+	}
+	if query.Result.OrgId != c.OrgID || query.Result.ServiceAccountId != nil {
+		return response.Error(http.StatusNotFound, "API key not found", nil)
+	}
+
+	canApprove, err := hs.canApproveAPIKey(c, ac.Scope("apikeys", "id", strconv.FormatInt(id, 10)))
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to evaluate permissions", err)
+	}
+	cmd.Pending = !canApprove
+
+	newKeyInfo, err := apikeygen.New(cmd.OrgId, query.Result.Name)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Generating API key failed", err)
+	}
+
+	cmd.Secret = newKeyInfo.Secret
+	cmd.Fingerprint = apikey.LeakHash(newKeyInfo.ClientSecret)
+	if query.Result.IsSigning() {
+		cmd.SigningSecret = newKeyInfo.ClientSecret
+	}
+	if err := hs.apiKeyService.RotateAPIKey(c.Req.Context(), &cmd); err != nil {
+		if errors.Is(err, apikey.ErrInvalidGracePeriod) || errors.Is(err, apikey.ErrSecondarySigning) {
+			return response.Error(http.StatusBadRequest, err.Error(), nil)
+		}
+		if errors.Is(err, apikey.ErrNotFound) {
+			return response.Error(http.StatusNotFound, "API key not found", err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to rotate API key", err)
+	}
+
+	result := &dtos.NewApiKeyResult{
+		ID:     cmd.Result.Id,
+		Name:   cmd.Result.Name,
+		Key:    newKeyInfo.ClientSecret,
+		Status: keyStatus(cmd.Result),
+	}
+
+	return response.JSON(http.StatusOK, result)
+}
+
+// swagger:route POST /auth/keys/{id}/secondary/promote api_keys promoteAPIkeySecondary
+//
+// Promote the secondary secret of an API key.
+//
+// Makes the secondary secret the current secret of the API key. The replaced secret stays valid for the requested grace period.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) PromoteAPIKeySecondary(c *models.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "id is invalid", err)
+	}
+
+	cmd := apikey.PromoteSecondaryCommand{}
+	if c.Req.ContentLength > 0 {
+		if err := web.Bind(c.Req, &cmd); err != nil {
+			return response.Error(http.StatusBadRequest, "bad request data", err)
+		}
+	}
+	cmd.Id = id
+	cmd.OrgId = c.OrgID
+	cmd.ActorId = c.UserID
+
+	if err := hs.apiKeyService.PromoteSecondaryAPIKey(c.Req.Context(), &cmd); err != nil {
+		if errors.Is(err, apikey.ErrInvalidGracePeriod) {
+			return response.Error(http.StatusBadRequest, err.Error(), nil)
+		}
+		if errors.Is(err, apikey.ErrNotFound) || errors.Is(err, apikey.ErrNoSecondary) {
+			return response.Error(http.StatusNotFound, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to promote the secondary secret of the API key", err)
+	}
+
+	return response.Success("Secondary secret promoted")
+}
+
+// swagger:route DELETE /auth/keys/{id}/secondary api_keys revokeAPIkeySecondary
+//
+// Revoke the secondary secret of an API key.
+//
+// The secondary secret is invalidated immediately, the current secret is left unchanged.
+//
+// Responses:
+// 200: okResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) RevokeAPIKeySecondary(c *models.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "id is invalid", err)
+	}
+
+	cmd := &apikey.RevokeSecondaryCommand{Id: id, OrgId: c.OrgID}
+	if err := hs.apiKeyService.RevokeSecondaryAPIKey(c.Req.Context(), cmd); err != nil {
+		if errors.Is(err, apikey.ErrNotFound) || errors.Is(err, apikey.ErrNoSecondary) {
+			return response.Error(http.StatusNotFound, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to revoke the secondary secret of the API key", err)
+	}
+
+	return response.Success("Secondary secret revoked")
+}
+
 // swagger:parameters getAPIkeys
 type GetAPIkeysParams struct {
 	// Show expired keys
@@ -159,6 +726,14 @@ type GetAPIkeysParams struct {
 	// required:false
 	// default:false
 	IncludeExpired bool `json:"includeExpired"`
+	// Only show keys having the label, in the name=value format. Can be repeated.
+	// in:query
+	// required:false
+	Label []string `json:"label"`
+	// Only show keys whose name, creator or labels contain the text
+	// in:query
+	// required:false
+	Query string `json:"query"`
 }
 
 // swagger:parameters addAPIkey
@@ -175,6 +750,100 @@ type DeleteAPIkeyParams struct {
 	ID int64 `json:"id"`
 }
 
+// swagger:parameters deleteAPIkeys
+type DeleteAPIkeysParams struct {
+	// Delete keys whose name starts with the prefix
+	// in:query
+	// required:false
+	NamePrefix string `json:"namePrefix"`
+	// Delete keys created by the user
+	// in:query
+	// required:false
+	CreatedBy int64 `json:"createdBy"`
+	// Delete keys not used since the date. Keys that have never been used match if they were created before it.
+	// in:query
+	// required:false
+	LastUsedBefore string `json:"lastUsedBefore"`
+}
+
+// swagger:parameters updateAPIkey
+type UpdateAPIkeyParams struct {
+	// in:path
+	// required:true
+	ID int64 `json:"id"`
+	// in:body
+	// required:true
+	Body apikey.UpdateCommand
+}
+
+// swagger:parameters approveAPIkey
+type ApproveAPIkeyParams struct {
+	// in:path
+	// required:true
+	ID int64 `json:"id"`
+}
+
+// swagger:parameters getAPIkeyStats
+type GetAPIkeyStatsParams struct {
+	// in:path
+	// required:true
+	ID int64 `json:"id"`
+}
+
+// swagger:parameters setAPIkeyNamingPolicy
+type SetAPIkeyNamingPolicyParams struct {
+	// in:body
+	// required:true
+	Body apikey.NamingPolicy
+}
+
+// swagger:parameters rejectAPIkey
+type RejectAPIkeyParams struct {
+	// in:path
+	// required:true
+	ID int64 `json:"id"`
+}
+
+// swagger:parameters rotateAPIkey
+type RotateAPIkeyParams struct {
+	// in:path
+	// required:true
+	ID int64 `json:"id"`
+	// in:body
+	// required:false
+	Body apikey.RotateCommand
+}
+
+// swagger:parameters promoteAPIkeySecondary
+type PromoteAPIkeySecondaryParams struct {
+	// in:path
+	// required:true
+	ID int64 `json:"id"`
+	// in:body
+	// required:false
+	Body apikey.PromoteSecondaryCommand
+}
+
+// swagger:parameters revokeAPIkeySecondary
+type RevokeAPIkeySecondaryParams struct {
+	// in:path
+	// required:true
+	ID int64 `json:"id"`
+}
+
+// swagger:parameters introspectAPIkey
+type IntrospectAPIkeyParams struct {
+	// in:body
+	// required:true
+	Body apikey.IntrospectQuery
+}
+
+// swagger:response introspectAPIkeyResponse
+type IntrospectAPIkeyResponse struct {
+	// in: body
+	Body dtos.ApiKeyIntrospection `json:"body"`
+}
+
 // swagger:response getAPIkeyResponse
 type GetAPIkeyResponse struct {
 	// The response message
@@ -182,6 +851,40 @@ type GetAPIkeyResponse struct {
 	Body []*dtos.ApiKeyDTO `json:"body"`
 }
 
+// swagger:response getAPIkeyStatsResponse
+type GetAPIkeyStatsResponse struct {
+	// in: body
+	Body apikey.UsageStats `json:"body"`
+}
+
+// swagger:response getAPIkeySummaryResponse
+type GetAPIkeySummaryResponse struct {
+	// in: body
+	Body apikey.Summary `json:"body"`
+}
+
+// swagger:response getAPIkeyNamingPolicyResponse
+type GetAPIkeyNamingPolicyResponse struct {
+	// in: body
+	Body apikey.NamingPolicy `json:"body"`
+}
+
+// swagger:response deleteAPIkeysResponse
+type DeleteAPIkeysResponse struct {
+	// in: body
+	Body struct {
+		// The number of deleted API keys
+		// required: true
+		// example: 3
+		Count int64 `json:"count"`
+
+		// Success message
+		// required: true
+		// example: API keys deleted
+		Message string `json:"message"`
+	} `json:"body"`
+}
+
 // swagger:response postAPIkeyResponse
 type PostAPIkeyResponse struct {
 	// The response message
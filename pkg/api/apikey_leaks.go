@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/grafana/grafana/pkg/api/response"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/web"
+)
+
+// maxSecretScanningPayload is the size of the largest secret scanning report
+// read.
+const maxSecretScanningPayload = 1 << 20
+
+// swagger:route POST /admin/api-keys/leaks admin reportLeakedAPIkeys
+//
+// Report leaked API keys.
+//
+// Revokes the leaked API keys and service account tokens, whether they are reported by secret or by hash, and records their hashes so that the keys created before the hashes of their secrets were recorded are revoked when they are used next. The creators of the revoked keys are notified by email.
+//
+// Responses:
+// 200: reportLeakedAPIkeysResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) AdminReportLeakedAPIKeys(c *models.ReqContext) response.Response {
+	cmd := apikey.ReportLeaksCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	if len(cmd.Keys) == 0 && len(cmd.Hashes) == 0 {
+		return response.Error(http.StatusBadRequest, "keys or hashes are required", nil)
+	}
+	if cmd.Source == "" {
+		cmd.Source = "admin"
+	}
+
+	if err := hs.apiKeyService.ReportLeakedKeys(c.Req.Context(), &cmd); err != nil {
+		if errors.Is(err, apikey.ErrInvalidLeakHash) {
+			return response.Error(http.StatusBadRequest, err.Error(), nil)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to report leaked API keys", err)
+	}
+
+	return response.JSON(http.StatusOK, cmd.Result)
+}
+
+// secretScanningMatch is a token found by GitHub secret scanning.
+type secretScanningMatch struct {
+	Token  string `json:"token"`
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Source string `json:"source"`
+}
+
+// secretScanningFeedback tells GitHub whether a reported token was a valid key.
+type secretScanningFeedback struct {
+	TokenHash string `json:"token_hash"`
+	TokenType string `json:"token_type"`
+	Label     string `json:"label"`
+}
+
+// GitHubSecretScanning receives the keys found by GitHub secret scanning, in
+// the format of its partner program, and revokes them.
+func (hs *HTTPServer) GitHubSecretScanning(c *models.ReqContext) response.Response {
+	if !hs.Cfg.ApiKeySecretScanningEnabled {
+		return response.Error(http.StatusNotFound, "Not found", nil)
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(c.Req.Body, maxSecretScanningPayload))
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Failed to read request body", err)
+	}
+	keyID := c.Req.Header.Get(apikey.SecretScanningKeyIDHeader)
+	signature := c.Req.Header.Get(apikey.SecretScanningSignatureHeader)
+	if err := hs.apiKeyService.VerifySecretScanningSignature(c.Req.Context(), keyID, signature, payload); err != nil {
+		if errors.Is(err, apikey.ErrInvalidSignature) {
+			return response.Error(http.StatusUnauthorized, err.Error(), nil)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to verify the signature", err)
+	}
+
+	var matches []secretScanningMatch
+	if err := json.Unmarshal(payload, &matches); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	feedback := make([]secretScanningFeedback, 0, len(matches))
+	for _, match := range matches {
+		cmd := apikey.ReportLeaksCommand{Keys: []string{match.Token}, Source: "github", Url: match.URL}
+		if err := hs.apiKeyService.ReportLeakedKeys(c.Req.Context(), &cmd); err != nil {
+			return response.Error(http.StatusInternalServerError, "Failed to report leaked API keys", err)
+		}
+		label := "false_positive"
+		if cmd.Result[0].Revoked {
+			label = "true_positive"
+		}
+		feedback = append(feedback, secretScanningFeedback{TokenHash: cmd.Result[0].Hash, TokenType: match.Type, Label: label})
+	}
+
+	return response.JSON(http.StatusOK, feedback)
+}
+
+// swagger:parameters reportLeakedAPIkeys
+type ReportLeakedAPIkeysParams struct {
+	// in:body
+	// required:true
+	Body apikey.ReportLeaksCommand
+}
+
+// swagger:response reportLeakedAPIkeysResponse
+type ReportLeakedAPIkeysResponse struct {
+	// in: body
+	Body []*apikey.ReportedLeak `json:"body"`
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/apikey/apikeytest"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestAPIKeyAPIEndpoint_StatsAccessControl(t *testing.T) {
+	tests := []accessControlTestCase{
+		{
+			expectedCode: http.StatusOK,
+			desc:         "GetAPIKeyStats should return 200 for user with access to all API keys",
+			url:          "/api/auth/keys/2/stats",
+			method:       http.MethodGet,
+			permissions:  []accesscontrol.Permission{{Action: accesscontrol.ActionAPIKeyRead, Scope: accesscontrol.ScopeAPIKeysAll}},
+		},
+		{
+			expectedCode: http.StatusOK,
+			desc:         "GetAPIKeyStats should return 200 for user with access to the API key",
+			url:          "/api/auth/keys/1/stats",
+			method:       http.MethodGet,
+			permissions:  []accesscontrol.Permission{{Action: accesscontrol.ActionAPIKeyRead, Scope: "apikeys:id:1"}},
+		},
+		{
+			expectedCode: http.StatusForbidden,
+			desc:         "GetAPIKeyStats should return 403 for user with access to another API key only",
+			url:          "/api/auth/keys/2/stats",
+			method:       http.MethodGet,
+			permissions:  []accesscontrol.Permission{{Action: accesscontrol.ActionAPIKeyRead, Scope: "apikeys:id:1"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			sc := setupHTTPServer(t, true, func(hs *HTTPServer) {
+				hs.apiKeyService = &apikeytest.Service{}
+			})
+			setInitCtxSignedInViewer(sc.initCtx)
+			setAccessControlPermissions(sc.acmock, test.permissions, sc.initCtx.OrgID)
+
+			response := callAPI(sc.server, test.method, test.url, nil, t)
+			assert.Equal(t, test.expectedCode, response.Code)
+		})
+	}
+}
+
+// recordingAPIKeyService records the commands replacing the secret of a key.
+type recordingAPIKeyService struct {
+	apikeytest.Service
+	added   *apikey.AddCommand
+	rotated *apikey.RotateCommand
+}
+
+func (s *recordingAPIKeyService) AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) error {
+	s.added = cmd
+	return s.Service.AddAPIKey(ctx, cmd)
+}
+
+func (s *recordingAPIKeyService) RotateAPIKey(ctx context.Context, cmd *apikey.RotateCommand) error {
+	s.rotated = cmd
+	return s.Service.RotateAPIKey(ctx, cmd)
+}
+
+func TestAPIKeyAPIEndpoint_ReplacedKeysNeedApproval(t *testing.T) {
+	writer := []accesscontrol.Permission{
+		{Action: accesscontrol.ActionAPIKeyCreate, Scope: accesscontrol.ScopeAPIKeysAll},
+		{Action: accesscontrol.ActionAPIKeyDelete, Scope: accesscontrol.ScopeAPIKeysAll},
+	}
+	approver := append([]accesscontrol.Permission{{Action: accesscontrol.ActionAPIKeyApprove, Scope: accesscontrol.ScopeAPIKeysAll}}, writer...)
+
+	tests := []struct {
+		desc          string
+		permissions   []accesscontrol.Permission
+		expectPending bool
+	}{
+		{desc: "keys replaced by users who cannot approve them are pending", permissions: writer, expectPending: true},
+		{desc: "keys replaced by approvers stay active", permissions: approver, expectPending: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			cfg := setting.NewCfg()
+			cfg.ApiKeyMaxSecondsToLive = -1
+			svc := &recordingAPIKeyService{Service: apikeytest.Service{
+				ExpectedAPIKey: &apikey.APIKey{Id: 1, OrgId: 1, Name: "ci", Role: org.RoleViewer, Status: apikey.StatusActive},
+			}}
+			sc := setupHTTPServerWithCfg(t, true, cfg, func(hs *HTTPServer) {
+				hs.apiKeyService = svc
+			})
+			setInitCtxSignedInViewer(sc.initCtx)
+			setAccessControlPermissions(sc.acmock, test.permissions, sc.initCtx.OrgID)
+
+			t.Run("upsert", func(t *testing.T) {
+				response := callAPI(sc.server, http.MethodPost, "/api/auth/keys", strings.NewReader(`{"name":"ci","role":"Viewer","upsert":true}`), t)
+				assert.Equal(t, http.StatusOK, response.Code)
+				require.NotNil(t, svc.added)
+				assert.Equal(t, test.expectPending, svc.added.Pending)
+			})
+
+			t.Run("rotation", func(t *testing.T) {
+				response := callAPI(sc.server, http.MethodPost, "/api/auth/keys/1/rotate", nil, t)
+				assert.Equal(t, http.StatusOK, response.Code)
+				require.NotNil(t, svc.rotated)
+				assert.Equal(t, test.expectPending, svc.rotated.Pending)
+			})
+		})
+	}
+}
@@ -376,7 +376,7 @@ func setupHTTPServerWithCfgDb(
 		ac = acmock
 	} else {
 		var err error
-		ac, err = ossaccesscontrol.ProvideService(cfg, database.ProvideService(db), routeRegister)
+		ac, err = ossaccesscontrol.ProvideService(cfg, database.ProvideService(db), routeRegister, db.Bus(), nil)
 		require.NoError(t, err)
 	}
 
@@ -19,6 +19,7 @@ import (
 	"github.com/grafana/grafana/pkg/plugins/adapters"
 	"github.com/grafana/grafana/pkg/services/datasources"
 	"github.com/grafana/grafana/pkg/services/datasources/permissions"
+	secretsKV "github.com/grafana/grafana/pkg/services/secrets/kvstore"
 	"github.com/grafana/grafana/pkg/services/user"
 	"github.com/grafana/grafana/pkg/util"
 	"github.com/grafana/grafana/pkg/util/proxyutil"
@@ -41,7 +42,7 @@ var secretsPluginError datasources.ErrDatasourceSecretsPluginUserFriendly
 // 403: forbiddenError
 // 500: internalServerError
 func (hs *HTTPServer) GetDataSources(c *models.ReqContext) response.Response {
-	query := datasources.GetDataSourcesQuery{OrgId: c.OrgID, DataSourceLimit: hs.Cfg.DataSourceLimit}
+	query := datasources.GetDataSourcesQuery{OrgId: c.OrgID, DataSourceLimit: hs.Cfg.DataSourceLimit, User: c.SignedInUser}
 
 	if err := hs.DataSourcesService.GetDataSources(c.Req.Context(), &query); err != nil {
 		return response.Error(500, "Failed to query datasources", err)
@@ -175,6 +176,10 @@ func (hs *HTTPServer) DeleteDataSourceById(c *models.ReqContext) response.Respon
 
 	err = hs.DataSourcesService.DeleteDataSource(c.Req.Context(), cmd)
 	if err != nil {
+		if errors.Is(err, secretsKV.ErrSecretAccessDenied) {
+			return response.Error(http.StatusForbidden, "Failed to delete datasource: "+err.Error(), err)
+		}
+
 		if errors.As(err, &secretsPluginError) {
 			return response.Error(500, "Failed to delete datasource: "+err.Error(), err)
 		}
@@ -254,6 +259,10 @@ func (hs *HTTPServer) DeleteDataSourceByUID(c *models.ReqContext) response.Respo
 
 	err = hs.DataSourcesService.DeleteDataSource(c.Req.Context(), cmd)
 	if err != nil {
+		if errors.Is(err, secretsKV.ErrSecretAccessDenied) {
+			return response.Error(http.StatusForbidden, "Failed to delete datasource: "+err.Error(), err)
+		}
+
 		if errors.As(err, &secretsPluginError) {
 			return response.Error(500, "Failed to delete datasource: "+err.Error(), err)
 		}
@@ -303,6 +312,10 @@ func (hs *HTTPServer) DeleteDataSourceByName(c *models.ReqContext) response.Resp
 	cmd := &datasources.DeleteDataSourceCommand{Name: name, OrgID: c.OrgID}
 	err := hs.DataSourcesService.DeleteDataSource(c.Req.Context(), cmd)
 	if err != nil {
+		if errors.Is(err, secretsKV.ErrSecretAccessDenied) {
+			return response.Error(http.StatusForbidden, "Failed to delete datasource: "+err.Error(), err)
+		}
+
 		if errors.As(err, &secretsPluginError) {
 			return response.Error(500, "Failed to delete datasource: "+err.Error(), err)
 		}
@@ -363,6 +376,15 @@ func (hs *HTTPServer) AddDataSource(c *models.ReqContext) response.Response {
 			return response.Error(409, err.Error(), err)
 		}
 
+		var secretValidationError *secretsKV.SecretValidationError
+		if errors.As(err, &secretValidationError) {
+			return response.Error(400, "Failed to add datasource: "+err.Error(), err)
+		}
+
+		if errors.Is(err, secretsKV.ErrSecretAccessDenied) {
+			return response.Error(http.StatusForbidden, "Failed to add datasource: "+err.Error(), err)
+		}
+
 		if errors.As(err, &secretsPluginError) {
 			return response.Error(500, "Failed to add datasource: "+err.Error(), err)
 		}
@@ -474,6 +496,15 @@ func (hs *HTTPServer) updateDataSourceByID(c *models.ReqContext, ds *datasources
 			return response.Error(409, "Datasource has already been updated by someone else. Please reload and try again", err)
 		}
 
+		var secretValidationError *secretsKV.SecretValidationError
+		if errors.As(err, &secretValidationError) {
+			return response.Error(400, "Failed to update datasource: "+err.Error(), err)
+		}
+
+		if errors.Is(err, secretsKV.ErrSecretAccessDenied) {
+			return response.Error(http.StatusForbidden, "Failed to update datasource: "+err.Error(), err)
+		}
+
 		if errors.As(err, &secretsPluginError) {
 			return response.Error(500, "Failed to update datasource: "+err.Error(), err)
 		}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/services/org"
 )
 
@@ -15,12 +16,44 @@ type NewApiKeyResult struct {
 	Name string `json:"name"`
 	// example: glsa_yscW25imSKJIuav8zF37RZmnbiDvB05G_fcaaf58a
 	Key string `json:"key"`
+	// The key cannot be used until an organization admin approves it when pending.
+	// example: active
+	Status string `json:"status,omitempty"`
+	// The key signs requests instead of being sent with them when signing.
+	// example: bearer
+	Type string `json:"type,omitempty"`
+}
+
+// ApiKeyIntrospection describes an API key without its secret. Only Active is
+// set for invalid and inactive keys.
+type ApiKeyIntrospection struct {
+	Active           bool                `json:"active"`
+	Id               int64               `json:"id,omitempty"`
+	Name             string              `json:"name,omitempty"`
+	OrgId            int64               `json:"orgId,omitempty"`
+	Role             org.RoleType        `json:"role,omitempty"`
+	ServiceAccountId *int64              `json:"serviceAccountId,omitempty"`
+	Type             string              `json:"type,omitempty"`
+	Expiration       *time.Time          `json:"expiration,omitempty"`
+	LastUsedAt       *time.Time          `json:"lastUsedAt,omitempty"`
+	Labels           map[string]string   `json:"labels,omitempty"`
+	DatasourceUIDs   []string            `json:"datasourceUids,omitempty"`
+	Permissions      map[string][]string `json:"permissions,omitempty"`
 }
 
 type ApiKeyDTO struct {
-	Id            int64                  `json:"id"`
-	Name          string                 `json:"name"`
-	Role          org.RoleType           `json:"role"`
-	Expiration    *time.Time             `json:"expiration,omitempty"`
-	AccessControl accesscontrol.Metadata `json:"accessControl,omitempty"`
+	Id              int64                  `json:"id"`
+	Name            string                 `json:"name"`
+	Role            org.RoleType           `json:"role"`
+	Expiration      *time.Time             `json:"expiration,omitempty"`
+	AllowedIPRanges []string               `json:"allowedIpRanges,omitempty"`
+	DatasourceUIDs  []string               `json:"datasourceUids,omitempty"`
+	OwnerTeamId     *int64                 `json:"ownerTeamId,omitempty"`
+	AccessWindows   []apikey.AccessWindow  `json:"accessWindows,omitempty"`
+	Labels          map[string]string      `json:"labels,omitempty"`
+	RateLimitRPS    int64                  `json:"rateLimitRps,omitempty"`
+	Status          string                 `json:"status"`
+	Type            string                 `json:"type"`
+	HasSecondary    bool                   `json:"hasSecondary,omitempty"`
+	AccessControl   accesscontrol.Metadata `json:"accessControl,omitempty"`
 }
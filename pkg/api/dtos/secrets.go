@@ -0,0 +1,20 @@
+package dtos
+
+import "encoding/json"
+
+type ExportSecretsCmd struct {
+	Passphrase  string  `json:"passphrase"`
+	KeyProvider string  `json:"keyProvider"`
+	OrgIds      []int64 `json:"orgIds"`
+}
+
+type ImportSecretsCmd struct {
+	Passphrase string          `json:"passphrase"`
+	OrgMapping map[int64]int64 `json:"orgMapping"`
+	Bundle     json.RawMessage `json:"bundle"`
+}
+
+type MoveSecretsNamespaceCmd struct {
+	Namespace string `json:"namespace"`
+	Backend   string `json:"backend"`
+}
@@ -2,11 +2,19 @@ package api
 
 import (
 	"context"
+	"net/http"
 	"time"
 
+	"github.com/grafana/grafana/pkg/api/response"
 	"github.com/grafana/grafana/pkg/models"
+	secretsKV "github.com/grafana/grafana/pkg/services/secrets/kvstore"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
 )
 
+type connectionPoolHealthChecker interface {
+	GetConnectionPoolHealth() sqlstore.ConnectionPoolHealth
+}
+
 func (hs *HTTPServer) databaseHealthy(ctx context.Context) bool {
 	const cacheKey = "db-healthy"
 
@@ -19,3 +27,24 @@ func (hs *HTTPServer) databaseHealthy(ctx context.Context) bool {
 	hs.CacheService.Set(cacheKey, healthy, time.Second*5)
 	return healthy
 }
+
+// SecretsHealth returns the health of the backend of the unified secrets, with http status code 503 when it
+// cannot be reached. Secrets that failed the integrity check only degrade the health, the backend still serves the
+// other secrets.
+func (hs *HTTPServer) SecretsHealth(c *models.ReqContext) response.Response {
+	health := hs.secretsHealth.Check(c.Req.Context())
+	if health.Status != secretsKV.HealthStatusOK && health.Status != secretsKV.HealthStatusDegraded {
+		return response.JSON(http.StatusServiceUnavailable, health)
+	}
+	return response.JSON(http.StatusOK, health)
+}
+
+// DatabaseConnectionPoolHealth returns the state of the connection pool of the database, with http status code 503
+// when the pool is exhausted.
+func (hs *HTTPServer) DatabaseConnectionPoolHealth(c *models.ReqContext) response.Response {
+	health := hs.connectionPool.GetConnectionPoolHealth()
+	if health.Exhausted {
+		return response.JSON(http.StatusServiceUnavailable, health)
+	}
+	return response.JSON(http.StatusOK, health)
+}
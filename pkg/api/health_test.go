@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/grafana/grafana/pkg/infra/localcache"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/grafana/grafana/pkg/services/sqlstore/mockstore"
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/grafana/grafana/pkg/web"
@@ -163,3 +165,25 @@ func setupHealthAPITestEnvironment(t *testing.T, cbs ...func(*setting.Cfg)) (*we
 	m.Get("/api/health", hs.apiHealthHandler)
 	return m, hs
 }
+
+type fakeConnectionPool struct {
+	health sqlstore.ConnectionPoolHealth
+}
+
+func (f *fakeConnectionPool) GetConnectionPoolHealth() sqlstore.ConnectionPoolHealth {
+	return f.health
+}
+
+func TestHealthAPI_DatabaseConnectionPool(t *testing.T) {
+	_, hs := setupHealthAPITestEnvironment(t)
+	pool := &fakeConnectionPool{health: sqlstore.ConnectionPoolHealth{Database: "grafana", MaxOpenConnections: 2, InUse: 1}}
+	hs.connectionPool = pool
+
+	resp := hs.DatabaseConnectionPoolHealth(&models.ReqContext{})
+	require.Equal(t, 200, resp.Status())
+
+	pool.health.InUse = 2
+	pool.health.Exhausted = true
+	resp = hs.DatabaseConnectionPoolHealth(&models.ReqContext{})
+	require.Equal(t, 503, resp.Status())
+}
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/middleware/csrf"
@@ -36,6 +37,7 @@ import (
 	"github.com/grafana/grafana/pkg/plugins"
 	"github.com/grafana/grafana/pkg/plugins/plugincontext"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/accesscontrol/decisionlog"
 	"github.com/grafana/grafana/pkg/services/alerting"
 	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/services/cleanup"
@@ -75,6 +77,7 @@ import (
 	"github.com/grafana/grafana/pkg/services/search"
 	"github.com/grafana/grafana/pkg/services/searchusers"
 	"github.com/grafana/grafana/pkg/services/secrets"
+	secretsKV "github.com/grafana/grafana/pkg/services/secrets/kvstore"
 	"github.com/grafana/grafana/pkg/services/serviceaccounts"
 	"github.com/grafana/grafana/pkg/services/shorturls"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
@@ -177,6 +180,16 @@ type HTTPServer struct {
 	apiKeyService                apikey.Service
 	kvStore                      kvstore.KVStore
 	secretsMigrator              secrets.Migrator
+	pluginSecretMigration        *secretsKV.PluginSecretMigrationService
+	secretsAudit                 *secretsKV.AuditService
+	secretsExport                *secretsKV.SecretsExportService
+	secretsHealth                *secretsKV.HealthService
+	secretsConsistency           *secretsKV.SecretsConsistencyService
+	secretsPluginQuarantine      *secretsKV.PluginQuarantineService
+	secretsPluginReload          *secretsKV.PluginReloadService
+	secretsRouting               *secretsKV.SecretsRoutingService
+	accessControlDecisions       *decisionlog.Service
+	connectionPool               connectionPoolHealthChecker
 	userService                  user.Service
 	tempUserService              tempUser.Service
 	loginAttemptService          loginAttempt.Service
@@ -215,6 +228,11 @@ func ProvideHTTPServer(opts ServerOptions, cfg *setting.Cfg, routeRegister routi
 	dashboardPermissionsService accesscontrol.DashboardPermissionsService, dashboardVersionService dashver.Service,
 	starService star.Service, csrfService csrf.Service, coremodels *registry.Base,
 	playlistService playlist.Service, apiKeyService apikey.Service, kvStore kvstore.KVStore, secretsMigrator secrets.Migrator, secretsPluginManager plugins.SecretsPluginManager,
+	pluginSecretMigration *secretsKV.PluginSecretMigrationService, secretsAudit *secretsKV.AuditService,
+	secretsExport *secretsKV.SecretsExportService, secretsHealth *secretsKV.HealthService, secretsConsistency *secretsKV.SecretsConsistencyService,
+	secretsPluginQuarantine *secretsKV.PluginQuarantineService, secretsPluginReload *secretsKV.PluginReloadService,
+	secretsRouting *secretsKV.SecretsRoutingService,
+	accessControlDecisions *decisionlog.Service,
 	publicDashboardsApi *publicdashboardsApi.Api, userService user.Service, tempUserService tempUser.Service, loginAttemptService loginAttempt.Service) (*HTTPServer, error) {
 	web.Env = cfg.Env
 	m := web.New()
@@ -302,6 +320,16 @@ func ProvideHTTPServer(opts ServerOptions, cfg *setting.Cfg, routeRegister routi
 		kvStore:                      kvStore,
 		PublicDashboardsApi:          publicDashboardsApi,
 		secretsMigrator:              secretsMigrator,
+		pluginSecretMigration:        pluginSecretMigration,
+		secretsAudit:                 secretsAudit,
+		secretsExport:                secretsExport,
+		secretsHealth:                secretsHealth,
+		secretsConsistency:           secretsConsistency,
+		secretsPluginQuarantine:      secretsPluginQuarantine,
+		secretsPluginReload:          secretsPluginReload,
+		secretsRouting:               secretsRouting,
+		accessControlDecisions:       accessControlDecisions,
+		connectionPool:               sqlStore,
 		userService:                  userService,
 		tempUserService:              tempUserService,
 		loginAttemptService:          loginAttemptService,
@@ -524,6 +552,7 @@ func (hs *HTTPServer) addMiddlewaresAndStaticRoutes() {
 
 	m.Use(middleware.RequestTracing(hs.tracer))
 	m.Use(middleware.RequestMetrics(hs.Features))
+	m.Use(middleware.APIKeyUsage(hs.Cfg, hs.apiKeyService, hs.bus))
 
 	m.Use(middleware.Logger(hs.Cfg))
 
@@ -558,6 +587,7 @@ func (hs *HTTPServer) addMiddlewaresAndStaticRoutes() {
 	m.Use(hs.pluginMetricsEndpoint)
 
 	m.Use(hs.ContextHandler.Middleware)
+	m.Use(middleware.APIKeyRateLimit(hs.RemoteCacheService, hs.apiKeyService, time.Now))
 	m.Use(middleware.OrgRedirect(hs.Cfg, hs.SQLStore))
 	m.Use(accesscontrol.LoadPermissionsMiddleware(hs.AccessControl))
 
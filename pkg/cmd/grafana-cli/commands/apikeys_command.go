@@ -0,0 +1,393 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/runner"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+	"github.com/grafana/grafana/pkg/components/apikeygen"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/apikey/apikeyimpl"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts"
+)
+
+var (
+	apiKeyOrgIDFlag = &cli.IntFlag{
+		Name:  "org-id",
+		Usage: "ID of the organization owning the API keys",
+		Value: 1,
+	}
+	apiKeyJSONFlag = &cli.BoolFlag{
+		Name:  "json",
+		Usage: "Print the result as JSON",
+	}
+)
+
+// apiKeyOutput is how API keys are printed by the apikeys commands.
+type apiKeyOutput struct {
+	ID         int64      `json:"id"`
+	OrgID      int64      `json:"orgId"`
+	Name       string     `json:"name"`
+	Role       string     `json:"role"`
+	Type       string     `json:"type"`
+	Expiration *time.Time `json:"expiration,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	// Key is the secret of a created or rotated key, it is only shown once.
+	Key string `json:"key,omitempty"`
+}
+
+func newAPIKeyOutput(key *apikey.APIKey, secret string) apiKeyOutput {
+	out := apiKeyOutput{
+		ID:         key.Id,
+		OrgID:      key.OrgId,
+		Name:       key.Name,
+		Role:       string(key.Role),
+		Type:       key.Type,
+		LastUsedAt: key.LastUsedAt,
+		Key:        secret,
+	}
+	if key.Expires != nil {
+		expiration := time.Unix(*key.Expires, 0).UTC()
+		out.Expiration = &expiration
+	}
+	return out
+}
+
+// writeAPIKey prints a single key, as a JSON object when asJSON is set.
+func writeAPIKey(w io.Writer, asJSON bool, key apiKeyOutput) error {
+	if asJSON {
+		return writeJSON(w, key)
+	}
+	return writeAPIKeys(w, false, []apiKeyOutput{key})
+}
+
+// writeAPIKeys prints a list of keys, as a JSON array when asJSON is set.
+func writeAPIKeys(w io.Writer, asJSON bool, keys []apiKeyOutput) error {
+	if asJSON {
+		return writeJSON(w, keys)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tROLE\tEXPIRATION\tLAST USED")
+	for _, k := range keys {
+		expiration, lastUsed := "never", "never"
+		if k.Expiration != nil {
+			expiration = k.Expiration.Format(time.RFC3339)
+		}
+		if k.LastUsedAt != nil {
+			lastUsed = k.LastUsedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", k.ID, k.Name, k.Role, expiration, lastUsed)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if k.Key != "" {
+			fmt.Fprintf(w, "\nKey for %q, it will not be shown again:\n%s\n", k.Name, k.Key)
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func listAPIKeysCommand(c utils.CommandLine, runner runner.Runner) error {
+	return listAPIKeys(context.Background(), runner.APIKeyService, int64(c.Int("org-id")), c.Bool("json"), os.Stdout)
+}
+
+func listAPIKeys(ctx context.Context, svc apikey.Service, orgID int64, asJSON bool, w io.Writer) error {
+	keys := svc.GetAllAPIKeys(ctx, orgID)
+
+	out := make([]apiKeyOutput, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, newAPIKeyOutput(k, ""))
+	}
+	return writeAPIKeys(w, asJSON, out)
+}
+
+func createAPIKeyCommand(c utils.CommandLine, runner runner.Runner) error {
+	cmd := apikey.AddCommand{
+		Name:          c.Args().First(),
+		Role:          org.RoleType(c.String("role")),
+		OrgId:         int64(c.Int("org-id")),
+		SecondsToLive: int64(c.Int("seconds-to-live")),
+		Type:          c.String("type"),
+		Upsert:        c.Bool("upsert"),
+	}
+	return createAPIKey(context.Background(), runner.APIKeyService, &cmd, c.Bool("json"), os.Stdout)
+}
+
+func createAPIKey(ctx context.Context, svc apikey.Service, cmd *apikey.AddCommand, asJSON bool, w io.Writer) error {
+	if cmd.Name == "" {
+		return fmt.Errorf("missing API key name")
+	}
+	if !cmd.Role.IsValid() {
+		return fmt.Errorf("invalid role %q, expected Viewer, Editor or Admin", cmd.Role)
+	}
+
+	newKeyInfo, err := apikeygen.New(cmd.OrgId, cmd.Name)
+	if err != nil {
+		return fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	cmd.Secret = newKeyInfo.Secret
+	cmd.Fingerprint = apikey.LeakHash(newKeyInfo.ClientSecret)
+	if cmd.Type == apikey.TypeSigning {
+		cmd.SigningSecret = newKeyInfo.ClientSecret
+	}
+	if err := svc.AddAPIKey(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	return writeAPIKey(w, asJSON, newAPIKeyOutput(cmd.Result, newKeyInfo.ClientSecret))
+}
+
+func revokeAPIKeyCommand(c utils.CommandLine, runner runner.Runner) error {
+	id, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid API key id %q", c.Args().First())
+	}
+
+	cmd := apikey.DeleteCommand{Id: id, OrgId: int64(c.Int("org-id"))}
+	if err := runner.APIKeyService.DeleteApiKey(context.Background(), &cmd); err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	if c.Bool("json") {
+		return writeJSON(os.Stdout, map[string]int64{"id": id})
+	}
+	logger.Infof("API key %d revoked %s\n", id, color.GreenString("✔"))
+	return nil
+}
+
+func rotateAPIKeyCommand(c utils.CommandLine, runner runner.Runner) error {
+	id, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid API key id %q", c.Args().First())
+	}
+
+	cmd := apikey.RotateCommand{Id: id, OrgId: int64(c.Int("org-id"))}
+	if c.String("grace-period") != "" {
+		gracePeriod, err := time.ParseDuration(c.String("grace-period"))
+		if err != nil {
+			return fmt.Errorf("invalid grace period: %w", err)
+		}
+		seconds := int64(gracePeriod.Seconds())
+		cmd.GracePeriodSeconds = &seconds
+	}
+	return rotateAPIKey(context.Background(), runner.APIKeyService, &cmd, c.Bool("json"), os.Stdout)
+}
+
+func rotateAPIKey(ctx context.Context, svc apikey.Service, cmd *apikey.RotateCommand, asJSON bool, w io.Writer) error {
+	query := apikey.GetByIDQuery{ApiKeyId: cmd.Id}
+	if err := svc.GetApiKeyById(ctx, &query); err != nil {
+		if errors.Is(err, apikey.ErrInvalid) {
+			return apikey.ErrNotFound
+		}
+		return fmt.Errorf("failed to get API key: %w", err)
+	}
+	if query.Result.OrgId != cmd.OrgId || query.Result.ServiceAccountId != nil {
+		return apikey.ErrNotFound
+	}
+
+	newKeyInfo, err := apikeygen.New(cmd.OrgId, query.Result.Name)
+	if err != nil {
+		return fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	cmd.Secret = newKeyInfo.Secret
+	cmd.Fingerprint = apikey.LeakHash(newKeyInfo.ClientSecret)
+	if query.Result.IsSigning() {
+		cmd.SigningSecret = newKeyInfo.ClientSecret
+	}
+	if err := svc.RotateAPIKey(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to rotate API key: %w", err)
+	}
+
+	return writeAPIKey(w, asJSON, newAPIKeyOutput(cmd.Result, newKeyInfo.ClientSecret))
+}
+
+func exportAPIKeysCommand(c utils.CommandLine, runner runner.Runner) error {
+	path := c.Args().First()
+	if path == "" {
+		return fmt.Errorf("missing path of the bundle")
+	}
+
+	query := apikey.ExportKeysQuery{
+		Passphrase:  c.String("passphrase"),
+		KeyProvider: c.String("key-provider"),
+	}
+	if orgID := c.Int("org-id"); orgID != 0 {
+		query.OrgIds = []int64{int64(orgID)}
+	}
+	if err := runner.APIKeyService.ExportKeys(context.Background(), &query); err != nil {
+		return fmt.Errorf("failed to export API keys: %w", err)
+	}
+
+	if err := os.WriteFile(path, query.Result, 0600); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	logger.Infof("API keys exported to %s %s\n", path, color.GreenString("✔"))
+	return nil
+}
+
+func importAPIKeysCommand(c utils.CommandLine, runner runner.Runner) error {
+	path := c.Args().First()
+	if path == "" {
+		return fmt.Errorf("missing path of the bundle")
+	}
+
+	// nolint:gosec
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	cmd := apikey.ImportKeysCommand{Bundle: bundle, Passphrase: c.String("passphrase")}
+	if err := runner.APIKeyService.ImportKeys(context.Background(), &cmd); err != nil {
+		return fmt.Errorf("failed to import API keys: %w", err)
+	}
+
+	if c.Bool("json") {
+		return writeJSON(os.Stdout, map[string]int{"imported": cmd.Result.Imported, "skipped": cmd.Result.Skipped})
+	}
+	logger.Infof("%d API keys imported, %d already existing skipped %s\n", cmd.Result.Imported, cmd.Result.Skipped, color.GreenString("✔"))
+	return nil
+}
+
+func enforceAPIKeysLifetimeCommand(c utils.CommandLine, runner runner.Runner) error {
+	if runner.Cfg.ApiKeyMaxSecondsToLive <= 0 {
+		return fmt.Errorf("api_key_max_seconds_to_live is not configured")
+	}
+	return enforceAPIKeysLifetime(context.Background(), runner.LifetimeEnforcer, c.Bool("dry-run"), c.Bool("json"), os.Stdout)
+}
+
+func enforceAPIKeysLifetime(ctx context.Context, enforcer *apikeyimpl.LifetimeEnforcer, dryRun, asJSON bool, w io.Writer) error {
+	report, err := enforcer.Enforce(ctx, dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to enforce API keys lifetime: %w", err)
+	}
+	if asJSON {
+		return writeJSON(w, report)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tORG\tNAME\tEXPIRATION\tMAX EXPIRATION")
+	for _, k := range report.Keys {
+		expiration, maxExpiration := "never", k.MaxExpires.UTC().Format(time.RFC3339)
+		if k.Expires != nil {
+			expiration = k.Expires.UTC().Format(time.RFC3339)
+		}
+		if k.Expired {
+			maxExpiration += " (expired)"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n", k.Id, k.OrgId, k.Name, expiration, maxExpiration)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if dryRun {
+		fmt.Fprintf(w, "\n%d API keys exceed the maximum lifetime, none updated (dry run)\n", len(report.Keys))
+	} else {
+		fmt.Fprintf(w, "\n%d API keys exceed the maximum lifetime, %d updated\n", len(report.Keys), report.Updated)
+	}
+	return nil
+}
+
+func migrateAPIKeysToServiceAccountsCommand(c utils.CommandLine, runner runner.Runner) error {
+	return migrateAPIKeysToServiceAccounts(context.Background(), runner.ServiceAccounts, int64(c.Int("org-id")), c.Bool("dry-run"), c.Bool("json"), os.Stdout)
+}
+
+func migrateAPIKeysToServiceAccounts(ctx context.Context, store serviceaccounts.Store, orgID int64, dryRun, asJSON bool, w io.Writer) error {
+	report, err := store.GetAPIKeysMigrationReport(ctx, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to check the API keys migration: %w", err)
+	}
+	if dryRun {
+		if asJSON {
+			return writeJSON(w, report)
+		}
+		return writeAPIKeysMigrationReport(w, report)
+	}
+
+	if len(report.Collisions) > 0 {
+		return fmt.Errorf("%d API keys collide with existing service accounts or users, run with --dry-run for details", len(report.Collisions))
+	}
+	if err := store.MigrateApiKeysToServiceAccounts(ctx, orgID); err != nil {
+		return fmt.Errorf("failed to migrate API keys to service accounts: %w", err)
+	}
+	if asJSON {
+		return writeJSON(w, report)
+	}
+	logger.Infof("%d API keys migrated to service accounts %s\n", len(report.Converted)+len(report.UnmappedRoles), color.GreenString("✔"))
+	return nil
+}
+
+func writeAPIKeysMigrationReport(w io.Writer, report *serviceaccounts.APIKeysMigrationReport) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tROLE\tSERVICE ACCOUNT\tSTATUS")
+	write := func(items []serviceaccounts.APIKeyMigrationItem, status func(serviceaccounts.APIKeyMigrationItem) string) {
+		for _, item := range items {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", item.KeyId, item.KeyName, item.Role, item.ServiceAccountLogin, status(item))
+		}
+	}
+	write(report.Converted, func(serviceaccounts.APIKeyMigrationItem) string { return "converted" })
+	write(report.Collisions, func(item serviceaccounts.APIKeyMigrationItem) string { return "collision: " + item.Reason })
+	write(report.UnmappedRoles, func(serviceaccounts.APIKeyMigrationItem) string { return "no role mapping" })
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n%d API keys would be converted, %d collide, %d have no role mapping (dry run)\n",
+		len(report.Converted), len(report.Collisions), len(report.UnmappedRoles))
+	return nil
+}
+
+func revertAPIKeysMigrationCommand(c utils.CommandLine, runner runner.Runner) error {
+	return revertAPIKeysMigration(context.Background(), runner.ServiceAccounts, int64(c.Int("org-id")), c.Bool("json"), os.Stdout)
+}
+
+func revertAPIKeysMigration(ctx context.Context, store serviceaccounts.Store, orgID int64, asJSON bool, w io.Writer) error {
+	report, err := store.RevertApiKeysMigration(ctx, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to revert the API keys migration: %w", err)
+	}
+	if asJSON {
+		return writeJSON(w, report)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tROLE\tSERVICE ACCOUNT\tSTATUS")
+	for _, item := range report.Reverted {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\treverted\n", item.KeyId, item.KeyName, item.Role, item.ServiceAccountLogin)
+	}
+	for _, item := range report.Skipped {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\tskipped: %s\n", item.KeyId, item.KeyName, item.Role, item.ServiceAccountLogin, item.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n%d service account tokens reverted to API keys, %d skipped\n", len(report.Reverted), len(report.Skipped))
+	return nil
+}
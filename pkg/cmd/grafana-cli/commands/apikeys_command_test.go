@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/apikey/apikeyimpl"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+func TestIntegrationAPIKeysCommands(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	db := sqlstore.InitTestDB(t)
+	svc := apikeyimpl.ProvideService(db, db.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clock.New())
+	ctx := context.Background()
+
+	var created apiKeyOutput
+	t.Run("create prints the key as JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		cmd := apikey.AddCommand{Name: "cli", Role: org.RoleEditor, OrgId: 1}
+		require.NoError(t, createAPIKey(ctx, svc, &cmd, true, &buf))
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &created))
+		assert.Equal(t, "cli", created.Name)
+		assert.Equal(t, "Editor", created.Role)
+		assert.NotEmpty(t, created.Key)
+	})
+
+	t.Run("create rejects invalid roles", func(t *testing.T) {
+		cmd := apikey.AddCommand{Name: "invalid", Role: "Owner", OrgId: 1}
+		require.Error(t, createAPIKey(ctx, svc, &cmd, true, &bytes.Buffer{}))
+	})
+
+	t.Run("list prints the keys of the organization", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, listAPIKeys(ctx, svc, 1, true, &buf))
+
+		var keys []apiKeyOutput
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &keys))
+		require.Len(t, keys, 1)
+		assert.Equal(t, created.ID, keys[0].ID)
+		assert.Empty(t, keys[0].Key)
+
+		buf.Reset()
+		require.NoError(t, listAPIKeys(ctx, svc, 2, true, &buf))
+		assert.JSONEq(t, "[]", buf.String())
+	})
+
+	t.Run("rotate prints a new key", func(t *testing.T) {
+		var buf bytes.Buffer
+		cmd := apikey.RotateCommand{Id: created.ID, OrgId: 1}
+		require.NoError(t, rotateAPIKey(ctx, svc, &cmd, true, &buf))
+
+		var rotated apiKeyOutput
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &rotated))
+		assert.Equal(t, created.ID, rotated.ID)
+		assert.NotEqual(t, created.Key, rotated.Key)
+	})
+
+	t.Run("rotate fails for keys of other organizations", func(t *testing.T) {
+		cmd := apikey.RotateCommand{Id: created.ID, OrgId: 2}
+		require.ErrorIs(t, rotateAPIKey(ctx, svc, &cmd, true, &bytes.Buffer{}), apikey.ErrNotFound)
+	})
+}
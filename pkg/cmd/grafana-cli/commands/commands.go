@@ -13,6 +13,7 @@ import (
 	"github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
 	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
 	"github.com/grafana/grafana/pkg/infra/tracing"
+	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/grafana/grafana/pkg/services/sqlstore/migrations"
 	"github.com/grafana/grafana/pkg/setting"
@@ -72,6 +73,34 @@ func runDbCommand(command func(commandLine utils.CommandLine, sqlStore *sqlstore
 	}
 }
 
+func runMigrationCommand(command func(commandLine utils.CommandLine, sqlStore *sqlstore.SQLStore) error) func(context *cli.Context) error {
+	return func(context *cli.Context) error {
+		cmd := &utils.ContextCommandLine{Context: context}
+
+		cfg, err := initCfg(cmd)
+		if err != nil {
+			return fmt.Errorf("%v: %w", "failed to load configuration", err)
+		}
+
+		tracer, err := tracing.ProvideService(cfg)
+		if err != nil {
+			return fmt.Errorf("%v: %w", "failed to initialize tracer service", err)
+		}
+
+		sqlStore, err := sqlstore.ProvideServiceWithoutMigrations(cfg, &migrations.OSSMigrations{}, bus.ProvideBus(tracer), tracer)
+		if err != nil {
+			return fmt.Errorf("%v: %w", "failed to initialize SQL store", err)
+		}
+
+		if err := command(cmd, sqlStore); err != nil {
+			return err
+		}
+
+		logger.Info("\n\n")
+		return nil
+	}
+}
+
 func initCfg(cmd *utils.ContextCommandLine) (*setting.Cfg, error) {
 	configOptions := strings.Split(cmd.String("configOverrides"), " ")
 	cfg, err := setting.NewCfgFromArgs(setting.CommandLineArgs{
@@ -192,6 +221,269 @@ var adminCommands = []*cli.Command{
 			},
 		},
 	},
+	{
+		Name:  "secrets",
+		Usage: "Manages the unified secrets through the secrets store",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "export",
+				Usage:  "export <path>",
+				Action: runRunnerCommand(exportSecretsCommand),
+				Flags: []cli.Flag{
+					secretsPassphraseFlag,
+					&cli.StringFlag{
+						Name:  "key-provider",
+						Usage: "Encryption provider encrypting the bundle instead of a passphrase, e.g. awskms.v1.my-key",
+					},
+					&cli.IntFlag{
+						Name:  "org-id",
+						Usage: "ID of the organization whose secrets are exported, all organizations when omitted",
+					},
+				},
+			},
+			{
+				Name:   "import",
+				Usage:  "import <path>",
+				Action: runRunnerCommand(importSecretsCommand),
+				Flags: []cli.Flag{
+					secretsPassphraseFlag,
+					apiKeyJSONFlag,
+					&cli.StringFlag{
+						Name:  "org-mapping",
+						Usage: "Organizations of the exporting instance and the organizations their secrets are imported in, e.g. 1:2,3:4",
+					},
+				},
+			},
+			{
+				Name:   "check-consistency",
+				Usage:  "Lists the secrets no data source references and the secrets of data sources that are missing",
+				Action: runRunnerCommand(checkSecretsConsistencyCommand),
+				Flags: []cli.Flag{
+					apiKeyJSONFlag,
+					&cli.BoolFlag{
+						Name:  "prune",
+						Usage: "Deletes the secrets no data source references",
+					},
+				},
+			},
+			{
+				Name:   "list",
+				Usage:  "Lists the keys of the secrets, without their values",
+				Action: runRunnerCommand(listSecretsCommand),
+				Flags: []cli.Flag{
+					apiKeyJSONFlag,
+					&cli.IntFlag{
+						Name:  "org-id",
+						Usage: "ID of the organization whose secrets are listed, all organizations when omitted",
+					},
+					&cli.StringFlag{
+						Name:  "namespace",
+						Usage: "Namespace of the secrets, in which * matches any characters",
+					},
+					&cli.StringFlag{
+						Name:  "type",
+						Usage: "Type of the secrets, e.g. datasource",
+					},
+				},
+			},
+			{
+				Name:   "get",
+				Usage:  "get <namespace> <type>",
+				Action: runRunnerCommand(getSecretCommand),
+				Flags: []cli.Flag{
+					secretOrgIDFlag,
+					apiKeyJSONFlag,
+					&cli.BoolFlag{
+						Name:  "show-value",
+						Usage: "Print the decrypted value of the secret instead of redacting it",
+					},
+				},
+			},
+			{
+				Name:   "set",
+				Usage:  "set <namespace> <type>, the value is read from stdin",
+				Action: runRunnerCommand(setSecretCommand),
+				Flags:  []cli.Flag{secretOrgIDFlag},
+			},
+			{
+				Name:   "delete",
+				Usage:  "delete <namespace> <type>",
+				Action: runRunnerCommand(deleteSecretCommand),
+				Flags:  []cli.Flag{secretOrgIDFlag},
+			},
+			{
+				Name:   "migrate",
+				Usage:  "Migrates the secrets to or from the secrets manager plugin, or moves a namespace to another backend of the routes",
+				Action: runRunnerCommand(migrateSecretsCommand),
+				Flags: []cli.Flag{
+					apiKeyJSONFlag,
+					&cli.StringFlag{
+						Name:  "namespace",
+						Usage: "Namespace moved to --backend, instead of migrating the secrets to or from the plugin",
+					},
+					&cli.StringFlag{
+						Name:  "backend",
+						Usage: "Backend of the routes the namespace is moved to",
+					},
+				},
+			},
+			{
+				Name:   "verify",
+				Usage:  "Reads every secret back, without printing the values, and lists the secrets that can't be read",
+				Action: runRunnerCommand(verifySecretsCommand),
+				Flags: []cli.Flag{
+					apiKeyJSONFlag,
+					&cli.IntFlag{
+						Name:  "org-id",
+						Usage: "ID of the organization whose secrets are verified, all organizations when omitted",
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:  "apikeys",
+		Usage: "Manage API keys without the HTTP API",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "Lists the API keys of an organization",
+				Action: runRunnerCommand(listAPIKeysCommand),
+				Flags:  []cli.Flag{apiKeyOrgIDFlag, apiKeyJSONFlag},
+			},
+			{
+				Name:   "create",
+				Usage:  "create <name>",
+				Action: runRunnerCommand(createAPIKeyCommand),
+				Flags: []cli.Flag{
+					apiKeyOrgIDFlag,
+					apiKeyJSONFlag,
+					&cli.StringFlag{
+						Name:  "role",
+						Usage: "Role of the API key, one of Viewer, Editor or Admin",
+						Value: "Viewer",
+					},
+					&cli.IntFlag{
+						Name:  "seconds-to-live",
+						Usage: "Number of seconds before the API key expires, 0 for no expiration",
+					},
+					&cli.StringFlag{
+						Name:  "type",
+						Usage: "Type of the API key, bearer or signing. The secret of a signing key signs requests instead of being sent with them",
+						Value: apikey.TypeBearer,
+					},
+					&cli.BoolFlag{
+						Name:  "upsert",
+						Usage: "Replace the secret and the expiration of the API key with the same name instead of failing",
+					},
+				},
+			},
+			{
+				Name:   "revoke",
+				Usage:  "revoke <id>",
+				Action: runRunnerCommand(revokeAPIKeyCommand),
+				Flags:  []cli.Flag{apiKeyOrgIDFlag, apiKeyJSONFlag},
+			},
+			{
+				Name:   "rotate",
+				Usage:  "rotate <id>",
+				Action: runRunnerCommand(rotateAPIKeyCommand),
+				Flags: []cli.Flag{
+					apiKeyOrgIDFlag,
+					apiKeyJSONFlag,
+					&cli.StringFlag{
+						Name:  "grace-period",
+						Usage: "How long the replaced key remains valid, e.g. 1h. Defaults to api_key_rotation_grace_period",
+					},
+				},
+			},
+			{
+				Name:   "export",
+				Usage:  "export <path>",
+				Action: runRunnerCommand(exportAPIKeysCommand),
+				Flags: []cli.Flag{
+					secretsPassphraseFlag,
+					&cli.StringFlag{
+						Name:  "key-provider",
+						Usage: "Encryption provider encrypting the bundle instead of a passphrase, e.g. awskms.v1.my-key",
+					},
+					&cli.IntFlag{
+						Name:  "org-id",
+						Usage: "ID of the organization whose API keys are exported, all organizations when omitted",
+					},
+				},
+			},
+			{
+				Name:   "import",
+				Usage:  "import <path>",
+				Action: runRunnerCommand(importAPIKeysCommand),
+				Flags:  []cli.Flag{secretsPassphraseFlag, apiKeyJSONFlag},
+			},
+			{
+				Name:   "enforce-lifetime",
+				Usage:  "Applies api_key_max_seconds_to_live to existing API keys",
+				Action: runRunnerCommand(enforceAPIKeysLifetimeCommand),
+				Flags: []cli.Flag{
+					apiKeyJSONFlag,
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Only report the API keys exceeding the maximum lifetime",
+					},
+				},
+			},
+			{
+				Name:   "migrate-to-service-accounts",
+				Usage:  "Migrates the API keys of an organization to service accounts",
+				Action: runRunnerCommand(migrateAPIKeysToServiceAccountsCommand),
+				Flags: []cli.Flag{
+					apiKeyOrgIDFlag,
+					apiKeyJSONFlag,
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Only report the API keys that would be converted, the name collisions and the roles without a mapping",
+					},
+				},
+			},
+			{
+				Name:   "revert-service-accounts-migration",
+				Usage:  "Converts the service account tokens created by migrate-to-service-accounts back to API keys",
+				Action: runRunnerCommand(revertAPIKeysMigrationCommand),
+				Flags:  []cli.Flag{apiKeyOrgIDFlag, apiKeyJSONFlag},
+			},
+		},
+	},
+	{
+		Name:  "kvstore",
+		Usage: "Inspects the key/value store to debug the services that use it",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "namespaces",
+				Usage:  "Lists the namespaces with their number of keys",
+				Action: runRunnerCommand(listKVStoreNamespacesCommand),
+				Flags:  []cli.Flag{apiKeyJSONFlag},
+			},
+			{
+				Name:   "dump",
+				Usage:  "dump <namespace>",
+				Action: runRunnerCommand(dumpKVStoreNamespaceCommand),
+				Flags: []cli.Flag{
+					apiKeyJSONFlag,
+					&cli.IntFlag{
+						Name:  "org-id",
+						Usage: "ID of the organization whose keys are dumped, all organizations when omitted",
+					},
+					&cli.StringFlag{
+						Name:  "prefix",
+						Usage: "Prefix of the keys to dump",
+					},
+					&cli.BoolFlag{
+						Name:  "show-values",
+						Usage: "Prints the values, decrypting the values of the sensitive namespaces, instead of redacting them",
+					},
+				},
+			},
+		},
+	},
 	{
 		Name:  "user-manager",
 		Usage: "Runs different helpful user commands",
@@ -217,6 +509,28 @@ var adminCommands = []*cli.Command{
 	},
 }
 
+var dbCommands = []*cli.Command{
+	{
+		Name:   "migrate",
+		Usage:  "Runs the pending database migrations",
+		Action: runMigrationCommand(migrateDatabaseCommand),
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Print the pending migrations and the SQL they execute without applying them",
+			},
+			&cli.StringFlag{
+				Name:  "db-type",
+				Usage: "Render the SQL for another database type (mysql, postgres or sqlite3) with --dry-run",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the pending migrations as JSON with --dry-run",
+			},
+		},
+	},
+}
+
 var Commands = []*cli.Command{
 	{
 		Name:        "plugins",
@@ -228,4 +542,9 @@ var Commands = []*cli.Command{
 		Usage:       "Grafana admin commands",
 		Subcommands: adminCommands,
 	},
+	{
+		Name:        "db",
+		Usage:       "Grafana database commands",
+		Subcommands: dbCommands,
+	},
 }
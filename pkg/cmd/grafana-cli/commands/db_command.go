@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+func migrateDatabaseCommand(c utils.CommandLine, sqlStore *sqlstore.SQLStore) error {
+	if c.Bool("dry-run") {
+		return planMigrations(sqlStore, c.String("db-type"), c.Bool("json"), os.Stdout)
+	}
+
+	if err := sqlStore.Migrate(sqlStore.Cfg.IsFeatureToggleEnabled(featuremgmt.FlagMigrationLocking)); err != nil {
+		return fmt.Errorf("failed to migrate the database: %w", err)
+	}
+	logger.Info("Database migrated\n")
+	return nil
+}
+
+func planMigrations(sqlStore *sqlstore.SQLStore, dbType string, asJSON bool, w io.Writer) error {
+	plan, err := sqlStore.PlanMigrations(dbType)
+	if err != nil {
+		return fmt.Errorf("failed to plan the database migrations: %w", err)
+	}
+	if asJSON {
+		return writeJSON(w, plan)
+	}
+
+	if len(plan) == 0 {
+		fmt.Fprintln(w, "No pending migrations")
+		return nil
+	}
+
+	fmt.Fprintf(w, "%d pending migrations:\n", len(plan))
+	for i, m := range plan {
+		fmt.Fprintf(w, "\n%d. %s\n", i+1, m.ID)
+		switch {
+		case m.Skipped:
+			fmt.Fprintln(w, "   skipped: already applied but missing from the migration log")
+		case m.CodeMigration:
+			fmt.Fprintln(w, "   code migration, the statements depend on the data")
+		default:
+			for _, line := range strings.Split(strings.TrimSpace(m.SQL), "\n") {
+				fmt.Fprintf(w, "   %s\n", line)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+)
+
+func TestIntegrationMigrateDatabaseDryRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	db := sqlstore.InitTestDB(t)
+
+	t.Run("prints that no migration is pending", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, planMigrations(db, "", false, &buf))
+		assert.Equal(t, "No pending migrations\n", buf.String())
+	})
+
+	t.Run("prints the pending migrations with the SQL of the dialect", func(t *testing.T) {
+		const migrationID = "Add expires to api_key table"
+		ctx := context.Background()
+		var record migrator.MigrationLog
+		err := db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+			has, err := sess.Where("migration_id = ?", migrationID).Get(&record)
+			require.True(t, has)
+			if err != nil {
+				return err
+			}
+			_, err = sess.ID(record.Id).Delete(&migrator.MigrationLog{})
+			return err
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			record.Id = 0
+			require.NoError(t, db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+				_, err := sess.Insert(&record)
+				return err
+			}))
+		})
+
+		var buf bytes.Buffer
+		require.NoError(t, planMigrations(db, "postgres", true, &buf))
+		var plan []migrator.PlannedMigration
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &plan))
+		require.Len(t, plan, 1)
+		assert.Equal(t, migrationID, plan[0].ID)
+		assert.Contains(t, plan[0].SQL, `alter table "api_key" ADD COLUMN "expires" BIGINT NULL`)
+
+		buf.Reset()
+		require.NoError(t, planMigrations(db, "", false, &buf))
+		assert.Contains(t, buf.String(), "1 pending migrations:\n\n1. "+migrationID)
+
+		buf.Reset()
+		require.Error(t, planMigrations(db, "oracle", false, &buf))
+	})
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/runner"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+)
+
+func listKVStoreNamespacesCommand(c utils.CommandLine, runner runner.Runner) error {
+	return listKVStoreNamespaces(context.Background(), runner.KVStore, c.Bool("json"), os.Stdout)
+}
+
+func listKVStoreNamespaces(ctx context.Context, kv kvstore.KVStore, asJSON bool, w io.Writer) error {
+	namespaces, err := kvstore.ListNamespaces(ctx, kv)
+	if err != nil {
+		return fmt.Errorf("failed to list kvstore namespaces: %w", err)
+	}
+	if asJSON {
+		return writeJSON(w, namespaces)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tBACKEND\tSENSITIVE\tKEYS\tBYTES")
+	for _, ns := range namespaces {
+		bytes := "-"
+		if ns.Backend == kvstore.BackendSQL {
+			bytes = fmt.Sprint(ns.Bytes)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%d\t%s\n", ns.Namespace, ns.Backend, ns.Sensitive, ns.Keys, bytes)
+	}
+	return tw.Flush()
+}
+
+func dumpKVStoreNamespaceCommand(c utils.CommandLine, runner runner.Runner) error {
+	namespace := c.Args().First()
+	if namespace == "" {
+		return fmt.Errorf("missing kvstore namespace")
+	}
+	orgID := int64(c.Int("org-id"))
+	if orgID == 0 {
+		orgID = kvstore.AllOrganizations
+	}
+	return dumpKVStoreNamespace(context.Background(), runner.KVStore, orgID, namespace, c.String("prefix"), c.Bool("show-values"), c.Bool("json"), os.Stdout)
+}
+
+func dumpKVStoreNamespace(ctx context.Context, kv kvstore.KVStore, orgID int64, namespace string, keyPrefix string, showValues bool, asJSON bool, w io.Writer) error {
+	items, err := kvstore.DumpNamespace(ctx, kv, orgID, namespace, keyPrefix, showValues)
+	if err != nil {
+		return fmt.Errorf("failed to dump kvstore namespace: %w", err)
+	}
+	if asJSON {
+		return writeJSON(w, items)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ORG\tKEY\tVALUE")
+	for _, item := range items {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", item.OrgId, item.Key, item.Value)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+func TestIntegrationKVStoreCommands(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	db := sqlstore.InitTestDB(t)
+	kv := kvstore.ProvideService(db)
+	ctx := context.Background()
+
+	require.NoError(t, kv.Set(ctx, 1, "plugin.settings", "fatal", "true"))
+	require.NoError(t, kv.Set(ctx, 2, "plugin.settings", "fatal", "false"))
+	require.NoError(t, kv.Set(ctx, 1, "plugin.settings", "other", "value"))
+
+	t.Run("namespaces lists the namespaces with their keys", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, listKVStoreNamespaces(ctx, kv, true, &buf))
+
+		var namespaces []kvstore.NamespaceInfo
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &namespaces))
+		require.Len(t, namespaces, 1)
+		assert.Equal(t, "plugin.settings", namespaces[0].Namespace)
+		assert.Equal(t, kvstore.BackendSQL, namespaces[0].Backend)
+		assert.Equal(t, int64(3), namespaces[0].Keys)
+	})
+
+	t.Run("dump redacts the values", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, dumpKVStoreNamespace(ctx, kv, kvstore.AllOrganizations, "plugin.settings", "fatal", false, true, &buf))
+
+		var items []kvstore.DumpedItem
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &items))
+		assert.Equal(t, []kvstore.DumpedItem{
+			{OrgId: 1, Key: "fatal", Value: kvstore.RedactedValue},
+			{OrgId: 2, Key: "fatal", Value: kvstore.RedactedValue},
+		}, items)
+	})
+
+	t.Run("dump shows the values when requested", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, dumpKVStoreNamespace(ctx, kv, 1, "plugin.settings", "", true, true, &buf))
+
+		var items []kvstore.DumpedItem
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &items))
+		assert.Equal(t, []kvstore.DumpedItem{
+			{OrgId: 1, Key: "fatal", Value: "true"},
+			{OrgId: 1, Key: "other", Value: "value"},
+		}, items)
+	})
+}
@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/runner"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+	"github.com/grafana/grafana/pkg/services/secrets/kvstore"
+)
+
+var secretsPassphraseFlag = &cli.StringFlag{
+	Name:    "passphrase",
+	Usage:   "Passphrase encrypting the bundle",
+	EnvVars: []string{"GF_SECRETS_BUNDLE_PASSPHRASE"},
+}
+
+func exportSecretsCommand(c utils.CommandLine, runner runner.Runner) error {
+	path := c.Args().First()
+	if path == "" {
+		return fmt.Errorf("missing path of the bundle")
+	}
+
+	opts := kvstore.SecretsExportOptions{
+		Passphrase:  c.String("passphrase"),
+		KeyProvider: c.String("key-provider"),
+	}
+	if orgID := c.Int("org-id"); orgID != 0 {
+		opts.OrgIds = []int64{int64(orgID)}
+	}
+	bundle, err := runner.SecretsExport.Export(context.Background(), opts)
+	if err != nil {
+		return fmt.Errorf("failed to export secrets: %w", err)
+	}
+
+	if err := os.WriteFile(path, bundle, 0600); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	logger.Infof("Secrets exported to %s %s\n", path, color.GreenString("✔"))
+	return nil
+}
+
+func importSecretsCommand(c utils.CommandLine, runner runner.Runner) error {
+	path := c.Args().First()
+	if path == "" {
+		return fmt.Errorf("missing path of the bundle")
+	}
+
+	orgMapping, err := parseOrgMapping(c.String("org-mapping"))
+	if err != nil {
+		return err
+	}
+	// nolint:gosec
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	result, err := runner.SecretsExport.Import(context.Background(), bundle, kvstore.SecretsImportOptions{
+		Passphrase: c.String("passphrase"),
+		OrgMapping: orgMapping,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import secrets: %w", err)
+	}
+
+	if c.Bool("json") {
+		return writeJSON(os.Stdout, result)
+	}
+	logger.Infof("%d secrets imported, %d expired skipped %s\n", result.Imported, result.Expired, color.GreenString("✔"))
+	return nil
+}
+
+func checkSecretsConsistencyCommand(c utils.CommandLine, runner runner.Runner) error {
+	report, err := runner.SecretsConsistency.Check(context.Background(), c.Bool("prune"))
+	if err != nil {
+		return fmt.Errorf("failed to check the consistency of the secrets: %w", err)
+	}
+
+	if c.Bool("json") {
+		return writeJSON(os.Stdout, report)
+	}
+	for _, secret := range report.Orphaned {
+		logger.Infof("orphaned: org %d, %s %q\n", secret.OrgId, secret.Type, secret.Namespace)
+	}
+	for _, secret := range report.Missing {
+		if secret.LegacySecrets {
+			logger.Infof("missing: org %d, %s %q (legacy secure json data)\n", secret.OrgId, secret.Type, secret.Namespace)
+			continue
+		}
+		logger.Infof("missing: org %d, %s %q\n", secret.OrgId, secret.Type, secret.Namespace)
+	}
+	logger.Infof("%d orphaned secrets, %d missing secrets, %d pruned\n", len(report.Orphaned), len(report.Missing), report.Pruned)
+	return nil
+}
+
+// parseOrgMapping parses a list of source:target organization IDs, e.g. 1:2,3:4
+func parseOrgMapping(mapping string) (map[int64]int64, error) {
+	orgMapping := map[int64]int64{}
+	if mapping == "" {
+		return orgMapping, nil
+	}
+	for _, pair := range strings.Split(mapping, ",") {
+		ids := strings.Split(strings.TrimSpace(pair), ":")
+		if len(ids) != 2 {
+			return nil, fmt.Errorf("invalid organization mapping %q, expected source:target", pair)
+		}
+		source, err := strconv.ParseInt(ids[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid organization mapping %q: %w", pair, err)
+		}
+		target, err := strconv.ParseInt(ids[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid organization mapping %q: %w", pair, err)
+		}
+		orgMapping[source] = target
+	}
+	return orgMapping, nil
+}
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	infrakv "github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/secrets/kvstore"
+)
+
+func TestParseOrgMapping(t *testing.T) {
+	mapping, err := parseOrgMapping("1:2, 3:4")
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]int64{1: 2, 3: 4}, mapping)
+
+	mapping, err = parseOrgMapping("")
+	require.NoError(t, err)
+	assert.Empty(t, mapping)
+
+	for _, invalid := range []string{"1", "1:2:3", "a:2", "1:b"} {
+		_, err := parseOrgMapping(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSecretsStoreCommands(t *testing.T) {
+	ctx := context.Background()
+	store := kvstore.NewFakeSecretsKVStore()
+	require.NoError(t, store.Set(ctx, 1, "ds1", "datasource", "secret 1"))
+	require.NoError(t, store.Set(ctx, 2, "ds2", "datasource", "secret 2"))
+
+	t.Run("list prints the keys without the values", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, listSecrets(ctx, store, kvstore.AllOrganizations, "ds*", "", true, &buf))
+
+		var references []kvstore.SecretReference
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &references))
+		assert.Equal(t, []kvstore.SecretReference{
+			{OrgId: 1, Namespace: "ds1", Type: "datasource"},
+			{OrgId: 2, Namespace: "ds2", Type: "datasource"},
+		}, references)
+		assert.NotContains(t, buf.String(), "secret 1")
+	})
+
+	t.Run("get redacts the value", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, getSecret(ctx, store, 1, "ds1", "datasource", false, false, &buf))
+		assert.Equal(t, infrakv.RedactedValue+"\n", buf.String())
+	})
+
+	t.Run("get shows the value when requested", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, getSecret(ctx, store, 1, "ds1", "datasource", true, true, &buf))
+
+		var secret SecretValue
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &secret))
+		assert.Equal(t, SecretValue{OrgId: 1, Namespace: "ds1", Type: "datasource", Found: true, Value: "secret 1"}, secret)
+	})
+
+	t.Run("get fails when the secret does not exist", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.Error(t, getSecret(ctx, store, 1, "missing", "datasource", true, false, &buf))
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("verify lists the secrets that can't be read", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, verifySecrets(ctx, store, kvstore.AllOrganizations, false, &buf))
+		assert.Contains(t, buf.String(), "2 secrets verified, 0 failed")
+
+		buf.Reset()
+		err := verifySecrets(ctx, failingGetSecretsKVStore{store}, kvstore.AllOrganizations, true, &buf)
+		require.Error(t, err)
+		var verification SecretsVerification
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &verification))
+		assert.Equal(t, 0, verification.Verified)
+		require.Len(t, verification.Failed, 2)
+		assert.Equal(t, "failed to decrypt", verification.Failed[0].Error)
+	})
+}
+
+func TestReadSecretValue(t *testing.T) {
+	value, err := readSecretValue(strings.NewReader("s3cr3t\nignored\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "s3cr3t", value)
+
+	_, err = readSecretValue(strings.NewReader(""))
+	assert.Error(t, err)
+	_, err = readSecretValue(strings.NewReader("\n"))
+	assert.Error(t, err)
+}
+
+type failingGetSecretsKVStore struct {
+	kvstore.FakeSecretsKVStore
+}
+
+func (f failingGetSecretsKVStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	return "", false, errors.New("failed to decrypt")
+}
@@ -0,0 +1,257 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/runner"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+	infrakv "github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/secrets/kvstore"
+)
+
+var secretOrgIDFlag = &cli.IntFlag{
+	Name:  "org-id",
+	Usage: "ID of the organization owning the secret",
+	Value: 1,
+}
+
+// SecretValue is a secret read by the secrets commands, its value is redacted unless it is explicitly shown
+type SecretValue struct {
+	OrgId     int64  `json:"orgId"`
+	Namespace string `json:"namespace"`
+	Type      string `json:"type"`
+	Found     bool   `json:"found"`
+	Value     string `json:"value,omitempty"`
+}
+
+// SecretsVerification lists the secrets that could not be read back from the secrets store
+type SecretsVerification struct {
+	Verified int                  `json:"verified"`
+	Failed   []SecretVerification `json:"failed"`
+}
+
+type SecretVerification struct {
+	kvstore.SecretReference
+	Error string `json:"error"`
+}
+
+// secretKeyArgs returns the namespace and type given as arguments of the command
+func secretKeyArgs(c utils.CommandLine) (string, string, error) {
+	namespace, typ := c.Args().Get(0), c.Args().Get(1)
+	if namespace == "" || typ == "" {
+		return "", "", fmt.Errorf("missing namespace and type of the secret")
+	}
+	return namespace, typ, nil
+}
+
+func listSecretsCommand(c utils.CommandLine, runner runner.Runner) error {
+	orgID := int64(c.Int("org-id"))
+	if orgID == 0 {
+		orgID = kvstore.AllOrganizations
+	}
+	return listSecrets(context.Background(), runner.SecretsStore, orgID, c.String("namespace"), c.String("type"), c.Bool("json"), os.Stdout)
+}
+
+func listSecrets(ctx context.Context, store kvstore.SecretsKVStore, orgID int64, namespacePattern string, typ string, asJSON bool, w io.Writer) error {
+	keys, err := store.ListKeys(ctx, orgID, namespacePattern, typ)
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+	sortSecretKeys(keys)
+	if asJSON {
+		references := make([]kvstore.SecretReference, 0, len(keys))
+		for _, key := range keys {
+			references = append(references, kvstore.SecretReference{OrgId: key.OrgId, Namespace: key.Namespace, Type: key.Type})
+		}
+		return writeJSON(w, references)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ORG\tNAMESPACE\tTYPE")
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", key.OrgId, key.Namespace, key.Type)
+	}
+	return tw.Flush()
+}
+
+func getSecretCommand(c utils.CommandLine, runner runner.Runner) error {
+	namespace, typ, err := secretKeyArgs(c)
+	if err != nil {
+		return err
+	}
+	return getSecret(context.Background(), runner.SecretsStore, int64(c.Int("org-id")), namespace, typ, c.Bool("show-value"), c.Bool("json"), os.Stdout)
+}
+
+// getSecret prints whether the secret exists, its value is only printed when showValue is set
+func getSecret(ctx context.Context, store kvstore.SecretsKVStore, orgID int64, namespace string, typ string, showValue bool, asJSON bool, w io.Writer) error {
+	value, found, err := store.Get(ctx, orgID, namespace, typ)
+	if err != nil {
+		return fmt.Errorf("failed to get secret: %w", err)
+	}
+	secret := SecretValue{OrgId: orgID, Namespace: namespace, Type: typ, Found: found}
+	if found {
+		secret.Value = infrakv.RedactedValue
+		if showValue {
+			secret.Value = value
+		}
+	}
+	if asJSON {
+		return writeJSON(w, secret)
+	}
+	if !found {
+		return fmt.Errorf("secret not found")
+	}
+	_, err = fmt.Fprintln(w, secret.Value)
+	return err
+}
+
+func setSecretCommand(c utils.CommandLine, runner runner.Runner) error {
+	namespace, typ, err := secretKeyArgs(c)
+	if err != nil {
+		return err
+	}
+	// the value is never taken from the arguments, which end up in the shell history
+	logger.Infof("Secret value: ")
+	value, err := readSecretValue(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if err := runner.SecretsStore.Set(context.Background(), int64(c.Int("org-id")), namespace, typ, value); err != nil {
+		return fmt.Errorf("failed to set secret: %w", err)
+	}
+	logger.Infof("\nSecret set %s\n", color.GreenString("✔"))
+	return nil
+}
+
+// readSecretValue reads the first line of r
+func readSecretValue(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	if ok := scanner.Scan(); !ok {
+		if err := scanner.Err(); err != nil {
+			return "", fmt.Errorf("can't read the secret value from stdin: %w", err)
+		}
+		return "", fmt.Errorf("can't read the secret value from stdin")
+	}
+	if scanner.Text() == "" {
+		return "", fmt.Errorf("empty secret value, delete the secret instead")
+	}
+	return scanner.Text(), nil
+}
+
+func deleteSecretCommand(c utils.CommandLine, runner runner.Runner) error {
+	namespace, typ, err := secretKeyArgs(c)
+	if err != nil {
+		return err
+	}
+	if err := runner.SecretsStore.Del(context.Background(), int64(c.Int("org-id")), namespace, typ); err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+	logger.Infof("Secret deleted %s\n", color.GreenString("✔"))
+	return nil
+}
+
+func migrateSecretsCommand(c utils.CommandLine, runner runner.Runner) error {
+	ctx := context.Background()
+	if namespace := c.String("namespace"); namespace != "" {
+		result, err := runner.SecretsRouting.Move(ctx, namespace, c.String("backend"))
+		if err != nil {
+			return fmt.Errorf("failed to move the secrets of the namespace: %w", err)
+		}
+		if c.Bool("json") {
+			return writeJSON(os.Stdout, result)
+		}
+		logger.Infof("%d secrets of %q moved to %s %s\n", result.Moved, result.Namespace, result.Backend, color.GreenString("✔"))
+		return nil
+	}
+
+	err := runner.SecretsMigration.Migrate(ctx)
+	status := runner.SecretsMigration.Status()
+	if c.Bool("json") {
+		if jsonErr := writeJSON(os.Stdout, status); jsonErr != nil {
+			return jsonErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate secrets: %w", err)
+	}
+	if c.Bool("json") {
+		return nil
+	}
+	if status.Phase == kvstore.PluginMigrationNotStarted {
+		logger.Info("Nothing to migrate, the secrets manager plugin is not used or the secrets are mirrored\n")
+		return nil
+	}
+	logger.Infof("%d secrets migrated, %d failed %s\n", status.Migrated, status.Failed, color.GreenString("✔"))
+	return nil
+}
+
+func verifySecretsCommand(c utils.CommandLine, runner runner.Runner) error {
+	orgID := int64(c.Int("org-id"))
+	if orgID == 0 {
+		orgID = kvstore.AllOrganizations
+	}
+	return verifySecrets(context.Background(), runner.SecretsStore, orgID, c.Bool("json"), os.Stdout)
+}
+
+// verifySecrets reads every secret of the store, without printing their values, and fails when some can't be read,
+// e.g. when they can't be decrypted anymore
+func verifySecrets(ctx context.Context, store kvstore.SecretsKVStore, orgID int64, asJSON bool, w io.Writer) error {
+	keys, err := store.ListKeys(ctx, orgID, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+	sortSecretKeys(keys)
+	verification := SecretsVerification{Failed: []SecretVerification{}}
+	for _, key := range keys {
+		_, found, err := store.Get(ctx, key.OrgId, key.Namespace, key.Type)
+		if err == nil && !found {
+			// deleted or expired since the keys were listed
+			continue
+		}
+		if err != nil {
+			verification.Failed = append(verification.Failed, SecretVerification{
+				SecretReference: kvstore.SecretReference{OrgId: key.OrgId, Namespace: key.Namespace, Type: key.Type},
+				Error:           err.Error(),
+			})
+			continue
+		}
+		verification.Verified++
+	}
+
+	if asJSON {
+		if err := writeJSON(w, verification); err != nil {
+			return err
+		}
+	} else {
+		for _, failed := range verification.Failed {
+			fmt.Fprintf(w, "failed: org %d, %s %q: %s\n", failed.OrgId, failed.Type, failed.Namespace, failed.Error)
+		}
+		fmt.Fprintf(w, "%d secrets verified, %d failed\n", verification.Verified, len(verification.Failed))
+	}
+	if len(verification.Failed) > 0 {
+		return fmt.Errorf("%d secrets could not be read", len(verification.Failed))
+	}
+	return nil
+}
+
+func sortSecretKeys(keys []kvstore.Key) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].OrgId != keys[j].OrgId {
+			return keys[i].OrgId < keys[j].OrgId
+		}
+		if keys[i].Namespace != keys[j].Namespace {
+			return keys[i].Namespace < keys[j].Namespace
+		}
+		return keys[i].Type < keys[j].Type
+	})
+}
@@ -1,39 +1,68 @@
 package runner
 
 import (
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/apikey/apikeyimpl"
 	"github.com/grafana/grafana/pkg/services/encryption"
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
 	"github.com/grafana/grafana/pkg/services/secrets"
+	secretsKV "github.com/grafana/grafana/pkg/services/secrets/kvstore"
 	"github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/grafana/grafana/pkg/services/user"
 	"github.com/grafana/grafana/pkg/setting"
 )
 
 type Runner struct {
-	Cfg               *setting.Cfg
-	SQLStore          *sqlstore.SQLStore
-	SettingsProvider  setting.Provider
-	Features          featuremgmt.FeatureToggles
-	EncryptionService encryption.Internal
-	SecretsService    *manager.SecretsService
-	SecretsMigrator   secrets.Migrator
-	UserService       user.Service
+	Cfg                *setting.Cfg
+	SQLStore           *sqlstore.SQLStore
+	SettingsProvider   setting.Provider
+	Features           featuremgmt.FeatureToggles
+	EncryptionService  encryption.Internal
+	SecretsService     *manager.SecretsService
+	SecretsMigrator    secrets.Migrator
+	UserService        user.Service
+	APIKeyService      apikey.Service
+	LifetimeEnforcer   *apikeyimpl.LifetimeEnforcer
+	ServiceAccounts    serviceaccounts.Store
+	SecretsExport      *secretsKV.SecretsExportService
+	SecretsConsistency *secretsKV.SecretsConsistencyService
+	KVStore            kvstore.KVStore
+	SecretsStore       secretsKV.SecretsKVStore
+	SecretsMigration   *secretsKV.PluginSecretMigrationService
+	SecretsRouting     *secretsKV.SecretsRoutingService
+	KVEncryption       *kvstore.Encryption
 }
 
 func New(cfg *setting.Cfg, sqlStore *sqlstore.SQLStore, settingsProvider setting.Provider,
 	encryptionService encryption.Internal, features featuremgmt.FeatureToggles,
 	secretsService *manager.SecretsService, secretsMigrator secrets.Migrator,
-	userService user.Service,
+	userService user.Service, apiKeyService apikey.Service, lifetimeEnforcer *apikeyimpl.LifetimeEnforcer,
+	serviceAccountsStore serviceaccounts.Store, secretsExport *secretsKV.SecretsExportService,
+	secretsConsistency *secretsKV.SecretsConsistencyService, kvStore kvstore.KVStore,
+	secretsStore secretsKV.SecretsKVStore, secretsMigration *secretsKV.PluginSecretMigrationService,
+	secretsRouting *secretsKV.SecretsRoutingService, kvEncryption *kvstore.Encryption,
 ) Runner {
 	return Runner{
-		Cfg:               cfg,
-		SQLStore:          sqlStore,
-		SettingsProvider:  settingsProvider,
-		EncryptionService: encryptionService,
-		SecretsService:    secretsService,
-		SecretsMigrator:   secretsMigrator,
-		Features:          features,
-		UserService:       userService,
+		Cfg:                cfg,
+		SQLStore:           sqlStore,
+		SettingsProvider:   settingsProvider,
+		EncryptionService:  encryptionService,
+		SecretsService:     secretsService,
+		SecretsMigrator:    secretsMigrator,
+		Features:           features,
+		UserService:        userService,
+		APIKeyService:      apiKeyService,
+		LifetimeEnforcer:   lifetimeEnforcer,
+		ServiceAccounts:    serviceAccountsStore,
+		SecretsExport:      secretsExport,
+		SecretsConsistency: secretsConsistency,
+		KVStore:            kvStore,
+		SecretsStore:       secretsStore,
+		SecretsMigration:   secretsMigration,
+		SecretsRouting:     secretsRouting,
+		KVEncryption:       kvEncryption,
 	}
 }
@@ -6,6 +6,7 @@ package runner
 import (
 	"context"
 
+	"github.com/benbjohnson/clock"
 	"github.com/google/wire"
 
 	sdkhttpclient "github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
@@ -38,8 +39,11 @@ import (
 	"github.com/grafana/grafana/pkg/plugins/manager/registry"
 	"github.com/grafana/grafana/pkg/plugins/plugincontext"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/accesscontrol/decisionlog"
 	"github.com/grafana/grafana/pkg/services/accesscontrol/ossaccesscontrol"
 	"github.com/grafana/grafana/pkg/services/alerting"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/apikey/apikeyimpl"
 	"github.com/grafana/grafana/pkg/services/auth"
 	"github.com/grafana/grafana/pkg/services/auth/jwt"
 	"github.com/grafana/grafana/pkg/services/cleanup"
@@ -139,6 +143,7 @@ import (
 
 var wireSet = wire.NewSet(
 	New,
+	clock.New,
 	localcache.ProvideService,
 	tracing.ProvideService,
 	bus.ProvideBus,
@@ -165,7 +170,8 @@ var wireSet = wire.NewSet(
 	thumbs.ProvideService,
 	rendering.ProvideService,
 	wire.Bind(new(rendering.Service), new(*rendering.RenderingService)),
-	kvstore.ProvideService,
+	kvstore.ProvideStore,
+	kvstore.ProvideEncryption,
 	updatechecker.ProvideGrafanaService,
 	updatechecker.ProvidePluginsService,
 	uss.ProvideService,
@@ -256,6 +262,7 @@ var wireSet = wire.NewSet(
 	ossaccesscontrol.ProvideServiceAccountPermissions,
 	wire.Bind(new(accesscontrol.ServiceAccountPermissionsService), new(*ossaccesscontrol.ServiceAccountPermissionsService)),
 	serviceaccountsmanager.ProvideServiceAccountsService,
+	serviceaccountsmanager.ProvideTokenRotationService,
 	wire.Bind(new(serviceaccounts.Service), new(*serviceaccountsmanager.ServiceAccountsService)),
 	expr.ProvideService,
 	teamguardianDatabase.ProvideTeamGuardianStore,
@@ -293,6 +300,7 @@ var wireSet = wire.NewSet(
 	wire.Bind(new(accesscontrol.FolderPermissionsService), new(*ossaccesscontrol.FolderPermissionsService)),
 	ossaccesscontrol.ProvideDashboardPermissions,
 	wire.Bind(new(accesscontrol.DashboardPermissionsService), new(*ossaccesscontrol.DashboardPermissionsService)),
+	decisionlog.ProvideService,
 	starimpl.ProvideService,
 	playlistimpl.ProvideService,
 	dashverimpl.ProvideService,
@@ -302,9 +310,22 @@ var wireSet = wire.NewSet(
 	wire.Bind(new(publicdashboards.Store), new(*publicdashboardsStore.PublicDashboardStoreImpl)),
 	publicdashboardsApi.ProvideApi,
 	userimpl.ProvideService,
+	apikeyimpl.ProvideService,
+	wire.Bind(new(apikey.Service), new(*apikeyimpl.Service)),
+	apikeyimpl.ProvideLifetimeEnforcer,
 	orgimpl.ProvideService,
 	datasourceservice.ProvideDataSourceMigrationService,
 	secretsStore.ProvidePluginSecretMigrationService,
+	secretsStore.ProvideAuditService,
+	secretsStore.ProvideSecretsExportService,
+	secretsStore.ProvideHealthService,
+	secretsStore.ProvideValidationService,
+	secretsStore.ProvideAccessControlService,
+	secretsStore.ProvideSharedCacheService,
+	secretsStore.ProvideSecretsConsistencyService,
+	secretsStore.ProvidePluginQuarantineService,
+	secretsStore.ProvidePluginReloadService,
+	secretsStore.ProvideSecretsRoutingService,
 	secretsMigrations.ProvideSecretMigrationService,
 	wire.Bind(new(secretsMigrations.SecretMigrationService), new(*secretsMigrations.SecretMigrationServiceImpl)),
 	userauthimpl.ProvideService,
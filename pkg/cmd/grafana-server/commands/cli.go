@@ -229,6 +229,9 @@ func listenToSystemSignals(ctx context.Context, s *server.Server) {
 			if err := log.Reload(); err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to reload loggers: %s\n", err)
 			}
+			if err := s.ReloadSecretsPlugin(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reload the secrets manager plugin: %s\n", err)
+			}
 		case sig := <-signalChan:
 			ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 			defer cancel()
@@ -2,17 +2,31 @@ package apikeygen
 
 import (
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"hash/crc32"
+	"math/big"
+	"strings"
 
 	"github.com/grafana/grafana/pkg/util"
 )
 
 var ErrInvalidApiKey = errors.New("invalid API key")
 
+// Prefix starts the keys generated by New. The keys have the
+// gfk_<payload>_<checksum> format, where the payload is the base62 encoded
+// JSON of the key and the checksum the hex encoded CRC32 of the prefix and
+// the payload, so that secret scanners can recognize them.
+const Prefix = "gfk_"
+
+const payloadBase = 62
+
 type KeyGenResult struct {
 	HashedKey    string
 	ClientSecret string
+	// Secret is the random secret of the key, hashed into HashedKey.
+	Secret string
 }
 
 type ApiKeyJson struct {
@@ -33,6 +47,7 @@ func New(orgId int64, name string) (KeyGenResult, error) {
 		return result, err
 	}
 
+	result.Secret = jsonKey.Key
 	result.HashedKey, err = util.EncodePassword(jsonKey.Key, name)
 	if err != nil {
 		return result, err
@@ -43,18 +58,67 @@ func New(orgId int64, name string) (KeyGenResult, error) {
 		return result, err
 	}
 
-	result.ClientSecret = base64.StdEncoding.EncodeToString(jsonString)
+	payload := new(big.Int).SetBytes(jsonString).Text(payloadBase)
+	result.ClientSecret = Prefix + payload + "_" + checksum(payload)
 	return result, nil
 }
 
+func checksum(payload string) string {
+	sum := crc32.ChecksumIEEE([]byte(Prefix + payload))
+	return hex.EncodeToString([]byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)})
+}
+
+// splitPrefixed returns the payload of a key in the prefixed format, and
+// false when the key does not have this format or its checksum is wrong.
+func splitPrefixed(keyString string) (string, bool) {
+	if !strings.HasPrefix(keyString, Prefix) {
+		return "", false
+	}
+	payload, sum, found := strings.Cut(strings.TrimPrefix(keyString, Prefix), "_")
+	if !found || payload == "" || strings.Contains(sum, "_") {
+		return "", false
+	}
+	for _, c := range payload {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return "", false
+		}
+	}
+	return payload, sum == checksum(payload)
+}
+
+// IsValidFormat returns true if the string has the format of the keys
+// generated by New and a valid checksum. It does not check that the key
+// exists, which lets secret scanners recognize Grafana keys offline. Keys
+// generated before the prefixed format are not recognized.
+func IsValidFormat(keyString string) bool {
+	_, ok := splitPrefixed(keyString)
+	return ok
+}
+
+// Decode decodes keys in the prefixed format as well as the base64 encoded
+// keys generated before it.
 func Decode(keyString string) (*ApiKeyJson, error) {
-	jsonString, err := base64.StdEncoding.DecodeString(keyString)
-	if err != nil {
-		return nil, ErrInvalidApiKey
+	var jsonString []byte
+	if strings.HasPrefix(keyString, Prefix) {
+		payload, ok := splitPrefixed(keyString)
+		if !ok {
+			return nil, ErrInvalidApiKey
+		}
+		n, ok := new(big.Int).SetString(payload, payloadBase)
+		if !ok {
+			return nil, ErrInvalidApiKey
+		}
+		jsonString = n.Bytes()
+	} else {
+		var err error
+		jsonString, err = base64.StdEncoding.DecodeString(keyString)
+		if err != nil {
+			return nil, ErrInvalidApiKey
+		}
 	}
 
 	var keyObj ApiKeyJson
-	err = json.Unmarshal(jsonString, &keyObj)
+	err := json.Unmarshal(jsonString, &keyObj)
 	if err != nil {
 		return nil, ErrInvalidApiKey
 	}
@@ -1,6 +1,7 @@
 package apikeygen
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -23,3 +24,42 @@ func TestApiKeyGen(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, result.HashedKey, keyHashed)
 }
+
+func TestApiKeyGenPrefixedFormat(t *testing.T) {
+	result, err := New(12, "Cool key")
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(result.ClientSecret, Prefix))
+	assert.Regexp(t, `^gfk_[0-9a-zA-Z]+_[0-9a-f]{8}$`, result.ClientSecret)
+	assert.True(t, IsValidFormat(result.ClientSecret))
+
+	keyInfo, err := Decode(result.ClientSecret)
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), keyInfo.OrgId)
+	assert.Equal(t, "Cool key", keyInfo.Name)
+
+	t.Run("a wrong checksum is rejected", func(t *testing.T) {
+		tampered := result.ClientSecret[:len(result.ClientSecret)-8] + "00000000"
+		assert.False(t, IsValidFormat(tampered))
+		_, err := Decode(tampered)
+		assert.ErrorIs(t, err, ErrInvalidApiKey)
+	})
+
+	t.Run("other strings are not recognized", func(t *testing.T) {
+		for _, s := range []string{"", "gfk_", "gfk__00000000", "gfk_abc-def_00000000", "glsa_yscW25imSKJIuav8zF37RZmnbiDvB05G_fcaaf58a", legacyKey} {
+			assert.False(t, IsValidFormat(s), s)
+		}
+	})
+}
+
+// legacyKey was generated before the prefixed format, for the key "Cool key"
+// of the organization 12.
+const legacyKey = "eyJrIjoiWHZiSWd3NzdCYUZnNUtibE9obUpESmE3bzJYNDRIc0UiLCJuIjoiQ29vbCBrZXkiLCJpZCI6MTJ9"
+
+func TestApiKeyDecodeLegacyFormat(t *testing.T) {
+	keyInfo, err := Decode(legacyKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), keyInfo.OrgId)
+	assert.Equal(t, "Cool key", keyInfo.Name)
+	assert.Equal(t, "XvbIgw77BaFg5KblOhmJDJa7o2X44HsE", keyInfo.Key)
+}
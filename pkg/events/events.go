@@ -19,6 +19,11 @@ type OrgUpdated struct {
 	Name      string    `json:"name"`
 }
 
+type OrgDeleted struct {
+	Timestamp time.Time `json:"timestamp"`
+	Id        int64     `json:"id"`
+}
+
 type UserCreated struct {
 	Timestamp time.Time `json:"timestamp"`
 	Id        int64     `json:"id"`
@@ -78,3 +83,87 @@ type FolderTitleUpdated struct {
 	UID       string    `json:"uid"`
 	OrgID     int64     `json:"org_id"`
 }
+
+// ApiKeyCreated, ApiKeyDeleted, ApiKeyRotated and ApiKeyExpired are published
+// when API keys change. ActorID is the user who made the change, 0 when it was
+// not made by a user, for example from the CLI or when a key expires.
+
+type ApiKeyCreated struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        int64     `json:"id"`
+	OrgID     int64     `json:"org_id"`
+	ActorID   int64     `json:"actor_id"`
+}
+
+type ApiKeyDeleted struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        int64     `json:"id"`
+	OrgID     int64     `json:"org_id"`
+	ActorID   int64     `json:"actor_id"`
+}
+
+type ApiKeyRotated struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        int64     `json:"id"`
+	OrgID     int64     `json:"org_id"`
+	ActorID   int64     `json:"actor_id"`
+}
+
+type ApiKeyExpired struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        int64     `json:"id"`
+	OrgID     int64     `json:"org_id"`
+	ActorID   int64     `json:"actor_id"`
+}
+
+// ApiKeyLeaked is published when a key reported as leaked is revoked. Source
+// and URL tell where the key was found, CreatedBy is the user who created the
+// key, 0 when unknown.
+type ApiKeyLeaked struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        int64     `json:"id"`
+	OrgID     int64     `json:"org_id"`
+	Name      string    `json:"name"`
+	CreatedBy int64     `json:"created_by"`
+	Source    string    `json:"source"`
+	URL       string    `json:"url"`
+}
+
+// ApiKeyUsed is published for each request authenticated with an API key when
+// api_key_usage_insights_enabled is set, for the usage insights. KeyHash
+// identifies the key without revealing its ID, RouteClass is the area of the
+// API called and Duration how long the request took.
+type ApiKeyUsed struct {
+	Timestamp  time.Time     `json:"timestamp"`
+	KeyHash    string        `json:"key_hash"`
+	OrgID      int64         `json:"org_id"`
+	RouteClass string        `json:"route_class"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// SecretExpired is published when a secret stored with a TTL expires and is
+// deleted from the secrets kvstore.
+type SecretExpired struct {
+	Timestamp time.Time `json:"timestamp"`
+	OrgID     int64     `json:"org_id"`
+	Namespace string    `json:"namespace"`
+	Type      string    `json:"type"`
+}
+
+// AccessControlPermissionsChanged is published when the managed permissions of a
+// user, a team or a basic role change. UserID is 0 when the permissions of a team
+// or of a basic role changed, since they apply to several users.
+type AccessControlPermissionsChanged struct {
+	Timestamp time.Time `json:"timestamp"`
+	OrgID     int64     `json:"org_id"`
+	UserID    int64     `json:"user_id"`
+}
+
+// TeamMemberChanged is published when a user is added to or removed from a team,
+// or when their permission on the team changes.
+type TeamMemberChanged struct {
+	Timestamp time.Time `json:"timestamp"`
+	OrgID     int64     `json:"org_id"`
+	TeamID    int64     `json:"team_id"`
+	UserID    int64     `json:"user_id"`
+}
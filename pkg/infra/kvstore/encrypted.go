@@ -0,0 +1,258 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// encryptedValuePrefix marks the encrypted values, so that the values set before a namespace was marked as
+// sensitive can still be read
+const encryptedValuePrefix = "$encrypted$"
+
+// ErrEncryptionNotConfigured is returned when a sensitive namespace is used before the secrets service is set
+var ErrEncryptionNotConfigured = errors.New("kvstore encryption of sensitive namespaces is not configured")
+
+func sensitiveNamespaces(cfg *setting.Cfg) map[string]bool {
+	namespaces := map[string]bool{}
+	for _, namespace := range cfg.SectionWithEnvOverrides("kvstore").Key("sensitive_namespaces").Strings(",") {
+		if namespace != "" {
+			namespaces[namespace] = true
+		}
+	}
+	return namespaces
+}
+
+// EnableEncryption sets the secrets service that encrypts the values of the namespaces listed in
+// `kvstore.sensitive_namespaces`. It is set once the secrets service is created, as the secrets service depends on the
+// kvstore itself.
+func EnableEncryption(kv KVStore, secretsService secrets.Service) {
+	if encrypted, ok := kv.(*encryptedKVStore); ok {
+		encrypted.setSecretsService(secretsService)
+	}
+}
+
+// Encryption is provided once the encryption of the sensitive namespaces is enabled. The server and the CLI depend on
+// it, so that the encryption is enabled in every wire graph building the kvstore and the secrets service.
+type Encryption struct{}
+
+// ProvideEncryption enables the encryption of the sensitive namespaces of the kvstore with the secrets service.
+func ProvideEncryption(kv KVStore, secretsService secrets.Service) *Encryption {
+	EnableEncryption(kv, secretsService)
+	return &Encryption{}
+}
+
+// encryptedKVStore encrypts the values of the sensitive namespaces with the secrets service, the keys are not
+// encrypted so that they can still be listed
+type encryptedKVStore struct {
+	store      KVStore
+	namespaces map[string]bool
+	log        log.Logger
+
+	mu             sync.RWMutex
+	secretsService secrets.Service
+}
+
+func (kv *encryptedKVStore) setSecretsService(secretsService secrets.Service) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.secretsService = secretsService
+}
+
+func (kv *encryptedKVStore) getSecretsService() (secrets.Service, error) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	if kv.secretsService == nil {
+		return nil, ErrEncryptionNotConfigured
+	}
+	return kv.secretsService, nil
+}
+
+func (kv *encryptedKVStore) encrypt(ctx context.Context, value string) (string, error) {
+	secretsService, err := kv.getSecretsService()
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := secretsService.Encrypt(ctx, []byte(value), secrets.WithoutScope())
+	if err != nil {
+		return "", err
+	}
+	return encryptedValuePrefix + base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+func (kv *encryptedKVStore) decrypt(ctx context.Context, value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedValuePrefix) {
+		// set before the namespace was marked as sensitive, it is encrypted by its next write
+		return value, nil
+	}
+	secretsService, err := kv.getSecretsService()
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedValuePrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode encrypted kvstore value: %w", err)
+	}
+	decrypted, err := secretsService.Decrypt(ctx, encrypted)
+	if err != nil {
+		return "", err
+	}
+	return string(decrypted), nil
+}
+
+func (kv *encryptedKVStore) Get(ctx context.Context, orgId int64, namespace string, key string) (string, bool, error) {
+	value, exists, err := kv.store.Get(ctx, orgId, namespace, key)
+	if err != nil || !exists || !kv.namespaces[namespace] {
+		return value, exists, err
+	}
+	decrypted, err := kv.decrypt(ctx, value)
+	if err != nil {
+		return "", false, err
+	}
+	return decrypted, true, nil
+}
+
+func (kv *encryptedKVStore) Set(ctx context.Context, orgId int64, namespace string, key string, value string) error {
+	if kv.namespaces[namespace] {
+		encrypted, err := kv.encrypt(ctx, value)
+		if err != nil {
+			return err
+		}
+		value = encrypted
+	}
+	return kv.store.Set(ctx, orgId, namespace, key, value)
+}
+
+func (kv *encryptedKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, key string, value string, ttl time.Duration) error {
+	if kv.namespaces[namespace] {
+		encrypted, err := kv.encrypt(ctx, value)
+		if err != nil {
+			return err
+		}
+		value = encrypted
+	}
+	return kv.store.SetWithTTL(ctx, orgId, namespace, key, value, ttl)
+}
+
+func (kv *encryptedKVStore) MGet(ctx context.Context, orgId int64, namespace string, keys []string) (map[string]string, error) {
+	values, err := kv.store.MGet(ctx, orgId, namespace, keys)
+	if err != nil || !kv.namespaces[namespace] {
+		return values, err
+	}
+	for key, value := range values {
+		decrypted, err := kv.decrypt(ctx, value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = decrypted
+	}
+	return values, nil
+}
+
+func (kv *encryptedKVStore) MSet(ctx context.Context, orgId int64, namespace string, values map[string]string) error {
+	if kv.namespaces[namespace] {
+		encrypted := make(map[string]string, len(values))
+		for key, value := range values {
+			var err error
+			if encrypted[key], err = kv.encrypt(ctx, value); err != nil {
+				return err
+			}
+		}
+		values = encrypted
+	}
+	return kv.store.MSet(ctx, orgId, namespace, values)
+}
+
+func (kv *encryptedKVStore) Del(ctx context.Context, orgId int64, namespace string, key string) error {
+	return kv.store.Del(ctx, orgId, namespace, key)
+}
+
+// CAS compares the decrypted value of a sensitive item, as the same value is encrypted differently by each write
+func (kv *encryptedKVStore) CAS(ctx context.Context, orgId int64, namespace string, key string, expected string, value string) (bool, error) {
+	if !kv.namespaces[namespace] {
+		return kv.store.CAS(ctx, orgId, namespace, key, expected, value)
+	}
+	encrypted, err := kv.encrypt(ctx, value)
+	if err != nil {
+		return false, err
+	}
+	if expected == "" {
+		return kv.store.CAS(ctx, orgId, namespace, key, "", encrypted)
+	}
+	current, exists, err := kv.store.Get(ctx, orgId, namespace, key)
+	if err != nil || !exists {
+		return false, err
+	}
+	decrypted, err := kv.decrypt(ctx, current)
+	if err != nil {
+		return false, err
+	}
+	if decrypted != expected {
+		return false, nil
+	}
+	if expected == value {
+		return true, nil
+	}
+	return kv.store.CAS(ctx, orgId, namespace, key, current, encrypted)
+}
+
+func (kv *encryptedKVStore) Keys(ctx context.Context, orgId int64, namespace string, keyPrefix string) ([]Key, error) {
+	return kv.store.Keys(ctx, orgId, namespace, keyPrefix)
+}
+
+func (kv *encryptedKVStore) KeysPage(ctx context.Context, orgId int64, namespace string, keyPrefix string, limit int, continueToken string) ([]Key, string, error) {
+	return kv.store.KeysPage(ctx, orgId, namespace, keyPrefix, limit, continueToken)
+}
+
+func (kv *encryptedKVStore) GetAll(ctx context.Context, orgId int64, namespace string) (map[int64]map[string]string, error) {
+	items, err := kv.store.GetAll(ctx, orgId, namespace)
+	if err != nil || !kv.namespaces[namespace] {
+		return items, err
+	}
+	for _, values := range items {
+		for key, value := range values {
+			decrypted, err := kv.decrypt(ctx, value)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = decrypted
+		}
+	}
+	return items, nil
+}
+
+// Watch streams the decrypted values of the sensitive items, the events whose value can't be decrypted are dropped
+func (kv *encryptedKVStore) Watch(ctx context.Context, namespace string, keyPrefix string) (<-chan Event, error) {
+	events, err := kv.store.Watch(ctx, namespace, keyPrefix)
+	if err != nil || !kv.namespaces[namespace] {
+		return events, err
+	}
+	decrypted := make(chan Event)
+	go func() {
+		defer close(decrypted)
+		for event := range events {
+			if event.Type == EventSet {
+				value, err := kv.decrypt(ctx, event.Value)
+				if err != nil {
+					kv.log.Warn("failed to decrypt watched kvstore value", "namespace", namespace, "key", event.Key, "error", err)
+					continue
+				}
+				event.Value = value
+			}
+			select {
+			case decrypted <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return decrypted, nil
+}
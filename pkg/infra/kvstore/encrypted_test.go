@@ -0,0 +1,90 @@
+package kvstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+)
+
+func TestIntegrationKVStoreSensitiveNamespaces(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	sqlKV := createTestableKVStore(t)
+	kv := &encryptedKVStore{
+		store:      sqlKV,
+		namespaces: map[string]bool{"sensitive": true},
+		log:        log.New("infra.kvstore.encrypted"),
+	}
+
+	ctx := context.Background()
+
+	t.Run("fails until the secrets service is set", func(t *testing.T) {
+		err := kv.Set(ctx, 1, "sensitive", "token", "secret")
+		assert.ErrorIs(t, err, ErrEncryptionNotConfigured)
+		require.NoError(t, kv.Set(ctx, 1, "plain", "token", "secret"))
+	})
+
+	EnableEncryption(kv, fakes.NewFakeSecretsService())
+
+	t.Run("encrypts the values of the sensitive namespaces", func(t *testing.T) {
+		require.NoError(t, kv.Set(ctx, 1, "sensitive", "token", "secret"))
+
+		stored, _, err := sqlKV.Get(ctx, 1, "sensitive", "token")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(stored, encryptedValuePrefix))
+		assert.NotContains(t, stored, "secret")
+
+		value, ok, err := kv.Get(ctx, 1, "sensitive", "token")
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "secret", value)
+
+		plain, _, err := sqlKV.Get(ctx, 1, "plain", "token")
+		require.NoError(t, err)
+		assert.Equal(t, "secret", plain)
+	})
+
+	t.Run("reads the values set before the namespace was sensitive", func(t *testing.T) {
+		require.NoError(t, sqlKV.Set(ctx, 1, "sensitive", "legacy", "plaintext"))
+		value, ok, err := kv.Get(ctx, 1, "sensitive", "legacy")
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "plaintext", value)
+	})
+
+	t.Run("decrypts the batch reads", func(t *testing.T) {
+		require.NoError(t, kv.MSet(ctx, 2, "sensitive", map[string]string{"a": "1", "b": "2"}))
+		values, err := kv.MGet(ctx, 2, "sensitive", []string{"a", "b"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"a": "1", "b": "2"}, values)
+
+		items, err := kv.GetAll(ctx, 2, "sensitive")
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]map[string]string{2: {"a": "1", "b": "2"}}, items)
+	})
+
+	t.Run("compares the decrypted values", func(t *testing.T) {
+		swapped, err := kv.CAS(ctx, 1, "sensitive", "token", "other", "new")
+		require.NoError(t, err)
+		assert.False(t, swapped)
+
+		swapped, err = kv.CAS(ctx, 1, "sensitive", "token", "secret", "new")
+		require.NoError(t, err)
+		assert.True(t, swapped)
+
+		swapped, err = kv.CAS(ctx, 1, "sensitive", "token", "", "other")
+		require.NoError(t, err)
+		assert.False(t, swapped)
+
+		value, _, err := kv.Get(ctx, 1, "sensitive", "token")
+		require.NoError(t, err)
+		assert.Equal(t, "new", value)
+	})
+}
@@ -0,0 +1,63 @@
+package kvstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// expiredItemsInterval is how often the items that expired are deleted
+const expiredItemsInterval = time.Minute
+
+// ExpiredItemsCleanupService deletes the items of the kvstore that expired, and updates the metrics of the usage of
+// the namespaces. Expired items are not returned by the store before they are deleted.
+type ExpiredItemsCleanupService struct {
+	store  *kvStoreSQL
+	leases *LeaseService
+	log    log.Logger
+}
+
+func ProvideExpiredItemsCleanupService(sqlStore sqlstore.Store, leases *LeaseService, clk clock.Clock) *ExpiredItemsCleanupService {
+	logger := log.New("infra.kvstore.expiry")
+	return &ExpiredItemsCleanupService{
+		store: &kvStoreSQL{
+			sqlStore: sqlStore,
+			log:      logger,
+			clock:    clk,
+		},
+		leases: leases,
+		log:    logger,
+	}
+}
+
+func (s *ExpiredItemsCleanupService) Run(ctx context.Context) error {
+	ticker := time.NewTicker(expiredItemsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.leases.ExecuteWithLease(ctx, "delete expired kvstore items", expiredItemsInterval/2, func(ctx context.Context, _ *Lease) {
+				count, err := s.store.DeleteExpired(ctx, s.store.clock.Now())
+				if err != nil {
+					s.log.Error("failed to delete expired kvstore items", "error", err)
+					return
+				}
+				if count > 0 {
+					s.log.Debug("deleted expired kvstore items", "count", count)
+				}
+			})
+			if err != nil {
+				s.log.Error("failed to acquire the lease of the expired kvstore items deletion", "error", err)
+			}
+			if err := s.store.updateUsageMetrics(ctx); err != nil {
+				s.log.Warn("failed to update kvstore usage metrics", "error", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const (
+	BackendSQL   = "sql"
+	BackendRedis = "redis"
+
+	// RedactedValue replaces the values of the dumped items unless they are requested
+	RedactedValue = "[REDACTED]"
+)
+
+// ErrInspectionNotSupported is returned when inspecting a kvstore that was not created by ProvideStore
+var ErrInspectionNotSupported = errors.New("kvstore inspection is not supported by this store")
+
+// NamespaceInfo describes a namespace of the kvstore for debugging. The items of the namespaces stored in the
+// database include the items that expired and are not deleted yet, the size of the namespaces stored in Redis is
+// not known.
+type NamespaceInfo struct {
+	Namespace string `json:"namespace"`
+	Backend   string `json:"backend"`
+	Sensitive bool   `json:"sensitive"`
+	Keys      int64  `json:"keys"`
+	Bytes     int64  `json:"bytes,omitempty"`
+}
+
+// DumpedItem is an item of the kvstore, its value is RedactedValue unless the values are requested
+type DumpedItem struct {
+	OrgId int64  `json:"orgId"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ListNamespaces returns the namespaces of the database that have items, and the namespaces stored in Redis
+func ListNamespaces(ctx context.Context, kv KVStore) ([]NamespaceInfo, error) {
+	var sensitive map[string]bool
+	if encrypted, ok := kv.(*encryptedKVStore); ok {
+		sensitive = encrypted.namespaces
+		kv = encrypted.store
+	}
+
+	var sqlStore *kvStoreSQL
+	var redisStore KVStore
+	var redisNamespaces map[string]bool
+	switch store := kv.(type) {
+	case *kvStoreSQL:
+		sqlStore = store
+	case *routedKVStore:
+		sqlStore, _ = store.sql.(*kvStoreSQL)
+		redisStore = store.redis
+		redisNamespaces = store.redisNamespaces
+	}
+	if sqlStore == nil {
+		return nil, ErrInspectionNotSupported
+	}
+
+	usage, err := sqlStore.Usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := make([]NamespaceInfo, 0, len(usage)+len(redisNamespaces))
+	for namespace, u := range usage {
+		namespaces = append(namespaces, NamespaceInfo{
+			Namespace: namespace,
+			Backend:   BackendSQL,
+			Sensitive: sensitive[namespace],
+			Keys:      u.Items,
+			Bytes:     u.Bytes,
+		})
+	}
+	for namespace := range redisNamespaces {
+		keys, err := redisStore.Keys(ctx, AllOrganizations, namespace, "")
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, NamespaceInfo{
+			Namespace: namespace,
+			Backend:   BackendRedis,
+			Sensitive: sensitive[namespace],
+			Keys:      int64(len(keys)),
+		})
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		if namespaces[i].Namespace != namespaces[j].Namespace {
+			return namespaces[i].Namespace < namespaces[j].Namespace
+		}
+		return namespaces[i].Backend < namespaces[j].Backend
+	})
+	return namespaces, nil
+}
+
+// DumpNamespace returns the items of the namespace with the given key prefix, sorted by organization and key. The
+// values are only read when showValues is set, the values of the sensitive namespaces are then decrypted. To dump
+// all the organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
+func DumpNamespace(ctx context.Context, kv KVStore, orgId int64, namespace string, keyPrefix string, showValues bool) ([]DumpedItem, error) {
+	items := []DumpedItem{}
+	if !showValues {
+		keys, err := kv.Keys(ctx, orgId, namespace, keyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			items = append(items, DumpedItem{OrgId: key.OrgId, Key: key.Key, Value: RedactedValue})
+		}
+	} else {
+		values, err := kv.GetAll(ctx, orgId, namespace)
+		if err != nil {
+			return nil, err
+		}
+		for itemOrgId, orgValues := range values {
+			for key, value := range orgValues {
+				if strings.HasPrefix(key, keyPrefix) {
+					items = append(items, DumpedItem{OrgId: itemOrgId, Key: key, Value: value})
+				}
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].OrgId != items[j].OrgId {
+			return items[i].OrgId < items[j].OrgId
+		}
+		return items[i].Key < items[j].Key
+	})
+	return items, nil
+}
@@ -2,7 +2,10 @@ package kvstore
 
 import (
 	"context"
+	"errors"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 )
@@ -12,10 +15,15 @@ const (
 	AllOrganizations = -1
 )
 
+// ErrInvalidContinueToken is returned when the continue token of a page of keys is malformed
+var ErrInvalidContinueToken = errors.New("invalid kvstore continue token")
+
 func ProvideService(sqlStore sqlstore.Store) KVStore {
 	return &kvStoreSQL{
-		sqlStore: sqlStore,
-		log:      log.New("infra.kvstore.sql"),
+		sqlStore:          sqlStore,
+		log:               log.New("infra.kvstore.sql"),
+		watchPollInterval: defaultWatchPollInterval,
+		clock:             clock.New(),
 	}
 }
 
@@ -23,9 +31,24 @@ func ProvideService(sqlStore sqlstore.Store) KVStore {
 type KVStore interface {
 	Get(ctx context.Context, orgId int64, namespace string, key string) (string, bool, error)
 	Set(ctx context.Context, orgId int64, namespace string, key string, value string) error
+	// SetWithTTL sets an item that is deleted once ttl elapsed, it is not returned anymore once expired. A ttl that is
+	// not positive deletes the item.
+	SetWithTTL(ctx context.Context, orgId int64, namespace string, key string, value string, ttl time.Duration) error
+	// MGet returns the values of the given keys that are set, by key
+	MGet(ctx context.Context, orgId int64, namespace string, keys []string) (map[string]string, error)
+	// MSet sets the values of several items, by key, at once
+	MSet(ctx context.Context, orgId int64, namespace string, values map[string]string) error
 	Del(ctx context.Context, orgId int64, namespace string, key string) error
+	// CAS sets the value of an item only if its current value is expected, an empty expected value matches a missing
+	// or expired item. It returns whether the value was set.
+	CAS(ctx context.Context, orgId int64, namespace string, key string, expected string, value string) (bool, error)
 	Keys(ctx context.Context, orgId int64, namespace string, keyPrefix string) ([]Key, error)
+	// KeysPage returns at most limit keys with the given prefix, and the token to pass to get the next page, which
+	// is empty on the last page. Pass an empty continueToken to get the first page.
+	KeysPage(ctx context.Context, orgId int64, namespace string, keyPrefix string, limit int, continueToken string) ([]Key, string, error)
 	GetAll(ctx context.Context, orgId int64, namespace string) (map[int64]map[string]string, error)
+	// Watch streams the changes of the items of all the organizations with the given prefix, until ctx is done
+	Watch(ctx context.Context, namespace string, keyPrefix string) (<-chan Event, error)
 }
 
 // WithNamespace returns a kvstore wrapper with fixed orgId and namespace.
@@ -52,14 +75,57 @@ func (kv *NamespacedKVStore) Set(ctx context.Context, key string, value string)
 	return kv.kvStore.Set(ctx, kv.orgId, kv.namespace, key, value)
 }
 
+func (kv *NamespacedKVStore) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	return kv.kvStore.SetWithTTL(ctx, kv.orgId, kv.namespace, key, value, ttl)
+}
+
+func (kv *NamespacedKVStore) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	return kv.kvStore.MGet(ctx, kv.orgId, kv.namespace, keys)
+}
+
+func (kv *NamespacedKVStore) MSet(ctx context.Context, values map[string]string) error {
+	return kv.kvStore.MSet(ctx, kv.orgId, kv.namespace, values)
+}
+
 func (kv *NamespacedKVStore) Del(ctx context.Context, key string) error {
 	return kv.kvStore.Del(ctx, kv.orgId, kv.namespace, key)
 }
 
+func (kv *NamespacedKVStore) CAS(ctx context.Context, key string, expected string, value string) (bool, error) {
+	return kv.kvStore.CAS(ctx, kv.orgId, kv.namespace, key, expected, value)
+}
+
 func (kv *NamespacedKVStore) Keys(ctx context.Context, keyPrefix string) ([]Key, error) {
 	return kv.kvStore.Keys(ctx, kv.orgId, kv.namespace, keyPrefix)
 }
 
+func (kv *NamespacedKVStore) KeysPage(ctx context.Context, keyPrefix string, limit int, continueToken string) ([]Key, string, error) {
+	return kv.kvStore.KeysPage(ctx, kv.orgId, kv.namespace, keyPrefix, limit, continueToken)
+}
+
+// Watch streams the changes of the items of the organization with the given prefix, until ctx is done
+func (kv *NamespacedKVStore) Watch(ctx context.Context, keyPrefix string) (<-chan Event, error) {
+	events, err := kv.kvStore.Watch(ctx, kv.namespace, keyPrefix)
+	if err != nil || kv.orgId == AllOrganizations {
+		return events, err
+	}
+	filtered := make(chan Event)
+	go func() {
+		defer close(filtered)
+		for event := range events {
+			if event.OrgId != kv.orgId {
+				continue
+			}
+			select {
+			case filtered <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return filtered, nil
+}
+
 // GetAll returns all the keys and values stored per organization. It returns a map of org -> key -> value.
 func (kv *NamespacedKVStore) GetAll(ctx context.Context) (map[int64]map[string]string, error) {
 	return kv.kvStore.GetAll(ctx, kv.orgId, kv.namespace)
@@ -3,8 +3,13 @@ package kvstore
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/stretchr/testify/assert"
@@ -19,6 +24,7 @@ func createTestableKVStore(t *testing.T) KVStore {
 	kv := &kvStoreSQL{
 		sqlStore: sqlStore,
 		log:      log.New("infra.kvstore.sql"),
+		clock:    clock.New(),
 	}
 
 	return kv
@@ -286,3 +292,356 @@ func TestGetItems(t *testing.T) {
 		}
 	})
 }
+
+func TestIntegrationKVStoreTTL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	kv := createTestableKVStore(t).(*kvStoreSQL)
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	kv.clock = clk
+
+	ctx := context.Background()
+
+	testSetWithTTL(t, kv, "shared-ttl")
+
+	require.NoError(t, kv.SetWithTTL(ctx, 1, "ttl", "expired", "value", time.Second))
+	require.NoError(t, kv.SetWithTTL(ctx, 1, "ttl", "persisted", "value", time.Second))
+	require.NoError(t, kv.SetWithTTL(ctx, 1, "ttl", "live", "value", time.Hour))
+	require.NoError(t, kv.Set(ctx, 1, "ttl", "persisted", "value"))
+	clk.Add(2 * time.Second)
+
+	t.Run("expired items are not returned", func(t *testing.T) {
+		_, ok, err := kv.Get(ctx, 1, "ttl", "expired")
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		value, ok, err := kv.Get(ctx, 1, "ttl", "live")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "value", value)
+
+		keys, err := kv.Keys(ctx, 1, "ttl", "")
+		require.NoError(t, err)
+		require.Equal(t, []Key{{OrgId: 1, Namespace: "ttl", Key: "live"}, {OrgId: 1, Namespace: "ttl", Key: "persisted"}}, keys)
+
+		items, err := kv.GetAll(ctx, 1, "ttl")
+		require.NoError(t, err)
+		require.Equal(t, map[int64]map[string]string{1: {"live": "value", "persisted": "value"}}, items)
+	})
+
+	t.Run("set removes the expiration", func(t *testing.T) {
+		_, ok, err := kv.Get(ctx, 1, "ttl", "persisted")
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("deletes the expired items", func(t *testing.T) {
+		count, err := kv.DeleteExpired(ctx, clk.Now())
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		// the live item and the one of the shared checks
+		count, err = kv.DeleteExpired(ctx, clk.Now().Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+
+		keys, err := kv.Keys(ctx, 1, "ttl", "")
+		require.NoError(t, err)
+		require.Equal(t, []Key{{OrgId: 1, Namespace: "ttl", Key: "persisted"}}, keys)
+	})
+}
+
+// testSetWithTTL checks the behavior of SetWithTTL that the database and Redis stores share
+func testSetWithTTL(t *testing.T, kv KVStore, namespace string) {
+	t.Helper()
+	ctx := context.Background()
+
+	t.Run("a positive ttl sets the item", func(t *testing.T) {
+		require.NoError(t, kv.SetWithTTL(ctx, 1, namespace, "ttl-positive", "value", time.Hour))
+		value, ok, err := kv.Get(ctx, 1, namespace, "ttl-positive")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, "value", value)
+	})
+
+	t.Run("a ttl that is not positive deletes the item", func(t *testing.T) {
+		require.NoError(t, kv.Set(ctx, 1, namespace, "ttl-zero", "value"))
+		require.NoError(t, kv.SetWithTTL(ctx, 1, namespace, "ttl-zero", "updated", 0))
+		require.NoError(t, kv.SetWithTTL(ctx, 1, namespace, "ttl-negative", "value", -time.Second))
+
+		for _, key := range []string{"ttl-zero", "ttl-negative"} {
+			_, ok, err := kv.Get(ctx, 1, namespace, key)
+			require.NoError(t, err)
+			assert.False(t, ok, key)
+		}
+		keys, err := kv.Keys(ctx, 1, namespace, "ttl-")
+		require.NoError(t, err)
+		assert.Equal(t, []Key{{OrgId: 1, Namespace: namespace, Key: "ttl-positive"}}, keys)
+	})
+}
+
+func TestIntegrationKVStoreCAS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	kv := createTestableKVStore(t)
+
+	ctx := context.Background()
+
+	t.Run("inserts a missing item", func(t *testing.T) {
+		swapped, err := kv.CAS(ctx, 1, "cas", "missing", "", "first")
+		require.NoError(t, err)
+		require.True(t, swapped)
+
+		swapped, err = kv.CAS(ctx, 1, "cas", "missing", "", "second")
+		require.NoError(t, err)
+		require.False(t, swapped)
+
+		value, _, err := kv.Get(ctx, 1, "cas", "missing")
+		require.NoError(t, err)
+		require.Equal(t, "first", value)
+	})
+
+	t.Run("updates the item only if it has the expected value", func(t *testing.T) {
+		require.NoError(t, kv.Set(ctx, 1, "cas", "existing", "v1"))
+
+		swapped, err := kv.CAS(ctx, 1, "cas", "existing", "v0", "v2")
+		require.NoError(t, err)
+		require.False(t, swapped)
+
+		swapped, err = kv.CAS(ctx, 1, "cas", "existing", "v1", "v2")
+		require.NoError(t, err)
+		require.True(t, swapped)
+
+		swapped, err = kv.CAS(ctx, 1, "cas", "existing", "v2", "v2")
+		require.NoError(t, err)
+		require.True(t, swapped)
+
+		value, _, err := kv.Get(ctx, 1, "cas", "existing")
+		require.NoError(t, err)
+		require.Equal(t, "v2", value)
+	})
+
+	t.Run("treats an expired item as missing", func(t *testing.T) {
+		require.NoError(t, kv.SetWithTTL(ctx, 1, "cas", "expired", "stale", -time.Second))
+
+		swapped, err := kv.CAS(ctx, 1, "cas", "expired", "stale", "updated")
+		require.NoError(t, err)
+		require.False(t, swapped)
+
+		swapped, err = kv.CAS(ctx, 1, "cas", "expired", "", "updated")
+		require.NoError(t, err)
+		require.True(t, swapped)
+
+		value, ok, err := kv.Get(ctx, 1, "cas", "expired")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "updated", value)
+	})
+
+	t.Run("only one of the concurrent swaps succeeds", func(t *testing.T) {
+		require.NoError(t, kv.Set(ctx, 1, "cas", "concurrent", "0"))
+
+		var wg sync.WaitGroup
+		var swaps int32
+		for i := 1; i <= 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				swapped, err := kv.CAS(ctx, 1, "cas", "concurrent", "0", fmt.Sprint(i))
+				if err == nil && swapped {
+					atomic.AddInt32(&swaps, 1)
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), swaps)
+	})
+}
+
+func TestIntegrationKVStoreKeysPage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	kv := createTestableKVStore(t)
+
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, kv.Set(ctx, int64(i%2+1), "paged", fmt.Sprintf("item%d", i), "value"))
+	}
+	require.NoError(t, kv.Set(ctx, 1, "paged", "other", "value"))
+	require.NoError(t, kv.SetWithTTL(ctx, 1, "paged", "item-expired", "value", -time.Second))
+
+	listAll := func(t *testing.T, orgId int64, limit int) ([]string, int) {
+		t.Helper()
+		var keys []string
+		var pages int
+		token := ""
+		for {
+			page, next, err := kv.KeysPage(ctx, orgId, "paged", "item", limit, token)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page), limit)
+			for _, key := range page {
+				keys = append(keys, fmt.Sprintf("%d/%s", key.OrgId, key.Key))
+			}
+			pages++
+			if next == "" {
+				return keys, pages
+			}
+			token = next
+		}
+	}
+
+	t.Run("lists the keys of every organization page by page", func(t *testing.T) {
+		keys, pages := listAll(t, AllOrganizations, 2)
+		assert.Equal(t, []string{"1/item0", "2/item1", "1/item2", "2/item3", "1/item4"}, keys)
+		assert.Equal(t, 3, pages)
+	})
+
+	t.Run("lists the keys of an organization", func(t *testing.T) {
+		keys, pages := listAll(t, 1, 3)
+		assert.Equal(t, []string{"1/item0", "1/item2", "1/item4"}, keys)
+		assert.Equal(t, 1, pages)
+	})
+
+	t.Run("rejects invalid tokens and limits", func(t *testing.T) {
+		_, _, err := kv.KeysPage(ctx, 1, "paged", "", 10, "not a token")
+		assert.ErrorIs(t, err, ErrInvalidContinueToken)
+		_, _, err = kv.KeysPage(ctx, 1, "paged", "", 0, "")
+		assert.Error(t, err)
+	})
+}
+
+func TestIntegrationKVStoreWatch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	kv := createTestableKVStore(t).(*kvStoreSQL)
+	kv.watchPollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, kv.Set(ctx, 1, "watched", "flag-a", "before"))
+	events, err := kv.Watch(ctx, "watched", "flag-")
+	require.NoError(t, err)
+	orgEvents, err := WithNamespace(kv, 2, "watched").Watch(ctx, "flag-")
+	require.NoError(t, err)
+
+	next := func(t *testing.T, events <-chan Event) Event {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no kvstore event")
+			return Event{}
+		}
+	}
+
+	require.NoError(t, kv.Set(ctx, 1, "watched", "flag-a", "after"))
+	assert.Equal(t, Event{Type: EventSet, OrgId: 1, Namespace: "watched", Key: "flag-a", Value: "after"}, next(t, events))
+
+	require.NoError(t, kv.Set(ctx, 1, "watched", "other", "ignored"))
+	require.NoError(t, kv.Set(ctx, 2, "watched", "flag-b", "value"))
+	assert.Equal(t, Event{Type: EventSet, OrgId: 2, Namespace: "watched", Key: "flag-b", Value: "value"}, next(t, events))
+	assert.Equal(t, Event{Type: EventSet, OrgId: 2, Namespace: "watched", Key: "flag-b", Value: "value"}, next(t, orgEvents))
+
+	require.NoError(t, kv.Del(ctx, 1, "watched", "flag-a"))
+	assert.Equal(t, Event{Type: EventDeleted, OrgId: 1, Namespace: "watched", Key: "flag-a"}, next(t, events))
+
+	cancel()
+	for range events {
+	}
+	for range orgEvents {
+	}
+}
+
+func TestIntegrationKVStoreBatch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	kv := createTestableKVStore(t)
+
+	ctx := context.Background()
+
+	require.NoError(t, kv.Set(ctx, 1, "batch", "existing", "before"))
+	require.NoError(t, kv.SetWithTTL(ctx, 1, "batch", "expired", "stale", -time.Second))
+
+	values := map[string]string{"existing": "after", "expired": "renewed"}
+	for i := 0; i < 2*batchSize; i++ {
+		values[fmt.Sprintf("key%d", i)] = fmt.Sprint(i)
+	}
+	require.NoError(t, kv.MSet(ctx, 1, "batch", values))
+
+	keys := []string{"missing"}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	got, err := kv.MGet(ctx, 1, "batch", keys)
+	require.NoError(t, err)
+	assert.Equal(t, values, got)
+
+	t.Run("gets only the items of the organization", func(t *testing.T) {
+		got, err := kv.MGet(ctx, 2, "batch", keys)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("does not return the expired items", func(t *testing.T) {
+		require.NoError(t, kv.SetWithTTL(ctx, 1, "batch", "existing", "expiring", -time.Second))
+		got, err := kv.MGet(ctx, 1, "batch", []string{"existing", "key0"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"key0": "0"}, got)
+	})
+}
+
+func TestIntegrationKVStoreQuota(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	kv := createTestableKVStore(t).(*kvStoreSQL)
+	kv.quota = quota{maxItems: 3, maxBytes: 30}
+
+	ctx := context.Background()
+
+	require.NoError(t, kv.Set(ctx, 1, "quota", "a", "1234"))
+	require.NoError(t, kv.MSet(ctx, 2, "quota", map[string]string{"b": "1234", "c": "1234"}))
+
+	t.Run("rejects the items beyond the maximum count", func(t *testing.T) {
+		err := kv.Set(ctx, 1, "quota", "d", "1")
+		assert.ErrorIs(t, err, ErrQuotaExceeded)
+		swapped, err := kv.CAS(ctx, 1, "quota", "d", "", "1")
+		assert.ErrorIs(t, err, ErrQuotaExceeded)
+		assert.False(t, swapped)
+		err = kv.MSet(ctx, 1, "quota", map[string]string{"a": "1", "d": "1"})
+		assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+		// other namespaces have their own quota
+		require.NoError(t, kv.Set(ctx, 1, "quota-other", "d", "1"))
+	})
+
+	t.Run("rejects the values beyond the maximum size", func(t *testing.T) {
+		err := kv.Set(ctx, 1, "quota", "a", strings.Repeat("x", 20))
+		assert.ErrorIs(t, err, ErrQuotaExceeded)
+		// 15 bytes used by the others
+		require.NoError(t, kv.Set(ctx, 1, "quota", "a", strings.Repeat("x", 14)))
+	})
+
+	t.Run("accepts the writes that shrink the namespace", func(t *testing.T) {
+		require.NoError(t, kv.Set(ctx, 1, "quota", "a", "1"))
+		require.NoError(t, kv.Del(ctx, 2, "quota", "c"))
+		require.NoError(t, kv.Set(ctx, 1, "quota", "d", "1"))
+	})
+
+	t.Run("reports the usage of the namespaces", func(t *testing.T) {
+		usage, err := kv.Usage(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, NamespaceUsage{Items: 3, Bytes: 9}, usage["quota"])
+		assert.Equal(t, NamespaceUsage{Items: 1, Bytes: 2}, usage["quota-other"])
+		require.NoError(t, kv.updateUsageMetrics(ctx))
+	})
+}
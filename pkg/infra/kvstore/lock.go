@@ -0,0 +1,199 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+)
+
+const leaseNamespace = "infra.kvstore.leases"
+
+var (
+	// ErrLeaseHeld is returned when acquiring a lease that is held by another owner and did not expire
+	ErrLeaseHeld = errors.New("kvstore lease is held by another owner")
+	// ErrLeaseLost is returned when renewing or releasing a lease that was acquired by another owner since
+	ErrLeaseLost = errors.New("kvstore lease was lost")
+)
+
+// Lease is the exclusive right of an owner to run an action until it expires. The fencing token is incremented each
+// time the lease is acquired, so the writes of an owner that lost its lease can be told apart from the writes of the
+// new owner.
+type Lease struct {
+	Name    string    `json:"-"`
+	Owner   string    `json:"owner"`
+	Token   int64     `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+func (l *Lease) expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+// LeaseService grants leases stored in the kvstore, so that a single Grafana instance of an HA deployment runs an
+// action. The expiry of the leases relies on the clocks of the instances being synchronized.
+type LeaseService struct {
+	kv    *NamespacedKVStore
+	owner string
+	log   log.Logger
+	now   func() time.Time
+}
+
+func ProvideLeaseService(kv KVStore) *LeaseService {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return &LeaseService{
+		kv:    WithNamespace(kv, 0, leaseNamespace),
+		owner: fmt.Sprintf("%s/%s", hostname, uuid.NewString()),
+		log:   log.New("infra.kvstore.leases"),
+		now:   time.Now,
+	}
+}
+
+func (s *LeaseService) get(ctx context.Context, name string) (*Lease, string, error) {
+	value, exists, err := s.kv.Get(ctx, name)
+	if err != nil || !exists {
+		return nil, "", err
+	}
+	lease := &Lease{Name: name}
+	if err := json.Unmarshal([]byte(value), lease); err != nil {
+		return nil, "", fmt.Errorf("failed to decode lease %q: %w", name, err)
+	}
+	return lease, value, nil
+}
+
+// swap replaces the stored lease, whose value is current, with the given lease
+func (s *LeaseService) swap(ctx context.Context, current string, lease *Lease) (bool, error) {
+	value, err := json.Marshal(lease)
+	if err != nil {
+		return false, err
+	}
+	return s.kv.CAS(ctx, lease.Name, current, string(value))
+}
+
+// AcquireLease acquires the lease with the given name for ttl, or returns ErrLeaseHeld if another owner holds it
+func (s *LeaseService) AcquireLease(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	now := s.now()
+	current, value, err := s.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	lease := &Lease{Name: name, Owner: s.owner, Token: 1, Expires: now.Add(ttl)}
+	if current != nil {
+		if !current.expired(now) {
+			return nil, ErrLeaseHeld
+		}
+		lease.Token = current.Token + 1
+	}
+	swapped, err := s.swap(ctx, value, lease)
+	if err != nil {
+		return nil, err
+	}
+	if !swapped {
+		// another owner acquired the lease since it was read
+		return nil, ErrLeaseHeld
+	}
+	return lease, nil
+}
+
+// Renew extends the lease for ttl from now, or returns ErrLeaseLost if the lease was acquired by another owner
+func (s *LeaseService) Renew(ctx context.Context, lease *Lease, ttl time.Duration) (*Lease, error) {
+	current, value, err := s.get(ctx, lease.Name)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || current.Owner != lease.Owner || current.Token != lease.Token {
+		return nil, ErrLeaseLost
+	}
+	renewed := &Lease{Name: lease.Name, Owner: lease.Owner, Token: lease.Token, Expires: s.now().Add(ttl)}
+	swapped, err := s.swap(ctx, value, renewed)
+	if err != nil {
+		return nil, err
+	}
+	if !swapped {
+		return nil, ErrLeaseLost
+	}
+	return renewed, nil
+}
+
+// Release expires the lease so that it can be acquired right away, or returns ErrLeaseLost if the lease was acquired
+// by another owner. The lease is kept in the store, so that its next owner gets the next fencing token.
+func (s *LeaseService) Release(ctx context.Context, lease *Lease) error {
+	current, value, err := s.get(ctx, lease.Name)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Owner != lease.Owner || current.Token != lease.Token {
+		return ErrLeaseLost
+	}
+	released := &Lease{Name: lease.Name, Owner: lease.Owner, Token: lease.Token, Expires: s.now()}
+	swapped, err := s.swap(ctx, value, released)
+	if err != nil {
+		return err
+	}
+	if !swapped {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// ExecuteWithLease runs fn if the lease with the given name can be acquired, and releases the lease once fn returns.
+// The lease is renewed while fn runs, and the context of fn is canceled if the lease is lost. It does nothing when
+// another owner holds the lease, like serverlock.LockAndExecute.
+func (s *LeaseService) ExecuteWithLease(ctx context.Context, name string, ttl time.Duration, fn func(ctx context.Context, lease *Lease)) error {
+	lease, err := s.AcquireLease(ctx, name, ttl)
+	if errors.Is(err, ErrLeaseHeld) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fnCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	// current is the last renewed lease, which is released once fn returns
+	current := lease
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				next, err := s.Renew(fnCtx, current, ttl)
+				if err != nil {
+					if fnCtx.Err() == nil {
+						s.log.Warn("failed to renew lease, canceling its action", "name", name, "token", current.Token, "error", err)
+						cancel()
+					}
+					return
+				}
+				current = next
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	fn(fnCtx, lease)
+	close(done)
+	<-stopped
+	if fnCtx.Err() != nil && ctx.Err() == nil {
+		// the lease was lost while fn was running
+		return nil
+	}
+	if err := s.Release(context.Background(), current); err != nil && !errors.Is(err, ErrLeaseLost) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package kvstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationKVStoreLeases(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	kv := createTestableKVStore(t)
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	first := ProvideLeaseService(kv)
+	first.now = clock
+	second := ProvideLeaseService(kv)
+	second.now = clock
+
+	ctx := context.Background()
+
+	t.Run("grants the lease to a single owner", func(t *testing.T) {
+		lease, err := first.AcquireLease(ctx, "single", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), lease.Token)
+
+		_, err = second.AcquireLease(ctx, "single", time.Minute)
+		assert.ErrorIs(t, err, ErrLeaseHeld)
+		_, err = first.AcquireLease(ctx, "single", time.Minute)
+		assert.ErrorIs(t, err, ErrLeaseHeld)
+	})
+
+	t.Run("increments the fencing token of an expired lease", func(t *testing.T) {
+		lease, err := first.AcquireLease(ctx, "expired", time.Minute)
+		require.NoError(t, err)
+
+		now = now.Add(2 * time.Minute)
+		acquired, err := second.AcquireLease(ctx, "expired", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, lease.Token+1, acquired.Token)
+
+		_, err = first.Renew(ctx, lease, time.Minute)
+		assert.ErrorIs(t, err, ErrLeaseLost)
+		assert.ErrorIs(t, first.Release(ctx, lease), ErrLeaseLost)
+	})
+
+	t.Run("renews the lease", func(t *testing.T) {
+		lease, err := first.AcquireLease(ctx, "renewed", time.Minute)
+		require.NoError(t, err)
+
+		now = now.Add(50 * time.Second)
+		renewed, err := first.Renew(ctx, lease, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, lease.Token, renewed.Token)
+
+		now = now.Add(50 * time.Second)
+		_, err = second.AcquireLease(ctx, "renewed", time.Minute)
+		assert.ErrorIs(t, err, ErrLeaseHeld)
+	})
+
+	t.Run("releases the lease", func(t *testing.T) {
+		lease, err := first.AcquireLease(ctx, "released", time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, first.Release(ctx, lease))
+
+		acquired, err := second.AcquireLease(ctx, "released", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, lease.Token+1, acquired.Token)
+	})
+
+	t.Run("executes with the lease", func(t *testing.T) {
+		executed := 0
+		err := first.ExecuteWithLease(ctx, "executed", time.Minute, func(ctx context.Context, lease *Lease) {
+			executed++
+			// the lease is held while the action runs
+			err := second.ExecuteWithLease(ctx, "executed", time.Minute, func(context.Context, *Lease) {
+				executed++
+			})
+			require.NoError(t, err)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, executed)
+
+		err = second.ExecuteWithLease(ctx, "executed", time.Minute, func(_ context.Context, lease *Lease) {
+			executed++
+			assert.Equal(t, int64(2), lease.Token)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, executed)
+	})
+}
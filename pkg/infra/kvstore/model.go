@@ -14,6 +14,8 @@ type Item struct {
 
 	Created time.Time
 	Updated time.Time
+	// Expires is nil for the items that don't expire
+	Expires *time.Time
 }
 
 func (i *Item) TableName() string {
@@ -29,3 +31,19 @@ type Key struct {
 func (i *Key) TableName() string {
 	return "kv_store"
 }
+
+type EventType string
+
+const (
+	EventSet     EventType = "set"
+	EventDeleted EventType = "deleted"
+)
+
+// Event is a change of an item of the store, Value is empty for the deleted items
+type Event struct {
+	Type      EventType `json:"type"`
+	OrgId     int64     `json:"orgId"`
+	Namespace string    `json:"namespace"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value,omitempty"`
+}
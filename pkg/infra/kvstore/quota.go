@@ -0,0 +1,133 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// ErrQuotaExceeded is returned when a write would grow a namespace beyond the limits of `kvstore.max_items_per_namespace`
+// or `kvstore.max_bytes_per_namespace`
+var ErrQuotaExceeded = errors.New("kvstore namespace quota exceeded")
+
+var (
+	namespaceItemsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "kvstore_namespace_items",
+		Help:      "Number of items of the kvstore in the database, by namespace",
+	}, []string{"namespace"})
+	namespaceBytesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "kvstore_namespace_bytes",
+		Help:      "Size of the keys and values of the kvstore in the database, by namespace",
+	}, []string{"namespace"})
+	quotaRejectionsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "kvstore_quota_rejections_total",
+		Help:      "Number of writes of the kvstore rejected because the namespace reached its quota, by namespace",
+	}, []string{"namespace"})
+)
+
+func init() {
+	prometheus.MustRegister(namespaceItemsGauge, namespaceBytesGauge, quotaRejectionsCounter)
+}
+
+// quota limits the number of items and the size of each namespace of the database, 0 means no limit
+type quota struct {
+	maxItems int64
+	maxBytes int64
+}
+
+func readQuota(cfg *setting.Cfg) quota {
+	section := cfg.SectionWithEnvOverrides("kvstore")
+	return quota{
+		maxItems: section.Key("max_items_per_namespace").MustInt64(0),
+		maxBytes: section.Key("max_bytes_per_namespace").MustInt64(0),
+	}
+}
+
+func (q quota) enabled() bool {
+	return q.maxItems > 0 || q.maxBytes > 0
+}
+
+// NamespaceUsage is the number of items of a namespace, and the size of their keys and values, including the items
+// that expired and are not deleted yet
+type NamespaceUsage struct {
+	Items int64 `xorm:"items"`
+	Bytes int64 `xorm:"bytes"`
+}
+
+func itemSize(key string, value string) int64 {
+	return int64(len(key) + len(value))
+}
+
+// namespaceUsage counts the items of the namespace in the session
+func (kv *kvStoreSQL) namespaceUsage(dbSession *sqlstore.DBSession, namespace string) (NamespaceUsage, error) {
+	var usage NamespaceUsage
+	_, err := dbSession.SQL(fmt.Sprintf("SELECT COUNT(*) AS items, COALESCE(SUM(LENGTH(%s) + LENGTH(value)), 0) AS bytes FROM kv_store WHERE namespace = ?",
+		kv.sqlStore.Quote("key")), namespace).Get(&usage)
+	return usage, err
+}
+
+// checkQuota returns ErrQuotaExceeded when adding items and bytes to the namespace would exceed its quota. Only the
+// writes that grow the namespace are checked, so that a namespace over its quota can still be cleaned up.
+func (kv *kvStoreSQL) checkQuota(dbSession *sqlstore.DBSession, namespace string, addedItems int64, addedBytes int64) error {
+	if !kv.quota.enabled() || (addedItems <= 0 && addedBytes <= 0) {
+		return nil
+	}
+	usage, err := kv.namespaceUsage(dbSession, namespace)
+	if err != nil {
+		return err
+	}
+	if addedItems > 0 && kv.quota.maxItems > 0 && usage.Items+addedItems > kv.quota.maxItems {
+		quotaRejectionsCounter.WithLabelValues(namespace).Inc()
+		return fmt.Errorf("%w: namespace %q has %d items, the maximum is %d", ErrQuotaExceeded, namespace, usage.Items, kv.quota.maxItems)
+	}
+	if addedBytes > 0 && kv.quota.maxBytes > 0 && usage.Bytes+addedBytes > kv.quota.maxBytes {
+		quotaRejectionsCounter.WithLabelValues(namespace).Inc()
+		return fmt.Errorf("%w: namespace %q has %d bytes, the maximum is %d", ErrQuotaExceeded, namespace, usage.Bytes, kv.quota.maxBytes)
+	}
+	return nil
+}
+
+// Usage returns the usage of every namespace of the database
+func (kv *kvStoreSQL) Usage(ctx context.Context) (map[string]NamespaceUsage, error) {
+	var rows []struct {
+		Namespace string `xorm:"namespace"`
+		Items     int64  `xorm:"items"`
+		Bytes     int64  `xorm:"bytes"`
+	}
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		return dbSession.SQL(fmt.Sprintf("SELECT namespace, COUNT(*) AS items, COALESCE(SUM(LENGTH(%s) + LENGTH(value)), 0) AS bytes FROM kv_store GROUP BY namespace",
+			kv.sqlStore.Quote("key"))).Find(&rows)
+	})
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[string]NamespaceUsage, len(rows))
+	for _, row := range rows {
+		usage[row.Namespace] = NamespaceUsage{Items: row.Items, Bytes: row.Bytes}
+	}
+	return usage, nil
+}
+
+// updateUsageMetrics sets the usage gauges of every namespace, the namespaces that are now empty are removed
+func (kv *kvStoreSQL) updateUsageMetrics(ctx context.Context) error {
+	usage, err := kv.Usage(ctx)
+	if err != nil {
+		return err
+	}
+	namespaceItemsGauge.Reset()
+	namespaceBytesGauge.Reset()
+	for namespace, u := range usage {
+		namespaceItemsGauge.WithLabelValues(namespace).Set(float64(u.Items))
+		namespaceBytesGauge.WithLabelValues(namespace).Set(float64(u.Bytes))
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+)
+
+const (
+	redisKeyPrefix = "grafana:kvstore:"
+	// number of keys requested per SCAN call
+	redisScanCount = 500
+)
+
+// redisCASScript sets KEYS[1] to ARGV[2] if its value is ARGV[1], an empty ARGV[1] matching a missing key
+var redisCASScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if (current == false and ARGV[1] == '') or current == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// kvStoreRedis provides a key/value store backed by Redis, for the namespaces whose keys change too often to be
+// written to the Grafana database. Each item is a Redis key, so that the items expire with the Redis TTL.
+type kvStoreRedis struct {
+	log    log.Logger
+	client redis.UniversalClient
+}
+
+// redisKey is the Redis key of an item, grafana:kvstore:{<namespace length>:<namespace>:<org id>}:<key>. The length
+// of the namespace tells the namespace, the org id and the key apart whatever they contain, and the namespace and the
+// org id are the hash tag of the key so that the items of an organization are in the same Redis Cluster slot, as
+// MGET and MSET require. The org id is between the namespace and the key so that the keys of a namespace can be listed
+// for all the organizations.
+func redisKey(orgId int64, namespace string, key string) string {
+	return redisNamespacePrefix(namespace) + strconv.FormatInt(orgId, 10) + "}:" + key
+}
+
+// redisNamespacePrefix is the start of the Redis keys of a namespace, up to the org id
+func redisNamespacePrefix(namespace string) string {
+	return fmt.Sprintf("%s{%d:%s:", redisKeyPrefix, len(namespace), namespace)
+}
+
+// escapeRedisPattern escapes the glob special characters of a SCAN pattern
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func redisKeysPattern(orgId int64, namespace string, keyPrefix string) string {
+	org := "*"
+	if orgId != AllOrganizations {
+		org = strconv.FormatInt(orgId, 10)
+	}
+	return escapeRedisPattern(redisNamespacePrefix(namespace)) + org + "}:" + escapeRedisPattern(keyPrefix) + "*"
+}
+
+// parseRedisKey returns the item of a Redis key of the namespace
+func parseRedisKey(redisKey string, namespace string) (Key, bool) {
+	rest := strings.TrimPrefix(redisKey, redisNamespacePrefix(namespace))
+	if rest == redisKey {
+		return Key{}, false
+	}
+	org, key, found := strings.Cut(rest, "}:")
+	if !found {
+		return Key{}, false
+	}
+	orgId, err := strconv.ParseInt(org, 10, 64)
+	if err != nil {
+		return Key{}, false
+	}
+	return Key{OrgId: orgId, Namespace: namespace, Key: key}, true
+}
+
+// Get an item from the store
+func (kv *kvStoreRedis) Get(ctx context.Context, orgId int64, namespace string, key string) (string, bool, error) {
+	value, err := kv.client.Get(ctx, redisKey(orgId, namespace, key)).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		kv.log.Debug("error getting kvstore value", "orgId", orgId, "namespace", namespace, "key", key, "err", err)
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// Set an item in the store
+func (kv *kvStoreRedis) Set(ctx context.Context, orgId int64, namespace string, key string, value string) error {
+	if err := kv.client.Set(ctx, redisKey(orgId, namespace, key), value, 0).Err(); err != nil {
+		return err
+	}
+	kv.publish(ctx, Event{Type: EventSet, OrgId: orgId, Namespace: namespace, Key: key, Value: value})
+	return nil
+}
+
+// SetWithTTL sets an item in the store that expires after ttl, a ttl that is not positive deletes the item
+func (kv *kvStoreRedis) SetWithTTL(ctx context.Context, orgId int64, namespace string, key string, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return kv.Del(ctx, orgId, namespace, key)
+	}
+	if err := kv.client.Set(ctx, redisKey(orgId, namespace, key), value, ttl).Err(); err != nil {
+		return err
+	}
+	kv.publish(ctx, Event{Type: EventSet, OrgId: orgId, Namespace: namespace, Key: key, Value: value})
+	return nil
+}
+
+// MGet gets several items with one MGET, the keys of an organization sharing a hash tag
+func (kv *kvStoreRedis) MGet(ctx context.Context, orgId int64, namespace string, keys []string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+	redisKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		redisKeys = append(redisKeys, redisKey(orgId, namespace, key))
+	}
+	results, err := kv.client.MGet(ctx, redisKeys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		if value, ok := result.(string); ok {
+			values[keys[i]] = value
+		}
+	}
+	return values, nil
+}
+
+// MSet sets several items with one MSET, which also removes their TTL. The keys of an organization share a hash tag.
+func (kv *kvStoreRedis) MSet(ctx context.Context, orgId int64, namespace string, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	pairs := make([]interface{}, 0, 2*len(values))
+	for key, value := range values {
+		pairs = append(pairs, redisKey(orgId, namespace, key), value)
+	}
+	if err := kv.client.MSet(ctx, pairs...).Err(); err != nil {
+		return err
+	}
+	for key, value := range values {
+		kv.publish(ctx, Event{Type: EventSet, OrgId: orgId, Namespace: namespace, Key: key, Value: value})
+	}
+	return nil
+}
+
+// Del deletes an item from the store.
+func (kv *kvStoreRedis) Del(ctx context.Context, orgId int64, namespace string, key string) error {
+	deleted, err := kv.client.Del(ctx, redisKey(orgId, namespace, key)).Result()
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		kv.publish(ctx, Event{Type: EventDeleted, OrgId: orgId, Namespace: namespace, Key: key})
+	}
+	return nil
+}
+
+// CAS sets an item with a script, which Redis runs atomically
+func (kv *kvStoreRedis) CAS(ctx context.Context, orgId int64, namespace string, key string, expected string, value string) (bool, error) {
+	swapped, err := redisCASScript.Run(ctx, kv.client, []string{redisKey(orgId, namespace, key)}, expected, value).Int()
+	if err != nil {
+		return false, err
+	}
+	if swapped == 1 {
+		kv.publish(ctx, Event{Type: EventSet, OrgId: orgId, Namespace: namespace, Key: key, Value: value})
+	}
+	return swapped == 1, nil
+}
+
+// scan returns the Redis keys of one SCAN call and the cursor of the next one, which is 0 after the last call
+func (kv *kvStoreRedis) scan(ctx context.Context, cursor uint64, orgId int64, namespace string, keyPrefix string) ([]Key, uint64, error) {
+	redisKeys, next, err := kv.client.Scan(ctx, cursor, redisKeysPattern(orgId, namespace, keyPrefix), redisScanCount).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	keys := make([]Key, 0, len(redisKeys))
+	for _, redisKey := range redisKeys {
+		// the org wildcard of the pattern can also match the start of a key containing "}:"
+		if key, ok := parseRedisKey(redisKey, namespace); ok && strings.HasPrefix(key.Key, keyPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	// SCAN doesn't return the keys in any particular order
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].OrgId != keys[j].OrgId {
+			return keys[i].OrgId < keys[j].OrgId
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	return keys, next, nil
+}
+
+// Keys get all keys for a given namespace and keyPrefix. To query for all
+// organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
+func (kv *kvStoreRedis) Keys(ctx context.Context, orgId int64, namespace string, keyPrefix string) ([]Key, error) {
+	keys := []Key{}
+	var cursor uint64
+	for {
+		page, next, err := kv.scan(ctx, cursor, orgId, namespace, keyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// KeysPage gets the keys page by page with SCAN. The continue token is the cursor of the SCAN call and the number of
+// its keys already returned. As with SCAN, a key can be returned twice when Redis resizes its keyspace while paging.
+func (kv *kvStoreRedis) KeysPage(ctx context.Context, orgId int64, namespace string, keyPrefix string, limit int, continueToken string) ([]Key, string, error) {
+	var cursor uint64
+	var skip int
+	if continueToken != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(continueToken)
+		if err != nil {
+			return nil, "", ErrInvalidContinueToken
+		}
+		if _, err := fmt.Sscanf(string(decoded), "%d:%d", &cursor, &skip); err != nil {
+			return nil, "", ErrInvalidContinueToken
+		}
+	}
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("invalid kvstore page limit %d", limit)
+	}
+
+	keys := []Key{}
+	for {
+		page, next, err := kv.scan(ctx, cursor, orgId, namespace, keyPrefix)
+		if err != nil {
+			return nil, "", err
+		}
+		if skip > len(page) {
+			skip = len(page)
+		}
+		page = page[skip:]
+		if remaining := limit - len(keys); len(page) > remaining {
+			keys = append(keys, page[:remaining]...)
+			token := fmt.Sprintf("%d:%d", cursor, skip+remaining)
+			return keys, base64.RawURLEncoding.EncodeToString([]byte(token)), nil
+		}
+		keys = append(keys, page...)
+		if next == 0 {
+			return keys, "", nil
+		}
+		cursor, skip = next, 0
+		if len(keys) == limit {
+			return keys, base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:0", cursor))), nil
+		}
+	}
+}
+
+// GetAll get all items a given namespace and org. To query for all
+// organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
+// The map result is like map[orgId]map[key]value
+func (kv *kvStoreRedis) GetAll(ctx context.Context, orgId int64, namespace string) (map[int64]map[string]string, error) {
+	keys, err := kv.Keys(ctx, orgId, namespace, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// MGET requires keys of the same Redis Cluster slot, the keys are read organization by organization
+	byOrg := map[int64][]string{}
+	for _, key := range keys {
+		byOrg[key.OrgId] = append(byOrg[key.OrgId], key.Key)
+	}
+
+	items := map[int64]map[string]string{}
+	for org, orgKeys := range byOrg {
+		for start := 0; start < len(orgKeys); start += redisScanCount {
+			end := start + redisScanCount
+			if end > len(orgKeys) {
+				end = len(orgKeys)
+			}
+			// values deleted or expired since the keys were listed are missing
+			values, err := kv.MGet(ctx, org, namespace, orgKeys[start:end])
+			if err != nil {
+				return nil, err
+			}
+			if len(values) == 0 {
+				continue
+			}
+			if _, ok := items[org]; !ok {
+				items[org] = map[string]string{}
+			}
+			for key, value := range values {
+				items[org][key] = value
+			}
+		}
+	}
+	return items, nil
+}
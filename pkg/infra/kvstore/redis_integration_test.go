@@ -0,0 +1,113 @@
+//go:build redis
+// +build redis
+
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisKVStore(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	kv := &kvStoreRedis{client: client, log: log.New("test")}
+	ctx := context.Background()
+	namespace := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		keys, _ := kv.Keys(ctx, AllOrganizations, namespace, "")
+		for _, key := range keys {
+			_ = kv.Del(ctx, key.OrgId, key.Namespace, key.Key)
+		}
+	})
+
+	t.Run("sets, gets and deletes items", func(t *testing.T) {
+		require.NoError(t, kv.Set(ctx, 1, namespace, "key", "value"))
+		value, ok, err := kv.Get(ctx, 1, namespace, "key")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, "value", value)
+
+		require.NoError(t, kv.Del(ctx, 1, namespace, "key"))
+		_, ok, err = kv.Get(ctx, 1, namespace, "key")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("expires items", func(t *testing.T) {
+		require.NoError(t, kv.SetWithTTL(ctx, 1, namespace, "ttl", "value", 50*time.Millisecond))
+		time.Sleep(100 * time.Millisecond)
+		_, ok, err := kv.Get(ctx, 1, namespace, "ttl")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	testSetWithTTL(t, kv, namespace)
+
+	t.Run("compares and swaps", func(t *testing.T) {
+		swapped, err := kv.CAS(ctx, 1, namespace, "cas", "", "v1")
+		require.NoError(t, err)
+		assert.True(t, swapped)
+		swapped, err = kv.CAS(ctx, 1, namespace, "cas", "", "v2")
+		require.NoError(t, err)
+		assert.False(t, swapped)
+		swapped, err = kv.CAS(ctx, 1, namespace, "cas", "v1", "v2")
+		require.NoError(t, err)
+		assert.True(t, swapped)
+	})
+
+	t.Run("lists the keys page by page", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			require.NoError(t, kv.Set(ctx, int64(i%2+1), namespace, fmt.Sprintf("item%d", i), "value"))
+		}
+		var keys []Key
+		token := ""
+		for {
+			page, next, err := kv.KeysPage(ctx, AllOrganizations, namespace, "item", 2, token)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page), 2)
+			keys = append(keys, page...)
+			if next == "" {
+				break
+			}
+			token = next
+		}
+		assert.Len(t, keys, 5)
+
+		items, err := kv.GetAll(ctx, 2, namespace)
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]map[string]string{2: {"item1": "value", "item3": "value"}}, items)
+
+		items, err = kv.GetAll(ctx, AllOrganizations, namespace)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"item1": "value", "item3": "value"}, items[2])
+		assert.Equal(t, "value", items[1]["item4"])
+	})
+
+	t.Run("gets and sets several items", func(t *testing.T) {
+		values := map[string]string{"batch1": "one", "batch2": "two"}
+		require.NoError(t, kv.MSet(ctx, 1, namespace, values))
+		got, err := kv.MGet(ctx, 1, namespace, []string{"batch1", "batch2", "missing"})
+		require.NoError(t, err)
+		assert.Equal(t, values, got)
+	})
+
+	t.Run("streams the changes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		events, err := kv.Watch(ctx, namespace, "watched")
+		require.NoError(t, err)
+
+		require.NoError(t, kv.Set(ctx, 1, namespace, "other", "value"))
+		require.NoError(t, kv.Set(ctx, 1, namespace, "watched", "value"))
+		require.NoError(t, kv.Del(ctx, 1, namespace, "watched"))
+		assert.Equal(t, Event{Type: EventSet, OrgId: 1, Namespace: namespace, Key: "watched", Value: "value"}, <-events)
+		assert.Equal(t, Event{Type: EventDeleted, OrgId: 1, Namespace: namespace, Key: "watched"}, <-events)
+	})
+}
@@ -0,0 +1,78 @@
+package kvstore
+
+import (
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestRedisKeys(t *testing.T) {
+	t.Run("parses the keys of the namespace", func(t *testing.T) {
+		key, ok := parseRedisKey(redisKey(2, "dedup", "alert:1"), "dedup")
+		require.True(t, ok)
+		assert.Equal(t, Key{OrgId: 2, Namespace: "dedup", Key: "alert:1"}, key)
+
+		_, ok = parseRedisKey(redisKey(2, "other", "alert:1"), "dedup")
+		assert.False(t, ok)
+		_, ok = parseRedisKey(redisKeyPrefix+"{5:dedup:x}:alert", "dedup")
+		assert.False(t, ok)
+	})
+
+	t.Run("tells the namespace, the org and the key apart", func(t *testing.T) {
+		assert.NotEqual(t, redisKey(2, "a:1", "x"), redisKey(1, "a", "2:x"))
+
+		_, ok := parseRedisKey(redisKey(1, "a", "2:x"), "a:1")
+		assert.False(t, ok)
+		key, ok := parseRedisKey(redisKey(1, "a", "}:x"), "a")
+		require.True(t, ok)
+		assert.Equal(t, Key{OrgId: 1, Namespace: "a", Key: "}:x"}, key)
+	})
+
+	t.Run("puts the keys of an organization in the same hash slot", func(t *testing.T) {
+		assert.Equal(t, "grafana:kvstore:{5:dedup:2}:alert", redisKey(2, "dedup", "alert"))
+		assert.Equal(t, "grafana:kvstore:{5:dedup:2}:other", redisKey(2, "dedup", "other"))
+	})
+
+	t.Run("escapes the patterns", func(t *testing.T) {
+		assert.Equal(t, `grafana:kvstore:{3:ns\*:*}:key\?\[a\]*`, redisKeysPattern(AllOrganizations, "ns*", "key?[a]"))
+		assert.Equal(t, `grafana:kvstore:{2:ns:3}:*`, redisKeysPattern(3, "ns", ""))
+	})
+}
+
+func TestProvideStore(t *testing.T) {
+	sqlStore := createTestableKVStore(t).(*kvStoreSQL).sqlStore
+
+	cfg := func(t *testing.T, remoteCache string) *setting.Cfg {
+		t.Helper()
+		raw, err := ini.Load([]byte(`
+		[kvstore]
+		redis_namespaces = dedup, ratelimit`))
+		require.NoError(t, err)
+		return &setting.Cfg{Raw: raw, RemoteCacheOptions: &setting.RemoteCacheOptions{Name: remoteCache, ConnStr: "addr=localhost:6379"}}
+	}
+
+	t.Run("uses the database without redis namespaces", func(t *testing.T) {
+		store, err := ProvideStore(setting.NewCfg(), sqlStore, clock.New())
+		require.NoError(t, err)
+		assert.IsType(t, &kvStoreSQL{}, store)
+	})
+
+	t.Run("routes the redis namespaces to redis", func(t *testing.T) {
+		store, err := ProvideStore(cfg(t, "redis"), sqlStore, clock.New())
+		require.NoError(t, err)
+		routed, ok := store.(*routedKVStore)
+		require.True(t, ok)
+		assert.IsType(t, &kvStoreRedis{}, routed.store("dedup"))
+		assert.IsType(t, &kvStoreRedis{}, routed.store("ratelimit"))
+		assert.IsType(t, &kvStoreSQL{}, routed.store("alertmanager"))
+	})
+
+	t.Run("requires the redis remote cache", func(t *testing.T) {
+		_, err := ProvideStore(cfg(t, "database"), sqlStore, clock.New())
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,131 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/remotecache"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// ProvideStore returns the kvstore of the Grafana database, which stores the namespaces listed in
+// `kvstore.redis_namespaces` in the Redis server of the remote cache, and encrypts the values of the namespaces listed
+// in `kvstore.sensitive_namespaces`.
+func ProvideStore(cfg *setting.Cfg, sqlStore sqlstore.Store, clk clock.Clock) (KVStore, error) {
+	store, err := provideRoutedStore(cfg, sqlStore, clk)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := sensitiveNamespaces(cfg)
+	if len(namespaces) == 0 {
+		return store, nil
+	}
+	return &encryptedKVStore{
+		store:      store,
+		namespaces: namespaces,
+		log:        log.New("infra.kvstore.encrypted"),
+	}, nil
+}
+
+func provideRoutedStore(cfg *setting.Cfg, sqlStore sqlstore.Store, clk clock.Clock) (KVStore, error) {
+	sqlKVStore := &kvStoreSQL{
+		sqlStore:          sqlStore,
+		log:               log.New("infra.kvstore.sql"),
+		watchPollInterval: cfg.SectionWithEnvOverrides("kvstore").Key("watch_poll_interval").MustDuration(defaultWatchPollInterval),
+		quota:             readQuota(cfg),
+		clock:             clk,
+	}
+	namespaces := redisNamespaces(cfg)
+	if len(namespaces) == 0 {
+		return sqlKVStore, nil
+	}
+
+	if cfg.RemoteCacheOptions == nil || cfg.RemoteCacheOptions.Name != "redis" {
+		return nil, errors.New("kvstore.redis_namespaces requires the remote_cache type to be redis")
+	}
+	options, err := remotecache.ParseRedisConnStr(cfg.RemoteCacheOptions.ConnStr)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.New("infra.kvstore.redis")
+	logger.Info("kvstore namespaces are stored in redis", "namespaces", cfg.SectionWithEnvOverrides("kvstore").Key("redis_namespaces").String())
+	return &routedKVStore{
+		sql:             sqlKVStore,
+		redis:           &kvStoreRedis{client: redis.NewClient(options), log: logger},
+		redisNamespaces: namespaces,
+	}, nil
+}
+
+func redisNamespaces(cfg *setting.Cfg) map[string]bool {
+	namespaces := map[string]bool{}
+	for _, namespace := range cfg.SectionWithEnvOverrides("kvstore").Key("redis_namespaces").Strings(",") {
+		if namespace != "" {
+			namespaces[namespace] = true
+		}
+	}
+	return namespaces
+}
+
+// routedKVStore stores each namespace either in the Grafana database or in Redis
+type routedKVStore struct {
+	sql             KVStore
+	redis           KVStore
+	redisNamespaces map[string]bool
+}
+
+func (kv *routedKVStore) store(namespace string) KVStore {
+	if kv.redisNamespaces[namespace] {
+		return kv.redis
+	}
+	return kv.sql
+}
+
+func (kv *routedKVStore) Get(ctx context.Context, orgId int64, namespace string, key string) (string, bool, error) {
+	return kv.store(namespace).Get(ctx, orgId, namespace, key)
+}
+
+func (kv *routedKVStore) Set(ctx context.Context, orgId int64, namespace string, key string, value string) error {
+	return kv.store(namespace).Set(ctx, orgId, namespace, key, value)
+}
+
+func (kv *routedKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, key string, value string, ttl time.Duration) error {
+	return kv.store(namespace).SetWithTTL(ctx, orgId, namespace, key, value, ttl)
+}
+
+func (kv *routedKVStore) MGet(ctx context.Context, orgId int64, namespace string, keys []string) (map[string]string, error) {
+	return kv.store(namespace).MGet(ctx, orgId, namespace, keys)
+}
+
+func (kv *routedKVStore) MSet(ctx context.Context, orgId int64, namespace string, values map[string]string) error {
+	return kv.store(namespace).MSet(ctx, orgId, namespace, values)
+}
+
+func (kv *routedKVStore) Del(ctx context.Context, orgId int64, namespace string, key string) error {
+	return kv.store(namespace).Del(ctx, orgId, namespace, key)
+}
+
+func (kv *routedKVStore) CAS(ctx context.Context, orgId int64, namespace string, key string, expected string, value string) (bool, error) {
+	return kv.store(namespace).CAS(ctx, orgId, namespace, key, expected, value)
+}
+
+func (kv *routedKVStore) Keys(ctx context.Context, orgId int64, namespace string, keyPrefix string) ([]Key, error) {
+	return kv.store(namespace).Keys(ctx, orgId, namespace, keyPrefix)
+}
+
+func (kv *routedKVStore) KeysPage(ctx context.Context, orgId int64, namespace string, keyPrefix string, limit int, continueToken string) ([]Key, string, error) {
+	return kv.store(namespace).KeysPage(ctx, orgId, namespace, keyPrefix, limit, continueToken)
+}
+
+func (kv *routedKVStore) GetAll(ctx context.Context, orgId int64, namespace string) (map[int64]map[string]string, error) {
+	return kv.store(namespace).GetAll(ctx, orgId, namespace)
+}
+
+func (kv *routedKVStore) Watch(ctx context.Context, namespace string, keyPrefix string) (<-chan Event, error) {
+	return kv.store(namespace).Watch(ctx, namespace, keyPrefix)
+}
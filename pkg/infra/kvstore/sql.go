@@ -2,9 +2,13 @@ package kvstore
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 )
@@ -13,6 +17,16 @@ import (
 type kvStoreSQL struct {
 	log      log.Logger
 	sqlStore sqlstore.Store
+	// how often the items are read to find the changes streamed by Watch
+	watchPollInterval time.Duration
+	quota             quota
+	// clock is the source of the creation, update and expiration times of the items
+	clock clock.Clock
+}
+
+// dbContext tags the queries of the store in the per-service database metrics
+func dbContext(ctx context.Context) context.Context {
+	return sqlstore.WithServiceName(ctx, "kvstore")
 }
 
 // Get an item from the store
@@ -24,7 +38,7 @@ func (kv *kvStoreSQL) Get(ctx context.Context, orgId int64, namespace string, ke
 	}
 	var itemFound bool
 
-	err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
 		has, err := dbSession.Get(&item)
 		if err != nil {
 			kv.log.Debug("error getting kvstore value", "orgId", orgId, "namespace", namespace, "key", key, "err", err)
@@ -34,6 +48,11 @@ func (kv *kvStoreSQL) Get(ctx context.Context, orgId int64, namespace string, ke
 			kv.log.Debug("kvstore value not found", "orgId", orgId, "namespace", namespace, "key", key)
 			return nil
 		}
+		// expired items are not returned before they are deleted
+		if item.Expires != nil && !item.Expires.After(kv.clock.Now()) {
+			kv.log.Debug("kvstore value expired", "orgId", orgId, "namespace", namespace, "key", key)
+			return nil
+		}
 		itemFound = true
 		kv.log.Debug("got kvstore value", "orgId", orgId, "namespace", namespace, "key", key, "value", item.Value)
 		return nil
@@ -44,7 +63,21 @@ func (kv *kvStoreSQL) Get(ctx context.Context, orgId int64, namespace string, ke
 
 // Set an item in the store
 func (kv *kvStoreSQL) Set(ctx context.Context, orgId int64, namespace string, key string, value string) error {
-	return kv.sqlStore.WithTransactionalDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+	return kv.set(ctx, orgId, namespace, key, value, nil)
+}
+
+// SetWithTTL sets an item in the store that expires after ttl, a ttl that is not positive deletes the item like
+// in Redis
+func (kv *kvStoreSQL) SetWithTTL(ctx context.Context, orgId int64, namespace string, key string, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return kv.Del(ctx, orgId, namespace, key)
+	}
+	expires := kv.clock.Now().Add(ttl)
+	return kv.set(ctx, orgId, namespace, key, value, &expires)
+}
+
+func (kv *kvStoreSQL) set(ctx context.Context, orgId int64, namespace string, key string, value string, expires *time.Time) error {
+	return kv.sqlStore.WithTransactionalDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
 		item := Item{
 			OrgId:     &orgId,
 			Namespace: &namespace,
@@ -57,16 +90,25 @@ func (kv *kvStoreSQL) Set(ctx context.Context, orgId int64, namespace string, ke
 			return err
 		}
 
-		if has && item.Value == value {
+		if has && item.Value == value && expires == nil && item.Expires == nil {
 			kv.log.Debug("kvstore value not changed", "orgId", orgId, "namespace", namespace, "key", key, "value", value)
 			return nil
 		}
 
+		addedItems, addedBytes := int64(1), itemSize(key, value)
+		if has {
+			addedItems, addedBytes = 0, addedBytes-itemSize(key, item.Value)
+		}
+		if err := kv.checkQuota(dbSession, namespace, addedItems, addedBytes); err != nil {
+			return err
+		}
+
 		item.Value = value
-		item.Updated = time.Now()
+		item.Updated = kv.clock.Now()
+		item.Expires = expires
 
 		if has {
-			_, err = dbSession.Exec("UPDATE kv_store SET value = ?, updated = ? WHERE id = ?", item.Value, item.Updated, item.Id)
+			_, err = dbSession.Exec("UPDATE kv_store SET value = ?, updated = ?, expires = ? WHERE id = ?", item.Value, item.Updated, item.Expires, item.Id)
 			if err != nil {
 				kv.log.Debug("error updating kvstore value", "orgId", orgId, "namespace", namespace, "key", key, "value", value, "err", err)
 			} else {
@@ -86,9 +128,115 @@ func (kv *kvStoreSQL) Set(ctx context.Context, orgId int64, namespace string, ke
 	})
 }
 
+// batchSize is the maximum number of keys of a query, to stay within the limits of the number of query parameters
+const batchSize = 500
+
+// MGet gets several items of the store with one query per batchSize keys
+func (kv *kvStoreSQL) MGet(ctx context.Context, orgId int64, namespace string, keys []string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		items, err := kv.findItems(dbSession, orgId, namespace, keys)
+		if err != nil {
+			return err
+		}
+		now := kv.clock.Now()
+		for _, item := range items {
+			if item.Expires != nil && !item.Expires.After(now) {
+				continue
+			}
+			values[*item.Key] = item.Value
+		}
+		return nil
+	})
+	return values, err
+}
+
+// MSet sets several items of the store in one transaction, reading the existing items with one query per batchSize
+// keys and inserting the missing ones with one query
+func (kv *kvStoreSQL) MSet(ctx context.Context, orgId int64, namespace string, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return kv.sqlStore.WithTransactionalDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		existing, err := kv.findItems(dbSession, orgId, namespace, keys)
+		if err != nil {
+			return err
+		}
+		existingByKey := make(map[string]Item, len(existing))
+		for _, item := range existing {
+			existingByKey[*item.Key] = item
+		}
+
+		var addedItems, addedBytes int64
+		for _, key := range keys {
+			if item, has := existingByKey[key]; has {
+				addedBytes += itemSize(key, values[key]) - itemSize(key, item.Value)
+			} else {
+				addedItems++
+				addedBytes += itemSize(key, values[key])
+			}
+		}
+		if err := kv.checkQuota(dbSession, namespace, addedItems, addedBytes); err != nil {
+			return err
+		}
+
+		now := kv.clock.Now()
+		var inserted []*Item
+		for _, key := range keys {
+			key, value := key, values[key]
+			item, has := existingByKey[key]
+			if !has {
+				inserted = append(inserted, &Item{OrgId: &orgId, Namespace: &namespace, Key: &key, Value: value, Created: now, Updated: now})
+				continue
+			}
+			if item.Value == value && item.Expires == nil {
+				continue
+			}
+			if _, err := dbSession.Exec("UPDATE kv_store SET value = ?, updated = ?, expires = NULL WHERE id = ?", value, now, item.Id); err != nil {
+				kv.log.Debug("error updating kvstore value", "orgId", orgId, "namespace", namespace, "key", key, "err", err)
+				return err
+			}
+		}
+		for start := 0; start < len(inserted); start += batchSize {
+			end := start + batchSize
+			if end > len(inserted) {
+				end = len(inserted)
+			}
+			if _, err := dbSession.InsertMulti(inserted[start:end]); err != nil {
+				kv.log.Debug("error inserting kvstore values", "orgId", orgId, "namespace", namespace, "err", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (kv *kvStoreSQL) findItems(dbSession *sqlstore.DBSession, orgId int64, namespace string, keys []string) ([]Item, error) {
+	var items []Item
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		var batch []Item
+		err := dbSession.Where("org_id = ? AND namespace = ?", orgId, namespace).In(kv.sqlStore.Quote("key"), keys[start:end]).Find(&batch)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, batch...)
+	}
+	return items, nil
+}
+
 // Del deletes an item from the store.
 func (kv *kvStoreSQL) Del(ctx context.Context, orgId int64, namespace string, key string) error {
-	err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
 		query := fmt.Sprintf("DELETE FROM kv_store WHERE org_id=? and namespace=? and %s=?", kv.sqlStore.Quote("key"))
 		_, err := dbSession.Exec(query, orgId, namespace, key)
 		return err
@@ -96,12 +244,81 @@ func (kv *kvStoreSQL) Del(ctx context.Context, orgId int64, namespace string, ke
 	return err
 }
 
+// CAS sets an item with a conditional update, so that concurrent instances can't overwrite each other's value. The
+// missing items are inserted, and the unique index rejects the concurrent inserts.
+func (kv *kvStoreSQL) CAS(ctx context.Context, orgId int64, namespace string, key string, expected string, value string) (bool, error) {
+	if expected != "" && expected == value {
+		// nothing to update, and MySQL doesn't count the rows that don't change as affected
+		current, ok, err := kv.Get(ctx, orgId, namespace, key)
+		return ok && current == expected, err
+	}
+
+	var swapped bool
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		// the replaced value of a missing or expired item is not known, it is counted as a new item
+		addedItems, addedBytes := int64(1), itemSize(key, value)
+		if expected != "" {
+			addedItems, addedBytes = 0, int64(len(value)-len(expected))
+		}
+		if err := kv.checkQuota(dbSession, namespace, addedItems, addedBytes); err != nil {
+			return err
+		}
+
+		now := kv.clock.Now()
+		where := fmt.Sprintf("org_id = ? AND namespace = ? AND %s = ?", kv.sqlStore.Quote("key"))
+		args := []interface{}{value, now, orgId, namespace, key}
+		if expected == "" {
+			where += " AND (value = ? OR expires <= ?)"
+		} else {
+			where += " AND value = ? AND (expires IS NULL OR expires > ?)"
+		}
+		args = append(args, expected, now)
+
+		query := "UPDATE kv_store SET value = ?, updated = ?, expires = NULL WHERE " + where
+		res, err := dbSession.Exec(append([]interface{}{query}, args...)...)
+		if err != nil {
+			return err
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			swapped = true
+			return nil
+		}
+		if expected != "" {
+			return nil
+		}
+
+		item := Item{
+			OrgId:     &orgId,
+			Namespace: &namespace,
+			Key:       &key,
+			Value:     value,
+			Created:   now,
+			Updated:   now,
+		}
+		if _, err := dbSession.Insert(&item); err != nil {
+			if kv.sqlStore.GetDialect().IsUniqueConstraintViolation(err) {
+				kv.log.Debug("kvstore value set concurrently", "orgId", orgId, "namespace", namespace, "key", key)
+				return nil
+			}
+			return err
+		}
+		swapped = true
+		return nil
+	})
+	return swapped, err
+}
+
 // Keys get all keys for a given namespace and keyPrefix. To query for all
 // organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
 func (kv *kvStoreSQL) Keys(ctx context.Context, orgId int64, namespace string, keyPrefix string) ([]Key, error) {
 	var keys []Key
-	err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
-		query := dbSession.Where("namespace = ?", namespace).And(fmt.Sprintf("%s LIKE ?", kv.sqlStore.Quote("key")), keyPrefix+"%")
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		query := dbSession.Where("namespace = ?", namespace).And(fmt.Sprintf("%s LIKE ?", kv.sqlStore.Quote("key")), keyPrefix+"%").
+			And("(expires IS NULL OR expires > ?)", kv.clock.Now())
 		if orgId != AllOrganizations {
 			query.And("org_id = ?", orgId)
 		}
@@ -110,13 +327,58 @@ func (kv *kvStoreSQL) Keys(ctx context.Context, orgId int64, namespace string, k
 	return keys, err
 }
 
+// KeysPage gets the keys for a given namespace and keyPrefix page by page, in the order they were inserted. The
+// continue token is the id of the last item of the page, so that the items inserted or deleted between the pages
+// don't shift the pages. To query for all organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
+func (kv *kvStoreSQL) KeysPage(ctx context.Context, orgId int64, namespace string, keyPrefix string, limit int, continueToken string) ([]Key, string, error) {
+	var afterId int64
+	if continueToken != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(continueToken)
+		if err != nil {
+			return nil, "", ErrInvalidContinueToken
+		}
+		if afterId, err = strconv.ParseInt(string(decoded), 10, 64); err != nil {
+			return nil, "", ErrInvalidContinueToken
+		}
+	}
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("invalid kvstore page limit %d", limit)
+	}
+
+	var items []Item
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		query := dbSession.Where("namespace = ?", namespace).And(fmt.Sprintf("%s LIKE ?", kv.sqlStore.Quote("key")), keyPrefix+"%").
+			And("(expires IS NULL OR expires > ?)", kv.clock.Now()).
+			And("id > ?", afterId)
+		if orgId != AllOrganizations {
+			query.And("org_id = ?", orgId)
+		}
+		// one more item than the page to know if there is a next page
+		return query.Asc("id").Limit(limit + 1).Find(&items)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(items) > limit {
+		items = items[:limit]
+		next = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(items[limit-1].Id, 10)))
+	}
+	keys := make([]Key, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, Key{OrgId: *item.OrgId, Namespace: *item.Namespace, Key: *item.Key})
+	}
+	return keys, next, nil
+}
+
 // GetAll get all items a given namespace and org. To query for all
 // organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
 // The map result is like map[orgId]map[key]value
 func (kv *kvStoreSQL) GetAll(ctx context.Context, orgId int64, namespace string) (map[int64]map[string]string, error) {
 	var results []Item
-	err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
-		query := dbSession.Where("namespace = ?", namespace)
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		query := dbSession.Where("namespace = ?", namespace).And("(expires IS NULL OR expires > ?)", kv.clock.Now())
 		if orgId != AllOrganizations {
 			query.And("org_id = ?", orgId)
 		}
@@ -134,3 +396,17 @@ func (kv *kvStoreSQL) GetAll(ctx context.Context, orgId int64, namespace string)
 
 	return items, err
 }
+
+// DeleteExpired deletes the items that expired at now, it returns the number of deleted items
+func (kv *kvStoreSQL) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	var count int64
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		res, err := dbSession.Exec("DELETE FROM kv_store WHERE expires <= ?", now)
+		if err != nil {
+			return err
+		}
+		count, err = res.RowsAffected()
+		return err
+	})
+	return count, err
+}
@@ -0,0 +1,147 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+const defaultWatchPollInterval = 5 * time.Second
+
+// Watch polls the items every watchPollInterval and streams the differences with the previous poll, so a value
+// that changes back and forth between two polls is not streamed, and an item that expires is streamed as deleted.
+// The events channel is closed once ctx is done.
+func (kv *kvStoreSQL) Watch(ctx context.Context, namespace string, keyPrefix string) (<-chan Event, error) {
+	previous, err := kv.watchedItems(ctx, namespace, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(kv.watchPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			current, err := kv.watchedItems(ctx, namespace, keyPrefix)
+			if err != nil {
+				if ctx.Err() == nil {
+					kv.log.Warn("failed to poll kvstore items", "namespace", namespace, "keyPrefix", keyPrefix, "error", err)
+				}
+				continue
+			}
+			for _, event := range diffItems(previous, current) {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			previous = current
+		}
+	}()
+	return events, nil
+}
+
+func (kv *kvStoreSQL) watchedItems(ctx context.Context, namespace string, keyPrefix string) (map[Key]string, error) {
+	var items []Item
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		return dbSession.Where("namespace = ?", namespace).And(fmt.Sprintf("%s LIKE ?", kv.sqlStore.Quote("key")), keyPrefix+"%").
+			And("(expires IS NULL OR expires > ?)", kv.clock.Now()).
+			Find(&items)
+	})
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[Key]string, len(items))
+	for _, item := range items {
+		values[Key{OrgId: *item.OrgId, Namespace: *item.Namespace, Key: *item.Key}] = item.Value
+	}
+	return values, nil
+}
+
+func diffItems(previous map[Key]string, current map[Key]string) []Event {
+	var events []Event
+	for key, value := range current {
+		if previousValue, ok := previous[key]; !ok || previousValue != value {
+			events = append(events, Event{Type: EventSet, OrgId: key.OrgId, Namespace: key.Namespace, Key: key.Key, Value: value})
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			events = append(events, Event{Type: EventDeleted, OrgId: key.OrgId, Namespace: key.Namespace, Key: key.Key})
+		}
+	}
+	return events
+}
+
+// redisEventsChannel is the pub/sub channel of the changes of the items of a namespace
+func redisEventsChannel(namespace string) string {
+	return "grafana:kvstore-events:" + namespace
+}
+
+// publish notifies the watchers of a change, failures are only logged as the change was applied
+func (kv *kvStoreRedis) publish(ctx context.Context, event Event) {
+	data, err := json.Marshal(event)
+	if err == nil {
+		err = kv.client.Publish(ctx, redisEventsChannel(event.Namespace), data).Err()
+	}
+	if err != nil {
+		kv.log.Warn("failed to publish kvstore event", "namespace", event.Namespace, "key", event.Key, "error", err)
+	}
+}
+
+// Watch subscribes to the changes published by the writes of every Grafana instance. The items that expire are not
+// streamed, as Redis deletes them without a write. The events channel is closed once ctx is done.
+func (kv *kvStoreRedis) Watch(ctx context.Context, namespace string, keyPrefix string) (<-chan Event, error) {
+	pubsub := kv.client.Subscribe(ctx, redisEventsChannel(namespace))
+	// wait for the subscription, so that the changes made after Watch returns are streamed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer func() {
+			_ = pubsub.Close()
+		}()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event Event
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					kv.log.Warn("failed to decode kvstore event", "namespace", namespace, "error", err)
+					continue
+				}
+				if !strings.HasPrefix(event.Key, keyPrefix) {
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
@@ -1,6 +1,7 @@
 package remotecache
 
 import (
+	"bytes"
 	"context"
 	"time"
 
@@ -123,6 +124,65 @@ func (dc *databaseCache) Set(ctx context.Context, key string, value interface{},
 	return err
 }
 
+// TakeToken takes a token from a bucket with an update conditioned on the
+// bucket read, which is attempted again when another instance updated the
+// bucket in the meantime. A bucket that expired is replaced by a full one.
+func (dc *databaseCache) TakeToken(ctx context.Context, key string, rate float64, burst int64, now time.Time) (bool, error) {
+	expiresInSeconds := int64(tokenBucketExpiration(rate, burst) / time.Second)
+	for attempt := 0; attempt < tokenBucketAttempts; attempt++ {
+		var taken, stored bool
+		err := dc.SQLStore.WithDbSession(ctx, func(session *sqlstore.DBSession) error {
+			cacheHit := CacheData{}
+			exist, err := session.Where("cache_key= ?", key).Get(&cacheHit)
+			if err != nil {
+				return err
+			}
+
+			var current *tokenBucket
+			if exist && (cacheHit.Expires == 0 || getTime().Unix()-cacheHit.CreatedAt < cacheHit.Expires) {
+				if current, err = decodeTokenBucket(cacheHit.Data); err != nil {
+					return err
+				}
+			}
+			bucket, ok := current.take(rate, burst, now)
+			taken = ok
+
+			if !exist {
+				sql := `INSERT INTO cache_data (cache_key,data,created_at,expires) VALUES(?,?,?,?)`
+				_, err = session.Exec(sql, key, bucket.encode(), getTime().Unix(), expiresInSeconds)
+				// another instance created the bucket in the meantime
+				if err != nil && dc.SQLStore.Dialect.IsUniqueConstraintViolation(err) {
+					return nil
+				}
+				stored = err == nil
+				return err
+			}
+
+			data := bucket.encode()
+			// MySQL does not count the rows left unchanged as affected
+			if current != nil && bytes.Equal(data, cacheHit.Data) {
+				stored = true
+				return nil
+			}
+			sql := `UPDATE cache_data SET data=?, created_at=?, expires=? WHERE cache_key=? AND data=?`
+			res, err := session.Exec(sql, data, getTime().Unix(), expiresInSeconds, key, cacheHit.Data)
+			if err != nil {
+				return err
+			}
+			affected, err := res.RowsAffected()
+			stored = affected == 1
+			return err
+		})
+		if err != nil && !dc.SQLStore.Dialect.IsDeadlock(err) {
+			return false, err
+		}
+		if stored {
+			return taken, nil
+		}
+	}
+	return false, errTokenBucketConflict
+}
+
 func (dc *databaseCache) Delete(ctx context.Context, key string) error {
 	return dc.SQLStore.WithDbSession(ctx, func(session *sqlstore.DBSession) error {
 		sql := "DELETE FROM cache_data WHERE cache_key=?"
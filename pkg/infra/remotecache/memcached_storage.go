@@ -2,6 +2,7 @@ package remotecache
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
@@ -66,6 +67,42 @@ func (s *memcachedStorage) Get(ctx context.Context, key string) (interface{}, er
 	return item.Val, nil
 }
 
+// TakeToken takes a token from a bucket with cas, and creates the bucket with
+// add when it is missing. Memcached expirations are in seconds.
+func (s *memcachedStorage) TakeToken(ctx context.Context, key string, rate float64, burst int64, now time.Time) (bool, error) {
+	expiresInSeconds := int32(tokenBucketExpiration(rate, burst) / time.Second)
+	for attempt := 0; attempt < tokenBucketAttempts; attempt++ {
+		item, err := s.c.Get(key)
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			bucket, taken := (*tokenBucket)(nil).take(rate, burst, now)
+			err = s.c.Add(newItem(key, bucket.encode(), expiresInSeconds))
+			// another client created the bucket in the meantime
+			if errors.Is(err, memcache.ErrNotStored) {
+				continue
+			}
+			return taken, err
+		}
+		if err != nil {
+			return false, err
+		}
+
+		current, err := decodeTokenBucket(item.Value)
+		if err != nil {
+			return false, err
+		}
+		bucket, taken := current.take(rate, burst, now)
+		item.Value = bucket.encode()
+		item.Expiration = expiresInSeconds
+		err = s.c.CompareAndSwap(item)
+		// another client updated or evicted the bucket in the meantime
+		if errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrNotStored) {
+			continue
+		}
+		return taken, err
+	}
+	return false, errTokenBucketConflict
+}
+
 // Delete delete a key from the cache
 func (s *memcachedStorage) Delete(ctx context.Context, key string) error {
 	return s.c.Delete(key)
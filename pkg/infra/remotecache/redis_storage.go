@@ -18,8 +18,8 @@ type redisStorage struct {
 	c *redis.Client
 }
 
-// parseRedisConnStr parses k=v pairs in csv and builds a redis Options object
-func parseRedisConnStr(connStr string) (*redis.Options, error) {
+// ParseRedisConnStr parses k=v pairs in csv and builds a redis Options object
+func ParseRedisConnStr(connStr string) (*redis.Options, error) {
 	keyValueCSV := strings.Split(connStr, ",")
 	options := &redis.Options{Network: "tcp"}
 	setTLSIsTrue := false
@@ -77,7 +77,7 @@ func parseRedisConnStr(connStr string) (*redis.Options, error) {
 }
 
 func newRedisStorage(opts *setting.RemoteCacheOptions) (*redisStorage, error) {
-	opt, err := parseRedisConnStr(opts.ConnStr)
+	opt, err := ParseRedisConnStr(opts.ConnStr)
 	if err != nil {
 		return nil, err
 	}
@@ -111,6 +111,43 @@ func (s *redisStorage) Get(ctx context.Context, key string) (interface{}, error)
 	return nil, err
 }
 
+// takeTokenScript refills the token bucket stored in the hash KEYS[1] and
+// takes a token, as tokenBucket.take does. ARGV are the rate, the burst, the
+// time in milliseconds and the expiration in milliseconds.
+var takeTokenScript = redis.NewScript(`
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tokens = burst
+local updated = now
+if bucket[1] and bucket[2] then
+	local last = tonumber(bucket[2])
+	updated = math.max(last, now)
+	tokens = math.min(burst, tonumber(bucket[1]) + (updated - last) / 1000 * rate)
+end
+local taken = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	taken = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(updated))
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return taken
+`)
+
+// TakeToken takes a token from a bucket with a script, which Redis runs
+// atomically.
+func (s *redisStorage) TakeToken(ctx context.Context, key string, rate float64, burst int64, now time.Time) (bool, error) {
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	expiration := tokenBucketExpiration(rate, burst) / time.Millisecond
+	taken, err := takeTokenScript.Run(ctx, s.c, []string{key}, rate, burst, nowMs, int64(expiration)).Int()
+	if err != nil {
+		return false, err
+	}
+	return taken == 1, nil
+}
+
 // Delete delete a key from session.
 func (s *redisStorage) Delete(ctx context.Context, key string) error {
 	cmd := s.c.Del(ctx, key)
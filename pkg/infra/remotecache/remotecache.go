@@ -21,6 +21,9 @@ var (
 	// ErrInvalidCacheType is returned if the type is invalid
 	ErrInvalidCacheType = errors.New("invalid remote cache name")
 
+	// ErrTokenBucketNotSupported is returned by TakeToken if the cache cannot update token buckets atomically
+	ErrTokenBucketNotSupported = errors.New("remote cache does not support token buckets")
+
 	defaultMaxCacheExpiration = time.Hour * 24
 )
 
@@ -57,6 +60,12 @@ type CacheStorage interface {
 	Delete(ctx context.Context, key string) error
 }
 
+// tokenBucketStorage is implemented by the cache storages that can update
+// token buckets atomically
+type tokenBucketStorage interface {
+	TakeToken(ctx context.Context, key string, rate float64, burst int64, now time.Time) (bool, error)
+}
+
 // RemoteCache allows Grafana to cache data outside its own process
 type RemoteCache struct {
 	log      log.Logger
@@ -79,6 +88,20 @@ func (ds *RemoteCache) Set(ctx context.Context, key string, value interface{}, e
 	return ds.client.Set(ctx, key, value, expire)
 }
 
+// TakeToken takes a token from the token bucket of key and returns whether
+// there was one left. The bucket holds up to burst tokens, it is full when
+// created and refilled continuously with rate tokens per second. The bucket is
+// shared by all the Grafana instances using the cache: Redis updates it with a
+// script, memcached and the database with a compare and swap. Buckets cannot
+// be read with Get, and expire once they would be full again.
+func (ds *RemoteCache) TakeToken(ctx context.Context, key string, rate float64, burst int64, now time.Time) (bool, error) {
+	buckets, ok := ds.client.(tokenBucketStorage)
+	if !ok {
+		return false, ErrTokenBucketNotSupported
+	}
+	return buckets.TakeToken(ctx, key, rate, burst, now)
+}
+
 // Delete object from cache
 func (ds *RemoteCache) Delete(ctx context.Context, key string) error {
 	return ds.client.Delete(ctx, key)
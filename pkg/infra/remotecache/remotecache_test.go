@@ -51,6 +51,7 @@ func TestInvalidCacheTypeReturnsError(t *testing.T) {
 func runTestsForClient(t *testing.T, client CacheStorage) {
 	canPutGetAndDeleteCachedObjects(t, client)
 	canNotFetchExpiredItems(t, client)
+	canTakeTokens(t, client)
 }
 
 func canPutGetAndDeleteCachedObjects(t *testing.T, client CacheStorage) {
@@ -87,3 +88,26 @@ func canNotFetchExpiredItems(t *testing.T, client CacheStorage) {
 	_, err = client.Get(context.Background(), "key1")
 	assert.Equal(t, err, ErrCacheItemNotFound)
 }
+
+func canTakeTokens(t *testing.T, client CacheStorage) {
+	cache, ok := client.(*RemoteCache)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	take := func(t *testing.T, key string, at time.Time) bool {
+		t.Helper()
+		taken, err := cache.TakeToken(context.Background(), key, 2, 2, at)
+		require.NoError(t, err)
+		return taken
+	}
+
+	assert.True(t, take(t, "bucket", now))
+	assert.True(t, take(t, "bucket", now))
+	assert.False(t, take(t, "bucket", now))
+	assert.True(t, take(t, "other bucket", now))
+
+	// the bucket is refilled with two tokens per second
+	assert.True(t, take(t, "bucket", now.Add(500*time.Millisecond)))
+	assert.False(t, take(t, "bucket", now.Add(500*time.Millisecond)))
+}
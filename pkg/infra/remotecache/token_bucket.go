@@ -0,0 +1,69 @@
+package remotecache
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenBucketAttempts is how many times a token is taken from a bucket with a compare and swap before giving up,
+// when other instances keep updating the bucket in the meantime
+const tokenBucketAttempts = 10
+
+// errTokenBucketConflict is returned when the bucket was updated concurrently at each attempt
+var errTokenBucketConflict = errors.New("token bucket updated concurrently")
+
+// tokenBucket is the state of a token bucket, as stored in the cache
+type tokenBucket struct {
+	tokens float64
+	// updated is when the bucket was last refilled, in milliseconds
+	updated int64
+}
+
+// take refills the bucket with rate tokens per second since it was last updated, up to burst tokens, and takes a
+// token when there is one left. A nil bucket is full.
+func (b *tokenBucket) take(rate float64, burst int64, now time.Time) (tokenBucket, bool) {
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	refilled := tokenBucket{tokens: float64(burst), updated: nowMs}
+	if b != nil {
+		// the clocks of the instances sharing the bucket may differ, a bucket is never refilled backwards
+		if b.updated > nowMs {
+			refilled.updated = b.updated
+		}
+		elapsed := float64(refilled.updated-b.updated) / 1000
+		refilled.tokens = math.Min(float64(burst), b.tokens+elapsed*rate)
+	}
+	if refilled.tokens < 1 {
+		return refilled, false
+	}
+	refilled.tokens--
+	return refilled, true
+}
+
+func (b tokenBucket) encode() []byte {
+	return []byte(strconv.FormatFloat(b.tokens, 'g', -1, 64) + ":" + strconv.FormatInt(b.updated, 10))
+}
+
+func decodeTokenBucket(data []byte) (*tokenBucket, error) {
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid token bucket %q", data)
+	}
+	tokens, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenBucket{tokens: tokens, updated: updated}, nil
+}
+
+// tokenBucketExpiration is how long a bucket is kept after its last use, by then it would be full again
+func tokenBucketExpiration(rate float64, burst int64) time.Duration {
+	return time.Duration(math.Ceil(float64(burst)/rate))*time.Second + time.Second
+}
@@ -0,0 +1,57 @@
+package remotecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	t.Run("a new bucket is full", func(t *testing.T) {
+		bucket, taken := (*tokenBucket)(nil).take(2, 3, now)
+		assert.True(t, taken)
+		assert.Equal(t, tokenBucket{tokens: 2, updated: nowMs}, bucket)
+	})
+
+	t.Run("no token is taken from an empty bucket", func(t *testing.T) {
+		bucket, taken := (&tokenBucket{tokens: 0.5, updated: nowMs}).take(2, 3, now)
+		assert.False(t, taken)
+		assert.Equal(t, tokenBucket{tokens: 0.5, updated: nowMs}, bucket)
+	})
+
+	t.Run("the bucket is refilled up to the burst", func(t *testing.T) {
+		bucket, taken := (&tokenBucket{tokens: 0.5, updated: nowMs}).take(2, 3, now.Add(250*time.Millisecond))
+		assert.True(t, taken)
+		assert.Equal(t, tokenBucket{tokens: 0, updated: nowMs + 250}, bucket)
+
+		bucket, taken = (&tokenBucket{tokens: 0, updated: nowMs}).take(2, 3, now.Add(time.Hour))
+		assert.True(t, taken)
+		assert.Equal(t, float64(2), bucket.tokens)
+	})
+
+	t.Run("the bucket is not refilled by an earlier clock", func(t *testing.T) {
+		bucket, taken := (&tokenBucket{tokens: 1, updated: nowMs}).take(2, 3, now.Add(-time.Second))
+		assert.True(t, taken)
+		assert.Equal(t, tokenBucket{tokens: 0, updated: nowMs}, bucket)
+	})
+
+	t.Run("the bucket is encoded", func(t *testing.T) {
+		bucket := tokenBucket{tokens: 1.25, updated: nowMs}
+		decoded, err := decodeTokenBucket(bucket.encode())
+		require.NoError(t, err)
+		assert.Equal(t, bucket, *decoded)
+
+		_, err = decodeTokenBucket([]byte("invalid"))
+		assert.Error(t, err)
+	})
+
+	t.Run("the bucket expires once it would be full again", func(t *testing.T) {
+		assert.Equal(t, 3*time.Second, tokenBucketExpiration(2, 3))
+		assert.Equal(t, 2*time.Second, tokenBucketExpiration(10, 10))
+	})
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/remotecache"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/web"
+)
+
+var apiKeyRateLimitCacheErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "grafana",
+	Name:      "api_key_rate_limit_cache_errors_total",
+	Help:      "Number of requests made with a rate limited API key let through because the remote cache failed.",
+})
+
+func init() {
+	prometheus.MustRegister(apiKeyRateLimitCacheErrors)
+}
+
+// APIKeyRateLimit is a middleware handler that rejects the requests made with
+// an API key over the rate limit of the key. Each key has a token bucket in
+// the remote cache, holding up to a second of requests and refilled
+// continuously, which is shared by all the instances of a high availability
+// setup. When the remote cache fails, the requests are let through so that an
+// unavailable cache does not lock automations out.
+func APIKeyRateLimit(remoteCache *remotecache.RemoteCache, apiKeyService apikey.Service, getTime getTimeFn) web.Handler {
+	logger := log.New("middleware.apikey-rate-limit")
+	return func(c *models.ReqContext) {
+		limit := c.APIKeyRateLimit
+		if limit == nil {
+			return
+		}
+
+		cacheKey := fmt.Sprintf("apikey-rate-limit-%d", limit.KeyID)
+		allowed, err := remoteCache.TakeToken(c.Req.Context(), cacheKey, float64(limit.RPS), limit.RPS, getTime())
+		if err != nil {
+			logger.Warn("Failed to check API key rate limit", "keyId", limit.KeyID, "error", err)
+			apiKeyRateLimitCacheErrors.Inc()
+			return
+		}
+
+		if !allowed {
+			apiKeyService.ReportValidation(apikey.ValidationRateLimited)
+			c.Resp.Header().Set("Retry-After", "1")
+			c.JsonApiErr(http.StatusTooManyRequests, "API key rate limit exceeded", nil)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/contexthandler"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/web"
+)
+
+// APIKeyUsage is a middleware handler that records the requests authenticated
+// with an API key for their usage statistics, by route pattern. When usage
+// insights are enabled, it also publishes an anonymized ApiKeyUsed event for
+// each of them.
+func APIKeyUsage(cfg *setting.Cfg, apiKeyService apikey.Service, bus bus.Bus) web.Middleware {
+	logger := log.New("middleware.apikey-usage")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			next.ServeHTTP(w, r)
+
+			// TODO: do not depend on web.Context from the future
+			mContext := web.FromContext(r.Context())
+			if mContext == nil {
+				return
+			}
+			reqContext := contexthandler.FromContext(mContext.Req.Context())
+			if reqContext == nil || reqContext.ApiKeyID == 0 {
+				return
+			}
+
+			route := "unknown"
+			if routeOperation, exists := routeOperationName(mContext.Req); exists {
+				route = routeOperation
+			}
+			now := time.Now()
+			apiKeyService.RecordUsage(&apikey.RecordUsageCommand{
+				Id:    reqContext.ApiKeyID,
+				OrgId: reqContext.OrgID,
+				Route: route,
+				Time:  now,
+			})
+
+			if !cfg.ApiKeyUsageInsightsEnabled {
+				return
+			}
+			err := bus.Publish(mContext.Req.Context(), &events.ApiKeyUsed{
+				Timestamp:  now,
+				KeyHash:    apiKeyUsageHash(cfg.SecretKey, reqContext.ApiKeyID),
+				OrgID:      reqContext.OrgID,
+				RouteClass: apiKeyRouteClass(route),
+				Duration:   now.Sub(start),
+			})
+			if err != nil {
+				logger.Warn("failed to publish api key usage", "error", err)
+			}
+		})
+	}
+}
+
+// apiKeyUsageHash identifies an API key in the usage insights without
+// revealing its ID. The hash is keyed with the secret key, so that the IDs,
+// which are sequential, can't be found by hashing every ID.
+func apiKeyUsageHash(secretKey string, id int64) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(strconv.FormatInt(id, 10)))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// apiKeyRouteClass groups the route patterns by area of the API, e.g.
+// /api/dashboards/uid/:uid and /api/dashboards/db are both dashboards, and
+// the data source queries and proxied requests are query and proxy.
+func apiKeyRouteClass(route string) string {
+	if !strings.HasPrefix(route, "/api/") {
+		return "other"
+	}
+	segments := strings.Split(strings.TrimPrefix(route, "/api/"), "/")
+	switch {
+	case segments[0] == "ds" || segments[0] == "tsdb":
+		return "query"
+	case segments[0] == "datasources" && (strings.Contains(route, "/proxy/") || strings.Contains(route, "/resources")):
+		return "proxy"
+	case segments[0] == "":
+		return "other"
+	}
+	return segments[0]
+}
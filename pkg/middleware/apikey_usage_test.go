@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyRouteClass(t *testing.T) {
+	tcs := []struct {
+		route    string
+		expected string
+	}{
+		{route: "/api/dashboards/uid/:uid", expected: "dashboards"},
+		{route: "/api/search/", expected: "search"},
+		{route: "/api/ds/query", expected: "query"},
+		{route: "/api/tsdb/query", expected: "query"},
+		{route: "/api/datasources/proxy/uid/:uid/*", expected: "proxy"},
+		{route: "/api/datasources/uid/:uid/resources/*", expected: "proxy"},
+		{route: "/api/datasources/uid/:uid", expected: "datasources"},
+		{route: "/metrics", expected: "other"},
+		{route: "unknown", expected: "other"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.expected, apiKeyRouteClass(tc.route), tc.route)
+	}
+}
+
+func TestAPIKeyUsageHash(t *testing.T) {
+	hash := apiKeyUsageHash("secret", 1)
+	assert.Len(t, hash, 32)
+	assert.Equal(t, hash, apiKeyUsageHash("secret", 1))
+	assert.NotEqual(t, hash, apiKeyUsageHash("secret", 2))
+	assert.NotEqual(t, hash, apiKeyUsageHash("other secret", 1))
+}
@@ -154,7 +154,7 @@ func TestMiddlewareContext(t *testing.T) {
 		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
 		require.NoError(t, err)
 
-		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{OrgId: orgID, Role: org.RoleEditor, Key: keyhash}
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: orgID, Role: org.RoleEditor, Key: keyhash}
 
 		sc.fakeReq("GET", "/").withValidApiKey().exec()
 
@@ -167,7 +167,7 @@ func TestMiddlewareContext(t *testing.T) {
 
 	middlewareScenario(t, "Valid API key, but does not match DB hash", func(t *testing.T, sc *scenarioContext) {
 		const keyhash = "Something_not_matching"
-		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{OrgId: 12, Role: org.RoleEditor, Key: keyhash}
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: 12, Role: org.RoleEditor, Key: keyhash}
 
 		sc.fakeReq("GET", "/").withValidApiKey().exec()
 
@@ -175,6 +175,206 @@ func TestMiddlewareContext(t *testing.T) {
 		assert.Equal(t, contexthandler.InvalidAPIKey, sc.respJson["message"])
 	})
 
+	middlewareScenario(t, "Rotated API key within grace period", func(t *testing.T, sc *scenarioContext) {
+		sc.contextHandler.GetTime = fakeGetTime()
+
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		previousKeyExpires := sc.contextHandler.GetTime().Add(time.Hour).Unix()
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: 12, Role: org.RoleEditor, Key: "rotated_hash", PreviousKey: &keyhash, PreviousKeyExpires: &previousKeyExpires}
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+
+		require.Equal(t, 200, sc.resp.Code)
+		assert.True(t, sc.context.IsSignedIn)
+	})
+
+	middlewareScenario(t, "Rotated API key after grace period", func(t *testing.T, sc *scenarioContext) {
+		sc.contextHandler.GetTime = fakeGetTime()
+
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		previousKeyExpires := sc.contextHandler.GetTime().Add(-1 * time.Second).Unix()
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: 12, Role: org.RoleEditor, Key: "rotated_hash", PreviousKey: &keyhash, PreviousKeyExpires: &previousKeyExpires}
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+
+		assert.Equal(t, 401, sc.resp.Code)
+		assert.Equal(t, contexthandler.InvalidAPIKey, sc.respJson["message"])
+	})
+
+	middlewareScenario(t, "API key with its secondary secret", func(t *testing.T, sc *scenarioContext) {
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: 12, Role: org.RoleEditor, Key: "current_hash", SecondaryKey: &keyhash}
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+
+		require.Equal(t, 200, sc.resp.Code)
+		assert.True(t, sc.context.IsSignedIn)
+	})
+
+	middlewareScenario(t, "Valid API key from an allowed network", func(t *testing.T, sc *scenarioContext) {
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: 12, Role: org.RoleEditor, Key: keyhash, AllowedIPRanges: "192.168.0.0/16,10.0.0.0/8"}
+
+		sc.fakeReq("GET", "/").withValidApiKey()
+		sc.req.RemoteAddr = "10.1.2.3:51234"
+		sc.exec()
+
+		require.Equal(t, 200, sc.resp.Code)
+		assert.True(t, sc.context.IsSignedIn)
+	})
+
+	middlewareScenario(t, "Valid API key outside of its access windows", func(t *testing.T, sc *scenarioContext) {
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		now := time.Now().UTC()
+		window := apikey.AccessWindow{Start: now.Add(time.Hour).Format("15:04"), End: now.Add(2 * time.Hour).Format("15:04")}
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: 12, Role: org.RoleEditor, Key: keyhash, AccessWindows: []apikey.AccessWindow{window}}
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+
+		assert.Equal(t, 401, sc.resp.Code)
+		assert.Equal(t, "API key cannot be used outside of its access windows", sc.respJson["message"])
+	})
+
+	middlewareScenario(t, "Valid API key restricted to data sources", func(t *testing.T, sc *scenarioContext) {
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: 12, Role: org.RoleEditor, Key: keyhash, DatasourceUIDs: "prometheus,loki"}
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+
+		require.Equal(t, 200, sc.resp.Code)
+		assert.True(t, sc.context.CanQueryDatasource("loki"))
+		assert.False(t, sc.context.CanQueryDatasource("postgres"))
+	})
+
+	middlewareScenario(t, "Valid API key from a network that is not allowed", func(t *testing.T, sc *scenarioContext) {
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: 12, Role: org.RoleEditor, Key: keyhash, AllowedIPRanges: "192.168.0.0/16"}
+
+		sc.fakeReq("GET", "/").withValidApiKey()
+		sc.req.RemoteAddr = "10.1.2.3:51234"
+		sc.req.Header.Set("X-Forwarded-For", "192.168.1.1")
+		sc.exec()
+
+		assert.Equal(t, 401, sc.resp.Code)
+		assert.Equal(t, "API key is not allowed from this IP address", sc.respJson["message"])
+	})
+
+	middlewareScenario(t, "Valid API key pending approval", func(t *testing.T, sc *scenarioContext) {
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: 12, Role: org.RoleEditor, Key: keyhash, Status: apikey.StatusPending}
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+
+		assert.Equal(t, 401, sc.resp.Code)
+		assert.Equal(t, "API key is pending approval", sc.respJson["message"])
+	})
+
+	middlewareScenario(t, "Signing API key sent as a bearer token", func(t *testing.T, sc *scenarioContext) {
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: 12, Role: org.RoleEditor, Key: keyhash, Type: apikey.TypeSigning}
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+
+		assert.Equal(t, 401, sc.resp.Code)
+		assert.Equal(t, "API key only accepts signed requests", sc.respJson["message"])
+	})
+
+	middlewareScenario(t, "Valid signed request", func(t *testing.T, sc *scenarioContext) {
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", Id: 42, OrgId: 12, Role: org.RoleEditor, Type: apikey.TypeSigning}
+
+		sc.fakeReq("GET", "/")
+		sc.req.Header.Set(apikey.SignatureKeyIDHeader, "42")
+		sc.req.Header.Set(apikey.SignatureTimestampHeader, "1660000000")
+		sc.req.Header.Set(apikey.SignatureHeader, "abcd")
+		sc.exec()
+
+		require.Equal(t, 200, sc.resp.Code)
+		assert.True(t, sc.context.IsSignedIn)
+		assert.Equal(t, int64(42), sc.context.ApiKeyID)
+		assert.Equal(t, int64(12), sc.context.OrgID)
+	})
+
+	middlewareScenario(t, "Signed request with an invalid signature", func(t *testing.T, sc *scenarioContext) {
+		sc.apiKeyService.ExpectedError = apikey.ErrInvalid
+
+		sc.fakeReq("GET", "/")
+		sc.req.Header.Set(apikey.SignatureKeyIDHeader, "42")
+		sc.req.Header.Set(apikey.SignatureTimestampHeader, "1660000000")
+		sc.req.Header.Set(apikey.SignatureHeader, "abcd")
+		sc.exec()
+
+		assert.Equal(t, 401, sc.resp.Code)
+		assert.Equal(t, contexthandler.InvalidAPIKey, sc.respJson["message"])
+	})
+
+	middlewareScenario(t, "Signed request with a body over the limit", func(t *testing.T, sc *scenarioContext) {
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", Id: 42, OrgId: 12, Role: org.RoleEditor, Type: apikey.TypeSigning}
+
+		sc.fakeReq("GET", "/")
+		sc.req.Body = io.NopCloser(strings.NewReader("more than eight bytes"))
+		sc.req.Header.Set(apikey.SignatureKeyIDHeader, "42")
+		sc.req.Header.Set(apikey.SignatureTimestampHeader, "1660000000")
+		sc.req.Header.Set(apikey.SignatureHeader, "abcd")
+		sc.exec()
+
+		assert.Equal(t, 413, sc.resp.Code)
+		assert.Equal(t, "Request body too large", sc.respJson["message"])
+	}, func(cfg *setting.Cfg) {
+		cfg.ApiKeySignedRequestMaxBodyBytes = 8
+	})
+
+	middlewareScenario(t, "Signed request with a body at the limit", func(t *testing.T, sc *scenarioContext) {
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", Id: 42, OrgId: 12, Role: org.RoleEditor, Type: apikey.TypeSigning}
+
+		sc.fakeReq("GET", "/")
+		sc.req.Body = io.NopCloser(strings.NewReader("8 bytes!"))
+		sc.req.Header.Set(apikey.SignatureKeyIDHeader, "42")
+		sc.req.Header.Set(apikey.SignatureTimestampHeader, "1660000000")
+		sc.req.Header.Set(apikey.SignatureHeader, "abcd")
+		sc.exec()
+
+		require.Equal(t, 200, sc.resp.Code)
+		assert.True(t, sc.context.IsSignedIn)
+	}, func(cfg *setting.Cfg) {
+		cfg.ApiKeySignedRequestMaxBodyBytes = 8
+	})
+
+	middlewareScenario(t, "Valid API key over its rate limit", func(t *testing.T, sc *scenarioContext) {
+		now := time.Now()
+		sc.contextHandler.GetTime = func() time.Time { return now }
+
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", Id: 42, OrgId: 12, Role: org.RoleEditor, Key: keyhash, RateLimitRPS: 1}
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+		require.Equal(t, 200, sc.resp.Code)
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+		assert.Equal(t, 429, sc.resp.Code)
+		assert.Equal(t, "API key rate limit exceeded", sc.respJson["message"])
+		assert.Equal(t, "1", sc.resp.Header().Get("Retry-After"))
+	})
+
 	middlewareScenario(t, "Valid API key, but expired", func(t *testing.T, sc *scenarioContext) {
 		sc.contextHandler.GetTime = fakeGetTime()
 
@@ -182,7 +382,7 @@ func TestMiddlewareContext(t *testing.T) {
 		require.NoError(t, err)
 
 		expires := sc.contextHandler.GetTime().Add(-1 * time.Second).Unix()
-		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{OrgId: 12, Role: org.RoleEditor, Key: keyhash, Expires: &expires}
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{Name: "asd", OrgId: 12, Role: org.RoleEditor, Key: keyhash, Expires: &expires}
 
 		sc.fakeReq("GET", "/").withValidApiKey().exec()
 
@@ -637,6 +837,7 @@ func middlewareScenario(t *testing.T, desc string, fn scenarioFunc, cbs ...func(
 		sc.sqlStore = ctxHdlr.SQLStore
 		sc.contextHandler = ctxHdlr
 		sc.m.Use(ctxHdlr.Middleware)
+		sc.m.Use(APIKeyRateLimit(ctxHdlr.RemoteCache, sc.apiKeyService, time.Now))
 		sc.m.Use(OrgRedirect(sc.cfg, sc.mockSQLStore))
 
 		sc.userAuthTokenService = ctxHdlr.AuthTokenService.(*auth.FakeUserAuthTokenService)
@@ -28,6 +28,16 @@ type ReqContext struct {
 
 	PerfmonTimer   prometheus.Summary
 	LookupTokenErr error
+
+	// APIKeyRateLimit is the rate limit of the API key or service account
+	// token authenticating the request, nil if it has none.
+	APIKeyRateLimit *APIKeyRateLimit
+}
+
+// APIKeyRateLimit is the number of requests per second allowed with an API key.
+type APIKeyRateLimit struct {
+	KeyID int64
+	RPS   int64
 }
 
 // Handle handles and logs error by given status.
@@ -52,5 +52,35 @@ func (sm *SecretsManagerGRPCClient) RenameSecret(ctx context.Context, req *Renam
 	return sm.SecretsManagerClient.RenameSecret(ctx, req)
 }
 
+// GetAll returns all the items of the store.
+func (sm *SecretsManagerGRPCClient) GetAllSecrets(ctx context.Context, req *GetAllSecretsRequest, opts ...grpc.CallOption) (*GetAllSecretsResponse, error) {
+	return sm.SecretsManagerClient.GetAllSecrets(ctx, req)
+}
+
+// SetSecrets sets several items in the store.
+func (sm *SecretsManagerGRPCClient) SetSecrets(ctx context.Context, req *SetSecretsRequest, opts ...grpc.CallOption) (*SetSecretsResponse, error) {
+	return sm.SecretsManagerClient.SetSecrets(ctx, req)
+}
+
+// DeleteSecrets deletes several items from the store.
+func (sm *SecretsManagerGRPCClient) DeleteSecrets(ctx context.Context, req *DeleteSecretsRequest, opts ...grpc.CallOption) (*DeleteSecretsResponse, error) {
+	return sm.SecretsManagerClient.DeleteSecrets(ctx, req)
+}
+
+// ListSecretKeys gets the keys matching a namespace pattern and a type.
+func (sm *SecretsManagerGRPCClient) ListSecretKeys(ctx context.Context, req *ListSecretKeysRequest, opts ...grpc.CallOption) (*ListSecretsResponse, error) {
+	return sm.SecretsManagerClient.ListSecretKeys(ctx, req)
+}
+
+// StreamAllSecrets returns all the items of the store, streamed in batches.
+func (sm *SecretsManagerGRPCClient) StreamAllSecrets(ctx context.Context, req *StreamAllSecretsRequest, opts ...grpc.CallOption) (SecretsManager_StreamAllSecretsClient, error) {
+	return sm.SecretsManagerClient.StreamAllSecrets(ctx, req)
+}
+
+// GetCapabilities negotiates the protocol version and returns the optional calls implemented by the plugin.
+func (sm *SecretsManagerGRPCClient) GetCapabilities(ctx context.Context, req *GetCapabilitiesRequest, opts ...grpc.CallOption) (*GetCapabilitiesResponse, error) {
+	return sm.SecretsManagerClient.GetCapabilities(ctx, req)
+}
+
 var _ SecretsManagerClient = &SecretsManagerGRPCClient{}
 var _ plugin.GRPCPlugin = &SecretsManagerGRPCPlugin{}
@@ -27,6 +27,12 @@ type SecretsManagerClient interface {
 	DeleteSecret(ctx context.Context, in *DeleteSecretRequest, opts ...grpc.CallOption) (*DeleteSecretResponse, error)
 	ListSecrets(ctx context.Context, in *ListSecretsRequest, opts ...grpc.CallOption) (*ListSecretsResponse, error)
 	RenameSecret(ctx context.Context, in *RenameSecretRequest, opts ...grpc.CallOption) (*RenameSecretResponse, error)
+	GetAllSecrets(ctx context.Context, in *GetAllSecretsRequest, opts ...grpc.CallOption) (*GetAllSecretsResponse, error)
+	SetSecrets(ctx context.Context, in *SetSecretsRequest, opts ...grpc.CallOption) (*SetSecretsResponse, error)
+	DeleteSecrets(ctx context.Context, in *DeleteSecretsRequest, opts ...grpc.CallOption) (*DeleteSecretsResponse, error)
+	ListSecretKeys(ctx context.Context, in *ListSecretKeysRequest, opts ...grpc.CallOption) (*ListSecretsResponse, error)
+	StreamAllSecrets(ctx context.Context, in *StreamAllSecretsRequest, opts ...grpc.CallOption) (SecretsManager_StreamAllSecretsClient, error)
+	GetCapabilities(ctx context.Context, in *GetCapabilitiesRequest, opts ...grpc.CallOption) (*GetCapabilitiesResponse, error)
 }
 
 type secretsManagerClient struct {
@@ -82,6 +88,83 @@ func (c *secretsManagerClient) RenameSecret(ctx context.Context, in *RenameSecre
 	return out, nil
 }
 
+func (c *secretsManagerClient) GetAllSecrets(ctx context.Context, in *GetAllSecretsRequest, opts ...grpc.CallOption) (*GetAllSecretsResponse, error) {
+	out := new(GetAllSecretsResponse)
+	err := c.cc.Invoke(ctx, "/secretsmanagerplugin.SecretsManager/GetAllSecrets", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *secretsManagerClient) SetSecrets(ctx context.Context, in *SetSecretsRequest, opts ...grpc.CallOption) (*SetSecretsResponse, error) {
+	out := new(SetSecretsResponse)
+	err := c.cc.Invoke(ctx, "/secretsmanagerplugin.SecretsManager/SetSecrets", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *secretsManagerClient) DeleteSecrets(ctx context.Context, in *DeleteSecretsRequest, opts ...grpc.CallOption) (*DeleteSecretsResponse, error) {
+	out := new(DeleteSecretsResponse)
+	err := c.cc.Invoke(ctx, "/secretsmanagerplugin.SecretsManager/DeleteSecrets", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *secretsManagerClient) ListSecretKeys(ctx context.Context, in *ListSecretKeysRequest, opts ...grpc.CallOption) (*ListSecretsResponse, error) {
+	out := new(ListSecretsResponse)
+	err := c.cc.Invoke(ctx, "/secretsmanagerplugin.SecretsManager/ListSecretKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *secretsManagerClient) StreamAllSecrets(ctx context.Context, in *StreamAllSecretsRequest, opts ...grpc.CallOption) (SecretsManager_StreamAllSecretsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &SecretsManager_ServiceDesc.Streams[0], "/secretsmanagerplugin.SecretsManager/StreamAllSecrets", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &secretsManagerStreamAllSecretsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type SecretsManager_StreamAllSecretsClient interface {
+	Recv() (*GetAllSecretsResponse, error)
+	grpc.ClientStream
+}
+
+type secretsManagerStreamAllSecretsClient struct {
+	grpc.ClientStream
+}
+
+func (x *secretsManagerStreamAllSecretsClient) Recv() (*GetAllSecretsResponse, error) {
+	m := new(GetAllSecretsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *secretsManagerClient) GetCapabilities(ctx context.Context, in *GetCapabilitiesRequest, opts ...grpc.CallOption) (*GetCapabilitiesResponse, error) {
+	out := new(GetCapabilitiesResponse)
+	err := c.cc.Invoke(ctx, "/secretsmanagerplugin.SecretsManager/GetCapabilities", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // SecretsManagerServer is the server API for SecretsManager service.
 // All implementations must embed UnimplementedSecretsManagerServer
 // for forward compatibility
@@ -91,6 +174,12 @@ type SecretsManagerServer interface {
 	DeleteSecret(context.Context, *DeleteSecretRequest) (*DeleteSecretResponse, error)
 	ListSecrets(context.Context, *ListSecretsRequest) (*ListSecretsResponse, error)
 	RenameSecret(context.Context, *RenameSecretRequest) (*RenameSecretResponse, error)
+	GetAllSecrets(context.Context, *GetAllSecretsRequest) (*GetAllSecretsResponse, error)
+	SetSecrets(context.Context, *SetSecretsRequest) (*SetSecretsResponse, error)
+	DeleteSecrets(context.Context, *DeleteSecretsRequest) (*DeleteSecretsResponse, error)
+	ListSecretKeys(context.Context, *ListSecretKeysRequest) (*ListSecretsResponse, error)
+	StreamAllSecrets(*StreamAllSecretsRequest, SecretsManager_StreamAllSecretsServer) error
+	GetCapabilities(context.Context, *GetCapabilitiesRequest) (*GetCapabilitiesResponse, error)
 	mustEmbedUnimplementedSecretsManagerServer()
 }
 
@@ -113,6 +202,24 @@ func (UnimplementedSecretsManagerServer) ListSecrets(context.Context, *ListSecre
 func (UnimplementedSecretsManagerServer) RenameSecret(context.Context, *RenameSecretRequest) (*RenameSecretResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RenameSecret not implemented")
 }
+func (UnimplementedSecretsManagerServer) GetAllSecrets(context.Context, *GetAllSecretsRequest) (*GetAllSecretsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAllSecrets not implemented")
+}
+func (UnimplementedSecretsManagerServer) SetSecrets(context.Context, *SetSecretsRequest) (*SetSecretsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetSecrets not implemented")
+}
+func (UnimplementedSecretsManagerServer) DeleteSecrets(context.Context, *DeleteSecretsRequest) (*DeleteSecretsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSecrets not implemented")
+}
+func (UnimplementedSecretsManagerServer) ListSecretKeys(context.Context, *ListSecretKeysRequest) (*ListSecretsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSecretKeys not implemented")
+}
+func (UnimplementedSecretsManagerServer) StreamAllSecrets(*StreamAllSecretsRequest, SecretsManager_StreamAllSecretsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamAllSecrets not implemented")
+}
+func (UnimplementedSecretsManagerServer) GetCapabilities(context.Context, *GetCapabilitiesRequest) (*GetCapabilitiesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCapabilities not implemented")
+}
 func (UnimplementedSecretsManagerServer) mustEmbedUnimplementedSecretsManagerServer() {}
 
 // UnsafeSecretsManagerServer may be embedded to opt out of forward compatibility for this service.
@@ -216,6 +323,117 @@ func _SecretsManager_RenameSecret_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SecretsManager_GetAllSecrets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAllSecretsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SecretsManagerServer).GetAllSecrets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/secretsmanagerplugin.SecretsManager/GetAllSecrets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SecretsManagerServer).GetAllSecrets(ctx, req.(*GetAllSecretsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SecretsManager_SetSecrets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetSecretsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SecretsManagerServer).SetSecrets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/secretsmanagerplugin.SecretsManager/SetSecrets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SecretsManagerServer).SetSecrets(ctx, req.(*SetSecretsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SecretsManager_DeleteSecrets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteSecretsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SecretsManagerServer).DeleteSecrets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/secretsmanagerplugin.SecretsManager/DeleteSecrets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SecretsManagerServer).DeleteSecrets(ctx, req.(*DeleteSecretsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SecretsManager_ListSecretKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSecretKeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SecretsManagerServer).ListSecretKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/secretsmanagerplugin.SecretsManager/ListSecretKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SecretsManagerServer).ListSecretKeys(ctx, req.(*ListSecretKeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SecretsManager_StreamAllSecrets_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamAllSecretsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SecretsManagerServer).StreamAllSecrets(m, &secretsManagerStreamAllSecretsServer{stream})
+}
+
+type SecretsManager_StreamAllSecretsServer interface {
+	Send(*GetAllSecretsResponse) error
+	grpc.ServerStream
+}
+
+type secretsManagerStreamAllSecretsServer struct {
+	grpc.ServerStream
+}
+
+func (x *secretsManagerStreamAllSecretsServer) Send(m *GetAllSecretsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _SecretsManager_GetCapabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCapabilitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SecretsManagerServer).GetCapabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/secretsmanagerplugin.SecretsManager/GetCapabilities",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SecretsManagerServer).GetCapabilities(ctx, req.(*GetCapabilitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // SecretsManager_ServiceDesc is the grpc.ServiceDesc for SecretsManager service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -243,7 +461,33 @@ var SecretsManager_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RenameSecret",
 			Handler:    _SecretsManager_RenameSecret_Handler,
 		},
+		{
+			MethodName: "GetAllSecrets",
+			Handler:    _SecretsManager_GetAllSecrets_Handler,
+		},
+		{
+			MethodName: "SetSecrets",
+			Handler:    _SecretsManager_SetSecrets_Handler,
+		},
+		{
+			MethodName: "DeleteSecrets",
+			Handler:    _SecretsManager_DeleteSecrets_Handler,
+		},
+		{
+			MethodName: "ListSecretKeys",
+			Handler:    _SecretsManager_ListSecretKeys_Handler,
+		},
+		{
+			MethodName: "GetCapabilities",
+			Handler:    _SecretsManager_GetCapabilities_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamAllSecrets",
+			Handler:       _SecretsManager_StreamAllSecrets_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "secretsmanager.proto",
 }
@@ -52,6 +52,12 @@ type SecretsPluginManager interface {
 	SecretsManager() *Plugin
 }
 
+type SecretsPluginReloader interface {
+	// ReloadSecretsManager stops the secretsmanager plugin and loads it again with the current plugin settings.
+	// The plugin returned is not started.
+	ReloadSecretsManager(ctx context.Context) (*Plugin, error)
+}
+
 type StaticRouteResolver interface {
 	Routes() []*StaticRoute
 }
@@ -3,6 +3,7 @@ package manager
 import (
 	"context"
 	"errors"
+	"fmt"
 	"path/filepath"
 	"sync"
 	"time"
@@ -25,6 +26,7 @@ var _ plugins.Store = (*PluginManager)(nil)
 var _ plugins.StaticRouteResolver = (*PluginManager)(nil)
 var _ plugins.RendererManager = (*PluginManager)(nil)
 var _ plugins.SecretsPluginManager = (*PluginManager)(nil)
+var _ plugins.SecretsPluginReloader = (*PluginManager)(nil)
 
 type PluginManager struct {
 	cfg             *plugins.Cfg
@@ -128,6 +130,45 @@ func (m *PluginManager) SecretsManager() *plugins.Plugin {
 	return nil
 }
 
+// ReloadSecretsManager loads the secretsmanager plugin again, from the path of its plugin settings or else from the
+// directory it was loaded from, so that its process is started with the current plugin settings. The plugin in use
+// is only stopped once the plugin is loaded again.
+func (m *PluginManager) ReloadSecretsManager(ctx context.Context) (*plugins.Plugin, error) {
+	p := m.SecretsManager()
+	if p == nil {
+		return nil, plugins.ErrPluginNotInstalled
+	}
+
+	path := p.PluginDir
+	if settingsPath := m.cfg.PluginSettings[p.ID]["path"]; settingsPath != "" {
+		path = settingsPath
+	}
+	registered := m.registeredPlugins(ctx)
+	delete(registered, p.ID)
+	loadedPlugins, err := m.pluginLoader.Load(ctx, p.Class, []string{path}, registered)
+	if err != nil {
+		return nil, err
+	}
+	var reloaded *plugins.Plugin
+	for _, loaded := range loadedPlugins {
+		if loaded.ID == p.ID && loaded.IsSecretsManager() {
+			reloaded = loaded
+		}
+	}
+	if reloaded == nil {
+		return nil, fmt.Errorf("secretsmanager plugin %s not found in %s", p.ID, path)
+	}
+
+	if err := m.unregisterAndStop(ctx, p); err != nil {
+		return nil, err
+	}
+	if err := m.registerAndStart(ctx, reloaded); err != nil {
+		return nil, err
+	}
+	m.log.Info("Plugin reloaded", "pluginId", reloaded.ID, "path", reloaded.PluginDir)
+	return reloaded, nil
+}
+
 func (m *PluginManager) Routes() []*plugins.StaticRoute {
 	staticRoutes := make([]*plugins.StaticRoute, 0)
 
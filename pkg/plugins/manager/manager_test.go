@@ -520,6 +520,54 @@ func TestPluginManager_lifecycle_unmanaged(t *testing.T) {
 	})
 }
 
+func TestPluginManager_ReloadSecretsManager(t *testing.T) {
+	secretsManager := func(p *plugins.Plugin) {
+		p.Type = plugins.SecretsManager
+		p.PluginDir = "/var/lib/grafana/plugins/secrets"
+	}
+
+	t.Run("Plugin is loaded again from the path of its settings", func(t *testing.T) {
+		p, pc := createPlugin(t, testPluginID, "", plugins.External, false, true, secretsManager)
+		reloaded, reloadedPc := createPlugin(t, testPluginID, "", plugins.External, false, true, secretsManager)
+		loader := &fakeLoader{mockedLoadedPlugins: []*plugins.Plugin{reloaded}}
+		pm := createManager(t, func(pm *PluginManager) {
+			pm.pluginLoader = loader
+			pm.cfg.PluginSettings = map[string]map[string]string{testPluginID: {"path": "/opt/secrets"}}
+		})
+		require.NoError(t, pm.registerAndStart(context.Background(), p))
+
+		res, err := pm.ReloadSecretsManager(context.Background())
+		require.NoError(t, err)
+		require.Same(t, reloaded, res)
+		require.Equal(t, []string{"/opt/secrets"}, loader.loadedPaths)
+		require.Equal(t, 1, pc.stopCount)
+		require.True(t, pc.decommissioned)
+		require.Equal(t, 0, reloadedPc.startCount)
+		require.Same(t, reloaded, pm.SecretsManager())
+	})
+
+	t.Run("Plugin in use is kept when it can't be loaded again", func(t *testing.T) {
+		p, pc := createPlugin(t, testPluginID, "", plugins.External, false, true, secretsManager)
+		loader := &fakeLoader{}
+		pm := createManager(t, func(pm *PluginManager) {
+			pm.pluginLoader = loader
+		})
+		require.NoError(t, pm.registerAndStart(context.Background(), p))
+
+		_, err := pm.ReloadSecretsManager(context.Background())
+		require.Error(t, err)
+		require.Equal(t, []string{p.PluginDir}, loader.loadedPaths)
+		require.Equal(t, 0, pc.stopCount)
+		require.Same(t, p, pm.SecretsManager())
+	})
+
+	t.Run("Fails when there is no secrets manager plugin", func(t *testing.T) {
+		pm := createManager(t)
+		_, err := pm.ReloadSecretsManager(context.Background())
+		require.ErrorIs(t, err, plugins.ErrPluginNotInstalled)
+	})
+}
+
 func createManager(t *testing.T, cbs ...func(*PluginManager)) *PluginManager {
 	t.Helper()
 
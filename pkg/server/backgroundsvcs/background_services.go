@@ -2,6 +2,7 @@ package backgroundsvcs
 
 import (
 	"github.com/grafana/grafana/pkg/api"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/infra/metrics"
 	"github.com/grafana/grafana/pkg/infra/remotecache"
 	"github.com/grafana/grafana/pkg/infra/tracing"
@@ -10,7 +11,9 @@ import (
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/plugins/manager"
 	"github.com/grafana/grafana/pkg/registry"
+	"github.com/grafana/grafana/pkg/services/accesscontrol/decisionlog"
 	"github.com/grafana/grafana/pkg/services/alerting"
+	"github.com/grafana/grafana/pkg/services/apikey/apikeyimpl"
 	"github.com/grafana/grafana/pkg/services/cleanup"
 	"github.com/grafana/grafana/pkg/services/dashboardsnapshots"
 	"github.com/grafana/grafana/pkg/services/guardian"
@@ -23,7 +26,9 @@ import (
 	"github.com/grafana/grafana/pkg/services/provisioning"
 	"github.com/grafana/grafana/pkg/services/rendering"
 	"github.com/grafana/grafana/pkg/services/searchV2"
+	secretsKV "github.com/grafana/grafana/pkg/services/secrets/kvstore"
 	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	secretsMigrator "github.com/grafana/grafana/pkg/services/secrets/migrator"
 	"github.com/grafana/grafana/pkg/services/serviceaccounts"
 	samanager "github.com/grafana/grafana/pkg/services/serviceaccounts/manager"
 	"github.com/grafana/grafana/pkg/services/store"
@@ -42,10 +47,28 @@ func ProvideBackgroundServiceRegistry(
 	secretsService *secretsManager.SecretsService, remoteCache *remotecache.RemoteCache,
 	thumbnailsService thumbs.Service, StorageService store.StorageService, searchService searchV2.SearchService, entityEventsService store.EntityEventsService,
 	saService *samanager.ServiceAccountsService, authInfoService *authinfoservice.Implementation,
+	saTokenRotation *samanager.TokenRotationService,
+	apiKeyExpiryNotifier *apikeyimpl.ExpiryNotifier,
+	apiKeyIdleRevoker *apikeyimpl.IdleKeyRevoker,
+	apiKeyLeakNotifier *apikeyimpl.LeakNotifier,
+	apiKeyLifetimeEnforcer *apikeyimpl.LifetimeEnforcer,
+	apiKeyUsageRollup *apikeyimpl.UsageRollup,
+	apiKeyExpiredEventPublisher *apikeyimpl.ExpiredEventPublisher,
+	apiKeyService *apikeyimpl.Service,
+	expiredSecretsCleanup *secretsKV.ExpiredSecretsCleanupService,
+	dataKeyReEncryption *secretsKV.DataKeyReEncryptionService,
+	dataKeyRotation *secretsMigrator.DataKeyRotationService,
+	aadUpgrade *secretsKV.AADUpgradeService,
+	secretsIntegrity *secretsKV.SecretsIntegrityService,
+	orgSecretsCleanup *secretsKV.OrgSecretsCleanupService,
+	secretsAudit *secretsKV.AuditService,
+	accessControlDecisions *decisionlog.Service,
+	expiredKVStoreItemsCleanup *kvstore.ExpiredItemsCleanupService,
 	// Need to make sure these are initialized, is there a better place to put them?
 	_ dashboardsnapshots.Service, _ *alerting.AlertNotificationService,
 	_ serviceaccounts.Service, _ *guardian.Provider,
 	_ *plugindashboardsservice.DashboardUpdater, _ *sanitizer.Provider,
+	_ *apikeyimpl.UsageInsightsExporter,
 ) *BackgroundServiceRegistry {
 	return NewBackgroundServiceRegistry(
 		httpServer,
@@ -72,7 +95,24 @@ func ProvideBackgroundServiceRegistry(
 		searchService,
 		entityEventsService,
 		saService,
+		saTokenRotation,
 		authInfoService,
+		apiKeyExpiryNotifier,
+		apiKeyIdleRevoker,
+		apiKeyLeakNotifier,
+		apiKeyLifetimeEnforcer,
+		apiKeyUsageRollup,
+		apiKeyExpiredEventPublisher,
+		apiKeyService,
+		expiredSecretsCleanup,
+		dataKeyReEncryption,
+		dataKeyRotation,
+		aadUpgrade,
+		secretsIntegrity,
+		orgSecretsCleanup,
+		secretsAudit,
+		accessControlDecisions,
+		expiredKVStoreItemsCleanup,
 	)
 }
 
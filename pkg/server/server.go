@@ -16,12 +16,14 @@ import (
 
 	"github.com/grafana/grafana/pkg/api"
 	_ "github.com/grafana/grafana/pkg/extensions"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/infra/metrics"
 	"github.com/grafana/grafana/pkg/login"
 	"github.com/grafana/grafana/pkg/login/social"
 	"github.com/grafana/grafana/pkg/registry"
 	"github.com/grafana/grafana/pkg/services/provisioning"
+	secretsKV "github.com/grafana/grafana/pkg/services/secrets/kvstore"
 	secretsMigrations "github.com/grafana/grafana/pkg/services/secrets/kvstore/migrations"
 	"github.com/grafana/grafana/pkg/services/user"
 
@@ -39,14 +41,16 @@ type Options struct {
 	Listener    net.Listener
 }
 
-// New returns a new instance of Server.
+// New returns a new instance of Server. kvEncryption is not used, the server depends on it so that the encryption of
+// the sensitive kvstore namespaces is enabled.
 func New(opts Options, cfg *setting.Cfg, httpServer *api.HTTPServer, roleRegistry accesscontrol.RoleRegistry,
 	provisioningService provisioning.ProvisioningService, backgroundServiceProvider registry.BackgroundServiceRegistry,
 	usageStatsProvidersRegistry registry.UsageStatsProvidersRegistry, statsCollectorService *statscollector.Service,
 	secretMigrationService secretsMigrations.SecretMigrationService, userService user.Service,
+	secretsPluginReload *secretsKV.PluginReloadService, kvEncryption *kvstore.Encryption,
 ) (*Server, error) {
 	statsCollectorService.RegisterProviders(usageStatsProvidersRegistry.GetServices())
-	s, err := newServer(opts, cfg, httpServer, roleRegistry, provisioningService, backgroundServiceProvider, secretMigrationService, userService)
+	s, err := newServer(opts, cfg, httpServer, roleRegistry, provisioningService, backgroundServiceProvider, secretMigrationService, userService, secretsPluginReload)
 	if err != nil {
 		return nil, err
 	}
@@ -61,6 +65,7 @@ func New(opts Options, cfg *setting.Cfg, httpServer *api.HTTPServer, roleRegistr
 func newServer(opts Options, cfg *setting.Cfg, httpServer *api.HTTPServer, roleRegistry accesscontrol.RoleRegistry,
 	provisioningService provisioning.ProvisioningService, backgroundServiceProvider registry.BackgroundServiceRegistry,
 	secretMigrationService secretsMigrations.SecretMigrationService, userService user.Service,
+	secretsPluginReload *secretsKV.PluginReloadService,
 ) (*Server, error) {
 	rootCtx, shutdownFn := context.WithCancel(context.Background())
 	childRoutines, childCtx := errgroup.WithContext(rootCtx)
@@ -82,6 +87,7 @@ func newServer(opts Options, cfg *setting.Cfg, httpServer *api.HTTPServer, roleR
 		backgroundServices:     backgroundServiceProvider.GetServices(),
 		secretMigrationService: secretMigrationService,
 		userService:            userService,
+		secretsPluginReload:    secretsPluginReload,
 	}
 
 	return s, nil
@@ -110,6 +116,7 @@ type Server struct {
 	provisioningService    provisioning.ProvisioningService
 	secretMigrationService secretsMigrations.SecretMigrationService
 	userService            user.Service
+	secretsPluginReload    *secretsKV.PluginReloadService
 }
 
 // init initializes the server and its services.
@@ -208,6 +215,16 @@ func (s *Server) Shutdown(ctx context.Context, reason string) error {
 	return err
 }
 
+// ReloadSecretsPlugin restarts the secrets manager plugin with the current plugin settings, when the secrets are
+// stored in the plugin.
+func (s *Server) ReloadSecretsPlugin(ctx context.Context) error {
+	err := s.secretsPluginReload.Reload(ctx)
+	if errors.Is(err, secretsKV.ErrSecretsPluginNotReloadable) {
+		return nil
+	}
+	return err
+}
+
 // ExitCode returns an exit code for a given error.
 func (s *Server) ExitCode(runError error) int {
 	if runError != nil {
@@ -1,16 +1,18 @@
 package server
 
 import (
+	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/services/notifications"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 )
 
-func ProvideTestEnv(server *Server, store *sqlstore.SQLStore, ns *notifications.NotificationServiceMock) (*TestEnv, error) {
-	return &TestEnv{server, store, ns}, nil
+func ProvideTestEnv(server *Server, store *sqlstore.SQLStore, ns *notifications.NotificationServiceMock, kv kvstore.KVStore) (*TestEnv, error) {
+	return &TestEnv{server, store, ns, kv}, nil
 }
 
 type TestEnv struct {
 	Server              *Server
 	SQLStore            *sqlstore.SQLStore
 	NotificationService *notifications.NotificationServiceMock
+	KVStore             kvstore.KVStore
 }
@@ -4,6 +4,7 @@
 package server
 
 import (
+	"github.com/benbjohnson/clock"
 	"github.com/google/wire"
 	sdkhttpclient "github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
 	"github.com/grafana/grafana/pkg/services/auth"
@@ -39,8 +40,10 @@ import (
 	"github.com/grafana/grafana/pkg/plugins/manager/registry"
 	"github.com/grafana/grafana/pkg/plugins/plugincontext"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/accesscontrol/decisionlog"
 	"github.com/grafana/grafana/pkg/services/accesscontrol/ossaccesscontrol"
 	"github.com/grafana/grafana/pkg/services/alerting"
+	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/services/apikey/apikeyimpl"
 	"github.com/grafana/grafana/pkg/services/auth/jwt"
 	"github.com/grafana/grafana/pkg/services/cleanup"
@@ -158,7 +161,11 @@ var wireBasicSet = wire.NewSet(
 	routing.ProvideRegister,
 	wire.Bind(new(routing.RouteRegister), new(*routing.RouteRegisterImpl)),
 	hooks.ProvideService,
-	kvstore.ProvideService,
+	kvstore.ProvideStore,
+	kvstore.ProvideEncryption,
+	kvstore.ProvideLeaseService,
+	kvstore.ProvideExpiredItemsCleanupService,
+	clock.New,
 	localcache.ProvideService,
 	updatechecker.ProvideGrafanaService,
 	updatechecker.ProvidePluginsService,
@@ -263,6 +270,7 @@ var wireBasicSet = wire.NewSet(
 	ossaccesscontrol.ProvideServiceAccountPermissions,
 	wire.Bind(new(accesscontrol.ServiceAccountPermissionsService), new(*ossaccesscontrol.ServiceAccountPermissionsService)),
 	serviceaccountsmanager.ProvideServiceAccountsService,
+	serviceaccountsmanager.ProvideTokenRotationService,
 	wire.Bind(new(serviceaccounts.Service), new(*serviceaccountsmanager.ServiceAccountsService)),
 	expr.ProvideService,
 	teamguardianDatabase.ProvideTeamGuardianStore,
@@ -302,9 +310,18 @@ var wireBasicSet = wire.NewSet(
 	wire.Bind(new(accesscontrol.FolderPermissionsService), new(*ossaccesscontrol.FolderPermissionsService)),
 	ossaccesscontrol.ProvideDashboardPermissions,
 	wire.Bind(new(accesscontrol.DashboardPermissionsService), new(*ossaccesscontrol.DashboardPermissionsService)),
+	decisionlog.ProvideService,
 	starimpl.ProvideService,
 	playlistimpl.ProvideService,
 	apikeyimpl.ProvideService,
+	wire.Bind(new(apikey.Service), new(*apikeyimpl.Service)),
+	apikeyimpl.ProvideExpiryNotifier,
+	apikeyimpl.ProvideIdleKeyRevoker,
+	apikeyimpl.ProvideLeakNotifier,
+	apikeyimpl.ProvideUsageInsightsExporter,
+	apikeyimpl.ProvideLifetimeEnforcer,
+	apikeyimpl.ProvideUsageRollup,
+	apikeyimpl.ProvideExpiredEventPublisher,
 	dashverimpl.ProvideService,
 	publicdashboardsService.ProvideService,
 	wire.Bind(new(publicdashboards.Service), new(*publicdashboardsService.PublicDashboardServiceImpl)),
@@ -317,6 +334,22 @@ var wireBasicSet = wire.NewSet(
 	loginattemptimpl.ProvideService,
 	datasourceservice.ProvideDataSourceMigrationService,
 	secretsStore.ProvidePluginSecretMigrationService,
+	secretsStore.ProvideExpiredSecretsCleanupService,
+	secretsStore.ProvideDataKeyReEncryptionService,
+	secretsStore.ProvideSecretsIntegrityService,
+	secretsStore.ProvideOrgSecretsCleanupService,
+	secretsStore.ProvideAADUpgradeService,
+	secretsMigrator.ProvideDataKeyRotationService,
+	secretsStore.ProvideAuditService,
+	secretsStore.ProvideSecretsExportService,
+	secretsStore.ProvideHealthService,
+	secretsStore.ProvidePluginQuarantineService,
+	secretsStore.ProvidePluginReloadService,
+	secretsStore.ProvideSecretsRoutingService,
+	secretsStore.ProvideValidationService,
+	secretsStore.ProvideAccessControlService,
+	secretsStore.ProvideSharedCacheService,
+	secretsStore.ProvideSecretsConsistencyService,
 	secretsMigrations.ProvideSecretMigrationService,
 	wire.Bind(new(secretsMigrations.SecretMigrationService), new(*secretsMigrations.SecretMigrationServiceImpl)),
 	userauthimpl.ProvideService,
@@ -4,7 +4,9 @@ import (
 	"context"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 )
@@ -132,7 +134,7 @@ func deletePermissions(sess *sqlstore.DBSession, ids []int64) error {
 }
 
 func (s *AccessControlStore) DeleteUserPermissions(ctx context.Context, orgID, userID int64) error {
-	err := s.sql.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+	err := s.sql.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
 		roleDeleteQuery := "DELETE FROM user_role WHERE user_id = ?"
 		roleDeleteParams := []interface{}{roleDeleteQuery, userID}
 		if orgID != accesscontrol.GlobalOrgID {
@@ -151,6 +153,10 @@ func (s *AccessControlStore) DeleteUserPermissions(ctx context.Context, orgID, u
 			if _, err := sess.Exec("DELETE FROM permission WHERE scope = ?", accesscontrol.Scope("users", "id", strconv.FormatInt(userID, 10))); err != nil {
 				return err
 			}
+			// the permissions scoped to the user were granted to other users
+			sess.PublishAfterCommit(&events.AccessControlPermissionsChanged{Timestamp: time.Now(), OrgID: accesscontrol.GlobalOrgID, UserID: 0})
+		} else {
+			sess.PublishAfterCommit(&events.AccessControlPermissionsChanged{Timestamp: time.Now(), OrgID: orgID, UserID: userID})
 		}
 
 		roleQuery := "SELECT id FROM role WHERE name = ?"
@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/accesscontrol/resourcepermissions/types"
@@ -220,6 +221,23 @@ func TestAccessControlStore_DeleteUserPermissions(t *testing.T) {
 		require.NoError(t, err)
 		assert.Len(t, permissions, 1)
 	})
+
+	t.Run("expect the permission changes to be published", func(t *testing.T) {
+		store, sql := setupTestEnv(t)
+		user, _ := createUserAndTeam(t, sql, 1)
+		var changed []events.AccessControlPermissionsChanged
+		sql.Bus().AddEventListener(func(_ context.Context, e *events.AccessControlPermissionsChanged) error {
+			changed = append(changed, *e)
+			return nil
+		})
+
+		require.NoError(t, store.DeleteUserPermissions(context.Background(), 1, user.ID))
+		require.NoError(t, store.DeleteUserPermissions(context.Background(), accesscontrol.GlobalOrgID, user.ID))
+
+		require.Len(t, changed, 2)
+		assert.Equal(t, events.AccessControlPermissionsChanged{Timestamp: changed[0].Timestamp, OrgID: 1, UserID: user.ID}, changed[0])
+		assert.Equal(t, events.AccessControlPermissionsChanged{Timestamp: changed[1].Timestamp, OrgID: accesscontrol.GlobalOrgID, UserID: 0}, changed[1])
+	})
 }
 
 func createUserAndTeam(t *testing.T, sql *sqlstore.SQLStore, orgID int64) (*user.User, models.Team) {
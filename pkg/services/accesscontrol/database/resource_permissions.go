@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/accesscontrol/resourcepermissions/types"
@@ -72,6 +73,7 @@ func (s *AccessControlStore) setUserResourcePermission(
 		}
 	}
 
+	sess.PublishAfterCommit(&events.AccessControlPermissionsChanged{Timestamp: time.Now(), OrgID: orgID, UserID: user.ID})
 	return permission, nil
 }
 
@@ -111,6 +113,7 @@ func (s *AccessControlStore) setTeamResourcePermission(
 		}
 	}
 
+	sess.PublishAfterCommit(&events.AccessControlPermissionsChanged{Timestamp: time.Now(), OrgID: orgID, UserID: 0})
 	return permission, nil
 }
 
@@ -154,6 +157,7 @@ func (s *AccessControlStore) setBuiltInResourcePermission(
 		}
 	}
 
+	sess.PublishAfterCommit(&events.AccessControlPermissionsChanged{Timestamp: time.Now(), OrgID: orgID, UserID: 0})
 	return permission, nil
 }
 
@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/accesscontrol/resourcepermissions/types"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
@@ -314,10 +315,18 @@ func TestAccessControlStore_SetResourcePermissions(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.desc, func(t *testing.T) {
-			store, _ := setupTestEnv(t)
+			store, sql := setupTestEnv(t)
+			var changed []int64
+			sql.Bus().AddEventListener(func(_ context.Context, e *events.AccessControlPermissionsChanged) error {
+				assert.Equal(t, tt.orgID, e.OrgID)
+				changed = append(changed, e.UserID)
+				return nil
+			})
 
 			permissions, err := store.SetResourcePermissions(context.Background(), tt.orgID, tt.commands, types.ResourceHooks{})
 			require.NoError(t, err)
+			// the changes of the permissions of a team or a basic role are published without user
+			assert.Equal(t, []int64{1, 0, 0}, changed)
 
 			require.Len(t, permissions, len(tt.commands))
 			for i, c := range tt.commands {
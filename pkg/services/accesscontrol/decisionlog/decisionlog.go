@@ -0,0 +1,253 @@
+package decisionlog
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/util"
+	"xorm.io/xorm"
+)
+
+const (
+	bufferSize      = 1000
+	flushInterval   = time.Second
+	cleanupInterval = time.Hour
+)
+
+var droppedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.ExporterName,
+	Name:      "access_control_decision_log_dropped_total",
+	Help:      "Number of access control decisions that were dropped because the buffer was full or could not be written",
+})
+
+func init() {
+	prometheus.MustRegister(droppedCounter)
+}
+
+// Decision is the evaluation of a logged action for a user
+type Decision struct {
+	Id       int64  `json:"id"`
+	OrgId    int64  `json:"orgId"`
+	UserId   int64  `json:"userId"`
+	ApiKeyId int64  `json:"apiKeyId"`
+	Action   string `json:"action"`
+	// Scopes are the scopes the action was evaluated against, once resolved
+	Scopes  []string `json:"scopes"`
+	Allowed bool     `json:"allowed"`
+	// MatchedScope is the scope of the permission of the user that allowed the action
+	MatchedScope string    `json:"matchedScope"`
+	Created      time.Time `json:"created"`
+}
+
+func (d *Decision) TableName() string {
+	return "accesscontrol_decision"
+}
+
+// Query filters the decisions, empty fields match every decision
+type Query struct {
+	OrgId   *int64
+	UserId  *int64
+	Action  string
+	Allowed *bool
+	From    time.Time
+	To      time.Time
+	Page    int
+	PerPage int
+}
+
+// QueryResult is a page of the decisions, the most recent first
+type QueryResult struct {
+	TotalCount int64       `json:"totalCount"`
+	Decisions  []*Decision `json:"decisions"`
+	Page       int         `json:"page"`
+	PerPage    int         `json:"perPage"`
+}
+
+// Service records the access control decisions taken for the logged actions in an append-only table. Decisions are
+// buffered and written in batches by Run, and deleted once they are older than the retention.
+type Service struct {
+	enabled   bool
+	actions   []string
+	retention time.Duration
+	sqlStore  sqlstore.Store
+	decisions chan *Decision
+	now       func() time.Time
+	log       log.Logger
+}
+
+func ProvideService(cfg *setting.Cfg, sqlStore sqlstore.Store) *Service {
+	section := cfg.SectionWithEnvOverrides("rbac")
+	return &Service{
+		enabled:   section.Key("decision_log_enabled").MustBool(false),
+		actions:   util.SplitString(section.Key("decision_log_actions").MustString("apikeys:*, secrets:*")),
+		retention: section.Key("decision_log_retention").MustDuration(30 * 24 * time.Hour),
+		sqlStore:  sqlStore,
+		decisions: make(chan *Decision, bufferSize),
+		now:       time.Now,
+		log:       log.New("accesscontrol.decisionlog"),
+	}
+}
+
+func (s *Service) IsDisabled() bool {
+	return s == nil || !s.enabled
+}
+
+// Record adds the decisions taken for the logged actions of evaluator to the log
+func (s *Service) Record(usr *user.SignedInUser, evaluator accesscontrol.Evaluator, permissions map[string][]string) {
+	if s.IsDisabled() {
+		return
+	}
+
+	for _, decision := range accesscontrol.ExplainEvaluation(evaluator, permissions) {
+		if !s.logged(decision.Action) {
+			continue
+		}
+
+		entry := &Decision{
+			OrgId:        usr.OrgID,
+			UserId:       usr.UserID,
+			ApiKeyId:     usr.ApiKeyID,
+			Action:       decision.Action,
+			Scopes:       decision.Scopes,
+			Allowed:      decision.Allowed,
+			MatchedScope: decision.MatchedScope,
+			Created:      s.now(),
+		}
+		if entry.Scopes == nil {
+			entry.Scopes = []string{}
+		}
+
+		select {
+		case s.decisions <- entry:
+		default:
+			droppedCounter.Inc()
+			s.log.Warn("access control decision log buffer is full, dropping decision", "action", decision.Action, "orgId", usr.OrgID, "userId", usr.UserID)
+		}
+	}
+}
+
+// logged returns whether the decisions taken for action are logged, an action ending with * matches every action
+// with its prefix
+func (s *Service) logged(action string) bool {
+	for _, pattern := range s.actions {
+		if pattern == action || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(action, pattern[:len(pattern)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) Run(ctx context.Context) error {
+	if s.IsDisabled() {
+		return nil
+	}
+
+	flushTicker := time.NewTicker(flushInterval)
+	defer flushTicker.Stop()
+	cleanupTicker := time.NewTicker(cleanupInterval)
+	defer cleanupTicker.Stop()
+
+	for {
+		select {
+		case <-flushTicker.C:
+			s.flush(ctx)
+		case <-cleanupTicker.C:
+			if err := s.deleteExpired(ctx, s.now()); err != nil {
+				s.log.Error("failed to delete expired access control decisions", "error", err)
+			}
+		case <-ctx.Done():
+			// the last decisions are written even though the server is stopping
+			s.flush(context.Background())
+			return ctx.Err()
+		}
+	}
+}
+
+// flush writes the buffered decisions
+func (s *Service) flush(ctx context.Context) {
+	var batch []*Decision
+loop:
+	for len(batch) < bufferSize {
+		select {
+		case decision := <-s.decisions:
+			batch = append(batch, decision)
+		default:
+			break loop
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	err := s.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		_, err := dbSession.InsertMulti(batch)
+		return err
+	})
+	if err != nil {
+		droppedCounter.Add(float64(len(batch)))
+		s.log.Error("failed to write access control decisions", "count", len(batch), "error", err)
+	}
+}
+
+func (s *Service) deleteExpired(ctx context.Context, now time.Time) error {
+	return s.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		_, err := dbSession.Where("created < ?", now.Add(-s.retention)).Delete(&Decision{})
+		return err
+	})
+}
+
+// Search returns a page of the decisions
+func (s *Service) Search(ctx context.Context, query Query) (*QueryResult, error) {
+	if query.PerPage <= 0 {
+		query.PerPage = 100
+	}
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+
+	result := &QueryResult{Decisions: []*Decision{}, Page: query.Page, PerPage: query.PerPage}
+	err := s.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		filter := func() *xorm.Session {
+			sess := dbSession.Table(&Decision{})
+			if query.OrgId != nil {
+				sess.Where("org_id = ?", *query.OrgId)
+			}
+			if query.UserId != nil {
+				sess.Where("user_id = ?", *query.UserId)
+			}
+			if query.Action != "" {
+				sess.Where("action = ?", query.Action)
+			}
+			if query.Allowed != nil {
+				sess.Where("allowed = ?", *query.Allowed)
+			}
+			if !query.From.IsZero() {
+				sess.Where("created >= ?", query.From)
+			}
+			if !query.To.IsZero() {
+				sess.Where("created <= ?", query.To)
+			}
+			return sess
+		}
+
+		var err error
+		if result.TotalCount, err = filter().Count(); err != nil {
+			return err
+		}
+		return filter().Desc("id").Limit(query.PerPage, (query.Page-1)*query.PerPage).Find(&result.Decisions)
+	})
+	return result, err
+}
+
+func dbContext(ctx context.Context) context.Context {
+	return sqlstore.WithServiceName(ctx, "accesscontrol")
+}
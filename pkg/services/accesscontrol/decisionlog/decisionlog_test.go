@@ -0,0 +1,112 @@
+package decisionlog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestService(t *testing.T) {
+	usr := &user.SignedInUser{UserID: 2, OrgID: 1}
+	permissions := map[string][]string{
+		"apikeys:read":    {"apikeys:name:*"},
+		"secrets:read":    {"secrets:type:datasource:*"},
+		"dashboards:read": {"dashboards:*"},
+	}
+
+	t.Run("records nothing when disabled", func(t *testing.T) {
+		s := setupService(t, "decision_log_enabled = false")
+		s.Record(usr, accesscontrol.EvalPermission("apikeys:read", "apikeys:id:1"), permissions)
+		assert.Len(t, s.decisions, 0)
+
+		var nilService *Service
+		nilService.Record(usr, accesscontrol.EvalPermission("apikeys:read", "apikeys:id:1"), permissions)
+	})
+
+	t.Run("records the decisions of the logged actions", func(t *testing.T) {
+		s := setupService(t, "decision_log_enabled = true")
+		s.Record(usr, accesscontrol.EvalAll(
+			accesscontrol.EvalPermission("dashboards:read", "dashboards:uid:1"),
+			accesscontrol.EvalPermission("apikeys:read", "apikeys:id:1", "apikeys:name:ci"),
+			accesscontrol.EvalPermission("secrets:write", "secrets:type:datasource:loki"),
+		), permissions)
+		s.flush(context.Background())
+
+		result, err := s.Search(context.Background(), Query{})
+		require.NoError(t, err)
+		require.EqualValues(t, 2, result.TotalCount)
+		secrets, apikeys := result.Decisions[0], result.Decisions[1]
+		assert.Equal(t, "secrets:write", secrets.Action)
+		assert.False(t, secrets.Allowed)
+		assert.Equal(t, []string{"secrets:type:datasource:loki"}, secrets.Scopes)
+		assert.Equal(t, "", secrets.MatchedScope)
+		assert.Equal(t, "apikeys:read", apikeys.Action)
+		assert.True(t, apikeys.Allowed)
+		assert.Equal(t, []string{"apikeys:id:1", "apikeys:name:ci"}, apikeys.Scopes)
+		assert.Equal(t, "apikeys:name:*", apikeys.MatchedScope)
+		assert.Equal(t, int64(2), apikeys.UserId)
+		assert.Equal(t, int64(1), apikeys.OrgId)
+	})
+
+	t.Run("logs the configured actions", func(t *testing.T) {
+		s := setupService(t, "decision_log_enabled = true\ndecision_log_actions = dashboards:read secrets:*")
+		assert.True(t, s.logged("dashboards:read"))
+		assert.False(t, s.logged("dashboards:write"))
+		assert.True(t, s.logged("secrets:delete"))
+		assert.False(t, s.logged("apikeys:read"))
+	})
+
+	t.Run("filters and pages the decisions", func(t *testing.T) {
+		s := setupService(t, "decision_log_enabled = true")
+		for _, scope := range []string{"apikeys:id:1", "apikeys:name:ci", "apikeys:name:backup"} {
+			s.Record(usr, accesscontrol.EvalPermission("apikeys:read", scope), permissions)
+		}
+		s.Record(usr, accesscontrol.EvalPermission("apikeys:delete", "apikeys:id:1"), permissions)
+		s.Record(&user.SignedInUser{UserID: 3, OrgID: 2}, accesscontrol.EvalPermission("apikeys:read", "apikeys:id:1"), permissions)
+		s.flush(context.Background())
+
+		orgId, userId, allowed := int64(1), int64(2), true
+		result, err := s.Search(context.Background(), Query{OrgId: &orgId, UserId: &userId, Action: "apikeys:read", Allowed: &allowed, PerPage: 1, Page: 2})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, result.TotalCount)
+		require.Len(t, result.Decisions, 1)
+		assert.Equal(t, []string{"apikeys:name:ci"}, result.Decisions[0].Scopes)
+
+		allowed = false
+		result, err = s.Search(context.Background(), Query{OrgId: &orgId, Allowed: &allowed})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, result.TotalCount)
+	})
+
+	t.Run("deletes the decisions older than the retention", func(t *testing.T) {
+		s := setupService(t, "decision_log_enabled = true\ndecision_log_retention = 1h")
+		now := time.Now()
+		s.now = func() time.Time { return now.Add(-2 * time.Hour) }
+		s.Record(usr, accesscontrol.EvalPermission("apikeys:read", "apikeys:id:1"), permissions)
+		s.now = func() time.Time { return now }
+		s.Record(usr, accesscontrol.EvalPermission("apikeys:read", "apikeys:id:2"), permissions)
+		s.flush(context.Background())
+
+		require.NoError(t, s.deleteExpired(context.Background(), now))
+		result, err := s.Search(context.Background(), Query{})
+		require.NoError(t, err)
+		require.Len(t, result.Decisions, 1)
+		assert.Equal(t, []string{"apikeys:id:2"}, result.Decisions[0].Scopes)
+	})
+}
+
+func setupService(t *testing.T, config string) *Service {
+	t.Helper()
+	raw, err := ini.Load([]byte("[rbac]\n" + config))
+	require.NoError(t, err)
+	return ProvideService(&setting.Cfg{Raw: raw}, sqlstore.InitTestDB(t))
+}
@@ -33,24 +33,30 @@ type permissionEvaluator struct {
 }
 
 func (p permissionEvaluator) Evaluate(permissions map[string][]string) bool {
+	_, ok := p.matchedScope(permissions)
+	return ok
+}
+
+// matchedScope returns the scope of the user permission granting the action, empty when no scope is required
+func (p permissionEvaluator) matchedScope(permissions map[string][]string) (string, bool) {
 	userScopes, ok := permissions[p.Action]
 	if !ok {
-		return false
+		return "", false
 	}
 
 	if len(p.Scopes) == 0 {
-		return true
+		return "", true
 	}
 
 	for _, target := range p.Scopes {
 		for _, scope := range userScopes {
 			if match(scope, target) {
-				return true
+				return scope, true
 			}
 		}
 	}
 
-	return false
+	return "", false
 }
 
 func match(scope, target string) bool {
@@ -202,3 +208,38 @@ func (a anyEvaluator) GoString() string {
 
 	return fmt.Sprintf("any(%s)", strings.Join(permissions, " "))
 }
+
+// PermissionDecision is the outcome of the evaluation of one of the actions of an Evaluator
+type PermissionDecision struct {
+	Action string
+	// Scopes are the scopes the action was evaluated against
+	Scopes  []string
+	Allowed bool
+	// MatchedScope is the scope of the user permission granting the action, empty when the action was denied or
+	// did not require any scope
+	MatchedScope string
+}
+
+// ExplainEvaluation returns the decision taken for every action of the evaluator, including the ones Evaluate
+// skips once the outcome is known
+func ExplainEvaluation(evaluator Evaluator, permissions map[string][]string) []PermissionDecision {
+	switch e := evaluator.(type) {
+	case permissionEvaluator:
+		scope, ok := e.matchedScope(permissions)
+		return []PermissionDecision{{Action: e.Action, Scopes: e.Scopes, Allowed: ok, MatchedScope: scope}}
+	case allEvaluator:
+		return explainEvaluations(e.allOf, permissions)
+	case anyEvaluator:
+		return explainEvaluations(e.anyOf, permissions)
+	default:
+		return nil
+	}
+}
+
+func explainEvaluations(evaluators []Evaluator, permissions map[string][]string) []PermissionDecision {
+	var decisions []PermissionDecision
+	for _, e := range evaluators {
+		decisions = append(decisions, ExplainEvaluation(e, permissions)...)
+	}
+	return decisions
+}
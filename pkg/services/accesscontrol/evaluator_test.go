@@ -410,3 +410,33 @@ func TestEval(t *testing.T) {
 		})
 	}
 }
+
+func TestExplainEvaluation(t *testing.T) {
+	permissions := map[string][]string{
+		"apikeys:read":   {"apikeys:id:1", "apikeys:name:*"},
+		"apikeys:delete": {"apikeys:id:1"},
+		"secrets:read":   {},
+	}
+
+	t.Run("should explain the matched scope of a permission", func(t *testing.T) {
+		decisions := ExplainEvaluation(EvalPermission("apikeys:read", "apikeys:id:2", "apikeys:name:ci"), permissions)
+		assert.Equal(t, []PermissionDecision{
+			{Action: "apikeys:read", Scopes: []string{"apikeys:id:2", "apikeys:name:ci"}, Allowed: true, MatchedScope: "apikeys:name:*"},
+		}, decisions)
+	})
+
+	t.Run("should explain every action of nested evaluators", func(t *testing.T) {
+		decisions := ExplainEvaluation(EvalAny(
+			EvalPermission("apikeys:delete", "apikeys:id:1"),
+			EvalAll(
+				EvalPermission("secrets:read"),
+				EvalPermission("secrets:write", "secrets:*"),
+			),
+		), permissions)
+		assert.Equal(t, []PermissionDecision{
+			{Action: "apikeys:delete", Scopes: []string{"apikeys:id:1"}, Allowed: true, MatchedScope: "apikeys:id:1"},
+			{Action: "secrets:read", Allowed: true},
+			{Action: "secrets:write", Scopes: []string{"secrets:*"}},
+		}, decisions)
+	})
+}
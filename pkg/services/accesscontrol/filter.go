@@ -2,6 +2,7 @@ package accesscontrol
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -10,6 +11,12 @@ import (
 
 var sqlIDAcceptList = map[string]struct{}{
 	"id":               {},
+	"uid":              {},
+	"name":             {},
+	"created_by":       {},
+	"owner_team_id":    {},
+	"namespace":        {},
+	"type":             {},
 	"org_user.user_id": {},
 	"role.uid":         {},
 	"t.id":             {},
@@ -41,48 +48,184 @@ func Filter(user *user.SignedInUser, sqlID, prefix string, actions ...string) (S
 		return denyQuery, errors.New("missing permissions")
 	}
 
-	wildcards := 0
-	result := make(map[interface{}]int)
-	for _, a := range actions {
-		ids, hasWildcard := ParseScopes(prefix, user.Permissions[user.OrgID][a])
-		if hasWildcard {
-			wildcards += 1
+	rootPrefix, attributePrefix, ok := extractPrefixes(prefix)
+	if !ok {
+		return denyQuery, nil
+	}
+	attribute := SQLFilterAttribute{
+		Name:    strings.TrimSuffix(strings.TrimPrefix(attributePrefix, rootPrefix), ":"),
+		Columns: []string{sqlID},
+		Int:     strings.HasSuffix(prefix, ":id:"),
+	}
+	return NewSQLFilterBuilder(strings.TrimSuffix(rootPrefix, ":"), attribute).Build(user, actions...)
+}
+
+// SQLFilterAttribute is an attribute of the scopes of a resource, e.g. "uid" for the "datasources:uid:<uid>" scopes,
+// and the columns holding it. The values of the attributes held by several columns are separated by ':' in the
+// scopes, e.g. "secrets:type:<type>:<namespace>", and a trailing '*' matches any value of the remaining columns.
+type SQLFilterAttribute struct {
+	Name    string
+	Columns []string
+	// Int is set when the values are integers, e.g. ids
+	Int bool
+}
+
+// SQLFilterID is the "id" attribute held by column
+func SQLFilterID(column string) SQLFilterAttribute {
+	return SQLFilterAttribute{Name: "id", Columns: []string{column}, Int: true}
+}
+
+// SQLFilterUID is the "uid" attribute held by column
+func SQLFilterUID(column string) SQLFilterAttribute {
+	return SQLFilterAttribute{Name: "uid", Columns: []string{column}}
+}
+
+// SQLFilterName is the "name" attribute held by column
+func SQLFilterName(column string) SQLFilterAttribute {
+	return SQLFilterAttribute{Name: "name", Columns: []string{column}}
+}
+
+// SQLFilterBuilder creates the where clauses restricting a query to the resources a user has permissions on, by any
+// of the attributes of their scopes. The resources matched by the wildcard scopes are not listed, e.g. the clause
+// of a user with the "datasources:*" or "datasources:uid:*" scope matches every row.
+type SQLFilterBuilder struct {
+	root       string
+	attributes []SQLFilterAttribute
+}
+
+// NewSQLFilterBuilder returns a builder of the filters of the resources whose scopes start with root, e.g. "datasources"
+func NewSQLFilterBuilder(root string, attributes ...SQLFilterAttribute) *SQLFilterBuilder {
+	return &SQLFilterBuilder{root: root, attributes: attributes}
+}
+
+// Build creates a where clause matching the resources the user has every action on. The columns of the attributes
+// must be in the accept list.
+func (b *SQLFilterBuilder) Build(user *user.SignedInUser, actions ...string) (SQLFilter, error) {
+	for _, attribute := range b.attributes {
+		for _, column := range attribute.Columns {
+			if _, ok := sqlIDAcceptList[column]; !ok {
+				return denyQuery, errors.New("sqlID is not in the accept list")
+			}
+		}
+	}
+	if user == nil || user.Permissions == nil || user.Permissions[user.OrgID] == nil {
+		return denyQuery, errors.New("missing permissions")
+	}
+
+	var conditions []string
+	var args []interface{}
+	seen := map[string]struct{}{}
+	for _, action := range actions {
+		condition, conditionArgs, all := b.actionCondition(user.Permissions[user.OrgID][action])
+		if all {
 			continue
 		}
-		if len(ids) == 0 {
+		if condition == "" {
 			return denyQuery, nil
 		}
-		for id := range ids {
-			result[id] += 1
+		// the actions granted on the same resources need a single condition
+		key := fmt.Sprint(condition, conditionArgs)
+		if _, ok := seen[key]; ok {
+			continue
 		}
+		seen[key] = struct{}{}
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
 	}
 
-	// return early if every action has wildcard scope
-	if wildcards == len(actions) {
+	if len(conditions) == 0 {
 		return allowAllQuery, nil
 	}
+	if len(conditions) == 1 {
+		return SQLFilter{" " + conditions[0], args}, nil
+	}
+	return SQLFilter{" (" + strings.Join(conditions, ") AND (") + ")", args}, nil
+}
+
+// actionCondition returns the condition matching the resources of the scopes, all is set when the scopes match
+// every resource
+func (b *SQLFilterBuilder) actionCondition(scopes []string) (condition string, args []interface{}, all bool) {
+	rootPrefix := b.root + ":"
+	values := make([][][]interface{}, len(b.attributes))
+	seen := make([]map[string]struct{}, len(b.attributes))
+	for i := range seen {
+		seen[i] = map[string]struct{}{}
+	}
 
-	var ids []interface{}
-	for id, count := range result {
-		// if an id exist for every action include it in the filter
-		if count+wildcards == len(actions) {
-			ids = append(ids, id)
+	for _, scope := range scopes {
+		if scope == "*" || scope == rootPrefix+"*" {
+			return "", nil, true
+		}
+		for i, attribute := range b.attributes {
+			attributePrefix := rootPrefix + attribute.Name + ":"
+			if !strings.HasPrefix(scope, attributePrefix) {
+				continue
+			}
+			value, ok := parseSQLFilterValue(attribute, scope[len(attributePrefix):])
+			if !ok {
+				continue
+			}
+			if len(value) == 0 {
+				return "", nil, true
+			}
+			key := fmt.Sprint(value...)
+			if _, ok := seen[i][key]; !ok {
+				seen[i][key] = struct{}{}
+				values[i] = append(values[i], value)
+			}
 		}
 	}
 
-	if len(ids) == 0 {
-		return denyQuery, nil
+	var terms []string
+	for i, attribute := range b.attributes {
+		if len(values[i]) == 0 {
+			continue
+		}
+		if len(attribute.Columns) == 1 {
+			terms = append(terms, attribute.Columns[0]+" IN (?"+strings.Repeat(",?", len(values[i])-1)+")")
+			for _, value := range values[i] {
+				args = append(args, value[0])
+			}
+			continue
+		}
+		for _, value := range values[i] {
+			columns := make([]string, 0, len(value))
+			for j := range value {
+				columns = append(columns, attribute.Columns[j]+" = ?")
+			}
+			terms = append(terms, "("+strings.Join(columns, " AND ")+")")
+			args = append(args, value...)
+		}
+	}
+	if len(terms) == 1 {
+		return terms[0], args, false
 	}
+	return strings.Join(terms, " OR "), args, false
+}
 
-	query := strings.Builder{}
-	query.WriteRune(' ')
-	query.WriteString(sqlID)
-	query.WriteString(" IN ")
-	query.WriteString("(?")
-	query.WriteString(strings.Repeat(",?", len(ids)-1))
-	query.WriteRune(')')
+// parseSQLFilterValue returns the values of the columns of the attribute in value, the last columns are omitted
+// when they match any value
+func parseSQLFilterValue(attribute SQLFilterAttribute, value string) ([]interface{}, bool) {
+	parts := strings.SplitN(value, ":", len(attribute.Columns))
+	if parts[len(parts)-1] == "*" {
+		parts = parts[:len(parts)-1]
+	} else if len(parts) != len(attribute.Columns) {
+		return nil, false
+	}
 
-	return SQLFilter{query.String(), ids}, nil
+	values := make([]interface{}, 0, len(parts))
+	for _, part := range parts {
+		if attribute.Int {
+			id, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			values = append(values, id)
+			continue
+		}
+		values = append(values, part)
+	}
+	return values, true
 }
 
 func ParseScopes(prefix string, scopes []string) (ids map[interface{}]struct{}, hasWildcard bool) {
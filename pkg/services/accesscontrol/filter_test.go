@@ -202,3 +202,116 @@ func TestFilter_Datasources(t *testing.T) {
 		})
 	}
 }
+
+func TestSQLFilterBuilder_Datasources(t *testing.T) {
+	tests := []struct {
+		desc                string
+		actions             []string
+		permissions         map[string][]string
+		expectedDataSources []string
+	}{
+		{
+			desc:    "expect all data sources for wildcard name scope",
+			actions: []string{"datasources:read"},
+			permissions: map[string][]string{
+				"datasources:read": {"datasources:name:*"},
+			},
+			expectedDataSources: []string{"ds:1", "ds:2", "ds:3", "ds:4", "ds:5"},
+		},
+		{
+			desc:    "expect data sources matched by any attribute",
+			actions: []string{"datasources:read"},
+			permissions: map[string][]string{
+				"datasources:read": {"datasources:id:1", "datasources:uid:uid3", "datasources:name:ds:5"},
+			},
+			expectedDataSources: []string{"ds:1", "ds:3", "ds:5"},
+		},
+		{
+			desc:    "expect data sources that users has every action for by different attributes",
+			actions: []string{"datasources:read", "datasources:write"},
+			permissions: map[string][]string{
+				"datasources:read":  {"datasources:uid:uid2", "datasources:uid:uid4"},
+				"datasources:write": {"datasources:name:ds:4", "datasources:id:1"},
+			},
+			expectedDataSources: []string{"ds:4"},
+		},
+		{
+			desc:    "expect no data sources when an action has no scope",
+			actions: []string{"datasources:read", "datasources:write"},
+			permissions: map[string][]string{
+				"datasources:read": {"datasources:*"},
+			},
+			expectedDataSources: []string{},
+		},
+	}
+
+	restore := accesscontrol.SetAcceptListForTest(map[string]struct{}{
+		"data_source.id":   {},
+		"data_source.uid":  {},
+		"data_source.name": {},
+	})
+	defer restore()
+
+	builder := accesscontrol.NewSQLFilterBuilder("datasources",
+		accesscontrol.SQLFilterID("data_source.id"),
+		accesscontrol.SQLFilterUID("data_source.uid"),
+		accesscontrol.SQLFilterName("data_source.name"),
+	)
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			store := sqlstore.InitTestDB(t)
+
+			sess := store.NewSession(context.Background())
+			defer sess.Close()
+
+			for i := 1; i <= 5; i++ {
+				err := store.AddDataSource(context.Background(), &datasources.AddDataSourceCommand{Name: fmt.Sprintf("ds:%d", i), Uid: fmt.Sprintf("uid%d", i)})
+				require.NoError(t, err)
+			}
+
+			acFilter, err := builder.Build(&user.SignedInUser{
+				OrgID:       1,
+				Permissions: map[int64]map[string][]string{1: tt.permissions},
+			}, tt.actions...)
+			require.NoError(t, err)
+
+			var result []datasources.DataSource
+			err = sess.SQL(`SELECT data_source.* FROM data_source WHERE`+acFilter.Where+` ORDER BY data_source.id`, acFilter.Args...).Find(&result)
+			require.NoError(t, err)
+			names := make([]string, 0, len(result))
+			for _, ds := range result {
+				names = append(names, ds.Name)
+			}
+			assert.Equal(t, tt.expectedDataSources, names)
+		})
+	}
+}
+
+func TestSQLFilterBuilder_MultipleColumns(t *testing.T) {
+	restore := accesscontrol.SetAcceptListForTest(map[string]struct{}{"type": {}, "namespace": {}})
+	defer restore()
+
+	builder := accesscontrol.NewSQLFilterBuilder("secrets",
+		accesscontrol.SQLFilterAttribute{Name: "type", Columns: []string{"type", "namespace"}})
+	build := func(scopes ...string) accesscontrol.SQLFilter {
+		filter, err := builder.Build(&user.SignedInUser{OrgID: 1, Permissions: map[int64]map[string][]string{
+			1: {"secrets:read": scopes},
+		}}, "secrets:read")
+		require.NoError(t, err)
+		return filter
+	}
+
+	filter := build("secrets:type:datasource:loki", "secrets:type:plugin:*", "secrets:type:datasource")
+	assert.Equal(t, " (type = ? AND namespace = ?) OR (type = ?)", filter.Where)
+	assert.Equal(t, []interface{}{"datasource", "loki", "plugin"}, filter.Args)
+
+	assert.Equal(t, " 1 = 1", build("secrets:type:*").Where)
+	assert.Equal(t, " 1 = 0", build("secrets:name:loki").Where)
+}
+
+func TestSQLFilterBuilder_AcceptList(t *testing.T) {
+	builder := accesscontrol.NewSQLFilterBuilder("datasources", accesscontrol.SQLFilterName("other.name"))
+	_, err := builder.Build(&user.SignedInUser{OrgID: 1, Permissions: map[int64]map[string][]string{1: {}}}, "datasources:read")
+	require.Error(t, err)
+}
@@ -281,9 +281,10 @@ const (
 
 	// Permission actions
 
-	ActionAPIKeyRead   = "apikeys:read"
-	ActionAPIKeyCreate = "apikeys:create"
-	ActionAPIKeyDelete = "apikeys:delete"
+	ActionAPIKeyRead    = "apikeys:read"
+	ActionAPIKeyCreate  = "apikeys:create"
+	ActionAPIKeyDelete  = "apikeys:delete"
+	ActionAPIKeyApprove = "apikeys:approve"
 
 	// Users actions
 	ActionUsersRead  = "users:read"
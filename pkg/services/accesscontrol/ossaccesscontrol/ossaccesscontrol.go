@@ -2,21 +2,31 @@ package ossaccesscontrol
 
 import (
 	"context"
+	"strings"
 
 	"github.com/grafana/grafana/pkg/api/routing"
+	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/infra/metrics"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/accesscontrol/api"
+	"github.com/grafana/grafana/pkg/services/accesscontrol/decisionlog"
 	"github.com/grafana/grafana/pkg/services/user"
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-func ProvideService(cfg *setting.Cfg, store accesscontrol.PermissionsStore, routeRegister routing.RouteRegister) (*OSSAccessControlService, error) {
+func ProvideService(cfg *setting.Cfg, store accesscontrol.PermissionsStore, routeRegister routing.RouteRegister, bus bus.Bus,
+	decisions *decisionlog.Service) (*OSSAccessControlService, error) {
 	var errDeclareRoles error
 	s := ProvideOSSAccessControl(cfg, store)
 	if !s.IsDisabled() {
+		s.decisions = decisions
+		if cfg.RBACPermissionCache {
+			s.cache = newPermissionCache()
+			s.cache.addEventListeners(bus)
+		}
+
 		api := api.AccessControlAPI{
 			RouteRegister: routeRegister,
 			AccessControl: s,
@@ -49,6 +59,10 @@ type OSSAccessControlService struct {
 	store          accesscontrol.PermissionsStore
 	registrations  accesscontrol.RegistrationList
 	roles          map[string]*accesscontrol.RoleDTO
+	// cache is nil when the permissions are not cached
+	cache *permissionCache
+	// decisions records the decisions taken for the sensitive actions, nil when they are not recorded
+	decisions *decisionlog.Service
 }
 
 func (ac *OSSAccessControlService) IsDisabled() bool {
@@ -83,7 +97,7 @@ func (ac *OSSAccessControlService) Evaluate(ctx context.Context, user *user.Sign
 	}
 
 	if _, ok := user.Permissions[user.OrgID]; !ok {
-		permissions, err := ac.GetUserPermissions(ctx, user, accesscontrol.Options{ReloadCache: true})
+		permissions, err := ac.GetUserPermissions(ctx, user, accesscontrol.Options{ReloadCache: false})
 		if err != nil {
 			return false, err
 		}
@@ -95,6 +109,7 @@ func (ac *OSSAccessControlService) Evaluate(ctx context.Context, user *user.Sign
 	if err != nil {
 		return false, err
 	}
+	ac.decisions.Record(user, resolvedEvaluator, user.Permissions[user.OrgID])
 	return resolvedEvaluator.Evaluate(user.Permissions[user.OrgID]), nil
 }
 
@@ -102,17 +117,26 @@ var actionsToFetch = append(
 	TeamAdminActions, append(DashboardAdminActions, FolderAdminActions...)...,
 )
 
-// GetUserPermissions returns user permissions based on built-in roles
-func (ac *OSSAccessControlService) GetUserPermissions(ctx context.Context, user *user.SignedInUser, _ accesscontrol.Options) ([]accesscontrol.Permission, error) {
+// GetUserPermissions returns user permissions based on built-in roles. The permissions are cached when
+// the permission cache is enabled, options.ReloadCache loads them again from the database.
+func (ac *OSSAccessControlService) GetUserPermissions(ctx context.Context, user *user.SignedInUser, options accesscontrol.Options) ([]accesscontrol.Permission, error) {
 	timer := prometheus.NewTimer(metrics.MAccessPermissionsSummary)
 	defer timer.ObserveDuration()
 
+	roles := accesscontrol.GetOrgRoles(ac.cfg, user)
+	id, key := cacheKey(user, roles)
+	if !options.ReloadCache {
+		if permissions, ok := ac.cache.get(user.OrgID, id, key); ok {
+			return permissions, nil
+		}
+	}
+
 	permissions := ac.getFixedPermissions(ctx, user)
 
 	dbPermissions, err := ac.store.GetUserPermissions(ctx, accesscontrol.GetUserPermissionsQuery{
 		OrgID:   user.OrgID,
 		UserID:  user.UserID,
-		Roles:   accesscontrol.GetOrgRoles(ac.cfg, user),
+		Roles:   roles,
 		TeamIDs: user.Teams,
 		Actions: actionsToFetch,
 	})
@@ -121,6 +145,7 @@ func (ac *OSSAccessControlService) GetUserPermissions(ctx context.Context, user
 	}
 
 	permissions = append(permissions, dbPermissions...)
+	permissions = append(permissions, teamAPIKeyPermissions(dbPermissions)...)
 	keywordMutator := ac.scopeResolvers.GetScopeKeywordMutator(user)
 	for i := range permissions {
 		// if the permission has a keyword in its scope it will be resolved
@@ -130,9 +155,27 @@ func (ac *OSSAccessControlService) GetUserPermissions(ctx context.Context, user
 		}
 	}
 
+	ac.cache.set(user.OrgID, id, key, permissions)
 	return permissions, nil
 }
 
+// teamAPIKeyPermissions grants the admins of a team the management of the API keys the team owns, e.g.
+// "teams.permissions:write" on "teams:id:1" grants "apikeys:read" on "apikeys:team:1"
+func teamAPIKeyPermissions(permissions []accesscontrol.Permission) []accesscontrol.Permission {
+	prefix := accesscontrol.Scope("teams", "id", "")
+	var teamPermissions []accesscontrol.Permission
+	for _, p := range permissions {
+		if p.Action != accesscontrol.ActionTeamsPermissionsWrite || !strings.HasPrefix(p.Scope, prefix) {
+			continue
+		}
+		scope := accesscontrol.Scope("apikeys", "team", strings.TrimPrefix(p.Scope, prefix))
+		for _, action := range TeamAdminAPIKeyActions {
+			teamPermissions = append(teamPermissions, accesscontrol.Permission{Action: action, Scope: scope})
+		}
+	}
+	return teamPermissions
+}
+
 func (ac *OSSAccessControlService) getFixedPermissions(ctx context.Context, user *user.SignedInUser) []accesscontrol.Permission {
 	permissions := make([]accesscontrol.Permission, 0)
 
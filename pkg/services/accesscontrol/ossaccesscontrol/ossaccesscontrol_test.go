@@ -140,10 +140,13 @@ func TestUsageMetrics(t *testing.T) {
 			cfg := setting.NewCfg()
 			cfg.RBACEnabled = tt.enabled
 
+			db := sqlstore.InitTestDB(t)
 			s, errInitAc := ProvideService(
 				cfg,
-				database.ProvideService(sqlstore.InitTestDB(t)),
+				database.ProvideService(db),
 				routing.NewRouteRegister(),
+				db.Bus(),
+				nil,
 			)
 			require.NoError(t, errInitAc)
 			assert.Equal(t, tt.expectedValue, s.GetUsageStats(context.Background())["stats.oss.accesscontrol.enabled.count"])
@@ -417,6 +420,20 @@ func TestOSSAccessControlService_GetUserPermissions(t *testing.T) {
 	}
 }
 
+func TestTeamAPIKeyPermissions(t *testing.T) {
+	permissions := teamAPIKeyPermissions([]accesscontrol.Permission{
+		{Action: accesscontrol.ActionTeamsPermissionsWrite, Scope: "teams:id:1"},
+		{Action: accesscontrol.ActionTeamsRead, Scope: "teams:id:2"},
+		{Action: accesscontrol.ActionTeamsPermissionsWrite, Scope: "teams:*"},
+	})
+
+	assert.ElementsMatch(t, []accesscontrol.Permission{
+		{Action: accesscontrol.ActionAPIKeyRead, Scope: "apikeys:team:1"},
+		{Action: accesscontrol.ActionAPIKeyCreate, Scope: "apikeys:team:1"},
+		{Action: accesscontrol.ActionAPIKeyDelete, Scope: "apikeys:team:1"},
+	}, permissions)
+}
+
 func TestOSSAccessControlService_Evaluate(t *testing.T) {
 	testUser := user.SignedInUser{
 		UserID:  2,
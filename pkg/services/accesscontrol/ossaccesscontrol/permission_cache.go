@@ -0,0 +1,136 @@
+package ossaccesscontrol
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+// permissionCacheTTL bounds how long the permissions are cached, the permission changes made by another instance
+// of Grafana are only seen once the permissions expire
+const permissionCacheTTL = time.Minute
+
+type cachedPermissions struct {
+	permissions []accesscontrol.Permission
+	expires     time.Time
+}
+
+// permissionCache caches the permissions of the users by organization. The entries are keyed by everything the
+// permissions are computed from besides the database, i.e. the basic roles and the teams of the user, so that a
+// change of role or of team membership misses the cache. The permission changes in the database are notified by
+// events on the bus.
+type permissionCache struct {
+	mu   sync.RWMutex
+	now  func() time.Time
+	orgs map[int64]map[int64]map[string]cachedPermissions
+}
+
+func newPermissionCache() *permissionCache {
+	return &permissionCache{
+		now:  time.Now,
+		orgs: map[int64]map[int64]map[string]cachedPermissions{},
+	}
+}
+
+// cacheKey returns the id of the cached user, negative for the api keys, and the key of their permissions given
+// their basic roles
+func cacheKey(usr *user.SignedInUser, roles []string) (int64, string) {
+	id := usr.UserID
+	if usr.IsApiKeyUser() {
+		id = -usr.ApiKeyID
+	}
+	return id, fmt.Sprintf("%v-%v", roles, usr.Teams)
+}
+
+func (c *permissionCache) get(orgID, id int64, key string) ([]accesscontrol.Permission, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cached, ok := c.orgs[orgID][id][key]
+	if !ok || c.now().After(cached.expires) {
+		return nil, false
+	}
+	return copyPermissions(cached.permissions), true
+}
+
+func (c *permissionCache) set(orgID, id int64, key string, permissions []accesscontrol.Permission) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	users, ok := c.orgs[orgID]
+	if !ok {
+		users = map[int64]map[string]cachedPermissions{}
+		c.orgs[orgID] = users
+	}
+	// the permissions cached for the former roles and teams of the user can't be used anymore
+	users[id] = map[string]cachedPermissions{
+		key: {permissions: copyPermissions(permissions), expires: c.now().Add(permissionCacheTTL)},
+	}
+}
+
+// invalidateUser removes the cached permissions of the user in the organization, in every organization when orgID
+// is accesscontrol.GlobalOrgID
+func (c *permissionCache) invalidateUser(orgID, userID int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if orgID == accesscontrol.GlobalOrgID {
+		for _, users := range c.orgs {
+			delete(users, userID)
+		}
+		return
+	}
+	delete(c.orgs[orgID], userID)
+}
+
+// invalidateOrg removes the cached permissions of every user of the organization, of every organization when orgID
+// is accesscontrol.GlobalOrgID
+func (c *permissionCache) invalidateOrg(orgID int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if orgID == accesscontrol.GlobalOrgID {
+		c.orgs = map[int64]map[int64]map[string]cachedPermissions{}
+		return
+	}
+	delete(c.orgs, orgID)
+}
+
+// addEventListeners invalidates the cached permissions the published changes apply to
+func (c *permissionCache) addEventListeners(b bus.Bus) {
+	b.AddEventListener(func(_ context.Context, e *events.AccessControlPermissionsChanged) error {
+		if e.UserID == 0 {
+			c.invalidateOrg(e.OrgID)
+		} else {
+			c.invalidateUser(e.OrgID, e.UserID)
+		}
+		return nil
+	})
+	b.AddEventListener(func(_ context.Context, e *events.TeamMemberChanged) error {
+		c.invalidateUser(e.OrgID, e.UserID)
+		return nil
+	})
+}
+
+// copyPermissions prevents the callers from altering the cached permissions, e.g. when resolving their scopes
+func copyPermissions(permissions []accesscontrol.Permission) []accesscontrol.Permission {
+	return append(make([]accesscontrol.Permission, 0, len(permissions)), permissions...)
+}
@@ -0,0 +1,168 @@
+package ossaccesscontrol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/api/routing"
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/tracing"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+type countingPermissionsStore struct {
+	calls       int
+	permissions []accesscontrol.Permission
+}
+
+func (s *countingPermissionsStore) GetUserPermissions(_ context.Context, _ accesscontrol.GetUserPermissionsQuery) ([]accesscontrol.Permission, error) {
+	s.calls++
+	return append([]accesscontrol.Permission{}, s.permissions...), nil
+}
+
+func (s *countingPermissionsStore) DeleteUserPermissions(_ context.Context, _, _ int64) error {
+	return nil
+}
+
+func TestPermissionCache(t *testing.T) {
+	setup := func(t *testing.T, cacheEnabled bool) (*OSSAccessControlService, *countingPermissionsStore, bus.Bus) {
+		t.Helper()
+		cfg := setting.NewCfg()
+		cfg.RBACEnabled = true
+		cfg.RBACPermissionCache = cacheEnabled
+		store := &countingPermissionsStore{permissions: []accesscontrol.Permission{{Action: "dashboards:read", Scope: "dashboards:uid:1"}}}
+		b := bus.ProvideBus(tracing.InitializeTracerForTest())
+		s, err := ProvideService(cfg, store, routing.NewRouteRegister(), b, nil)
+		require.NoError(t, err)
+		return s, store, b
+	}
+	viewer := func(userID int64, teams ...int64) *user.SignedInUser {
+		return &user.SignedInUser{UserID: userID, OrgID: 1, OrgRole: org.RoleViewer, Teams: teams}
+	}
+	ctx := context.Background()
+
+	t.Run("permissions are loaded once", func(t *testing.T) {
+		s, store, _ := setup(t, true)
+
+		first, err := s.GetUserPermissions(ctx, viewer(1), accesscontrol.Options{})
+		require.NoError(t, err)
+		second, err := s.GetUserPermissions(ctx, viewer(1), accesscontrol.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, store.calls)
+		assert.Equal(t, first, second)
+
+		_, err = s.GetUserPermissions(ctx, viewer(2), accesscontrol.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, store.calls)
+	})
+
+	t.Run("permissions are not cached when the cache is disabled", func(t *testing.T) {
+		s, store, _ := setup(t, false)
+
+		for i := 0; i < 2; i++ {
+			_, err := s.GetUserPermissions(ctx, viewer(1), accesscontrol.Options{})
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 2, store.calls)
+	})
+
+	t.Run("reloading the cache loads the permissions", func(t *testing.T) {
+		s, store, _ := setup(t, true)
+
+		_, err := s.GetUserPermissions(ctx, viewer(1), accesscontrol.Options{})
+		require.NoError(t, err)
+		_, err = s.GetUserPermissions(ctx, viewer(1), accesscontrol.Options{ReloadCache: true})
+		require.NoError(t, err)
+		assert.Equal(t, 2, store.calls)
+	})
+
+	t.Run("a change of role or of teams misses the cache", func(t *testing.T) {
+		s, store, _ := setup(t, true)
+
+		_, err := s.GetUserPermissions(ctx, viewer(1), accesscontrol.Options{})
+		require.NoError(t, err)
+		_, err = s.GetUserPermissions(ctx, viewer(1, 3), accesscontrol.Options{})
+		require.NoError(t, err)
+		editor := viewer(1, 3)
+		editor.OrgRole = org.RoleEditor
+		_, err = s.GetUserPermissions(ctx, editor, accesscontrol.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, 3, store.calls)
+	})
+
+	t.Run("cached permissions expire", func(t *testing.T) {
+		s, store, _ := setup(t, true)
+		now := time.Now()
+		s.cache.now = func() time.Time { return now }
+
+		_, err := s.GetUserPermissions(ctx, viewer(1), accesscontrol.Options{})
+		require.NoError(t, err)
+		now = now.Add(permissionCacheTTL + time.Second)
+		_, err = s.GetUserPermissions(ctx, viewer(1), accesscontrol.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, store.calls)
+	})
+
+	t.Run("permission change events invalidate the cache", func(t *testing.T) {
+		tests := []struct {
+			desc     string
+			event    interface{}
+			expected map[int64]int
+		}{
+			{
+				desc:     "permissions of a user",
+				event:    &events.AccessControlPermissionsChanged{OrgID: 1, UserID: 1},
+				expected: map[int64]int{1: 2, 2: 1},
+			},
+			{
+				desc:     "permissions of a team or a basic role",
+				event:    &events.AccessControlPermissionsChanged{OrgID: 1},
+				expected: map[int64]int{1: 2, 2: 2},
+			},
+			{
+				desc:     "permissions of another organization",
+				event:    &events.AccessControlPermissionsChanged{OrgID: 2},
+				expected: map[int64]int{1: 1, 2: 1},
+			},
+			{
+				desc:     "permissions of every organization",
+				event:    &events.AccessControlPermissionsChanged{OrgID: accesscontrol.GlobalOrgID, UserID: 2},
+				expected: map[int64]int{1: 1, 2: 2},
+			},
+			{
+				desc:     "team membership of a user",
+				event:    &events.TeamMemberChanged{OrgID: 1, TeamID: 3, UserID: 2},
+				expected: map[int64]int{1: 1, 2: 2},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.desc, func(t *testing.T) {
+				s, _, b := setup(t, true)
+				stores := map[int64]*countingPermissionsStore{}
+				for _, userID := range []int64{1, 2} {
+					store := &countingPermissionsStore{}
+					stores[userID] = store
+					s.store = store
+					_, err := s.GetUserPermissions(ctx, viewer(userID), accesscontrol.Options{})
+					require.NoError(t, err)
+				}
+
+				require.NoError(t, b.Publish(ctx, tt.event))
+				for _, userID := range []int64{1, 2} {
+					s.store = stores[userID]
+					_, err := s.GetUserPermissions(ctx, viewer(userID), accesscontrol.Options{})
+					require.NoError(t, err)
+					assert.Equal(t, tt.expected[userID], stores[userID].calls, "user %d", userID)
+				}
+			})
+		}
+	})
+}
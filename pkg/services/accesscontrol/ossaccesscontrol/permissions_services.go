@@ -33,6 +33,12 @@ var (
 		accesscontrol.ActionTeamsPermissionsRead,
 		accesscontrol.ActionTeamsPermissionsWrite,
 	}
+	// TeamAdminAPIKeyActions are granted to the admins of a team on the API keys the team owns
+	TeamAdminAPIKeyActions = []string{
+		accesscontrol.ActionAPIKeyRead,
+		accesscontrol.ActionAPIKeyCreate,
+		accesscontrol.ActionAPIKeyDelete,
+	}
 )
 
 func ProvideTeamPermissions(
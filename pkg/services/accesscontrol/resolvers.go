@@ -20,7 +20,8 @@ const (
 func NewScopeResolvers() ScopeResolvers {
 	return ScopeResolvers{
 		keywordResolvers: map[string]ScopeKeywordResolver{
-			"users:self": userSelfResolver,
+			"users:self":           userSelfResolver,
+			"apikeys:creator:self": apiKeyCreatorSelfResolver,
 		},
 		attributeResolvers: map[string]ScopeAttributeResolver{},
 		cache:              localcache.New(ttl, cleanInterval),
@@ -138,3 +139,8 @@ func ScopeInjector(params ScopeParams) ScopeAttributeMutator {
 var userSelfResolver = ScopeKeywordResolverFunc(func(ctx context.Context, user *user.SignedInUser) (string, error) {
 	return Scope("users", "id", fmt.Sprintf("%v", user.UserID)), nil
 })
+
+// apiKeyCreatorSelfResolver resolves the API keys created by the user, e.g. "apikeys:creator:self" -> "apikeys:creator:1"
+var apiKeyCreatorSelfResolver = ScopeKeywordResolverFunc(func(ctx context.Context, user *user.SignedInUser) (string, error) {
+	return Scope("apikeys", "creator", fmt.Sprintf("%v", user.UserID)), nil
+})
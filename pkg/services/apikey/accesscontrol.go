@@ -0,0 +1,30 @@
+package apikey
+
+import (
+	"strconv"
+
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+)
+
+const (
+	ScopeRoot = "apikeys"
+	// ScopeCreatorPrefix is the prefix of the scopes of the API keys created by a user, e.g. "apikeys:creator:1"
+	ScopeCreatorPrefix = ScopeRoot + ":creator:"
+	// ScopeCreatorSelf is resolved to the creator scope of the signed in user, so that a role can grant the API keys
+	// users created themselves
+	ScopeCreatorSelf = ScopeCreatorPrefix + "self"
+	// ScopeTeamPrefix is the prefix of the scopes of the API keys owned by a team, e.g. "apikeys:team:1"
+	ScopeTeamPrefix = ScopeRoot + ":team:"
+)
+
+var ScopeProvider = accesscontrol.NewScopeProvider(ScopeRoot)
+
+// ScopeCreator returns the scope of the API keys created by the user
+func ScopeCreator(userID int64) string {
+	return ScopeCreatorPrefix + strconv.FormatInt(userID, 10)
+}
+
+// ScopeTeam returns the scope of the API keys owned by the team
+func ScopeTeam(teamID int64) string {
+	return ScopeTeamPrefix + strconv.FormatInt(teamID, 10)
+}
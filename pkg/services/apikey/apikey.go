@@ -8,9 +8,31 @@ type Service interface {
 	GetAPIKeys(ctx context.Context, query *GetApiKeysQuery) error
 	GetAllAPIKeys(ctx context.Context, orgID int64) []*APIKey
 	DeleteApiKey(ctx context.Context, cmd *DeleteCommand) error
+	DeleteByFilter(ctx context.Context, cmd *DeleteByFilterCommand) error
 	AddAPIKey(ctx context.Context, cmd *AddCommand) error
+	RotateAPIKey(ctx context.Context, cmd *RotateCommand) error
+	PromoteSecondaryAPIKey(ctx context.Context, cmd *PromoteSecondaryCommand) error
+	RevokeSecondaryAPIKey(ctx context.Context, cmd *RevokeSecondaryCommand) error
+	UpdateAPIKey(ctx context.Context, cmd *UpdateCommand) error
+	ApproveAPIKey(ctx context.Context, cmd *ApproveCommand) error
+	RejectAPIKey(ctx context.Context, cmd *RejectCommand) error
 	GetApiKeyById(ctx context.Context, query *GetByIDQuery) error
 	GetApiKeyByName(ctx context.Context, query *GetByNameQuery) error
 	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
-	UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64) error
+	VerifySecret(ctx context.Context, query *VerifySecretQuery) error
+	IntrospectAPIKey(ctx context.Context, query *IntrospectQuery) error
+	ReportLeakedKeys(ctx context.Context, cmd *ReportLeaksCommand) error
+	CheckLeakedKey(ctx context.Context, key *APIKey, keyString string) (bool, error)
+	VerifySecretScanningSignature(ctx context.Context, keyID, signature string, payload []byte) error
+	VerifySignature(ctx context.Context, query *VerifySignatureQuery) error
+	UpdateAPIKeyLastUsed(ctx context.Context, cmd *UpdateLastUsedCommand) error
+	RecordUsage(cmd *RecordUsageCommand)
+	GetUsageStats(ctx context.Context, query *GetUsageStatsQuery) error
+	GetSummary(ctx context.Context, query *GetSummaryQuery) error
+	ExportKeys(ctx context.Context, query *ExportKeysQuery) error
+	ImportKeys(ctx context.Context, cmd *ImportKeysCommand) error
+	ReportValidation(outcome ValidationOutcome)
+	GetNamingPolicy(ctx context.Context, query *GetNamingPolicyQuery) error
+	SetNamingPolicy(ctx context.Context, cmd *SetNamingPolicyCommand) error
+	ProtectMetadata(ctx context.Context, key *APIKey) error
 }
@@ -0,0 +1,149 @@
+package apikeyimpl
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+func TestIntegrationScopeResolvers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	db := sqlstore.InitTestDB(t)
+	ss := &sqlStore{db: db, cfg: db.Cfg, clock: clock.New()}
+	ctx := context.Background()
+
+	ci := apikey.AddCommand{OrgId: 1, Name: "ci", Key: "ci", CreatedBy: 2}
+	require.NoError(t, ss.AddAPIKey(ctx, &ci))
+	backup := apikey.AddCommand{OrgId: 1, Name: "backup", Key: "backup", CreatedBy: 2}
+	require.NoError(t, ss.AddAPIKey(ctx, &backup))
+	legacy := apikey.AddCommand{OrgId: 1, Name: "legacy", Key: "legacy"}
+	require.NoError(t, ss.AddAPIKey(ctx, &legacy))
+	team, err := db.CreateTeam("payments", "", 1)
+	require.NoError(t, err)
+	billing := apikey.AddCommand{OrgId: 1, Name: "billing", Key: "billing", CreatedBy: 3, OwnerTeamId: team.Id}
+	require.NoError(t, ss.AddAPIKey(ctx, &billing))
+
+	idScope := func(id int64) string {
+		return apikey.ScopeProvider.GetResourceScope(strconv.FormatInt(id, 10))
+	}
+
+	t.Run("id scope resolves to the name and creator scopes of the key", func(t *testing.T) {
+		_, resolver := NewIDScopeResolver(ss)
+		scopes, err := resolver.Resolve(ctx, 1, idScope(ci.Result.Id))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{idScope(ci.Result.Id), "apikeys:name:ci", "apikeys:creator:2"}, scopes)
+
+		scopes, err = resolver.Resolve(ctx, 1, idScope(legacy.Result.Id))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{idScope(legacy.Result.Id), "apikeys:name:legacy"}, scopes)
+
+		scopes, err = resolver.Resolve(ctx, 1, idScope(billing.Result.Id))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{idScope(billing.Result.Id), "apikeys:name:billing", "apikeys:creator:3", apikey.ScopeTeam(team.Id)}, scopes)
+	})
+
+	t.Run("id scope of a key of another org is not resolved", func(t *testing.T) {
+		_, resolver := NewIDScopeResolver(ss)
+		scopes, err := resolver.Resolve(ctx, 2, idScope(ci.Result.Id))
+		require.NoError(t, err)
+		assert.Equal(t, []string{idScope(ci.Result.Id)}, scopes)
+	})
+
+	t.Run("id scope of an unknown key is not resolved", func(t *testing.T) {
+		_, resolver := NewIDScopeResolver(ss)
+		scopes, err := resolver.Resolve(ctx, 1, idScope(1000))
+		require.NoError(t, err)
+		assert.Equal(t, []string{idScope(1000)}, scopes)
+	})
+
+	t.Run("invalid id scope is rejected", func(t *testing.T) {
+		_, resolver := NewIDScopeResolver(ss)
+		_, err := resolver.Resolve(ctx, 1, "apikeys:id:ci")
+		assert.ErrorIs(t, err, accesscontrol.ErrInvalidScope)
+	})
+
+	t.Run("name scope resolves to the id scope of the key", func(t *testing.T) {
+		_, resolver := NewNameScopeResolver(ss)
+		scopes, err := resolver.Resolve(ctx, 1, "apikeys:name:backup")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"apikeys:name:backup", idScope(backup.Result.Id)}, scopes)
+
+		scopes, err = resolver.Resolve(ctx, 1, "apikeys:name:unknown")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"apikeys:name:unknown"}, scopes)
+	})
+
+	t.Run("creator scope resolves to the id scopes of the keys of the user", func(t *testing.T) {
+		_, resolver := NewCreatorScopeResolver(ss)
+		scopes, err := resolver.Resolve(ctx, 1, "apikeys:creator:2")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"apikeys:creator:2", idScope(ci.Result.Id), idScope(backup.Result.Id)}, scopes)
+
+		_, err = resolver.Resolve(ctx, 1, "apikeys:creator:self")
+		assert.ErrorIs(t, err, accesscontrol.ErrInvalidScope)
+	})
+
+	t.Run("team scope resolves to the id scopes of the keys of the team", func(t *testing.T) {
+		_, resolver := NewTeamScopeResolver(ss)
+		scopes, err := resolver.Resolve(ctx, 1, apikey.ScopeTeam(team.Id))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{apikey.ScopeTeam(team.Id), idScope(billing.Result.Id)}, scopes)
+
+		scopes, err = resolver.Resolve(ctx, 2, apikey.ScopeTeam(team.Id))
+		require.NoError(t, err)
+		assert.Equal(t, []string{apikey.ScopeTeam(team.Id)}, scopes)
+	})
+
+	t.Run("keys of unknown teams are rejected", func(t *testing.T) {
+		cmd := apikey.AddCommand{OrgId: 2, Name: "billing", Key: "other-billing", OwnerTeamId: team.Id}
+		assert.ErrorIs(t, ss.AddAPIKey(ctx, &cmd), apikey.ErrTeamNotFound)
+	})
+
+	t.Run("keys can be listed by name and creator", func(t *testing.T) {
+		tests := []struct {
+			desc     string
+			scopes   []string
+			expected []string
+		}{
+			{desc: "by name", scopes: []string{"apikeys:name:legacy"}, expected: []string{"legacy"}},
+			{desc: "by creator", scopes: []string{"apikeys:creator:2"}, expected: []string{"backup", "ci"}},
+			{desc: "by id and name", scopes: []string{idScope(ci.Result.Id), "apikeys:name:legacy"}, expected: []string{"ci", "legacy"}},
+			{desc: "by team", scopes: []string{apikey.ScopeTeam(team.Id)}, expected: []string{"billing"}},
+			{desc: "by any name", scopes: []string{"apikeys:name:*"}, expected: []string{"backup", "billing", "ci", "legacy"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.desc, func(t *testing.T) {
+				usr := &user.SignedInUser{OrgID: 1, Permissions: map[int64]map[string][]string{
+					1: {accesscontrol.ActionAPIKeyRead: tt.scopes},
+				}}
+				query := apikey.GetApiKeysQuery{OrgId: 1, User: usr}
+				require.NoError(t, ss.GetAPIKeys(ctx, &query))
+
+				names := make([]string, 0, len(query.Result))
+				for _, key := range query.Result {
+					names = append(names, key.Name)
+				}
+				assert.ElementsMatch(t, tt.expected, names)
+			})
+		}
+	})
+	t.Run("keys of a deleted team are kept", func(t *testing.T) {
+		require.NoError(t, db.DeleteTeam(ctx, &models.DeleteTeamCommand{OrgId: 1, Id: team.Id}))
+
+		query := apikey.GetByIDQuery{ApiKeyId: billing.Result.Id}
+		require.NoError(t, ss.GetApiKeyById(ctx, &query))
+		assert.Nil(t, query.Result.OwnerTeamId)
+	})
+}
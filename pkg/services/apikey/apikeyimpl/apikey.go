@@ -2,18 +2,149 @@ package apikeyimpl
 
 import (
 	"context"
+	"errors"
+	"time"
 
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/encryption"
+	"github.com/grafana/grafana/pkg/services/secrets"
 	"github.com/grafana/grafana/pkg/services/sqlstore/db"
 	"github.com/grafana/grafana/pkg/setting"
 )
 
 type Service struct {
 	store store
+	cfg   *setting.Cfg
+	log   log.Logger
+	clock clock.Clock
+	// lastUsed buffers the usages of API keys when a flush interval is
+	// configured, it is nil otherwise.
+	lastUsed *lastUsedBuffer
+	// usage counts the requests of API keys for their usage statistics.
+	usage *usageBuffer
+	// hasher hashes secrets with the configured algorithm, hashers verify
+	// secrets hashed with any supported algorithm.
+	hasher  Hasher
+	hashers []Hasher
+	// secretsService encrypts signing secrets and the metadata of service
+	// account tokens, its key providers encrypt export bundles.
+	secretsService secrets.Service
+	// encryption encrypts export bundles with their data key, and data keys
+	// with a passphrase.
+	encryption encryption.Internal
+	// kvStore holds the naming policies of organizations.
+	kvStore kvstore.KVStore
+	// scanningKeys verify the reports of leaked keys from secret scanning.
+	scanningKeys *secretScanningKeys
 }
 
-func ProvideService(db db.DB, cfg *setting.Cfg) apikey.Service {
-	return &Service{store: &sqlStore{db: db, cfg: cfg}}
+func ProvideService(db db.DB, cfg *setting.Cfg, secretsService secrets.Service, encryptionService encryption.Internal, kvStore kvstore.KVStore, ac accesscontrol.AccessControl, clk clock.Clock) *Service {
+	keyStore := &sqlStore{db: db, cfg: cfg, clock: clk}
+	s := &Service{
+		store:          keyStore,
+		cfg:            cfg,
+		log:            log.New("apikey"),
+		clock:          clk,
+		secretsService: secretsService,
+		encryption:     encryptionService,
+		kvStore:        kvStore,
+		usage:          newUsageBuffer(),
+		scanningKeys:   newSecretScanningKeys(cfg.ApiKeySecretScanningPublicKeysURL),
+	}
+	s.hasher, s.hashers = newHashers(cfg)
+	if cfg.ApiKeyLastUsedFlushInterval > 0 {
+		s.lastUsed = newLastUsedBuffer()
+	}
+
+	ac.RegisterScopeAttributeResolver(NewIDScopeResolver(keyStore))
+	ac.RegisterScopeAttributeResolver(NewNameScopeResolver(keyStore))
+	ac.RegisterScopeAttributeResolver(NewCreatorScopeResolver(keyStore))
+	ac.RegisterScopeAttributeResolver(NewTeamScopeResolver(keyStore))
+
+	return s
+}
+
+// metricsCollectionInterval is how often the gauge of keys by expiry is updated.
+const metricsCollectionInterval = 30 * time.Minute
+
+// Run periodically updates the metrics of API keys, moves the metadata of
+// service account tokens out of plaintext columns, writes the request counts
+// of API keys to the database, and writes their buffered last usages when a
+// flush interval is configured.
+func (s *Service) Run(ctx context.Context) error {
+	s.collectMetrics(ctx)
+	s.migrateMetadata(ctx)
+	metricsTicker := time.NewTicker(metricsCollectionInterval)
+	defer metricsTicker.Stop()
+	usageTicker := time.NewTicker(usageFlushInterval)
+	defer usageTicker.Stop()
+
+	// last usages are written on every request without a buffer, a nil channel
+	// never fires
+	var flush <-chan time.Time
+	if s.lastUsed != nil {
+		flushTicker := time.NewTicker(s.cfg.ApiKeyLastUsedFlushInterval)
+		defer flushTicker.Stop()
+		flush = flushTicker.C
+	}
+
+	for {
+		select {
+		case <-metricsTicker.C:
+			s.collectMetrics(ctx)
+			s.migrateMetadata(ctx)
+		case <-flush:
+			s.flushLastUsed(ctx)
+		case <-usageTicker.C:
+			s.flushUsage(ctx)
+		case <-ctx.Done():
+			// ctx is canceled on shutdown, flush what is left with a fresh one
+			s.flushUsage(context.Background())
+			if s.lastUsed != nil {
+				s.flushLastUsed(context.Background())
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Service) collectMetrics(ctx context.Context) {
+	expirations, err := s.store.GetExpirations(ctx)
+	if err != nil {
+		s.log.Warn("failed to collect api key metrics", "error", err)
+		return
+	}
+
+	counts := make(map[string]int, len(expiryBuckets))
+	now := s.clock.Now().Unix()
+	for _, expires := range expirations {
+		counts[expiryBucket(expires, now)]++
+	}
+	for _, bucket := range expiryBuckets {
+		keysByExpiryGauge.WithLabelValues(bucket).Set(float64(counts[bucket]))
+	}
+}
+
+func (s *Service) flushLastUsed(ctx context.Context) {
+	cmds := s.lastUsed.drain()
+	if len(cmds) == 0 {
+		return
+	}
+	if err := s.updateLastUsed(ctx, cmds); err != nil {
+		s.log.Error("failed to update api keys last used date", "count", len(cmds), "error", err)
+	}
+}
+
+func (s *Service) updateLastUsed(ctx context.Context, cmds []*apikey.UpdateLastUsedCommand) error {
+	start := time.Now()
+	defer func() {
+		lastUsedUpdateDuration.Observe(time.Since(start).Seconds())
+	}()
+	return s.store.UpdateAPIKeysLastUsed(ctx, cmds)
 }
 
 func (s *Service) GetAPIKeys(ctx context.Context, query *apikey.GetApiKeysQuery) error {
@@ -23,20 +154,218 @@ func (s *Service) GetAllAPIKeys(ctx context.Context, orgID int64) []*apikey.APIK
 	return s.store.GetAllAPIKeys(ctx, orgID)
 }
 func (s *Service) GetApiKeyById(ctx context.Context, query *apikey.GetByIDQuery) error {
-	return s.store.GetApiKeyById(ctx, query)
+	if err := s.store.GetApiKeyById(ctx, query); err != nil {
+		return err
+	}
+	return s.decryptMetadata(ctx, query.Result)
 }
 func (s *Service) GetApiKeyByName(ctx context.Context, query *apikey.GetByNameQuery) error {
-	return s.store.GetApiKeyByName(ctx, query)
+	if err := s.store.GetApiKeyByName(ctx, query); err != nil {
+		return err
+	}
+	return s.decryptMetadata(ctx, query.Result)
 }
+
+// GetAPIKeyByHash returns the service account token with the hash. Tokens are
+// looked up by their hash, the store never asks to rehash them.
 func (s *Service) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
-	return s.store.GetAPIKeyByHash(ctx, hash)
+	key, _, err := s.store.GetAPIKeyByHash(ctx, hash)
+	if err != nil {
+		return key, err
+	}
+	if err := s.decryptMetadata(ctx, key); err != nil {
+		return nil, err
+	}
+	return key, nil
 }
+
+// VerifySecret checks the secret of an API key. A valid secret whose hash was
+// produced with another algorithm or other parameters than the configured ones
+// is rehashed.
+func (s *Service) VerifySecret(ctx context.Context, query *apikey.VerifySecretQuery) error {
+	hashed := query.Key.Key
+	if query.Previous {
+		if query.Key.PreviousKey == nil {
+			query.Result = false
+			return nil
+		}
+		hashed = *query.Key.PreviousKey
+	}
+	if query.Secondary {
+		if query.Key.SecondaryKey == nil {
+			query.Result = false
+			return nil
+		}
+		hashed = *query.Key.SecondaryKey
+	}
+
+	var hasher Hasher
+	for _, h := range s.hashers {
+		if h.Recognizes(hashed) {
+			hasher = h
+			break
+		}
+	}
+	if hasher == nil {
+		return errUnknownHash
+	}
+
+	valid, needsRehash, err := hasher.Verify(query.Secret, query.Key.Name, hashed)
+	if err != nil {
+		return err
+	}
+	query.Result = valid
+
+	// previous keys are about to expire, they are not worth rehashing, and
+	// secondary keys are rehashed once promoted
+	if valid && !query.Previous && !query.Secondary && (needsRehash || !s.hasher.Recognizes(hashed)) {
+		s.rehash(ctx, query.Key, query.Secret)
+	}
+	return nil
+}
+
+func (s *Service) rehash(ctx context.Context, key *apikey.APIKey, secret string) {
+	newHash, err := s.hasher.Hash(secret, key.Name)
+	if err != nil {
+		s.log.Error("failed to rehash api key", "keyId", key.Id, "error", err)
+		return
+	}
+	if err := s.store.UpdateAPIKeyHash(ctx, key.Id, key.Key, newHash); err != nil {
+		s.log.Error("failed to rehash api key", "keyId", key.Id, "error", err)
+		return
+	}
+	key.Key = newHash
+}
+
 func (s *Service) DeleteApiKey(ctx context.Context, cmd *apikey.DeleteCommand) error {
-	return s.store.DeleteApiKey(ctx, cmd)
+	if err := s.store.DeleteApiKey(ctx, cmd); err != nil {
+		return err
+	}
+	deletedCounter.Inc()
+	return nil
+}
+func (s *Service) DeleteByFilter(ctx context.Context, cmd *apikey.DeleteByFilterCommand) error {
+	if err := s.store.DeleteByFilter(ctx, cmd); err != nil {
+		return err
+	}
+	deletedCounter.Add(float64(cmd.Result))
+	return nil
 }
 func (s *Service) AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) error {
-	return s.store.AddAPIKey(ctx, cmd)
+	if err := s.checkNamingPolicy(ctx, cmd.OrgId, cmd.Name); err != nil {
+		return err
+	}
+
+	// the store receives the hash of the secret, and the encrypted secret of
+	// signing keys
+	storeCmd := *cmd
+	if cmd.Secret != "" {
+		hashed, err := s.hasher.Hash(cmd.Secret, cmd.Name)
+		if err != nil {
+			return err
+		}
+		storeCmd.Key = hashed
+	}
+	if cmd.Type == apikey.TypeSigning {
+		encrypted, err := s.encryptSigningSecret(ctx, cmd.SigningSecret)
+		if err != nil {
+			return err
+		}
+		storeCmd.SigningSecret = encrypted
+	}
+	metadata := apikey.APIKey{Labels: cmd.Labels, AccessWindows: cmd.AccessWindows}
+	if err := s.encryptMetadata(ctx, &metadata); err != nil {
+		return err
+	}
+	storeCmd.EncryptedMetadata = metadata.EncryptedMetadata
+	if err := s.store.AddAPIKey(ctx, &storeCmd); err != nil {
+		return err
+	}
+	cmd.Result, cmd.Replaced = storeCmd.Result, storeCmd.Replaced
+	if !cmd.Replaced {
+		createdCounter.Inc()
+	}
+	return nil
 }
-func (s *Service) UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64) error {
-	return s.store.UpdateAPIKeyLastUsedDate(ctx, tokenID)
+func (s *Service) RotateAPIKey(ctx context.Context, cmd *apikey.RotateCommand) error {
+	storeCmd := *cmd
+	if cmd.Secret != "" {
+		// the legacy algorithm salts the secret with the key name
+		query := apikey.GetByIDQuery{ApiKeyId: cmd.Id}
+		if err := s.store.GetApiKeyById(ctx, &query); err != nil {
+			if errors.Is(err, apikey.ErrInvalid) {
+				return apikey.ErrNotFound
+			}
+			return err
+		}
+		hashed, err := s.hasher.Hash(cmd.Secret, query.Result.Name)
+		if err != nil {
+			return err
+		}
+		storeCmd.Key = hashed
+	}
+	if cmd.SigningSecret != "" {
+		encrypted, err := s.encryptSigningSecret(ctx, cmd.SigningSecret)
+		if err != nil {
+			return err
+		}
+		storeCmd.SigningSecret = encrypted
+	}
+	if err := s.store.RotateAPIKey(ctx, &storeCmd); err != nil {
+		return err
+	}
+	cmd.Result = storeCmd.Result
+	return nil
+}
+func (s *Service) PromoteSecondaryAPIKey(ctx context.Context, cmd *apikey.PromoteSecondaryCommand) error {
+	return s.store.PromoteSecondaryAPIKey(ctx, cmd)
+}
+func (s *Service) RevokeSecondaryAPIKey(ctx context.Context, cmd *apikey.RevokeSecondaryCommand) error {
+	return s.store.RevokeSecondaryAPIKey(ctx, cmd)
+}
+func (s *Service) UpdateAPIKey(ctx context.Context, cmd *apikey.UpdateCommand) error {
+	storeCmd := *cmd
+	if cmd.AccessWindows != nil {
+		// the encrypted copy of the metadata follows the new access windows,
+		// the labels of a key never change
+		query := apikey.GetByIDQuery{ApiKeyId: cmd.Id}
+		if err := s.store.GetApiKeyById(ctx, &query); err != nil {
+			if errors.Is(err, apikey.ErrInvalid) {
+				return apikey.ErrNotFound
+			}
+			return err
+		}
+		metadata := apikey.APIKey{Labels: query.Result.Labels, AccessWindows: cmd.AccessWindows}
+		if err := s.encryptMetadata(ctx, &metadata); err != nil {
+			return err
+		}
+		storeCmd.EncryptedMetadata = &metadata.EncryptedMetadata
+	}
+	return s.store.UpdateAPIKey(ctx, &storeCmd)
+}
+func (s *Service) ApproveAPIKey(ctx context.Context, cmd *apikey.ApproveCommand) error {
+	return s.store.ApproveAPIKey(ctx, cmd)
+}
+func (s *Service) RejectAPIKey(ctx context.Context, cmd *apikey.RejectCommand) error {
+	if err := s.store.RejectAPIKey(ctx, cmd); err != nil {
+		return err
+	}
+	deletedCounter.Inc()
+	return nil
+}
+func (s *Service) UpdateAPIKeyLastUsed(ctx context.Context, cmd *apikey.UpdateLastUsedCommand) error {
+	if s.lastUsed != nil {
+		s.lastUsed.add(cmd)
+		return nil
+	}
+	return s.updateLastUsed(ctx, []*apikey.UpdateLastUsedCommand{cmd})
+}
+
+// ReportValidation records the outcome of the authentication of a request
+// with an API key.
+func (s *Service) ReportValidation(outcome apikey.ValidationOutcome) {
+	validationsCounter.Inc()
+	if outcome != apikey.ValidationSucceeded {
+		validationFailuresCounter.WithLabelValues(string(outcome)).Inc()
+	}
 }
@@ -0,0 +1,251 @@
+package apikeyimpl
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	secretsDatabase "github.com/grafana/grafana/pkg/services/secrets/database"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/util"
+)
+
+func TestIntegrationBufferedLastUsed(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	cfg := *db.Cfg
+	cfg.ApiKeyLastUsedFlushInterval = time.Minute
+	s := ProvideService(db, &cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clock.New())
+
+	cmd := apikey.AddCommand{OrgId: 1, Name: "buffered", Key: "buffered"}
+	require.NoError(t, s.AddAPIKey(context.Background(), &cmd))
+
+	used := time.Now().Truncate(time.Second)
+	require.NoError(t, s.UpdateAPIKeyLastUsed(context.Background(), &apikey.UpdateLastUsedCommand{Id: cmd.Result.Id, IP: "10.0.0.2", UserAgent: "new", Time: used}))
+	require.NoError(t, s.UpdateAPIKeyLastUsed(context.Background(), &apikey.UpdateLastUsedCommand{Id: cmd.Result.Id, IP: "10.0.0.1", UserAgent: "old", Time: used.Add(-time.Minute)}))
+
+	query := apikey.GetByNameQuery{OrgId: 1, KeyName: "buffered"}
+	require.NoError(t, s.GetApiKeyByName(context.Background(), &query))
+	assert.Nil(t, query.Result.LastUsedAt, "usage should not be written before the buffer is flushed")
+
+	s.flushLastUsed(context.Background())
+
+	require.NoError(t, s.GetApiKeyByName(context.Background(), &query))
+	require.NotNil(t, query.Result.LastUsedAt)
+	assert.Equal(t, used.Unix(), query.Result.LastUsedAt.Unix())
+	assert.Equal(t, "10.0.0.2", query.Result.LastUsedIP)
+	assert.Equal(t, "new", query.Result.LastUsedUserAgent)
+}
+
+func TestIntegrationVerifySecret(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	cfg := *db.Cfg
+	cfg.ApiKeyHashingAlgorithm = setting.ApiKeyHashingArgon2id
+	cfg.ApiKeyArgon2idTime = 1
+	cfg.ApiKeyArgon2idMemory = 1024
+	cfg.ApiKeyArgon2idThreads = 1
+	s := ProvideService(db, &cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clock.New())
+
+	legacyHash, err := util.EncodePassword("secret", "legacy")
+	require.NoError(t, err)
+	cmd := apikey.AddCommand{OrgId: 1, Name: "legacy", Key: legacyHash}
+	require.NoError(t, s.AddAPIKey(context.Background(), &cmd))
+
+	t.Run("the store requests a rehash of legacy keys", func(t *testing.T) {
+		key, needsRehash, err := s.store.GetAPIKeyByHash(context.Background(), legacyHash)
+		require.NoError(t, err)
+		assert.Equal(t, cmd.Result.Id, key.Id)
+		assert.True(t, needsRehash)
+	})
+
+	t.Run("invalid secret is not rehashed", func(t *testing.T) {
+		query := apikey.VerifySecretQuery{Key: cmd.Result, Secret: "wrong"}
+		require.NoError(t, s.VerifySecret(context.Background(), &query))
+		assert.False(t, query.Result)
+		assert.Equal(t, legacyHash, cmd.Result.Key)
+	})
+
+	t.Run("valid legacy secret is rehashed", func(t *testing.T) {
+		query := apikey.VerifySecretQuery{Key: cmd.Result, Secret: "secret"}
+		require.NoError(t, s.VerifySecret(context.Background(), &query))
+		assert.True(t, query.Result)
+
+		byName := apikey.GetByNameQuery{OrgId: 1, KeyName: "legacy"}
+		require.NoError(t, s.GetApiKeyByName(context.Background(), &byName))
+		assert.True(t, strings.HasPrefix(byName.Result.Key, argon2idPrefix))
+
+		query = apikey.VerifySecretQuery{Key: byName.Result, Secret: "secret"}
+		require.NoError(t, s.VerifySecret(context.Background(), &query))
+		assert.True(t, query.Result)
+		assert.Equal(t, byName.Result.Key, query.Key.Key, "up to date hash should not be rehashed")
+	})
+
+	t.Run("new secrets are hashed with the configured algorithm", func(t *testing.T) {
+		add := apikey.AddCommand{OrgId: 1, Name: "new", Secret: "first"}
+		require.NoError(t, s.AddAPIKey(context.Background(), &add))
+		assert.True(t, strings.HasPrefix(add.Result.Key, argon2idPrefix))
+
+		query := apikey.VerifySecretQuery{Key: add.Result, Secret: "first"}
+		require.NoError(t, s.VerifySecret(context.Background(), &query))
+		assert.True(t, query.Result)
+
+		rotate := apikey.RotateCommand{Id: add.Result.Id, OrgId: 1, Secret: "second"}
+		require.NoError(t, s.RotateAPIKey(context.Background(), &rotate))
+		assert.True(t, strings.HasPrefix(rotate.Result.Key, argon2idPrefix))
+
+		query = apikey.VerifySecretQuery{Key: rotate.Result, Secret: "second"}
+		require.NoError(t, s.VerifySecret(context.Background(), &query))
+		assert.True(t, query.Result)
+	})
+
+	t.Run("rotating a missing key fails", func(t *testing.T) {
+		rotate := apikey.RotateCommand{Id: 1000, OrgId: 1, Secret: "secret"}
+		assert.ErrorIs(t, s.RotateAPIKey(context.Background(), &rotate), apikey.ErrNotFound)
+	})
+}
+
+func TestIntegrationExportImportKeys(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	secretsService := secretsManager.SetupTestService(t, secretsDatabase.ProvideSecretsStore(db))
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	s := ProvideService(db, db.Cfg, secretsService, encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clk)
+	ctx := context.Background()
+
+	keys := []apikey.AddCommand{
+		{OrgId: 1, Name: "exported", Key: "exported-hash", Role: org.RoleEditor, Labels: map[string]string{"team": "a"}, RateLimitRPS: 5},
+		{OrgId: 1, Name: "signing", Key: "signing-hash", Role: org.RoleViewer, Type: apikey.TypeSigning, SigningSecret: "s3cr3t"},
+		{OrgId: 1, Name: "expired", Key: "expired-hash", Role: org.RoleViewer, SecondsToLive: 1},
+		{OrgId: 2, Name: "other-org", Key: "other-org-hash", Role: org.RoleViewer},
+	}
+	for i := range keys {
+		require.NoError(t, s.AddAPIKey(ctx, &keys[i]))
+	}
+
+	clk.Add(time.Hour)
+
+	t.Run("requires exactly one of a passphrase and a key provider", func(t *testing.T) {
+		require.ErrorIs(t, s.ExportKeys(ctx, &apikey.ExportKeysQuery{}), apikey.ErrBundleKey)
+		require.ErrorIs(t, s.ExportKeys(ctx, &apikey.ExportKeysQuery{Passphrase: "pass", KeyProvider: "fake.v1.key"}), apikey.ErrBundleKey)
+		require.ErrorIs(t, s.ExportKeys(ctx, &apikey.ExportKeysQuery{KeyProvider: "unknown.v1.key"}), apikey.ErrUnknownKeyProvider)
+	})
+
+	export := apikey.ExportKeysQuery{OrgIds: []int64{1}, Passphrase: "pass"}
+	require.NoError(t, s.ExportKeys(ctx, &export))
+	assert.NotContains(t, string(export.Result), "exported-hash", "the bundle should be encrypted")
+	assert.NotContains(t, string(export.Result), "s3cr3t", "the bundle should be encrypted")
+
+	t.Run("keys that already exist are skipped", func(t *testing.T) {
+		cmd := apikey.ImportKeysCommand{Bundle: export.Result, Passphrase: "pass"}
+		require.NoError(t, s.ImportKeys(ctx, &cmd))
+		assert.Equal(t, apikey.ImportKeysResult{Imported: 0, Skipped: 2}, *cmd.Result)
+	})
+
+	t.Run("deleted keys are imported with their metadata", func(t *testing.T) {
+		require.NoError(t, s.DeleteApiKey(ctx, &apikey.DeleteCommand{Id: keys[0].Result.Id, OrgId: 1}))
+
+		cmd := apikey.ImportKeysCommand{Bundle: export.Result, Passphrase: "pass"}
+		require.NoError(t, s.ImportKeys(ctx, &cmd))
+		assert.Equal(t, apikey.ImportKeysResult{Imported: 1, Skipped: 1}, *cmd.Result)
+
+		query := apikey.GetByNameQuery{OrgId: 1, KeyName: "exported"}
+		require.NoError(t, s.GetApiKeyByName(ctx, &query))
+		assert.Equal(t, "exported-hash", query.Result.Key)
+		assert.Equal(t, org.RoleEditor, query.Result.Role)
+		assert.Equal(t, map[string]string{"team": "a"}, query.Result.Labels)
+		assert.Equal(t, int64(5), query.Result.RateLimitRPS)
+	})
+
+	t.Run("keys are imported by an instance with other data keys", func(t *testing.T) {
+		for _, key := range keys[:2] {
+			require.NoError(t, s.DeleteByFilter(ctx, &apikey.DeleteByFilterCommand{OrgId: 1, NamePrefix: key.Name}))
+		}
+
+		other := ProvideService(db, db.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clk)
+		cmd := apikey.ImportKeysCommand{Bundle: export.Result, Passphrase: "pass"}
+		require.NoError(t, other.ImportKeys(ctx, &cmd))
+		assert.Equal(t, apikey.ImportKeysResult{Imported: 2, Skipped: 0}, *cmd.Result)
+
+		// the signing secret is encrypted again with the secrets service of the importing instance
+		query := apikey.GetByNameQuery{OrgId: 1, KeyName: "signing"}
+		require.NoError(t, other.GetApiKeyByName(ctx, &query))
+		secret, err := other.decryptSigningSecret(ctx, query.Result.SigningSecret)
+		require.NoError(t, err)
+		assert.Equal(t, "s3cr3t", secret)
+	})
+
+	t.Run("keys are encrypted with a key provider", func(t *testing.T) {
+		withProvider := ProvideService(db, db.Cfg, fakeKeyProviders{FakeSecretsService: fakes.NewFakeSecretsService()},
+			encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clk)
+		query := apikey.ExportKeysQuery{OrgIds: []int64{1}, KeyProvider: "fake.v1.key"}
+		require.NoError(t, withProvider.ExportKeys(ctx, &query))
+
+		cmd := apikey.ImportKeysCommand{Bundle: query.Result}
+		require.NoError(t, withProvider.ImportKeys(ctx, &cmd))
+		assert.Equal(t, apikey.ImportKeysResult{Imported: 0, Skipped: 2}, *cmd.Result)
+	})
+
+	t.Run("bundles that cannot be decrypted are rejected", func(t *testing.T) {
+		cmd := apikey.ImportKeysCommand{Bundle: export.Result, Passphrase: "wrong"}
+		require.ErrorIs(t, s.ImportKeys(ctx, &cmd), apikey.ErrBundleDecryption)
+
+		cmd = apikey.ImportKeysCommand{Bundle: export.Result}
+		require.ErrorIs(t, s.ImportKeys(ctx, &cmd), apikey.ErrBundleKey)
+
+		cmd = apikey.ImportKeysCommand{Bundle: []byte("not a bundle"), Passphrase: "pass"}
+		require.ErrorIs(t, s.ImportKeys(ctx, &cmd), apikey.ErrInvalidBundle)
+	})
+}
+
+// fakeKeyProviders is a secrets service with a key provider reversing the
+// encrypted blobs.
+type fakeKeyProviders struct {
+	fakes.FakeSecretsService
+}
+
+func (fakeKeyProviders) GetProviders() map[secrets.ProviderID]secrets.Provider {
+	return map[secrets.ProviderID]secrets.Provider{"fake.v1.key": fakeKeyProvider{}}
+}
+
+type fakeKeyProvider struct{}
+
+func (fakeKeyProvider) Encrypt(_ context.Context, blob []byte) ([]byte, error) {
+	return reverse(blob), nil
+}
+
+func (fakeKeyProvider) Decrypt(_ context.Context, blob []byte) ([]byte, error) {
+	return reverse(blob), nil
+}
+
+func reverse(blob []byte) []byte {
+	reversed := make([]byte, len(blob))
+	for i, b := range blob {
+		reversed[len(blob)-1-i] = b
+	}
+	return reversed
+}
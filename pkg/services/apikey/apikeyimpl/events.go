@@ -0,0 +1,88 @@
+package apikeyimpl
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/services/sqlstore/db"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	// expiredEventsInterval is how often the API keys that expired are looked
+	// up to publish their ApiKeyExpired event.
+	expiredEventsInterval = time.Minute
+	// expiredEventsCheckpointKey holds the time until which expired keys were
+	// published, so that each expiration is published once by one instance.
+	expiredEventsCheckpointKey = "expired-events-checkpoint"
+)
+
+// ExpiredEventPublisher publishes an ApiKeyExpired event on the bus when an
+// API key expires. Keys expire when their expiration passes, nothing else
+// happens at that time.
+type ExpiredEventPublisher struct {
+	store      store
+	kvStore    *kvstore.NamespacedKVStore
+	serverLock *serverlock.ServerLockService
+	log        log.Logger
+	clock      clock.Clock
+}
+
+func ProvideExpiredEventPublisher(db db.DB, cfg *setting.Cfg, serverLockService *serverlock.ServerLockService, kvStore kvstore.KVStore, clk clock.Clock) *ExpiredEventPublisher {
+	return &ExpiredEventPublisher{
+		store:      &sqlStore{db: db, cfg: cfg, clock: clk},
+		kvStore:    kvstore.WithNamespace(kvStore, 0, kvStoreNamespace),
+		serverLock: serverLockService,
+		log:        log.New("apikey.expired-events"),
+		clock:      clk,
+	}
+}
+
+func (p *ExpiredEventPublisher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(expiredEventsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := p.serverLock.LockAndExecute(ctx, "publish expired api keys", expiredEventsInterval/2, func(ctx context.Context) {
+				if err := p.publishExpired(ctx, p.clock.Now()); err != nil {
+					p.log.Error("failed to publish expired api keys", "error", err)
+				}
+			})
+			if err != nil {
+				p.log.Error("failed to lock and execute expired api keys publication", "error", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// publishExpired publishes the keys that expired since the checkpoint, keys
+// that expired before the first run are not published.
+func (p *ExpiredEventPublisher) publishExpired(ctx context.Context, now time.Time) error {
+	value, exists, err := p.kvStore.Get(ctx, expiredEventsCheckpointKey)
+	if err != nil {
+		return err
+	}
+	if exists {
+		checkpoint, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		count, err := p.store.PublishExpiredAPIKeys(ctx, time.Unix(checkpoint, 0), now)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			p.log.Debug("published expired api keys", "count", count)
+		}
+	}
+	return p.kvStore.Set(ctx, expiredEventsCheckpointKey, strconv.FormatInt(now.Unix(), 10))
+}
@@ -0,0 +1,90 @@
+package apikeyimpl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+func TestIntegrationAPIKeyEvents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	kv := kvstore.ProvideService(db)
+	clk := clock.NewMock()
+	s := ProvideService(db, db.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kv, accesscontrolmock.New(), clk)
+	ctx := context.Background()
+
+	var published []interface{}
+	db.Bus().AddEventListener(func(ctx context.Context, e *events.ApiKeyCreated) error {
+		published = append(published, *e)
+		return nil
+	})
+	db.Bus().AddEventListener(func(ctx context.Context, e *events.ApiKeyRotated) error {
+		published = append(published, *e)
+		return nil
+	})
+	db.Bus().AddEventListener(func(ctx context.Context, e *events.ApiKeyDeleted) error {
+		published = append(published, *e)
+		return nil
+	})
+	db.Bus().AddEventListener(func(ctx context.Context, e *events.ApiKeyExpired) error {
+		published = append(published, *e)
+		return nil
+	})
+
+	now := time.Now().Truncate(time.Second)
+	clk.Set(now)
+
+	t.Run("changes of keys are published with their actor", func(t *testing.T) {
+		published = nil
+		add := apikey.AddCommand{OrgId: 1, Name: "published", Key: "published", CreatedBy: 10}
+		require.NoError(t, s.AddAPIKey(ctx, &add))
+		id := add.Result.Id
+		require.NoError(t, s.RotateAPIKey(ctx, &apikey.RotateCommand{Id: id, OrgId: 1, Key: "rotated", ActorId: 11}))
+		require.NoError(t, s.DeleteApiKey(ctx, &apikey.DeleteCommand{Id: id, OrgId: 1, ActorId: 12}))
+
+		assert.Equal(t, []interface{}{
+			events.ApiKeyCreated{Timestamp: now, ID: id, OrgID: 1, ActorID: 10},
+			events.ApiKeyRotated{Timestamp: now, ID: id, OrgID: 1, ActorID: 11},
+			events.ApiKeyDeleted{Timestamp: now, ID: id, OrgID: 1, ActorID: 12},
+		}, published)
+	})
+
+	t.Run("failed changes are not published", func(t *testing.T) {
+		published = nil
+		require.ErrorIs(t, s.DeleteApiKey(ctx, &apikey.DeleteCommand{Id: 1000, OrgId: 1}), apikey.ErrNotFound)
+		assert.Empty(t, published)
+	})
+
+	t.Run("expired keys are published once", func(t *testing.T) {
+		publisher := &ExpiredEventPublisher{store: s.store, kvStore: kvstore.WithNamespace(kv, 0, kvStoreNamespace), log: log.New("test"), clock: clk}
+		expiring := apikey.AddCommand{OrgId: 1, Name: "expiring", Key: "expiring", SecondsToLive: 60}
+		require.NoError(t, s.AddAPIKey(ctx, &expiring))
+		require.NoError(t, s.AddAPIKey(ctx, &apikey.AddCommand{OrgId: 1, Name: "later", Key: "later", SecondsToLive: 3600}))
+
+		published = nil
+		require.NoError(t, publisher.publishExpired(ctx, now))
+		require.NoError(t, publisher.publishExpired(ctx, now.Add(2*time.Minute)))
+		require.NoError(t, publisher.publishExpired(ctx, now.Add(3*time.Minute)))
+
+		assert.Equal(t, []interface{}{
+			events.ApiKeyExpired{Timestamp: now.Add(time.Minute), ID: expiring.Result.Id, OrgID: 1},
+		}, published)
+	})
+}
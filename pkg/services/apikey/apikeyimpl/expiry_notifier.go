@@ -0,0 +1,170 @@
+package apikeyimpl
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/notifications"
+	"github.com/grafana/grafana/pkg/services/sqlstore/db"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	expiryNotificationNamespace = "apikey.expiry_notifications"
+	expiryNotificationInterval  = time.Hour
+	expiryNotificationTemplate  = "api_key_expiring"
+)
+
+// ExpiryNotifier periodically looks for API keys that are about to expire and
+// announces them by email and webhook. Each key is announced once per expiration
+// date, a key whose expiration changes is announced again.
+type ExpiryNotifier struct {
+	cfg           *setting.Cfg
+	store         store
+	kvStore       kvstore.KVStore
+	notifications notifications.Service
+	serverLock    *serverlock.ServerLockService
+	log           log.Logger
+	clock         clock.Clock
+}
+
+func ProvideExpiryNotifier(db db.DB, cfg *setting.Cfg, kvStore kvstore.KVStore,
+	notificationService notifications.Service, serverLockService *serverlock.ServerLockService, clk clock.Clock) *ExpiryNotifier {
+	return &ExpiryNotifier{
+		cfg:           cfg,
+		store:         &sqlStore{db: db, cfg: cfg, clock: clk},
+		kvStore:       kvStore,
+		notifications: notificationService,
+		serverLock:    serverLockService,
+		log:           log.New("apikey.expiry-notifier"),
+		clock:         clk,
+	}
+}
+
+// IsDisabled returns true when no notification window has been configured.
+func (n *ExpiryNotifier) IsDisabled() bool {
+	return n.cfg.ApiKeyExpiryNotificationWindow <= 0
+}
+
+func (n *ExpiryNotifier) Run(ctx context.Context) error {
+	ticker := time.NewTicker(expiryNotificationInterval)
+	defer ticker.Stop()
+
+	for {
+		err := n.serverLock.LockAndExecute(ctx, "notify about expiring api keys", expiryNotificationInterval, func(ctx context.Context) {
+			if err := n.notifyExpiringKeys(ctx); err != nil {
+				n.log.Error("failed to notify about expiring api keys", "error", err)
+			}
+		})
+		if err != nil {
+			n.log.Error("failed to lock and execute expiring api keys notification", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (n *ExpiryNotifier) notifyExpiringKeys(ctx context.Context) error {
+	before := n.clock.Now().Add(n.cfg.ApiKeyExpiryNotificationWindow).Unix()
+	keys, err := n.store.GetKeysExpiringBefore(ctx, before)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		expires := strconv.FormatInt(*key.Expires, 10)
+		notifiedFor, ok, err := n.kvStore.Get(ctx, key.OrgId, expiryNotificationNamespace, strconv.FormatInt(key.Id, 10))
+		if err != nil {
+			return err
+		}
+		if ok && notifiedFor == expires {
+			continue
+		}
+
+		if err := n.notify(ctx, key); err != nil {
+			n.log.Warn("failed to notify about expiring api key", "keyId", key.Id, "orgId", key.OrgId, "error", err)
+			continue
+		}
+
+		if err := n.kvStore.Set(ctx, key.OrgId, expiryNotificationNamespace, strconv.FormatInt(key.Id, 10), expires); err != nil {
+			return err
+		}
+		n.log.Debug("notified about expiring api key", "keyId", key.Id, "orgId", key.OrgId, "expires", expires)
+	}
+
+	return nil
+}
+
+func (n *ExpiryNotifier) notify(ctx context.Context, key *apikey.APIKey) error {
+	expires := time.Unix(*key.Expires, 0).UTC()
+
+	if len(n.cfg.ApiKeyExpiryNotificationEmails) > 0 {
+		err := n.notifications.SendEmailCommandHandlerSync(ctx, &models.SendEmailCommandSync{
+			SendEmailCommand: models.SendEmailCommand{
+				To:       n.cfg.ApiKeyExpiryNotificationEmails,
+				Template: expiryNotificationTemplate,
+				Data: map[string]interface{}{
+					"KeyName": key.Name,
+					"OrgId":   key.OrgId,
+					"Expires": expires.Format(time.RFC1123),
+					"KeysUrl": setting.ToAbsUrl("org/apikeys"),
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.cfg.ApiKeyExpiryNotificationWebhookURL != "" {
+		body, err := json.Marshal(expiringKeyWebhookBody{
+			Event:   webhookEventExpiring,
+			OrgId:   key.OrgId,
+			KeyId:   key.Id,
+			KeyName: key.Name,
+			Expires: expires,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = n.notifications.SendWebhookSync(ctx, &models.SendWebhookSync{
+			Url:         n.cfg.ApiKeyExpiryNotificationWebhookURL,
+			Body:        string(body),
+			HttpMethod:  "POST",
+			ContentType: "application/json",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The events of the webhook bodies, the expiring keys and the idle keys are
+// announced to the same webhook.
+const (
+	webhookEventExpiring       = "api_key_expiring"
+	webhookEventIdleRevocation = "api_key_idle_revocation"
+)
+
+type expiringKeyWebhookBody struct {
+	Event   string    `json:"event"`
+	OrgId   int64     `json:"orgId"`
+	KeyId   int64     `json:"keyId"`
+	KeyName string    `json:"keyName"`
+	Expires time.Time `json:"expires"`
+}
@@ -0,0 +1,83 @@
+package apikeyimpl
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/notifications"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+func TestIntegrationExpiryNotifier(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	db.Cfg.ApiKeyExpiryNotificationWindow = 24 * time.Hour
+	db.Cfg.ApiKeyExpiryNotificationEmails = []string{"admin@example.com"}
+	db.Cfg.ApiKeyExpiryNotificationWebhookURL = "http://localhost/webhook"
+
+	ss := &sqlStore{db: db, cfg: db.Cfg, clock: clock.New()}
+	for _, cmd := range []*apikey.AddCommand{
+		{OrgId: 1, Name: "expiring-soon", Key: "expiring-soon", SecondsToLive: 3600},
+		{OrgId: 1, Name: "expiring-later", Key: "expiring-later", SecondsToLive: 30 * 24 * 3600},
+		{OrgId: 1, Name: "never-expiring", Key: "never-expiring"},
+	} {
+		require.NoError(t, ss.AddAPIKey(context.Background(), cmd))
+	}
+
+	var emails []models.SendEmailCommandSync
+	var webhooks []models.SendWebhookSync
+	notificationService := notifications.MockNotificationService()
+	notificationService.EmailHandlerSync = func(_ context.Context, cmd *models.SendEmailCommandSync) error {
+		emails = append(emails, *cmd)
+		return nil
+	}
+	notificationService.WebhookHandler = func(_ context.Context, cmd *models.SendWebhookSync) error {
+		webhooks = append(webhooks, *cmd)
+		return nil
+	}
+
+	notifier := &ExpiryNotifier{
+		cfg:           db.Cfg,
+		store:         ss,
+		kvStore:       kvstore.ProvideService(db),
+		notifications: notificationService,
+		log:           log.New("test"),
+		clock:         clock.New(),
+	}
+	require.False(t, notifier.IsDisabled())
+
+	t.Run("notifies about keys expiring within the window", func(t *testing.T) {
+		require.NoError(t, notifier.notifyExpiringKeys(context.Background()))
+
+		require.Len(t, emails, 1)
+		assert.Equal(t, []string{"admin@example.com"}, emails[0].To)
+		assert.Equal(t, "expiring-soon", emails[0].Data["KeyName"])
+
+		require.Len(t, webhooks, 1)
+		var body expiringKeyWebhookBody
+		require.NoError(t, json.Unmarshal([]byte(webhooks[0].Body), &body))
+		assert.Equal(t, webhookEventExpiring, body.Event)
+		assert.Equal(t, "expiring-soon", body.KeyName)
+		assert.Equal(t, int64(1), body.OrgId)
+	})
+
+	t.Run("does not notify twice about the same key", func(t *testing.T) {
+		require.NoError(t, notifier.notifyExpiringKeys(context.Background()))
+
+		assert.Len(t, emails, 1)
+		assert.Len(t, webhooks, 1)
+	})
+}
@@ -0,0 +1,275 @@
+package apikeyimpl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/secrets"
+)
+
+const exportBundleVersion = 2
+
+// exportBundle is an export of API keys. The keys are encrypted with a random
+// data key, which is encrypted with a passphrase, or with a key provider such
+// as a KMS key when KeyProvider is set. Unlike the secrets service, neither
+// depends on the data keys of the exporting instance.
+type exportBundle struct {
+	Version      int    `json:"version"`
+	KeyProvider  string `json:"keyProvider,omitempty"`
+	EncryptedKey []byte `json:"encryptedKey"`
+	Keys         []byte `json:"keys"`
+}
+
+type keyProviders interface {
+	GetProviders() map[secrets.ProviderID]secrets.Provider
+}
+
+// exportedKey is the metadata needed to recreate an API key on another
+// instance. Secrets are not known by Grafana, only their hashes are exported,
+// so that clients keep using the same secrets after the migration. The
+// organization ID is part of the secrets, keys are imported in the
+// organization with the same ID.
+type exportedKey struct {
+	OrgId              int64                 `json:"orgId"`
+	Name               string                `json:"name"`
+	Hash               string                `json:"hash"`
+	Role               org.RoleType          `json:"role"`
+	Created            time.Time             `json:"created"`
+	Expires            *int64                `json:"expires,omitempty"`
+	PreviousHash       *string               `json:"previousHash,omitempty"`
+	PreviousKeyExpires *int64                `json:"previousKeyExpires,omitempty"`
+	SecondaryHash      *string               `json:"secondaryHash,omitempty"`
+	AllowedIPRanges    string                `json:"allowedIpRanges,omitempty"`
+	DatasourceUIDs     string                `json:"datasourceUids,omitempty"`
+	OwnerTeamId        *int64                `json:"ownerTeamId,omitempty"`
+	AccessWindows      []apikey.AccessWindow `json:"accessWindows,omitempty"`
+	Labels             map[string]string     `json:"labels,omitempty"`
+	RateLimitRPS       int64                 `json:"rateLimitRps,omitempty"`
+	Status             string                `json:"status,omitempty"`
+	Type               string                `json:"type,omitempty"`
+	// SigningSecret is the decrypted secret of a signing key, it is encrypted
+	// again by the secrets service of the importing instance.
+	SigningSecret string `json:"signingSecret,omitempty"`
+	// PreviousSigningSecret is the decrypted secret replaced by the last
+	// rotation of a signing key, valid until PreviousKeyExpires.
+	PreviousSigningSecret *string `json:"previousSigningSecret,omitempty"`
+}
+
+// ExportKeys exports the API keys that have not expired. Keys of service
+// accounts are not exported.
+func (s *Service) ExportKeys(ctx context.Context, query *apikey.ExportKeysQuery) error {
+	if (query.Passphrase == "") == (query.KeyProvider == "") {
+		return apikey.ErrBundleKey
+	}
+
+	orgIDs := query.OrgIds
+	if len(orgIDs) == 0 {
+		orgIDs = []int64{-1}
+	}
+
+	now := s.clock.Now().Unix()
+	exported := []exportedKey{}
+	for _, orgID := range orgIDs {
+		for _, key := range s.store.GetAllAPIKeys(ctx, orgID) {
+			if key.Expires != nil && *key.Expires <= now {
+				continue
+			}
+			signingSecret := ""
+			var previousSigningSecret *string
+			if key.IsSigning() && key.SigningSecret != "" {
+				var err error
+				if signingSecret, err = s.decryptSigningSecret(ctx, key.SigningSecret); err != nil {
+					return err
+				}
+				if key.PreviousSigningSecret != nil {
+					previous, err := s.decryptSigningSecret(ctx, *key.PreviousSigningSecret)
+					if err != nil {
+						return err
+					}
+					previousSigningSecret = &previous
+				}
+			}
+			exported = append(exported, exportedKey{
+				OrgId:                 key.OrgId,
+				Name:                  key.Name,
+				Hash:                  key.Key,
+				Role:                  key.Role,
+				Created:               key.Created,
+				Expires:               key.Expires,
+				PreviousHash:          key.PreviousKey,
+				PreviousKeyExpires:    key.PreviousKeyExpires,
+				SecondaryHash:         key.SecondaryKey,
+				AllowedIPRanges:       key.AllowedIPRanges,
+				DatasourceUIDs:        key.DatasourceUIDs,
+				OwnerTeamId:           key.OwnerTeamId,
+				AccessWindows:         key.AccessWindows,
+				Labels:                key.Labels,
+				RateLimitRPS:          key.RateLimitRPS,
+				Status:                key.Status,
+				Type:                  key.Type,
+				SigningSecret:         signingSecret,
+				PreviousSigningSecret: previousSigningSecret,
+			})
+		}
+	}
+
+	payload, err := json.Marshal(exported)
+	if err != nil {
+		return err
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	bundle := exportBundle{Version: exportBundleVersion, KeyProvider: query.KeyProvider}
+	if bundle.Keys, err = s.encryption.Encrypt(ctx, payload, hex.EncodeToString(dataKey)); err != nil {
+		return err
+	}
+	if query.KeyProvider != "" {
+		provider, err := s.keyProvider(query.KeyProvider)
+		if err != nil {
+			return err
+		}
+		bundle.EncryptedKey, err = provider.Encrypt(ctx, dataKey)
+		if err != nil {
+			return err
+		}
+	} else if bundle.EncryptedKey, err = s.encryption.Encrypt(ctx, dataKey, query.Passphrase); err != nil {
+		return err
+	}
+
+	query.Result, err = json.Marshal(bundle)
+	return err
+}
+
+// keyProvider returns the encryption provider of the instance with the id.
+func (s *Service) keyProvider(id string) (secrets.Provider, error) {
+	providers, ok := s.secretsService.(keyProviders)
+	if !ok {
+		return nil, apikey.ErrUnknownKeyProvider
+	}
+	provider, ok := providers.GetProviders()[secrets.ProviderID(id)]
+	if !ok {
+		return nil, apikey.ErrUnknownKeyProvider
+	}
+	return provider, nil
+}
+
+// decryptBundle returns the keys of a bundle produced by ExportKeys.
+func (s *Service) decryptBundle(ctx context.Context, data []byte, passphrase string) ([]exportedKey, error) {
+	var bundle exportBundle
+	if err := json.Unmarshal(data, &bundle); err != nil || bundle.Version != exportBundleVersion {
+		return nil, apikey.ErrInvalidBundle
+	}
+
+	var dataKey []byte
+	var err error
+	if bundle.KeyProvider != "" {
+		provider, err := s.keyProvider(bundle.KeyProvider)
+		if err != nil {
+			return nil, err
+		}
+		dataKey, err = provider.Decrypt(ctx, bundle.EncryptedKey)
+		if err != nil {
+			s.log.Debug("failed to decrypt the key of the api key bundle", "error", err)
+			return nil, apikey.ErrBundleDecryption
+		}
+	} else {
+		if passphrase == "" {
+			return nil, apikey.ErrBundleKey
+		}
+		if dataKey, err = s.encryption.Decrypt(ctx, bundle.EncryptedKey, passphrase); err != nil {
+			s.log.Debug("failed to decrypt the key of the api key bundle", "error", err)
+			return nil, apikey.ErrBundleDecryption
+		}
+	}
+	payload, err := s.encryption.Decrypt(ctx, bundle.Keys, hex.EncodeToString(dataKey))
+	if err != nil {
+		s.log.Debug("failed to decrypt the keys of the api key bundle", "error", err)
+		return nil, apikey.ErrBundleDecryption
+	}
+	// a wrong passphrase doesn't always fail the decryption, it then fails the parsing of the garbled payload
+	var keys []exportedKey
+	if err := json.Unmarshal(payload, &keys); err != nil {
+		return nil, apikey.ErrBundleDecryption
+	}
+	return keys, nil
+}
+
+// ImportKeys imports the API keys of a bundle produced by ExportKeys,
+// decrypted with the passphrase or the key provider it was encrypted with.
+func (s *Service) ImportKeys(ctx context.Context, cmd *apikey.ImportKeysCommand) error {
+	exported, err := s.decryptBundle(ctx, cmd.Bundle, cmd.Passphrase)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*apikey.APIKey, 0, len(exported))
+	for _, k := range exported {
+		if k.OrgId == 0 || k.Name == "" || k.Hash == "" || !k.Role.IsValid() {
+			return apikey.ErrInvalidBundle
+		}
+		status := apikey.StatusActive
+		if k.Status == apikey.StatusPending {
+			status = apikey.StatusPending
+		}
+		keyType := apikey.TypeBearer
+		signingSecret := ""
+		var previousSigningSecret *string
+		if k.Type == apikey.TypeSigning {
+			if k.SigningSecret == "" {
+				return apikey.ErrInvalidBundle
+			}
+			keyType = apikey.TypeSigning
+			if signingSecret, err = s.encryptSigningSecret(ctx, k.SigningSecret); err != nil {
+				return err
+			}
+			if k.PreviousSigningSecret != nil {
+				previous, err := s.encryptSigningSecret(ctx, *k.PreviousSigningSecret)
+				if err != nil {
+					return err
+				}
+				previousSigningSecret = &previous
+			}
+		}
+		keys = append(keys, &apikey.APIKey{
+			OrgId:                 k.OrgId,
+			Name:                  k.Name,
+			Key:                   k.Hash,
+			Role:                  k.Role,
+			Created:               k.Created,
+			Expires:               k.Expires,
+			PreviousKey:           k.PreviousHash,
+			PreviousKeyExpires:    k.PreviousKeyExpires,
+			SecondaryKey:          k.SecondaryHash,
+			AllowedIPRanges:       k.AllowedIPRanges,
+			DatasourceUIDs:        k.DatasourceUIDs,
+			OwnerTeamId:           k.OwnerTeamId,
+			AccessWindows:         k.AccessWindows,
+			Labels:                k.Labels,
+			RateLimitRPS:          k.RateLimitRPS,
+			Status:                status,
+			Type:                  keyType,
+			SigningSecret:         signingSecret,
+			PreviousSigningSecret: previousSigningSecret,
+		})
+	}
+
+	for _, key := range keys {
+		if err := s.encryptMetadata(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	imported, err := s.store.ImportAPIKeys(ctx, keys)
+	if err != nil {
+		return err
+	}
+	cmd.Result = &apikey.ImportKeysResult{Imported: imported, Skipped: len(keys) - imported}
+	return nil
+}
@@ -0,0 +1,123 @@
+package apikeyimpl
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/util"
+)
+
+var errUnknownHash = errors.New("unknown API key hash format")
+
+// Hasher hashes the secrets of API keys. Hashes identify the hasher that
+// produced them, so that keys keep working after the configured algorithm
+// changes.
+type Hasher interface {
+	// Hash hashes the secret of the API key with the given name.
+	Hash(secret, name string) (string, error)
+	// Verify reports whether the secret matches the hash, and whether the hash
+	// should be recomputed because it was produced with other parameters.
+	Verify(secret, name, hashed string) (valid bool, needsRehash bool, err error)
+	// Recognizes returns true if the hash was produced by the hasher.
+	Recognizes(hashed string) bool
+}
+
+// newHashers returns the hasher configured for new hashes, followed by all
+// the hashers able to verify existing ones.
+func newHashers(cfg *setting.Cfg) (Hasher, []Hasher) {
+	pbkdf2 := &pbkdf2Hasher{}
+	argon2id := &argon2idHasher{
+		time:    uint32(cfg.ApiKeyArgon2idTime),
+		memory:  uint32(cfg.ApiKeyArgon2idMemory),
+		threads: uint8(cfg.ApiKeyArgon2idThreads),
+	}
+
+	hashers := []Hasher{argon2id, pbkdf2}
+	if cfg.ApiKeyHashingAlgorithm == setting.ApiKeyHashingArgon2id {
+		return argon2id, hashers
+	}
+	return pbkdf2, hashers
+}
+
+// pbkdf2Hasher is the legacy hasher, salting the secret with the key name.
+type pbkdf2Hasher struct{}
+
+func (h *pbkdf2Hasher) Hash(secret, name string) (string, error) {
+	return util.EncodePassword(secret, name)
+}
+
+func (h *pbkdf2Hasher) Verify(secret, name, hashed string) (bool, bool, error) {
+	check, err := util.EncodePassword(secret, name)
+	if err != nil {
+		return false, false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(check), []byte(hashed)) == 1, false, nil
+}
+
+func (h *pbkdf2Hasher) Recognizes(hashed string) bool {
+	return !strings.HasPrefix(hashed, "$")
+}
+
+const (
+	argon2idPrefix     = "$argon2id$"
+	argon2idSaltLength = 16
+	argon2idKeyLength  = 32
+)
+
+// argon2idHasher stores hashes in the PHC string format, with a random salt.
+type argon2idHasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+func (h *argon2idHasher) Hash(secret, _ string) (string, error) {
+	salt := make([]byte, argon2idSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(secret), salt, h.time, h.memory, h.threads, argon2idKeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *argon2idHasher) Verify(secret, _, hashed string) (bool, bool, error) {
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 {
+		return false, false, errUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, errUnknownHash
+	}
+
+	var params argon2idHasher
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return false, false, errUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, errUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, errUnknownHash
+	}
+
+	check := argon2.IDKey([]byte(secret), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(check, key) == 1, params != *h, nil
+}
+
+func (h *argon2idHasher) Recognizes(hashed string) bool {
+	return strings.HasPrefix(hashed, argon2idPrefix)
+}
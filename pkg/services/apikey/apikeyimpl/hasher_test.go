@@ -0,0 +1,67 @@
+package apikeyimpl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/util"
+)
+
+func TestPBKDF2Hasher(t *testing.T) {
+	h := &pbkdf2Hasher{}
+
+	hashed, err := h.Hash("secret", "name")
+	require.NoError(t, err)
+	legacy, err := util.EncodePassword("secret", "name")
+	require.NoError(t, err)
+	assert.Equal(t, legacy, hashed)
+	assert.True(t, h.Recognizes(hashed))
+
+	valid, needsRehash, err := h.Verify("secret", "name", hashed)
+	require.NoError(t, err)
+	assert.True(t, valid)
+	assert.False(t, needsRehash)
+
+	valid, _, err = h.Verify("secret", "other-name", hashed)
+	require.NoError(t, err)
+	assert.False(t, valid)
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	h := &argon2idHasher{time: 1, memory: 1024, threads: 1}
+
+	hashed, err := h.Hash("secret", "name")
+	require.NoError(t, err)
+	assert.True(t, h.Recognizes(hashed))
+	assert.False(t, (&pbkdf2Hasher{}).Recognizes(hashed))
+
+	other, err := h.Hash("secret", "name")
+	require.NoError(t, err)
+	assert.NotEqual(t, hashed, other, "hashes should be salted")
+
+	t.Run("verifies the secret", func(t *testing.T) {
+		valid, needsRehash, err := h.Verify("secret", "name", hashed)
+		require.NoError(t, err)
+		assert.True(t, valid)
+		assert.False(t, needsRehash)
+
+		valid, _, err = h.Verify("wrong", "name", hashed)
+		require.NoError(t, err)
+		assert.False(t, valid)
+	})
+
+	t.Run("requests a rehash when the parameters change", func(t *testing.T) {
+		stronger := &argon2idHasher{time: 2, memory: 1024, threads: 1}
+		valid, needsRehash, err := stronger.Verify("secret", "name", hashed)
+		require.NoError(t, err)
+		assert.True(t, valid)
+		assert.True(t, needsRehash)
+	})
+
+	t.Run("rejects malformed hashes", func(t *testing.T) {
+		_, _, err := h.Verify("secret", "name", "$argon2id$v=19$m=1024")
+		assert.ErrorIs(t, err, errUnknownHash)
+	})
+}
@@ -0,0 +1,239 @@
+package apikeyimpl
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/notifications"
+	"github.com/grafana/grafana/pkg/services/sqlstore/db"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	idleRevocationNamespace = "apikey.idle_revocations"
+	idleRevocationInterval  = time.Hour
+	idleRevocationTemplate  = "api_key_idle_revocation"
+)
+
+// idleRevocationWarning is the warning recorded for an idle key. LastActive is
+// the last usage, or the creation, of the key when it was announced, a key used
+// since then is announced again once it is idle.
+type idleRevocationWarning struct {
+	LastActive int64 `json:"lastActive"`
+	RevokeAt   int64 `json:"revokeAt"`
+}
+
+// IdleKeyRevoker periodically revokes the API keys that have not been used for
+// api_key_idle_revocation_period, by expiring them. Idle keys are announced
+// by email and webhook api_key_idle_revocation_warning_period before they are
+// revoked, keys having the exemption label are never revoked.
+type IdleKeyRevoker struct {
+	cfg           *setting.Cfg
+	store         store
+	kvStore       kvstore.KVStore
+	notifications notifications.Service
+	serverLock    *serverlock.ServerLockService
+	log           log.Logger
+	clock         clock.Clock
+}
+
+func ProvideIdleKeyRevoker(db db.DB, cfg *setting.Cfg, kvStore kvstore.KVStore,
+	notificationService notifications.Service, serverLockService *serverlock.ServerLockService, clk clock.Clock) *IdleKeyRevoker {
+	return &IdleKeyRevoker{
+		cfg:           cfg,
+		store:         &sqlStore{db: db, cfg: cfg, clock: clk},
+		kvStore:       kvStore,
+		notifications: notificationService,
+		serverLock:    serverLockService,
+		log:           log.New("apikey.idle-revoker"),
+		clock:         clk,
+	}
+}
+
+// IsDisabled returns true when no idle revocation period has been configured.
+func (r *IdleKeyRevoker) IsDisabled() bool {
+	return r.cfg.ApiKeyIdleRevocationPeriod <= 0
+}
+
+func (r *IdleKeyRevoker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(idleRevocationInterval)
+	defer ticker.Stop()
+
+	for {
+		err := r.serverLock.LockAndExecute(ctx, "revoke idle api keys", idleRevocationInterval, func(ctx context.Context) {
+			if err := r.revokeIdleKeys(ctx); err != nil {
+				r.log.Error("failed to revoke idle api keys", "error", err)
+			}
+		})
+		if err != nil {
+			r.log.Error("failed to lock and execute idle api keys revocation", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// revokeIdleKeys announces the keys that will be revoked within the warning
+// period and revokes the keys that were announced at least the warning period
+// ago, so that no key is revoked without a warning, even when the policy is
+// enabled while keys are already idle.
+func (r *IdleKeyRevoker) revokeIdleKeys(ctx context.Context) error {
+	period, warning := r.cfg.ApiKeyIdleRevocationPeriod, r.cfg.ApiKeyIdleRevocationWarningPeriod
+	if warning < 0 {
+		warning = 0
+	}
+	// the keys are looked up as soon as they have to be announced
+	now := r.clock.Now()
+	since := now
+	if warning < period {
+		since = now.Add(warning - period)
+	}
+
+	keys, err := r.store.GetIdleAPIKeys(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if _, exempt := key.Labels[r.cfg.ApiKeyIdleRevocationExemptLabel]; exempt {
+			continue
+		}
+
+		lastActive := key.Created
+		if key.LastUsedAt != nil {
+			lastActive = *key.LastUsedAt
+		}
+		revokeAt := lastActive.Add(period)
+		if warning > 0 {
+			announced, err := r.getWarning(ctx, key)
+			if err != nil {
+				return err
+			}
+			if announced == nil || announced.LastActive != lastActive.Unix() {
+				if earliest := now.Add(warning); revokeAt.Before(earliest) {
+					revokeAt = earliest
+				}
+				r.warn(ctx, key, lastActive, revokeAt)
+				continue
+			}
+			revokeAt = time.Unix(announced.RevokeAt, 0)
+		}
+		if now.Before(revokeAt) {
+			continue
+		}
+
+		revoked, err := r.store.ShortenAPIKeyExpiration(ctx, key.Id, now.Unix())
+		if err != nil {
+			return err
+		}
+		if err := r.kvStore.Del(ctx, key.OrgId, idleRevocationNamespace, strconv.FormatInt(key.Id, 10)); err != nil {
+			return err
+		}
+		if revoked {
+			r.log.Info("revoked idle api key", "keyId", key.Id, "orgId", key.OrgId, "name", key.Name, "lastActive", lastActive)
+		}
+	}
+
+	return nil
+}
+
+func (r *IdleKeyRevoker) getWarning(ctx context.Context, key *apikey.APIKey) (*idleRevocationWarning, error) {
+	value, ok, err := r.kvStore.Get(ctx, key.OrgId, idleRevocationNamespace, strconv.FormatInt(key.Id, 10))
+	if err != nil || !ok {
+		return nil, err
+	}
+	var warning idleRevocationWarning
+	if err := json.Unmarshal([]byte(value), &warning); err != nil {
+		r.log.Warn("invalid idle api key warning, the key is announced again", "keyId", key.Id, "error", err)
+		return nil, nil
+	}
+	return &warning, nil
+}
+
+// warn announces the revocation of the key and records the announcement. The
+// key is revoked at the announced date even when the announcement could not
+// be sent, the failures are logged.
+func (r *IdleKeyRevoker) warn(ctx context.Context, key *apikey.APIKey, lastActive, revokeAt time.Time) {
+	if err := r.notify(ctx, key, lastActive, revokeAt); err != nil {
+		r.log.Warn("failed to announce the revocation of idle api key", "keyId", key.Id, "orgId", key.OrgId, "error", err)
+	}
+
+	value, err := json.Marshal(idleRevocationWarning{LastActive: lastActive.Unix(), RevokeAt: revokeAt.Unix()})
+	if err == nil {
+		err = r.kvStore.Set(ctx, key.OrgId, idleRevocationNamespace, strconv.FormatInt(key.Id, 10), string(value))
+	}
+	if err != nil {
+		r.log.Warn("failed to record the warning of idle api key", "keyId", key.Id, "orgId", key.OrgId, "error", err)
+		return
+	}
+	r.log.Info("idle api key will be revoked", "keyId", key.Id, "orgId", key.OrgId, "name", key.Name, "revokeAt", revokeAt)
+}
+
+func (r *IdleKeyRevoker) notify(ctx context.Context, key *apikey.APIKey, lastActive, revokeAt time.Time) error {
+	if len(r.cfg.ApiKeyExpiryNotificationEmails) > 0 {
+		err := r.notifications.SendEmailCommandHandlerSync(ctx, &models.SendEmailCommandSync{
+			SendEmailCommand: models.SendEmailCommand{
+				To:       r.cfg.ApiKeyExpiryNotificationEmails,
+				Template: idleRevocationTemplate,
+				Data: map[string]interface{}{
+					"KeyName":     key.Name,
+					"OrgId":       key.OrgId,
+					"LastActive":  lastActive.UTC().Format(time.RFC1123),
+					"RevokeAt":    revokeAt.UTC().Format(time.RFC1123),
+					"ExemptLabel": r.cfg.ApiKeyIdleRevocationExemptLabel,
+					"KeysUrl":     setting.ToAbsUrl("org/apikeys"),
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.cfg.ApiKeyExpiryNotificationWebhookURL != "" {
+		body, err := json.Marshal(idleKeyWebhookBody{
+			Event:      webhookEventIdleRevocation,
+			OrgId:      key.OrgId,
+			KeyId:      key.Id,
+			KeyName:    key.Name,
+			LastActive: lastActive.UTC(),
+			RevokeAt:   revokeAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		err = r.notifications.SendWebhookSync(ctx, &models.SendWebhookSync{
+			Url:         r.cfg.ApiKeyExpiryNotificationWebhookURL,
+			Body:        string(body),
+			HttpMethod:  "POST",
+			ContentType: "application/json",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type idleKeyWebhookBody struct {
+	Event      string    `json:"event"`
+	OrgId      int64     `json:"orgId"`
+	KeyId      int64     `json:"keyId"`
+	KeyName    string    `json:"keyName"`
+	LastActive time.Time `json:"lastActive"`
+	RevokeAt   time.Time `json:"revokeAt"`
+}
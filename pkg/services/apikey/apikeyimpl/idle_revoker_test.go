@@ -0,0 +1,109 @@
+package apikeyimpl
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/notifications"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+func TestIntegrationIdleKeyRevoker(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	cfg := *db.Cfg
+	cfg.ApiKeyIdleRevocationPeriod = 90 * 24 * time.Hour
+	cfg.ApiKeyIdleRevocationWarningPeriod = 7 * 24 * time.Hour
+	cfg.ApiKeyIdleRevocationExemptLabel = "keep"
+	cfg.ApiKeyExpiryNotificationEmails = []string{"admin@example.com"}
+	cfg.ApiKeyExpiryNotificationWebhookURL = "http://localhost/webhook"
+
+	ctx := context.Background()
+	clk := clock.NewMock()
+	now := time.Now()
+	clk.Set(now.Add(-100 * 24 * time.Hour))
+	ss := &sqlStore{db: db, cfg: &cfg, clock: clk}
+	keys := map[string]*apikey.AddCommand{
+		"idle":   {OrgId: 1, Name: "idle", Key: "idle"},
+		"exempt": {OrgId: 1, Name: "exempt", Key: "exempt", Labels: map[string]string{"keep": "true"}},
+		"used":   {OrgId: 1, Name: "used", Key: "used"},
+	}
+	for _, cmd := range keys {
+		require.NoError(t, ss.AddAPIKey(ctx, cmd))
+	}
+	clk.Set(now)
+	require.NoError(t, ss.UpdateAPIKeysLastUsed(ctx, []*apikey.UpdateLastUsedCommand{{Id: keys["used"].Result.Id, Time: now.Add(-24 * time.Hour)}}))
+
+	var emails []models.SendEmailCommandSync
+	var webhooks []models.SendWebhookSync
+	notificationService := notifications.MockNotificationService()
+	notificationService.EmailHandlerSync = func(_ context.Context, cmd *models.SendEmailCommandSync) error {
+		emails = append(emails, *cmd)
+		return nil
+	}
+	notificationService.WebhookHandler = func(_ context.Context, cmd *models.SendWebhookSync) error {
+		webhooks = append(webhooks, *cmd)
+		return nil
+	}
+
+	revoker := &IdleKeyRevoker{
+		cfg:           &cfg,
+		store:         ss,
+		kvStore:       kvstore.ProvideService(db),
+		notifications: notificationService,
+		log:           log.New("test"),
+		clock:         clk,
+	}
+	require.False(t, revoker.IsDisabled())
+
+	expires := func(t *testing.T, name string) *int64 {
+		t.Helper()
+		query := apikey.GetByIDQuery{ApiKeyId: keys[name].Result.Id}
+		require.NoError(t, ss.GetApiKeyById(ctx, &query))
+		return query.Result.Expires
+	}
+
+	t.Run("announces the idle keys before revoking them", func(t *testing.T) {
+		require.NoError(t, revoker.revokeIdleKeys(ctx))
+
+		require.Len(t, emails, 1)
+		assert.Equal(t, "idle", emails[0].Data["KeyName"])
+		assert.Equal(t, idleRevocationTemplate, emails[0].Template)
+		require.Len(t, webhooks, 1)
+		var body idleKeyWebhookBody
+		require.NoError(t, json.Unmarshal([]byte(webhooks[0].Body), &body))
+		assert.Equal(t, webhookEventIdleRevocation, body.Event)
+		assert.Equal(t, "idle", body.KeyName)
+		assert.Equal(t, now.Add(7*24*time.Hour).Unix(), body.RevokeAt.Unix())
+		assert.Nil(t, expires(t, "idle"))
+
+		require.NoError(t, revoker.revokeIdleKeys(ctx))
+		assert.Len(t, emails, 1)
+		assert.Nil(t, expires(t, "idle"))
+	})
+
+	t.Run("revokes the idle keys once the warning period passed", func(t *testing.T) {
+		clk.Add(7 * 24 * time.Hour)
+		require.NoError(t, revoker.revokeIdleKeys(ctx))
+
+		revoked := expires(t, "idle")
+		require.NotNil(t, revoked)
+		assert.Equal(t, clk.Now().Unix(), *revoked)
+		assert.Nil(t, expires(t, "exempt"))
+		assert.Nil(t, expires(t, "used"))
+		assert.Len(t, emails, 1)
+	})
+}
@@ -0,0 +1,122 @@
+package apikeyimpl
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/components/apikeygen"
+	apikeygenprefix "github.com/grafana/grafana/pkg/components/apikeygenprefixed"
+	"github.com/grafana/grafana/pkg/services/apikey"
+)
+
+// IntrospectAPIKey finds the key of a secret, like the authentication of
+// requests does, without recording a usage of the key. Unknown keys, invalid
+// secrets and keys of other organizations leave the result empty.
+func (s *Service) IntrospectAPIKey(ctx context.Context, query *apikey.IntrospectQuery) error {
+	if !isKeyFormat(query.Key) {
+		return nil
+	}
+	key, err := s.findKey(ctx, query.Key)
+	if err != nil {
+		return err
+	}
+	if key == nil || key.OrgId != query.OrgId {
+		return nil
+	}
+
+	query.Result = key
+	// the secret of a signing key cannot authenticate requests on its own
+	query.Active = !key.IsSigning() && key.CheckUsable(s.clock.Now()) == apikey.ValidationSucceeded
+	return nil
+}
+
+// isKeyFormat returns false for strings that cannot be the secret of a key,
+// so that they are rejected before being hashed or looked up. Keys generated
+// before the gfk_ format are only recognized by their encoding.
+func isKeyFormat(keyString string) bool {
+	switch {
+	case strings.HasPrefix(keyString, apikeygen.Prefix):
+		return apikeygen.IsValidFormat(keyString)
+	case strings.HasPrefix(keyString, apikeygenprefix.GrafanaPrefix):
+		_, err := apikeygenprefix.Decode(keyString)
+		return err == nil
+	default:
+		_, err := apikeygen.Decode(keyString)
+		return err == nil
+	}
+}
+
+// findKey returns the key whose secret is keyString, nil if there is none.
+func (s *Service) findKey(ctx context.Context, keyString string) (*apikey.APIKey, error) {
+	var (
+		key *apikey.APIKey
+		err error
+	)
+	if strings.HasPrefix(keyString, apikeygenprefix.GrafanaPrefix) {
+		key, err = s.findPrefixedKey(ctx, keyString)
+	} else {
+		key, err = s.findLegacyKey(ctx, keyString)
+	}
+	if errors.Is(err, apikey.ErrInvalid) || errors.Is(err, apikeygen.ErrInvalidApiKey) {
+		return nil, nil
+	}
+	if err != nil || key == nil {
+		return key, err
+	}
+	if err := s.decryptMetadata(ctx, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *Service) findPrefixedKey(ctx context.Context, keyString string) (*apikey.APIKey, error) {
+	decoded, err := apikeygenprefix.Decode(keyString)
+	if err != nil {
+		return nil, apikeygen.ErrInvalidApiKey
+	}
+	hash, err := decoded.Hash()
+	if err != nil {
+		return nil, err
+	}
+	key, _, err := s.store.GetAPIKeyByHash(ctx, hash)
+	return key, err
+}
+
+func (s *Service) findLegacyKey(ctx context.Context, keyString string) (*apikey.APIKey, error) {
+	decoded, err := apikeygen.Decode(keyString)
+	if err != nil {
+		return nil, err
+	}
+
+	keyQuery := apikey.GetByNameQuery{KeyName: decoded.Name, OrgId: decoded.OrgId}
+	if err := s.store.GetApiKeyByName(ctx, &keyQuery); err != nil {
+		return nil, err
+	}
+	key := keyQuery.Result
+
+	// the secret can be the current, the secondary or the previous secret of
+	// the key during its grace period
+	verify := apikey.VerifySecretQuery{Key: key, Secret: decoded.Key}
+	if err := s.VerifySecret(ctx, &verify); err != nil {
+		return nil, err
+	}
+	if !verify.Result && key.SecondaryKey != nil {
+		verify.Secondary = true
+		if err := s.VerifySecret(ctx, &verify); err != nil {
+			return nil, err
+		}
+		verify.Secondary = false
+	}
+	if !verify.Result && key.PreviousKey != nil && key.PreviousKeyExpires != nil &&
+		*key.PreviousKeyExpires > s.clock.Now().Unix() {
+		verify.Previous = true
+		if err := s.VerifySecret(ctx, &verify); err != nil {
+			return nil, err
+		}
+	}
+	if !verify.Result {
+		return nil, apikeygen.ErrInvalidApiKey
+	}
+	return key, nil
+}
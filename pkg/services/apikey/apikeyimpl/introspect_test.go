@@ -0,0 +1,125 @@
+package apikeyimpl
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/components/apikeygen"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/util"
+)
+
+func TestIntegrationIntrospectAPIKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	s := ProvideService(db, db.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clk)
+	ctx := context.Background()
+
+	addKey := func(t *testing.T, name string, secondsToLive int64) apikeygen.KeyGenResult {
+		t.Helper()
+		generated, err := apikeygen.New(1, name)
+		require.NoError(t, err)
+		cmd := apikey.AddCommand{OrgId: 1, Name: name, Role: org.RoleViewer, Key: generated.HashedKey, SecondsToLive: secondsToLive}
+		require.NoError(t, s.AddAPIKey(ctx, &cmd))
+		return generated
+	}
+	introspect := func(t *testing.T, orgID int64, key string) apikey.IntrospectQuery {
+		t.Helper()
+		query := apikey.IntrospectQuery{OrgId: orgID, Key: key}
+		require.NoError(t, s.IntrospectAPIKey(ctx, &query))
+		return query
+	}
+
+	valid := addKey(t, "valid", 0)
+	expiring := addKey(t, "expiring", 60)
+
+	t.Run("describes a valid key", func(t *testing.T) {
+		query := introspect(t, 1, valid.ClientSecret)
+		assert.True(t, query.Active)
+		require.NotNil(t, query.Result)
+		assert.Equal(t, "valid", query.Result.Name)
+		assert.Equal(t, org.RoleViewer, query.Result.Role)
+	})
+
+	t.Run("reports an invalid secret", func(t *testing.T) {
+		other, err := apikeygen.New(1, "valid")
+		require.NoError(t, err)
+		query := introspect(t, 1, other.ClientSecret)
+		assert.False(t, query.Active)
+		assert.Nil(t, query.Result)
+
+		query = introspect(t, 1, "not-a-key")
+		assert.False(t, query.Active)
+		assert.Nil(t, query.Result)
+	})
+
+	t.Run("rejects a key with a wrong checksum", func(t *testing.T) {
+		tampered := valid.ClientSecret[:len(valid.ClientSecret)-8] + "zzzzzzzz"
+		require.False(t, apikeygen.IsValidFormat(tampered))
+		query := introspect(t, 1, tampered)
+		assert.False(t, query.Active)
+		assert.Nil(t, query.Result)
+	})
+
+	t.Run("describes a key generated before the prefixed format", func(t *testing.T) {
+		legacy := apikeygen.ApiKeyJson{Key: "legacy-secret", Name: "legacy", OrgId: 1}
+		hashed, err := util.EncodePassword(legacy.Key, legacy.Name)
+		require.NoError(t, err)
+		require.NoError(t, s.AddAPIKey(ctx, &apikey.AddCommand{OrgId: 1, Name: legacy.Name, Role: org.RoleViewer, Key: hashed}))
+		encoded, err := json.Marshal(legacy)
+		require.NoError(t, err)
+
+		query := introspect(t, 1, base64.StdEncoding.EncodeToString(encoded))
+		assert.True(t, query.Active)
+		require.NotNil(t, query.Result)
+		assert.Equal(t, "legacy", query.Result.Name)
+	})
+
+	t.Run("hides the keys of other organizations", func(t *testing.T) {
+		query := introspect(t, 2, valid.ClientSecret)
+		assert.False(t, query.Active)
+		assert.Nil(t, query.Result)
+	})
+
+	t.Run("reports an expired key as inactive", func(t *testing.T) {
+		assert.True(t, introspect(t, 1, expiring.ClientSecret).Active)
+		clk.Add(time.Minute)
+		query := introspect(t, 1, expiring.ClientSecret)
+		assert.False(t, query.Active)
+		assert.NotNil(t, query.Result)
+	})
+
+	t.Run("reports a key outside of its access windows as inactive", func(t *testing.T) {
+		generated, err := apikeygen.New(1, "windowed")
+		require.NoError(t, err)
+		now := clk.Now().UTC()
+		window := apikey.AccessWindow{Start: now.Add(time.Hour).Format("15:04"), End: now.Add(2 * time.Hour).Format("15:04")}
+		cmd := apikey.AddCommand{OrgId: 1, Name: "windowed", Role: org.RoleViewer, Key: generated.HashedKey, AccessWindows: []apikey.AccessWindow{window}}
+		require.NoError(t, s.AddAPIKey(ctx, &cmd))
+
+		query := introspect(t, 1, generated.ClientSecret)
+		assert.False(t, query.Active)
+		assert.NotNil(t, query.Result)
+
+		clk.Add(90 * time.Minute)
+		assert.True(t, introspect(t, 1, generated.ClientSecret).Active)
+	})
+}
@@ -0,0 +1,42 @@
+package apikeyimpl
+
+import (
+	"sync"
+
+	"github.com/grafana/grafana/pkg/services/apikey"
+)
+
+// lastUsedBuffer keeps the last usage of each API key in memory until it is
+// written to the database, so that a key used for many requests results in a
+// single write per flush.
+type lastUsedBuffer struct {
+	mu     sync.Mutex
+	usages map[int64]*apikey.UpdateLastUsedCommand
+}
+
+func newLastUsedBuffer() *lastUsedBuffer {
+	return &lastUsedBuffer{usages: make(map[int64]*apikey.UpdateLastUsedCommand)}
+}
+
+func (b *lastUsedBuffer) add(cmd *apikey.UpdateLastUsedCommand) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if usage, ok := b.usages[cmd.Id]; ok && usage.Time.After(cmd.Time) {
+		return
+	}
+	b.usages[cmd.Id] = cmd
+}
+
+// drain empties the buffer and returns its content.
+func (b *lastUsedBuffer) drain() []*apikey.UpdateLastUsedCommand {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cmds := make([]*apikey.UpdateLastUsedCommand, 0, len(b.usages))
+	for _, cmd := range b.usages {
+		cmds = append(cmds, cmd)
+	}
+	b.usages = make(map[int64]*apikey.UpdateLastUsedCommand)
+	return cmds
+}
@@ -0,0 +1,186 @@
+package apikeyimpl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/apikey"
+)
+
+// ReportLeakedKeys records the reported leaks and revokes the leaked keys
+// that are found. A key is revoked when any of its secrets leaked, reported
+// hashes are matched against the fingerprints of the secrets. Reported strings
+// that are not in the format of a key are neither looked up nor recorded.
+func (s *Service) ReportLeakedKeys(ctx context.Context, cmd *apikey.ReportLeaksCommand) error {
+	hashes := make([]string, 0, len(cmd.Hashes))
+	for _, hash := range cmd.Hashes {
+		hash = strings.ToLower(hash)
+		if !apikey.IsValidLeakHash(hash) {
+			return apikey.ErrInvalidLeakHash
+		}
+		hashes = append(hashes, hash)
+	}
+
+	reported := make([]*apikey.ReportedLeak, 0, len(cmd.Keys)+len(hashes))
+	for _, keyString := range cmd.Keys {
+		if !isKeyFormat(keyString) {
+			reported = append(reported, &apikey.ReportedLeak{Hash: apikey.LeakHash(keyString)})
+			continue
+		}
+		key, err := s.findKey(ctx, keyString)
+		if err != nil {
+			return err
+		}
+		leak := &apikey.Leak{Hash: apikey.LeakHash(keyString), Source: cmd.Source, Url: cmd.Url, Created: s.clock.Now()}
+		if err := s.store.RecordLeak(ctx, leak, key); err != nil {
+			return err
+		}
+		if key != nil {
+			s.log.Warn("revoked leaked api key", "keyId", key.Id, "orgId", key.OrgId, "source", cmd.Source, "url", cmd.Url)
+		}
+		reported = append(reported, &apikey.ReportedLeak{Hash: leak.Hash, Revoked: key != nil})
+	}
+	for _, hash := range hashes {
+		leak := &apikey.Leak{Hash: hash, Source: cmd.Source, Url: cmd.Url, Created: s.clock.Now()}
+		if err := s.store.RecordLeak(ctx, leak, nil); err != nil {
+			return err
+		}
+		if leak.ApiKeyId != nil {
+			s.log.Warn("revoked leaked api key", "keyId", *leak.ApiKeyId, "source", cmd.Source, "url", cmd.Url)
+		}
+		reported = append(reported, &apikey.ReportedLeak{Hash: hash, Revoked: leak.ApiKeyId != nil})
+	}
+
+	cmd.Result = reported
+	return nil
+}
+
+// CheckLeakedKey returns true if keyString, the secret of the key sent with a
+// request, has been reported as leaked. The leaks of fingerprinted keys are
+// revoked when they are reported, only the keys created before fingerprints
+// were recorded are looked up: their leaks are revoked the first time they
+// are checked, and their fingerprints are backfilled otherwise.
+func (s *Service) CheckLeakedKey(ctx context.Context, key *apikey.APIKey, keyString string) (bool, error) {
+	if key.Fingerprinted {
+		return false, nil
+	}
+
+	hash := apikey.LeakHash(keyString)
+	leak, err := s.store.GetLeak(ctx, hash)
+	if err != nil {
+		return false, err
+	}
+	if leak == nil {
+		if err := s.store.BackfillFingerprint(ctx, key, hash); err != nil {
+			s.log.Warn("failed to backfill api key fingerprint", "keyId", key.Id, "error", err)
+		}
+		return false, nil
+	}
+	if leak.ApiKeyId == nil {
+		if err := s.store.RecordLeak(ctx, leak, key); err != nil {
+			return true, err
+		}
+		s.log.Warn("revoked leaked api key", "keyId", key.Id, "orgId", key.OrgId, "source", leak.Source, "url", leak.Url)
+	}
+	return true, nil
+}
+
+// secretScanningKeysRefreshInterval is how often the public keys are fetched
+// at most, when a report is signed with an unknown key.
+const secretScanningKeysRefreshInterval = time.Minute
+
+// secretScanningKeys caches the public keys verifying the secret scanning
+// reports.
+type secretScanningKeys struct {
+	url    string
+	client *http.Client
+	mu     sync.Mutex
+	keys   map[string]*ecdsa.PublicKey
+	// fetched is when the keys were last fetched.
+	fetched time.Time
+}
+
+func newSecretScanningKeys(url string) *secretScanningKeys {
+	return &secretScanningKeys{url: url, client: &http.Client{Timeout: 10 * time.Second}, keys: map[string]*ecdsa.PublicKey{}}
+}
+
+type secretScanningPublicKeys struct {
+	PublicKeys []struct {
+		KeyIdentifier string `json:"key_identifier"`
+		Key           string `json:"key"`
+	} `json:"public_keys"`
+}
+
+func (k *secretScanningKeys) get(ctx context.Context, keyID string, now time.Time) (*ecdsa.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, ok := k.keys[keyID]; ok || now.Sub(k.fetched) < secretScanningKeysRefreshInterval {
+		return key, nil
+	}
+	k.fetched = now
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch the secret scanning public keys: %s", resp.Status)
+	}
+
+	var published secretScanningPublicKeys
+	if err := json.NewDecoder(resp.Body).Decode(&published); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*ecdsa.PublicKey, len(published.PublicKeys))
+	for _, p := range published.PublicKeys {
+		block, _ := pem.Decode([]byte(p.Key))
+		if block == nil {
+			continue
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if key, ok := parsed.(*ecdsa.PublicKey); ok {
+			keys[p.KeyIdentifier] = key
+		}
+	}
+	k.keys = keys
+	return keys[keyID], nil
+}
+
+// VerifySecretScanningSignature checks the signature of a GitHub secret
+// scanning report, an ECDSA signature of the payload made with one of the
+// public keys published by GitHub.
+func (s *Service) VerifySecretScanningSignature(ctx context.Context, keyID, signature string, payload []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if keyID == "" || err != nil {
+		return apikey.ErrInvalidSignature
+	}
+
+	key, err := s.scanningKeys.get(ctx, keyID, s.clock.Now())
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(payload)
+	if key == nil || !ecdsa.VerifyASN1(key, digest[:], sig) {
+		return apikey.ErrInvalidSignature
+	}
+	return nil
+}
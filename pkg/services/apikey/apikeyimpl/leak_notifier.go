@@ -0,0 +1,69 @@
+package apikeyimpl
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/notifications"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const leakedKeyTemplate = "api_key_leaked"
+
+// LeakNotifier emails the creators of the API keys revoked because they
+// leaked.
+type LeakNotifier struct {
+	users         user.Service
+	notifications notifications.Service
+	log           log.Logger
+}
+
+func ProvideLeakNotifier(bus bus.Bus, userService user.Service, notificationService notifications.Service) *LeakNotifier {
+	n := &LeakNotifier{
+		users:         userService,
+		notifications: notificationService,
+		log:           log.New("apikey.leak-notifier"),
+	}
+	bus.AddEventListener(n.handleKeyLeaked)
+	return n
+}
+
+// Run only waits, the notifications are sent when keys are revoked.
+func (n *LeakNotifier) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (n *LeakNotifier) handleKeyLeaked(ctx context.Context, e *events.ApiKeyLeaked) error {
+	if e.CreatedBy == 0 {
+		return nil
+	}
+
+	creator, err := n.users.GetByID(ctx, &user.GetUserByIDQuery{ID: e.CreatedBy})
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if creator.Email == "" {
+		return nil
+	}
+
+	n.log.Info("notifying the creator of a leaked api key", "keyId", e.ID, "orgId", e.OrgID, "userId", e.CreatedBy)
+	return n.notifications.SendEmailCommandHandler(ctx, &models.SendEmailCommand{
+		To:       []string{creator.Email},
+		Template: leakedKeyTemplate,
+		Data: map[string]interface{}{
+			"KeyName": e.Name,
+			"OrgId":   e.OrgID,
+			"Source":  e.Source,
+			"Url":     e.URL,
+			"KeysUrl": setting.ToAbsUrl("org/apikeys"),
+		},
+	})
+}
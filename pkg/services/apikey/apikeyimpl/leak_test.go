@@ -0,0 +1,262 @@
+package apikeyimpl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/components/apikeygen"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/tracing"
+	"github.com/grafana/grafana/pkg/models"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/notifications"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/services/user/usertest"
+)
+
+func TestIntegrationLeakedKeys(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	s := ProvideService(db, db.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clk)
+	ctx := context.Background()
+
+	// addKey adds a key, with the fingerprint of its secret when fingerprinted
+	// is set, like keys created before fingerprints were recorded otherwise.
+	addKey := func(t *testing.T, name string, fingerprinted bool) (apikeygen.KeyGenResult, *apikey.APIKey) {
+		t.Helper()
+		generated, err := apikeygen.New(1, name)
+		require.NoError(t, err)
+		cmd := apikey.AddCommand{OrgId: 1, Name: name, Role: org.RoleViewer, Key: generated.HashedKey}
+		if fingerprinted {
+			cmd.Fingerprint = apikey.LeakHash(generated.ClientSecret)
+		}
+		require.NoError(t, s.AddAPIKey(ctx, &cmd))
+		return generated, cmd.Result
+	}
+	expires := func(t *testing.T, id int64) *int64 {
+		t.Helper()
+		query := apikey.GetByIDQuery{ApiKeyId: id}
+		require.NoError(t, s.GetApiKeyById(ctx, &query))
+		return query.Result.Expires
+	}
+
+	t.Run("reported keys are revoked right away", func(t *testing.T) {
+		generated, key := addKey(t, "leaked", true)
+		unknown, err := apikeygen.New(1, "unknown")
+		require.NoError(t, err)
+
+		cmd := apikey.ReportLeaksCommand{Keys: []string{generated.ClientSecret, unknown.ClientSecret}, Source: "github", Url: "https://github.com/org/repo"}
+		require.NoError(t, s.ReportLeakedKeys(ctx, &cmd))
+		assert.Equal(t, []*apikey.ReportedLeak{
+			{Hash: apikey.LeakHash(generated.ClientSecret), Revoked: true},
+			{Hash: apikey.LeakHash(unknown.ClientSecret), Revoked: false},
+		}, cmd.Result)
+
+		revoked := expires(t, key.Id)
+		require.NotNil(t, revoked)
+		assert.Equal(t, clk.Now().Unix(), *revoked)
+
+		// the expired key is rejected, its leak is not looked up again
+		leaked, err := s.CheckLeakedKey(ctx, key, generated.ClientSecret)
+		require.NoError(t, err)
+		assert.False(t, leaked)
+	})
+
+	t.Run("keys reported by hash are revoked right away", func(t *testing.T) {
+		generated, key := addKey(t, "leaked-fingerprint", true)
+		_, other := addKey(t, "not-leaked-fingerprint", true)
+		hash := apikey.LeakHash(generated.ClientSecret)
+
+		cmd := apikey.ReportLeaksCommand{Hashes: []string{hash}, Source: "scanner"}
+		require.NoError(t, s.ReportLeakedKeys(ctx, &cmd))
+		assert.Equal(t, []*apikey.ReportedLeak{{Hash: hash, Revoked: true}}, cmd.Result)
+
+		revoked := expires(t, key.Id)
+		require.NotNil(t, revoked)
+		assert.Equal(t, clk.Now().Unix(), *revoked)
+		assert.Nil(t, expires(t, other.Id))
+	})
+
+	t.Run("rotated secrets reported by hash revoke their key", func(t *testing.T) {
+		_, key := addKey(t, "leaked-rotated", true)
+		rotated, err := apikeygen.New(1, "leaked-rotated")
+		require.NoError(t, err)
+		rotate := apikey.RotateCommand{Id: key.Id, OrgId: 1, Key: rotated.HashedKey, Fingerprint: apikey.LeakHash(rotated.ClientSecret), Secondary: true}
+		require.NoError(t, s.RotateAPIKey(ctx, &rotate))
+
+		cmd := apikey.ReportLeaksCommand{Hashes: []string{apikey.LeakHash(rotated.ClientSecret)}, Source: "scanner"}
+		require.NoError(t, s.ReportLeakedKeys(ctx, &cmd))
+		assert.True(t, cmd.Result[0].Revoked)
+		assert.NotNil(t, expires(t, key.Id))
+	})
+
+	t.Run("keys without fingerprint reported by hash are revoked when they are used", func(t *testing.T) {
+		generated, key := addKey(t, "leaked-hash", false)
+		_, other := addKey(t, "not-leaked", false)
+		hash := apikey.LeakHash(generated.ClientSecret)
+
+		cmd := apikey.ReportLeaksCommand{Hashes: []string{hash}, Source: "scanner"}
+		require.NoError(t, s.ReportLeakedKeys(ctx, &cmd))
+		assert.Equal(t, []*apikey.ReportedLeak{{Hash: hash, Revoked: false}}, cmd.Result)
+		assert.Nil(t, expires(t, key.Id))
+
+		leaked, err := s.CheckLeakedKey(ctx, other, "not-leaked")
+		require.NoError(t, err)
+		assert.False(t, leaked)
+
+		leaked, err = s.CheckLeakedKey(ctx, key, generated.ClientSecret)
+		require.NoError(t, err)
+		assert.True(t, leaked)
+		assert.NotNil(t, expires(t, key.Id))
+		assert.Nil(t, expires(t, other.Id))
+
+		require.NoError(t, s.ReportLeakedKeys(ctx, &cmd))
+		assert.Equal(t, []*apikey.ReportedLeak{{Hash: hash, Revoked: true}}, cmd.Result)
+	})
+
+	t.Run("keys without fingerprint are fingerprinted when they are used", func(t *testing.T) {
+		generated, key := addKey(t, "backfilled", false)
+		assert.False(t, key.Fingerprinted)
+
+		leaked, err := s.CheckLeakedKey(ctx, key, generated.ClientSecret)
+		require.NoError(t, err)
+		assert.False(t, leaked)
+		query := apikey.GetByIDQuery{ApiKeyId: key.Id}
+		require.NoError(t, s.GetApiKeyById(ctx, &query))
+		assert.True(t, query.Result.Fingerprinted)
+
+		hash := apikey.LeakHash(generated.ClientSecret)
+		cmd := apikey.ReportLeaksCommand{Hashes: []string{hash}, Source: "scanner"}
+		require.NoError(t, s.ReportLeakedKeys(ctx, &cmd))
+		assert.Equal(t, []*apikey.ReportedLeak{{Hash: hash, Revoked: true}}, cmd.Result)
+	})
+
+	t.Run("keys with other secrets without fingerprint are still looked up", func(t *testing.T) {
+		generated, key := addKey(t, "partly-backfilled", false)
+		rotated, err := apikeygen.New(1, "partly-backfilled")
+		require.NoError(t, err)
+		rotate := apikey.RotateCommand{Id: key.Id, OrgId: 1, Key: rotated.HashedKey, Fingerprint: apikey.LeakHash(rotated.ClientSecret), Secondary: true}
+		require.NoError(t, s.RotateAPIKey(ctx, &rotate))
+		assert.False(t, rotate.Result.Fingerprinted)
+
+		leaked, err := s.CheckLeakedKey(ctx, rotate.Result, generated.ClientSecret)
+		require.NoError(t, err)
+		assert.False(t, leaked)
+		query := apikey.GetByIDQuery{ApiKeyId: key.Id}
+		require.NoError(t, s.GetApiKeyById(ctx, &query))
+		assert.False(t, query.Result.Fingerprinted, "the secondary secret may be the one without fingerprint")
+
+		_, fingerprinted := addKey(t, "fingerprinted", true)
+		assert.True(t, fingerprinted.Fingerprinted)
+	})
+
+	t.Run("strings that are not keys are not recorded", func(t *testing.T) {
+		generated, key := addKey(t, "tampered", true)
+		tampered := generated.ClientSecret[:len(generated.ClientSecret)-8] + "zzzzzzzz"
+
+		cmd := apikey.ReportLeaksCommand{Keys: []string{tampered}, Source: "github"}
+		require.NoError(t, s.ReportLeakedKeys(ctx, &cmd))
+		assert.Equal(t, []*apikey.ReportedLeak{{Hash: apikey.LeakHash(tampered), Revoked: false}}, cmd.Result)
+		assert.Nil(t, expires(t, key.Id))
+
+		leak, err := s.store.GetLeak(ctx, apikey.LeakHash(tampered))
+		require.NoError(t, err)
+		assert.Nil(t, leak)
+	})
+
+	t.Run("invalid hashes are rejected", func(t *testing.T) {
+		cmd := apikey.ReportLeaksCommand{Hashes: []string{"not-a-hash"}, Source: "scanner"}
+		assert.ErrorIs(t, s.ReportLeakedKeys(ctx, &cmd), apikey.ErrInvalidLeakHash)
+	})
+}
+
+func TestVerifySecretScanningSignature(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		var published secretScanningPublicKeys
+		published.PublicKeys = append(published.PublicKeys, struct {
+			KeyIdentifier string `json:"key_identifier"`
+			Key           string `json:"key"`
+		}{KeyIdentifier: "current", Key: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))})
+		require.NoError(t, json.NewEncoder(w).Encode(published))
+	}))
+	t.Cleanup(server.Close)
+
+	clk := clock.NewMock()
+	s := &Service{clock: clk, scanningKeys: newSecretScanningKeys(server.URL)}
+	payload := []byte(`[{"token":"gfk_abc_00000000","type":"grafana_api_key","url":"https://github.com/org/repo","source":"content"}]`)
+	digest := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, privateKey, digest[:])
+	require.NoError(t, err)
+	signature := base64.StdEncoding.EncodeToString(sig)
+	ctx := context.Background()
+
+	require.NoError(t, s.VerifySecretScanningSignature(ctx, "current", signature, payload))
+	assert.ErrorIs(t, s.VerifySecretScanningSignature(ctx, "current", signature, []byte("[]")), apikey.ErrInvalidSignature)
+	assert.ErrorIs(t, s.VerifySecretScanningSignature(ctx, "current", "not base64", payload), apikey.ErrInvalidSignature)
+
+	// unknown keys are looked up at most once per refresh interval
+	assert.ErrorIs(t, s.VerifySecretScanningSignature(ctx, "unknown", signature, payload), apikey.ErrInvalidSignature)
+	assert.ErrorIs(t, s.VerifySecretScanningSignature(ctx, "unknown", signature, payload), apikey.ErrInvalidSignature)
+	assert.Equal(t, 1, fetches)
+	clk.Add(secretScanningKeysRefreshInterval)
+	assert.ErrorIs(t, s.VerifySecretScanningSignature(ctx, "unknown", signature, payload), apikey.ErrInvalidSignature)
+	assert.Equal(t, 2, fetches)
+}
+
+func TestLeakNotifier(t *testing.T) {
+	b := bus.ProvideBus(tracing.InitializeTracerForTest())
+	users := usertest.NewUserServiceFake()
+	users.ExpectedUser = &user.User{ID: 7, Email: "creator@example.com"}
+	var emails []*models.SendEmailCommand
+	notificationService := notifications.MockNotificationService()
+	notificationService.EmailHandler = func(_ context.Context, cmd *models.SendEmailCommand) error {
+		emails = append(emails, cmd)
+		return nil
+	}
+	ProvideLeakNotifier(b, users, notificationService)
+	ctx := context.Background()
+
+	require.NoError(t, b.Publish(ctx, &events.ApiKeyLeaked{ID: 1, OrgID: 1, Name: "leaked", CreatedBy: 7, Source: "github", URL: "https://github.com/org/repo"}))
+	require.Len(t, emails, 1)
+	assert.Equal(t, []string{"creator@example.com"}, emails[0].To)
+	assert.Equal(t, leakedKeyTemplate, emails[0].Template)
+	assert.Equal(t, "leaked", emails[0].Data["KeyName"])
+
+	// keys created from the CLI have no creator
+	require.NoError(t, b.Publish(ctx, &events.ApiKeyLeaked{ID: 2, OrgID: 1, Name: "cli"}))
+	assert.Len(t, emails, 1)
+}
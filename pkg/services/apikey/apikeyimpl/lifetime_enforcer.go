@@ -0,0 +1,147 @@
+package apikeyimpl
+
+import (
+	"context"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/services/sqlstore/db"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const lifetimeEnforcementInterval = time.Hour
+
+// LifetimeReport lists the API keys exceeding api_key_max_seconds_to_live.
+type LifetimeReport struct {
+	DryRun           bool                `json:"dryRun"`
+	MaxSecondsToLive int64               `json:"maxSecondsToLive"`
+	Keys             []LifetimeViolation `json:"keys"`
+	// Updated is the number of keys whose expiration has been shortened, it
+	// is always 0 in dry run.
+	Updated int `json:"updated"`
+}
+
+// LifetimeViolation is a key exceeding the maximum lifetime. Expires is nil
+// for keys that never expire, MaxExpires is the expiration enforced on the key.
+type LifetimeViolation struct {
+	Id         int64      `json:"id"`
+	OrgId      int64      `json:"orgId"`
+	Name       string     `json:"name"`
+	Created    time.Time  `json:"created"`
+	Expires    *time.Time `json:"expires,omitempty"`
+	MaxExpires time.Time  `json:"maxExpires"`
+	// Expired is true when the key is older than the maximum lifetime, so
+	// enforcing it expires the key immediately.
+	Expired bool `json:"expired"`
+}
+
+// LifetimeEnforcer periodically applies api_key_max_seconds_to_live to
+// existing API keys, for example keys created before the limit was set.
+type LifetimeEnforcer struct {
+	cfg        *setting.Cfg
+	store      store
+	serverLock *serverlock.ServerLockService
+	log        log.Logger
+	clock      clock.Clock
+}
+
+func ProvideLifetimeEnforcer(db db.DB, cfg *setting.Cfg, serverLockService *serverlock.ServerLockService, clk clock.Clock) *LifetimeEnforcer {
+	return &LifetimeEnforcer{
+		cfg:        cfg,
+		store:      &sqlStore{db: db, cfg: cfg, clock: clk},
+		serverLock: serverLockService,
+		log:        log.New("apikey.lifetime-enforcer"),
+		clock:      clk,
+	}
+}
+
+// IsDisabled returns true when the enforcement is disabled or no maximum
+// lifetime has been configured.
+func (e *LifetimeEnforcer) IsDisabled() bool {
+	return e.cfg.ApiKeyLifetimeEnforcement == setting.ApiKeyLifetimeEnforcementDisabled || e.cfg.ApiKeyMaxSecondsToLive <= 0
+}
+
+func (e *LifetimeEnforcer) Run(ctx context.Context) error {
+	dryRun := e.cfg.ApiKeyLifetimeEnforcement == setting.ApiKeyLifetimeEnforcementDryRun
+
+	ticker := time.NewTicker(lifetimeEnforcementInterval)
+	defer ticker.Stop()
+
+	for {
+		err := e.serverLock.LockAndExecute(ctx, "enforce api keys lifetime", lifetimeEnforcementInterval, func(ctx context.Context) {
+			report, err := e.Enforce(ctx, dryRun)
+			if err != nil {
+				e.log.Error("failed to enforce api keys lifetime", "error", err)
+				return
+			}
+			e.logReport(report)
+		})
+		if err != nil {
+			e.log.Error("failed to lock and execute api keys lifetime enforcement", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Enforce looks for the keys exceeding the maximum lifetime and, unless dryRun
+// is set, shortens their expiration to their creation plus the maximum
+// lifetime.
+func (e *LifetimeEnforcer) Enforce(ctx context.Context, dryRun bool) (*LifetimeReport, error) {
+	report := &LifetimeReport{DryRun: dryRun, MaxSecondsToLive: e.cfg.ApiKeyMaxSecondsToLive, Keys: []LifetimeViolation{}}
+	if e.cfg.ApiKeyMaxSecondsToLive <= 0 {
+		return report, nil
+	}
+
+	keys, err := e.store.GetKeysExceedingLifetime(ctx, e.cfg.ApiKeyMaxSecondsToLive)
+	if err != nil {
+		return nil, err
+	}
+
+	now := e.clock.Now()
+	for _, key := range keys {
+		maxExpires := key.Created.Add(time.Duration(e.cfg.ApiKeyMaxSecondsToLive) * time.Second)
+		violation := LifetimeViolation{
+			Id:         key.Id,
+			OrgId:      key.OrgId,
+			Name:       key.Name,
+			Created:    key.Created,
+			MaxExpires: maxExpires,
+			Expired:    !maxExpires.After(now),
+		}
+		if key.Expires != nil {
+			expires := time.Unix(*key.Expires, 0)
+			violation.Expires = &expires
+		}
+		report.Keys = append(report.Keys, violation)
+
+		if dryRun {
+			continue
+		}
+		updated, err := e.store.ShortenAPIKeyExpiration(ctx, key.Id, maxExpires.Unix())
+		if err != nil {
+			return nil, err
+		}
+		if updated {
+			report.Updated++
+		}
+	}
+
+	return report, nil
+}
+
+func (e *LifetimeEnforcer) logReport(report *LifetimeReport) {
+	for _, v := range report.Keys {
+		e.log.Info("api key exceeds the maximum lifetime", "keyId", v.Id, "orgId", v.OrgId, "name", v.Name,
+			"maxExpires", v.MaxExpires, "expired", v.Expired, "dryRun", report.DryRun)
+	}
+	if len(report.Keys) > 0 {
+		e.log.Info("enforced api keys lifetime", "keys", len(report.Keys), "updated", report.Updated, "dryRun", report.DryRun)
+	}
+}
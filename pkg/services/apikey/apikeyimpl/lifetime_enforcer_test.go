@@ -0,0 +1,92 @@
+package apikeyimpl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestIntegrationLifetimeEnforcer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	clk := clock.NewMock()
+	ss := &sqlStore{db: db, cfg: db.Cfg, clock: clk}
+	ctx := context.Background()
+
+	now := time.Now()
+	clk.Set(now.Add(-10 * 24 * time.Hour))
+	old := &apikey.AddCommand{OrgId: 1, Name: "old", Key: "old"}
+	require.NoError(t, ss.AddAPIKey(ctx, old))
+	clk.Set(now)
+
+	keys := []*apikey.AddCommand{
+		{OrgId: 1, Name: "never-expiring", Key: "never-expiring"},
+		{OrgId: 1, Name: "long-lived", Key: "long-lived", SecondsToLive: 30 * 24 * 3600},
+		{OrgId: 1, Name: "short-lived", Key: "short-lived", SecondsToLive: 3600},
+	}
+	for _, cmd := range keys {
+		require.NoError(t, ss.AddAPIKey(ctx, cmd))
+	}
+
+	cfg := *db.Cfg
+	cfg.ApiKeyMaxSecondsToLive = 7 * 24 * 3600
+	cfg.ApiKeyLifetimeEnforcement = setting.ApiKeyLifetimeEnforcementEnforce
+	enforcer := &LifetimeEnforcer{cfg: &cfg, store: ss, log: log.New("test"), clock: clk}
+	require.False(t, enforcer.IsDisabled())
+
+	names := func(report *LifetimeReport) []string {
+		var names []string
+		for _, k := range report.Keys {
+			names = append(names, k.Name)
+		}
+		return names
+	}
+
+	t.Run("dry run reports the keys without updating them", func(t *testing.T) {
+		report, err := enforcer.Enforce(ctx, true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"old", "never-expiring", "long-lived"}, names(report))
+		assert.True(t, report.Keys[0].Expired)
+		assert.False(t, report.Keys[1].Expired)
+		assert.Zero(t, report.Updated)
+
+		query := apikey.GetByIDQuery{ApiKeyId: keys[0].Result.Id}
+		require.NoError(t, ss.GetApiKeyById(ctx, &query))
+		assert.Nil(t, query.Result.Expires)
+	})
+
+	t.Run("enforce shortens the expiration of the keys", func(t *testing.T) {
+		report, err := enforcer.Enforce(ctx, false)
+		require.NoError(t, err)
+		assert.Len(t, report.Keys, 3)
+		assert.Equal(t, 3, report.Updated)
+
+		query := apikey.GetByIDQuery{ApiKeyId: keys[0].Result.Id}
+		require.NoError(t, ss.GetApiKeyById(ctx, &query))
+		require.NotNil(t, query.Result.Expires)
+		assert.Equal(t, query.Result.Created.Unix()+cfg.ApiKeyMaxSecondsToLive, *query.Result.Expires)
+
+		query = apikey.GetByIDQuery{ApiKeyId: old.Result.Id}
+		require.NoError(t, ss.GetApiKeyById(ctx, &query))
+		require.NotNil(t, query.Result.Expires)
+		assert.LessOrEqual(t, *query.Result.Expires, now.Unix())
+	})
+
+	t.Run("enforce is a no-op once the keys comply", func(t *testing.T) {
+		report, err := enforcer.Enforce(ctx, false)
+		require.NoError(t, err)
+		assert.Empty(t, report.Keys)
+	})
+}
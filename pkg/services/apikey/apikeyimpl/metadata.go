@@ -0,0 +1,97 @@
+package apikeyimpl
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/secrets"
+)
+
+// encryptMetadata sets the encrypted copy of the labels and the access
+// windows of a key, which is empty when the key has none. The plaintext copy
+// is cleared for service account tokens, API keys keep it to be searched.
+func (s *Service) encryptMetadata(ctx context.Context, key *apikey.APIKey) error {
+	key.EncryptedMetadata = ""
+	if key.HasMetadata() {
+		payload, err := json.Marshal(apikey.Metadata{Labels: key.Labels, AccessWindows: key.AccessWindows})
+		if err != nil {
+			return err
+		}
+		encrypted, err := s.secretsService.Encrypt(ctx, payload, secrets.WithoutScope())
+		if err != nil {
+			return err
+		}
+		key.EncryptedMetadata = base64.StdEncoding.EncodeToString(encrypted)
+	}
+	if key.ServiceAccountId != nil {
+		key.Labels = nil
+		key.AccessWindows = nil
+	}
+	return nil
+}
+
+// decryptMetadata sets the labels and the access windows of a key from their
+// encrypted copy. Keys without encrypted copy and API keys whose plaintext
+// copy is set are left untouched.
+func (s *Service) decryptMetadata(ctx context.Context, key *apikey.APIKey) error {
+	if key.EncryptedMetadata == "" || (key.ServiceAccountId == nil && key.HasMetadata()) {
+		return nil
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(key.EncryptedMetadata)
+	if err != nil {
+		return err
+	}
+	payload, err := s.secretsService.Decrypt(ctx, encrypted)
+	if err != nil {
+		return err
+	}
+	var metadata apikey.Metadata
+	if err := json.Unmarshal(payload, &metadata); err != nil {
+		return err
+	}
+	key.Labels = metadata.Labels
+	key.AccessWindows = metadata.AccessWindows
+	return nil
+}
+
+// ProtectMetadata places the labels and the access windows of a key read from
+// the database according to its kind, typically when it is converted to a
+// service account token or back to an API key: only their encrypted copy is
+// kept for tokens, API keys keep both copies.
+func (s *Service) ProtectMetadata(ctx context.Context, key *apikey.APIKey) error {
+	if err := s.decryptMetadata(ctx, key); err != nil {
+		return err
+	}
+	return s.encryptMetadata(ctx, key)
+}
+
+// migrateMetadata places the labels and the access windows of the keys that
+// were written before their metadata was protected on write: API keys without
+// encrypted copy, API keys converted back from tokens without plaintext copy,
+// and tokens with a plaintext copy. A key that can't be migrated doesn't
+// prevent the migration of the others.
+func (s *Service) migrateMetadata(ctx context.Context) {
+	keys, err := s.store.GetKeysWithMisplacedMetadata(ctx)
+	if err != nil {
+		s.log.Warn("failed to list api keys with misplaced metadata", "error", err)
+		return
+	}
+
+	migrated := 0
+	for _, key := range keys {
+		err = s.ProtectMetadata(ctx, key)
+		if err == nil {
+			err = s.store.UpdateAPIKeyMetadata(ctx, key)
+		}
+		if err != nil {
+			s.log.Error("failed to migrate api key metadata", "id", key.Id, "error", err)
+			continue
+		}
+		migrated++
+	}
+	if migrated > 0 {
+		s.log.Info("migrated api key metadata", "count", migrated)
+	}
+}
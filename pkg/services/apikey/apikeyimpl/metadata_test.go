@@ -0,0 +1,148 @@
+package apikeyimpl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/org"
+	secretsDatabase "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+func TestIntegrationMigrateMetadata(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	secretsService := secretsManager.SetupTestService(t, secretsDatabase.ProvideSecretsStore(db))
+	s := ProvideService(db, db.Cfg, secretsService, encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clock.New())
+	ctx := context.Background()
+
+	windows := []apikey.AccessWindow{{Days: []string{"mon"}, Start: "02:00", End: "04:00"}}
+	cmd := &apikey.AddCommand{OrgId: 1, Name: "converted", Key: "converted-hash", Role: org.RoleViewer,
+		Labels: map[string]string{"team": "billing"}, AccessWindows: windows}
+	require.NoError(t, s.AddAPIKey(ctx, cmd))
+	plain := &apikey.AddCommand{OrgId: 1, Name: "plain", Key: "plain-hash", Role: org.RoleViewer}
+	require.NoError(t, s.AddAPIKey(ctx, plain))
+
+	storedKey := func(t *testing.T, id int64) *apikey.APIKey {
+		t.Helper()
+		var key apikey.APIKey
+		err := db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+			_, err := sess.ID(id).Get(&key)
+			return err
+		})
+		require.NoError(t, err)
+		return &key
+	}
+	setServiceAccount := func(t *testing.T, id int64, serviceAccountID interface{}) {
+		t.Helper()
+		err := db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+			_, err := sess.Exec("UPDATE api_key SET service_account_id = ? WHERE id = ?", serviceAccountID, id)
+			return err
+		})
+		require.NoError(t, err)
+	}
+
+	decrypted := func(t *testing.T, key *apikey.APIKey) apikey.Metadata {
+		t.Helper()
+		token := &apikey.APIKey{EncryptedMetadata: key.EncryptedMetadata, ServiceAccountId: key.ServiceAccountId}
+		require.NoError(t, s.decryptMetadata(ctx, token))
+		return apikey.Metadata{Labels: token.Labels, AccessWindows: token.AccessWindows}
+	}
+
+	t.Run("keeps an encrypted copy of the metadata of API keys", func(t *testing.T) {
+		key := storedKey(t, cmd.Result.Id)
+		assert.Equal(t, map[string]string{"team": "billing"}, key.Labels)
+		require.NotEmpty(t, key.EncryptedMetadata)
+		assert.NotContains(t, key.EncryptedMetadata, "billing")
+		assert.Empty(t, storedKey(t, plain.Result.Id).EncryptedMetadata, "keys without metadata should have no copy")
+
+		key.ServiceAccountId = new(int64)
+		assert.Equal(t, apikey.Metadata{Labels: map[string]string{"team": "billing"}, AccessWindows: windows}, decrypted(t, key))
+	})
+
+	t.Run("updates the encrypted copy with the access windows", func(t *testing.T) {
+		windows = []apikey.AccessWindow{{Start: "08:00", End: "18:00"}}
+		require.NoError(t, s.UpdateAPIKey(ctx, &apikey.UpdateCommand{Id: cmd.Result.Id, OrgId: 1, AccessWindows: windows}))
+
+		key := storedKey(t, cmd.Result.Id)
+		assert.Equal(t, windows, key.AccessWindows)
+		key.ServiceAccountId = new(int64)
+		assert.Equal(t, apikey.Metadata{Labels: map[string]string{"team": "billing"}, AccessWindows: windows}, decrypted(t, key))
+	})
+
+	t.Run("keeps only the encrypted copy of keys converted to tokens", func(t *testing.T) {
+		key := storedKey(t, cmd.Result.Id)
+		serviceAccountID := int64(42)
+		key.ServiceAccountId = &serviceAccountID
+		require.NoError(t, s.ProtectMetadata(ctx, key))
+		assert.Empty(t, key.Labels)
+		assert.Empty(t, key.AccessWindows)
+		assert.Equal(t, apikey.Metadata{Labels: map[string]string{"team": "billing"}, AccessWindows: windows}, decrypted(t, key))
+
+		key.ServiceAccountId = nil
+		require.NoError(t, s.ProtectMetadata(ctx, key))
+		assert.Equal(t, map[string]string{"team": "billing"}, key.Labels)
+		assert.Equal(t, windows, key.AccessWindows)
+		assert.NotEmpty(t, key.EncryptedMetadata)
+	})
+
+	t.Run("encrypts the metadata of tokens stored in plaintext", func(t *testing.T) {
+		setServiceAccount(t, cmd.Result.Id, 42)
+		setServiceAccount(t, plain.Result.Id, 42)
+		s.migrateMetadata(ctx)
+
+		key := storedKey(t, cmd.Result.Id)
+		assert.Empty(t, key.Labels)
+		assert.Empty(t, key.AccessWindows)
+		require.NotEmpty(t, key.EncryptedMetadata)
+		assert.NotContains(t, key.EncryptedMetadata, "billing")
+		assert.Empty(t, storedKey(t, plain.Result.Id).EncryptedMetadata, "tokens without metadata should be left untouched")
+	})
+
+	t.Run("decrypts the metadata of tokens when reading them", func(t *testing.T) {
+		key, err := s.GetAPIKeyByHash(ctx, "converted-hash")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"team": "billing"}, key.Labels)
+		assert.Equal(t, windows, key.AccessWindows)
+
+		query := &apikey.GetByIDQuery{ApiKeyId: cmd.Result.Id}
+		require.NoError(t, s.GetApiKeyById(ctx, query))
+		assert.Equal(t, map[string]string{"team": "billing"}, query.Result.Labels)
+	})
+
+	t.Run("restores the plaintext metadata of tokens converted back to API keys", func(t *testing.T) {
+		setServiceAccount(t, cmd.Result.Id, nil)
+		s.migrateMetadata(ctx)
+
+		key := storedKey(t, cmd.Result.Id)
+		assert.Equal(t, map[string]string{"team": "billing"}, key.Labels)
+		assert.Equal(t, windows, key.AccessWindows)
+		assert.NotEmpty(t, key.EncryptedMetadata)
+	})
+
+	t.Run("adds the encrypted copy of API keys created without one", func(t *testing.T) {
+		err := db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+			_, err := sess.Exec("UPDATE api_key SET encrypted_metadata = NULL WHERE id = ?", cmd.Result.Id)
+			return err
+		})
+		require.NoError(t, err)
+		s.migrateMetadata(ctx)
+
+		key := storedKey(t, cmd.Result.Id)
+		assert.Equal(t, map[string]string{"team": "billing"}, key.Labels)
+		key.ServiceAccountId = new(int64)
+		assert.Equal(t, apikey.Metadata{Labels: map[string]string{"team": "billing"}, AccessWindows: windows}, decrypted(t, key))
+	})
+}
@@ -0,0 +1,104 @@
+package apikeyimpl
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/grafana/grafana/pkg/infra/metrics/metricutil"
+	"github.com/grafana/grafana/pkg/services/apikey"
+)
+
+// Expiry buckets of the keys gauge, a key belongs to the first bucket whose
+// duration is not exceeded by its remaining lifetime.
+const (
+	expiryBucketExpired = "expired"
+	expiryBucket1d      = "1d"
+	expiryBucket7d      = "7d"
+	expiryBucket30d     = "30d"
+	expiryBucketLater   = "later"
+	expiryBucketNever   = "never"
+)
+
+var expiryBuckets = []string{expiryBucketExpired, expiryBucket1d, expiryBucket7d, expiryBucket30d, expiryBucketLater, expiryBucketNever}
+
+var (
+	createdCounter = metricutil.NewCounterStartingAtZero(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "api_key_created_total",
+		Help:      "A counter for created API keys",
+	})
+	deletedCounter = metricutil.NewCounterStartingAtZero(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "api_key_deleted_total",
+		Help:      "A counter for deleted API keys",
+	})
+	validationsCounter = metricutil.NewCounterStartingAtZero(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "api_key_validations_total",
+		Help:      "A counter for requests authenticated with an API key",
+	})
+	validationFailuresCounter = metricutil.NewCounterVecStartingAtZero(
+		prometheus.CounterOpts{
+			Namespace: metrics.ExporterName,
+			Name:      "api_key_validation_failures_total",
+			Help:      "A counter for requests rejected during API key authentication, by reason",
+		},
+		[]string{"reason"},
+		map[string][]string{
+			"reason": {
+				string(apikey.ValidationInvalid),
+				string(apikey.ValidationExpired),
+				string(apikey.ValidationIPNotAllowed),
+				string(apikey.ValidationRateLimited),
+				string(apikey.ValidationPending),
+				string(apikey.ValidationOutsideWindow),
+				string(apikey.ValidationError),
+			},
+		},
+	)
+	lastUsedUpdateDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "api_key_last_used_update_duration_seconds",
+		Help:      "Histogram of the time taken to write the last usage of API keys to the database",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 7),
+	})
+	keysByExpiryGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "api_keys_by_expiry",
+		Help:      "Number of API keys by time left before they expire",
+	}, []string{"bucket"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		createdCounter,
+		deletedCounter,
+		validationsCounter,
+		validationFailuresCounter,
+		lastUsedUpdateDuration,
+		keysByExpiryGauge,
+	)
+}
+
+// expiryBucket returns the bucket of a key expiring at expires, now and
+// expires being Unix timestamps.
+func expiryBucket(expires *int64, now int64) string {
+	if expires == nil {
+		return expiryBucketNever
+	}
+
+	const day = 24 * 60 * 60
+	left := *expires - now
+	switch {
+	case left <= 0:
+		return expiryBucketExpired
+	case left <= day:
+		return expiryBucket1d
+	case left <= 7*day:
+		return expiryBucket7d
+	case left <= 30*day:
+		return expiryBucket30d
+	default:
+		return expiryBucketLater
+	}
+}
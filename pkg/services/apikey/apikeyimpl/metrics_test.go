@@ -0,0 +1,73 @@
+package apikeyimpl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+func TestExpiryBucket(t *testing.T) {
+	now := time.Now().Unix()
+	at := func(d time.Duration) *int64 {
+		v := now + int64(d.Seconds())
+		return &v
+	}
+
+	assert.Equal(t, expiryBucketNever, expiryBucket(nil, now))
+	assert.Equal(t, expiryBucketExpired, expiryBucket(at(-time.Hour), now))
+	assert.Equal(t, expiryBucketExpired, expiryBucket(at(0), now))
+	assert.Equal(t, expiryBucket1d, expiryBucket(at(time.Hour), now))
+	assert.Equal(t, expiryBucket7d, expiryBucket(at(48*time.Hour), now))
+	assert.Equal(t, expiryBucket30d, expiryBucket(at(10*24*time.Hour), now))
+	assert.Equal(t, expiryBucketLater, expiryBucket(at(90*24*time.Hour), now))
+}
+
+func TestIntegrationMetrics(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	s := ProvideService(db, db.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clock.New())
+	ctx := context.Background()
+
+	created := testutil.ToFloat64(createdCounter)
+	deleted := testutil.ToFloat64(deletedCounter)
+
+	keys := []apikey.AddCommand{
+		{OrgId: 1, Name: "never", Key: "never"},
+		{OrgId: 1, Name: "hour", Key: "hour", SecondsToLive: 3600},
+		{OrgId: 1, Name: "week", Key: "week", SecondsToLive: 5 * 24 * 3600},
+	}
+	for i := range keys {
+		require.NoError(t, s.AddAPIKey(ctx, &keys[i]))
+	}
+	require.NoError(t, s.DeleteApiKey(ctx, &apikey.DeleteCommand{Id: keys[2].Result.Id, OrgId: 1}))
+
+	assert.Equal(t, created+3, testutil.ToFloat64(createdCounter))
+	assert.Equal(t, deleted+1, testutil.ToFloat64(deletedCounter))
+
+	s.collectMetrics(ctx)
+	assert.Equal(t, float64(1), testutil.ToFloat64(keysByExpiryGauge.WithLabelValues(expiryBucketNever)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(keysByExpiryGauge.WithLabelValues(expiryBucket1d)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(keysByExpiryGauge.WithLabelValues(expiryBucket7d)))
+
+	validations := testutil.ToFloat64(validationsCounter)
+	expired := testutil.ToFloat64(validationFailuresCounter.WithLabelValues(string(apikey.ValidationExpired)))
+	s.ReportValidation(apikey.ValidationSucceeded)
+	s.ReportValidation(apikey.ValidationExpired)
+	assert.Equal(t, validations+2, testutil.ToFloat64(validationsCounter))
+	assert.Equal(t, expired+1, testutil.ToFloat64(validationFailuresCounter.WithLabelValues(string(apikey.ValidationExpired))))
+}
@@ -0,0 +1,65 @@
+package apikeyimpl
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/apikey"
+)
+
+const (
+	// kvStoreNamespace is the namespace of the kvstore entries of API keys.
+	kvStoreNamespace = "apikey"
+	namingPolicyKey  = "naming-policy"
+)
+
+func (s *Service) GetNamingPolicy(ctx context.Context, query *apikey.GetNamingPolicyQuery) error {
+	policy, err := s.getNamingPolicy(ctx, query.OrgId)
+	if err != nil {
+		return err
+	}
+	query.Result = policy
+	return nil
+}
+
+// SetNamingPolicy replaces the naming policy of the organization, an empty
+// policy removes it.
+func (s *Service) SetNamingPolicy(ctx context.Context, cmd *apikey.SetNamingPolicyCommand) error {
+	if err := cmd.Policy.Validate(); err != nil {
+		return err
+	}
+
+	store := kvstore.WithNamespace(s.kvStore, cmd.OrgId, kvStoreNamespace)
+	if cmd.Policy.Pattern == "" && cmd.Policy.RequiredPrefix == "" && len(cmd.Policy.ForbiddenWords) == 0 {
+		return store.Del(ctx, namingPolicyKey)
+	}
+
+	value, err := json.Marshal(cmd.Policy)
+	if err != nil {
+		return err
+	}
+	return store.Set(ctx, namingPolicyKey, string(value))
+}
+
+func (s *Service) getNamingPolicy(ctx context.Context, orgID int64) (*apikey.NamingPolicy, error) {
+	policy := &apikey.NamingPolicy{}
+	value, exists, err := kvstore.WithNamespace(s.kvStore, orgID, kvStoreNamespace).Get(ctx, namingPolicyKey)
+	if err != nil || !exists {
+		return policy, err
+	}
+	if err := json.Unmarshal([]byte(value), policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// checkNamingPolicy returns an error describing why name does not follow the
+// naming policy of the organization.
+func (s *Service) checkNamingPolicy(ctx context.Context, orgID int64, name string) error {
+	policy, err := s.getNamingPolicy(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	return policy.Check(name)
+}
@@ -0,0 +1,75 @@
+package apikeyimpl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+func TestIntegrationNamingPolicy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	s := ProvideService(db, db.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clock.New())
+	ctx := context.Background()
+
+	policy := apikey.NamingPolicy{
+		Pattern:        "^ci-[a-z]+-[a-z]+$",
+		RequiredPrefix: "ci-",
+		ForbiddenWords: []string{"tmp"},
+	}
+	require.NoError(t, s.SetNamingPolicy(ctx, &apikey.SetNamingPolicyCommand{OrgId: 1, Policy: policy}))
+
+	t.Run("returns the policy of the organization", func(t *testing.T) {
+		query := apikey.GetNamingPolicyQuery{OrgId: 1}
+		require.NoError(t, s.GetNamingPolicy(ctx, &query))
+		assert.Equal(t, policy, *query.Result)
+
+		query = apikey.GetNamingPolicyQuery{OrgId: 2}
+		require.NoError(t, s.GetNamingPolicy(ctx, &query))
+		assert.Equal(t, apikey.NamingPolicy{}, *query.Result)
+	})
+
+	for name, expected := range map[string]string{
+		"ci-platform-deploy": "",
+		"platform-deploy":    `name must start with "ci-"`,
+		"ci-platform":        `name must match "^ci-[a-z]+-[a-z]+$"`,
+		"ci-tmp-deploy":      `name must not contain "tmp"`,
+	} {
+		t.Run("validates "+name, func(t *testing.T) {
+			err := s.AddAPIKey(ctx, &apikey.AddCommand{OrgId: 1, Name: name, Key: name})
+			if expected == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, apikey.ErrNamingPolicy)
+			assert.Contains(t, err.Error(), expected)
+		})
+	}
+
+	t.Run("does not apply to other organizations", func(t *testing.T) {
+		require.NoError(t, s.AddAPIKey(ctx, &apikey.AddCommand{OrgId: 2, Name: "tmp", Key: "tmp"}))
+	})
+
+	t.Run("rejects invalid patterns", func(t *testing.T) {
+		cmd := apikey.SetNamingPolicyCommand{OrgId: 1, Policy: apikey.NamingPolicy{Pattern: "["}}
+		require.ErrorIs(t, s.SetNamingPolicy(ctx, &cmd), apikey.ErrInvalidPolicy)
+	})
+
+	t.Run("an empty policy removes it", func(t *testing.T) {
+		require.NoError(t, s.SetNamingPolicy(ctx, &apikey.SetNamingPolicyCommand{OrgId: 1}))
+		require.NoError(t, s.AddAPIKey(ctx, &apikey.AddCommand{OrgId: 1, Name: "tmp", Key: "tmp-1"}))
+	})
+}
@@ -0,0 +1,104 @@
+package apikeyimpl
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/secrets"
+)
+
+// encryptSigningSecret encrypts the secret of a signing key before it is
+// stored, Grafana needs the secret itself to verify signatures.
+func (s *Service) encryptSigningSecret(ctx context.Context, secret string) (string, error) {
+	if secret == "" {
+		return "", apikey.ErrInvalid
+	}
+	encrypted, err := s.secretsService.Encrypt(ctx, []byte(secret), secrets.WithoutScope())
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+func (s *Service) decryptSigningSecret(ctx context.Context, encoded string) (string, error) {
+	encrypted, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	secret, err := s.secretsService.Decrypt(ctx, encrypted)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// VerifySignature checks the signature of a request signed with a signing
+// key. It returns apikey.ErrInvalid for unknown keys, keys that are not
+// signing keys and wrong signatures.
+func (s *Service) VerifySignature(ctx context.Context, query *apikey.VerifySignatureQuery) error {
+	skew := s.clock.Now().Sub(time.Unix(query.Timestamp, 0))
+	if skew > apikey.SignatureMaxSkew || skew < -apikey.SignatureMaxSkew {
+		return apikey.ErrSignatureExpired
+	}
+
+	keyQuery := apikey.GetByIDQuery{ApiKeyId: query.ApiKeyId}
+	if err := s.store.GetApiKeyById(ctx, &keyQuery); err != nil {
+		return err
+	}
+	key := keyQuery.Result
+	if !key.IsSigning() || key.SigningSecret == "" {
+		return apikey.ErrInvalid
+	}
+
+	signature, err := hex.DecodeString(query.Signature)
+	if err != nil {
+		return apikey.ErrInvalid
+	}
+	stringToSign := apikey.StringToSign(query.Method, query.URI, query.Timestamp, query.BodyHash)
+
+	secret, valid, err := s.verifySignatureWith(ctx, key.SigningSecret, signature, stringToSign)
+	if err != nil {
+		return err
+	}
+	// the secret replaced by a rotation is valid during the grace period
+	if !valid && key.PreviousSigningSecret != nil && key.PreviousKeyExpires != nil &&
+		*key.PreviousKeyExpires > s.clock.Now().Unix() {
+		if secret, valid, err = s.verifySignatureWith(ctx, *key.PreviousSigningSecret, signature, stringToSign); err != nil {
+			return err
+		}
+	}
+	if !valid {
+		return apikey.ErrInvalid
+	}
+
+	// the secret is never sent, the leaks of keys without fingerprint are
+	// looked up with the secret that signed the request instead
+	leaked, err := s.CheckLeakedKey(ctx, key, secret)
+	if err != nil {
+		return err
+	}
+	if leaked {
+		return apikey.ErrLeaked
+	}
+
+	query.Result = key
+	return nil
+}
+
+// verifySignatureWith returns the decrypted secret, and whether it produced
+// the signature.
+func (s *Service) verifySignatureWith(ctx context.Context, encryptedSecret string, signature []byte, stringToSign string) (string, bool, error) {
+	secret, err := s.decryptSigningSecret(ctx, encryptedSecret)
+	if err != nil {
+		return "", false, err
+	}
+	expected, err := hex.DecodeString(apikey.Sign(secret, stringToSign))
+	if err != nil {
+		return "", false, err
+	}
+	return secret, hmac.Equal(signature, expected), nil
+}
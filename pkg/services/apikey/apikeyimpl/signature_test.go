@@ -0,0 +1,116 @@
+package apikeyimpl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/org"
+	secretsDatabase "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+func TestIntegrationVerifySignature(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	secretsService := secretsManager.SetupTestService(t, secretsDatabase.ProvideSecretsStore(db))
+	s := ProvideService(db, db.Cfg, secretsService, encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clk)
+	ctx := context.Background()
+
+	signing := &apikey.AddCommand{OrgId: 1, Name: "signing", Key: "signing-hash", Role: org.RoleViewer, Type: apikey.TypeSigning, SigningSecret: "s3cr3t"}
+	require.NoError(t, s.AddAPIKey(ctx, signing))
+	assert.Equal(t, "s3cr3t", signing.SigningSecret, "the command should keep the secret it was given")
+	assert.NotContains(t, signing.Result.SigningSecret, "s3cr3t", "the stored secret should be encrypted")
+
+	bearer := &apikey.AddCommand{OrgId: 1, Name: "bearer", Key: "bearer-hash", Role: org.RoleViewer}
+	require.NoError(t, s.AddAPIKey(ctx, bearer))
+
+	now := clk.Now()
+	body := apikey.HashBody([]byte(`{"dashboard":{}}`))
+	signedQuery := func(id int64, secret string, timestamp time.Time) *apikey.VerifySignatureQuery {
+		return &apikey.VerifySignatureQuery{
+			ApiKeyId:  id,
+			Timestamp: timestamp.Unix(),
+			Signature: apikey.Sign(secret, apikey.StringToSign("POST", "/api/dashboards/db", timestamp.Unix(), body)),
+			Method:    "POST",
+			URI:       "/api/dashboards/db",
+			BodyHash:  body,
+		}
+	}
+
+	t.Run("accepts requests signed with the secret", func(t *testing.T) {
+		query := signedQuery(signing.Result.Id, "s3cr3t", now)
+		require.NoError(t, s.VerifySignature(ctx, query))
+		assert.Equal(t, signing.Result.Id, query.Result.Id)
+	})
+
+	t.Run("rejects requests signed with another secret", func(t *testing.T) {
+		require.ErrorIs(t, s.VerifySignature(ctx, signedQuery(signing.Result.Id, "other", now)), apikey.ErrInvalid)
+	})
+
+	t.Run("rejects requests whose body was changed", func(t *testing.T) {
+		query := signedQuery(signing.Result.Id, "s3cr3t", now)
+		query.BodyHash = apikey.HashBody([]byte(`{}`))
+		require.ErrorIs(t, s.VerifySignature(ctx, query), apikey.ErrInvalid)
+	})
+
+	t.Run("rejects old signatures", func(t *testing.T) {
+		query := signedQuery(signing.Result.Id, "s3cr3t", now.Add(-apikey.SignatureMaxSkew-time.Minute))
+		require.ErrorIs(t, s.VerifySignature(ctx, query), apikey.ErrSignatureExpired)
+	})
+
+	t.Run("rejects bearer keys", func(t *testing.T) {
+		require.ErrorIs(t, s.VerifySignature(ctx, signedQuery(bearer.Result.Id, "s3cr3t", now)), apikey.ErrInvalid)
+	})
+
+	t.Run("rotation replaces the secret", func(t *testing.T) {
+		rotate := &apikey.RotateCommand{Id: signing.Result.Id, OrgId: 1, Key: "rotated-hash", SigningSecret: "n3w"}
+		require.NoError(t, s.RotateAPIKey(ctx, rotate))
+
+		require.ErrorIs(t, s.VerifySignature(ctx, signedQuery(signing.Result.Id, "s3cr3t", now)), apikey.ErrInvalid)
+		require.NoError(t, s.VerifySignature(ctx, signedQuery(signing.Result.Id, "n3w", now)))
+	})
+
+	t.Run("the previous secret is valid during the grace period", func(t *testing.T) {
+		gracePeriod := int64(60)
+		rotate := &apikey.RotateCommand{Id: signing.Result.Id, OrgId: 1, Key: "graceful-hash", SigningSecret: "n3w3r", GracePeriodSeconds: &gracePeriod}
+		require.NoError(t, s.RotateAPIKey(ctx, rotate))
+
+		require.NoError(t, s.VerifySignature(ctx, signedQuery(signing.Result.Id, "n3w3r", now)))
+		require.NoError(t, s.VerifySignature(ctx, signedQuery(signing.Result.Id, "n3w", now)))
+		require.ErrorIs(t, s.VerifySignature(ctx, signedQuery(signing.Result.Id, "s3cr3t", now)), apikey.ErrInvalid)
+
+		clk.Add(2 * time.Minute)
+		now := clk.Now()
+		require.NoError(t, s.VerifySignature(ctx, signedQuery(signing.Result.Id, "n3w3r", now)))
+		require.ErrorIs(t, s.VerifySignature(ctx, signedQuery(signing.Result.Id, "n3w", now)), apikey.ErrInvalid)
+	})
+
+	t.Run("rejects keys without fingerprint whose secret leaked", func(t *testing.T) {
+		leaked := &apikey.AddCommand{OrgId: 1, Name: "leaked-signing", Key: "leaked-signing-hash", Role: org.RoleViewer, Type: apikey.TypeSigning, SigningSecret: "l34k3d"}
+		require.NoError(t, s.AddAPIKey(ctx, leaked))
+		report := &apikey.ReportLeaksCommand{Hashes: []string{apikey.LeakHash("l34k3d")}, Source: "scanner"}
+		require.NoError(t, s.ReportLeakedKeys(ctx, report))
+
+		require.ErrorIs(t, s.VerifySignature(ctx, signedQuery(leaked.Result.Id, "l34k3d", now)), apikey.ErrLeaked)
+	})
+
+	t.Run("rejects unknown types", func(t *testing.T) {
+		cmd := &apikey.AddCommand{OrgId: 1, Name: "unknown", Key: "unknown-hash", Role: org.RoleViewer, Type: "hmac"}
+		require.ErrorIs(t, s.AddAPIKey(ctx, cmd), apikey.ErrInvalidType)
+	})
+}
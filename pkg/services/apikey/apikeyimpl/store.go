@@ -2,9 +2,13 @@ package apikeyimpl
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
@@ -17,53 +21,244 @@ type store interface {
 	GetAPIKeys(ctx context.Context, query *apikey.GetApiKeysQuery) error
 	GetAllAPIKeys(ctx context.Context, orgID int64) []*apikey.APIKey
 	DeleteApiKey(ctx context.Context, cmd *apikey.DeleteCommand) error
+	DeleteByFilter(ctx context.Context, cmd *apikey.DeleteByFilterCommand) error
 	AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) error
+	CountActiveKeys(ctx context.Context, orgID int64) (int64, error)
+	RotateAPIKey(ctx context.Context, cmd *apikey.RotateCommand) error
+	PromoteSecondaryAPIKey(ctx context.Context, cmd *apikey.PromoteSecondaryCommand) error
+	RevokeSecondaryAPIKey(ctx context.Context, cmd *apikey.RevokeSecondaryCommand) error
+	UpdateAPIKey(ctx context.Context, cmd *apikey.UpdateCommand) error
+	ApproveAPIKey(ctx context.Context, cmd *apikey.ApproveCommand) error
+	RejectAPIKey(ctx context.Context, cmd *apikey.RejectCommand) error
 	GetApiKeyById(ctx context.Context, query *apikey.GetByIDQuery) error
 	GetApiKeyByName(ctx context.Context, query *apikey.GetByNameQuery) error
-	GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, error)
-	UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64) error
+	GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, bool, error)
+	GetAPIKeyIDsByCreator(ctx context.Context, orgID int64, userID int64) ([]int64, error)
+	GetAPIKeyIDsByTeam(ctx context.Context, orgID int64, teamID int64) ([]int64, error)
+	UpdateAPIKeysLastUsed(ctx context.Context, cmds []*apikey.UpdateLastUsedCommand) error
+	UpdateAPIKeyHash(ctx context.Context, id int64, oldHash, newHash string) error
+	GetKeysWithMisplacedMetadata(ctx context.Context) ([]*apikey.APIKey, error)
+	UpdateAPIKeyMetadata(ctx context.Context, key *apikey.APIKey) error
+	GetKeysExpiringBefore(ctx context.Context, before int64) ([]*apikey.APIKey, error)
+	ImportAPIKeys(ctx context.Context, keys []*apikey.APIKey) (int, error)
+	GetExpirations(ctx context.Context) ([]*int64, error)
+	GetKeysExceedingLifetime(ctx context.Context, maxSecondsToLive int64) ([]*apikey.APIKey, error)
+	ShortenAPIKeyExpiration(ctx context.Context, id int64, expires int64) (bool, error)
+	GetIdleAPIKeys(ctx context.Context, since time.Time) ([]*apikey.APIKey, error)
+	InsertAPIKeyUsage(ctx context.Context, rows []*usageRow) error
+	GetAPIKeyUsageStats(ctx context.Context, id int64, now time.Time) (*apikey.UsageStats, error)
+	GetAPIKeySummary(ctx context.Context, orgID int64, now time.Time, expiringWindow time.Duration) (*apikey.Summary, error)
+	RollupAPIKeyUsage(ctx context.Context, now time.Time) error
+	DeleteOrphanFingerprints(ctx context.Context) (int64, error)
+	PublishExpiredAPIKeys(ctx context.Context, from, to time.Time) (int, error)
+	GetLeak(ctx context.Context, hash string) (*apikey.Leak, error)
+	BackfillFingerprint(ctx context.Context, key *apikey.APIKey, hash string) error
+	RecordLeak(ctx context.Context, leak *apikey.Leak, key *apikey.APIKey) error
 }
 
 type sqlStore struct {
-	db  db.DB
-	cfg *setting.Cfg
+	db    db.DB
+	cfg   *setting.Cfg
+	clock clock.Clock
 }
 
-// timeNow makes it possible to test usage of time
-var timeNow = time.Now
+// maxUserAgentLength is the size of the last_used_user_agent column
+const maxUserAgentLength = 255
 
-func (ss *sqlStore) GetAPIKeys(ctx context.Context, query *apikey.GetApiKeysQuery) error {
-	return ss.db.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
-		var sess *xorm.Session
+// importBatchSize is the number of imported keys read back at once
+const importBatchSize = 500
 
-		if query.IncludeExpired {
-			sess = dbSession.Limit(100, 0).
-				Where("org_id=?", query.OrgId).
-				Asc("name")
-		} else {
-			sess = dbSession.Limit(100, 0).
-				Where("org_id=? and ( expires IS NULL or expires >= ?)", query.OrgId, timeNow().Unix()).
-				Asc("name")
-		}
+// deleteBatchSize is the number of keys deleted by a statement when keys are
+// deleted by filter
+const deleteBatchSize = 500
 
-		sess = sess.Where("service_account_id IS NULL")
+// dbContext tags the queries of the store in the per-service database metrics
+func dbContext(ctx context.Context) context.Context {
+	return sqlstore.WithServiceName(ctx, "apikey")
+}
 
-		if !accesscontrol.IsDisabled(ss.cfg) {
-			filter, err := accesscontrol.Filter(query.User, "id", "apikeys:id:", accesscontrol.ActionAPIKeyRead)
+func (ss *sqlStore) GetAPIKeys(ctx context.Context, query *apikey.GetApiKeysQuery) error {
+	return ss.db.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		query.Result = make([]*apikey.APIKey, 0)
+		// the free text query also matches the JSON text of the labels, the
+		// keys matching only through it are dropped after reading a page and
+		// pages are read until the limit is filled
+		for offset := 0; ; offset += apiKeysPageSize {
+			sess, err := ss.apiKeysSession(dbSession, query)
 			if err != nil {
 				return err
 			}
-			sess.And(filter.Where, filter.Args...)
-		}
+			page := make([]*apikey.APIKey, 0)
+			if err := sess.Limit(apiKeysPageSize, offset).Find(&page); err != nil {
+				return err
+			}
+			read := len(page)
+			if query.Query != "" {
+				if page, err = ss.keepSearchMatches(dbSession, query, page); err != nil {
+					return err
+				}
+			}
 
-		query.Result = make([]*apikey.APIKey, 0)
-		return sess.Find(&query.Result)
+			query.Result = append(query.Result, page...)
+			if len(query.Result) >= apiKeysPageSize {
+				query.Result = query.Result[:apiKeysPageSize]
+				return nil
+			}
+			if query.Query == "" || read < apiKeysPageSize {
+				return nil
+			}
+		}
 	})
 }
 
+// apiKeysPageSize is the maximum number of keys returned by GetAPIKeys.
+const apiKeysPageSize = 100
+
+// apiKeysSession selects the keys returned by GetAPIKeys, ordered by name.
+func (ss *sqlStore) apiKeysSession(dbSession *sqlstore.DBSession, query *apikey.GetApiKeysQuery) (*xorm.Session, error) {
+	var sess *xorm.Session
+	if query.IncludeExpired {
+		sess = dbSession.Where("org_id=?", query.OrgId).
+			Asc("name")
+	} else {
+		sess = dbSession.Where("org_id=? and ( expires IS NULL or expires >= ?)", query.OrgId, ss.clock.Now().Unix()).
+			Asc("name")
+	}
+
+	sess = sess.Where("service_account_id IS NULL")
+
+	like := ss.db.GetDialect().LikeStr()
+	for name, value := range query.Labels {
+		fragment, err := labelFragment(name, value)
+		if err != nil {
+			return nil, err
+		}
+		// the label is preceded by the start of the object or a comma and
+		// followed by a comma or the end of the object, both of which only
+		// appear outside of strings in the compact JSON of the column
+		fragment = likeEscaper.Replace(fragment)
+		sess.And("(labels "+like+" ? ESCAPE '"+likeEscape+"' OR labels "+like+" ? ESCAPE '"+likeEscape+"'"+
+			" OR labels "+like+" ? ESCAPE '"+likeEscape+"' OR labels "+like+" ? ESCAPE '"+likeEscape+"')",
+			"{"+fragment+"}", "{"+fragment+",%", "%,"+fragment+"}", "%,"+fragment+",%")
+	}
+
+	if query.Query != "" {
+		pattern := likePattern(query.Query)
+		// labels are matched in their JSON encoded form, the matches spanning
+		// several keys and values are dropped by keepSearchMatches
+		labelsPattern, err := jsonLikePattern(query.Query)
+		if err != nil {
+			return nil, err
+		}
+		sess.And("(name "+like+" ? ESCAPE '"+likeEscape+"' OR labels "+like+" ? ESCAPE '"+likeEscape+"' OR created_by IN ("+ss.creatorsSQL()+"))",
+			pattern, labelsPattern, query.OrgId, pattern, pattern, pattern)
+	}
+
+	if !accesscontrol.IsDisabled(ss.cfg) {
+		filter, err := readFilterBuilder.Build(query.User, accesscontrol.ActionAPIKeyRead)
+		if err != nil {
+			return nil, err
+		}
+		sess.And(filter.Where, filter.Args...)
+	}
+	return sess, nil
+}
+
+// likeEscape escapes the wildcards of LIKE patterns. A backslash would have to
+// be escaped itself in MySQL string literals.
+const likeEscape = "!"
+
+var likeEscaper = strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_")
+
+// likePattern returns a LIKE pattern matching the strings containing text.
+func likePattern(text string) string {
+	return "%" + likeEscaper.Replace(text) + "%"
+}
+
+// jsonLikePattern returns a LIKE pattern matching the JSON documents with a
+// string containing text.
+func jsonLikePattern(text string) (string, error) {
+	encoded, err := json.Marshal(text)
+	if err != nil {
+		return "", err
+	}
+	return likePattern(strings.TrimSuffix(strings.TrimPrefix(string(encoded), `"`), `"`)), nil
+}
+
+// creatorsSQL selects the members of an organization whose login, name or
+// email match a pattern. It takes the organization and the pattern three
+// times.
+func (ss *sqlStore) creatorsSQL() string {
+	like := ss.db.GetDialect().LikeStr()
+	return "SELECT u.id FROM " + ss.db.GetDialect().Quote("user") + " AS u" +
+		" INNER JOIN org_user AS ou ON ou.user_id = u.id" +
+		" WHERE ou.org_id = ? AND (u.login " + like + " ? ESCAPE '" + likeEscape + "'" +
+		" OR u.name " + like + " ? ESCAPE '" + likeEscape + "'" +
+		" OR u.email " + like + " ? ESCAPE '" + likeEscape + "')"
+}
+
+// keepSearchMatches drops the keys matching the free text query of the search
+// only through the JSON text of their labels, like "team":"billing" for the
+// query ":".
+func (ss *sqlStore) keepSearchMatches(sess *sqlstore.DBSession, query *apikey.GetApiKeysQuery, keys []*apikey.APIKey) ([]*apikey.APIKey, error) {
+	text := strings.ToLower(query.Query)
+	pattern := likePattern(query.Query)
+	args := []interface{}{query.OrgId, pattern, pattern, pattern}
+	for _, key := range keys {
+		if !strings.Contains(strings.ToLower(key.Name), text) && !labelsContain(key.Labels, text) {
+			args = append(args, key.CreatedBy)
+		}
+	}
+	if len(args) == 4 {
+		return keys, nil
+	}
+
+	var ids []int64
+	in := strings.Repeat(",?", len(args)-4)[1:]
+	if err := sess.SQL(ss.creatorsSQL()+" AND u.id IN ("+in+")", args...).Find(&ids); err != nil {
+		return nil, err
+	}
+	creators := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		creators[id] = true
+	}
+
+	matching := keys[:0]
+	for _, key := range keys {
+		if creators[key.CreatedBy] || strings.Contains(strings.ToLower(key.Name), text) || labelsContain(key.Labels, text) {
+			matching = append(matching, key)
+		}
+	}
+	return matching, nil
+}
+
+// labelsContain returns true if the name or the value of a label contains the
+// lower case text.
+func labelsContain(labels map[string]string, text string) bool {
+	for name, value := range labels {
+		if strings.Contains(strings.ToLower(name), text) || strings.Contains(strings.ToLower(value), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// labelFragment returns how a label appears in the JSON encoded labels column.
+func labelFragment(name, value string) (string, error) {
+	n, err := json.Marshal(name)
+	if err != nil {
+		return "", err
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(n) + ":" + string(v), nil
+}
+
 func (ss *sqlStore) GetAllAPIKeys(ctx context.Context, orgID int64) []*apikey.APIKey {
 	result := make([]*apikey.APIKey, 0)
-	err := ss.db.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+	err := ss.db.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
 		sess := dbSession.Where("service_account_id IS NULL").Asc("name")
 		if orgID != -1 {
 			sess = sess.Where("org_id=?", orgID)
@@ -78,7 +273,7 @@ func (ss *sqlStore) GetAllAPIKeys(ctx context.Context, orgID int64) []*apikey.AP
 }
 
 func (ss *sqlStore) DeleteApiKey(ctx context.Context, cmd *apikey.DeleteCommand) error {
-	return ss.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
 		rawSQL := "DELETE FROM api_key WHERE id=? and org_id=? and service_account_id IS NULL"
 		result, err := sess.Exec(rawSQL, cmd.Id, cmd.OrgId)
 		if err != nil {
@@ -90,19 +285,115 @@ func (ss *sqlStore) DeleteApiKey(ctx context.Context, cmd *apikey.DeleteCommand)
 		} else if n == 0 {
 			return apikey.ErrNotFound
 		}
+		if _, err := sess.Exec("DELETE FROM api_key_fingerprint WHERE api_key_id=?", cmd.Id); err != nil {
+			return err
+		}
+		sess.PublishAfterCommit(&events.ApiKeyDeleted{Timestamp: ss.clock.Now(), ID: cmd.Id, OrgID: cmd.OrgId, ActorID: cmd.ActorId})
 		return nil
 	})
 }
 
-func (ss *sqlStore) AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) error {
-	return ss.db.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
-		key := apikey.APIKey{OrgId: cmd.OrgId, Name: cmd.Name}
-		exists, _ := sess.Get(&key)
-		if exists {
-			return apikey.ErrDuplicate
+func (ss *sqlStore) DeleteByFilter(ctx context.Context, cmd *apikey.DeleteByFilterCommand) error {
+	filters := []string{}
+	args := []interface{}{cmd.OrgId}
+
+	if cmd.NamePrefix != "" {
+		filters = append(filters, "name LIKE ? ESCAPE '"+likeEscape+"'")
+		args = append(args, likeEscaper.Replace(cmd.NamePrefix)+"%")
+	}
+	if cmd.CreatedBy != 0 {
+		filters = append(filters, "created_by = ?")
+		args = append(args, cmd.CreatedBy)
+	}
+	if cmd.LastUsedBefore != nil {
+		// keys that have never been used are matched if they were created before the given date
+		filters = append(filters, "(last_used_at < ? OR (last_used_at IS NULL AND created < ?))")
+		args = append(args, *cmd.LastUsedBefore, *cmd.LastUsedBefore)
+	}
+	if len(filters) == 0 {
+		return apikey.ErrEmptyFilter
+	}
+
+	var deleted int64
+	err := ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		// the callback runs again when the transaction is retried
+		deleted = 0
+		where := "org_id=? AND service_account_id IS NULL AND " + strings.Join(filters, " AND ")
+		var ids []int64
+		if err := sess.Table("api_key").Where(where, args...).Cols("id").Find(&ids); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		// the ids are deleted in batches, databases limit the number of
+		// parameters of a statement
+		for start := 0; start < len(ids); start += deleteBatchSize {
+			end := start + deleteBatchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			batch := ids[start:end]
+			in := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+			deleteArgs := make([]interface{}, 0, len(batch)+1)
+			deleteArgs = append(deleteArgs, "DELETE FROM api_key WHERE id IN ("+in+")")
+			for _, id := range batch {
+				deleteArgs = append(deleteArgs, id)
+			}
+			result, err := sess.Exec(deleteArgs...)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			deleted += n
+
+			deleteArgs[0] = "DELETE FROM api_key_fingerprint WHERE api_key_id IN (" + in + ")"
+			if _, err := sess.Exec(deleteArgs...); err != nil {
+				return err
+			}
 		}
+		now := ss.clock.Now()
+		for _, id := range ids {
+			sess.PublishAfterCommit(&events.ApiKeyDeleted{Timestamp: now, ID: id, OrgID: cmd.OrgId, ActorID: cmd.ActorId})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cmd.Result = deleted
+	return nil
+}
 
-		updated := timeNow()
+func (ss *sqlStore) AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) error {
+	if err := apikey.ValidateIPRanges(cmd.AllowedIPRanges); err != nil {
+		return err
+	}
+	if err := apikey.ValidateDatasourceUIDs(cmd.DatasourceUIDs); err != nil {
+		return err
+	}
+	if err := apikey.ValidateAccessWindows(cmd.AccessWindows); err != nil {
+		return err
+	}
+	if err := apikey.ValidateLabels(cmd.Labels); err != nil {
+		return err
+	}
+	if cmd.RateLimitRPS < 0 {
+		return apikey.ErrInvalidRateLimit
+	}
+	keyType := cmd.Type
+	if keyType == "" {
+		keyType = apikey.TypeBearer
+	}
+	if keyType != apikey.TypeBearer && keyType != apikey.TypeSigning {
+		return apikey.ErrInvalidType
+	}
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		updated := ss.clock.Now()
 		var expires *int64 = nil
 		if cmd.SecondsToLive > 0 {
 			v := updated.Add(time.Second * time.Duration(cmd.SecondsToLive)).Unix()
@@ -111,6 +402,78 @@ func (ss *sqlStore) AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) error
 			return apikey.ErrInvalidExpiration
 		}
 
+		key := apikey.APIKey{OrgId: cmd.OrgId, Name: cmd.Name}
+		exists, err := sess.Get(&key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if !cmd.Upsert || key.ServiceAccountId != nil {
+				return apikey.ErrDuplicate
+			}
+			if key.IsSigning() != (keyType == apikey.TypeSigning) {
+				return fmt.Errorf("%w: the existing key cannot change type", apikey.ErrInvalidType)
+			}
+
+			// the secondary secret is kept
+			key.Fingerprinted = cmd.Fingerprint != "" && (key.Fingerprinted || key.SecondaryKey == nil)
+			key.Key = cmd.Key
+			key.Expires = expires
+			key.PreviousKey = nil
+			key.PreviousKeyExpires = nil
+			key.Updated = updated
+			cols := []string{"key", "expires", "previous_key", "previous_key_expires", "fingerprinted", "updated"}
+			if key.IsSigning() {
+				key.SigningSecret = cmd.SigningSecret
+				key.PreviousSigningSecret = nil
+				cols = append(cols, "signing_secret", "previous_signing_secret")
+			}
+			if cmd.Pending {
+				key.Status = apikey.StatusPending
+				cols = append(cols, "status")
+			}
+			if _, err := sess.ID(key.Id).Cols(cols...).Update(&key); err != nil {
+				return err
+			}
+			if err := addFingerprint(sess, key.Id, cmd.Fingerprint, updated); err != nil {
+				return err
+			}
+			sess.PublishAfterCommit(&events.ApiKeyRotated{Timestamp: updated, ID: key.Id, OrgID: key.OrgId, ActorID: cmd.CreatedBy})
+			cmd.Replaced = true
+			cmd.Result = &key
+			return nil
+		}
+
+		// the quota is checked in the transaction inserting the key so that
+		// concurrent requests cannot both take the last slot, replacing a key
+		// does not add an active key
+		if ss.cfg.ApiKeyMaxActivePerOrg > 0 {
+			count, err := countActiveKeys(sess, cmd.OrgId, updated)
+			if err != nil {
+				return err
+			}
+			if count >= ss.cfg.ApiKeyMaxActivePerOrg {
+				return apikey.ErrQuotaReached
+			}
+		}
+
+		status := apikey.StatusActive
+		if cmd.Pending {
+			status = apikey.StatusPending
+		}
+
+		var ownerTeamID *int64
+		if cmd.OwnerTeamId != 0 {
+			exists, err := sess.Table("team").Where("org_id = ? AND id = ?", cmd.OrgId, cmd.OwnerTeamId).Exist()
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return apikey.ErrTeamNotFound
+			}
+			ownerTeamID = &cmd.OwnerTeamId
+		}
+
 		t := apikey.APIKey{
 			OrgId:            cmd.OrgId,
 			Name:             cmd.Name,
@@ -120,18 +483,368 @@ func (ss *sqlStore) AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) error
 			Updated:          updated,
 			Expires:          expires,
 			ServiceAccountId: nil,
+			CreatedBy:        cmd.CreatedBy,
+			OwnerTeamId:      ownerTeamID,
+			AllowedIPRanges:  strings.Join(cmd.AllowedIPRanges, ","),
+			DatasourceUIDs:   strings.Join(cmd.DatasourceUIDs, ","),
+			AccessWindows:    cmd.AccessWindows,
+			Labels:           cmd.Labels,
+			RateLimitRPS:     cmd.RateLimitRPS,
+			Status:           status,
+			Type:             keyType,
+			SigningSecret:    cmd.SigningSecret,
+			Fingerprinted:    cmd.Fingerprint != "",
 		}
 
+		t.EncryptedMetadata = cmd.EncryptedMetadata
 		if _, err := sess.Insert(&t); err != nil {
 			return err
 		}
+		if err := addFingerprint(sess, t.Id, cmd.Fingerprint, updated); err != nil {
+			return err
+		}
+		sess.PublishAfterCommit(&events.ApiKeyCreated{Timestamp: updated, ID: t.Id, OrgID: t.OrgId, ActorID: cmd.CreatedBy})
 		cmd.Result = &t
 		return nil
 	})
 }
 
+// CountActiveKeys returns the number of API keys of the organization that
+// have not expired.
+func (ss *sqlStore) CountActiveKeys(ctx context.Context, orgID int64) (int64, error) {
+	var count int64
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		var err error
+		count, err = countActiveKeys(sess, orgID, ss.clock.Now())
+		return err
+	})
+	return count, err
+}
+
+func countActiveKeys(sess *sqlstore.DBSession, orgID int64, now time.Time) (int64, error) {
+	return sess.Table("api_key").
+		Where("org_id=? AND service_account_id IS NULL AND (expires IS NULL OR expires > ?)", orgID, now.Unix()).
+		Count()
+}
+
+// ImportAPIKeys inserts keys exported from another instance, keeping their
+// hashes and expirations. Keys whose name or hash already exists are skipped,
+// the number of inserted keys is returned. The quota of active keys does not
+// apply to imports.
+func (ss *sqlStore) ImportAPIKeys(ctx context.Context, keys []*apikey.APIKey) (int, error) {
+	imported := 0
+	err := ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		newKeys := make([]*apikey.APIKey, 0, len(keys))
+		hashes := make([]string, 0, len(keys))
+		byHash := make(map[string]*apikey.APIKey, len(keys))
+		// the keys of the import are checked against each other as well, since they are inserted together
+		seenNames := map[int64]map[string]bool{}
+		seenHashes := map[string]bool{}
+		for _, key := range keys {
+			if seenNames[key.OrgId][key.Name] || seenHashes[key.Key] {
+				continue
+			}
+			exists, err := sess.Table("api_key").
+				Where("(org_id=? AND name=?) OR "+ss.db.GetDialect().Quote("key")+"=?", key.OrgId, key.Name, key.Key).
+				Exist()
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+
+			if seenNames[key.OrgId] == nil {
+				seenNames[key.OrgId] = map[string]bool{}
+			}
+			seenNames[key.OrgId][key.Name] = true
+			seenHashes[key.Key] = true
+
+			key.Id = 0
+			key.Updated = ss.clock.Now()
+			// the fingerprints are not exported
+			key.Fingerprinted = false
+			newKeys = append(newKeys, key)
+			hashes = append(hashes, key.Key)
+			byHash[key.Key] = key
+		}
+
+		if _, err := sess.BulkInsert("api_key", newKeys); err != nil {
+			return err
+		}
+
+		// the bulk insert doesn't set the IDs of the keys, they are read back
+		for start := 0; start < len(hashes); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(hashes) {
+				end = len(hashes)
+			}
+			var inserted []*apikey.APIKey
+			if err := sess.Table("api_key").In("key", hashes[start:end]).Cols("id", "key").Find(&inserted); err != nil {
+				return err
+			}
+			for _, row := range inserted {
+				key := byHash[row.Key]
+				key.Id = row.Id
+				sess.PublishAfterCommit(&events.ApiKeyCreated{Timestamp: key.Updated, ID: key.Id, OrgID: key.OrgId})
+			}
+		}
+		imported = len(newKeys)
+		return nil
+	})
+	return imported, err
+}
+
+// gracePeriod returns the number of seconds the secret replaced by a rotation
+// or a promotion remains valid.
+func (ss *sqlStore) gracePeriod(requested *int64) (int64, error) {
+	gracePeriod := int64(ss.cfg.ApiKeyRotationGracePeriod.Seconds())
+	if requested != nil {
+		gracePeriod = *requested
+	}
+	if gracePeriod < 0 {
+		return 0, apikey.ErrInvalidGracePeriod
+	}
+	return gracePeriod, nil
+}
+
+// replaceKey makes hash the current hash of the key. The replaced hash stays
+// valid for gracePeriod seconds.
+func replaceKey(key *apikey.APIKey, hash string, gracePeriod int64, updated time.Time) {
+	key.PreviousKey = nil
+	key.PreviousKeyExpires = nil
+	if gracePeriod > 0 {
+		previousKey := key.Key
+		previousKeyExpires := updated.Add(time.Second * time.Duration(gracePeriod)).Unix()
+		key.PreviousKey = &previousKey
+		key.PreviousKeyExpires = &previousKeyExpires
+	}
+	key.Key = hash
+	key.Updated = updated
+}
+
+func getRotatableKey(sess *sqlstore.DBSession, id, orgID int64) (*apikey.APIKey, error) {
+	var key apikey.APIKey
+	has, err := sess.Where("id=? AND org_id=? AND service_account_id IS NULL", id, orgID).Get(&key)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, apikey.ErrNotFound
+	}
+	return &key, nil
+}
+
+// RotateAPIKey replaces the secret of a key. The new secret is stored as the
+// secondary secret when cmd.Secondary is set, otherwise it replaces the
+// current one and any secondary secret is revoked.
+func (ss *sqlStore) RotateAPIKey(ctx context.Context, cmd *apikey.RotateCommand) error {
+	gracePeriod, err := ss.gracePeriod(cmd.GracePeriodSeconds)
+	if err != nil {
+		return err
+	}
+
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		key, err := getRotatableKey(sess, cmd.Id, cmd.OrgId)
+		if err != nil {
+			return err
+		}
+
+		updated := ss.clock.Now()
+		if cmd.Secondary {
+			if key.IsSigning() {
+				return apikey.ErrSecondarySigning
+			}
+			secondaryKey := cmd.Key
+			key.SecondaryKey = &secondaryKey
+			key.Fingerprinted = key.Fingerprinted && cmd.Fingerprint != ""
+			key.Updated = updated
+			cols := []string{"secondary_key", "fingerprinted", "updated"}
+			if cmd.Pending {
+				key.Status = apikey.StatusPending
+				cols = append(cols, "status")
+			}
+			if _, err := sess.ID(key.Id).Cols(cols...).Update(key); err != nil {
+				return err
+			}
+			if err := addFingerprint(sess, key.Id, cmd.Fingerprint, updated); err != nil {
+				return err
+			}
+			cmd.Result = key
+			return nil
+		}
+
+		// the replaced secret stays valid during the grace period
+		key.Fingerprinted = cmd.Fingerprint != "" && (key.Fingerprinted || gracePeriod == 0)
+		replaceKey(key, cmd.Key, gracePeriod, updated)
+		key.SecondaryKey = nil
+		cols := []string{"key", "previous_key", "previous_key_expires", "secondary_key", "fingerprinted", "updated"}
+		if key.IsSigning() {
+			// the previous secret verifies signatures for the grace period
+			key.PreviousSigningSecret = nil
+			if key.PreviousKey != nil {
+				previousSecret := key.SigningSecret
+				key.PreviousSigningSecret = &previousSecret
+			}
+			key.SigningSecret = cmd.SigningSecret
+			cols = append(cols, "signing_secret", "previous_signing_secret")
+		}
+		if cmd.Pending {
+			key.Status = apikey.StatusPending
+			cols = append(cols, "status")
+		}
+
+		if _, err := sess.ID(key.Id).Cols(cols...).Update(key); err != nil {
+			return err
+		}
+		if err := addFingerprint(sess, key.Id, cmd.Fingerprint, updated); err != nil {
+			return err
+		}
+		sess.PublishAfterCommit(&events.ApiKeyRotated{Timestamp: updated, ID: key.Id, OrgID: key.OrgId, ActorID: cmd.ActorId})
+		cmd.Result = key
+		return nil
+	})
+}
+
+// PromoteSecondaryAPIKey makes the secondary secret of a key its current
+// secret. The replaced secret stays valid for the grace period, like after a
+// rotation.
+func (ss *sqlStore) PromoteSecondaryAPIKey(ctx context.Context, cmd *apikey.PromoteSecondaryCommand) error {
+	gracePeriod, err := ss.gracePeriod(cmd.GracePeriodSeconds)
+	if err != nil {
+		return err
+	}
+
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		key, err := getRotatableKey(sess, cmd.Id, cmd.OrgId)
+		if err != nil {
+			return err
+		}
+		if key.SecondaryKey == nil {
+			return apikey.ErrNoSecondary
+		}
+
+		updated := ss.clock.Now()
+		replaceKey(key, *key.SecondaryKey, gracePeriod, updated)
+		key.SecondaryKey = nil
+		cols := []string{"key", "previous_key", "previous_key_expires", "secondary_key", "updated"}
+		if _, err := sess.ID(key.Id).Cols(cols...).Update(key); err != nil {
+			return err
+		}
+		sess.PublishAfterCommit(&events.ApiKeyRotated{Timestamp: updated, ID: key.Id, OrgID: key.OrgId, ActorID: cmd.ActorId})
+		cmd.Result = key
+		return nil
+	})
+}
+
+// RevokeSecondaryAPIKey invalidates the secondary secret of a key right away.
+func (ss *sqlStore) RevokeSecondaryAPIKey(ctx context.Context, cmd *apikey.RevokeSecondaryCommand) error {
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		key, err := getRotatableKey(sess, cmd.Id, cmd.OrgId)
+		if err != nil {
+			return err
+		}
+		if key.SecondaryKey == nil {
+			return apikey.ErrNoSecondary
+		}
+
+		key.SecondaryKey = nil
+		key.Updated = ss.clock.Now()
+		_, err = sess.ID(key.Id).Cols("secondary_key", "updated").Update(key)
+		return err
+	})
+}
+
+func (ss *sqlStore) UpdateAPIKey(ctx context.Context, cmd *apikey.UpdateCommand) error {
+	if err := apikey.ValidateIPRanges(cmd.AllowedIPRanges); err != nil {
+		return err
+	}
+	if err := apikey.ValidateDatasourceUIDs(cmd.DatasourceUIDs); err != nil {
+		return err
+	}
+	if err := apikey.ValidateAccessWindows(cmd.AccessWindows); err != nil {
+		return err
+	}
+	if cmd.RateLimitRPS != nil && *cmd.RateLimitRPS < 0 {
+		return apikey.ErrInvalidRateLimit
+	}
+
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		var key apikey.APIKey
+		has, err := sess.Where("id=? AND org_id=? AND service_account_id IS NULL", cmd.Id, cmd.OrgId).Get(&key)
+		if err != nil {
+			return err
+		} else if !has {
+			return apikey.ErrNotFound
+		}
+
+		cols := []string{"updated"}
+		if cmd.AllowedIPRanges != nil {
+			key.AllowedIPRanges = strings.Join(cmd.AllowedIPRanges, ",")
+			cols = append(cols, "allowed_ip_ranges")
+		}
+		if cmd.DatasourceUIDs != nil {
+			key.DatasourceUIDs = strings.Join(cmd.DatasourceUIDs, ",")
+			cols = append(cols, "datasource_uids")
+		}
+		if cmd.AccessWindows != nil {
+			key.AccessWindows = cmd.AccessWindows
+			cols = append(cols, "access_windows")
+		}
+		if cmd.EncryptedMetadata != nil {
+			key.EncryptedMetadata = *cmd.EncryptedMetadata
+			cols = append(cols, "encrypted_metadata")
+		}
+		if cmd.RateLimitRPS != nil {
+			key.RateLimitRPS = *cmd.RateLimitRPS
+			cols = append(cols, "rate_limit_rps")
+		}
+		key.Updated = ss.clock.Now()
+
+		_, err = sess.ID(key.Id).Cols(cols...).Update(&key)
+		return err
+	})
+}
+
+func (ss *sqlStore) ApproveAPIKey(ctx context.Context, cmd *apikey.ApproveCommand) error {
+	return ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		rawSQL := "UPDATE api_key SET status=?, updated=? WHERE id=? AND org_id=? AND status=? AND service_account_id IS NULL"
+		result, err := sess.Exec(rawSQL, apikey.StatusActive, ss.clock.Now(), cmd.Id, cmd.OrgId, apikey.StatusPending)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		} else if n == 0 {
+			return apikey.ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (ss *sqlStore) RejectAPIKey(ctx context.Context, cmd *apikey.RejectCommand) error {
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		rawSQL := "DELETE FROM api_key WHERE id=? AND org_id=? AND status=? AND service_account_id IS NULL"
+		result, err := sess.Exec(rawSQL, cmd.Id, cmd.OrgId, apikey.StatusPending)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		} else if n == 0 {
+			return apikey.ErrNotFound
+		}
+		if _, err := sess.Exec("DELETE FROM api_key_fingerprint WHERE api_key_id=?", cmd.Id); err != nil {
+			return err
+		}
+		sess.PublishAfterCommit(&events.ApiKeyDeleted{Timestamp: ss.clock.Now(), ID: cmd.Id, OrgID: cmd.OrgId, ActorID: cmd.ActorId})
+		return nil
+	})
+}
+
 func (ss *sqlStore) GetApiKeyById(ctx context.Context, query *apikey.GetByIDQuery) error {
-	return ss.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+	return ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
 		var key apikey.APIKey
 		has, err := sess.ID(query.ApiKeyId).Get(&key)
 
@@ -147,7 +860,7 @@ func (ss *sqlStore) GetApiKeyById(ctx context.Context, query *apikey.GetByIDQuer
 }
 
 func (ss *sqlStore) GetApiKeyByName(ctx context.Context, query *apikey.GetByNameQuery) error {
-	return ss.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+	return ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
 		var key apikey.APIKey
 		has, err := sess.Where("org_id=? AND name=?", query.OrgId, query.KeyName).Get(&key)
 
@@ -162,9 +875,12 @@ func (ss *sqlStore) GetApiKeyByName(ctx context.Context, query *apikey.GetByName
 	})
 }
 
-func (ss *sqlStore) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
+// GetAPIKeyByHash returns the key whose current secret has the hash, and
+// whether it should be rehashed with the configured algorithm. Service account
+// tokens are looked up by their hash, they are never rehashed.
+func (ss *sqlStore) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, bool, error) {
 	var key apikey.APIKey
-	err := ss.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
 		has, err := sess.Table("api_key").Where(fmt.Sprintf("%s = ?", ss.db.GetDialect().Quote("key")), hash).Get(&key)
 		if err != nil {
 			return err
@@ -173,16 +889,457 @@ func (ss *sqlStore) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.A
 		}
 		return nil
 	})
-	return &key, err
+	if err != nil {
+		return &key, false, err
+	}
+
+	hasher, _ := newHashers(ss.cfg)
+	return &key, key.ServiceAccountId == nil && !hasher.Recognizes(key.Key), nil
+}
+
+// GetAPIKeyIDsByCreator returns the ids of the API keys of the organization created by the user, service account
+// tokens excluded.
+func (ss *sqlStore) GetAPIKeyIDsByCreator(ctx context.Context, orgID int64, userID int64) ([]int64, error) {
+	ids := make([]int64, 0)
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		return sess.Table("api_key").Cols("id").
+			Where("org_id = ? AND created_by = ? AND service_account_id IS NULL", orgID, userID).
+			Find(&ids)
+	})
+	return ids, err
+}
+
+// GetAPIKeyIDsByTeam returns the ids of the API keys of the organization owned by the team.
+func (ss *sqlStore) GetAPIKeyIDsByTeam(ctx context.Context, orgID int64, teamID int64) ([]int64, error) {
+	ids := make([]int64, 0)
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		return sess.Table("api_key").Cols("id").
+			Where("org_id = ? AND owner_team_id = ? AND service_account_id IS NULL", orgID, teamID).
+			Find(&ids)
+	})
+	return ids, err
+}
+
+// UpdateAPIKeyHash replaces the hash of a key, unless it has been changed in
+// the meantime, for example by a rotation.
+func (ss *sqlStore) UpdateAPIKeyHash(ctx context.Context, id int64, oldHash, newHash string) error {
+	keyCol := ss.db.GetDialect().Quote("key")
+	return ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		rawSQL := "UPDATE api_key SET " + keyCol + "=? WHERE id=? AND " + keyCol + "=?"
+		_, err := sess.Exec(rawSQL, newHash, id, oldHash)
+		return err
+	})
+}
+
+// GetKeysWithMisplacedMetadata returns the service account tokens whose
+// labels or access windows are stored in plaintext, and the API keys missing
+// either the plaintext or the encrypted copy of their metadata.
+func (ss *sqlStore) GetKeysWithMisplacedMetadata(ctx context.Context) ([]*apikey.APIKey, error) {
+	plaintext := "((labels IS NOT NULL AND labels NOT IN ('', 'null', '{}')) OR " +
+		"(access_windows IS NOT NULL AND access_windows NOT IN ('', 'null', '[]')))"
+	encrypted := "(encrypted_metadata IS NOT NULL AND encrypted_metadata <> '')"
+	keys := make([]*apikey.APIKey, 0)
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		return sess.Where("(service_account_id IS NOT NULL AND " + plaintext + ") OR " +
+			"(service_account_id IS NULL AND " + encrypted + " AND NOT " + plaintext + ") OR " +
+			"(service_account_id IS NULL AND " + plaintext + " AND NOT " + encrypted + ")").
+			Asc("id").Find(&keys)
+	})
+	return keys, err
+}
+
+// UpdateAPIKeyMetadata writes the labels, the access windows and the
+// encrypted metadata of a key.
+func (ss *sqlStore) UpdateAPIKeyMetadata(ctx context.Context, key *apikey.APIKey) error {
+	return ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		_, err := sess.Table("api_key").ID(key.Id).Cols("labels", "access_windows", "encrypted_metadata").Update(key)
+		return err
+	})
+}
+
+func (ss *sqlStore) UpdateAPIKeysLastUsed(ctx context.Context, cmds []*apikey.UpdateLastUsedCommand) error {
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		for _, cmd := range cmds {
+			userAgent := cmd.UserAgent
+			if len(userAgent) > maxUserAgentLength {
+				userAgent = userAgent[:maxUserAgentLength]
+			}
+
+			key := &apikey.APIKey{LastUsedAt: &cmd.Time, LastUsedIP: cmd.IP, LastUsedUserAgent: userAgent}
+			if _, err := sess.Table("api_key").ID(cmd.Id).Cols("last_used_at", "last_used_ip", "last_used_user_agent").Update(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetKeysExpiringBefore returns the API keys which are not expired yet but
+// will be at the given unix timestamp.
+func (ss *sqlStore) GetKeysExpiringBefore(ctx context.Context, before int64) ([]*apikey.APIKey, error) {
+	result := make([]*apikey.APIKey, 0)
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		return sess.Where("service_account_id IS NULL AND expires IS NOT NULL AND expires > ? AND expires <= ?", ss.clock.Now().Unix(), before).
+			Asc("expires").
+			Find(&result)
+	})
+	return result, err
 }
 
-func (ss *sqlStore) UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64) error {
-	now := timeNow()
-	return ss.db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
-		if _, err := sess.Table("api_key").ID(tokenID).Cols("last_used_at").Update(&apikey.APIKey{LastUsedAt: &now}); err != nil {
+// GetExpirations returns the expiration of every API key, nil for keys that
+// never expire.
+func (ss *sqlStore) GetExpirations(ctx context.Context) ([]*int64, error) {
+	var rows []struct {
+		Expires *int64
+	}
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		return sess.Table("api_key").Cols("expires").Where("service_account_id IS NULL").Find(&rows)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	expirations := make([]*int64, 0, len(rows))
+	for _, row := range rows {
+		expirations = append(expirations, row.Expires)
+	}
+	return expirations, nil
+}
+
+// GetKeysExceedingLifetime returns the API keys which never expire or expire
+// more than maxSecondsToLive seconds after their creation.
+func (ss *sqlStore) GetKeysExceedingLifetime(ctx context.Context, maxSecondsToLive int64) ([]*apikey.APIKey, error) {
+	keys := make([]*apikey.APIKey, 0)
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		return sess.Where("service_account_id IS NULL").Asc("id").Find(&keys)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// created is a datetime and expires a unix timestamp, comparing them in
+	// SQL would depend on the database.
+	result := make([]*apikey.APIKey, 0)
+	for _, key := range keys {
+		if key.Expires == nil || *key.Expires > key.Created.Unix()+maxSecondsToLive {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
+
+// ShortenAPIKeyExpiration sets the expiration of a key, unless it already
+// expires earlier. It returns whether the key has been updated.
+func (ss *sqlStore) ShortenAPIKeyExpiration(ctx context.Context, id int64, expires int64) (bool, error) {
+	var updated bool
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		rawSQL := "UPDATE api_key SET expires=?, updated=? WHERE id=? AND (expires IS NULL OR expires > ?)"
+		result, err := sess.Exec(rawSQL, expires, ss.clock.Now(), id, expires)
+		if err != nil {
 			return err
 		}
+		n, err := result.RowsAffected()
+		updated = n > 0
+		return err
+	})
+	return updated, err
+}
+
+// GetIdleAPIKeys returns the active API keys which are not expired and were
+// last used before since, or never used and created before since.
+func (ss *sqlStore) GetIdleAPIKeys(ctx context.Context, since time.Time) ([]*apikey.APIKey, error) {
+	result := make([]*apikey.APIKey, 0)
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		return sess.Where("service_account_id IS NULL AND status <> ?", apikey.StatusPending).
+			And("(expires IS NULL OR expires > ?)", ss.clock.Now().Unix()).
+			And("(last_used_at < ? OR (last_used_at IS NULL AND created < ?))", since, since).
+			Asc("id").
+			Find(&result)
+	})
+	return result, err
+}
+
+func (ss *sqlStore) InsertAPIKeyUsage(ctx context.Context, rows []*usageRow) error {
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		for _, row := range rows {
+			if _, err := sess.Table("api_key_usage").Insert(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetAPIKeyUsageStats aggregates the usages of a key. Usages older than 24
+// hours are stored per day, the 7 and 30 days windows start at the beginning
+// of the first complete day they contain.
+func (ss *sqlStore) GetAPIKeyUsageStats(ctx context.Context, id int64, now time.Time) (*apikey.UsageStats, error) {
+	stats := &apikey.UsageStats{TopRoutes: make([]apikey.RouteUsage, 0)}
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		windows := []struct {
+			since  time.Duration
+			result *int64
+		}{
+			{24 * time.Hour, &stats.Requests24h},
+			{7 * 24 * time.Hour, &stats.Requests7d},
+			{usageRetention, &stats.Requests30d},
+		}
+		for _, w := range windows {
+			total, err := sess.Table("api_key_usage").
+				Where("api_key_id=? AND period_start>=?", id, now.Add(-w.since).Unix()).
+				SumInt(&usageRow{}, "requests")
+			if err != nil {
+				return err
+			}
+			*w.result = total
+		}
+
+		rawSQL := "SELECT route, SUM(requests) AS requests FROM api_key_usage WHERE api_key_id=? AND period_start>=? " +
+			"GROUP BY route ORDER BY requests DESC, route ASC " + ss.db.GetDialect().Limit(usageTopRoutes)
+		return sess.SQL(rawSQL, id, now.Add(-usageRetention).Unix()).Find(&stats.TopRoutes)
+	})
+	return stats, err
+}
+
+// GetAPIKeySummary counts the API keys of an organization by status with a
+// single query.
+func (ss *sqlStore) GetAPIKeySummary(ctx context.Context, orgID int64, now time.Time, expiringWindow time.Duration) (*apikey.Summary, error) {
+	summary := &apikey.Summary{}
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		notExpired := "(expires IS NULL OR expires >= ?)"
+		active := notExpired + " AND status <> ?"
+		rawSQL := "SELECT COUNT(*) AS total, " +
+			"COALESCE(SUM(CASE WHEN " + active + " THEN 1 ELSE 0 END), 0) AS active, " +
+			"COALESCE(SUM(CASE WHEN " + notExpired + " AND status = ? THEN 1 ELSE 0 END), 0) AS pending, " +
+			"COALESCE(SUM(CASE WHEN expires < ? THEN 1 ELSE 0 END), 0) AS expired, " +
+			"COALESCE(SUM(CASE WHEN " + active + " AND expires < ? THEN 1 ELSE 0 END), 0) AS expiring_soon, " +
+			"COALESCE(SUM(CASE WHEN " + active + " AND last_used_at IS NULL THEN 1 ELSE 0 END), 0) AS never_used " +
+			"FROM api_key WHERE org_id = ? AND service_account_id IS NULL"
+		nowUnix := now.Unix()
+		_, err := sess.SQL(rawSQL,
+			nowUnix, apikey.StatusPending,
+			nowUnix, apikey.StatusPending,
+			nowUnix,
+			nowUnix, apikey.StatusPending, now.Add(expiringWindow).Unix(),
+			nowUnix, apikey.StatusPending,
+			orgID).Get(summary)
+		return err
+	})
+	return summary, err
+}
+
+// RollupAPIKeyUsage deletes the usages older than the retention or of deleted
+// keys, and merges the rows of the same completed hour, or of the same day
+// for usages older than 24 hours.
+func (ss *sqlStore) RollupAPIKeyUsage(ctx context.Context, now time.Time) error {
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		if _, err := sess.Exec("DELETE FROM api_key_usage WHERE period_start < ?", now.Add(-usageRetention).Unix()); err != nil {
+			return err
+		}
+		if _, err := sess.Exec("DELETE FROM api_key_usage WHERE api_key_id NOT IN (SELECT id FROM api_key)"); err != nil {
+			return err
+		}
+
+		var rows []*usageRow
+		currentHour := now.Unix() - now.Unix()%hourSeconds
+		if err := sess.Table("api_key_usage").Where("period_seconds=? AND period_start<?", hourSeconds, currentHour).Find(&rows); err != nil {
+			return err
+		}
+
+		type period struct {
+			usageKey
+			seconds int64
+		}
+		dayBefore := now.Add(-24 * time.Hour).Unix()
+		groups := make(map[period][]*usageRow)
+		for _, row := range rows {
+			p := period{usageKey{row.ApiKeyId, row.OrgId, row.Route, row.PeriodStart}, hourSeconds}
+			if row.PeriodStart < dayBefore {
+				p.periodStart -= p.periodStart % daySeconds
+				p.seconds = daySeconds
+			}
+			groups[p] = append(groups[p], row)
+		}
+
+		for p, group := range groups {
+			if len(group) == 1 && p.seconds == hourSeconds {
+				continue
+			}
 
+			merged := &usageRow{ApiKeyId: p.apiKeyID, OrgId: p.orgID, Route: p.route, PeriodStart: p.periodStart, PeriodSeconds: p.seconds}
+			ids := make([]int64, 0, len(group))
+			for _, row := range group {
+				merged.Requests += row.Requests
+				ids = append(ids, row.Id)
+			}
+			if _, err := sess.Table("api_key_usage").In("id", ids).Delete(&usageRow{}); err != nil {
+				return err
+			}
+			if _, err := sess.Table("api_key_usage").Insert(merged); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PublishExpiredAPIKeys publishes an ApiKeyExpired event for every API key
+// that expired after from and until to.
+func (ss *sqlStore) PublishExpiredAPIKeys(ctx context.Context, from, to time.Time) (int, error) {
+	var keys []*apikey.APIKey
+	err := ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		if err := sess.Where("service_account_id IS NULL AND expires > ? AND expires <= ?", from.Unix(), to.Unix()).
+			Cols("id", "org_id", "expires").Find(&keys); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			sess.PublishAfterCommit(&events.ApiKeyExpired{Timestamp: time.Unix(*key.Expires, 0), ID: key.Id, OrgID: key.OrgId})
+		}
+		return nil
+	})
+	return len(keys), err
+}
+
+// GetLeak returns the leak reported with the hash, nil if no leak was.
+func (ss *sqlStore) GetLeak(ctx context.Context, hash string) (*apikey.Leak, error) {
+	var leak apikey.Leak
+	var has bool
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		var err error
+		has, err = sess.Where("hash=?", hash).Get(&leak)
+		return err
+	})
+	if err != nil || !has {
+		return nil, err
+	}
+	return &leak, nil
+}
+
+// BackfillFingerprint records the fingerprint of a secret of a key that is not
+// fingerprinted. The key is marked fingerprinted when the secret is the only
+// one it can be used with, unless it was rotated since it was read.
+func (ss *sqlStore) BackfillFingerprint(ctx context.Context, key *apikey.APIKey, hash string) error {
+	keyCol := ss.db.GetDialect().Quote("key")
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		now := ss.clock.Now()
+		exists, err := sess.Where("hash=?", hash).Exist(&apikey.Fingerprint{})
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err := addFingerprint(sess, key.Id, hash, now); err != nil {
+				return err
+			}
+		}
+
+		if key.SecondaryKey != nil || (key.PreviousKeyExpires != nil && *key.PreviousKeyExpires > now.Unix()) {
+			return nil
+		}
+		rawSQL := "UPDATE api_key SET fingerprinted=? WHERE id=? AND " + keyCol + "=? AND secondary_key IS NULL"
+		_, err = sess.Exec(rawSQL, true, key.Id, key.Key)
+		return err
+	})
+}
+
+// DeleteOrphanFingerprints deletes the fingerprints of deleted keys, which
+// are left behind by the deletions not going through the store, and returns
+// their number.
+func (ss *sqlStore) DeleteOrphanFingerprints(ctx context.Context) (int64, error) {
+	var deleted int64
+	err := ss.db.WithDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		result, err := sess.Exec("DELETE FROM api_key_fingerprint WHERE api_key_id NOT IN (SELECT id FROM api_key)")
+		if err != nil {
+			return err
+		}
+		deleted, err = result.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
+
+// addFingerprint records the fingerprint of a new secret of the key, it does
+// nothing when the fingerprint is empty.
+func addFingerprint(sess *sqlstore.DBSession, keyID int64, hash string, created time.Time) error {
+	if hash == "" {
+		return nil
+	}
+	_, err := sess.Insert(&apikey.Fingerprint{ApiKeyId: keyID, Hash: hash, Created: created})
+	return err
+}
+
+// getKeyByFingerprint returns the key with a secret matching the hash, nil if
+// no key does.
+func getKeyByFingerprint(sess *sqlstore.DBSession, hash string) (*apikey.APIKey, error) {
+	var fingerprint apikey.Fingerprint
+	has, err := sess.Where("hash=?", hash).Get(&fingerprint)
+	if err != nil || !has {
+		return nil, err
+	}
+
+	var key apikey.APIKey
+	has, err = sess.ID(fingerprint.ApiKeyId).Get(&key)
+	if err != nil || !has {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RecordLeak records a leak, unless it was already reported, and revokes the
+// leaked key by expiring it. The key is looked up by its fingerprints when
+// it is not given.
+func (ss *sqlStore) RecordLeak(ctx context.Context, leak *apikey.Leak, key *apikey.APIKey) error {
+	return ss.db.WithTransactionalDbSession(dbContext(ctx), func(sess *sqlstore.DBSession) error {
+		var existing apikey.Leak
+		has, err := sess.Where("hash=?", leak.Hash).Get(&existing)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			if key, err = getKeyByFingerprint(sess, leak.Hash); err != nil {
+				return err
+			}
+		}
+		if key != nil {
+			leak.ApiKeyId = &key.Id
+		}
+		if !has {
+			if _, err := sess.Insert(leak); err != nil {
+				return err
+			}
+		} else {
+			*leak = existing
+			if key == nil || existing.ApiKeyId != nil {
+				return nil
+			}
+			leak.ApiKeyId = &key.Id
+			if _, err := sess.ID(leak.Id).Cols("api_key_id").Update(leak); err != nil {
+				return err
+			}
+		}
+		if key == nil {
+			return nil
+		}
+
+		now := ss.clock.Now()
+		result, err := sess.Exec("UPDATE api_key SET expires=?, updated=? WHERE id=? AND (expires IS NULL OR expires > ?)",
+			now.Unix(), now, key.Id, now.Unix())
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n > 0 {
+			sess.PublishAfterCommit(&events.ApiKeyLeaked{
+				Timestamp: now,
+				ID:        key.Id,
+				OrgID:     key.OrgId,
+				Name:      key.Name,
+				CreatedBy: key.CreatedBy,
+				Source:    leak.Source,
+				URL:       leak.Url,
+			})
+		}
 		return nil
 	})
 }
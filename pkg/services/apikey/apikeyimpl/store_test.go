@@ -3,42 +3,46 @@ package apikeyimpl
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/org"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/grafana/grafana/pkg/services/user"
 )
 
-func mockTimeNow() {
-	var timeSeed int64
-	timeNow = func() time.Time {
-		loc := time.FixedZone("MockZoneUTC-5", -5*60*60)
-		fakeNow := time.Unix(timeSeed, 0).In(loc)
-		timeSeed++
-		return fakeNow
-	}
+// tickingClock is a mock clock that advances by a second each time it is read
+type tickingClock struct {
+	*clock.Mock
+	loc *time.Location
+}
+
+func newTickingClock() *tickingClock {
+	return &tickingClock{Mock: clock.NewMock(), loc: time.FixedZone("MockZoneUTC-5", -5*60*60)}
 }
 
-func resetTimeNow() {
-	timeNow = time.Now
+func (c *tickingClock) Now() time.Time {
+	now := c.Mock.Now().In(c.loc)
+	c.Mock.Add(time.Second)
+	return now
 }
 
 func TestIntegrationApiKeyDataAccess(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
 	}
-	mockTimeNow()
-	defer resetTimeNow()
+	clk := newTickingClock()
 
 	t.Run("Testing API Key data access", func(t *testing.T) {
 		db := sqlstore.InitTestDB(t)
-		ss := &sqlStore{db: db, cfg: db.Cfg}
+		ss := &sqlStore{db: db, cfg: db.Cfg, clock: clk}
 
 		t.Run("Given saved api key", func(t *testing.T) {
 			cmd := apikey.AddCommand{OrgId: 1, Name: "hello", Key: "asd"}
@@ -54,7 +58,7 @@ func TestIntegrationApiKeyDataAccess(t *testing.T) {
 			})
 
 			t.Run("Should be able to get key by hash", func(t *testing.T) {
-				key, err := ss.GetAPIKeyByHash(context.Background(), cmd.Key)
+				key, _, err := ss.GetAPIKeyByHash(context.Background(), cmd.Key)
 
 				assert.Nil(t, err)
 				assert.NotNil(t, key)
@@ -83,12 +87,12 @@ func TestIntegrationApiKeyDataAccess(t *testing.T) {
 			err = ss.GetApiKeyByName(context.Background(), &query)
 			assert.Nil(t, err)
 
-			assert.True(t, *query.Result.Expires >= timeNow().Unix())
+			assert.True(t, *query.Result.Expires >= clk.Now().Unix())
 
-			// timeNow() has been called twice since creation; once by AddAPIKey and once by GetApiKeyByName
-			// therefore two seconds should be subtracted by next value returned by timeNow()
-			// that equals the number by which timeSeed has been advanced
-			then := timeNow().Add(-2 * time.Second)
+			// the clock has been read twice since creation; once by AddAPIKey and once by GetApiKeyByName
+			// therefore two seconds should be subtracted by next value returned by the clock
+			// that equals the number of seconds by which the clock has been advanced
+			then := clk.Now().Add(-2 * time.Second)
 			expected := then.Add(1 * time.Hour).UTC().Unix()
 			assert.Equal(t, *query.Result.Expires, expected)
 		})
@@ -101,7 +105,9 @@ func TestIntegrationApiKeyDataAccess(t *testing.T) {
 
 			assert.Nil(t, cmd.Result.LastUsedAt)
 
-			err = ss.UpdateAPIKeyLastUsedDate(context.Background(), cmd.Result.Id)
+			err = ss.UpdateAPIKeysLastUsed(context.Background(), []*apikey.UpdateLastUsedCommand{
+				{Id: cmd.Result.Id, IP: "10.0.0.1", UserAgent: "curl/7.79.1", Time: clk.Now()},
+			})
 			require.NoError(t, err)
 
 			query := apikey.GetByNameQuery{KeyName: "last-update-at", OrgId: 1}
@@ -109,6 +115,269 @@ func TestIntegrationApiKeyDataAccess(t *testing.T) {
 			assert.Nil(t, err)
 
 			assert.NotNil(t, query.Result.LastUsedAt)
+			assert.Equal(t, "10.0.0.1", query.Result.LastUsedIP)
+			assert.Equal(t, "curl/7.79.1", query.Result.LastUsedUserAgent)
+		})
+
+		t.Run("Rotate key", func(t *testing.T) {
+			cmd := apikey.AddCommand{OrgId: 1, Name: "rotate-me", Key: "rotate-old"}
+			err := ss.AddAPIKey(context.Background(), &cmd)
+			require.NoError(t, err)
+
+			gracePeriod := int64(3600)
+			rotateCmd := apikey.RotateCommand{Id: cmd.Result.Id, OrgId: 1, Key: "rotate-new", GracePeriodSeconds: &gracePeriod}
+			err = ss.RotateAPIKey(context.Background(), &rotateCmd)
+			require.NoError(t, err)
+
+			query := apikey.GetByNameQuery{KeyName: "rotate-me", OrgId: 1}
+			err = ss.GetApiKeyByName(context.Background(), &query)
+			require.NoError(t, err)
+			assert.Equal(t, "rotate-new", query.Result.Key)
+			require.NotNil(t, query.Result.PreviousKey)
+			assert.Equal(t, "rotate-old", *query.Result.PreviousKey)
+			require.NotNil(t, query.Result.PreviousKeyExpires)
+			assert.True(t, *query.Result.PreviousKeyExpires > clk.Now().Unix())
+
+			t.Run("without grace period the previous key is discarded", func(t *testing.T) {
+				noGracePeriod := int64(0)
+				rotateCmd := apikey.RotateCommand{Id: cmd.Result.Id, OrgId: 1, Key: "rotate-newer", GracePeriodSeconds: &noGracePeriod}
+				err = ss.RotateAPIKey(context.Background(), &rotateCmd)
+				require.NoError(t, err)
+
+				err = ss.GetApiKeyByName(context.Background(), &query)
+				require.NoError(t, err)
+				assert.Equal(t, "rotate-newer", query.Result.Key)
+				assert.Nil(t, query.Result.PreviousKey)
+				assert.Nil(t, query.Result.PreviousKeyExpires)
+			})
+
+			t.Run("key from another org is not found", func(t *testing.T) {
+				rotateCmd := apikey.RotateCommand{Id: cmd.Result.Id, OrgId: 2, Key: "rotate-other"}
+				err = ss.RotateAPIKey(context.Background(), &rotateCmd)
+				assert.ErrorIs(t, err, apikey.ErrNotFound)
+			})
+
+			t.Run("negative grace period is rejected", func(t *testing.T) {
+				negativeGracePeriod := int64(-1)
+				rotateCmd := apikey.RotateCommand{Id: cmd.Result.Id, OrgId: 1, Key: "rotate-negative", GracePeriodSeconds: &negativeGracePeriod}
+				err = ss.RotateAPIKey(context.Background(), &rotateCmd)
+				assert.ErrorIs(t, err, apikey.ErrInvalidGracePeriod)
+			})
+		})
+
+		t.Run("Rotate key with a secondary secret", func(t *testing.T) {
+			cmd := apikey.AddCommand{OrgId: 1, Name: "dual-secret", Key: "dual-current"}
+			err := ss.AddAPIKey(context.Background(), &cmd)
+			require.NoError(t, err)
+
+			rotateCmd := apikey.RotateCommand{Id: cmd.Result.Id, OrgId: 1, Key: "dual-next", Secondary: true}
+			err = ss.RotateAPIKey(context.Background(), &rotateCmd)
+			require.NoError(t, err)
+
+			query := apikey.GetByNameQuery{KeyName: "dual-secret", OrgId: 1}
+			err = ss.GetApiKeyByName(context.Background(), &query)
+			require.NoError(t, err)
+			assert.Equal(t, "dual-current", query.Result.Key)
+			require.NotNil(t, query.Result.SecondaryKey)
+			assert.Equal(t, "dual-next", *query.Result.SecondaryKey)
+			assert.Nil(t, query.Result.PreviousKey)
+
+			t.Run("promoting the secondary secret keeps the replaced one for the grace period", func(t *testing.T) {
+				gracePeriod := int64(600)
+				promoteCmd := apikey.PromoteSecondaryCommand{Id: cmd.Result.Id, OrgId: 1, GracePeriodSeconds: &gracePeriod}
+				err := ss.PromoteSecondaryAPIKey(context.Background(), &promoteCmd)
+				require.NoError(t, err)
+
+				err = ss.GetApiKeyByName(context.Background(), &query)
+				require.NoError(t, err)
+				assert.Equal(t, "dual-next", query.Result.Key)
+				assert.Nil(t, query.Result.SecondaryKey)
+				require.NotNil(t, query.Result.PreviousKey)
+				assert.Equal(t, "dual-current", *query.Result.PreviousKey)
+				require.NotNil(t, query.Result.PreviousKeyExpires)
+				assert.Equal(t, query.Result.Updated.Add(10*time.Minute).Unix(), *query.Result.PreviousKeyExpires)
+
+				err = ss.PromoteSecondaryAPIKey(context.Background(), &promoteCmd)
+				assert.ErrorIs(t, err, apikey.ErrNoSecondary)
+			})
+
+			t.Run("revoking the secondary secret keeps the current one", func(t *testing.T) {
+				rotateCmd := apikey.RotateCommand{Id: cmd.Result.Id, OrgId: 1, Key: "dual-revoked", Secondary: true}
+				err := ss.RotateAPIKey(context.Background(), &rotateCmd)
+				require.NoError(t, err)
+
+				revokeCmd := apikey.RevokeSecondaryCommand{Id: cmd.Result.Id, OrgId: 1}
+				err = ss.RevokeSecondaryAPIKey(context.Background(), &revokeCmd)
+				require.NoError(t, err)
+
+				err = ss.GetApiKeyByName(context.Background(), &query)
+				require.NoError(t, err)
+				assert.Equal(t, "dual-next", query.Result.Key)
+				assert.Nil(t, query.Result.SecondaryKey)
+
+				err = ss.RevokeSecondaryAPIKey(context.Background(), &revokeCmd)
+				assert.ErrorIs(t, err, apikey.ErrNoSecondary)
+				err = ss.RevokeSecondaryAPIKey(context.Background(), &apikey.RevokeSecondaryCommand{Id: cmd.Result.Id, OrgId: 2})
+				assert.ErrorIs(t, err, apikey.ErrNotFound)
+			})
+		})
+
+		t.Run("Restrict key to access windows", func(t *testing.T) {
+			windows := []apikey.AccessWindow{{Days: []string{"mon", "tue"}, Start: "02:00", End: "04:00"}}
+			cmd := apikey.AddCommand{OrgId: 1, Name: "batch", Key: "batch", AccessWindows: windows}
+			err := ss.AddAPIKey(context.Background(), &cmd)
+			require.NoError(t, err)
+
+			query := apikey.GetByNameQuery{KeyName: "batch", OrgId: 1}
+			err = ss.GetApiKeyByName(context.Background(), &query)
+			require.NoError(t, err)
+			assert.Equal(t, windows, query.Result.AccessWindows)
+
+			t.Run("windows can be lifted", func(t *testing.T) {
+				updateCmd := apikey.UpdateCommand{Id: cmd.Result.Id, OrgId: 1, AccessWindows: []apikey.AccessWindow{}}
+				err = ss.UpdateAPIKey(context.Background(), &updateCmd)
+				require.NoError(t, err)
+
+				err = ss.GetApiKeyByName(context.Background(), &query)
+				require.NoError(t, err)
+				assert.Empty(t, query.Result.AccessWindows)
+			})
+
+			t.Run("invalid windows are rejected", func(t *testing.T) {
+				invalid := []apikey.AccessWindow{{Start: "2am", End: "4am"}}
+				updateCmd := apikey.UpdateCommand{Id: cmd.Result.Id, OrgId: 1, AccessWindows: invalid}
+				err = ss.UpdateAPIKey(context.Background(), &updateCmd)
+				assert.ErrorIs(t, err, apikey.ErrInvalidAccessWindow)
+
+				addCmd := apikey.AddCommand{OrgId: 1, Name: "invalid-window", Key: "invalid-window", AccessWindows: invalid}
+				err = ss.AddAPIKey(context.Background(), &addCmd)
+				assert.ErrorIs(t, err, apikey.ErrInvalidAccessWindow)
+			})
+		})
+
+		t.Run("Restrict key to data sources", func(t *testing.T) {
+			cmd := apikey.AddCommand{OrgId: 1, Name: "datasources", Key: "datasources", DatasourceUIDs: []string{"prometheus", "loki-logs"}}
+			err := ss.AddAPIKey(context.Background(), &cmd)
+			require.NoError(t, err)
+
+			query := apikey.GetByNameQuery{KeyName: "datasources", OrgId: 1}
+			err = ss.GetApiKeyByName(context.Background(), &query)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"prometheus", "loki-logs"}, query.Result.AllowedDatasources())
+
+			t.Run("omitted data sources are left unchanged", func(t *testing.T) {
+				rateLimit := int64(2)
+				updateCmd := apikey.UpdateCommand{Id: cmd.Result.Id, OrgId: 1, RateLimitRPS: &rateLimit}
+				err = ss.UpdateAPIKey(context.Background(), &updateCmd)
+				require.NoError(t, err)
+
+				err = ss.GetApiKeyByName(context.Background(), &query)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"prometheus", "loki-logs"}, query.Result.AllowedDatasources())
+			})
+
+			t.Run("data sources can be lifted", func(t *testing.T) {
+				updateCmd := apikey.UpdateCommand{Id: cmd.Result.Id, OrgId: 1, DatasourceUIDs: []string{}}
+				err = ss.UpdateAPIKey(context.Background(), &updateCmd)
+				require.NoError(t, err)
+
+				err = ss.GetApiKeyByName(context.Background(), &query)
+				require.NoError(t, err)
+				assert.Nil(t, query.Result.AllowedDatasources())
+			})
+
+			t.Run("invalid data source UIDs are rejected", func(t *testing.T) {
+				updateCmd := apikey.UpdateCommand{Id: cmd.Result.Id, OrgId: 1, DatasourceUIDs: []string{"not a uid"}}
+				err = ss.UpdateAPIKey(context.Background(), &updateCmd)
+				assert.ErrorIs(t, err, apikey.ErrInvalidDatasource)
+
+				addCmd := apikey.AddCommand{OrgId: 1, Name: "invalid-datasource", Key: "invalid-datasource", DatasourceUIDs: []string{""}}
+				err = ss.AddAPIKey(context.Background(), &addCmd)
+				assert.ErrorIs(t, err, apikey.ErrInvalidDatasource)
+			})
+		})
+
+		t.Run("Restrict key to IP ranges", func(t *testing.T) {
+			cmd := apikey.AddCommand{OrgId: 1, Name: "restricted", Key: "restricted", AllowedIPRanges: []string{"10.0.0.0/8", "2001:db8::/32"}}
+			err := ss.AddAPIKey(context.Background(), &cmd)
+			require.NoError(t, err)
+
+			query := apikey.GetByNameQuery{KeyName: "restricted", OrgId: 1}
+			err = ss.GetApiKeyByName(context.Background(), &query)
+			require.NoError(t, err)
+			assert.Equal(t, "10.0.0.0/8,2001:db8::/32", query.Result.AllowedIPRanges)
+
+			t.Run("ranges can be lifted", func(t *testing.T) {
+				updateCmd := apikey.UpdateCommand{Id: cmd.Result.Id, OrgId: 1, AllowedIPRanges: []string{}}
+				err = ss.UpdateAPIKey(context.Background(), &updateCmd)
+				require.NoError(t, err)
+
+				err = ss.GetApiKeyByName(context.Background(), &query)
+				require.NoError(t, err)
+				assert.Empty(t, query.Result.AllowedIPRanges)
+			})
+
+			t.Run("invalid ranges are rejected", func(t *testing.T) {
+				updateCmd := apikey.UpdateCommand{Id: cmd.Result.Id, OrgId: 1, AllowedIPRanges: []string{"10.0.0.1"}}
+				err = ss.UpdateAPIKey(context.Background(), &updateCmd)
+				assert.ErrorIs(t, err, apikey.ErrInvalidIPRange)
+
+				addCmd := apikey.AddCommand{OrgId: 1, Name: "invalid-range", Key: "invalid-range", AllowedIPRanges: []string{"not-a-cidr"}}
+				err = ss.AddAPIKey(context.Background(), &addCmd)
+				assert.ErrorIs(t, err, apikey.ErrInvalidIPRange)
+			})
+
+			t.Run("key from another org is not found", func(t *testing.T) {
+				updateCmd := apikey.UpdateCommand{Id: cmd.Result.Id, OrgId: 2}
+				err = ss.UpdateAPIKey(context.Background(), &updateCmd)
+				assert.ErrorIs(t, err, apikey.ErrNotFound)
+			})
+		})
+
+		t.Run("Rate limit key", func(t *testing.T) {
+			cmd := apikey.AddCommand{OrgId: 1, Name: "rate-limited", Key: "rate-limited", RateLimitRPS: 5, AllowedIPRanges: []string{"10.0.0.0/8"}}
+			err := ss.AddAPIKey(context.Background(), &cmd)
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), cmd.Result.RateLimitRPS)
+
+			rps := int64(20)
+			updateCmd := apikey.UpdateCommand{Id: cmd.Result.Id, OrgId: 1, RateLimitRPS: &rps}
+			err = ss.UpdateAPIKey(context.Background(), &updateCmd)
+			require.NoError(t, err)
+
+			query := apikey.GetByNameQuery{KeyName: "rate-limited", OrgId: 1}
+			err = ss.GetApiKeyByName(context.Background(), &query)
+			require.NoError(t, err)
+			assert.Equal(t, int64(20), query.Result.RateLimitRPS)
+			assert.Equal(t, "10.0.0.0/8", query.Result.AllowedIPRanges, "omitted fields should be left unchanged")
+
+			negative := int64(-1)
+			updateCmd = apikey.UpdateCommand{Id: cmd.Result.Id, OrgId: 1, RateLimitRPS: &negative}
+			err = ss.UpdateAPIKey(context.Background(), &updateCmd)
+			assert.ErrorIs(t, err, apikey.ErrInvalidRateLimit)
+		})
+
+		t.Run("Get keys expiring before a date", func(t *testing.T) {
+			cmd := apikey.AddCommand{OrgId: 1, Name: "expiring-in-a-day", Key: "asd4", SecondsToLive: 24 * 3600}
+			err := ss.AddAPIKey(context.Background(), &cmd)
+			require.NoError(t, err)
+
+			keys, err := ss.GetKeysExpiringBefore(context.Background(), clk.Now().Add(2*time.Hour).Unix())
+			require.NoError(t, err)
+			for _, k := range keys {
+				assert.NotEqual(t, "expiring-in-a-day", k.Name)
+				assert.NotEqual(t, "non-expiring", k.Name)
+			}
+
+			keys, err = ss.GetKeysExpiringBefore(context.Background(), clk.Now().Add(48*time.Hour).Unix())
+			require.NoError(t, err)
+			found := false
+			for _, k := range keys {
+				if k.Name == "expiring-in-a-day" {
+					found = true
+				}
+			}
+			assert.True(t, found)
 		})
 
 		t.Run("Add a key with negative lifespan", func(t *testing.T) {
@@ -138,8 +407,8 @@ func TestIntegrationApiKeyDataAccess(t *testing.T) {
 			err = ss.AddAPIKey(context.Background(), &cmd)
 			assert.Nil(t, err)
 
-			// advance mocked getTime by 1s
-			timeNow()
+			// advance the mocked clock by 1s
+			clk.Now()
 
 			testUser := &user.SignedInUser{
 				OrgID: 1,
@@ -172,16 +441,401 @@ func TestIntegrationApiKeyDataAccess(t *testing.T) {
 	})
 }
 
+func TestIntegrationApiKeyLabels(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	ss := &sqlStore{db: db, cfg: db.Cfg, clock: clock.New()}
+
+	for _, cmd := range []*apikey.AddCommand{
+		{OrgId: 1, Name: "payments-prod", Key: "payments-prod", Labels: map[string]string{"team": "payments", "env": "prod"}},
+		{OrgId: 1, Name: "payments-dev", Key: "payments-dev", Labels: map[string]string{"team": "payments", "env": "dev"}},
+		{OrgId: 1, Name: "search-prod", Key: "search-prod", Labels: map[string]string{"team": "search", "env": "prod"}},
+		{OrgId: 1, Name: "wildcard", Key: "wildcard", Labels: map[string]string{"team": "pay%"}},
+		{OrgId: 1, Name: "quoted", Key: "quoted", Labels: map[string]string{`x"team`: "payments", "env": `"team":"search"`}},
+		{OrgId: 1, Name: "unlabeled", Key: "unlabeled"},
+	} {
+		require.NoError(t, ss.AddAPIKey(context.Background(), cmd))
+	}
+
+	testUser := &user.SignedInUser{
+		OrgID: 1,
+		Permissions: map[int64]map[string][]string{
+			1: {accesscontrol.ActionAPIKeyRead: []string{accesscontrol.ScopeAPIKeysAll}},
+		},
+	}
+
+	names := func(keys []*apikey.APIKey) []string {
+		result := make([]string, 0, len(keys))
+		for _, k := range keys {
+			result = append(result, k.Name)
+		}
+		return result
+	}
+
+	t.Run("labels are stored", func(t *testing.T) {
+		query := apikey.GetByNameQuery{OrgId: 1, KeyName: "payments-prod"}
+		require.NoError(t, ss.GetApiKeyByName(context.Background(), &query))
+		assert.Equal(t, map[string]string{"team": "payments", "env": "prod"}, query.Result.Labels)
+	})
+
+	t.Run("filter by one label", func(t *testing.T) {
+		query := apikey.GetApiKeysQuery{OrgId: 1, User: testUser, Labels: map[string]string{"team": "payments"}}
+		require.NoError(t, ss.GetAPIKeys(context.Background(), &query))
+		assert.Equal(t, []string{"payments-dev", "payments-prod"}, names(query.Result))
+	})
+
+	t.Run("filter by several labels", func(t *testing.T) {
+		query := apikey.GetApiKeysQuery{OrgId: 1, User: testUser, Labels: map[string]string{"team": "payments", "env": "prod"}}
+		require.NoError(t, ss.GetAPIKeys(context.Background(), &query))
+		assert.Equal(t, []string{"payments-prod"}, names(query.Result))
+	})
+
+	t.Run("wildcards in labels are matched literally", func(t *testing.T) {
+		query := apikey.GetApiKeysQuery{OrgId: 1, User: testUser, Labels: map[string]string{"team": "pay%"}}
+		require.NoError(t, ss.GetAPIKeys(context.Background(), &query))
+		assert.Equal(t, []string{"wildcard"}, names(query.Result))
+	})
+
+	t.Run("labels are not matched inside other names or values", func(t *testing.T) {
+		query := apikey.GetApiKeysQuery{OrgId: 1, User: testUser, Labels: map[string]string{"team": "search"}}
+		require.NoError(t, ss.GetAPIKeys(context.Background(), &query))
+		assert.Equal(t, []string{"search-prod"}, names(query.Result))
+
+		query = apikey.GetApiKeysQuery{OrgId: 1, User: testUser, Labels: map[string]string{`x"team`: "payments"}}
+		require.NoError(t, ss.GetAPIKeys(context.Background(), &query))
+		assert.Equal(t, []string{"quoted"}, names(query.Result))
+	})
+
+	t.Run("empty label name is rejected", func(t *testing.T) {
+		cmd := apikey.AddCommand{OrgId: 1, Name: "invalid-label", Key: "invalid-label", Labels: map[string]string{"": "value"}}
+		err := ss.AddAPIKey(context.Background(), &cmd)
+		assert.ErrorIs(t, err, apikey.ErrInvalidLabel)
+	})
+}
+
+func TestIntegrationApiKeySearch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	ss := &sqlStore{db: db, cfg: db.Cfg, clock: clock.New()}
+	ctx := context.Background()
+
+	creator, err := db.CreateUser(ctx, user.CreateUserCommand{Login: "alice", Name: "Alice Doe", Email: "alice@example.org"})
+	require.NoError(t, err)
+	outsider, err := db.CreateUser(ctx, user.CreateUserCommand{Login: "mallory", Email: "mallory@example.org", SkipOrgSetup: true})
+	require.NoError(t, err)
+
+	ids := map[string]int64{}
+	for _, cmd := range []*apikey.AddCommand{
+		{OrgId: 1, Name: "Billing-Prod", Key: "billing-prod"},
+		{OrgId: 1, Name: "exporter", Key: "exporter", Labels: map[string]string{"team": "billing"}},
+		{OrgId: 1, Name: "alice-key", Key: "alice-key", CreatedBy: creator.ID},
+		{OrgId: 1, Name: "outsider-key", Key: "outsider-key", CreatedBy: outsider.ID},
+		{OrgId: 1, Name: "discount", Key: "discount", Labels: map[string]string{"rate": "50%", "tier_1": "gold"}},
+		{OrgId: 1, Name: "unrelated", Key: "unrelated"},
+		{OrgId: 2, Name: "billing-other-org", Key: "billing-other-org"},
+	} {
+		require.NoError(t, ss.AddAPIKey(ctx, cmd))
+		ids[cmd.Name] = cmd.Result.Id
+	}
+
+	allKeys := &user.SignedInUser{
+		OrgID: 1,
+		Permissions: map[int64]map[string][]string{
+			1: {accesscontrol.ActionAPIKeyRead: []string{accesscontrol.ScopeAPIKeysAll}},
+		},
+	}
+	search := func(t *testing.T, signedInUser *user.SignedInUser, text string) []string {
+		t.Helper()
+		query := apikey.GetApiKeysQuery{OrgId: 1, User: signedInUser, Query: text}
+		require.NoError(t, ss.GetAPIKeys(ctx, &query))
+		names := make([]string, 0, len(query.Result))
+		for _, k := range query.Result {
+			names = append(names, k.Name)
+		}
+		return names
+	}
+
+	t.Run("matches names and labels case insensitively", func(t *testing.T) {
+		assert.Equal(t, []string{"Billing-Prod", "exporter"}, search(t, allKeys, "billing"))
+	})
+
+	t.Run("matches the login, name and email of the creator", func(t *testing.T) {
+		assert.Equal(t, []string{"alice-key"}, search(t, allKeys, "doe"))
+		assert.Equal(t, []string{"alice-key"}, search(t, allKeys, "example.org"))
+	})
+
+	t.Run("ignores the creators outside of the organization", func(t *testing.T) {
+		assert.Empty(t, search(t, allKeys, "mallory"))
+	})
+
+	t.Run("matches wildcards literally", func(t *testing.T) {
+		assert.Equal(t, []string{"discount"}, search(t, allKeys, "50%"))
+		assert.Equal(t, []string{"discount"}, search(t, allKeys, "tier_"))
+		assert.Empty(t, search(t, allKeys, "%billing"))
+	})
+
+	t.Run("matches the names and values of labels, not their JSON encoding", func(t *testing.T) {
+		assert.Empty(t, search(t, allKeys, `":"`))
+		assert.Empty(t, search(t, allKeys, `team":"billing`))
+		assert.Equal(t, []string{"exporter"}, search(t, allKeys, "team"))
+	})
+
+	t.Run("returns every key without query", func(t *testing.T) {
+		assert.Len(t, search(t, allKeys, ""), 6)
+	})
+
+	t.Run("fills the page with the keys after the dropped matches", func(t *testing.T) {
+		for i := 0; i < 120; i++ {
+			name := fmt.Sprintf("labeled-%03d", i)
+			cmd := apikey.AddCommand{OrgId: 1, Name: name, Key: name, Labels: map[string]string{"a": "b"}}
+			require.NoError(t, ss.AddAPIKey(ctx, &cmd))
+		}
+		cmd := apikey.AddCommand{OrgId: 1, Name: "zz:colon", Key: "zz:colon"}
+		require.NoError(t, ss.AddAPIKey(ctx, &cmd))
+
+		assert.Equal(t, []string{"zz:colon"}, search(t, allKeys, ":"))
+		assert.Len(t, search(t, allKeys, "labeled"), 100)
+	})
+
+	t.Run("is combined with the access control filter", func(t *testing.T) {
+		oneKey := &user.SignedInUser{
+			OrgID: 1,
+			Permissions: map[int64]map[string][]string{
+				1: {accesscontrol.ActionAPIKeyRead: []string{fmt.Sprintf("apikeys:id:%d", ids["exporter"])}},
+			},
+		}
+		assert.Equal(t, []string{"exporter"}, search(t, oneKey, "billing"))
+	})
+}
+
+func TestIntegrationApiKeySummary(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	ss := &sqlStore{db: db, cfg: db.Cfg, clock: clk}
+	ctx := context.Background()
+
+	ids := map[string]int64{}
+	for _, cmd := range []*apikey.AddCommand{
+		{OrgId: 1, Name: "forever", Key: "forever"},
+		{OrgId: 1, Name: "expiring", Key: "expiring", SecondsToLive: 3600},
+		{OrgId: 1, Name: "used", Key: "used", SecondsToLive: 30 * 24 * 3600},
+		{OrgId: 1, Name: "expired", Key: "expired", SecondsToLive: 60},
+		{OrgId: 1, Name: "pending", Key: "pending", Pending: true},
+		{OrgId: 1, Name: "token", Key: "token"},
+		{OrgId: 2, Name: "other-org", Key: "other-org"},
+	} {
+		require.NoError(t, ss.AddAPIKey(ctx, cmd))
+		ids[cmd.Name] = cmd.Result.Id
+	}
+	require.NoError(t, ss.UpdateAPIKeysLastUsed(ctx, []*apikey.UpdateLastUsedCommand{{Id: ids["used"], Time: clk.Now()}}))
+	err := db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("UPDATE api_key SET service_account_id = 1 WHERE id = ?", ids["token"])
+		return err
+	})
+	require.NoError(t, err)
+	clk.Add(2 * time.Minute)
+
+	summary, err := ss.GetAPIKeySummary(ctx, 1, clk.Now(), 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, &apikey.Summary{Total: 5, Active: 3, Pending: 1, Expired: 1, ExpiringSoon: 1, NeverUsed: 2}, summary)
+
+	t.Run("counts nothing for organizations without keys", func(t *testing.T) {
+		summary, err := ss.GetAPIKeySummary(ctx, 3, clk.Now(), 24*time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, &apikey.Summary{}, summary)
+	})
+}
+
+func TestIntegrationApiKeyQuota(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+
+	db := sqlstore.InitTestDB(t)
+	cfg := *db.Cfg
+	cfg.ApiKeyMaxActivePerOrg = 2
+	ss := &sqlStore{db: db, cfg: &cfg, clock: clk}
+
+	require.NoError(t, ss.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "non-expiring", Key: "non-expiring"}))
+	require.NoError(t, ss.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "expiring", Key: "expiring", SecondsToLive: 1}))
+	require.NoError(t, ss.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 2, Name: "other-org", Key: "other-org"}))
+
+	count, err := ss.CountActiveKeys(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	err = ss.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "over-quota", Key: "over-quota"})
+	assert.ErrorIs(t, err, apikey.ErrQuotaReached)
+
+	t.Run("expired keys are not counted", func(t *testing.T) {
+		clk.Add(2 * time.Second)
+
+		count, err := ss.CountActiveKeys(context.Background(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		err = ss.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "after-expiry", Key: "after-expiry"})
+		assert.NoError(t, err)
+	})
+
+	t.Run("replacing a key at the quota is allowed", func(t *testing.T) {
+		err := ss.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "non-expiring", Key: "replaced", Upsert: true})
+		assert.NoError(t, err)
+	})
+
+	t.Run("concurrent additions do not exceed the quota", func(t *testing.T) {
+		var wg sync.WaitGroup
+		errs := make([]error, 5)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				name := fmt.Sprintf("concurrent-%d", i)
+				errs[i] = ss.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 3, Name: name, Key: name})
+			}(i)
+		}
+		wg.Wait()
+
+		added := 0
+		for _, err := range errs {
+			if err == nil {
+				added++
+				continue
+			}
+			assert.ErrorIs(t, err, apikey.ErrQuotaReached)
+		}
+		assert.Equal(t, 2, added)
+
+		count, err := ss.CountActiveKeys(context.Background(), 3)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+	})
+}
+
+func TestIntegrationApiKeyApproval(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	db := sqlstore.InitTestDB(t)
+	ss := &sqlStore{db: db, cfg: db.Cfg, clock: clock.New()}
+
+	active := apikey.AddCommand{OrgId: 1, Name: "active", Key: "active"}
+	require.NoError(t, ss.AddAPIKey(context.Background(), &active))
+	assert.Equal(t, apikey.StatusActive, active.Result.Status)
+
+	approved := apikey.AddCommand{OrgId: 1, Name: "approved", Key: "approved", Pending: true}
+	require.NoError(t, ss.AddAPIKey(context.Background(), &approved))
+	assert.True(t, approved.Result.IsPending())
+
+	rejected := apikey.AddCommand{OrgId: 1, Name: "rejected", Key: "rejected", Pending: true}
+	require.NoError(t, ss.AddAPIKey(context.Background(), &rejected))
+
+	t.Run("approving a pending key activates it", func(t *testing.T) {
+		err := ss.ApproveAPIKey(context.Background(), &apikey.ApproveCommand{Id: approved.Result.Id, OrgId: 2})
+		assert.ErrorIs(t, err, apikey.ErrNotFound)
+
+		require.NoError(t, ss.ApproveAPIKey(context.Background(), &apikey.ApproveCommand{Id: approved.Result.Id, OrgId: 1}))
+		query := apikey.GetByIDQuery{ApiKeyId: approved.Result.Id}
+		require.NoError(t, ss.GetApiKeyById(context.Background(), &query))
+		assert.Equal(t, apikey.StatusActive, query.Result.Status)
+
+		err = ss.ApproveAPIKey(context.Background(), &apikey.ApproveCommand{Id: approved.Result.Id, OrgId: 1})
+		assert.ErrorIs(t, err, apikey.ErrNotFound, "active keys cannot be approved")
+	})
+
+	t.Run("rejecting a pending key deletes it", func(t *testing.T) {
+		err := ss.RejectAPIKey(context.Background(), &apikey.RejectCommand{Id: active.Result.Id, OrgId: 1})
+		assert.ErrorIs(t, err, apikey.ErrNotFound, "active keys cannot be rejected")
+
+		require.NoError(t, ss.RejectAPIKey(context.Background(), &apikey.RejectCommand{Id: rejected.Result.Id, OrgId: 1}))
+		query := apikey.GetByIDQuery{ApiKeyId: rejected.Result.Id}
+		assert.ErrorIs(t, ss.GetApiKeyById(context.Background(), &query), apikey.ErrInvalid)
+	})
+
+	t.Run("replacing or rotating a key without approval makes it pending", func(t *testing.T) {
+		replace := apikey.AddCommand{OrgId: 1, Name: "active", Key: "replaced", Upsert: true, Pending: true}
+		require.NoError(t, ss.AddAPIKey(context.Background(), &replace))
+		assert.True(t, replace.Result.IsPending())
+
+		require.NoError(t, ss.ApproveAPIKey(context.Background(), &apikey.ApproveCommand{Id: active.Result.Id, OrgId: 1}))
+		for _, secondary := range []bool{false, true} {
+			rotate := apikey.RotateCommand{Id: active.Result.Id, OrgId: 1, Key: fmt.Sprintf("rotated-%t", secondary), Secondary: secondary, Pending: true}
+			require.NoError(t, ss.RotateAPIKey(context.Background(), &rotate))
+			query := apikey.GetByIDQuery{ApiKeyId: active.Result.Id}
+			require.NoError(t, ss.GetApiKeyById(context.Background(), &query))
+			assert.True(t, query.Result.IsPending())
+			require.NoError(t, ss.ApproveAPIKey(context.Background(), &apikey.ApproveCommand{Id: active.Result.Id, OrgId: 1}))
+		}
+	})
+}
+
+func TestIntegrationApiKeyUpsert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	cfg := *db.Cfg
+	cfg.ApiKeyMaxActivePerOrg = 1
+	ss := &sqlStore{db: db, cfg: &cfg, clock: newTickingClock()}
+
+	original := apikey.AddCommand{OrgId: 1, Name: "provisioned", Key: "first", Role: org.RoleViewer, SecondsToLive: 3600}
+	require.NoError(t, ss.AddAPIKey(context.Background(), &original))
+
+	t.Run("replaces the secret and the expiration of the existing key", func(t *testing.T) {
+		cmd := apikey.AddCommand{OrgId: 1, Name: "provisioned", Key: "second", Role: org.RoleAdmin, Upsert: true}
+		require.NoError(t, ss.AddAPIKey(context.Background(), &cmd))
+		assert.True(t, cmd.Replaced)
+		assert.Equal(t, original.Result.Id, cmd.Result.Id)
+
+		query := apikey.GetByIDQuery{ApiKeyId: original.Result.Id}
+		require.NoError(t, ss.GetApiKeyById(context.Background(), &query))
+		assert.Equal(t, "second", query.Result.Key)
+		assert.Nil(t, query.Result.Expires)
+		assert.Equal(t, org.RoleViewer, query.Result.Role)
+	})
+
+	t.Run("creates the key when it does not exist", func(t *testing.T) {
+		cmd := apikey.AddCommand{OrgId: 2, Name: "provisioned", Key: "third", Upsert: true}
+		require.NoError(t, ss.AddAPIKey(context.Background(), &cmd))
+		assert.False(t, cmd.Replaced)
+	})
+
+	t.Run("fails without upsert", func(t *testing.T) {
+		cmd := apikey.AddCommand{OrgId: 1, Name: "provisioned", Key: "fourth"}
+		assert.ErrorIs(t, ss.AddAPIKey(context.Background(), &cmd), apikey.ErrDuplicate)
+
+		cfg.ApiKeyMaxActivePerOrg = 0
+		assert.ErrorIs(t, ss.AddAPIKey(context.Background(), &cmd), apikey.ErrDuplicate)
+	})
+
+	t.Run("cannot change the type of the key", func(t *testing.T) {
+		cmd := apikey.AddCommand{OrgId: 1, Name: "provisioned", Key: "fifth", Type: apikey.TypeSigning, Upsert: true}
+		assert.ErrorIs(t, ss.AddAPIKey(context.Background(), &cmd), apikey.ErrInvalidType)
+	})
+}
+
 func TestIntegrationApiKeyErrors(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
 	}
-	mockTimeNow()
-	defer resetTimeNow()
 
 	t.Run("Testing API Key errors", func(t *testing.T) {
 		db := sqlstore.InitTestDB(t)
-		ss := &sqlStore{db: db, cfg: db.Cfg}
+		ss := &sqlStore{db: db, cfg: db.Cfg, clock: newTickingClock()}
 
 		t.Run("Delete non-existing key should return error", func(t *testing.T) {
 			cmd := apikey.DeleteCommand{Id: 1}
@@ -206,6 +860,62 @@ func TestIntegrationApiKeyErrors(t *testing.T) {
 	})
 }
 
+func TestIntegrationApiKeyDeleteByFilter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	ss := &sqlStore{db: db, cfg: db.Cfg, clock: clock.New()}
+
+	for _, cmd := range []*apikey.AddCommand{
+		{OrgId: 1, Name: "ci-deploy", Key: "ci-deploy", CreatedBy: 1},
+		{OrgId: 1, Name: "ci-test", Key: "ci-test", CreatedBy: 2},
+		{OrgId: 1, Name: "backup", Key: "backup", CreatedBy: 2},
+		{OrgId: 2, Name: "ci-other-org", Key: "ci-other-org", CreatedBy: 1},
+	} {
+		require.NoError(t, ss.AddAPIKey(context.Background(), cmd))
+	}
+	backup := apikey.GetByNameQuery{OrgId: 1, KeyName: "backup"}
+	require.NoError(t, ss.GetApiKeyByName(context.Background(), &backup))
+	require.NoError(t, ss.UpdateAPIKeysLastUsed(context.Background(), []*apikey.UpdateLastUsedCommand{{Id: backup.Result.Id, Time: ss.clock.Now()}}))
+
+	t.Run("requires a filter", func(t *testing.T) {
+		cmd := &apikey.DeleteByFilterCommand{OrgId: 1}
+		err := ss.DeleteByFilter(context.Background(), cmd)
+		assert.ErrorIs(t, err, apikey.ErrEmptyFilter)
+	})
+
+	t.Run("name prefix wildcards match themselves", func(t *testing.T) {
+		for _, prefix := range []string{"%", "_", "ci_"} {
+			cmd := &apikey.DeleteByFilterCommand{OrgId: 1, NamePrefix: prefix}
+			require.NoError(t, ss.DeleteByFilter(context.Background(), cmd))
+			assert.Equal(t, int64(0), cmd.Result, prefix)
+		}
+		require.Len(t, ss.GetAllAPIKeys(context.Background(), -1), 4)
+	})
+
+	t.Run("deletes keys matching all filters", func(t *testing.T) {
+		cmd := &apikey.DeleteByFilterCommand{OrgId: 1, NamePrefix: "ci-", CreatedBy: 2}
+		require.NoError(t, ss.DeleteByFilter(context.Background(), cmd))
+		assert.Equal(t, int64(1), cmd.Result)
+
+		keys := ss.GetAllAPIKeys(context.Background(), -1)
+		require.Len(t, keys, 3)
+	})
+
+	t.Run("deletes keys not used since a date", func(t *testing.T) {
+		lastUsedBefore := time.Now().Add(time.Hour)
+		cmd := &apikey.DeleteByFilterCommand{OrgId: 1, LastUsedBefore: &lastUsedBefore}
+		require.NoError(t, ss.DeleteByFilter(context.Background(), cmd))
+		assert.Equal(t, int64(2), cmd.Result)
+
+		keys := ss.GetAllAPIKeys(context.Background(), -1)
+		require.Len(t, keys, 1)
+		assert.Equal(t, "ci-other-org", keys[0].Name)
+	})
+}
+
 type getApiKeysTestCase struct {
 	desc            string
 	user            *user.SignedInUser
@@ -243,7 +953,7 @@ func TestIntegrationSQLStore_GetAPIKeys(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.desc, func(t *testing.T) {
 			db := sqlstore.InitTestDB(t, sqlstore.InitTestDBOpt{})
-			store := &sqlStore{db: db, cfg: db.Cfg}
+			store := &sqlStore{db: db, cfg: db.Cfg, clock: clock.New()}
 			seedApiKeys(t, store, 10)
 
 			query := &apikey.GetApiKeysQuery{OrgId: 1, User: tt.user}
@@ -266,3 +976,68 @@ func seedApiKeys(t *testing.T, store store, num int) {
 		require.NoError(t, err)
 	}
 }
+
+func TestIntegrationApiKeyFingerprintDeletion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	ss := &sqlStore{db: db, cfg: db.Cfg, clock: clock.New()}
+	ctx := context.Background()
+
+	addKey := func(t *testing.T, name string, pending bool) int64 {
+		t.Helper()
+		cmd := apikey.AddCommand{OrgId: 1, Name: name, Key: name, Fingerprint: name + "-fingerprint", Pending: pending}
+		require.NoError(t, ss.AddAPIKey(ctx, &cmd))
+		return cmd.Result.Id
+	}
+	fingerprints := func(t *testing.T, id int64) int64 {
+		t.Helper()
+		var count int64
+		err := db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+			var err error
+			count, err = sess.Where("api_key_id=?", id).Count(&apikey.Fingerprint{})
+			return err
+		})
+		require.NoError(t, err)
+		return count
+	}
+
+	t.Run("deleting a key deletes its fingerprints", func(t *testing.T) {
+		id := addKey(t, "deleted", false)
+		require.Equal(t, int64(1), fingerprints(t, id))
+		require.NoError(t, ss.DeleteApiKey(ctx, &apikey.DeleteCommand{Id: id, OrgId: 1}))
+		assert.Zero(t, fingerprints(t, id))
+	})
+
+	t.Run("deleting keys by filter deletes their fingerprints", func(t *testing.T) {
+		id := addKey(t, "filtered", false)
+		kept := addKey(t, "kept", false)
+		require.NoError(t, ss.DeleteByFilter(ctx, &apikey.DeleteByFilterCommand{OrgId: 1, NamePrefix: "filtered"}))
+		assert.Zero(t, fingerprints(t, id))
+		assert.Equal(t, int64(1), fingerprints(t, kept))
+	})
+
+	t.Run("rejecting a key deletes its fingerprints", func(t *testing.T) {
+		id := addKey(t, "rejected", true)
+		require.NoError(t, ss.RejectAPIKey(ctx, &apikey.RejectCommand{Id: id, OrgId: 1}))
+		assert.Zero(t, fingerprints(t, id))
+	})
+
+	t.Run("the fingerprints of deleted keys are swept", func(t *testing.T) {
+		id := addKey(t, "swept", false)
+		kept := addKey(t, "not-swept", false)
+		err := db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+			_, err := sess.Exec("DELETE FROM api_key WHERE id=?", id)
+			return err
+		})
+		require.NoError(t, err)
+
+		deleted, err := ss.DeleteOrphanFingerprints(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+		assert.Zero(t, fingerprints(t, id))
+		assert.Equal(t, int64(1), fingerprints(t, kept))
+	})
+}
@@ -0,0 +1,183 @@
+package apikeyimpl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/sqlstore/db"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	// usageFlushInterval is how often the buffered usages are written to the
+	// api_key_usage table.
+	usageFlushInterval = time.Minute
+	// usageRollupInterval is how often the api_key_usage table is compacted.
+	usageRollupInterval = time.Hour
+	// usageRetention is the largest window of the usage statistics, older
+	// usages are deleted.
+	usageRetention = 30 * 24 * time.Hour
+	// usageTopRoutes is the number of routes in the usage statistics.
+	usageTopRoutes = 10
+
+	hourSeconds = 60 * 60
+	daySeconds  = 24 * hourSeconds
+)
+
+// usageRow is a row of the api_key_usage table, the number of requests of a
+// key to a route during the period starting at PeriodStart. Usages are
+// recorded per hour and rolled up per day after 24 hours.
+type usageRow struct {
+	Id            int64
+	ApiKeyId      int64
+	OrgId         int64
+	Route         string
+	PeriodStart   int64
+	PeriodSeconds int64
+	Requests      int64
+}
+
+type usageKey struct {
+	apiKeyID    int64
+	orgID       int64
+	route       string
+	periodStart int64
+}
+
+// usageBuffer counts the requests of API keys in memory until they are
+// written to the database.
+type usageBuffer struct {
+	mu     sync.Mutex
+	counts map[usageKey]int64
+}
+
+func newUsageBuffer() *usageBuffer {
+	return &usageBuffer{counts: make(map[usageKey]int64)}
+}
+
+func (b *usageBuffer) add(cmd *apikey.RecordUsageCommand) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	periodStart := cmd.Time.Unix()
+	periodStart -= periodStart % hourSeconds
+	b.counts[usageKey{apiKeyID: cmd.Id, orgID: cmd.OrgId, route: cmd.Route, periodStart: periodStart}]++
+}
+
+// drain empties the buffer and returns its content.
+func (b *usageBuffer) drain() []*usageRow {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rows := make([]*usageRow, 0, len(b.counts))
+	for k, count := range b.counts {
+		rows = append(rows, &usageRow{
+			ApiKeyId:      k.apiKeyID,
+			OrgId:         k.orgID,
+			Route:         k.route,
+			PeriodStart:   k.periodStart,
+			PeriodSeconds: hourSeconds,
+			Requests:      count,
+		})
+	}
+	b.counts = make(map[usageKey]int64)
+	return rows
+}
+
+func (s *Service) RecordUsage(cmd *apikey.RecordUsageCommand) {
+	s.usage.add(cmd)
+}
+
+func (s *Service) flushUsage(ctx context.Context) {
+	rows := s.usage.drain()
+	if len(rows) == 0 {
+		return
+	}
+	if err := s.store.InsertAPIKeyUsage(ctx, rows); err != nil {
+		s.log.Error("failed to write api keys usage", "count", len(rows), "error", err)
+	}
+}
+
+func (s *Service) GetUsageStats(ctx context.Context, query *apikey.GetUsageStatsQuery) error {
+	keyQuery := apikey.GetByIDQuery{ApiKeyId: query.ApiKeyId}
+	if err := s.store.GetApiKeyById(ctx, &keyQuery); err != nil {
+		if errors.Is(err, apikey.ErrInvalid) {
+			return apikey.ErrNotFound
+		}
+		return err
+	}
+	if keyQuery.Result.OrgId != query.OrgId || keyQuery.Result.ServiceAccountId != nil {
+		return apikey.ErrNotFound
+	}
+
+	stats, err := s.store.GetAPIKeyUsageStats(ctx, query.ApiKeyId, s.clock.Now())
+	if err != nil {
+		return err
+	}
+	query.Result = stats
+	return nil
+}
+
+func (s *Service) GetSummary(ctx context.Context, query *apikey.GetSummaryQuery) error {
+	window := s.cfg.ApiKeyExpiryNotificationWindow
+	if window <= 0 {
+		window = apikey.SummaryExpiringSoonWindow
+	}
+	summary, err := s.store.GetAPIKeySummary(ctx, query.OrgId, s.clock.Now(), window)
+	if err != nil {
+		return err
+	}
+	query.Result = summary
+	return nil
+}
+
+// UsageRollup periodically compacts the api_key_usage table: usages of the
+// same hour are merged, usages older than 24 hours are merged per day and
+// usages older than 30 days are deleted.
+type UsageRollup struct {
+	store      store
+	serverLock *serverlock.ServerLockService
+	log        log.Logger
+	clock      clock.Clock
+}
+
+func ProvideUsageRollup(db db.DB, cfg *setting.Cfg, serverLockService *serverlock.ServerLockService, clk clock.Clock) *UsageRollup {
+	return &UsageRollup{
+		store:      &sqlStore{db: db, cfg: cfg, clock: clk},
+		serverLock: serverLockService,
+		log:        log.New("apikey.usage-rollup"),
+		clock:      clk,
+	}
+}
+
+func (r *UsageRollup) Run(ctx context.Context) error {
+	ticker := time.NewTicker(usageRollupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := r.serverLock.LockAndExecute(ctx, "roll up api keys usage", usageRollupInterval, func(ctx context.Context) {
+				if err := r.store.RollupAPIKeyUsage(ctx, r.clock.Now()); err != nil {
+					r.log.Error("failed to roll up api keys usage", "error", err)
+				}
+				if deleted, err := r.store.DeleteOrphanFingerprints(ctx); err != nil {
+					r.log.Error("failed to delete the fingerprints of deleted api keys", "error", err)
+				} else if deleted > 0 {
+					r.log.Debug("deleted the fingerprints of deleted api keys", "count", deleted)
+				}
+			})
+			if err != nil {
+				r.log.Error("failed to lock and execute api keys usage rollup", "error", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
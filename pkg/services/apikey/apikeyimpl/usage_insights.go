@@ -0,0 +1,42 @@
+package apikeyimpl
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const usageInsightsEventName = "api-key-used"
+
+// UsageInsightsExporter exports the ApiKeyUsed events published when
+// api_key_usage_insights_enabled is set to the usage insights log, from
+// which they are shipped with the other usage insights events.
+type UsageInsightsExporter struct {
+	log log.Logger
+}
+
+func ProvideUsageInsightsExporter(cfg *setting.Cfg, bus bus.Bus) *UsageInsightsExporter {
+	e := &UsageInsightsExporter{
+		log: log.New("usage-insights.apikey"),
+	}
+	if cfg.ApiKeyUsageInsightsEnabled {
+		bus.AddEventListener(e.handleKeyUsed)
+	}
+	return e
+}
+
+func (e *UsageInsightsExporter) handleKeyUsed(_ context.Context, evt *events.ApiKeyUsed) error {
+	e.log.Info("api key used",
+		"kind", "usage_insights",
+		"eventName", usageInsightsEventName,
+		"timestamp", evt.Timestamp,
+		"keyHash", evt.KeyHash,
+		"orgId", evt.OrgID,
+		"routeClass", evt.RouteClass,
+		"durationMs", evt.Duration.Milliseconds(),
+	)
+	return nil
+}
@@ -0,0 +1,52 @@
+package apikeyimpl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/log/logtest"
+	"github.com/grafana/grafana/pkg/infra/tracing"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestUsageInsightsExporter(t *testing.T) {
+	now := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	used := &events.ApiKeyUsed{Timestamp: now, KeyHash: "hash", OrgID: 2, RouteClass: "dashboards", Duration: 1500 * time.Millisecond}
+
+	t.Run("exports the api key usage events", func(t *testing.T) {
+		b := bus.ProvideBus(tracing.InitializeTracerForTest())
+		cfg := setting.NewCfg()
+		cfg.ApiKeyUsageInsightsEnabled = true
+		exporter := ProvideUsageInsightsExporter(cfg, b)
+		logger := &logtest.Fake{}
+		exporter.log = logger
+
+		require.NoError(t, b.Publish(context.Background(), used))
+		assert.Equal(t, 1, logger.InfoLogs.Calls)
+		assert.Equal(t, []interface{}{
+			"kind", "usage_insights",
+			"eventName", usageInsightsEventName,
+			"timestamp", now,
+			"keyHash", "hash",
+			"orgId", int64(2),
+			"routeClass", "dashboards",
+			"durationMs", int64(1500),
+		}, logger.InfoLogs.Ctx)
+	})
+
+	t.Run("does not export the events when usage insights are disabled", func(t *testing.T) {
+		b := bus.ProvideBus(tracing.InitializeTracerForTest())
+		exporter := ProvideUsageInsightsExporter(setting.NewCfg(), b)
+		logger := &logtest.Fake{}
+		exporter.log = logger
+
+		require.NoError(t, b.Publish(context.Background(), used))
+		assert.Equal(t, 0, logger.InfoLogs.Calls)
+	})
+}
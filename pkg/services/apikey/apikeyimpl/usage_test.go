@@ -0,0 +1,96 @@
+package apikeyimpl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+func TestIntegrationUsageStats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	db := sqlstore.InitTestDB(t)
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	s := ProvideService(db, db.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clk)
+	ctx := context.Background()
+
+	cmd := &apikey.AddCommand{OrgId: 1, Name: "used", Key: "used"}
+	require.NoError(t, s.AddAPIKey(ctx, cmd))
+	id := cmd.Result.Id
+
+	// noon, so that usages a few hours apart fall on the same day
+	now := time.Now().Truncate(24 * time.Hour).Add(-12 * time.Hour)
+	clk.Set(now)
+
+	record := func(route string, at time.Time, times int) {
+		for i := 0; i < times; i++ {
+			s.RecordUsage(&apikey.RecordUsageCommand{Id: id, OrgId: 1, Route: route, Time: at})
+		}
+		s.flushUsage(ctx)
+	}
+	record("/api/search/", now, 3)
+	record("/api/search/", now, 1)
+	record("/api/dashboards/uid/:uid", now.Add(-2*time.Hour), 2)
+	record("/api/dashboards/uid/:uid", now.Add(-3*24*time.Hour), 5)
+	record("/api/dashboards/uid/:uid", now.Add(-3*24*time.Hour+time.Hour), 5)
+	record("/api/folders/", now.Add(-20*24*time.Hour), 1)
+	record("/api/folders/", now.Add(-40*24*time.Hour), 100)
+
+	assertStats := func(t *testing.T) {
+		t.Helper()
+		query := apikey.GetUsageStatsQuery{ApiKeyId: id, OrgId: 1}
+		require.NoError(t, s.GetUsageStats(ctx, &query))
+		assert.Equal(t, int64(6), query.Result.Requests24h)
+		assert.Equal(t, int64(16), query.Result.Requests7d)
+		assert.Equal(t, int64(17), query.Result.Requests30d)
+		assert.Equal(t, []apikey.RouteUsage{
+			{Route: "/api/dashboards/uid/:uid", Requests: 12},
+			{Route: "/api/search/", Requests: 4},
+			{Route: "/api/folders/", Requests: 1},
+		}, query.Result.TopRoutes)
+	}
+
+	t.Run("aggregates the usages of the key", assertStats)
+
+	t.Run("rollup keeps the statistics and compacts the table", func(t *testing.T) {
+		require.NoError(t, s.store.RollupAPIKeyUsage(ctx, now.Add(time.Hour)))
+		assertStats(t)
+
+		// one row per route and hour in the last 24 hours, per route and day before
+		assert.Len(t, usageRows(t, db), 4)
+	})
+
+	t.Run("fails for keys of other organizations", func(t *testing.T) {
+		query := apikey.GetUsageStatsQuery{ApiKeyId: id, OrgId: 2}
+		require.ErrorIs(t, s.GetUsageStats(ctx, &query), apikey.ErrNotFound)
+	})
+
+	t.Run("rollup deletes the usages of deleted keys", func(t *testing.T) {
+		require.NoError(t, s.DeleteApiKey(ctx, &apikey.DeleteCommand{Id: id, OrgId: 1}))
+		require.NoError(t, s.store.RollupAPIKeyUsage(ctx, now))
+		assert.Empty(t, usageRows(t, db))
+	})
+}
+
+func usageRows(t *testing.T, db *sqlstore.SQLStore) []*usageRow {
+	t.Helper()
+	var rows []*usageRow
+	require.NoError(t, db.WithDbSession(context.Background(), func(sess *sqlstore.DBSession) error {
+		return sess.Table("api_key_usage").Find(&rows)
+	}))
+	return rows
+}
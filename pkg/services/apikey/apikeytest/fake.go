@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/util"
 )
 
 type Service struct {
@@ -30,13 +31,107 @@ func (s *Service) GetApiKeyByName(ctx context.Context, query *apikey.GetByNameQu
 func (s *Service) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
 	return s.ExpectedAPIKey, s.ExpectedError
 }
+
+// VerifySecret only supports keys hashed with the legacy algorithm.
+func (s *Service) VerifySecret(ctx context.Context, query *apikey.VerifySecretQuery) error {
+	hashed := query.Key.Key
+	if query.Previous {
+		if query.Key.PreviousKey == nil {
+			return s.ExpectedError
+		}
+		hashed = *query.Key.PreviousKey
+	}
+	if query.Secondary {
+		if query.Key.SecondaryKey == nil {
+			return s.ExpectedError
+		}
+		hashed = *query.Key.SecondaryKey
+	}
+
+	check, err := util.EncodePassword(query.Secret, query.Key.Name)
+	if err != nil {
+		return err
+	}
+	query.Result = check == hashed
+	return s.ExpectedError
+}
+func (s *Service) IntrospectAPIKey(ctx context.Context, query *apikey.IntrospectQuery) error {
+	query.Result = s.ExpectedAPIKey
+	query.Active = s.ExpectedAPIKey != nil
+	return s.ExpectedError
+}
+func (s *Service) ReportLeakedKeys(ctx context.Context, cmd *apikey.ReportLeaksCommand) error {
+	cmd.Result = []*apikey.ReportedLeak{}
+	return s.ExpectedError
+}
+func (s *Service) CheckLeakedKey(ctx context.Context, key *apikey.APIKey, keyString string) (bool, error) {
+	return false, s.ExpectedError
+}
+func (s *Service) VerifySecretScanningSignature(ctx context.Context, keyID, signature string, payload []byte) error {
+	return s.ExpectedError
+}
+func (s *Service) VerifySignature(ctx context.Context, query *apikey.VerifySignatureQuery) error {
+	query.Result = s.ExpectedAPIKey
+	return s.ExpectedError
+}
 func (s *Service) DeleteApiKey(ctx context.Context, cmd *apikey.DeleteCommand) error {
 	return s.ExpectedError
 }
+func (s *Service) DeleteByFilter(ctx context.Context, cmd *apikey.DeleteByFilterCommand) error {
+	cmd.Result = int64(len(s.ExpectedAPIKeys))
+	return s.ExpectedError
+}
 func (s *Service) AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) error {
 	cmd.Result = s.ExpectedAPIKey
 	return s.ExpectedError
 }
-func (s *Service) UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64) error {
+func (s *Service) RotateAPIKey(ctx context.Context, cmd *apikey.RotateCommand) error {
+	cmd.Result = s.ExpectedAPIKey
+	return s.ExpectedError
+}
+func (s *Service) PromoteSecondaryAPIKey(ctx context.Context, cmd *apikey.PromoteSecondaryCommand) error {
+	cmd.Result = s.ExpectedAPIKey
+	return s.ExpectedError
+}
+func (s *Service) RevokeSecondaryAPIKey(ctx context.Context, cmd *apikey.RevokeSecondaryCommand) error {
+	return s.ExpectedError
+}
+func (s *Service) UpdateAPIKey(ctx context.Context, cmd *apikey.UpdateCommand) error {
+	return s.ExpectedError
+}
+func (s *Service) ApproveAPIKey(ctx context.Context, cmd *apikey.ApproveCommand) error {
+	return s.ExpectedError
+}
+func (s *Service) RejectAPIKey(ctx context.Context, cmd *apikey.RejectCommand) error {
+	return s.ExpectedError
+}
+func (s *Service) UpdateAPIKeyLastUsed(ctx context.Context, cmd *apikey.UpdateLastUsedCommand) error {
+	return s.ExpectedError
+}
+func (s *Service) RecordUsage(cmd *apikey.RecordUsageCommand) {}
+func (s *Service) GetSummary(ctx context.Context, query *apikey.GetSummaryQuery) error {
+	query.Result = &apikey.Summary{}
+	return s.ExpectedError
+}
+func (s *Service) GetUsageStats(ctx context.Context, query *apikey.GetUsageStatsQuery) error {
+	query.Result = &apikey.UsageStats{TopRoutes: []apikey.RouteUsage{}}
+	return s.ExpectedError
+}
+func (s *Service) ExportKeys(ctx context.Context, query *apikey.ExportKeysQuery) error {
+	return s.ExpectedError
+}
+func (s *Service) ImportKeys(ctx context.Context, cmd *apikey.ImportKeysCommand) error {
+	cmd.Result = &apikey.ImportKeysResult{}
+	return s.ExpectedError
+}
+func (s *Service) ReportValidation(outcome apikey.ValidationOutcome) {}
+func (s *Service) GetNamingPolicy(ctx context.Context, query *apikey.GetNamingPolicyQuery) error {
+	query.Result = &apikey.NamingPolicy{}
+	return s.ExpectedError
+}
+func (s *Service) SetNamingPolicy(ctx context.Context, cmd *apikey.SetNamingPolicyCommand) error {
+	return s.ExpectedError
+}
+func (s *Service) ProtectMetadata(ctx context.Context, key *apikey.APIKey) error {
 	return s.ExpectedError
 }
@@ -0,0 +1,76 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// SecretScanningKeyIDHeader and SecretScanningSignatureHeader carry the key
+// and the signature of the GitHub secret scanning reports.
+const (
+	SecretScanningKeyIDHeader     = "Github-Public-Key-Identifier"
+	SecretScanningSignatureHeader = "Github-Public-Key-Signature"
+)
+
+// Leak is a key reported as leaked, identified by the hex encoded SHA-256 of
+// the key. ApiKeyId is the revoked key, nil until the leaked key is found.
+type Leak struct {
+	Id       int64
+	Hash     string
+	ApiKeyId *int64 `xorm:"api_key_id"`
+	Source   string
+	Url      string
+	Created  time.Time
+}
+
+func (l Leak) TableName() string { return "api_key_leak" }
+
+// Fingerprint links the LeakHash of a secret to its key, so that the key is
+// found when the hash is reported as leaked. A fingerprint is recorded for
+// every secret generated for a key.
+type Fingerprint struct {
+	Id       int64
+	ApiKeyId int64 `xorm:"api_key_id"`
+	Hash     string
+	Created  time.Time
+}
+
+func (f Fingerprint) TableName() string { return "api_key_fingerprint" }
+
+// LeakHash returns the hash identifying a leaked key.
+func LeakHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// swagger:model
+type ReportLeaksCommand struct {
+	// Leaked API keys or service account tokens. They are revoked right away.
+	Keys []string `json:"keys"`
+	// Hex encoded SHA-256 hashes of leaked keys. The keys they match are
+	// revoked right away, keys created before their secrets were fingerprinted
+	// are revoked when they are used next.
+	Hashes []string `json:"hashes"`
+	// Where the keys were found, for example github.
+	Source string `json:"source"`
+	// Location of the leak.
+	Url    string          `json:"url"`
+	Result []*ReportedLeak `json:"-"`
+}
+
+// ReportedLeak tells whether the key of a reported leak has been found and
+// revoked.
+type ReportedLeak struct {
+	Hash    string `json:"hash"`
+	Revoked bool   `json:"revoked"`
+}
+
+// IsValidLeakHash returns true if the string is a hex encoded SHA-256.
+func IsValidLeakHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
@@ -2,34 +2,296 @@ package apikey
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana/pkg/services/org"
 	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/util"
 )
 
 var (
-	ErrNotFound          = errors.New("API key not found")
-	ErrInvalid           = errors.New("invalid API key")
-	ErrInvalidExpiration = errors.New("negative value for SecondsToLive")
-	ErrDuplicate         = errors.New("API key, organization ID and name must be unique")
+	ErrNotFound            = errors.New("API key not found")
+	ErrInvalid             = errors.New("invalid API key")
+	ErrInvalidExpiration   = errors.New("negative value for SecondsToLive")
+	ErrDuplicate           = errors.New("API key, organization ID and name must be unique")
+	ErrInvalidGracePeriod  = errors.New("negative value for GracePeriodSeconds")
+	ErrEmptyFilter         = errors.New("at least one filter must be set to delete API keys")
+	ErrInvalidIPRange      = errors.New("invalid IP range, expected CIDR notation")
+	ErrInvalidLabel        = errors.New("label name must not be empty")
+	ErrInvalidRateLimit    = errors.New("negative value for RateLimitRPS")
+	ErrQuotaReached        = errors.New("maximum number of active API keys reached for the organization")
+	ErrInvalidBundle       = errors.New("invalid API key export bundle")
+	ErrBundleKey           = errors.New("either a passphrase or a key provider must encrypt the API key export bundle")
+	ErrBundleDecryption    = errors.New("failed to decrypt the API key export bundle, check the passphrase or the key provider")
+	ErrUnknownKeyProvider  = errors.New("unknown key provider, it must be configured in [security.encryption]")
+	ErrInvalidType         = errors.New("invalid API key type, expected bearer or signing")
+	ErrSignatureExpired    = errors.New("request signature timestamp is too far from the current time")
+	ErrNamingPolicy        = errors.New("API key name does not follow the naming policy of the organization")
+	ErrInvalidPolicy       = errors.New("invalid API key naming policy")
+	ErrNoSecondary         = errors.New("API key has no secondary secret")
+	ErrSecondarySigning    = errors.New("signing API keys cannot have a secondary secret")
+	ErrInvalidLeakHash     = errors.New("invalid leaked API key hash, expected a hex encoded SHA-256")
+	ErrInvalidSignature    = errors.New("invalid secret scanning signature")
+	ErrInvalidDatasource   = errors.New("invalid data source UID")
+	ErrTeamNotFound        = errors.New("owner team not found")
+	ErrInvalidAccessWindow = errors.New("invalid API key access window")
+	ErrLeaked              = errors.New("API key has been revoked because it leaked")
 )
 
 type APIKey struct {
-	Id               int64
-	OrgId            int64
-	Name             string
-	Key              string
-	Role             org.RoleType
-	Created          time.Time
-	Updated          time.Time
-	LastUsedAt       *time.Time `xorm:"last_used_at"`
-	Expires          *int64
-	ServiceAccountId *int64
+	Id         int64
+	OrgId      int64
+	Name       string
+	Key        string
+	Role       org.RoleType
+	Created    time.Time
+	Updated    time.Time
+	LastUsedAt *time.Time `xorm:"last_used_at"`
+	// LastUsedIP and LastUsedUserAgent describe the client of the last
+	// request authenticated with the key.
+	LastUsedIP        string `xorm:"last_used_ip"`
+	LastUsedUserAgent string `xorm:"last_used_user_agent"`
+	Expires           *int64
+	ServiceAccountId  *int64
+	CreatedBy         int64
+	// OwnerTeamId is the team managing the key, the admins of the team can
+	// manage it whoever created it. The key belongs to the organization when
+	// it is nil.
+	OwnerTeamId *int64 `xorm:"owner_team_id"`
+	// PreviousKey is the hash replaced by the last rotation. It stays valid
+	// until PreviousKeyExpires so that clients can switch over to the new key.
+	PreviousKey        *string
+	PreviousKeyExpires *int64
+	// SecondaryKey is the hash of the next secret of the key. Both secrets
+	// are valid until the secondary one is promoted or revoked.
+	SecondaryKey *string `xorm:"secondary_key"`
+	// AllowedIPRanges is a comma separated list of CIDRs the key can be used
+	// from. The key can be used from anywhere when it is empty.
+	AllowedIPRanges string `xorm:"allowed_ip_ranges"`
+	// DatasourceUIDs is a comma separated list of the UIDs of the data sources
+	// the key can query. The key can query any data source its role allows
+	// when it is empty.
+	DatasourceUIDs string `xorm:"datasource_uids"`
+	// AccessWindows are the periods of time during which the key can be
+	// used. The key can be used at any time when it is empty.
+	AccessWindows []AccessWindow `xorm:"access_windows"`
+	Labels        map[string]string
+	// RateLimitRPS is the number of requests per second allowed with the key,
+	// requests are not limited when it is zero.
+	RateLimitRPS int64 `xorm:"rate_limit_rps"`
+	// Status is StatusPending for keys waiting for the approval of an
+	// organization admin, keys with any other status are active.
+	Status string
+	// Type is TypeSigning for keys whose secret signs requests instead of
+	// being sent with them, keys with any other type are bearer keys.
+	Type string `xorm:"key_type"`
+	// SigningSecret is the secret of a signing key, encrypted with the
+	// secrets service and base64 encoded.
+	SigningSecret string `xorm:"signing_secret"`
+	// PreviousSigningSecret is the encrypted secret replaced by the last
+	// rotation of a signing key. Like PreviousKey, it verifies signatures
+	// until PreviousKeyExpires.
+	PreviousSigningSecret *string `xorm:"previous_signing_secret"`
+	// Fingerprinted is set when all the secrets of the key have a
+	// fingerprint. Their leaks are then revoked when they are reported, and
+	// the requests authenticated with the key need not look them up.
+	Fingerprinted bool `xorm:"fingerprinted"`
+	// EncryptedMetadata is a copy of the labels and access windows of the
+	// key, encrypted with the secrets service and base64 encoded, empty when
+	// the key has none. It is the only copy of service account tokens, whose
+	// labels and access_windows columns are left empty.
+	EncryptedMetadata string `xorm:"encrypted_metadata"`
+}
+
+// Metadata is the JSON payload of a key kept by its rotations. It never
+// contains the secret of the key.
+type Metadata struct {
+	Labels        map[string]string `json:"labels,omitempty"`
+	AccessWindows []AccessWindow    `json:"accessWindows,omitempty"`
+}
+
+// HasMetadata returns true if the key has labels or access windows.
+func (k APIKey) HasMetadata() bool {
+	return len(k.Labels) > 0 || len(k.AccessWindows) > 0
+}
+
+const (
+	StatusActive  = "active"
+	StatusPending = "pending"
+)
+
+const (
+	TypeBearer  = "bearer"
+	TypeSigning = "signing"
+)
+
+// IsSigning returns true if the key can only authenticate signed requests.
+func (k APIKey) IsSigning() bool {
+	return k.Type == TypeSigning
+}
+
+// IsPending returns true if the key cannot be used until it is approved.
+func (k APIKey) IsPending() bool {
+	return k.Status == StatusPending
 }
 
 func (k APIKey) TableName() string { return "api_key" }
 
+// CheckUsable returns ValidationSucceeded if the key, whose secret or
+// signature is valid, can authenticate requests at the given time, and the
+// outcome of the failed check otherwise. The checks depending on the request,
+// such as the allowed IP ranges and the rate limit, are left to the caller.
+func (k APIKey) CheckUsable(now time.Time) ValidationOutcome {
+	if k.Expires != nil && *k.Expires <= now.Unix() {
+		return ValidationExpired
+	}
+	if k.IsPending() {
+		return ValidationPending
+	}
+	if !k.IsWithinAccessWindow(now) {
+		return ValidationOutsideWindow
+	}
+	return ValidationSucceeded
+}
+
+// IsAllowedIP returns true if the key can be used from the given IP address.
+func (k APIKey) IsAllowedIP(ip net.IP) bool {
+	if k.AllowedIPRanges == "" {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+
+	for _, r := range strings.Split(k.AllowedIPRanges, ",") {
+		_, ipNet, err := net.ParseCIDR(r)
+		if err != nil {
+			continue
+		}
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsWithinAccessWindow returns true if the key can be used at the given time.
+func (k APIKey) IsWithinAccessWindow(t time.Time) bool {
+	if len(k.AccessWindows) == 0 {
+		return true
+	}
+	for _, w := range k.AccessWindows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedDatasources returns the UIDs of the data sources the key can query,
+// nil when the key is not restricted to some data sources.
+func (k APIKey) AllowedDatasources() []string {
+	if k.DatasourceUIDs == "" {
+		return nil
+	}
+	return strings.Split(k.DatasourceUIDs, ",")
+}
+
+// ValidateLabels checks that every label has a name.
+func ValidateLabels(labels map[string]string) error {
+	for name := range labels {
+		if strings.TrimSpace(name) == "" {
+			return ErrInvalidLabel
+		}
+	}
+	return nil
+}
+
+// ValidateDatasourceUIDs checks that every data source UID is a valid UID.
+func ValidateDatasourceUIDs(uids []string) error {
+	for _, uid := range uids {
+		if uid == "" || !util.IsValidShortUID(uid) {
+			return fmt.Errorf("%w: %q", ErrInvalidDatasource, uid)
+		}
+	}
+	return nil
+}
+
+// ValidateIPRanges checks that every range is in CIDR notation.
+func ValidateIPRanges(ranges []string) error {
+	for _, r := range ranges {
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidIPRange, r)
+		}
+	}
+	return nil
+}
+
+// NamingPolicy constrains the names of the API keys created in an
+// organization. Empty fields do not constrain names.
+// swagger:model
+type NamingPolicy struct {
+	// Regular expression the names must match
+	// example: ^ci-[a-z]+-[a-z]+$
+	Pattern string `json:"pattern"`
+	// Prefix the names must start with
+	// example: ci-
+	RequiredPrefix string `json:"requiredPrefix"`
+	// Words the names must not contain, case insensitive
+	// example: ["test","tmp"]
+	ForbiddenWords []string `json:"forbiddenWords"`
+}
+
+// Validate checks that the pattern of the policy is a valid regular expression.
+func (p *NamingPolicy) Validate() error {
+	if _, err := regexp.Compile(p.Pattern); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPolicy, err)
+	}
+	for _, word := range p.ForbiddenWords {
+		if strings.TrimSpace(word) == "" {
+			return fmt.Errorf("%w: forbidden words must not be empty", ErrInvalidPolicy)
+		}
+	}
+	return nil
+}
+
+// Check returns an error describing why name does not follow the policy.
+func (p *NamingPolicy) Check(name string) error {
+	if p.RequiredPrefix != "" && !strings.HasPrefix(name, p.RequiredPrefix) {
+		return fmt.Errorf("%w: name must start with %q", ErrNamingPolicy, p.RequiredPrefix)
+	}
+	if p.Pattern != "" {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidPolicy, err)
+		}
+		if !re.MatchString(name) {
+			return fmt.Errorf("%w: name must match %q", ErrNamingPolicy, p.Pattern)
+		}
+	}
+	lower := strings.ToLower(name)
+	for _, word := range p.ForbiddenWords {
+		if strings.Contains(lower, strings.ToLower(word)) {
+			return fmt.Errorf("%w: name must not contain %q", ErrNamingPolicy, word)
+		}
+	}
+	return nil
+}
+
+type GetNamingPolicyQuery struct {
+	OrgId  int64
+	Result *NamingPolicy
+}
+
+type SetNamingPolicyCommand struct {
+	OrgId  int64
+	Policy NamingPolicy
+}
+
 // swagger:model
 type AddCommand struct {
 	Name          string       `json:"name" binding:"Required"`
@@ -37,17 +299,301 @@ type AddCommand struct {
 	OrgId         int64        `json:"-"`
 	Key           string       `json:"-"`
 	SecondsToLive int64        `json:"secondsToLive"`
-	Result        *APIKey      `json:"-"`
+	// List of CIDRs the key can be used from. The key can be used from
+	// anywhere when omitted.
+	AllowedIPRanges []string `json:"allowedIpRanges"`
+	// UIDs of the data sources the key can query. The key can query any data
+	// source its role allows when omitted.
+	DatasourceUIDs []string `json:"datasourceUids"`
+	// Periods of time during which the key can be used. The key can be used
+	// at any time when omitted.
+	AccessWindows []AccessWindow `json:"accessWindows"`
+	// Labels used to organize and search keys.
+	Labels map[string]string `json:"labels"`
+	// Number of requests per second allowed with the key. Requests are not
+	// limited when omitted.
+	RateLimitRPS int64 `json:"rateLimitRps"`
+	CreatedBy    int64 `json:"-"`
+	// ID of the team owning the key. The admins of the team can manage the
+	// key, the key belongs to the organization when omitted.
+	OwnerTeamId int64 `json:"ownerTeamId"`
+	// Type of the key, bearer when omitted. The secret of a signing key is
+	// never sent to Grafana, it signs the requests instead.
+	Type string `json:"type"`
+	// Secret is the secret of the key, the service hashes it with the
+	// configured algorithm into Key. Key is stored as is when omitted.
+	Secret string `json:"-"`
+	// SigningSecret is the secret of a signing key, the service encrypts it
+	// before it is stored.
+	SigningSecret string `json:"-"`
+	// Fingerprint is the LeakHash of the secret of the key.
+	Fingerprint string `json:"-"`
+	// EncryptedMetadata is the encrypted copy of the labels and the access
+	// windows, set by the service.
+	EncryptedMetadata string `json:"-"`
+	// Pending creates the key in the pending state, or puts back the key it
+	// replaces in it. The key cannot be used until an organization admin
+	// approves it.
+	Pending bool `json:"-"`
+	// Upsert replaces the secret and the expiration of the key with the same
+	// name when it exists, instead of failing with ErrDuplicate.
+	Upsert bool `json:"upsert"`
+	// Replaced is set when Upsert replaced an existing key.
+	Replaced bool    `json:"-"`
+	Result   *APIKey `json:"-"`
+}
+
+// swagger:model
+type UpdateCommand struct {
+	// List of CIDRs the key can be used from. An empty list lifts the
+	// restriction, the ranges are left unchanged when omitted.
+	AllowedIPRanges []string `json:"allowedIpRanges"`
+	// UIDs of the data sources the key can query. An empty list lifts the
+	// restriction, the data sources are left unchanged when omitted.
+	DatasourceUIDs []string `json:"datasourceUids"`
+	// Periods of time during which the key can be used. An empty list lifts
+	// the restriction, the windows are left unchanged when omitted.
+	AccessWindows []AccessWindow `json:"accessWindows"`
+	// Number of requests per second allowed with the key. Zero lifts the
+	// limit, the limit is left unchanged when omitted.
+	RateLimitRPS *int64 `json:"rateLimitRps"`
+	// EncryptedMetadata is the new encrypted copy of the labels and the
+	// access windows, set by the service when the access windows change.
+	EncryptedMetadata *string `json:"-"`
+	Id                int64   `json:"-"`
+	OrgId             int64   `json:"-"`
+}
+
+// swagger:model
+type RotateCommand struct {
+	// Number of seconds the replaced key remains valid. Defaults to the
+	// configured api_key_rotation_grace_period when omitted.
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds"`
+	// Store the new secret as the secondary secret of the key instead of
+	// replacing the current one. Both secrets are valid until the secondary
+	// secret is promoted or revoked. Not supported by signing keys.
+	Secondary bool   `json:"secondary"`
+	Id        int64  `json:"-"`
+	OrgId     int64  `json:"-"`
+	Key       string `json:"-"`
+	// Secret is the new secret of the key, the service hashes it with the
+	// configured algorithm into Key. Key is stored as is when omitted.
+	Secret string `json:"-"`
+	// SigningSecret is the new secret of a signing key, the service encrypts
+	// it before it is stored.
+	SigningSecret string `json:"-"`
+	// Fingerprint is the LeakHash of the new secret.
+	Fingerprint string `json:"-"`
+	// Pending puts the key back in the pending state, none of its secrets
+	// can be used until an organization admin approves it again.
+	Pending bool `json:"-"`
+	// ActorId is the user rotating the key, 0 for the CLI.
+	ActorId int64   `json:"-"`
+	Result  *APIKey `json:"-"`
+}
+
+// swagger:model
+type PromoteSecondaryCommand struct {
+	// Number of seconds the replaced secret remains valid. Defaults to the
+	// configured api_key_rotation_grace_period when omitted.
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds"`
+	Id                 int64  `json:"-"`
+	OrgId              int64  `json:"-"`
+	// ActorId is the user promoting the secret, 0 for the CLI.
+	ActorId int64   `json:"-"`
+	Result  *APIKey `json:"-"`
+}
+
+// RevokeSecondaryCommand invalidates the secondary secret of an API key.
+type RevokeSecondaryCommand struct {
+	Id    int64
+	OrgId int64
 }
 
 type DeleteCommand struct {
 	Id    int64 `json:"id"`
 	OrgId int64 `json:"-"`
+	// ActorId is the user deleting the key, 0 for the CLI.
+	ActorId int64 `json:"-"`
+}
+
+// ApproveCommand activates a pending API key.
+type ApproveCommand struct {
+	Id    int64
+	OrgId int64
+}
+
+// RejectCommand deletes a pending API key.
+type RejectCommand struct {
+	Id      int64
+	OrgId   int64
+	ActorId int64
+}
+
+// DeleteByFilterCommand deletes all the API keys of an organization matching
+// every filter that is set.
+type DeleteByFilterCommand struct {
+	OrgId          int64
+	NamePrefix     string
+	CreatedBy      int64
+	LastUsedBefore *time.Time
+	ActorId        int64
+	Result         int64
+}
+
+// VerifySecretQuery checks the secret of an API key against its hash.
+type VerifySecretQuery struct {
+	Key    *APIKey
+	Secret string
+	// Previous checks the secret against the hash replaced by the last
+	// rotation instead of the current one.
+	Previous bool
+	// Secondary checks the secret against the hash of the secondary secret
+	// instead of the current one.
+	Secondary bool
+	Result    bool
+}
+
+// swagger:model
+type IntrospectQuery struct {
+	// The API key or service account token to describe, in full: the
+	// first characters of the keys do not identify them.
+	Key string `json:"key"`
+	// OrgId is the organization of the caller, keys of other organizations
+	// are reported as inactive.
+	OrgId int64 `json:"-"`
+	// Result is the key when the secret is valid, nil otherwise.
+	Result *APIKey `json:"-"`
+	// Active is true when the key can authenticate requests.
+	Active bool `json:"-"`
+}
+
+// VerifySignatureQuery checks the signature of a request signed with a
+// signing key. BodyHash is the hex encoded SHA-256 hash of the request body.
+type VerifySignatureQuery struct {
+	ApiKeyId  int64
+	Timestamp int64
+	Signature string
+	Method    string
+	URI       string
+	BodyHash  string
+	Result    *APIKey
+}
+
+// UpdateLastUsedCommand records a request authenticated with an API key.
+type UpdateLastUsedCommand struct {
+	Id        int64
+	IP        string
+	UserAgent string
+	Time      time.Time
+}
+
+// RecordUsageCommand records a request authenticated with an API key, for
+// the usage statistics of the key. Route is the pattern of the route called,
+// not its path, to keep the number of distinct routes low.
+type RecordUsageCommand struct {
+	Id    int64
+	OrgId int64
+	Route string
+	Time  time.Time
+}
+
+// GetUsageStatsQuery returns the usage statistics of a key.
+type GetUsageStatsQuery struct {
+	ApiKeyId int64
+	OrgId    int64
+	Result   *UsageStats
+}
+
+// GetSummaryQuery returns the number of API keys of an organization by
+// status.
+type GetSummaryQuery struct {
+	OrgId  int64
+	Result *Summary
+}
+
+// Summary counts the API keys of an organization, service account tokens
+// excluded. Expiring soon and never used keys are active keys.
+type Summary struct {
+	Total   int64 `json:"total"`
+	Active  int64 `json:"active"`
+	Pending int64 `json:"pending"`
+	Expired int64 `json:"expired"`
+	// ExpiringSoon is the number of keys expiring within the expiry
+	// notification window, or within SummaryExpiringSoonWindow when no
+	// notification window is configured.
+	ExpiringSoon int64 `json:"expiringSoon"`
+	NeverUsed    int64 `json:"neverUsed"`
+}
+
+// SummaryExpiringSoonWindow is the default window of the keys counted as
+// expiring soon.
+const SummaryExpiringSoonWindow = 7 * 24 * time.Hour
+
+// UsageStats are the number of requests authenticated with a key over the
+// last 24 hours, 7 days and 30 days, and the routes it called the most over
+// the last 30 days.
+type UsageStats struct {
+	Requests24h int64        `json:"requests24h"`
+	Requests7d  int64        `json:"requests7d"`
+	Requests30d int64        `json:"requests30d"`
+	TopRoutes   []RouteUsage `json:"topRoutes"`
+}
+
+type RouteUsage struct {
+	Route    string `json:"route"`
+	Requests int64  `json:"requests"`
+}
+
+// ValidationOutcome is the outcome of the authentication of a request with an
+// API key, reported for monitoring.
+type ValidationOutcome string
+
+const (
+	ValidationSucceeded ValidationOutcome = "success"
+	// ValidationInvalid is reported for unknown keys and wrong secrets.
+	ValidationInvalid      ValidationOutcome = "invalid"
+	ValidationExpired      ValidationOutcome = "expired"
+	ValidationIPNotAllowed ValidationOutcome = "ip_not_allowed"
+	ValidationRateLimited  ValidationOutcome = "rate_limited"
+	ValidationPending      ValidationOutcome = "pending"
+	// ValidationOutsideWindow is reported for keys used outside of their
+	// access windows.
+	ValidationOutsideWindow ValidationOutcome = "outside_access_window"
+	ValidationError         ValidationOutcome = "error"
+)
+
+// ExportKeysQuery exports API keys to a bundle encrypted with exactly one of
+// Passphrase and KeyProvider. Keys of all organizations are exported when
+// OrgIds is empty.
+type ExportKeysQuery struct {
+	OrgIds     []int64
+	Passphrase string
+	// KeyProvider is the id of an encryption provider of the instance, e.g. awskms.v1.my-key
+	KeyProvider string
+	Result      []byte
+}
+
+// ImportKeysCommand imports the API keys of a bundle produced by ExportKeys.
+// Keys are imported in the organization they were exported from. Passphrase
+// is required for bundles that were encrypted with one.
+type ImportKeysCommand struct {
+	Bundle     []byte
+	Passphrase string
+	Result     *ImportKeysResult
+}
+
+type ImportKeysResult struct {
+	Imported int
+	// Skipped is the number of keys that already exist in the instance.
+	Skipped int
 }
 
 type GetApiKeysQuery struct {
 	OrgId          int64
 	IncludeExpired bool
+	Labels         map[string]string // only keys having all the labels are returned
+	Query          string            // only keys whose name, label names or values, or creator contain it are returned
 	User           *user.SignedInUser
 	Result         []*APIKey
 }
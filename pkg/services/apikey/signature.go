@@ -0,0 +1,42 @@
+package apikey
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of a request signed with a signing key.
+const (
+	SignatureKeyIDHeader     = "X-Grafana-Key-Id"
+	SignatureTimestampHeader = "X-Grafana-Timestamp"
+	SignatureHeader          = "X-Grafana-Signature"
+)
+
+// SignatureMaxSkew is how far the timestamp of a signed request can be from
+// the current time, it limits how long a captured request can be replayed.
+const SignatureMaxSkew = 5 * time.Minute
+
+// StringToSign returns the string signed by clients: the method, the path and
+// query of the request, the Unix timestamp of the signature and the hex
+// encoded SHA-256 hash of the body, separated by new lines.
+func StringToSign(method, uri string, timestamp int64, bodyHash string) string {
+	return strings.Join([]string{strings.ToUpper(method), uri, strconv.FormatInt(timestamp, 10), bodyHash}, "\n")
+}
+
+// HashBody returns the hex encoded SHA-256 hash of a request body.
+func HashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the string to sign, with the
+// secret of a signing key as returned when the key was created.
+func Sign(secret, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
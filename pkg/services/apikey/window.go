@@ -0,0 +1,104 @@
+package apikey
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// accessWindowTimeLayout is the layout of the start and end of access windows.
+const accessWindowTimeLayout = "15:04"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// AccessWindow is a daily period of time, in UTC, during which a key can be
+// used.
+// swagger:model
+type AccessWindow struct {
+	// Days of the week of the window, as the first three letters of their
+	// English name. The window applies every day when omitted.
+	// example: ["mon","tue","wed","thu","fri"]
+	Days []string `json:"days,omitempty"`
+	// Start of the window in the HH:MM format, in UTC
+	// example: 02:00
+	Start string `json:"start"`
+	// End of the window in the HH:MM format, in UTC, excluded. A window
+	// ending before it starts ends the next day, a window ending when it
+	// starts lasts the whole day.
+	// example: 04:00
+	End string `json:"end"`
+}
+
+// Contains returns true if t is within the window. The day of a window that
+// ends the next day is the day it starts.
+func (w AccessWindow) Contains(t time.Time) bool {
+	start, errStart := parseWindowTime(w.Start)
+	end, errEnd := parseWindowTime(w.End)
+	if errStart != nil || errEnd != nil {
+		return false
+	}
+
+	t = t.UTC()
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if end == start {
+		return w.onDay(day)
+	}
+	if end > start {
+		return minute >= start && minute < end && w.onDay(day)
+	}
+	// the window spans midnight
+	if minute >= start {
+		return w.onDay(day)
+	}
+	return minute < end && w.onDay((day+6)%7)
+}
+
+func (w AccessWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWindowTime returns the number of minutes since midnight of a time in
+// the HH:MM format.
+func parseWindowTime(value string) (int, error) {
+	t, err := time.Parse(accessWindowTimeLayout, value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ValidateAccessWindows checks that the days, the start and the end of every
+// window are valid.
+func ValidateAccessWindows(windows []AccessWindow) error {
+	for _, w := range windows {
+		for _, d := range w.Days {
+			if _, ok := weekdays[strings.ToLower(d)]; !ok {
+				return fmt.Errorf("%w: unknown day %q", ErrInvalidAccessWindow, d)
+			}
+		}
+		if _, err := parseWindowTime(w.Start); err != nil {
+			return fmt.Errorf("%w: invalid start %q, expected HH:MM", ErrInvalidAccessWindow, w.Start)
+		}
+		if _, err := parseWindowTime(w.End); err != nil {
+			return fmt.Errorf("%w: invalid end %q, expected HH:MM", ErrInvalidAccessWindow, w.End)
+		}
+	}
+	return nil
+}
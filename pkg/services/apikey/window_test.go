@@ -0,0 +1,59 @@
+package apikey
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessWindow(t *testing.T) {
+	// 2022-10-17 is a Monday
+	at := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		desc     string
+		window   AccessWindow
+		time     time.Time
+		expected bool
+	}{
+		{desc: "within a daily window", window: AccessWindow{Start: "02:00", End: "04:00"}, time: at("2022-10-17T03:59:00Z"), expected: true},
+		{desc: "at the start of a window", window: AccessWindow{Start: "02:00", End: "04:00"}, time: at("2022-10-17T02:00:00Z"), expected: true},
+		{desc: "at the end of a window", window: AccessWindow{Start: "02:00", End: "04:00"}, time: at("2022-10-17T04:00:00Z"), expected: false},
+		{desc: "in another time zone", window: AccessWindow{Start: "02:00", End: "04:00"}, time: at("2022-10-17T05:30:00+02:00"), expected: true},
+		{desc: "on a day of the window", window: AccessWindow{Days: []string{"mon", "Tue"}, Start: "02:00", End: "04:00"}, time: at("2022-10-18T03:00:00Z"), expected: true},
+		{desc: "on another day", window: AccessWindow{Days: []string{"sat", "sun"}, Start: "02:00", End: "04:00"}, time: at("2022-10-17T03:00:00Z"), expected: false},
+		{desc: "before midnight of a window spanning midnight", window: AccessWindow{Days: []string{"sun"}, Start: "22:00", End: "02:00"}, time: at("2022-10-16T23:00:00Z"), expected: true},
+		{desc: "after midnight of a window spanning midnight", window: AccessWindow{Days: []string{"sun"}, Start: "22:00", End: "02:00"}, time: at("2022-10-17T01:00:00Z"), expected: true},
+		{desc: "after midnight of the day before the window", window: AccessWindow{Days: []string{"mon"}, Start: "22:00", End: "02:00"}, time: at("2022-10-17T01:00:00Z"), expected: false},
+		{desc: "whole day window", window: AccessWindow{Days: []string{"mon"}, Start: "00:00", End: "00:00"}, time: at("2022-10-17T12:00:00Z"), expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.window.Contains(tt.time))
+		})
+	}
+
+	t.Run("keys without windows can be used at any time", func(t *testing.T) {
+		assert.True(t, APIKey{}.IsWithinAccessWindow(at("2022-10-17T12:00:00Z")))
+	})
+
+	t.Run("keys can be used during any of their windows", func(t *testing.T) {
+		key := APIKey{AccessWindows: []AccessWindow{{Start: "02:00", End: "04:00"}, {Start: "12:00", End: "13:00"}}}
+		assert.True(t, key.IsWithinAccessWindow(at("2022-10-17T12:30:00Z")))
+		assert.False(t, key.IsWithinAccessWindow(at("2022-10-17T10:00:00Z")))
+	})
+}
+
+func TestValidateAccessWindows(t *testing.T) {
+	assert.NoError(t, ValidateAccessWindows([]AccessWindow{{Days: []string{"mon", "FRI"}, Start: "22:00", End: "02:30"}}))
+	assert.ErrorIs(t, ValidateAccessWindows([]AccessWindow{{Days: []string{"monday"}, Start: "02:00", End: "04:00"}}), ErrInvalidAccessWindow)
+	assert.ErrorIs(t, ValidateAccessWindows([]AccessWindow{{Start: "2am", End: "04:00"}}), ErrInvalidAccessWindow)
+	assert.ErrorIs(t, ValidateAccessWindows([]AccessWindow{{Start: "02:00", End: "24:00"}}), ErrInvalidAccessWindow)
+}
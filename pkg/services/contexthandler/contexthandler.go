@@ -2,8 +2,10 @@
 package contexthandler
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -144,6 +146,7 @@ func (h *ContextHandler) Middleware(mContext *web.Context) {
 	// then test if anonymous access is enabled
 	switch {
 	case h.initContextWithRenderAuth(reqContext):
+	case h.initContextWithSignedRequest(reqContext):
 	case h.initContextWithAPIKey(reqContext):
 	case h.initContextWithBasicAuth(reqContext, orgID):
 	case h.initContextWithAuthProxy(reqContext, orgID):
@@ -221,17 +224,53 @@ func (h *ContextHandler) getAPIKey(ctx context.Context, keyString string) (*apik
 	}
 
 	// validate api key
-	isValid, err := apikeygen.IsValid(decoded, keyQuery.Result.Key)
-	if err != nil {
+	validQuery := apikey.VerifySecretQuery{Key: keyQuery.Result, Secret: decoded.Key}
+	if err := h.apiKeyService.VerifySecret(ctx, &validQuery); err != nil {
 		return nil, err
 	}
-	if !isValid {
+	if !validQuery.Result && keyQuery.Result.SecondaryKey != nil {
+		// the client might already use the next secret of the key
+		validQuery.Secondary = true
+		if err := h.apiKeyService.VerifySecret(ctx, &validQuery); err != nil {
+			return nil, err
+		}
+		validQuery.Secondary = false
+	}
+	if !validQuery.Result && h.isPreviousKeyActive(keyQuery.Result) {
+		// the key might have been rotated recently and still be in its grace period
+		validQuery.Previous = true
+		if err := h.apiKeyService.VerifySecret(ctx, &validQuery); err != nil {
+			return nil, err
+		}
+	}
+	if !validQuery.Result {
 		return nil, apikeygen.ErrInvalidApiKey
 	}
 
 	return keyQuery.Result, nil
 }
 
+func (h *ContextHandler) isPreviousKeyActive(key *apikey.APIKey) bool {
+	if key.PreviousKey == nil || key.PreviousKeyExpires == nil {
+		return false
+	}
+
+	getTime := h.GetTime
+	if getTime == nil {
+		getTime = time.Now
+	}
+	return *key.PreviousKeyExpires > getTime().Unix()
+}
+
+func newLastUsedCommand(reqContext *models.ReqContext, key *apikey.APIKey, now time.Time) *apikey.UpdateLastUsedCommand {
+	return &apikey.UpdateLastUsedCommand{
+		Id:        key.Id,
+		IP:        reqContext.RemoteAddr(),
+		UserAgent: reqContext.Req.UserAgent(),
+		Time:      now,
+	}
+}
+
 func (h *ContextHandler) initContextWithAPIKey(reqContext *models.ReqContext) bool {
 	header := reqContext.Req.Header.Get("Authorization")
 	parts := strings.SplitN(header, " ", 2)
@@ -253,46 +292,171 @@ func (h *ContextHandler) initContextWithAPIKey(reqContext *models.ReqContext) bo
 	defer span.End()
 
 	var (
-		apikey *apikey.APIKey
+		key    *apikey.APIKey
 		errKey error
 	)
 	if strings.HasPrefix(keyString, apikeygenprefix.GrafanaPrefix) {
-		apikey, errKey = h.getPrefixedAPIKey(reqContext.Req.Context(), keyString) // decode prefixed key
+		key, errKey = h.getPrefixedAPIKey(reqContext.Req.Context(), keyString) // decode prefixed key
 	} else {
-		apikey, errKey = h.getAPIKey(reqContext.Req.Context(), keyString) // decode legacy api key
+		key, errKey = h.getAPIKey(reqContext.Req.Context(), keyString) // decode legacy api key
 	}
 
 	if errKey != nil {
 		status := http.StatusInternalServerError
+		outcome := apikey.ValidationError
 		if errors.Is(errKey, apikeygen.ErrInvalidApiKey) {
 			status = http.StatusUnauthorized
+			outcome = apikey.ValidationInvalid
+		} else if errors.Is(errKey, apikey.ErrInvalid) {
+			// the key does not exist
+			outcome = apikey.ValidationInvalid
 		}
+		h.apiKeyService.ReportValidation(outcome)
 		reqContext.JsonApiErr(status, InvalidAPIKey, errKey)
 		return true
 	}
 
-	// check for expiration
+	// keys reported as leaked are revoked, even when they are still valid
+	leaked, err := h.apiKeyService.CheckLeakedKey(reqContext.Req.Context(), key, keyString)
+	if err != nil {
+		h.apiKeyService.ReportValidation(apikey.ValidationError)
+		reqContext.JsonApiErr(http.StatusInternalServerError, InvalidAPIKey, err)
+		return true
+	}
+	if leaked {
+		h.apiKeyService.ReportValidation(apikey.ValidationInvalid)
+		reqContext.JsonApiErr(http.StatusUnauthorized, apikey.ErrLeaked.Error(), nil)
+		return true
+	}
+
+	// the secret of a signing key must never be sent
+	if key.IsSigning() {
+		h.apiKeyService.ReportValidation(apikey.ValidationInvalid)
+		reqContext.JsonApiErr(http.StatusUnauthorized, "API key only accepts signed requests", nil)
+		return true
+	}
+
+	return h.authenticateAPIKey(reqContext, key)
+}
+
+// initContextWithSignedRequest authenticates requests signed with a signing
+// key, whose secret is not sent with the request.
+func (h *ContextHandler) initContextWithSignedRequest(reqContext *models.ReqContext) bool {
+	keyID := reqContext.Req.Header.Get(apikey.SignatureKeyIDHeader)
+	if keyID == "" {
+		return false
+	}
+
+	_, span := h.tracer.Start(reqContext.Req.Context(), "initContextWithSignedRequest")
+	defer span.End()
+
+	query := apikey.VerifySignatureQuery{
+		Signature: reqContext.Req.Header.Get(apikey.SignatureHeader),
+		Method:    reqContext.Req.Method,
+		URI:       reqContext.Req.URL.RequestURI(),
+	}
+	id, errID := strconv.ParseInt(keyID, 10, 64)
+	timestamp, errTimestamp := strconv.ParseInt(reqContext.Req.Header.Get(apikey.SignatureTimestampHeader), 10, 64)
+	if errID != nil || errTimestamp != nil || query.Signature == "" {
+		h.apiKeyService.ReportValidation(apikey.ValidationInvalid)
+		reqContext.JsonApiErr(http.StatusUnauthorized, "Invalid request signature headers", nil)
+		return true
+	}
+	query.ApiKeyId = id
+	query.Timestamp = timestamp
+
+	// the body is read to be hashed, handlers read it again
+	var body []byte
+	if reqContext.Req.Body != nil {
+		var err error
+		limit := h.Cfg.ApiKeySignedRequestMaxBodyBytes
+		body, err = io.ReadAll(http.MaxBytesReader(reqContext.Resp, reqContext.Req.Body, limit))
+		// the reader fails once the limit is read for bodies that are larger
+		if err != nil && int64(len(body)) >= limit {
+			h.apiKeyService.ReportValidation(apikey.ValidationInvalid)
+			reqContext.JsonApiErr(http.StatusRequestEntityTooLarge, "Request body too large", nil)
+			return true
+		}
+		if err != nil {
+			h.apiKeyService.ReportValidation(apikey.ValidationError)
+			reqContext.JsonApiErr(http.StatusBadRequest, "Failed to read request body", err)
+			return true
+		}
+		reqContext.Req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	query.BodyHash = apikey.HashBody(body)
+
+	if err := h.apiKeyService.VerifySignature(reqContext.Req.Context(), &query); err != nil {
+		switch {
+		case errors.Is(err, apikey.ErrSignatureExpired):
+			h.apiKeyService.ReportValidation(apikey.ValidationInvalid)
+			reqContext.JsonApiErr(http.StatusUnauthorized, "Request signature expired", nil)
+		case errors.Is(err, apikey.ErrLeaked):
+			h.apiKeyService.ReportValidation(apikey.ValidationInvalid)
+			reqContext.JsonApiErr(http.StatusUnauthorized, apikey.ErrLeaked.Error(), nil)
+		case errors.Is(err, apikey.ErrInvalid):
+			h.apiKeyService.ReportValidation(apikey.ValidationInvalid)
+			reqContext.JsonApiErr(http.StatusUnauthorized, InvalidAPIKey, err)
+		default:
+			h.apiKeyService.ReportValidation(apikey.ValidationError)
+			reqContext.JsonApiErr(http.StatusInternalServerError, InvalidAPIKey, err)
+		}
+		return true
+	}
+
+	return h.authenticateAPIKey(reqContext, query.Result)
+}
+
+// unusableAPIKeyMessages are the errors returned for the outcomes of
+// apikey.APIKey.CheckUsable.
+var unusableAPIKeyMessages = map[apikey.ValidationOutcome]string{
+	apikey.ValidationExpired:       "Expired API key",
+	apikey.ValidationPending:       "API key is pending approval",
+	apikey.ValidationOutsideWindow: "API key cannot be used outside of its access windows",
+}
+
+// authenticateAPIKey checks that a key whose secret or signature is valid can
+// be used for the request, and signs the request in with it.
+func (h *ContextHandler) authenticateAPIKey(reqContext *models.ReqContext, key *apikey.APIKey) bool {
 	getTime := h.GetTime
 	if getTime == nil {
 		getTime = time.Now
 	}
-	if apikey.Expires != nil && *apikey.Expires <= getTime().Unix() {
-		reqContext.JsonApiErr(http.StatusUnauthorized, "Expired API key", nil)
+	// check for expiration, approval and access windows
+	if outcome := key.CheckUsable(getTime()); outcome != apikey.ValidationSucceeded {
+		h.apiKeyService.ReportValidation(outcome)
+		reqContext.JsonApiErr(http.StatusUnauthorized, unusableAPIKeyMessages[outcome], nil)
 		return true
 	}
 
+	// check that the key is used from an allowed network, proxy headers are
+	// ignored as they can be set by the client
+	ip, _ := network.GetIPFromAddress(reqContext.Req.RemoteAddr)
+	if !key.IsAllowedIP(ip) {
+		h.apiKeyService.ReportValidation(apikey.ValidationIPNotAllowed)
+		reqContext.JsonApiErr(http.StatusUnauthorized, "API key is not allowed from this IP address", nil)
+		return true
+	}
+
+	// keys with a rate limit are throttled by the APIKeyRateLimit middleware
+	if key.RateLimitRPS > 0 {
+		reqContext.APIKeyRateLimit = &models.APIKeyRateLimit{KeyID: key.Id, RPS: key.RateLimitRPS}
+	}
+
 	// update api_key last used date
-	if err := h.apiKeyService.UpdateAPIKeyLastUsedDate(reqContext.Req.Context(), apikey.Id); err != nil {
-		reqContext.JsonApiErr(http.StatusInternalServerError, InvalidAPIKey, errKey)
+	if err := h.apiKeyService.UpdateAPIKeyLastUsed(reqContext.Req.Context(), newLastUsedCommand(reqContext, key, getTime())); err != nil {
+		h.apiKeyService.ReportValidation(apikey.ValidationError)
+		reqContext.JsonApiErr(http.StatusInternalServerError, InvalidAPIKey, err)
 		return true
 	}
+	h.apiKeyService.ReportValidation(apikey.ValidationSucceeded)
 
-	if apikey.ServiceAccountId == nil || *apikey.ServiceAccountId < 1 { //There is no service account attached to the apikey
+	if key.ServiceAccountId == nil || *key.ServiceAccountId < 1 { //There is no service account attached to the apikey
 		//Use the old APIkey method.  This provides backwards compatibility.
-		reqContext.SignedInUser = &user.SignedInUser{}
-		reqContext.OrgRole = apikey.Role
-		reqContext.ApiKeyID = apikey.Id
-		reqContext.OrgID = apikey.OrgId
+		reqContext.SignedInUser = &user.SignedInUser{DatasourceUIDs: key.AllowedDatasources()}
+		reqContext.OrgRole = key.Role
+		reqContext.ApiKeyID = key.Id
+		reqContext.OrgID = key.OrgId
 		reqContext.IsSignedIn = true
 		return true
 	}
@@ -300,7 +464,7 @@ func (h *ContextHandler) initContextWithAPIKey(reqContext *models.ReqContext) bo
 	//There is a service account attached to the API key
 
 	//Use service account linked to API key as the signed in user
-	querySignedInUser := user.GetSignedInUserQuery{UserID: *apikey.ServiceAccountId, OrgID: apikey.OrgId}
+	querySignedInUser := user.GetSignedInUserQuery{UserID: *key.ServiceAccountId, OrgID: key.OrgId}
 	querySignedInUserResult, err := h.userService.GetSignedInUserWithCacheCtx(reqContext.Req.Context(), &querySignedInUser)
 	if err != nil {
 		reqContext.Logger.Error(
@@ -319,6 +483,14 @@ func (h *ContextHandler) initContextWithAPIKey(reqContext *models.ReqContext) bo
 		return true
 	}
 
+	// the signed in user is cached, the data sources of the token are set on
+	// a copy
+	if datasourceUIDs := key.AllowedDatasources(); datasourceUIDs != nil {
+		signedInUser := *querySignedInUserResult
+		signedInUser.DatasourceUIDs = datasourceUIDs
+		querySignedInUserResult = &signedInUser
+	}
+
 	reqContext.IsSignedIn = true
 	reqContext.SignedInUser = querySignedInUserResult
 
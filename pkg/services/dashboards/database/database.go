@@ -8,6 +8,7 @@ import (
 
 	"xorm.io/xorm"
 
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/infra/metrics"
 	"github.com/grafana/grafana/pkg/models"
@@ -814,6 +815,9 @@ func (d *DashboardStore) deleteDashboard(cmd *models.DeleteDashboardCommand, ses
 		}
 	}
 
+	// the permissions on the dashboard, and on the dashboards of the folder, are removed
+	sess.PublishAfterCommit(&events.AccessControlPermissionsChanged{Timestamp: time.Now(), OrgID: dashboard.OrgId, UserID: 0})
+
 	if err := d.deleteAlertDefinition(dashboard.Id, sess); err != nil {
 		return err
 	}
@@ -44,7 +44,7 @@ func (dc *CacheServiceImpl) GetDatasource(
 		if cached, found := dc.CacheService.Get(cacheKey); found {
 			ds := cached.(*datasources.DataSource)
 			if ds.OrgId == user.OrgID {
-				return ds, nil
+				return allowedDatasource(ds, user)
 			}
 		}
 	}
@@ -63,7 +63,7 @@ func (dc *CacheServiceImpl) GetDatasource(
 		dc.CacheService.Set(uidKey(ds.OrgId, ds.Uid), ds, time.Second*5)
 	}
 	dc.CacheService.Set(cacheKey, ds, dc.cacheTTL)
-	return ds, nil
+	return allowedDatasource(ds, user)
 }
 
 func (dc *CacheServiceImpl) GetDatasourceByUID(
@@ -84,7 +84,7 @@ func (dc *CacheServiceImpl) GetDatasourceByUID(
 		if cached, found := dc.CacheService.Get(uidCacheKey); found {
 			ds := cached.(*datasources.DataSource)
 			if ds.OrgId == user.OrgID {
-				return ds, nil
+				return allowedDatasource(ds, user)
 			}
 		}
 	}
@@ -100,6 +100,15 @@ func (dc *CacheServiceImpl) GetDatasourceByUID(
 
 	dc.CacheService.Set(uidCacheKey, ds, dc.cacheTTL)
 	dc.CacheService.Set(idKey(ds.Id), ds, dc.cacheTTL)
+	return allowedDatasource(ds, user)
+}
+
+// allowedDatasource denies the access to the data sources that are not among
+// the ones the API key of the user is restricted to.
+func allowedDatasource(ds *datasources.DataSource, user *user.SignedInUser) (*datasources.DataSource, error) {
+	if !user.CanQueryDatasource(ds.Uid) {
+		return nil, datasources.ErrDataSourceAccessDenied
+	}
 	return ds, nil
 }
 
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/localcache"
+	"github.com/grafana/grafana/pkg/services/datasources"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+func TestIntegrationCacheService_DatasourceRestriction(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	sqlStore := sqlstore.InitTestDB(t)
+	cmd := datasources.AddDataSourceCommand{OrgId: 1, Name: "prometheus", Uid: "prometheus", Type: datasources.DS_PROMETHEUS, Access: datasources.DS_ACCESS_PROXY}
+	require.NoError(t, sqlStore.AddDataSource(context.Background(), &cmd))
+	cache := ProvideCacheService(localcache.ProvideService(), sqlStore)
+
+	for _, skipCache := range []bool{true, false} {
+		unrestricted := &user.SignedInUser{OrgID: 1}
+		ds, err := cache.GetDatasourceByUID(context.Background(), "prometheus", unrestricted, skipCache)
+		require.NoError(t, err)
+		assert.Equal(t, "prometheus", ds.Uid)
+
+		allowed := &user.SignedInUser{OrgID: 1, DatasourceUIDs: []string{"loki", "prometheus"}}
+		_, err = cache.GetDatasource(context.Background(), cmd.Result.Id, allowed, skipCache)
+		require.NoError(t, err)
+
+		restricted := &user.SignedInUser{OrgID: 1, DatasourceUIDs: []string{"loki"}}
+		_, err = cache.GetDatasourceByUID(context.Background(), "prometheus", restricted, skipCache)
+		assert.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+		_, err = cache.GetDatasource(context.Background(), cmd.Result.Id, restricted, skipCache)
+		assert.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	}
+}
@@ -78,7 +78,7 @@ type DataSourceRetriever interface {
 	GetDataSource(ctx context.Context, query *datasources.GetDataSourceQuery) error
 }
 
-const secretType = "datasource"
+const secretType = kvstore.DataSourceSecretType
 
 // NewNameScopeResolver provides an ScopeAttributeResolver able to
 // translate a scope prefixed with "datasources:name:" into an uid based scope.
@@ -165,7 +165,9 @@ func (s *Service) AddDataSource(ctx context.Context, cmd *datasources.AddDataSou
 				return err
 			}
 
-			return s.SecretsStore.Set(ctx, cmd.OrgId, cmd.Name, secretType, string(secret))
+			// the permission of the user to create the data source is checked by the API, its secret is not checked
+			// against the secrets permissions of the user
+			return s.SecretsStore.Set(kvstore.WithServiceAccess(ctx), cmd.OrgId, cmd.Name, secretType, string(secret))
 		}
 
 		if err := s.SQLStore.AddDataSource(ctx, cmd); err != nil {
@@ -197,7 +199,8 @@ func (s *Service) AddDataSource(ctx context.Context, cmd *datasources.AddDataSou
 func (s *Service) DeleteDataSource(ctx context.Context, cmd *datasources.DeleteDataSourceCommand) error {
 	return s.SQLStore.InTransaction(ctx, func(ctx context.Context) error {
 		cmd.UpdateSecretFn = func() error {
-			return s.SecretsStore.Del(ctx, cmd.OrgID, cmd.Name, secretType)
+			// the permission of the user to delete the data source is checked by the API
+			return s.SecretsStore.Del(kvstore.WithServiceAccess(ctx), cmd.OrgID, cmd.Name, secretType)
 		}
 
 		return s.SQLStore.DeleteDataSource(ctx, cmd)
@@ -229,14 +232,16 @@ func (s *Service) UpdateDataSource(ctx context.Context, cmd *datasources.UpdateD
 					return err
 				}
 
+				// the permission of the user to update the data source is checked by the API
+				secretsCtx := kvstore.WithServiceAccess(ctx)
 				if query.Result.Name != cmd.Name {
-					err := s.SecretsStore.Rename(ctx, cmd.OrgId, query.Result.Name, secretType, cmd.Name)
+					err := s.SecretsStore.Rename(secretsCtx, cmd.OrgId, query.Result.Name, secretType, cmd.Name)
 					if err != nil {
 						return err
 					}
 				}
 
-				return s.SecretsStore.Set(ctx, cmd.OrgId, cmd.Name, secretType, string(secret))
+				return s.SecretsStore.Set(secretsCtx, cmd.OrgId, cmd.Name, secretType, string(secret))
 			}
 		}
 
@@ -299,7 +304,8 @@ func (s *Service) GetTLSConfig(ctx context.Context, ds *datasources.DataSource,
 
 func (s *Service) DecryptedValues(ctx context.Context, ds *datasources.DataSource) (map[string]string, error) {
 	decryptedValues := make(map[string]string)
-	secret, exist, err := s.SecretsStore.Get(ctx, ds.OrgId, ds.Name, secretType)
+	// the secrets are decrypted to query the data source or to update its secure fields, never returned to the user
+	secret, exist, err := s.SecretsStore.Get(kvstore.WithServiceAccess(ctx), ds.OrgId, ds.Name, secretType)
 	if err != nil {
 		return nil, err
 	}
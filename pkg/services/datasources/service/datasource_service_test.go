@@ -15,15 +15,21 @@ import (
 
 	"github.com/grafana/grafana/pkg/components/simplejson"
 	"github.com/grafana/grafana/pkg/infra/httpclient"
+	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	acmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/contexthandler/ctxkey"
 	"github.com/grafana/grafana/pkg/services/datasources"
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/grafana/grafana/pkg/services/org"
 	"github.com/grafana/grafana/pkg/services/secrets"
 	"github.com/grafana/grafana/pkg/services/secrets/fakes"
 	"github.com/grafana/grafana/pkg/services/secrets/kvstore"
 	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/user"
 	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/web"
 )
 
 type dataSourceMockRetriever struct {
@@ -597,6 +603,52 @@ func TestService_GetDecryptedValues(t *testing.T) {
 	})
 }
 
+func TestService_SecretsOfDataSourceEditors(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsStore := kvstore.SetupTestService(t)
+	secretsAccessControl, err := kvstore.ProvideAccessControlService(acmock.New())
+	require.NoError(t, err)
+	secretsService := secretsManager.SetupTestService(t, fakes.NewFakeSecretsStore())
+	dsService := ProvideService(sqlStore, secretsService, secretsAccessControl.Wrap(secretsStore), nil, featuremgmt.WithFeatures(), acmock.New().WithDisabled(), acmock.NewMockedPermissionsService())
+
+	// the editor may edit the data sources but has no secrets permission
+	editor := &user.SignedInUser{UserID: 2, OrgID: 1, OrgRole: org.RoleEditor, Permissions: map[int64]map[string][]string{1: {
+		datasources.ActionCreate: {},
+		datasources.ActionWrite:  {datasources.ScopeAll},
+		datasources.ActionDelete: {datasources.ScopeAll},
+	}}}
+	reqCtx := &models.ReqContext{Context: &web.Context{Req: &http.Request{}}, SignedInUser: editor}
+	ctx := ctxkey.Set(context.Background(), reqCtx)
+
+	addCmd := &datasources.AddDataSourceCommand{OrgId: 1, Name: "prometheus", Type: "prometheus", Access: datasources.DS_ACCESS_PROXY,
+		SecureJsonData: map[string]string{"password": "secret"}}
+	require.NoError(t, dsService.AddDataSource(ctx, addCmd))
+	secret, found, err := secretsStore.Get(context.Background(), 1, "prometheus", secretType)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.JSONEq(t, `{"password":"secret"}`, secret)
+
+	updateCmd := &datasources.UpdateDataSourceCommand{Id: addCmd.Result.Id, OrgId: 1, Name: "renamed", Type: "prometheus",
+		Access: datasources.DS_ACCESS_PROXY, Version: addCmd.Result.Version, SecureJsonData: map[string]string{"password": "updated"}}
+	require.NoError(t, dsService.UpdateDataSource(ctx, updateCmd))
+	secret, found, err = secretsStore.Get(context.Background(), 1, "renamed", secretType)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.JSONEq(t, `{"password":"updated"}`, secret)
+	_, found, err = secretsStore.Get(context.Background(), 1, "prometheus", secretType)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, dsService.DeleteDataSource(ctx, &datasources.DeleteDataSourceCommand{ID: addCmd.Result.Id, Name: "renamed", OrgID: 1}))
+	_, found, err = secretsStore.Get(context.Background(), 1, "renamed", secretType)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// the secrets are still denied when the editor accesses them directly
+	_, _, err = secretsAccessControl.Wrap(secretsStore).Get(ctx, 1, "renamed", secretType)
+	assert.ErrorIs(t, err, kvstore.ErrSecretAccessDenied)
+}
+
 const caCert string = `-----BEGIN CERTIFICATE-----
 MIIDATCCAemgAwIBAgIJAMQ5hC3CPDTeMA0GCSqGSIb3DQEBCwUAMBcxFTATBgNV
 BAMMDGNhLWs4cy1zdGhsbTAeFw0xNjEwMjcwODQyMjdaFw00NDAzMTQwODQyMjda
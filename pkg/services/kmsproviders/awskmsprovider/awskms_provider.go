@@ -0,0 +1,95 @@
+package awskmsprovider
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// Kind is the kind of the AWS KMS providers, which are configured in the
+// [security.encryption.awskms.<KEY-NAME>] sections.
+const Kind = "awskms"
+
+var ErrMissingKeyID = errors.New("missing key_id")
+
+// kmsClient is the part of the KMS API used by the provider.
+type kmsClient interface {
+	EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error)
+	DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error)
+}
+
+// awsKMSProvider wraps the data keys with a symmetric key of AWS KMS, so
+// that they can only be unwrapped by the identities allowed to use the key.
+type awsKMSProvider struct {
+	keyID  string
+	client kmsClient
+}
+
+// New returns a provider using the key_id key of the region. It is
+// authenticated with the access_key_id and secret_access_key of the section
+// when set, and with the default credentials chain of the AWS SDK otherwise,
+// e.g. the IAM role of the EC2 instance or of the Kubernetes service account.
+// The role of assume_role_arn is assumed with those credentials when set.
+func New(section setting.Section) (secrets.Provider, error) {
+	keyID := section.KeyValue("key_id").Value()
+	if keyID == "" {
+		return nil, ErrMissingKeyID
+	}
+
+	cfg := aws.NewConfig()
+	if region := section.KeyValue("region").Value(); region != "" {
+		cfg = cfg.WithRegion(region)
+	}
+	if endpoint := section.KeyValue("endpoint").Value(); endpoint != "" {
+		cfg = cfg.WithEndpoint(endpoint)
+	}
+	accessKeyID := section.KeyValue("access_key_id").Value()
+	secretAccessKey := section.KeyValue("secret_access_key").Value()
+	if accessKeyID != "" || secretAccessKey != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(accessKeyID, secretAccessKey, section.KeyValue("session_token").Value()))
+	}
+
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var overrides []*aws.Config
+	if roleARN := section.KeyValue("assume_role_arn").Value(); roleARN != "" {
+		overrides = append(overrides, aws.NewConfig().WithCredentials(stscreds.NewCredentials(sess, roleARN)))
+	}
+
+	return &awsKMSProvider{keyID: keyID, client: kms.New(sess, overrides...)}, nil
+}
+
+func (p *awsKMSProvider) Encrypt(ctx context.Context, blob []byte) ([]byte, error) {
+	out, err := p.client.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(p.keyID),
+		Plaintext: blob,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.CiphertextBlob, nil
+}
+
+// Decrypt doesn't set the key of the provider, as the ciphertext references
+// the key it was encrypted with, so that the data keys wrapped before key_id
+// was pointed to another key can still be decrypted.
+func (p *awsKMSProvider) Decrypt(ctx context.Context, blob []byte) ([]byte, error) {
+	out, err := p.client.DecryptWithContext(ctx, &kms.DecryptInput{
+		CiphertextBlob: blob,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
@@ -0,0 +1,84 @@
+package awskmsprovider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+type fakeKMSClient struct {
+	encryptInput *kms.EncryptInput
+	decryptInput *kms.DecryptInput
+}
+
+func (c *fakeKMSClient) EncryptWithContext(_ aws.Context, input *kms.EncryptInput, _ ...request.Option) (*kms.EncryptOutput, error) {
+	c.encryptInput = input
+	return &kms.EncryptOutput{CiphertextBlob: append([]byte("wrapped:"), input.Plaintext...)}, nil
+}
+
+func (c *fakeKMSClient) DecryptWithContext(_ aws.Context, input *kms.DecryptInput, _ ...request.Option) (*kms.DecryptOutput, error) {
+	c.decryptInput = input
+	return &kms.DecryptOutput{Plaintext: input.CiphertextBlob[len("wrapped:"):]}, nil
+}
+
+func section(t *testing.T, rawCfg string) setting.Section {
+	t.Helper()
+	raw, err := ini.Load([]byte(rawCfg))
+	require.NoError(t, err)
+	settings := &setting.OSSImpl{Cfg: &setting.Cfg{Raw: raw}}
+	return settings.Section("security.encryption.awskms.test")
+}
+
+func TestNew(t *testing.T) {
+	t.Run("key_id is required", func(t *testing.T) {
+		_, err := New(section(t, `
+		[security.encryption.awskms.test]
+		region = eu-west-1
+		`))
+		assert.ErrorIs(t, err, ErrMissingKeyID)
+	})
+
+	t.Run("provider is configured with static credentials", func(t *testing.T) {
+		p, err := New(section(t, `
+		[security.encryption.awskms.test]
+		key_id = alias/grafana
+		region = eu-west-1
+		access_key_id = AKID
+		secret_access_key = SECRET
+		`))
+		require.NoError(t, err)
+		assert.Equal(t, "alias/grafana", p.(*awsKMSProvider).keyID)
+	})
+
+	t.Run("provider is configured with an assumed role", func(t *testing.T) {
+		_, err := New(section(t, `
+		[security.encryption.awskms.test]
+		key_id = alias/grafana
+		region = eu-west-1
+		assume_role_arn = arn:aws:iam::123456789012:role/grafana
+		`))
+		require.NoError(t, err)
+	})
+}
+
+func TestAWSKMSProvider(t *testing.T) {
+	client := &fakeKMSClient{}
+	p := &awsKMSProvider{keyID: "alias/grafana", client: client}
+
+	encrypted, err := p.Encrypt(context.Background(), []byte("data key"))
+	require.NoError(t, err)
+	assert.Equal(t, "alias/grafana", aws.StringValue(client.encryptInput.KeyId))
+
+	decrypted, err := p.Decrypt(context.Background(), encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data key"), decrypted)
+	assert.Nil(t, client.decryptInput.KeyId)
+}
@@ -0,0 +1,158 @@
+package hashicorpvaultprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// Kind is the kind of the HashiCorp Vault providers, which are configured in
+// the [security.encryption.hashicorpvault.<KEY-NAME>] sections.
+const Kind = "hashicorpvault"
+
+var (
+	ErrMissingURL     = errors.New("missing url")
+	ErrMissingToken   = errors.New("missing token")
+	ErrMissingKeyRing = errors.New("missing key_ring")
+)
+
+// vaultTransitProvider wraps the data keys with a named key of the transit
+// secrets engine of HashiCorp Vault, so that the key never leaves Vault.
+type vaultTransitProvider struct {
+	url                  string
+	token                string
+	transitEnginePath    string
+	keyRing              string
+	tokenRenewalInterval time.Duration
+
+	client *http.Client
+	log    log.Logger
+}
+
+// New returns a provider using the key_ring key of the transit engine mounted
+// at transit_engine_path. The token is renewed every token_renewal_interval,
+// so a periodic token doesn't expire while Grafana is running.
+func New(section setting.Section) (secrets.Provider, error) {
+	p := &vaultTransitProvider{
+		url:                  strings.TrimSuffix(section.KeyValue("url").Value(), "/"),
+		token:                section.KeyValue("token").Value(),
+		transitEnginePath:    strings.Trim(section.KeyValue("transit_engine_path").MustString("transit"), "/"),
+		keyRing:              section.KeyValue("key_ring").Value(),
+		tokenRenewalInterval: section.KeyValue("token_renewal_interval").MustDuration(5 * time.Minute),
+		client:               &http.Client{Timeout: section.KeyValue("timeout").MustDuration(10 * time.Second)},
+		log:                  log.New("secrets.hashicorpvault"),
+	}
+
+	switch {
+	case p.url == "":
+		return nil, ErrMissingURL
+	case p.token == "":
+		return nil, ErrMissingToken
+	case p.keyRing == "":
+		return nil, ErrMissingKeyRing
+	}
+	return p, nil
+}
+
+func (p *vaultTransitProvider) Encrypt(ctx context.Context, blob []byte) ([]byte, error) {
+	var res struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	body := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(blob)}
+	if err := p.do(ctx, p.transitURL("encrypt"), body, &res); err != nil {
+		return nil, err
+	}
+	return []byte(res.Data.Ciphertext), nil
+}
+
+// Decrypt sends the ciphertext as returned by Encrypt, it contains the
+// version of the key it was encrypted with, so that the data keys wrapped
+// before the key was rotated in Vault can still be decrypted.
+func (p *vaultTransitProvider) Decrypt(ctx context.Context, blob []byte) ([]byte, error) {
+	var res struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	body := map[string]string{"ciphertext": string(blob)}
+	if err := p.do(ctx, p.transitURL("decrypt"), body, &res); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(res.Data.Plaintext)
+}
+
+// Run renews the token every token_renewal_interval, the renewal is disabled
+// when the interval is not positive.
+func (p *vaultTransitProvider) Run(ctx context.Context) error {
+	if p.tokenRenewalInterval <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(p.tokenRenewalInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.renewToken(ctx); err != nil {
+				p.log.Error("Failed to renew Vault token", "error", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *vaultTransitProvider) renewToken(ctx context.Context) error {
+	return p.do(ctx, p.url+"/v1/auth/token/renew-self", map[string]string{}, nil)
+}
+
+func (p *vaultTransitProvider) transitURL(operation string) string {
+	return fmt.Sprintf("%s/v1/%s/%s/%s", p.url, p.transitEnginePath, operation, url.PathEscape(p.keyRing))
+}
+
+// do sends an authenticated request to Vault and decodes the response into out
+func (p *vaultTransitProvider) do(ctx context.Context, reqURL string, body interface{}, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&vaultErr)
+		return fmt.Errorf("vault request failed with status %d: %s", res.StatusCode, strings.Join(vaultErr.Errors, ", "))
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, res.Body)
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,131 @@
+package hashicorpvaultprovider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// fakeTransit implements the encrypt, decrypt and renew-self endpoints of Vault,
+// the ciphertext is the base64 plaintext prefixed with the key version.
+type fakeTransit struct {
+	mtx      sync.Mutex
+	renewals int
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if r.Header.Get("X-Vault-Token") != "s.token" {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors": ["permission denied"]}`))
+		return
+	}
+
+	body := map[string]string{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	switch r.URL.Path {
+	case "/v1/transit-grafana/encrypt/grafana-key":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"ciphertext": "vault:v1:" + body["plaintext"]}})
+	case "/v1/transit-grafana/decrypt/grafana-key":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"plaintext": strings.TrimPrefix(body["ciphertext"], "vault:v1:")}})
+	case "/v1/auth/token/renew-self":
+		f.renewals++
+		_, _ = w.Write([]byte(`{"auth": {}}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeTransit) renewalCount() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.renewals
+}
+
+func newProvider(t *testing.T, rawCfg string) *vaultTransitProvider {
+	t.Helper()
+	raw, err := ini.Load([]byte(rawCfg))
+	require.NoError(t, err)
+	settings := &setting.OSSImpl{Cfg: &setting.Cfg{Raw: raw}}
+	p, err := New(settings.Section("security.encryption.hashicorpvault.test"))
+	require.NoError(t, err)
+	return p.(*vaultTransitProvider)
+}
+
+func TestVaultTransitProvider(t *testing.T) {
+	transit := &fakeTransit{}
+	server := httptest.NewServer(transit)
+	t.Cleanup(server.Close)
+
+	t.Run("settings are required", func(t *testing.T) {
+		raw, err := ini.Load([]byte(`
+		[security.encryption.hashicorpvault.test]
+		url = http://localhost:8200
+		token = s.token
+		`))
+		require.NoError(t, err)
+		settings := &setting.OSSImpl{Cfg: &setting.Cfg{Raw: raw}}
+		_, err = New(settings.Section("security.encryption.hashicorpvault.test"))
+		assert.ErrorIs(t, err, ErrMissingKeyRing)
+	})
+
+	t.Run("data keys are encrypted and decrypted by the transit engine", func(t *testing.T) {
+		p := newProvider(t, `
+		[security.encryption.hashicorpvault.test]
+		url = `+server.URL+`/
+		token = s.token
+		transit_engine_path = /transit-grafana/
+		key_ring = grafana-key
+		`)
+
+		encrypted, err := p.Encrypt(context.Background(), []byte("data key"))
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(encrypted), "vault:v1:"))
+
+		decrypted, err := p.Decrypt(context.Background(), encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data key"), decrypted)
+	})
+
+	t.Run("rejected tokens fail", func(t *testing.T) {
+		p := newProvider(t, `
+		[security.encryption.hashicorpvault.test]
+		url = `+server.URL+`
+		token = s.revoked
+		transit_engine_path = transit-grafana
+		key_ring = grafana-key
+		`)
+
+		_, err := p.Encrypt(context.Background(), []byte("data key"))
+		assert.ErrorContains(t, err, "permission denied")
+	})
+
+	t.Run("token is renewed periodically", func(t *testing.T) {
+		p := newProvider(t, `
+		[security.encryption.hashicorpvault.test]
+		url = `+server.URL+`
+		token = s.token
+		key_ring = grafana-key
+		token_renewal_interval = 10ms
+		`)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- p.Run(ctx) }()
+		require.Eventually(t, func() bool { return transit.renewalCount() >= 2 }, time.Second, 10*time.Millisecond)
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+	})
+}
@@ -1,12 +1,17 @@
 package osskmsproviders
 
 import (
+	"fmt"
+
 	"github.com/grafana/grafana/pkg/services/encryption"
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
 	"github.com/grafana/grafana/pkg/services/kmsproviders"
+	"github.com/grafana/grafana/pkg/services/kmsproviders/awskmsprovider"
 	grafana "github.com/grafana/grafana/pkg/services/kmsproviders/defaultprovider"
+	"github.com/grafana/grafana/pkg/services/kmsproviders/hashicorpvaultprovider"
 	"github.com/grafana/grafana/pkg/services/secrets"
 	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/util"
 )
 
 type Service struct {
@@ -23,8 +28,41 @@ func ProvideService(enc encryption.Internal, settings setting.Provider, features
 	}
 }
 
+// Provide returns the default provider, along with the current and the
+// available encryption providers of the kinds supported by the OSS edition.
+// The providers of the other kinds are left out.
 func (s Service) Provide() (map[secrets.ProviderID]secrets.Provider, error) {
-	return map[secrets.ProviderID]secrets.Provider{
+	providers := map[secrets.ProviderID]secrets.Provider{
 		kmsproviders.Default: grafana.New(s.settings, s.enc),
-	}, nil
+	}
+
+	ids := util.SplitString(s.settings.KeyValue("security", "available_encryption_providers").Value())
+	ids = append(ids, s.settings.KeyValue("security", "encryption_provider").Value())
+	for _, rawID := range ids {
+		id := kmsproviders.NormalizeProviderID(secrets.ProviderID(rawID))
+		if _, ok := providers[id]; ok || id == "" {
+			continue
+		}
+
+		kind, err := id.Kind()
+		if err != nil {
+			return nil, err
+		}
+		section := s.settings.Section(fmt.Sprintf("security.encryption.%s", id))
+		var provider secrets.Provider
+		switch kind {
+		case awskmsprovider.Kind:
+			provider, err = awskmsprovider.New(section)
+		case hashicorpvaultprovider.Kind:
+			provider, err = hashicorpvaultprovider.New(section)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure encryption provider %s: %w", id, err)
+		}
+		providers[id] = provider
+	}
+
+	return providers, nil
 }
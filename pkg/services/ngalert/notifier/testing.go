@@ -5,9 +5,12 @@ import (
 	"crypto/md5"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/services/ngalert/models"
@@ -161,6 +164,51 @@ func (fkv *FakeKVStore) Set(_ context.Context, orgId int64, namespace string, ke
 
 	return nil
 }
+
+func (fkv *FakeKVStore) MGet(ctx context.Context, orgId int64, namespace string, keys []string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, ok, err := fkv.Get(ctx, orgId, namespace, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+func (fkv *FakeKVStore) MSet(ctx context.Context, orgId int64, namespace string, values map[string]string) error {
+	for key, value := range values {
+		if err := fkv.Set(ctx, orgId, namespace, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetWithTTL sets the item, which does not expire in the fake store
+func (fkv *FakeKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, key string, value string, _ time.Duration) error {
+	return fkv.Set(ctx, orgId, namespace, key, value)
+}
+
+func (fkv *FakeKVStore) CAS(ctx context.Context, orgId int64, namespace string, key string, expected string, value string) (bool, error) {
+	fkv.mtx.Lock()
+	defer fkv.mtx.Unlock()
+	if fkv.store[orgId][namespace][key] != expected {
+		return false, nil
+	}
+	if _, ok := fkv.store[orgId]; !ok {
+		fkv.store[orgId] = map[string]map[string]string{}
+	}
+	if _, ok := fkv.store[orgId][namespace]; !ok {
+		fkv.store[orgId][namespace] = map[string]string{}
+	}
+	fkv.store[orgId][namespace][key] = value
+	return true, nil
+}
+
 func (fkv *FakeKVStore) Del(_ context.Context, orgId int64, namespace string, key string) error {
 	fkv.mtx.Lock()
 	defer fkv.mtx.Unlock()
@@ -201,6 +249,43 @@ func (fkv *FakeKVStore) Keys(ctx context.Context, orgID int64, namespace string,
 	return keys, nil
 }
 
+func (fkv *FakeKVStore) KeysPage(ctx context.Context, orgId int64, namespace string, keyPrefix string, limit int, continueToken string) ([]kvstore.Key, string, error) {
+	keys, err := fkv.Keys(ctx, orgId, namespace, keyPrefix)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].OrgId != keys[j].OrgId {
+			return keys[i].OrgId < keys[j].OrgId
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	offset := 0
+	if continueToken != "" {
+		if offset, err = strconv.Atoi(continueToken); err != nil {
+			return nil, "", kvstore.ErrInvalidContinueToken
+		}
+	}
+	if offset >= len(keys) {
+		return []kvstore.Key{}, "", nil
+	}
+	keys = keys[offset:]
+	if len(keys) > limit {
+		return keys[:limit], strconv.Itoa(offset + limit), nil
+	}
+	return keys, "", nil
+}
+
+// Watch streams no changes, the channel is closed once ctx is done
+func (fkv *FakeKVStore) Watch(ctx context.Context, namespace string, keyPrefix string) (<-chan kvstore.Event, error) {
+	events := make(chan kvstore.Event)
+	go func() {
+		<-ctx.Done()
+		close(events)
+	}()
+	return events, nil
+}
+
 func (fkv *FakeKVStore) GetAll(ctx context.Context, orgId int64, namespace string) (map[int64]map[string]string, error) {
 	return nil, nil
 }
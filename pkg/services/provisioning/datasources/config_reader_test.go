@@ -2,6 +2,7 @@ package datasources
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"testing"
 
@@ -27,6 +28,7 @@ var (
 	multipleOrgsWithDefault         = "testdata/multiple-org-default"
 	withoutDefaults                 = "testdata/appliedDefaults"
 	invalidAccess                   = "testdata/invalid-access"
+	secretRefs                      = "testdata/secret-refs"
 
 	oneDatasourceWithTwoCorrelations = "testdata/one-datasource-two-correlations"
 )
@@ -36,7 +38,7 @@ func TestDatasourceAsConfig(t *testing.T) {
 		store := &spyStore{}
 		orgStore := &mockOrgStore{ExpectedOrg: &models.Org{Id: 1}}
 		correlationsStore := &mockCorrelationsStore{}
-		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 		err := dc.applyChanges(context.Background(), withoutDefaults)
 		if err != nil {
 			t.Fatalf("applyChanges return an error %v", err)
@@ -55,7 +57,7 @@ func TestDatasourceAsConfig(t *testing.T) {
 		}
 		orgStore := &mockOrgStore{}
 		correlationsStore := &mockCorrelationsStore{}
-		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 		err := dc.applyChanges(context.Background(), withoutDefaults)
 		if err != nil {
 			t.Fatalf("applyChanges return an error %v", err)
@@ -71,7 +73,7 @@ func TestDatasourceAsConfig(t *testing.T) {
 		store := &spyStore{}
 		orgStore := &mockOrgStore{}
 		correlationsStore := &mockCorrelationsStore{}
-		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 		err := dc.applyChanges(context.Background(), twoDatasourcesConfig)
 		if err != nil {
 			t.Fatalf("applyChanges return an error %v", err)
@@ -86,7 +88,7 @@ func TestDatasourceAsConfig(t *testing.T) {
 		store := &spyStore{items: []*datasources.DataSource{{Name: "Graphite", OrgId: 1, Id: 1}}}
 		orgStore := &mockOrgStore{}
 		correlationsStore := &mockCorrelationsStore{}
-		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 		err := dc.applyChanges(context.Background(), twoDatasourcesConfig)
 		if err != nil {
 			t.Fatalf("applyChanges return an error %v", err)
@@ -101,7 +103,7 @@ func TestDatasourceAsConfig(t *testing.T) {
 		store := &spyStore{}
 		orgStore := &mockOrgStore{}
 		correlationsStore := &mockCorrelationsStore{}
-		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 		err := dc.applyChanges(context.Background(), doubleDatasourcesConfig)
 		require.Equal(t, err, ErrInvalidConfigToManyDefault)
 	})
@@ -110,7 +112,7 @@ func TestDatasourceAsConfig(t *testing.T) {
 		store := &spyStore{}
 		orgStore := &mockOrgStore{}
 		correlationsStore := &mockCorrelationsStore{}
-		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 		err := dc.applyChanges(context.Background(), multipleOrgsWithDefault)
 		require.NoError(t, err)
 		require.Equal(t, len(store.inserted), 4)
@@ -124,7 +126,7 @@ func TestDatasourceAsConfig(t *testing.T) {
 		store := &spyStore{}
 		orgStore := &mockOrgStore{}
 		correlationsStore := &mockCorrelationsStore{}
-		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 		err := dc.applyChanges(context.Background(), deleteOneDatasource)
 		if err != nil {
 			t.Fatalf("applyChanges return an error %v", err)
@@ -141,7 +143,7 @@ func TestDatasourceAsConfig(t *testing.T) {
 		store := &spyStore{items: []*datasources.DataSource{{Name: "old-graphite", OrgId: 1, Id: 1}, {Name: "old-graphite2", OrgId: 1, Id: 2}}}
 		orgStore := &mockOrgStore{}
 		correlationsStore := &mockCorrelationsStore{}
-		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 		err := dc.applyChanges(context.Background(), twoDatasourcesConfigPurgeOthers)
 		if err != nil {
 			t.Fatalf("applyChanges return an error %v", err)
@@ -156,7 +158,7 @@ func TestDatasourceAsConfig(t *testing.T) {
 		store := &spyStore{items: []*datasources.DataSource{{Name: "Graphite", OrgId: 1, Id: 1}, {Name: "old-graphite2", OrgId: 1, Id: 2}}}
 		orgStore := &mockOrgStore{}
 		correlationsStore := &mockCorrelationsStore{}
-		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+		dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 		err := dc.applyChanges(context.Background(), twoDatasourcesConfig)
 		if err != nil {
 			t.Fatalf("applyChanges return an error %v", err)
@@ -237,12 +239,46 @@ func TestDatasourceAsConfig(t *testing.T) {
 		validateDeleteDatasources(t, dsCfg)
 	})
 
+	t.Run("Secret references", func(t *testing.T) {
+		t.Run("Resolves the secret references of the secure json data", func(t *testing.T) {
+			store := &spyStore{}
+			secretsStore := &mockSecretsStore{secrets: map[string]string{"2/prometheus/password": "s3cr3t", "2/ci/token": "t0k3n"}}
+			dc := newDatasourceProvisioner(logger, store, &mockCorrelationsStore{}, &mockOrgStore{}, secretsStore)
+			err := dc.applyChanges(context.Background(), secretRefs)
+			require.NoError(t, err)
+
+			require.Len(t, store.inserted, 1)
+			require.Equal(t, map[string]string{
+				"basicAuthPassword": "s3cr3t",
+				"httpHeaderValue1":  "Bearer t0k3n",
+				"tlsClientKey":      "plain",
+			}, store.inserted[0].SecureJsonData)
+		})
+
+		t.Run("Fails when a referenced secret doesn't exist", func(t *testing.T) {
+			store := &spyStore{}
+			secretsStore := &mockSecretsStore{secrets: map[string]string{"1/prometheus/password": "s3cr3t", "2/ci/token": "t0k3n"}}
+			dc := newDatasourceProvisioner(logger, store, &mockCorrelationsStore{}, &mockOrgStore{}, secretsStore)
+			err := dc.applyChanges(context.Background(), secretRefs)
+			require.ErrorContains(t, err, "secret prometheus/password not found")
+			require.Len(t, store.inserted, 0)
+		})
+
+		t.Run("Fails without secrets store", func(t *testing.T) {
+			store := &spyStore{}
+			dc := newDatasourceProvisioner(logger, store, &mockCorrelationsStore{}, &mockOrgStore{}, nil)
+			err := dc.applyChanges(context.Background(), secretRefs)
+			require.ErrorIs(t, err, errSecretsStoreNotAvailable)
+			require.Len(t, store.inserted, 0)
+		})
+	})
+
 	t.Run("Correlations", func(t *testing.T) {
 		t.Run("Creates two correlations", func(t *testing.T) {
 			store := &spyStore{}
 			orgStore := &mockOrgStore{}
 			correlationsStore := &mockCorrelationsStore{}
-			dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+			dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 			err := dc.applyChanges(context.Background(), oneDatasourceWithTwoCorrelations)
 			if err != nil {
 				t.Fatalf("applyChanges return an error %v", err)
@@ -257,7 +293,7 @@ func TestDatasourceAsConfig(t *testing.T) {
 			store := &spyStore{items: []*datasources.DataSource{{Name: "Graphite", OrgId: 1, Id: 1}}}
 			orgStore := &mockOrgStore{}
 			correlationsStore := &mockCorrelationsStore{}
-			dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+			dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 			err := dc.applyChanges(context.Background(), oneDatasourceWithTwoCorrelations)
 			if err != nil {
 				t.Fatalf("applyChanges return an error %v", err)
@@ -272,7 +308,7 @@ func TestDatasourceAsConfig(t *testing.T) {
 			store := &spyStore{items: []*datasources.DataSource{{Name: "old-data-source", OrgId: 1, Id: 1, Uid: "some-uid"}}}
 			orgStore := &mockOrgStore{}
 			correlationsStore := &mockCorrelationsStore{items: []correlations.Correlation{{UID: "some-uid", SourceUID: "some-uid", TargetUID: "target-uid"}}}
-			dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore)
+			dc := newDatasourceProvisioner(logger, store, correlationsStore, orgStore, nil)
 			err := dc.applyChanges(context.Background(), deleteOneDatasource)
 			if err != nil {
 				t.Fatalf("applyChanges return an error %v", err)
@@ -360,6 +396,13 @@ func (m *mockCorrelationsStore) DeleteCorrelationsByTargetUID(c context.Context,
 	return nil
 }
 
+type mockSecretsStore struct{ secrets map[string]string }
+
+func (m *mockSecretsStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	value, ok := m.secrets[fmt.Sprintf("%d/%s/%s", orgId, namespace, typ)]
+	return value, ok, nil
+}
+
 type spyStore struct {
 	inserted []*datasources.AddDataSourceCommand
 	deleted  []*datasources.DeleteDataSourceCommand
@@ -32,8 +32,8 @@ var (
 
 // Provision scans a directory for provisioning config files
 // and provisions the datasource in those files.
-func Provision(ctx context.Context, configDirectory string, store Store, correlationsStore CorrelationsStore, orgStore utils.OrgStore) error {
-	dc := newDatasourceProvisioner(log.New("provisioning.datasources"), store, correlationsStore, orgStore)
+func Provision(ctx context.Context, configDirectory string, store Store, correlationsStore CorrelationsStore, orgStore utils.OrgStore, secretsStore SecretsStore) error {
+	dc := newDatasourceProvisioner(log.New("provisioning.datasources"), store, correlationsStore, orgStore, secretsStore)
 	return dc.applyChanges(ctx, configDirectory)
 }
 
@@ -44,14 +44,16 @@ type DatasourceProvisioner struct {
 	cfgProvider       *configReader
 	store             Store
 	correlationsStore CorrelationsStore
+	secretsStore      SecretsStore
 }
 
-func newDatasourceProvisioner(log log.Logger, store Store, correlationsStore CorrelationsStore, orgStore utils.OrgStore) DatasourceProvisioner {
+func newDatasourceProvisioner(log log.Logger, store Store, correlationsStore CorrelationsStore, orgStore utils.OrgStore, secretsStore SecretsStore) DatasourceProvisioner {
 	return DatasourceProvisioner{
 		log:               log,
 		cfgProvider:       &configReader{log: log, orgStore: orgStore},
 		store:             store,
 		correlationsStore: correlationsStore,
+		secretsStore:      secretsStore,
 	}
 }
 
@@ -63,6 +65,10 @@ func (dc *DatasourceProvisioner) apply(ctx context.Context, cfg *configs) error
 	correlationsToInsert := make([]correlations.CreateCorrelationCommand, 0)
 
 	for _, ds := range cfg.Datasources {
+		if err := dc.resolveSecretRefs(ctx, ds); err != nil {
+			return fmt.Errorf("failed to provision %q data source: %w", ds.Name, err)
+		}
+
 		cmd := &datasources.GetDataSourceQuery{OrgId: ds.OrgID, Name: ds.Name}
 		err := dc.store.GetDataSource(ctx, cmd)
 		if err != nil && !errors.Is(err, datasources.ErrDataSourceNotFound) {
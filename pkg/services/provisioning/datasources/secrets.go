@@ -0,0 +1,50 @@
+package datasources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/services/provisioning/values"
+)
+
+// SecretsStore is the store the $__secret{namespace/key} references of the secure json data are resolved from, in
+// the organization of the data source
+type SecretsStore interface {
+	Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error)
+}
+
+var errSecretsStoreNotAvailable = errors.New("secrets store is not available")
+
+// resolveSecretRefs replaces the references to the secrets store in the secure json data of the data source with
+// the values of the secrets, so that the provisioning files don't contain them
+func (dc *DatasourceProvisioner) resolveSecretRefs(ctx context.Context, ds *upsertDataSourceFromConfig) error {
+	for field, value := range ds.SecureJSONData {
+		var resolveErr error
+		resolved := values.SecretRefRegex.ReplaceAllStringFunc(value, func(ref string) string {
+			if resolveErr != nil {
+				return ref
+			}
+			match := values.SecretRefRegex.FindStringSubmatch(ref)
+			namespace, key := match[1], match[2]
+			if dc.secretsStore == nil {
+				resolveErr = errSecretsStoreNotAvailable
+				return ref
+			}
+
+			secret, exists, err := dc.secretsStore.Get(ctx, ds.OrgID, namespace, key)
+			switch {
+			case err != nil:
+				resolveErr = fmt.Errorf("failed to get secret %s/%s: %w", namespace, key, err)
+			case !exists:
+				resolveErr = fmt.Errorf("secret %s/%s not found", namespace, key)
+			}
+			return secret
+		})
+		if resolveErr != nil {
+			return fmt.Errorf("failed to resolve %q secure json data field: %w", field, resolveErr)
+		}
+		ds.SecureJSONData[field] = resolved
+	}
+	return nil
+}
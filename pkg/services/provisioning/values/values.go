@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -283,6 +284,10 @@ func transformMap(i map[interface{}]interface{}) (interface{}, interface{}, erro
 	return transformed, raw, nil
 }
 
+// SecretRefRegex matches the $__secret{namespace/key} references to the secrets store. They are kept as is by the
+// interpolation, and resolved by the provisioners supporting them.
+var SecretRefRegex = regexp.MustCompile(`\$__secret\{([^}]+)/([^}/]+)\}`)
+
 // interpolateValue returns the final value after interpolation. In addition to environment variable interpolation,
 // expanders available for the settings file are expanded here.
 // For a literal '$', '$$' can be used to avoid interpolation.
@@ -290,16 +295,36 @@ func interpolateValue(val string) (string, string, error) {
 	parts := strings.Split(val, "$$")
 	interpolated := make([]string, len(parts))
 	for i, v := range parts {
-		expanded, err := setting.ExpandVar(v)
+		expanded, err := interpolateOutsideSecretRefs(v)
 		if err != nil {
 			return val, val, fmt.Errorf("failed to interpolate value '%s': %w", val, err)
 		}
-		v = expanded
-		interpolated[i] = os.ExpandEnv(v)
+		interpolated[i] = expanded
 	}
 	return strings.Join(interpolated, "$"), val, nil
 }
 
+// interpolateOutsideSecretRefs interpolates the parts of val that aren't secret references
+func interpolateOutsideSecretRefs(val string) (string, error) {
+	var b strings.Builder
+	start := 0
+	for _, ref := range SecretRefRegex.FindAllStringIndex(val, -1) {
+		expanded, err := setting.ExpandVar(val[start:ref[0]])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(os.ExpandEnv(expanded))
+		b.WriteString(val[ref[0]:ref[1]])
+		start = ref[1]
+	}
+	expanded, err := setting.ExpandVar(val[start:])
+	if err != nil {
+		return "", err
+	}
+	b.WriteString(os.ExpandEnv(expanded))
+	return b.String(), nil
+}
+
 type interpolated struct {
 	value string
 	raw   string
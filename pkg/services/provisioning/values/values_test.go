@@ -329,6 +329,18 @@ func TestValues_readFile(t *testing.T) {
 	assert.Equal(t, expected, data.Val.Value())
 }
 
+func TestValues_secretRefs(t *testing.T) {
+	type Data struct {
+		Val StringValue `yaml:"val"`
+	}
+
+	t.Setenv("SECRET_NAMESPACE", "ci")
+	data := &Data{}
+	err := yaml.Unmarshal([]byte("val: $SECRET_NAMESPACE-$__secret{prometheus/password}-$__secret{$SECRET_NAMESPACE/token}"), data)
+	require.NoError(t, err)
+	assert.Equal(t, "ci-$__secret{prometheus/password}-$__secret{$SECRET_NAMESPACE/token}", data.Val.Value())
+}
+
 func TestValues_expanderError(t *testing.T) {
 	type Data struct {
 		Top JSONValue `yaml:"top"`
@@ -62,7 +62,7 @@ func setupTestServer(
 	}
 
 	var err error
-	ac, err := ossaccesscontrol.ProvideService(cfg, database.ProvideService(db), rr)
+	ac, err := ossaccesscontrol.ProvideService(cfg, database.ProvideService(db), rr, db.Bus(), nil)
 	require.NoError(t, err)
 
 	// build mux
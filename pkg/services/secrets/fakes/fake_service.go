@@ -18,6 +18,12 @@ func (f FakeSecretsService) Encrypt(_ context.Context, payload []byte, _ secrets
 func (f FakeSecretsService) Decrypt(_ context.Context, payload []byte) ([]byte, error) {
 	return payload, nil
 }
+func (f FakeSecretsService) EncryptWithAAD(_ context.Context, payload []byte, _ []byte, _ secrets.EncryptionOptions) ([]byte, error) {
+	return payload, nil
+}
+func (f FakeSecretsService) DecryptWithAAD(_ context.Context, payload []byte, _ []byte) ([]byte, error) {
+	return payload, nil
+}
 func (f FakeSecretsService) EncryptJsonData(_ context.Context, kv map[string]string, _ secrets.EncryptionOptions) (map[string][]byte, error) {
 	result := make(map[string][]byte, len(kv))
 	for key, value := range kv {
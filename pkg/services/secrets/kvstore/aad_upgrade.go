@@ -0,0 +1,157 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	AADUpgradeCheckpointKey = "aad_upgrade_checkpoint"
+
+	defaultAADUpgradeInterval = 10 * time.Minute
+)
+
+// aadUpgradeCheckpoint is the progress of the upgrade to the v2 envelope encryption format
+type aadUpgradeCheckpoint struct {
+	Table     string `json:"table"`
+	LastId    int64  `json:"lastId"`
+	Completed bool   `json:"completed"`
+}
+
+// AADUpgradeService encrypts again, in the v2 envelope encryption format, the values of the sql store and their
+// previous versions that were encrypted before the values were bound to their organization, namespace and type,
+// so that a value copied to another row can't be decrypted there. The values are upgraded in batches of the
+// data keys re-encryption, and the progress is checkpointed in the kvstore until every value is upgraded: the
+// values set since then are encrypted in the v2 format already.
+type AADUpgradeService struct {
+	reEncryption *DataKeyReEncryptionService
+	features     featuremgmt.FeatureToggles
+	interval     time.Duration
+	log          log.Logger
+}
+
+func ProvideAADUpgradeService(reEncryption *DataKeyReEncryptionService, features featuremgmt.FeatureToggles, cfg *setting.Cfg) *AADUpgradeService {
+	return &AADUpgradeService{
+		reEncryption: reEncryption,
+		features:     features,
+		interval:     cfg.SectionWithEnvOverrides("secrets").Key("aad_upgrade_interval").MustDuration(defaultAADUpgradeInterval),
+		log:          log.New("secrets.kvstore.aadupgrade"),
+	}
+}
+
+// IsDisabled returns true when the upgrade interval is not positive or envelope encryption is disabled, the
+// values are not encrypted in the v2 format then
+func (s *AADUpgradeService) IsDisabled() bool {
+	return s.interval <= 0 || s.features.IsEnabled(featuremgmt.FlagDisableEnvelopeEncryption)
+}
+
+func (s *AADUpgradeService) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			var completed bool
+			err := s.reEncryption.serverLock.LockAndExecute(ctx, "upgrade secrets encryption", s.interval/2, func(ctx context.Context) {
+				var err error
+				if completed, err = s.Upgrade(ctx); err != nil {
+					s.log.Error("failed to upgrade secrets encryption", "error", err)
+				}
+			})
+			if err != nil {
+				s.log.Error("failed to lock and execute secrets encryption upgrade", "error", err)
+			}
+			if completed {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Upgrade encrypts again in the v2 format the values encrypted in another format, from the last checkpoint. It
+// returns whether every value is upgraded.
+func (s *AADUpgradeService) Upgrade(ctx context.Context) (bool, error) {
+	checkpoint, err := s.getCheckpoint(ctx)
+	if err != nil {
+		return false, err
+	}
+	if checkpoint.Completed {
+		return true, nil
+	}
+	if checkpoint.Table == "" {
+		checkpoint.Table = reEncryptionTables[0]
+	}
+
+	s.log.Info("upgrading the secrets encryption", "table", checkpoint.Table, "lastId", checkpoint.LastId)
+	var upgraded, failed int
+	start := 0
+	for i, table := range reEncryptionTables {
+		if table == checkpoint.Table {
+			start = i
+		}
+	}
+	for _, table := range reEncryptionTables[start:] {
+		if table != checkpoint.Table {
+			checkpoint.Table, checkpoint.LastId = table, 0
+		}
+		for {
+			count, failures, lastId, err := s.reEncryption.reEncryptBatch(ctx, table, checkpoint.LastId, func(payload []byte) bool {
+				return len(payload) > 0 && payload[0] != secrets.EnvelopeV2Delimiter
+			})
+			if err != nil {
+				return false, err
+			}
+			if lastId == checkpoint.LastId {
+				break
+			}
+			upgraded += count
+			failed += failures
+			checkpoint.LastId = lastId
+			if err := s.setCheckpoint(ctx, checkpoint); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if failed > 0 {
+		// the next run starts over, the values that were upgraded are skipped
+		s.log.Warn("secrets encryption upgraded with errors", "upgraded", upgraded, "failed", failed)
+		return false, s.setCheckpoint(ctx, aadUpgradeCheckpoint{})
+	}
+	checkpoint.Completed = true
+	if err := s.setCheckpoint(ctx, checkpoint); err != nil {
+		return false, err
+	}
+	s.log.Info("secrets encryption upgraded, the secrets are bound to their organization, namespace and type", "upgraded", upgraded)
+	return true, nil
+}
+
+func (s *AADUpgradeService) getCheckpoint(ctx context.Context) (aadUpgradeCheckpoint, error) {
+	var checkpoint aadUpgradeCheckpoint
+	value, exists, err := s.reEncryption.kvstore.Get(ctx, AADUpgradeCheckpointKey)
+	if err != nil || !exists {
+		return checkpoint, err
+	}
+	if err := json.Unmarshal([]byte(value), &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("invalid encryption upgrade checkpoint %q: %w", value, err)
+	}
+	return checkpoint, nil
+}
+
+func (s *AADUpgradeService) setCheckpoint(ctx context.Context, checkpoint aadUpgradeCheckpoint) error {
+	value, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return s.reEncryption.kvstore.Set(ctx, AADUpgradeCheckpointKey, string(value))
+}
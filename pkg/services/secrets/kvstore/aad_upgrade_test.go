@@ -0,0 +1,80 @@
+package kvstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/services/secrets/database"
+	"github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestAADUpgradeService(t *testing.T) {
+	ctx := context.Background()
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsStore := database.ProvideSecretsStore(sqlStore)
+	secretsService := manager.SetupTestService(t, secretsStore)
+	cfg := &setting.Cfg{Raw: ini.Empty()}
+	reEncryption := ProvideDataKeyReEncryptionService(sqlStore, secretsService, secretsStore, kvstore.ProvideService(sqlStore), nil, cfg)
+	reEncryption.batchSize = 1
+	svc := ProvideAADUpgradeService(reEncryption, NewFakeFeatureToggles(t, false), cfg)
+	kv := reEncryption.store
+	kv.historyVersions = 2
+
+	// the values of the secrets and their versions encrypted in the v1 format
+	require.NoError(t, kv.Set(ctx, 1, "ds1", "datasource", "old"))
+	require.NoError(t, kv.Set(ctx, 1, "ds1", "datasource", "secret1"))
+	require.NoError(t, kv.Set(ctx, 2, "ds2", "datasource", "secret2"))
+	for _, table := range reEncryptionTables {
+		var rows []reEncryptionRow
+		require.NoError(t, sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+			return dbSession.Table(table).Cols("id", "org_id", "namespace", "type", "value").Find(&rows)
+		}))
+		for _, row := range rows {
+			decoded, err := b64.DecodeString(row.Value)
+			require.NoError(t, err)
+			decrypted, err := secretsService.DecryptWithAAD(ctx, decoded, secrets.ContextAAD(row.OrgId, row.Namespace, row.Type))
+			require.NoError(t, err)
+			encrypted, err := secretsService.Encrypt(ctx, decrypted, secrets.WithScope(orgDataKeyScope(row.OrgId)))
+			require.NoError(t, err)
+			require.NoError(t, sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+				_, err := dbSession.Exec("UPDATE "+table+" SET value = ? WHERE id = ?", b64.EncodeToString(encrypted), row.Id)
+				return err
+			}))
+		}
+	}
+
+	completed, err := svc.Upgrade(ctx)
+	require.NoError(t, err)
+	assert.True(t, completed)
+
+	for _, table := range reEncryptionTables {
+		var values []string
+		require.NoError(t, sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+			return dbSession.Table(table).Cols("value").Find(&values)
+		}))
+		require.NotEmpty(t, values)
+		for _, value := range values {
+			decoded, err := b64.DecodeString(value)
+			require.NoError(t, err)
+			assert.Equal(t, byte(secrets.EnvelopeV2Delimiter), decoded[0], "value of %s not upgraded", table)
+		}
+	}
+	kv.decryptionCache = newDecryptionCache(defaultDecryptionCacheMaxEntries, defaultDecryptionCacheTTL)
+	value, _, err := kv.Get(ctx, 1, "ds1", "datasource")
+	require.NoError(t, err)
+	assert.Equal(t, "secret1", value)
+	value, _, err = kv.GetVersion(ctx, 1, "ds1", "datasource", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "old", value)
+
+	checkpoint, err := svc.getCheckpoint(ctx)
+	require.NoError(t, err)
+	assert.True(t, checkpoint.Completed)
+}
@@ -0,0 +1,288 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/contexthandler"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+const (
+	ActionSecretsRead   = "secrets:read"
+	ActionSecretsWrite  = "secrets:write"
+	ActionSecretsDelete = "secrets:delete"
+
+	ScopeSecretsRoot = "secrets"
+)
+
+var (
+	ScopeSecretsProvider = accesscontrol.NewScopeProvider(ScopeSecretsRoot)
+	ScopeSecretsAll      = ScopeSecretsProvider.GetResourceAllScope()
+
+	ErrSecretAccessDenied = errors.New("access to the secret denied")
+)
+
+// ScopeSecret returns the scope of a secret, e.g. "secrets:type:datasource:prometheus" for the secret of the
+// prometheus data source. "secrets:type:datasource:*" matches the secrets of every data source.
+func ScopeSecret(typ string, namespace string) string {
+	return ScopeSecretsProvider.GetResourceScopeType(typ) + ":" + namespace
+}
+
+// readFilterBuilder restricts a query of the secrets table to the secrets the user can read
+var readFilterBuilder = accesscontrol.NewSQLFilterBuilder(ScopeSecretsRoot,
+	accesscontrol.SQLFilterAttribute{Name: "type", Columns: []string{"type", "namespace"}},
+)
+
+// readFilterKey is used as key to save the filter of the keys the user can read in `context.Context`
+type readFilterKey struct{}
+
+// readFilterFromContext returns the filter the sql store applies to the listed keys, so that the keys the user can't
+// read are not loaded
+func readFilterFromContext(ctx context.Context) (accesscontrol.SQLFilter, bool) {
+	filter, ok := ctx.Value(readFilterKey{}).(accesscontrol.SQLFilter)
+	return filter, ok
+}
+
+// serviceAccessKey is used as key to mark the `context.Context` of the accesses made on behalf of Grafana
+type serviceAccessKey struct{}
+
+// WithServiceAccess returns a context whose accesses to the secrets are made on behalf of Grafana rather than of the
+// signed in user, e.g. to decrypt the secrets of a data source the user queries, so that they are not checked
+// against the permissions of the user.
+func WithServiceAccess(ctx context.Context) context.Context {
+	return context.WithValue(ctx, serviceAccessKey{}, true)
+}
+
+// AccessControlService checks the accesses of the signed in users to the secrets store against their secrets
+// permissions. The accesses made without a signed in user, such as provisioning or background jobs, and the ones
+// of the Grafana server admins are not checked.
+type AccessControlService struct {
+	ac  accesscontrol.AccessControl
+	log log.Logger
+}
+
+func ProvideAccessControlService(ac accesscontrol.AccessControl) (*AccessControlService, error) {
+	s := &AccessControlService{ac: ac, log: log.New("secrets.kvstore.accesscontrol")}
+	if err := s.declareFixedRoles(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *AccessControlService) declareFixedRoles() error {
+	reader := accesscontrol.RoleRegistration{
+		Role: accesscontrol.RoleDTO{
+			Name:        "fixed:secrets:reader",
+			DisplayName: "Secrets reader",
+			Description: "Read the secrets of the organization.",
+			Group:       "Secrets",
+			Permissions: []accesscontrol.Permission{
+				{Action: ActionSecretsRead, Scope: ScopeSecretsAll},
+			},
+		},
+		Grants: []string{string(org.RoleAdmin)},
+	}
+
+	writer := accesscontrol.RoleRegistration{
+		Role: accesscontrol.RoleDTO{
+			Name:        "fixed:secrets:writer",
+			DisplayName: "Secrets writer",
+			Description: "Read, set and delete the secrets of the organization.",
+			Group:       "Secrets",
+			Permissions: accesscontrol.ConcatPermissions(reader.Role.Permissions, []accesscontrol.Permission{
+				{Action: ActionSecretsWrite, Scope: ScopeSecretsAll},
+				{Action: ActionSecretsDelete, Scope: ScopeSecretsAll},
+			}),
+		},
+		Grants: []string{string(org.RoleAdmin)},
+	}
+
+	return s.ac.DeclareFixedRoles(reader, writer)
+}
+
+// Wrap returns a SecretsKVStore checking the permissions of the signed in user before accessing store
+func (s *AccessControlService) Wrap(store SecretsKVStore) SecretsKVStore {
+	if s == nil || s.ac.IsDisabled() {
+		return store
+	}
+	return &accessControlledKVStore{store: store, service: s}
+}
+
+// authorize returns ErrSecretAccessDenied when the signed in user may not apply action to every given secret
+func (s *AccessControlService) authorize(ctx context.Context, action string, keys ...Key) error {
+	usr := signedInUser(ctx)
+	if usr == nil {
+		return nil
+	}
+	for _, key := range keys {
+		// the permissions of the user are the ones of their current organization
+		if key.OrgId != usr.OrgID {
+			return ErrSecretAccessDenied
+		}
+		ok, err := s.ac.Evaluate(ctx, usr, accesscontrol.EvalPermission(action, ScopeSecret(key.Type, key.Namespace)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			s.log.Debug("access to secret denied", "action", action, "orgId", key.OrgId, "namespace", key.Namespace, "type", key.Type, "userId", usr.UserID)
+			return ErrSecretAccessDenied
+		}
+	}
+	return nil
+}
+
+// withReadFilter returns a context with the filter of the keys the signed in user may read, when their permissions
+// are loaded
+func (s *AccessControlService) withReadFilter(ctx context.Context) (context.Context, error) {
+	usr := signedInUser(ctx)
+	if usr == nil || usr.Permissions == nil || usr.Permissions[usr.OrgID] == nil {
+		return ctx, nil
+	}
+	filter, err := readFilterBuilder.Build(usr, ActionSecretsRead)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, readFilterKey{}, filter), nil
+}
+
+// filter returns the keys the signed in user may read
+func (s *AccessControlService) filter(ctx context.Context, keys []Key) ([]Key, error) {
+	if signedInUser(ctx) == nil {
+		return keys, nil
+	}
+	filtered := make([]Key, 0, len(keys))
+	for _, key := range keys {
+		err := s.authorize(ctx, ActionSecretsRead, key)
+		if errors.Is(err, ErrSecretAccessDenied) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		filtered = append(filtered, key)
+	}
+	return filtered, nil
+}
+
+// signedInUser returns the user whose permissions are checked, nil when the access is not checked
+func signedInUser(ctx context.Context) *user.SignedInUser {
+	if serviceAccess, _ := ctx.Value(serviceAccessKey{}).(bool); serviceAccess {
+		return nil
+	}
+	reqCtx := contexthandler.FromContext(ctx)
+	if reqCtx == nil || reqCtx.SignedInUser == nil || reqCtx.SignedInUser.IsGrafanaAdmin {
+		return nil
+	}
+	return reqCtx.SignedInUser
+}
+
+// accessControlledKVStore checks the permissions of the signed in user before accessing the wrapped store
+type accessControlledKVStore struct {
+	store   SecretsKVStore
+	service *AccessControlService
+}
+
+func (kv *accessControlledKVStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	if err := kv.service.authorize(ctx, ActionSecretsRead, Key{OrgId: orgId, Namespace: namespace, Type: typ}); err != nil {
+		return "", false, err
+	}
+	return kv.store.Get(ctx, orgId, namespace, typ)
+}
+
+func (kv *accessControlledKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	if err := kv.service.authorize(ctx, ActionSecretsWrite, Key{OrgId: orgId, Namespace: namespace, Type: typ}); err != nil {
+		return err
+	}
+	return kv.store.Set(ctx, orgId, namespace, typ, value)
+}
+
+func (kv *accessControlledKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	if err := kv.service.authorize(ctx, ActionSecretsWrite, Key{OrgId: orgId, Namespace: namespace, Type: typ}); err != nil {
+		return err
+	}
+	return kv.store.SetWithTTL(ctx, orgId, namespace, typ, value, ttl)
+}
+
+func (kv *accessControlledKVStore) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	if err := kv.service.authorize(ctx, ActionSecretsDelete, Key{OrgId: orgId, Namespace: namespace, Type: typ}); err != nil {
+		return err
+	}
+	return kv.store.Del(ctx, orgId, namespace, typ)
+}
+
+func (kv *accessControlledKVStore) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	keys, err := kv.store.Keys(ctx, orgId, namespace, typ)
+	if err != nil {
+		return nil, err
+	}
+	return kv.service.filter(ctx, keys)
+}
+
+// ListKeys lets the sql store filter the keys, the keys of the other stores are filtered once listed
+func (kv *accessControlledKVStore) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	ctx, err := kv.service.withReadFilter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := kv.store.ListKeys(ctx, orgId, namespacePattern, typeFilter)
+	if err != nil {
+		return nil, err
+	}
+	return kv.service.filter(ctx, keys)
+}
+
+// Rename needs the permission to write the secret under both its current and its new namespace
+func (kv *accessControlledKVStore) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	err := kv.service.authorize(ctx, ActionSecretsWrite,
+		Key{OrgId: orgId, Namespace: namespace, Type: typ}, Key{OrgId: orgId, Namespace: newNamespace, Type: typ})
+	if err != nil {
+		return err
+	}
+	return kv.store.Rename(ctx, orgId, namespace, typ, newNamespace)
+}
+
+func (kv *accessControlledKVStore) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	if err := kv.service.authorize(ctx, ActionSecretsRead, Key{OrgId: orgId, Namespace: namespace, Type: typ}); err != nil {
+		return "", false, err
+	}
+	return kv.store.GetVersion(ctx, orgId, namespace, typ, version)
+}
+
+func (kv *accessControlledKVStore) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	if err := kv.service.authorize(ctx, ActionSecretsRead, Key{OrgId: orgId, Namespace: namespace, Type: typ}); err != nil {
+		return nil, err
+	}
+	return kv.store.ListVersions(ctx, orgId, namespace, typ)
+}
+
+func (kv *accessControlledKVStore) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	if err := kv.service.authorize(ctx, ActionSecretsWrite, Key{OrgId: orgId, Namespace: namespace, Type: typ}); err != nil {
+		return err
+	}
+	return kv.store.Rollback(ctx, orgId, namespace, typ, version)
+}
+
+// SetMultiple sets none of the items when the user may not write one of them
+func (kv *accessControlledKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	keys := make([]Key, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, Key{OrgId: *item.OrgId, Namespace: *item.Namespace, Type: *item.Type})
+	}
+	if err := kv.service.authorize(ctx, ActionSecretsWrite, keys...); err != nil {
+		return err
+	}
+	return kv.store.SetMultiple(ctx, items)
+}
+
+// DelMultiple deletes none of the items when the user may not delete one of them
+func (kv *accessControlledKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	if err := kv.service.authorize(ctx, ActionSecretsDelete, keys...); err != nil {
+		return err
+	}
+	return kv.store.DelMultiple(ctx, keys)
+}
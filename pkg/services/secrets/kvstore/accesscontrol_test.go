@@ -0,0 +1,170 @@
+package kvstore
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/contexthandler/ctxkey"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessControlService(t *testing.T) {
+	signedInCtx := func(usr *user.SignedInUser) context.Context {
+		reqCtx := &models.ReqContext{Context: &web.Context{Req: &http.Request{}}, SignedInUser: usr}
+		return ctxkey.Set(context.Background(), reqCtx)
+	}
+	setup := func(t *testing.T, permissions map[string][]string) (SecretsKVStore, context.Context) {
+		t.Helper()
+		service, err := ProvideAccessControlService(accesscontrolmock.New())
+		require.NoError(t, err)
+		store := NewFakeSecretsKVStore()
+		require.NoError(t, store.Set(context.Background(), 1, "prometheus", DataSourceSecretType, "prometheus secret"))
+		require.NoError(t, store.Set(context.Background(), 1, "loki", DataSourceSecretType, "loki secret"))
+		usr := &user.SignedInUser{UserID: 2, OrgID: 1, Permissions: map[int64]map[string][]string{1: permissions}}
+		return service.Wrap(store), signedInCtx(usr)
+	}
+
+	t.Run("declares fixed roles granting every secret", func(t *testing.T) {
+		ac := accesscontrolmock.New()
+		_, err := ProvideAccessControlService(ac)
+		require.NoError(t, err)
+		require.Len(t, ac.Calls.DeclareFixedRoles, 1)
+
+		registrations := ac.Calls.DeclareFixedRoles[0].([]interface{})[0].([]accesscontrol.RoleRegistration)
+		var permissions []accesscontrol.Permission
+		for _, registration := range registrations {
+			permissions = append(permissions, registration.Role.Permissions...)
+		}
+		assert.Contains(t, permissions, accesscontrol.Permission{Action: ActionSecretsDelete, Scope: "secrets:*"})
+	})
+
+	t.Run("returns the store unchanged when access control is disabled", func(t *testing.T) {
+		service, err := ProvideAccessControlService(accesscontrolmock.New().WithDisabled())
+		require.NoError(t, err)
+		store := NewFakeSecretsKVStore()
+		assert.Equal(t, store, service.Wrap(store))
+		var nilService *AccessControlService
+		assert.Equal(t, store, nilService.Wrap(store))
+	})
+
+	t.Run("secrets are read, written and deleted with the permissions of the secret", func(t *testing.T) {
+		kv, ctx := setup(t, map[string][]string{
+			ActionSecretsRead:   {ScopeSecret(DataSourceSecretType, "prometheus")},
+			ActionSecretsWrite:  {ScopeSecret(DataSourceSecretType, "prometheus")},
+			ActionSecretsDelete: {ScopeSecret(DataSourceSecretType, "prometheus")},
+		})
+
+		value, found, err := kv.Get(ctx, 1, "prometheus", DataSourceSecretType)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "prometheus secret", value)
+		require.NoError(t, kv.Set(ctx, 1, "prometheus", DataSourceSecretType, "updated"))
+		require.NoError(t, kv.Del(ctx, 1, "prometheus", DataSourceSecretType))
+
+		_, _, err = kv.Get(ctx, 1, "loki", DataSourceSecretType)
+		assert.ErrorIs(t, err, ErrSecretAccessDenied)
+		assert.ErrorIs(t, kv.Set(ctx, 1, "loki", DataSourceSecretType, "updated"), ErrSecretAccessDenied)
+		assert.ErrorIs(t, kv.Del(ctx, 1, "loki", DataSourceSecretType), ErrSecretAccessDenied)
+		value, _, err = kv.Get(context.Background(), 1, "loki", DataSourceSecretType)
+		require.NoError(t, err)
+		assert.Equal(t, "loki secret", value)
+	})
+
+	t.Run("secrets of other organizations are denied", func(t *testing.T) {
+		kv, ctx := setup(t, map[string][]string{ActionSecretsRead: {ScopeSecretsAll}})
+
+		_, _, err := kv.Get(ctx, 2, "prometheus", DataSourceSecretType)
+		assert.ErrorIs(t, err, ErrSecretAccessDenied)
+	})
+
+	t.Run("renaming needs the permission on both namespaces", func(t *testing.T) {
+		kv, ctx := setup(t, map[string][]string{
+			ActionSecretsWrite: {ScopeSecret(DataSourceSecretType, "prometheus")},
+		})
+
+		err := kv.Rename(ctx, 1, "prometheus", DataSourceSecretType, "mimir")
+		assert.ErrorIs(t, err, ErrSecretAccessDenied)
+	})
+
+	t.Run("setting several items needs the permission on each of them", func(t *testing.T) {
+		kv, ctx := setup(t, map[string][]string{
+			ActionSecretsWrite: {ScopeSecret(DataSourceSecretType, "prometheus")},
+		})
+		orgId, typ := int64(1), DataSourceSecretType
+		prometheus, loki := "prometheus", "loki"
+
+		err := kv.SetMultiple(ctx, []Item{
+			{OrgId: &orgId, Namespace: &prometheus, Type: &typ, Value: "updated"},
+			{OrgId: &orgId, Namespace: &loki, Type: &typ, Value: "updated"},
+		})
+		assert.ErrorIs(t, err, ErrSecretAccessDenied)
+		value, _, err := kv.Get(context.Background(), 1, "prometheus", DataSourceSecretType)
+		require.NoError(t, err)
+		assert.Equal(t, "prometheus secret", value)
+	})
+
+	t.Run("listed keys are filtered by the read permission", func(t *testing.T) {
+		kv, ctx := setup(t, map[string][]string{
+			ActionSecretsRead: {ScopeSecret(DataSourceSecretType, "loki")},
+		})
+
+		keys, err := kv.ListKeys(ctx, 1, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, []Key{{OrgId: 1, Namespace: "loki", Type: DataSourceSecretType}}, keys)
+	})
+
+	t.Run("wildcard scopes match the secrets of a type", func(t *testing.T) {
+		kv, ctx := setup(t, map[string][]string{
+			ActionSecretsRead: {ScopeSecret(DataSourceSecretType, "*")},
+		})
+
+		_, found, err := kv.Get(ctx, 1, "loki", DataSourceSecretType)
+		require.NoError(t, err)
+		assert.True(t, found)
+		_, _, err = kv.Get(ctx, 1, "loki", "plugin")
+		assert.ErrorIs(t, err, ErrSecretAccessDenied)
+	})
+
+	t.Run("accesses on behalf of Grafana and of server admins are not checked", func(t *testing.T) {
+		kv, ctx := setup(t, map[string][]string{})
+
+		_, found, err := kv.Get(WithServiceAccess(ctx), 1, "loki", DataSourceSecretType)
+		require.NoError(t, err)
+		assert.True(t, found)
+
+		adminCtx := signedInCtx(&user.SignedInUser{UserID: 1, OrgID: 1, IsGrafanaAdmin: true,
+			Permissions: map[int64]map[string][]string{1: {}}})
+		_, found, err = kv.Get(adminCtx, 1, "loki", DataSourceSecretType)
+		require.NoError(t, err)
+		assert.True(t, found)
+	})
+
+	t.Run("the sql store only lists the keys the user can read", func(t *testing.T) {
+		service, err := ProvideAccessControlService(accesscontrolmock.New())
+		require.NoError(t, err)
+		store := SetupTestService(t)
+		for _, namespace := range []string{"prometheus", "loki", "tempo"} {
+			require.NoError(t, store.Set(context.Background(), 1, namespace, DataSourceSecretType, "secret"))
+		}
+		require.NoError(t, store.Set(context.Background(), 1, "app", "plugin", "secret"))
+		usr := &user.SignedInUser{UserID: 2, OrgID: 1, Permissions: map[int64]map[string][]string{1: {
+			ActionSecretsRead: {ScopeSecret(DataSourceSecretType, "loki"), ScopeSecret("plugin", "*")},
+		}}}
+
+		ctx, err := service.withReadFilter(signedInCtx(usr))
+		require.NoError(t, err)
+		keys, err := store.ListKeys(ctx, 1, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, []Key{
+			{OrgId: 1, Namespace: "app", Type: "plugin"},
+			{OrgId: 1, Namespace: "loki", Type: DataSourceSecretType},
+		}, keys)
+	})
+}
@@ -0,0 +1,348 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/contexthandler"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"xorm.io/xorm"
+)
+
+// Operations recorded in the audit trail
+const (
+	AuditOperationGet        = "get"
+	AuditOperationSet        = "set"
+	AuditOperationDelete     = "delete"
+	AuditOperationRename     = "rename"
+	AuditOperationGetVersion = "get_version"
+	AuditOperationRollback   = "rollback"
+	AuditOperationGetAll     = "get_all"
+)
+
+// Outcomes of the recorded operations
+const (
+	AuditOutcomeSuccess  = "success"
+	AuditOutcomeNotFound = "not_found"
+	AuditOutcomeError    = "error"
+)
+
+const (
+	auditBufferSize      = 1000
+	auditFlushInterval   = time.Second
+	auditCleanupInterval = time.Hour
+	grafanaPackagePrefix = "github.com/grafana/grafana/"
+)
+
+// AuditEntry is an access to a secret. Values are never recorded.
+type AuditEntry struct {
+	Id        int64     `json:"id"`
+	OrgId     int64     `json:"orgId"`
+	Namespace string    `json:"namespace"`
+	Type      string    `json:"type"`
+	Operation string    `json:"operation"`
+	Caller    string    `json:"caller"`
+	UserId    int64     `json:"userId"`
+	Outcome   string    `json:"outcome"`
+	Created   time.Time `json:"created"`
+}
+
+func (e *AuditEntry) TableName() string {
+	return "secrets_audit"
+}
+
+// AuditQuery filters the audit trail, empty fields match every entry
+type AuditQuery struct {
+	OrgId     *int64
+	Namespace string
+	Type      string
+	Operation string
+	From      time.Time
+	To        time.Time
+	Page      int
+	PerPage   int
+}
+
+// AuditQueryResult is a page of the audit trail, the most recent entries first
+type AuditQueryResult struct {
+	TotalCount int64         `json:"totalCount"`
+	Entries    []*AuditEntry `json:"entries"`
+	Page       int           `json:"page"`
+	PerPage    int           `json:"perPage"`
+}
+
+// AuditService records the accesses to the secrets kvstore in an append-only table. Entries are buffered and
+// written in batches by Run, and deleted once they are older than the retention.
+type AuditService struct {
+	enabled   bool
+	retention time.Duration
+	sqlStore  sqlstore.Store
+	entries   chan *AuditEntry
+	log       log.Logger
+}
+
+func ProvideAuditService(cfg *setting.Cfg, sqlStore sqlstore.Store) *AuditService {
+	section := cfg.SectionWithEnvOverrides("secrets")
+	return &AuditService{
+		enabled:   section.Key("audit_enabled").MustBool(false),
+		retention: section.Key("audit_retention").MustDuration(30 * 24 * time.Hour),
+		sqlStore:  sqlStore,
+		entries:   make(chan *AuditEntry, auditBufferSize),
+		log:       log.New("secrets.kvstore.audit"),
+	}
+}
+
+func (a *AuditService) IsDisabled() bool {
+	return a == nil || !a.enabled
+}
+
+// Wrap returns a SecretsKVStore that records the accesses to store, or store itself when auditing is disabled
+func (a *AuditService) Wrap(store SecretsKVStore) SecretsKVStore {
+	if a.IsDisabled() {
+		return store
+	}
+	return &auditedKVStore{store: store, audit: a}
+}
+
+// Record adds an access to the audit trail, err is the error returned by the access, if any
+func (a *AuditService) Record(ctx context.Context, operation string, orgId int64, namespace string, typ string, found bool, err error) {
+	if a.IsDisabled() {
+		return
+	}
+
+	entry := &AuditEntry{
+		OrgId:     orgId,
+		Namespace: namespace,
+		Type:      typ,
+		Operation: operation,
+		Caller:    auditCaller(),
+		Outcome:   AuditOutcomeSuccess,
+		Created:   time.Now(),
+	}
+	if reqCtx := contexthandler.FromContext(ctx); reqCtx != nil && reqCtx.SignedInUser != nil {
+		entry.UserId = reqCtx.SignedInUser.UserID
+	}
+	switch {
+	case err != nil:
+		entry.Outcome = AuditOutcomeError
+	case !found:
+		entry.Outcome = AuditOutcomeNotFound
+	}
+
+	select {
+	case a.entries <- entry:
+	default:
+		auditDroppedCounter.Inc()
+		a.log.Warn("secrets audit buffer is full, dropping entry", "operation", operation, "orgId", orgId, "namespace", namespace, "type", typ)
+	}
+}
+
+func (a *AuditService) Run(ctx context.Context) error {
+	if a.IsDisabled() {
+		return nil
+	}
+
+	flushTicker := time.NewTicker(auditFlushInterval)
+	defer flushTicker.Stop()
+	cleanupTicker := time.NewTicker(auditCleanupInterval)
+	defer cleanupTicker.Stop()
+
+	for {
+		select {
+		case <-flushTicker.C:
+			a.flush(ctx)
+		case <-cleanupTicker.C:
+			if err := a.deleteExpired(ctx, time.Now()); err != nil {
+				a.log.Error("failed to delete expired secrets audit entries", "error", err)
+			}
+		case <-ctx.Done():
+			// the last entries are written even though the server is stopping
+			a.flush(context.Background())
+			return ctx.Err()
+		}
+	}
+}
+
+// flush writes the buffered entries
+func (a *AuditService) flush(ctx context.Context) {
+	var batch []*AuditEntry
+loop:
+	for len(batch) < auditBufferSize {
+		select {
+		case entry := <-a.entries:
+			batch = append(batch, entry)
+		default:
+			break loop
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	err := a.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		_, err := dbSession.InsertMulti(batch)
+		return err
+	})
+	if err != nil {
+		auditDroppedCounter.Add(float64(len(batch)))
+		a.log.Error("failed to write secrets audit entries", "count", len(batch), "error", err)
+	}
+}
+
+func (a *AuditService) deleteExpired(ctx context.Context, now time.Time) error {
+	return a.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		_, err := dbSession.Where("created < ?", now.Add(-a.retention)).Delete(&AuditEntry{})
+		return err
+	})
+}
+
+// Search returns a page of the audit trail
+func (a *AuditService) Search(ctx context.Context, query AuditQuery) (*AuditQueryResult, error) {
+	if query.PerPage <= 0 {
+		query.PerPage = 100
+	}
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+
+	result := &AuditQueryResult{Entries: []*AuditEntry{}, Page: query.Page, PerPage: query.PerPage}
+	err := a.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		filter := func() *xorm.Session {
+			sess := dbSession.Table(&AuditEntry{})
+			if query.OrgId != nil {
+				sess.Where("org_id = ?", *query.OrgId)
+			}
+			if query.Namespace != "" {
+				sess.Where("namespace = ?", query.Namespace)
+			}
+			if query.Type != "" {
+				sess.Where("type = ?", query.Type)
+			}
+			if query.Operation != "" {
+				sess.Where("operation = ?", query.Operation)
+			}
+			if !query.From.IsZero() {
+				sess.Where("created >= ?", query.From)
+			}
+			if !query.To.IsZero() {
+				sess.Where("created <= ?", query.To)
+			}
+			return sess
+		}
+
+		var err error
+		if result.TotalCount, err = filter().Count(); err != nil {
+			return err
+		}
+		return filter().Desc("id").Limit(query.PerPage, (query.Page-1)*query.PerPage).Find(&result.Entries)
+	})
+	return result, err
+}
+
+// auditCaller returns the package that called the secrets kvstore
+func auditCaller() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		pkg := functionPackage(frame.Function)
+		if pkg != "" && !strings.HasSuffix(pkg, "pkg/services/secrets/kvstore") {
+			return strings.TrimPrefix(pkg, grafanaPackagePrefix)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// functionPackage returns the package of a function name, e.g. github.com/grafana/grafana/pkg/api for
+// github.com/grafana/grafana/pkg/api.(*HTTPServer).GetDataSourceById
+func functionPackage(function string) string {
+	lastSlash := strings.LastIndex(function, "/")
+	dot := strings.Index(function[lastSlash+1:], ".")
+	if dot < 0 {
+		return function
+	}
+	return function[:lastSlash+1+dot]
+}
+
+// auditedKVStore records the accesses to the wrapped store
+type auditedKVStore struct {
+	store SecretsKVStore
+	audit *AuditService
+}
+
+func (kv *auditedKVStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	value, found, err := kv.store.Get(ctx, orgId, namespace, typ)
+	kv.audit.Record(ctx, AuditOperationGet, orgId, namespace, typ, found, err)
+	return value, found, err
+}
+
+func (kv *auditedKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	err := kv.store.Set(ctx, orgId, namespace, typ, value)
+	kv.audit.Record(ctx, AuditOperationSet, orgId, namespace, typ, true, err)
+	return err
+}
+
+func (kv *auditedKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	err := kv.store.SetWithTTL(ctx, orgId, namespace, typ, value, ttl)
+	kv.audit.Record(ctx, AuditOperationSet, orgId, namespace, typ, true, err)
+	return err
+}
+
+func (kv *auditedKVStore) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	err := kv.store.Del(ctx, orgId, namespace, typ)
+	kv.audit.Record(ctx, AuditOperationDelete, orgId, namespace, typ, true, err)
+	return err
+}
+
+func (kv *auditedKVStore) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	return kv.store.Keys(ctx, orgId, namespace, typ)
+}
+
+func (kv *auditedKVStore) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	return kv.store.ListKeys(ctx, orgId, namespacePattern, typeFilter)
+}
+
+func (kv *auditedKVStore) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	err := kv.store.Rename(ctx, orgId, namespace, typ, newNamespace)
+	kv.audit.Record(ctx, AuditOperationRename, orgId, namespace, typ, true, err)
+	return err
+}
+
+func (kv *auditedKVStore) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	value, found, err := kv.store.GetVersion(ctx, orgId, namespace, typ, version)
+	kv.audit.Record(ctx, AuditOperationGetVersion, orgId, namespace, typ, found, err)
+	return value, found, err
+}
+
+func (kv *auditedKVStore) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	return kv.store.ListVersions(ctx, orgId, namespace, typ)
+}
+
+func (kv *auditedKVStore) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	err := kv.store.Rollback(ctx, orgId, namespace, typ, version)
+	kv.audit.Record(ctx, AuditOperationRollback, orgId, namespace, typ, !errors.Is(err, ErrSecretVersionNotFound), err)
+	return err
+}
+
+func (kv *auditedKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	err := kv.store.SetMultiple(ctx, items)
+	for _, item := range items {
+		kv.audit.Record(ctx, AuditOperationSet, *item.OrgId, *item.Namespace, *item.Type, true, err)
+	}
+	return err
+}
+
+func (kv *auditedKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	err := kv.store.DelMultiple(ctx, keys)
+	for _, key := range keys {
+		kv.audit.Record(ctx, AuditOperationDelete, key.OrgId, key.Namespace, key.Type, true, err)
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/contexthandler/ctxkey"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestAuditService(t *testing.T) {
+	t.Run("returns the store unchanged when disabled", func(t *testing.T) {
+		store := NewFakeSecretsKVStore()
+		audit := setupAuditService(t, false)
+
+		assert.Equal(t, store, audit.Wrap(store))
+		var nilAudit *AuditService
+		assert.Equal(t, store, nilAudit.Wrap(store))
+	})
+
+	t.Run("records the accesses without the values", func(t *testing.T) {
+		audit := setupAuditService(t, true)
+		kv := audit.Wrap(NewFakeSecretsKVStore())
+		reqCtx := &models.ReqContext{Context: &web.Context{Req: &http.Request{}}, SignedInUser: &user.SignedInUser{UserID: 7}}
+		ctx := ctxkey.Set(context.Background(), reqCtx)
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "secret"))
+		_, found, err := kv.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		require.True(t, found)
+		_, found, err = kv.Get(context.Background(), 2, "missing", "datasource")
+		require.NoError(t, err)
+		require.False(t, found)
+		require.NoError(t, kv.Del(ctx, 1, "ds", "datasource"))
+		audit.flush(context.Background())
+
+		result, err := audit.Search(context.Background(), AuditQuery{})
+		require.NoError(t, err)
+		require.EqualValues(t, 4, result.TotalCount)
+		var recorded []string
+		for _, entry := range result.Entries {
+			recorded = append(recorded, entry.Operation+":"+entry.Outcome)
+			assert.Equal(t, "testing", entry.Caller)
+		}
+		assert.Equal(t, []string{"delete:success", "get:not_found", "get:success", "set:success"}, recorded)
+		assert.Equal(t, int64(7), result.Entries[0].UserId)
+		assert.Equal(t, int64(0), result.Entries[1].UserId)
+	})
+
+	t.Run("records the errors", func(t *testing.T) {
+		audit := setupAuditService(t, true)
+
+		audit.Record(context.Background(), AuditOperationGetAll, AllOrganizations, "", "", true, errors.New("sql error"))
+		audit.flush(context.Background())
+
+		result, err := audit.Search(context.Background(), AuditQuery{})
+		require.NoError(t, err)
+		require.Len(t, result.Entries, 1)
+		assert.Equal(t, AuditOutcomeError, result.Entries[0].Outcome)
+		assert.Equal(t, int64(AllOrganizations), result.Entries[0].OrgId)
+	})
+
+	t.Run("filters and pages the entries", func(t *testing.T) {
+		audit := setupAuditService(t, true)
+		ctx := context.Background()
+		for _, namespace := range []string{"ds1", "ds2", "ds1", "ds1"} {
+			audit.Record(ctx, AuditOperationGet, 1, namespace, "datasource", true, nil)
+		}
+		audit.Record(ctx, AuditOperationGet, 2, "ds1", "datasource", true, nil)
+		audit.flush(ctx)
+
+		orgId := int64(1)
+		result, err := audit.Search(ctx, AuditQuery{OrgId: &orgId, Namespace: "ds1", PerPage: 2})
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, result.TotalCount)
+		assert.Len(t, result.Entries, 2)
+
+		result, err = audit.Search(ctx, AuditQuery{OrgId: &orgId, Namespace: "ds1", PerPage: 2, Page: 2})
+		require.NoError(t, err)
+		assert.Len(t, result.Entries, 1)
+
+		result, err = audit.Search(ctx, AuditQuery{From: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		assert.Empty(t, result.Entries)
+	})
+
+	t.Run("deletes the entries past the retention", func(t *testing.T) {
+		audit := setupAuditService(t, true)
+		ctx := context.Background()
+		audit.Record(ctx, AuditOperationGet, 1, "ds", "datasource", true, nil)
+		audit.flush(ctx)
+
+		require.NoError(t, audit.deleteExpired(ctx, time.Now().Add(audit.retention-time.Minute)))
+		result, err := audit.Search(ctx, AuditQuery{})
+		require.NoError(t, err)
+		assert.Len(t, result.Entries, 1)
+
+		require.NoError(t, audit.deleteExpired(ctx, time.Now().Add(audit.retention+time.Minute)))
+		result, err = audit.Search(ctx, AuditQuery{})
+		require.NoError(t, err)
+		assert.Empty(t, result.Entries)
+	})
+}
+
+func setupAuditService(t *testing.T, enabled bool) *AuditService {
+	t.Helper()
+	raw, err := ini.Load([]byte(`
+		[secrets]
+		audit_enabled = ` + strconv.FormatBool(enabled)))
+	require.NoError(t, err)
+	return ProvideAuditService(&setting.Cfg{Raw: raw}, sqlstore.InitTestDB(t))
+}
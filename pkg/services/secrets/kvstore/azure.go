@@ -0,0 +1,448 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	AzureAuthManagedIdentity = "managed_identity"
+	AzureAuthClientSecret    = "client_secret"
+
+	// AzureSoftDeleteRecover keeps deleted secrets recoverable, and recovers
+	// them when they are set again.
+	AzureSoftDeleteRecover = "recover"
+	// AzureSoftDeletePurge purges deleted secrets, which needs the purge permission.
+	AzureSoftDeletePurge = "purge"
+
+	azureKeyVaultAPIVersion = "7.3"
+	azureKeyVaultScope      = "https://vault.azure.net/.default"
+
+	azureTagOrgId     = "grafana-org-id"
+	azureTagNamespace = "grafana-namespace"
+	azureTagType      = "grafana-type"
+)
+
+var (
+	// Deleting, purging and recovering secrets is asynchronous in Key Vault,
+	// requests on a secret in one of these states conflict until it is done.
+	azureConflictRetries    = 10
+	azureConflictRetryDelay = time.Second
+
+	azureNameUnsafeChars = regexp.MustCompile(`[^0-9a-zA-Z]+`)
+	azureNamePrefix      = regexp.MustCompile(`^[0-9a-zA-Z-]{1,20}$`)
+)
+
+// azureKeyVaultSettings are read from the `secrets.azure_key_vault` section of the configuration.
+type azureKeyVaultSettings struct {
+	URL        string
+	NamePrefix string
+	SoftDelete string
+	Timeout    time.Duration
+
+	AuthMethod string
+	// managed identity auth, the system-assigned identity is used without client ID
+	ManagedIdentityClientID string
+	// client secret auth
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+}
+
+func readAzureKeyVaultSettings(cfg *setting.Cfg) azureKeyVaultSettings {
+	section := cfg.SectionWithEnvOverrides("secrets.azure_key_vault")
+	return azureKeyVaultSettings{
+		URL:                     strings.TrimSuffix(section.Key("url").MustString(""), "/"),
+		NamePrefix:              section.Key("name_prefix").MustString("grafana"),
+		SoftDelete:              section.Key("soft_delete").MustString(AzureSoftDeleteRecover),
+		Timeout:                 section.Key("timeout").MustDuration(10 * time.Second),
+		AuthMethod:              section.Key("auth_method").MustString(AzureAuthManagedIdentity),
+		ManagedIdentityClientID: section.Key("managed_identity_client_id").MustString(""),
+		TenantID:                section.Key("tenant_id").MustString(""),
+		ClientID:                section.Key("client_id").MustString(""),
+		ClientSecret:            section.Key("client_secret").MustString(""),
+	}
+}
+
+func (s azureKeyVaultSettings) validate() error {
+	if s.URL == "" {
+		return errors.New("azure key vault requires `url`")
+	}
+	if !azureNamePrefix.MatchString(s.NamePrefix) {
+		return fmt.Errorf("azure key vault name_prefix %q must be up to 20 letters, digits and dashes", s.NamePrefix)
+	}
+	if s.SoftDelete != AzureSoftDeleteRecover && s.SoftDelete != AzureSoftDeletePurge {
+		return fmt.Errorf("unknown azure key vault soft_delete %q", s.SoftDelete)
+	}
+	return nil
+}
+
+func (s azureKeyVaultSettings) credential() (azcore.TokenCredential, error) {
+	switch s.AuthMethod {
+	case AzureAuthManagedIdentity:
+		options := &azidentity.ManagedIdentityCredentialOptions{}
+		if s.ManagedIdentityClientID != "" {
+			options.ID = azidentity.ClientID(s.ManagedIdentityClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(options)
+	case AzureAuthClientSecret:
+		if s.TenantID == "" || s.ClientID == "" || s.ClientSecret == "" {
+			return nil, errors.New("azure key vault client_secret auth requires `tenant_id`, `client_id` and `client_secret`")
+		}
+		return azidentity.NewClientSecretCredential(s.TenantID, s.ClientID, s.ClientSecret, nil)
+	}
+	return nil, fmt.Errorf("unknown azure key vault auth_method %q", s.AuthMethod)
+}
+
+// secretsKVStoreAzure provides a key/value store backed by Azure Key Vault secrets.
+// Secret names only allow letters, digits and dashes, so the org/namespace/type
+// triple is mangled into the name and kept as tags of the secret.
+type secretsKVStoreAzure struct {
+	log                            log.Logger
+	settings                       azureKeyVaultSettings
+	client                         *http.Client
+	credential                     azcore.TokenCredential
+	kvstore                        *kvstore.NamespacedKVStore
+	backwardsCompatibilityDisabled bool
+
+	tokenMu sync.Mutex
+	token   *azcore.AccessToken
+}
+
+func newSecretsKVStoreAzure(settings azureKeyVaultSettings, kv *kvstore.NamespacedKVStore, backwardsCompatibilityDisabled bool, logger log.Logger) (*secretsKVStoreAzure, error) {
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+	credential, err := settings.credential()
+	if err != nil {
+		return nil, err
+	}
+	return &secretsKVStoreAzure{
+		log:                            logger,
+		settings:                       settings,
+		client:                         &http.Client{Timeout: settings.Timeout},
+		credential:                     credential,
+		kvstore:                        kv,
+		backwardsCompatibilityDisabled: backwardsCompatibilityDisabled,
+	}, nil
+}
+
+type azureSecret struct {
+	ID    string            `json:"id,omitempty"`
+	Value string            `json:"value,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+type azureSecretList struct {
+	Value    []azureSecret `json:"value"`
+	NextLink string        `json:"nextLink"`
+}
+
+// Get an item from the store
+func (kv *secretsKVStoreAzure) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	var secret azureSecret
+	status, err := kv.do(ctx, http.MethodGet, kv.secretURL("secrets", orgId, namespace, typ, ""), nil, &secret)
+	if status == http.StatusNotFound {
+		kv.log.Debug("secret value not found", "orgId", orgId, "type", typ, "namespace", namespace)
+		return "", false, nil
+	}
+	if err != nil {
+		kv.log.Error("error getting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return "", false, err
+	}
+
+	updateFatalFlag(ctx, kv.kvstore, kv.backwardsCompatibilityDisabled, kv.log)
+	return secret.Value, true, nil
+}
+
+// Set an item in the store. When the secret was deleted but is still
+// recoverable, it is recovered or purged first depending on `soft_delete`.
+func (kv *secretsKVStoreAzure) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	secret := azureSecret{
+		Value: value,
+		Tags: map[string]string{
+			azureTagOrgId:     strconv.FormatInt(orgId, 10),
+			azureTagNamespace: namespace,
+			azureTagType:      typ,
+		},
+	}
+	err := kv.retryConflicts(ctx, func(attempt int) (int, error) {
+		status, err := kv.do(ctx, http.MethodPut, kv.secretURL("secrets", orgId, namespace, typ, ""), secret, nil)
+		if status == http.StatusConflict && attempt == 0 {
+			if err := kv.clearDeleted(ctx, orgId, namespace, typ); err != nil {
+				return 0, err
+			}
+		}
+		return status, err
+	})
+	if err != nil {
+		kv.log.Error("error setting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return err
+	}
+
+	updateFatalFlag(ctx, kv.kvstore, kv.backwardsCompatibilityDisabled, kv.log)
+	return nil
+}
+
+// SetWithTTL is not supported, expired key vault secrets are still returned
+func (kv *secretsKVStoreAzure) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	return ErrTTLNotSupported
+}
+
+// Del deletes an item from the store. It stays recoverable unless `soft_delete` is purge.
+func (kv *secretsKVStoreAzure) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	status, err := kv.do(ctx, http.MethodDelete, kv.secretURL("secrets", orgId, namespace, typ, ""), nil, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	if err == nil && kv.settings.SoftDelete == AzureSoftDeletePurge {
+		err = kv.purge(ctx, orgId, namespace, typ)
+	}
+	if err != nil {
+		kv.log.Error("error deleting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+	}
+	return err
+}
+
+// Keys get all keys for a given namespace. To query for all
+// organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
+func (kv *secretsKVStoreAzure) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	return kv.listKeys(ctx, func(key Key) bool {
+		return key.Namespace == namespace && key.Type == typ && (orgId == AllOrganizations || key.OrgId == orgId)
+	})
+}
+
+// ListKeys get the keys whose namespace matches namespacePattern and whose type is typeFilter, see SecretsKVStore.
+// The keys are read from the tags of the secrets, their values are not fetched.
+func (kv *secretsKVStoreAzure) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	return kv.listKeys(ctx, func(key Key) bool {
+		return matchKey(key, orgId, namespacePattern, typeFilter)
+	})
+}
+
+// Rename an item in the store. Key Vault has no rename, so the value is copied
+// to its new name before the old name is deleted.
+func (kv *secretsKVStoreAzure) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	value, exists, err := kv.Get(ctx, orgId, namespace, typ)
+	if err != nil || !exists {
+		return err
+	}
+	if err := kv.Set(ctx, orgId, newNamespace, typ, value); err != nil {
+		return err
+	}
+	return kv.Del(ctx, orgId, namespace, typ)
+}
+
+// SetMultiple sets the items one at a time, the Key Vault API has no batch calls
+func (kv *secretsKVStoreAzure) SetMultiple(ctx context.Context, items []Item) error {
+	return setEach(ctx, kv, items)
+}
+
+// DelMultiple deletes the items one at a time, see SetMultiple
+func (kv *secretsKVStoreAzure) DelMultiple(ctx context.Context, keys []Key) error {
+	return delEach(ctx, kv, keys)
+}
+
+// GetVersion is not supported, the azure store only keeps the current value of secrets
+func (kv *secretsKVStoreAzure) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	return "", false, ErrVersioningNotSupported
+}
+
+// ListVersions is not supported, see GetVersion
+func (kv *secretsKVStoreAzure) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	return nil, ErrVersioningNotSupported
+}
+
+// Rollback is not supported, see GetVersion
+func (kv *secretsKVStoreAzure) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	return ErrVersioningNotSupported
+}
+
+// Health checks that Grafana can authenticate with Azure and list the secrets of the key vault.
+func (kv *secretsKVStoreAzure) Health(ctx context.Context) error {
+	_, err := kv.do(ctx, http.MethodGet, fmt.Sprintf("%s/secrets?api-version=%s&maxresults=1", kv.settings.URL, azureKeyVaultAPIVersion), nil, nil)
+	return err
+}
+
+// secretName mangles the org/namespace/type triple into a valid secret name.
+// The readable part is lossy, the hash of the triple keeps names unique.
+func (kv *secretsKVStoreAzure) secretName(orgId int64, namespace string, typ string) string {
+	sanitize := func(s string) string {
+		s = strings.Trim(azureNameUnsafeChars.ReplaceAllString(s, "-"), "-")
+		if len(s) > 32 {
+			s = strings.TrimRight(s[:32], "-")
+		}
+		return s
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\x00%s\x00%s", orgId, namespace, typ)))
+	parts := []string{kv.settings.NamePrefix, strconv.FormatInt(orgId, 10), sanitize(namespace), sanitize(typ), hex.EncodeToString(sum[:8])}
+	name := parts[0]
+	for _, part := range parts[1:] {
+		if part != "" {
+			name += "-" + part
+		}
+	}
+	return name
+}
+
+func (kv *secretsKVStoreAzure) secretURL(collection string, orgId int64, namespace string, typ string, action string) string {
+	u := fmt.Sprintf("%s/%s/%s", kv.settings.URL, collection, kv.secretName(orgId, namespace, typ))
+	if action != "" {
+		u += "/" + action
+	}
+	return u + "?api-version=" + azureKeyVaultAPIVersion
+}
+
+// clearDeleted recovers or purges the deleted secret that conflicts with a new one.
+// listKeys pages through the secrets of the vault and returns the keys of those set by Grafana that match
+func (kv *secretsKVStoreAzure) listKeys(ctx context.Context, match func(key Key) bool) ([]Key, error) {
+	var keys []Key
+	next := fmt.Sprintf("%s/secrets?api-version=%s", kv.settings.URL, azureKeyVaultAPIVersion)
+	for next != "" {
+		var list azureSecretList
+		if _, err := kv.do(ctx, http.MethodGet, next, nil, &list); err != nil {
+			return nil, err
+		}
+		for _, secret := range list.Value {
+			// secrets not set by Grafana have no org tag and are skipped
+			id, err := strconv.ParseInt(secret.Tags[azureTagOrgId], 10, 64)
+			if err != nil {
+				continue
+			}
+			key := Key{OrgId: id, Namespace: secret.Tags[azureTagNamespace], Type: secret.Tags[azureTagType]}
+			if match(key) {
+				keys = append(keys, key)
+			}
+		}
+		next = list.NextLink
+	}
+	return keys, nil
+}
+
+func (kv *secretsKVStoreAzure) clearDeleted(ctx context.Context, orgId int64, namespace string, typ string) error {
+	status, err := kv.do(ctx, http.MethodGet, kv.secretURL("deletedsecrets", orgId, namespace, typ, ""), nil, nil)
+	if status == http.StatusNotFound {
+		// not deleted, the conflict is an operation in progress
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if kv.settings.SoftDelete == AzureSoftDeletePurge {
+		return kv.purge(ctx, orgId, namespace, typ)
+	}
+	kv.log.Debug("recovering deleted secret", "orgId", orgId, "type", typ, "namespace", namespace)
+	_, err = kv.do(ctx, http.MethodPost, kv.secretURL("deletedsecrets", orgId, namespace, typ, "recover"), nil, nil)
+	return err
+}
+
+func (kv *secretsKVStoreAzure) purge(ctx context.Context, orgId int64, namespace string, typ string) error {
+	return kv.retryConflicts(ctx, func(int) (int, error) {
+		status, err := kv.do(ctx, http.MethodDelete, kv.secretURL("deletedsecrets", orgId, namespace, typ, ""), nil, nil)
+		if status == http.StatusNotFound {
+			// the deletion is still in progress
+			return http.StatusConflict, err
+		}
+		return status, err
+	})
+}
+
+func (kv *secretsKVStoreAzure) retryConflicts(ctx context.Context, fn func(attempt int) (int, error)) error {
+	var err error
+	for attempt := 0; attempt < azureConflictRetries; attempt++ {
+		var status int
+		status, err = fn(attempt)
+		if status != http.StatusConflict {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(azureConflictRetryDelay):
+		}
+	}
+	return err
+}
+
+func (kv *secretsKVStoreAzure) do(ctx context.Context, method string, reqURL string, body interface{}, out interface{}) (int, error) {
+	token, err := kv.getToken(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := kv.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var azureErr struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&azureErr)
+		return res.StatusCode, fmt.Errorf("azure key vault request failed with status %d: %s %s", res.StatusCode, azureErr.Error.Code, azureErr.Error.Message)
+	}
+	if out != nil && res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return res.StatusCode, err
+		}
+	}
+	return res.StatusCode, nil
+}
+
+// getToken returns the last access token until it is about to expire.
+func (kv *secretsKVStoreAzure) getToken(ctx context.Context) (string, error) {
+	kv.tokenMu.Lock()
+	defer kv.tokenMu.Unlock()
+	if kv.token != nil && time.Now().Add(5*time.Minute).Before(kv.token.ExpiresOn) {
+		return kv.token.Token, nil
+	}
+
+	token, err := kv.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureKeyVaultScope}})
+	if err != nil {
+		return "", fmt.Errorf("failed to get azure key vault token with %s: %w", kv.settings.AuthMethod, err)
+	}
+	kv.token = token
+	return token.Token, nil
+}
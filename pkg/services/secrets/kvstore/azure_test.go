@@ -0,0 +1,212 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretsKVStoreAzure(t *testing.T) {
+	ctx := context.Background()
+	azureConflictRetryDelay = time.Millisecond
+	t.Cleanup(func() {
+		azureConflictRetryDelay = time.Second
+	})
+
+	t.Run("mangles the org, namespace and type into a valid name", func(t *testing.T) {
+		kv := setupAzureStore(t, newFakeKeyVault(t), AzureSoftDeleteRecover)
+
+		name := kv.secretName(1, "my datasource/prod", "datasource")
+		assert.Regexp(t, `^grafana-1-my-datasource-prod-datasource-[0-9a-f]{16}$`, name)
+		assert.NotEqual(t, name, kv.secretName(1, "my datasource prod", "datasource"))
+		assert.LessOrEqual(t, len(kv.secretName(1, strings.Repeat("a", 500), strings.Repeat("b", 500))), 127)
+	})
+
+	t.Run("stores, lists and renames secrets", func(t *testing.T) {
+		vault := newFakeKeyVault(t)
+		kv := setupAzureStore(t, vault, AzureSoftDeleteRecover)
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "one"))
+		require.NoError(t, kv.Set(ctx, 2, "ds", "datasource", "two"))
+		require.NoError(t, kv.Set(ctx, 2, "other", "datasource", "three"))
+
+		value, exists, err := kv.Get(ctx, 2, "ds", "datasource")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "two", value)
+
+		keys, err := kv.Keys(ctx, AllOrganizations, "ds", "datasource")
+		require.NoError(t, err)
+		sort.Slice(keys, func(i, j int) bool { return keys[i].OrgId < keys[j].OrgId })
+		assert.Equal(t, []Key{{OrgId: 1, Namespace: "ds", Type: "datasource"}, {OrgId: 2, Namespace: "ds", Type: "datasource"}}, keys)
+
+		require.NoError(t, kv.Rename(ctx, 1, "ds", "datasource", "renamed"))
+		keys, err = kv.Keys(ctx, 1, "renamed", "datasource")
+		require.NoError(t, err)
+		assert.Len(t, keys, 1)
+		_, exists, err = kv.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("recovers deleted secrets when they are set again", func(t *testing.T) {
+		vault := newFakeKeyVault(t)
+		kv := setupAzureStore(t, vault, AzureSoftDeleteRecover)
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "old"))
+		require.NoError(t, kv.Del(ctx, 1, "ds", "datasource"))
+		assert.Len(t, vault.deleted, 1)
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "new"))
+		assert.Equal(t, 1, vault.recovered)
+		assert.Empty(t, vault.deleted)
+		value, _, err := kv.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "new", value)
+	})
+
+	t.Run("purges deleted secrets", func(t *testing.T) {
+		vault := newFakeKeyVault(t)
+		vault.purgeConflicts = 2
+		kv := setupAzureStore(t, vault, AzureSoftDeletePurge)
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "secret"))
+		require.NoError(t, kv.Del(ctx, 1, "ds", "datasource"))
+		assert.Empty(t, vault.secrets)
+		assert.Empty(t, vault.deleted)
+		require.NoError(t, kv.Del(ctx, 1, "ds", "datasource"))
+	})
+}
+
+func setupAzureStore(t *testing.T, vault *fakeKeyVault, softDelete string) *secretsKVStoreAzure {
+	t.Helper()
+	sqlStore := sqlstore.InitTestDB(t)
+	return &secretsKVStoreAzure{
+		log:        log.New("test"),
+		settings:   azureKeyVaultSettings{URL: vault.server.URL, NamePrefix: "grafana", SoftDelete: softDelete},
+		client:     vault.server.Client(),
+		credential: fakeTokenCredential{},
+		kvstore:    GetNamespacedKVStore(kvstore.ProvideService(sqlStore)),
+	}
+}
+
+type fakeTokenCredential struct{}
+
+func (fakeTokenCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	return &azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeKeyVault serves the parts of the Key Vault secrets API used by the azure store
+type fakeKeyVault struct {
+	server         *httptest.Server
+	mu             sync.Mutex
+	secrets        map[string]azureSecret
+	deleted        map[string]azureSecret
+	recovered      int
+	purgeConflicts int
+}
+
+func newFakeKeyVault(t *testing.T) *fakeKeyVault {
+	t.Helper()
+	vault := &fakeKeyVault{
+		secrets: map[string]azureSecret{},
+		deleted: map[string]azureSecret{},
+	}
+	vault.server = httptest.NewServer(http.HandlerFunc(vault.handle))
+	t.Cleanup(vault.server.Close)
+	return vault
+}
+
+func (v *fakeKeyVault) handle(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(segments) == 1 && segments[0] == "secrets":
+		// one secret per page to follow the next links
+		names := make([]string, 0, len(v.secrets))
+		for name := range v.secrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
+		list := azureSecretList{Value: []azureSecret{}}
+		if skip < len(names) {
+			list.Value = append(list.Value, azureSecret{ID: names[skip], Tags: v.secrets[names[skip]].Tags})
+			list.NextLink = fmt.Sprintf("%s/secrets?api-version=%s&skip=%d", v.server.URL, azureKeyVaultAPIVersion, skip+1)
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	case len(segments) == 2 && segments[0] == "secrets":
+		name := segments[1]
+		switch r.Method {
+		case http.MethodPut:
+			if _, ok := v.deleted[name]; ok {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			var secret azureSecret
+			_ = json.NewDecoder(r.Body).Decode(&secret)
+			v.secrets[name] = secret
+		case http.MethodDelete:
+			secret, ok := v.secrets[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(v.secrets, name)
+			v.deleted[name] = secret
+		default:
+			secret, ok := v.secrets[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(secret)
+		}
+	case len(segments) >= 2 && segments[0] == "deletedsecrets":
+		name := segments[1]
+		secret, ok := v.deleted[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch {
+		case len(segments) == 3 && segments[2] == "recover":
+			v.recovered++
+			delete(v.deleted, name)
+			v.secrets[name] = secret
+		case r.Method == http.MethodDelete:
+			if v.purgeConflicts > 0 {
+				v.purgeConflicts--
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			delete(v.deleted, name)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -49,6 +49,17 @@ func (kv *CachedKVStore) Set(ctx context.Context, orgId int64, namespace string,
 	return nil
 }
 
+func (kv *CachedKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	err := kv.store.SetWithTTL(ctx, orgId, namespace, typ, value, ttl)
+	if err != nil {
+		return err
+	}
+	// not cached here, so that the value is never cached for longer than its ttl
+	key := fmt.Sprint(orgId, namespace, typ)
+	kv.cache.Delete(key)
+	return nil
+}
+
 func (kv *CachedKVStore) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
 	err := kv.store.Del(ctx, orgId, namespace, typ)
 	if err != nil {
@@ -63,20 +74,81 @@ func (kv *CachedKVStore) Keys(ctx context.Context, orgId int64, namespace string
 	return kv.store.Keys(ctx, orgId, namespace, typ)
 }
 
+func (kv *CachedKVStore) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	return kv.store.ListKeys(ctx, orgId, namespacePattern, typeFilter)
+}
+
 func (kv *CachedKVStore) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
 	err := kv.store.Rename(ctx, orgId, namespace, typ, newNamespace)
 	if err != nil {
 		return err
 	}
 	key := fmt.Sprint(orgId, namespace, typ)
+	newKey := fmt.Sprint(orgId, newNamespace, typ)
 	if value, ok := kv.cache.Get(key); ok {
-		newKey := fmt.Sprint(orgId, newNamespace, typ)
 		kv.cache.SetDefault(newKey, value)
 		kv.cache.Delete(key)
+	} else {
+		// the value previously cached for the new namespace was replaced
+		kv.cache.Delete(newKey)
+	}
+	return nil
+}
+
+func (kv *CachedKVStore) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	return kv.store.GetVersion(ctx, orgId, namespace, typ, version)
+}
+
+func (kv *CachedKVStore) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	return kv.store.ListVersions(ctx, orgId, namespace, typ)
+}
+
+func (kv *CachedKVStore) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	err := kv.store.Rollback(ctx, orgId, namespace, typ, version)
+	if err != nil {
+		return err
 	}
+	key := fmt.Sprint(orgId, namespace, typ)
+	kv.cache.Delete(key)
 	return nil
 }
 
+// SetMultiple removes the items from the cache even when it fails, as some of them may have been set
+func (kv *CachedKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	err := kv.store.SetMultiple(ctx, items)
+	for _, item := range items {
+		kv.cache.Delete(fmt.Sprint(*item.OrgId, *item.Namespace, *item.Type))
+	}
+	return err
+}
+
+// DelMultiple removes the items from the cache even when it fails, see SetMultiple
+func (kv *CachedKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	err := kv.store.DelMultiple(ctx, keys)
+	for _, key := range keys {
+		kv.cache.Delete(fmt.Sprint(key.OrgId, key.Namespace, key.Type))
+	}
+	return err
+}
+
+// invalidate removes the secret from the cache, when it was changed by another Grafana instance
+func (kv *CachedKVStore) invalidate(orgId int64, namespace string, typ string) {
+	kv.cache.Delete(fmt.Sprint(orgId, namespace, typ))
+}
+
 func (kv *CachedKVStore) GetUnwrappedStore() SecretsKVStore {
-	return kv.store
+	store := kv.store
+	if shared, ok := store.(*sharedCachedKVStore); ok {
+		store = shared.store
+	}
+	if tracked, ok := store.(*healthTrackedKVStore); ok {
+		store = tracked.store
+	}
+	if instrumented, ok := store.(*instrumentedKVStore); ok {
+		store = instrumented.store
+	}
+	if retrying, ok := store.(*retryingKVStore); ok {
+		store = retrying.store
+	}
+	return store
 }
@@ -0,0 +1,41 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxDecompressedValueSize bounds the memory used to decompress a value, secrets are far smaller
+const maxDecompressedValueSize = 64 << 20
+
+// compressedValuePrefix marks the values of the sql store that were compressed with zstd before they were
+// encrypted. Values that start with it are always compressed, so that a value is never mistaken for a compressed one.
+var compressedValuePrefix = []byte("\x00zstd\x00")
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedValueSize))
+)
+
+// compressValue returns the value compressed and prefixed with compressedValuePrefix when it is at least threshold
+// bytes long, or the value as is. A threshold of 0 disables the compression.
+func compressValue(value []byte, threshold int) []byte {
+	if !bytes.HasPrefix(value, compressedValuePrefix) && (threshold <= 0 || len(value) < threshold) {
+		return value
+	}
+	return zstdEncoder.EncodeAll(value, append([]byte{}, compressedValuePrefix...))
+}
+
+// decompressValue is the inverse of compressValue, it returns the values that are not compressed as is
+func decompressValue(value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, compressedValuePrefix) {
+		return value, nil
+	}
+	decompressed, err := zstdDecoder.DecodeAll(value[len(compressedValuePrefix):], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress secret value: %w", err)
+	}
+	return decompressed, nil
+}
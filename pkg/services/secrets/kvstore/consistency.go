@@ -0,0 +1,138 @@
+package kvstore
+
+import (
+	"context"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/datasources"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// DataSourceSecretType is the type of the secrets of the data sources, whose namespace is the name of the data source
+const DataSourceSecretType = "datasource"
+
+// SecretReference is a secret of the consistency report
+type SecretReference struct {
+	OrgId     int64  `json:"orgId"`
+	Namespace string `json:"namespace"`
+	Type      string `json:"type"`
+	// LegacySecrets is set for the missing secrets of data sources that still have their secure json data, which
+	// they are read from instead
+	LegacySecrets bool `json:"legacySecrets,omitempty"`
+}
+
+// SecretsConsistencyReport lists the secrets of the secrets store that no resource references, and the secrets of
+// resources that are not in the secrets store
+type SecretsConsistencyReport struct {
+	Orphaned []SecretReference `json:"orphaned"`
+	Missing  []SecretReference `json:"missing"`
+	// Pruned is the number of orphaned secrets deleted from the secrets store
+	Pruned int `json:"pruned"`
+}
+
+// SecretsConsistencyService cross-checks the secrets of the active secrets store with the data sources referencing
+// them, e.g. after a partial migration or a restore of the database. Data sources are the only resources whose
+// secrets are in the secrets store, plugin settings keep their secure json data in the database.
+type SecretsConsistencyService struct {
+	sqlStore     sqlstore.Store
+	secretsStore SecretsKVStore
+	log          log.Logger
+}
+
+func ProvideSecretsConsistencyService(sqlStore sqlstore.Store, secretsStore SecretsKVStore) *SecretsConsistencyService {
+	return &SecretsConsistencyService{
+		sqlStore:     sqlStore,
+		secretsStore: secretsStore,
+		log:          log.New("secrets.kvstore.consistency"),
+	}
+}
+
+// Check returns the orphaned and missing secrets, and deletes the orphaned secrets when prune is set. The data
+// sources are read again before pruning, so that the secret of a data source created during the check is kept.
+func (s *SecretsConsistencyService) Check(ctx context.Context, prune bool) (*SecretsConsistencyReport, error) {
+	dataSources, err := s.getDataSources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.secretsStore.ListKeys(ctx, AllOrganizations, "", DataSourceSecretType)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SecretsConsistencyReport{Orphaned: []SecretReference{}, Missing: []SecretReference{}}
+	stored := make(map[Key]bool, len(keys))
+	var orphaned []Key
+	for _, key := range keys {
+		stored[key] = true
+		if _, ok := dataSources[key]; !ok {
+			orphaned = append(orphaned, key)
+		}
+	}
+	for key, legacySecrets := range dataSources {
+		if !stored[key] {
+			reference := keyReference(key)
+			reference.LegacySecrets = legacySecrets
+			report.Missing = append(report.Missing, reference)
+		}
+	}
+
+	if prune && len(orphaned) > 0 {
+		if dataSources, err = s.getDataSources(ctx); err != nil {
+			return nil, err
+		}
+		var pruned []Key
+		for _, key := range orphaned {
+			if _, ok := dataSources[key]; !ok {
+				pruned = append(pruned, key)
+			}
+		}
+		if err := s.secretsStore.DelMultiple(ctx, pruned); err != nil {
+			return nil, err
+		}
+		report.Pruned = len(pruned)
+		s.log.Info("deleted the secrets of the secrets store no data source references", "pruned", report.Pruned)
+	}
+	for _, key := range orphaned {
+		report.Orphaned = append(report.Orphaned, keyReference(key))
+	}
+
+	sortSecretReferences(report.Orphaned)
+	sortSecretReferences(report.Missing)
+	return report, nil
+}
+
+// CheckMirror compares the secrets of the primary and the secondary store when the secrets are mirrored, it returns
+// ErrSecretsMirrorDisabled otherwise
+func (s *SecretsConsistencyService) CheckMirror(ctx context.Context) (*SecretsMirrorReport, error) {
+	mirrored, ok := findMirroredKVStore(s.secretsStore)
+	if !ok {
+		return nil, ErrSecretsMirrorDisabled
+	}
+	return mirrored.Divergence(ctx)
+}
+
+// getDataSources returns the keys of the secrets of the data sources, and whether they have legacy secrets
+func (s *SecretsConsistencyService) getDataSources(ctx context.Context) (map[Key]bool, error) {
+	var dataSources []*datasources.DataSource
+	err := s.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		return dbSession.Table("data_source").Cols("org_id", "name", "secure_json_data").Find(&dataSources)
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[Key]bool, len(dataSources))
+	for _, ds := range dataSources {
+		keys[Key{OrgId: ds.OrgId, Namespace: ds.Name, Type: DataSourceSecretType}] = len(ds.SecureJsonData) > 0
+	}
+	return keys, nil
+}
+
+func sortSecretReferences(references []SecretReference) {
+	sort.Slice(references, func(i, j int) bool {
+		if references[i].OrgId != references[j].OrgId {
+			return references[i].OrgId < references[j].OrgId
+		}
+		return references[i].Namespace < references[j].Namespace
+	})
+}
@@ -0,0 +1,88 @@
+package kvstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/datasources"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretsConsistencyService(t *testing.T) {
+	ctx := context.Background()
+	kv := setupTestService(t)
+	consistencyService := ProvideSecretsConsistencyService(kv.sqlStore, kv)
+
+	addDataSource := func(t *testing.T, orgId int64, name string, secureJsonData map[string][]byte) {
+		t.Helper()
+		err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+			_, err := dbSession.Table("data_source").Insert(&datasources.DataSource{
+				OrgId:          orgId,
+				Name:           name,
+				Type:           "prometheus",
+				Access:         datasources.DS_ACCESS_PROXY,
+				Uid:            name,
+				SecureJsonData: secureJsonData,
+				Created:        time.Now(),
+				Updated:        time.Now(),
+			})
+			return err
+		})
+		require.NoError(t, err)
+	}
+
+	addDataSource(t, 1, "synced", nil)
+	addDataSource(t, 1, "legacy", map[string][]byte{"password": []byte("encrypted")})
+	addDataSource(t, 2, "missing", nil)
+	require.NoError(t, kv.Set(ctx, 1, "synced", DataSourceSecretType, "{}"))
+	require.NoError(t, kv.Set(ctx, 1, "deleted", DataSourceSecretType, "{}"))
+	require.NoError(t, kv.Set(ctx, 2, "synced", DataSourceSecretType, "{}"))
+	require.NoError(t, kv.Set(ctx, 1, "other", "plugin", "{}"))
+
+	orphaned := []SecretReference{
+		{OrgId: 1, Namespace: "deleted", Type: DataSourceSecretType},
+		{OrgId: 2, Namespace: "synced", Type: DataSourceSecretType},
+	}
+	missing := []SecretReference{
+		{OrgId: 1, Namespace: "legacy", Type: DataSourceSecretType, LegacySecrets: true},
+		{OrgId: 2, Namespace: "missing", Type: DataSourceSecretType},
+	}
+
+	t.Run("reports orphaned and missing secrets", func(t *testing.T) {
+		report, err := consistencyService.Check(ctx, false)
+		require.NoError(t, err)
+		assert.Equal(t, orphaned, report.Orphaned)
+		assert.Equal(t, missing, report.Missing)
+		assert.Equal(t, 0, report.Pruned)
+
+		_, found, err := kv.Get(ctx, 1, "deleted", DataSourceSecretType)
+		require.NoError(t, err)
+		assert.True(t, found)
+	})
+
+	t.Run("prunes only the orphaned secrets", func(t *testing.T) {
+		report, err := consistencyService.Check(ctx, true)
+		require.NoError(t, err)
+		assert.Equal(t, orphaned, report.Orphaned)
+		assert.Equal(t, 2, report.Pruned)
+
+		for _, secret := range orphaned {
+			_, found, err := kv.Get(ctx, secret.OrgId, secret.Namespace, secret.Type)
+			require.NoError(t, err)
+			assert.False(t, found)
+		}
+		for _, key := range []Key{{OrgId: 1, Namespace: "synced", Type: DataSourceSecretType}, {OrgId: 1, Namespace: "other", Type: "plugin"}} {
+			_, found, err := kv.Get(ctx, key.OrgId, key.Namespace, key.Type)
+			require.NoError(t, err)
+			assert.True(t, found)
+		}
+
+		report, err = consistencyService.Check(ctx, false)
+		require.NoError(t, err)
+		assert.Empty(t, report.Orphaned)
+		assert.Equal(t, missing, report.Missing)
+	})
+}
@@ -0,0 +1,120 @@
+package kvstore
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	defaultDecryptionCacheMaxEntries = 10000
+	defaultDecryptionCacheTTL        = time.Hour
+)
+
+// decryptionCache keeps the decrypted values of the sql store by item id, so that reading a secret doesn't decrypt it
+// every time. The cache holds at most maxEntries values, the least recently used one is evicted first, and a value
+// is only used until ttl after it was cached or until the item is updated.
+type decryptionCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[int64]*list.Element
+	// most recently used first
+	lru *list.List
+}
+
+type cachedDecrypted struct {
+	id      int64
+	updated time.Time
+	value   string
+	expires time.Time
+}
+
+// newDecryptionCache returns a cache of maxEntries values, caching is disabled when maxEntries is 0
+func newDecryptionCache(maxEntries int, ttl time.Duration) *decryptionCache {
+	return &decryptionCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[int64]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func newDecryptionCacheFromConfig(cfg *setting.Cfg) *decryptionCache {
+	section := cfg.SectionWithEnvOverrides("secrets")
+	return newDecryptionCache(
+		section.Key("decryption_cache_max_entries").MustInt(defaultDecryptionCacheMaxEntries),
+		section.Key("decryption_cache_ttl").MustDuration(defaultDecryptionCacheTTL),
+	)
+}
+
+// get returns the cached value of the item, if it was cached since its last update
+func (c *decryptionCache) get(id int64, updated time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		decryptionCacheMissesCounter.Inc()
+		return "", false
+	}
+	cached := element.Value.(*cachedDecrypted)
+	if !cached.updated.Equal(updated) || !time.Now().Before(cached.expires) {
+		c.remove(element)
+		decryptionCacheMissesCounter.Inc()
+		return "", false
+	}
+
+	c.lru.MoveToFront(element)
+	decryptionCacheHitsCounter.Inc()
+	return cached.value, true
+}
+
+func (c *decryptionCache) set(id int64, updated time.Time, value string) {
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := &cachedDecrypted{id: id, updated: updated, value: value, expires: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[id]; ok {
+		element.Value = cached
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[id] = c.lru.PushFront(cached)
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		decryptionCacheEvictionsCounter.Inc()
+	}
+}
+
+// invalidate removes the values of the items, after they were updated or deleted
+func (c *decryptionCache) invalidate(ids ...int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if element, ok := c.entries[id]; ok {
+			c.remove(element)
+			decryptionCacheInvalidationsCounter.Inc()
+		}
+	}
+}
+
+func (c *decryptionCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// remove must be called with the lock held
+func (c *decryptionCache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cachedDecrypted).id)
+}
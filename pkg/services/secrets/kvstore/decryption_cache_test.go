@@ -0,0 +1,82 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecryptionCache(t *testing.T) {
+	updated := time.Now()
+
+	t.Run("evicts the least recently used value", func(t *testing.T) {
+		cache := newDecryptionCache(2, time.Hour)
+		evictions := testutil.ToFloat64(decryptionCacheEvictionsCounter)
+
+		cache.set(1, updated, "one")
+		cache.set(2, updated, "two")
+		_, ok := cache.get(1, updated)
+		require.True(t, ok)
+		cache.set(3, updated, "three")
+
+		assert.Equal(t, 2, cache.len())
+		_, ok = cache.get(2, updated)
+		assert.False(t, ok)
+		value, ok := cache.get(1, updated)
+		assert.True(t, ok)
+		assert.Equal(t, "one", value)
+		assert.Equal(t, evictions+1, testutil.ToFloat64(decryptionCacheEvictionsCounter))
+	})
+
+	t.Run("misses the values of updated items and expired values", func(t *testing.T) {
+		cache := newDecryptionCache(10, time.Hour)
+		cache.set(1, updated, "one")
+		_, ok := cache.get(1, updated.Add(time.Second))
+		assert.False(t, ok)
+		assert.Equal(t, 0, cache.len())
+
+		cache = newDecryptionCache(10, -time.Second)
+		cache.set(1, updated, "one")
+		_, ok = cache.get(1, updated)
+		assert.False(t, ok)
+	})
+
+	t.Run("counts the hits and misses", func(t *testing.T) {
+		cache := newDecryptionCache(10, time.Hour)
+		hits := testutil.ToFloat64(decryptionCacheHitsCounter)
+		misses := testutil.ToFloat64(decryptionCacheMissesCounter)
+
+		_, _ = cache.get(1, updated)
+		cache.set(1, updated, "one")
+		_, _ = cache.get(1, updated)
+		_, _ = cache.get(1, updated)
+
+		assert.Equal(t, hits+2, testutil.ToFloat64(decryptionCacheHitsCounter))
+		assert.Equal(t, misses+1, testutil.ToFloat64(decryptionCacheMissesCounter))
+	})
+
+	t.Run("invalidates values", func(t *testing.T) {
+		cache := newDecryptionCache(10, time.Hour)
+		invalidations := testutil.ToFloat64(decryptionCacheInvalidationsCounter)
+		cache.set(1, updated, "one")
+		cache.set(2, updated, "two")
+
+		cache.invalidate(1, 3)
+
+		_, ok := cache.get(1, updated)
+		assert.False(t, ok)
+		_, ok = cache.get(2, updated)
+		assert.True(t, ok)
+		assert.Equal(t, invalidations+1, testutil.ToFloat64(decryptionCacheInvalidationsCounter))
+	})
+
+	t.Run("caches nothing without entries", func(t *testing.T) {
+		cache := newDecryptionCache(0, time.Hour)
+		cache.set(1, updated, "one")
+		_, ok := cache.get(1, updated)
+		assert.False(t, ok)
+	})
+}
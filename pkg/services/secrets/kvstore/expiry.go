@@ -0,0 +1,64 @@
+package kvstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// expiredSecretsInterval is how often the secrets that expired are deleted
+const expiredSecretsInterval = time.Minute
+
+// ExpiredSecretsCleanupService deletes the secrets of the sql store that expired, and publishes a SecretExpired
+// event for each of them. Expired secrets are not returned by the store before they are deleted.
+type ExpiredSecretsCleanupService struct {
+	store      *secretsKVStoreSQL
+	serverLock *serverlock.ServerLockService
+	log        log.Logger
+}
+
+func ProvideExpiredSecretsCleanupService(sqlStore sqlstore.Store, secretsService secrets.Service, serverLockService *serverlock.ServerLockService, clk clock.Clock) *ExpiredSecretsCleanupService {
+	logger := log.New("secrets.kvstore.expiry")
+	return &ExpiredSecretsCleanupService{
+		store: &secretsKVStoreSQL{
+			sqlStore:        sqlStore,
+			secretsService:  secretsService,
+			log:             logger,
+			decryptionCache: newDecryptionCache(defaultDecryptionCacheMaxEntries, defaultDecryptionCacheTTL),
+			clock:           clk,
+		},
+		serverLock: serverLockService,
+		log:        logger,
+	}
+}
+
+func (s *ExpiredSecretsCleanupService) Run(ctx context.Context) error {
+	ticker := time.NewTicker(expiredSecretsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.serverLock.LockAndExecute(ctx, "delete expired secrets", expiredSecretsInterval/2, func(ctx context.Context) {
+				count, err := s.store.DeleteExpired(ctx, s.store.clock.Now())
+				if err != nil {
+					s.log.Error("failed to delete expired secrets", "error", err)
+					return
+				}
+				if count > 0 {
+					s.log.Debug("deleted expired secrets", "count", count)
+				}
+			})
+			if err != nil {
+				s.log.Error("failed to lock and execute expired secrets deletion", "error", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
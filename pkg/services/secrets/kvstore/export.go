@@ -0,0 +1,287 @@
+package kvstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/encryption"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+const (
+	secretsBundleVersion = 1
+	// number of secrets read from the sql store at a time during an export
+	secretsExportBatchSize = 1000
+)
+
+var (
+	ErrInvalidSecretsBundle    = errors.New("invalid secrets bundle")
+	ErrSecretsBundleDecryption = errors.New("failed to decrypt the secrets bundle, check the passphrase or the key provider")
+	ErrSecretsBundleKey        = errors.New("either a passphrase or a key provider must encrypt the secrets bundle")
+	ErrUnknownKeyProvider      = errors.New("unknown key provider, it must be configured in [security.encryption]")
+	ErrSecretsBundleOrgMissing = errors.New("organizations of the secrets bundle don't exist, map them to existing organizations")
+)
+
+// secretsBundle is an export of the unified secrets. The secrets are encrypted with a random data key, which is
+// encrypted with a passphrase, or with a key provider such as a KMS key when KeyProvider is set.
+type secretsBundle struct {
+	Version      int       `json:"version"`
+	Created      time.Time `json:"created"`
+	KeyProvider  string    `json:"keyProvider,omitempty"`
+	EncryptedKey []byte    `json:"encryptedKey"`
+	Secrets      []byte    `json:"secrets"`
+}
+
+type exportedSecret struct {
+	OrgId     int64      `json:"orgId"`
+	Namespace string     `json:"namespace"`
+	Type      string     `json:"type"`
+	Value     string     `json:"value"`
+	Expires   *time.Time `json:"expires,omitempty"`
+}
+
+// SecretsExportOptions selects how the bundle is encrypted, with exactly one of Passphrase and KeyProvider, and
+// the organizations whose secrets are exported, all of them when OrgIds is empty
+type SecretsExportOptions struct {
+	Passphrase string
+	// KeyProvider is the id of an encryption provider of the instance, e.g. awskms.v1.my-key
+	KeyProvider string
+	OrgIds      []int64
+}
+
+// SecretsImportOptions are the passphrase of a bundle that was encrypted with one, and the organizations of the
+// exporting instance whose secrets are imported in a different organization. Other secrets keep their org id.
+type SecretsImportOptions struct {
+	Passphrase string
+	OrgMapping map[int64]int64
+}
+
+type SecretsImportResult struct {
+	Imported int `json:"imported"`
+	// Expired is the number of secrets of the bundle that expired since the export, they are not imported
+	Expired int `json:"expired"`
+}
+
+type keyProviders interface {
+	GetProviders() map[secrets.ProviderID]secrets.Provider
+}
+
+// SecretsExportService exports the unified secrets of the sql store into encrypted bundles, and imports them in
+// the secrets store of another instance, e.g. to clone an instance or to rehearse a disaster recovery.
+type SecretsExportService struct {
+	sqlStore     sqlstore.Store
+	store        *secretsKVStoreSQL
+	secretsStore SecretsKVStore
+	encryption   encryption.Internal
+	keyProviders keyProviders
+	log          log.Logger
+}
+
+func ProvideSecretsExportService(sqlStore sqlstore.Store, secretsService *manager.SecretsService, secretsStore SecretsKVStore,
+	encryptionService encryption.Internal) *SecretsExportService {
+	return newSecretsExportService(sqlStore, secretsService, secretsService, secretsStore, encryptionService)
+}
+
+func newSecretsExportService(sqlStore sqlstore.Store, secretsService secrets.Service, keyProviders keyProviders,
+	secretsStore SecretsKVStore, encryptionService encryption.Internal) *SecretsExportService {
+	logger := log.New("secrets.kvstore.export")
+	return &SecretsExportService{
+		sqlStore: sqlStore,
+		store: &secretsKVStoreSQL{
+			sqlStore:        sqlStore,
+			secretsService:  secretsService,
+			log:             logger,
+			decryptionCache: newDecryptionCache(0, 0),
+			clock:           clock.New(),
+		},
+		secretsStore: secretsStore,
+		encryption:   encryptionService,
+		keyProviders: keyProviders,
+		log:          logger,
+	}
+}
+
+// Export returns a bundle of the unified secrets that are not expired. It fails when a secret can't be decrypted,
+// rather than leaving it out of the bundle.
+func (s *SecretsExportService) Export(ctx context.Context, opts SecretsExportOptions) ([]byte, error) {
+	if (opts.Passphrase == "") == (opts.KeyProvider == "") {
+		return nil, ErrSecretsBundleKey
+	}
+	orgIds := map[int64]bool{}
+	for _, orgId := range opts.OrgIds {
+		orgIds[orgId] = true
+	}
+
+	now := time.Now()
+	exported := []exportedSecret{}
+	var undecryptable []Key
+	for afterId := int64(0); ; {
+		batch, failed, err := s.store.GetBatch(ctx, afterId, secretsExportBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 && len(failed) == 0 {
+			break
+		}
+		afterId = lastItemId(batch, failed)
+
+		for _, item := range failed {
+			if len(orgIds) == 0 || orgIds[*item.OrgId] {
+				undecryptable = append(undecryptable, itemKey(item))
+			}
+		}
+		for _, item := range batch {
+			if (len(orgIds) > 0 && !orgIds[*item.OrgId]) || (item.Expires != nil && !item.Expires.After(now)) {
+				continue
+			}
+			exported = append(exported, exportedSecret{
+				OrgId:     *item.OrgId,
+				Namespace: *item.Namespace,
+				Type:      *item.Type,
+				Value:     item.Value,
+				Expires:   item.Expires,
+			})
+		}
+	}
+	if len(undecryptable) > 0 {
+		return nil, fmt.Errorf("%d secrets could not be decrypted: %s", len(undecryptable), formatKeys(undecryptable))
+	}
+
+	payload, err := json.Marshal(exported)
+	if err != nil {
+		return nil, err
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	bundle := secretsBundle{Version: secretsBundleVersion, Created: now.UTC(), KeyProvider: opts.KeyProvider}
+	if bundle.Secrets, err = s.encryption.Encrypt(ctx, payload, hex.EncodeToString(dataKey)); err != nil {
+		return nil, err
+	}
+	if opts.KeyProvider != "" {
+		provider, ok := s.keyProviders.GetProviders()[secrets.ProviderID(opts.KeyProvider)]
+		if !ok {
+			return nil, ErrUnknownKeyProvider
+		}
+		bundle.EncryptedKey, err = provider.Encrypt(ctx, dataKey)
+	} else {
+		bundle.EncryptedKey, err = s.encryption.Encrypt(ctx, dataKey, opts.Passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info("exported secrets", "count", len(exported), "keyProvider", opts.KeyProvider)
+	return json.Marshal(bundle)
+}
+
+// Import sets the secrets of a bundle made by Export in the secrets store, in the organizations of the mapping.
+// Nothing is imported when an organization doesn't exist in this instance.
+func (s *SecretsExportService) Import(ctx context.Context, data []byte, opts SecretsImportOptions) (*SecretsImportResult, error) {
+	var bundle secretsBundle
+	if err := json.Unmarshal(data, &bundle); err != nil || bundle.Version != secretsBundleVersion {
+		return nil, ErrInvalidSecretsBundle
+	}
+
+	var dataKey []byte
+	var err error
+	if bundle.KeyProvider != "" {
+		provider, ok := s.keyProviders.GetProviders()[secrets.ProviderID(bundle.KeyProvider)]
+		if !ok {
+			return nil, ErrUnknownKeyProvider
+		}
+		dataKey, err = provider.Decrypt(ctx, bundle.EncryptedKey)
+	} else {
+		if opts.Passphrase == "" {
+			return nil, ErrSecretsBundleKey
+		}
+		dataKey, err = s.encryption.Decrypt(ctx, bundle.EncryptedKey, opts.Passphrase)
+	}
+	if err != nil {
+		s.log.Debug("failed to decrypt the key of the secrets bundle", "error", err)
+		return nil, ErrSecretsBundleDecryption
+	}
+	payload, err := s.encryption.Decrypt(ctx, bundle.Secrets, hex.EncodeToString(dataKey))
+	if err != nil {
+		s.log.Debug("failed to decrypt the secrets of the bundle", "error", err)
+		return nil, ErrSecretsBundleDecryption
+	}
+	// a wrong passphrase doesn't always fail the decryption, it then fails the parsing of the garbled payload
+	var exported []exportedSecret
+	if err := json.Unmarshal(payload, &exported); err != nil {
+		return nil, ErrSecretsBundleDecryption
+	}
+
+	now := time.Now()
+	result := &SecretsImportResult{}
+	items := make([]Item, 0, len(exported))
+	orgIds := map[int64]bool{}
+	for i := range exported {
+		sec := exported[i]
+		if sec.Namespace == "" || sec.Type == "" {
+			return nil, ErrInvalidSecretsBundle
+		}
+		if sec.Expires != nil && !sec.Expires.After(now) {
+			result.Expired++
+			continue
+		}
+		if orgId, ok := opts.OrgMapping[sec.OrgId]; ok {
+			sec.OrgId = orgId
+		}
+		orgIds[sec.OrgId] = true
+		items = append(items, Item{OrgId: &sec.OrgId, Namespace: &sec.Namespace, Type: &sec.Type, Value: sec.Value, Expires: sec.Expires})
+	}
+	if err := s.checkOrgsExist(ctx, orgIds); err != nil {
+		return nil, err
+	}
+
+	if err := s.secretsStore.SetMultiple(ctx, items); err != nil {
+		return nil, err
+	}
+	result.Imported = len(items)
+	s.log.Info("imported secrets", "count", result.Imported, "expired", result.Expired)
+	return result, nil
+}
+
+func (s *SecretsExportService) checkOrgsExist(ctx context.Context, orgIds map[int64]bool) error {
+	if len(orgIds) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(orgIds))
+	for orgId := range orgIds {
+		ids = append(ids, orgId)
+	}
+
+	var existing []int64
+	err := s.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		return dbSession.Table("org").In("id", ids).Cols("id").Find(&existing)
+	})
+	if err != nil {
+		return err
+	}
+	for _, orgId := range existing {
+		delete(orgIds, orgId)
+	}
+	if len(orgIds) == 0 {
+		return nil
+	}
+
+	missing := make([]string, 0, len(orgIds))
+	for orgId := range orgIds {
+		missing = append(missing, fmt.Sprint(orgId))
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("%w: %s", ErrSecretsBundleOrgMissing, strings.Join(missing, ", "))
+}
@@ -0,0 +1,127 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretsExportService(t *testing.T) {
+	ctx := context.Background()
+	kv := setupTestService(t)
+	exportService := newSecretsExportService(kv.sqlStore, kv.secretsService, fakeKeyProviders{"fake.v1.key": fakeKeyProvider{}},
+		kv, service.SetupTestService(t))
+
+	var orgIds []int64
+	for _, name := range []string{"first", "second"} {
+		org, err := kv.sqlStore.(*sqlstore.SQLStore).CreateOrgWithMember(name, 1)
+		require.NoError(t, err)
+		orgIds = append(orgIds, org.Id)
+	}
+	first, second := orgIds[0], orgIds[1]
+
+	// setSecrets resets the store to a secret in each organization and an expired one
+	setSecrets := func(t *testing.T) {
+		t.Helper()
+		all, err := kv.GetAll(ctx)
+		require.NoError(t, err)
+		keys := make([]Key, 0, len(all))
+		for _, item := range all {
+			keys = append(keys, itemKey(item))
+		}
+		require.NoError(t, kv.DelMultiple(ctx, keys))
+		require.NoError(t, kv.Set(ctx, first, "ds1", "datasource", "one"))
+		require.NoError(t, kv.Set(ctx, second, "ds2", "datasource", "two"))
+		require.NoError(t, kv.SetWithTTL(ctx, first, "ds3", "datasource", "expired", time.Millisecond))
+		time.Sleep(10 * time.Millisecond)
+	}
+	getSecret := func(t *testing.T, orgId int64, namespace string) string {
+		t.Helper()
+		value, _, err := kv.Get(ctx, orgId, namespace, "datasource")
+		require.NoError(t, err)
+		return value
+	}
+
+	t.Run("requires exactly one of a passphrase and a key provider", func(t *testing.T) {
+		_, err := exportService.Export(ctx, SecretsExportOptions{})
+		assert.ErrorIs(t, err, ErrSecretsBundleKey)
+		_, err = exportService.Export(ctx, SecretsExportOptions{Passphrase: "pass", KeyProvider: "fake.v1.key"})
+		assert.ErrorIs(t, err, ErrSecretsBundleKey)
+		_, err = exportService.Export(ctx, SecretsExportOptions{KeyProvider: "unknown.v1.key"})
+		assert.ErrorIs(t, err, ErrUnknownKeyProvider)
+	})
+
+	t.Run("exports the secrets encrypted with a passphrase", func(t *testing.T) {
+		setSecrets(t)
+		bundle, err := exportService.Export(ctx, SecretsExportOptions{Passphrase: "pass"})
+		require.NoError(t, err)
+		assert.NotContains(t, string(bundle), "ds1")
+
+		_, err = exportService.Import(ctx, bundle, SecretsImportOptions{Passphrase: "wrong"})
+		assert.ErrorIs(t, err, ErrSecretsBundleDecryption)
+		_, err = exportService.Import(ctx, []byte(`{"version":2}`), SecretsImportOptions{Passphrase: "pass"})
+		assert.ErrorIs(t, err, ErrInvalidSecretsBundle)
+
+		require.NoError(t, kv.Del(ctx, first, "ds1", "datasource"))
+		result, err := exportService.Import(ctx, bundle, SecretsImportOptions{Passphrase: "pass"})
+		require.NoError(t, err)
+		assert.Equal(t, &SecretsImportResult{Imported: 2}, result)
+		assert.Equal(t, "one", getSecret(t, first, "ds1"))
+	})
+
+	t.Run("remaps the organizations of the imported secrets", func(t *testing.T) {
+		setSecrets(t)
+		bundle, err := exportService.Export(ctx, SecretsExportOptions{Passphrase: "pass"})
+		require.NoError(t, err)
+
+		_, err = exportService.Import(ctx, bundle, SecretsImportOptions{Passphrase: "pass", OrgMapping: map[int64]int64{second: 1000}})
+		assert.ErrorIs(t, err, ErrSecretsBundleOrgMissing)
+
+		_, err = exportService.Import(ctx, bundle, SecretsImportOptions{Passphrase: "pass", OrgMapping: map[int64]int64{second: first}})
+		require.NoError(t, err)
+		assert.Equal(t, "one", getSecret(t, first, "ds1"))
+		assert.Equal(t, "two", getSecret(t, first, "ds2"))
+	})
+
+	t.Run("exports the secrets of an organization encrypted with a key provider", func(t *testing.T) {
+		setSecrets(t)
+		bundle, err := exportService.Export(ctx, SecretsExportOptions{KeyProvider: "fake.v1.key", OrgIds: []int64{second}})
+		require.NoError(t, err)
+		var envelope secretsBundle
+		require.NoError(t, json.Unmarshal(bundle, &envelope))
+		assert.Equal(t, "fake.v1.key", envelope.KeyProvider)
+
+		result, err := exportService.Import(ctx, bundle, SecretsImportOptions{OrgMapping: map[int64]int64{second: first}})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Imported)
+		assert.Equal(t, "two", getSecret(t, first, "ds2"))
+	})
+}
+
+type fakeKeyProviders map[secrets.ProviderID]secrets.Provider
+
+func (p fakeKeyProviders) GetProviders() map[secrets.ProviderID]secrets.Provider {
+	return p
+}
+
+// fakeKeyProvider reverses the bytes it encrypts
+type fakeKeyProvider struct{}
+
+func (fakeKeyProvider) Encrypt(_ context.Context, blob []byte) ([]byte, error) {
+	reversed := make([]byte, len(blob))
+	for i, b := range blob {
+		reversed[len(blob)-1-i] = b
+	}
+	return reversed, nil
+}
+
+func (p fakeKeyProvider) Decrypt(ctx context.Context, blob []byte) ([]byte, error) {
+	return p.Encrypt(ctx, blob)
+}
@@ -0,0 +1,428 @@
+package kvstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+const (
+	gcpLabelOrgId     = "grafana_org_id"
+	gcpLabelNamespace = "grafana_namespace"
+	gcpLabelType      = "grafana_type"
+
+	gcpMaxSecretIdLength   = 255
+	gcpMaxLabelValueLength = 63
+)
+
+var (
+	// lowercase base32 only uses digits and the letters a to v, which are
+	// valid in secret ids and label values
+	gcpEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+	gcpSecretIdPrefix = regexp.MustCompile(`^[a-zA-Z0-9-]{1,20}$`)
+)
+
+// gcpSecretManagerSettings are read from the `secrets.gcp_secret_manager` section of the configuration.
+type gcpSecretManagerSettings struct {
+	ProjectID string
+	// CredentialsFile is a service account key or a workload identity federation
+	// configuration, Application Default Credentials are used when it is empty.
+	CredentialsFile string
+	SecretIdPrefix  string
+	KeepVersions    int
+}
+
+func readGCPSecretManagerSettings(cfg *setting.Cfg) gcpSecretManagerSettings {
+	section := cfg.SectionWithEnvOverrides("secrets.gcp_secret_manager")
+	return gcpSecretManagerSettings{
+		ProjectID:       section.Key("project_id").MustString(""),
+		CredentialsFile: section.Key("credentials_file").MustString(""),
+		SecretIdPrefix:  section.Key("secret_id_prefix").MustString("grafana"),
+		KeepVersions:    section.Key("keep_versions").MustInt(0),
+	}
+}
+
+func (s gcpSecretManagerSettings) validate() error {
+	if s.ProjectID == "" {
+		return errors.New("gcp secret manager requires `project_id`")
+	}
+	if !gcpSecretIdPrefix.MatchString(s.SecretIdPrefix) {
+		return fmt.Errorf("gcp secret manager secret_id_prefix %q must be up to 20 letters, digits and dashes", s.SecretIdPrefix)
+	}
+	if s.KeepVersions < 0 {
+		return errors.New("gcp secret manager keep_versions must not be negative")
+	}
+	return nil
+}
+
+// secretsKVStoreGCP provides a key/value store backed by Google Secret Manager.
+// Every Set adds a version to the secret, Get reads the latest version. The
+// org/namespace/type triple is encoded in the secret id and in labels, which
+// are used to filter secrets.
+type secretsKVStoreGCP struct {
+	log                            log.Logger
+	settings                       gcpSecretManagerSettings
+	service                        *secretmanager.Service
+	kvstore                        *kvstore.NamespacedKVStore
+	backwardsCompatibilityDisabled bool
+}
+
+func newSecretsKVStoreGCP(settings gcpSecretManagerSettings, kv *kvstore.NamespacedKVStore, backwardsCompatibilityDisabled bool, logger log.Logger, opts ...option.ClientOption) (*secretsKVStoreGCP, error) {
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+	if settings.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(settings.CredentialsFile))
+	}
+	service, err := secretmanager.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &secretsKVStoreGCP{
+		log:                            logger,
+		settings:                       settings,
+		service:                        service,
+		kvstore:                        kv,
+		backwardsCompatibilityDisabled: backwardsCompatibilityDisabled,
+	}, nil
+}
+
+// Get an item from the store
+func (kv *secretsKVStoreGCP) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	name, err := kv.secretName(orgId, namespace, typ)
+	if err != nil {
+		return "", false, err
+	}
+	value, exists, err := kv.accessLatest(ctx, name)
+	if err != nil {
+		kv.log.Error("error getting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return "", false, err
+	}
+	if !exists {
+		kv.log.Debug("secret value not found", "orgId", orgId, "type", typ, "namespace", namespace)
+		return "", false, nil
+	}
+
+	updateFatalFlag(ctx, kv.kvstore, kv.backwardsCompatibilityDisabled, kv.log)
+	return value, true, nil
+}
+
+// Set an item in the store by adding a version to its secret. The secret is
+// created on the first Set, and nothing is added when the value did not change.
+func (kv *secretsKVStoreGCP) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	name, err := kv.secretName(orgId, namespace, typ)
+	if err != nil {
+		return err
+	}
+	current, exists, err := kv.accessLatest(ctx, name)
+	if err != nil {
+		kv.log.Error("error checking secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return err
+	}
+	if exists && current == value {
+		kv.log.Debug("secret value not changed", "orgId", orgId, "type", typ, "namespace", namespace)
+		return nil
+	}
+
+	if !exists {
+		secret := &secretmanager.Secret{
+			Labels: map[string]string{
+				gcpLabelOrgId:     strconv.FormatInt(orgId, 10),
+				gcpLabelNamespace: gcpLabelValue(namespace),
+				gcpLabelType:      gcpLabelValue(typ),
+			},
+			Replication: &secretmanager.Replication{Automatic: &secretmanager.Automatic{}},
+		}
+		_, err = kv.service.Projects.Secrets.Create(kv.parent(), secret).SecretId(name[strings.LastIndex(name, "/")+1:]).Context(ctx).Do()
+		// the secret can exist without any enabled version
+		if err != nil && !isGoogleAPIError(err, http.StatusConflict) {
+			kv.log.Error("error creating secret", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+			return err
+		}
+	}
+
+	payload := &secretmanager.AddSecretVersionRequest{Payload: &secretmanager.SecretPayload{Data: base64.StdEncoding.EncodeToString([]byte(value))}}
+	if _, err := kv.service.Projects.Secrets.AddVersion(name, payload).Context(ctx).Do(); err != nil {
+		kv.log.Error("error adding secret version", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return err
+	}
+	if err := kv.destroyOldVersions(ctx, name); err != nil {
+		// the new value is stored, old versions are destroyed on the next Set
+		kv.log.Warn("error destroying old secret versions", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+	}
+
+	updateFatalFlag(ctx, kv.kvstore, kv.backwardsCompatibilityDisabled, kv.log)
+	return nil
+}
+
+// SetWithTTL is not supported, secret manager expiration is not handled by this store
+func (kv *secretsKVStoreGCP) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	return ErrTTLNotSupported
+}
+
+// Del deletes an item from the store with all its versions.
+func (kv *secretsKVStoreGCP) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	name, err := kv.secretName(orgId, namespace, typ)
+	if err != nil {
+		return err
+	}
+	_, err = kv.service.Projects.Secrets.Delete(name).Context(ctx).Do()
+	if err != nil && !isGoogleAPIError(err, http.StatusNotFound) {
+		kv.log.Error("error deleting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return err
+	}
+	return nil
+}
+
+// Keys get all keys for a given namespace. To query for all
+// organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
+func (kv *secretsKVStoreGCP) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	filter := fmt.Sprintf("labels.%s=%s AND labels.%s=%s", gcpLabelNamespace, gcpLabelValue(namespace), gcpLabelType, gcpLabelValue(typ))
+	if orgId != AllOrganizations {
+		filter += fmt.Sprintf(" AND labels.%s=%d", gcpLabelOrgId, orgId)
+	}
+	keys, err := kv.listKeys(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// label values of long namespaces and types are hashed, the ids are exact
+	var matching []Key
+	for _, key := range keys {
+		if key.Namespace == namespace && key.Type == typ {
+			matching = append(matching, key)
+		}
+	}
+	return matching, nil
+}
+
+// ListKeys get the keys whose namespace matches namespacePattern and whose type is typeFilter, see SecretsKVStore.
+// The organization and type are filtered by the labels, the namespace pattern on the decoded ids.
+func (kv *secretsKVStoreGCP) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	filter := fmt.Sprintf("labels.%s:*", gcpLabelOrgId)
+	if orgId != AllOrganizations {
+		filter = fmt.Sprintf("labels.%s=%d", gcpLabelOrgId, orgId)
+	}
+	if typeFilter != "" {
+		filter += fmt.Sprintf(" AND labels.%s=%s", gcpLabelType, gcpLabelValue(typeFilter))
+	}
+	keys, err := kv.listKeys(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []Key
+	for _, key := range keys {
+		if matchKey(key, orgId, namespacePattern, typeFilter) {
+			matching = append(matching, key)
+		}
+	}
+	return matching, nil
+}
+
+// Rename an item in the store. Secret Manager has no rename, so the latest
+// value is copied to a new secret before the old secret is deleted.
+func (kv *secretsKVStoreGCP) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	value, exists, err := kv.Get(ctx, orgId, namespace, typ)
+	if err != nil || !exists {
+		return err
+	}
+	if err := kv.Set(ctx, orgId, newNamespace, typ, value); err != nil {
+		return err
+	}
+	return kv.Del(ctx, orgId, namespace, typ)
+}
+
+// SetMultiple sets the items one at a time, the Secret Manager API has no batch calls
+func (kv *secretsKVStoreGCP) SetMultiple(ctx context.Context, items []Item) error {
+	return setEach(ctx, kv, items)
+}
+
+// DelMultiple deletes the items one at a time, see SetMultiple
+func (kv *secretsKVStoreGCP) DelMultiple(ctx context.Context, keys []Key) error {
+	return delEach(ctx, kv, keys)
+}
+
+// GetVersion is not supported, the gcp store only keeps the current value of secrets
+func (kv *secretsKVStoreGCP) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	return "", false, ErrVersioningNotSupported
+}
+
+// ListVersions is not supported, see GetVersion
+func (kv *secretsKVStoreGCP) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	return nil, ErrVersioningNotSupported
+}
+
+// Rollback is not supported, see GetVersion
+func (kv *secretsKVStoreGCP) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	return ErrVersioningNotSupported
+}
+
+// GetAll returns all the secrets stored by Grafana with their latest value.
+func (kv *secretsKVStoreGCP) GetAll(ctx context.Context) ([]Item, error) {
+	keys, err := kv.listKeys(ctx, fmt.Sprintf("labels.%s:*", gcpLabelOrgId))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(keys))
+	for i := range keys {
+		key := keys[i]
+		value, exists, err := kv.Get(ctx, key.OrgId, key.Namespace, key.Type)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		items = append(items, Item{OrgId: &key.OrgId, Namespace: &key.Namespace, Type: &key.Type, Value: value})
+	}
+	return items, nil
+}
+
+// Health checks that Grafana can authenticate with Google and list the secrets of the project.
+func (kv *secretsKVStoreGCP) Health(ctx context.Context) error {
+	_, err := kv.service.Projects.Secrets.List(kv.parent()).PageSize(1).Context(ctx).Do()
+	return err
+}
+
+func (kv *secretsKVStoreGCP) parent() string {
+	return "projects/" + kv.settings.ProjectID
+}
+
+// secretName returns the resource name of the secret of the org/namespace/type
+// triple. The namespace and type are encoded so that the id can be decoded.
+func (kv *secretsKVStoreGCP) secretName(orgId int64, namespace string, typ string) (string, error) {
+	id := fmt.Sprintf("%s_%d_%s_%s", kv.settings.SecretIdPrefix, orgId, gcpEncode(namespace), gcpEncode(typ))
+	if len(id) > gcpMaxSecretIdLength {
+		return "", fmt.Errorf("namespace and type are too long for a gcp secret id: %d characters, at most %d", len(id), gcpMaxSecretIdLength)
+	}
+	return kv.parent() + "/secrets/" + id, nil
+}
+
+// parseSecretName is the inverse of secretName, it returns false for secrets
+// that were not stored by Grafana.
+func (kv *secretsKVStoreGCP) parseSecretName(name string) (Key, bool) {
+	parts := strings.Split(name[strings.LastIndex(name, "/")+1:], "_")
+	if len(parts) != 4 || parts[0] != kv.settings.SecretIdPrefix {
+		return Key{}, false
+	}
+	orgId, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Key{}, false
+	}
+	namespace, err := gcpDecode(parts[2])
+	if err != nil {
+		return Key{}, false
+	}
+	typ, err := gcpDecode(parts[3])
+	if err != nil {
+		return Key{}, false
+	}
+	return Key{OrgId: orgId, Namespace: namespace, Type: typ}, true
+}
+
+func (kv *secretsKVStoreGCP) listKeys(ctx context.Context, filter string) ([]Key, error) {
+	var keys []Key
+	err := kv.service.Projects.Secrets.List(kv.parent()).Filter(filter).Pages(ctx, func(res *secretmanager.ListSecretsResponse) error {
+		for _, secret := range res.Secrets {
+			if key, ok := kv.parseSecretName(secret.Name); ok {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (kv *secretsKVStoreGCP) accessLatest(ctx context.Context, name string) (string, bool, error) {
+	res, err := kv.service.Projects.Secrets.Versions.Access(name + "/versions/latest").Context(ctx).Do()
+	if isGoogleAPIError(err, http.StatusNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	value, err := base64.StdEncoding.DecodeString(res.Payload.Data)
+	if err != nil {
+		return "", false, err
+	}
+	return string(value), true, nil
+}
+
+// destroyOldVersions destroys the enabled versions of a secret past the
+// `keep_versions` most recent ones.
+func (kv *secretsKVStoreGCP) destroyOldVersions(ctx context.Context, name string) error {
+	if kv.settings.KeepVersions == 0 {
+		return nil
+	}
+
+	var versions []string
+	err := kv.service.Projects.Secrets.Versions.List(name).Filter("state:ENABLED").Pages(ctx, func(res *secretmanager.ListSecretVersionsResponse) error {
+		for _, version := range res.Versions {
+			versions = append(versions, version.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return gcpVersionNumber(versions[i]) > gcpVersionNumber(versions[j])
+	})
+	for i := kv.settings.KeepVersions; i < len(versions); i++ {
+		if _, err := kv.service.Projects.Secrets.Versions.Destroy(versions[i], &secretmanager.DestroySecretVersionRequest{}).Context(ctx).Do(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func gcpVersionNumber(name string) int64 {
+	number, _ := strconv.ParseInt(name[strings.LastIndex(name, "/")+1:], 10, 64)
+	return number
+}
+
+func gcpEncode(s string) string {
+	return strings.ToLower(gcpEncoding.EncodeToString([]byte(s)))
+}
+
+func gcpDecode(s string) (string, error) {
+	value, err := gcpEncoding.DecodeString(strings.ToUpper(s))
+	return string(value), err
+}
+
+// gcpLabelValue returns the encoded value, or a hash of it when it is longer
+// than label values can be. The hash starts with an underscore which is not
+// part of the encoding.
+func gcpLabelValue(s string) string {
+	if value := gcpEncode(s); len(value) <= gcpMaxLabelValueLength {
+		return value
+	}
+	sum := sha256.Sum256([]byte(s))
+	return "_" + hex.EncodeToString(sum[:20])
+}
+
+func isGoogleAPIError(err error, code int) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
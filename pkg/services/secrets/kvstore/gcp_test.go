@@ -0,0 +1,265 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+func TestSecretsKVStoreGCP(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("encodes the org, namespace and type in the secret id", func(t *testing.T) {
+		kv := setupGCPStore(t, newFakeSecretManager(t), 0)
+
+		name, err := kv.secretName(2, "My Datasource/prod", "datasource")
+		require.NoError(t, err)
+		assert.Regexp(t, `^projects/grafana-test/secrets/grafana_2_[0-9a-v]+_[0-9a-v]+$`, name)
+		key, ok := kv.parseSecretName(name)
+		require.True(t, ok)
+		assert.Equal(t, Key{OrgId: 2, Namespace: "My Datasource/prod", Type: "datasource"}, key)
+
+		_, ok = kv.parseSecretName("projects/grafana-test/secrets/not-grafana")
+		assert.False(t, ok)
+		_, err = kv.secretName(1, strings.Repeat("a", 200), "datasource")
+		require.Error(t, err)
+	})
+
+	t.Run("adds a version for each changed value", func(t *testing.T) {
+		manager := newFakeSecretManager(t)
+		kv := setupGCPStore(t, manager, 0)
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "one"))
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "one"))
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "two"))
+
+		name, err := kv.secretName(1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.Len(t, manager.secrets[name].versions, 2)
+		value, exists, err := kv.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "two", value)
+	})
+
+	t.Run("destroys the versions past keep_versions", func(t *testing.T) {
+		manager := newFakeSecretManager(t)
+		kv := setupGCPStore(t, manager, 2)
+
+		for i := 0; i < 4; i++ {
+			require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", fmt.Sprint(i)))
+		}
+
+		name, err := kv.secretName(1, "ds", "datasource")
+		require.NoError(t, err)
+		var states []string
+		for _, version := range manager.secrets[name].versions {
+			states = append(states, version.state)
+		}
+		assert.Equal(t, []string{"DESTROYED", "DESTROYED", "ENABLED", "ENABLED"}, states)
+		value, _, err := kv.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "3", value)
+	})
+
+	t.Run("filters keys and all secrets by labels", func(t *testing.T) {
+		manager := newFakeSecretManager(t)
+		kv := setupGCPStore(t, manager, 0)
+		longNamespace := strings.Repeat("n", 60)
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "one"))
+		require.NoError(t, kv.Set(ctx, 2, "ds", "datasource", "two"))
+		require.NoError(t, kv.Set(ctx, 2, "other", "datasource", "three"))
+		require.NoError(t, kv.Set(ctx, 3, longNamespace, "datasource", "four"))
+
+		keys, err := kv.Keys(ctx, AllOrganizations, "ds", "datasource")
+		require.NoError(t, err)
+		sort.Slice(keys, func(i, j int) bool { return keys[i].OrgId < keys[j].OrgId })
+		assert.Equal(t, []Key{{OrgId: 1, Namespace: "ds", Type: "datasource"}, {OrgId: 2, Namespace: "ds", Type: "datasource"}}, keys)
+
+		keys, err = kv.Keys(ctx, 2, "ds", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, []Key{{OrgId: 2, Namespace: "ds", Type: "datasource"}}, keys)
+
+		keys, err = kv.Keys(ctx, 3, longNamespace, "datasource")
+		require.NoError(t, err)
+		assert.Len(t, keys, 1)
+
+		items, err := kv.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Len(t, items, 4)
+	})
+
+	t.Run("renames and deletes secrets", func(t *testing.T) {
+		manager := newFakeSecretManager(t)
+		kv := setupGCPStore(t, manager, 0)
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "secret"))
+		require.NoError(t, kv.Rename(ctx, 1, "ds", "datasource", "renamed"))
+		_, exists, err := kv.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.False(t, exists)
+		value, _, err := kv.Get(ctx, 1, "renamed", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "secret", value)
+
+		require.NoError(t, kv.Del(ctx, 1, "renamed", "datasource"))
+		require.NoError(t, kv.Del(ctx, 1, "renamed", "datasource"))
+		assert.Empty(t, manager.secrets)
+	})
+}
+
+func setupGCPStore(t *testing.T, manager *fakeSecretManager, keepVersions int) *secretsKVStoreGCP {
+	t.Helper()
+	sqlStore := sqlstore.InitTestDB(t)
+	settings := gcpSecretManagerSettings{ProjectID: "grafana-test", SecretIdPrefix: "grafana", KeepVersions: keepVersions}
+	kv, err := newSecretsKVStoreGCP(settings, GetNamespacedKVStore(kvstore.ProvideService(sqlStore)), false, log.New("test"),
+		option.WithEndpoint(manager.server.URL+"/"), option.WithoutAuthentication())
+	require.NoError(t, err)
+	return kv
+}
+
+type fakeGCPSecret struct {
+	labels   map[string]string
+	versions []fakeGCPSecretVersion
+}
+
+type fakeGCPSecretVersion struct {
+	data  string
+	state string
+}
+
+// fakeSecretManager serves the parts of the Secret Manager API used by the gcp store
+type fakeSecretManager struct {
+	server  *httptest.Server
+	mu      sync.Mutex
+	secrets map[string]*fakeGCPSecret
+}
+
+func newFakeSecretManager(t *testing.T) *fakeSecretManager {
+	t.Helper()
+	manager := &fakeSecretManager{secrets: map[string]*fakeGCPSecret{}}
+	manager.server = httptest.NewServer(http.HandlerFunc(manager.handle))
+	t.Cleanup(manager.server.Close)
+	return manager
+}
+
+func (m *fakeSecretManager) handle(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"code":404,"message":"not found"}}`))
+	}
+	writeJSON := func(v interface{}) {
+		_ = json.NewEncoder(w).Encode(v)
+	}
+
+	switch {
+	case path == "projects/grafana-test/secrets" && r.Method == http.MethodPost:
+		name := path + "/" + r.URL.Query().Get("secretId")
+		if _, ok := m.secrets[name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"error":{"code":409,"message":"already exists"}}`))
+			return
+		}
+		var secret secretmanager.Secret
+		_ = json.NewDecoder(r.Body).Decode(&secret)
+		m.secrets[name] = &fakeGCPSecret{labels: secret.Labels}
+		writeJSON(secretmanager.Secret{Name: name})
+	case path == "projects/grafana-test/secrets":
+		names := make([]string, 0, len(m.secrets))
+		for name, secret := range m.secrets {
+			if matchesGCPFilter(secret.labels, r.URL.Query().Get("filter")) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		// one secret per page to follow the page tokens
+		skip, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+		res := secretmanager.ListSecretsResponse{}
+		if skip < len(names) {
+			res.Secrets = []*secretmanager.Secret{{Name: names[skip], Labels: m.secrets[names[skip]].labels}}
+			if skip+1 < len(names) {
+				res.NextPageToken = strconv.Itoa(skip + 1)
+			}
+		}
+		writeJSON(res)
+	case strings.HasSuffix(path, ":addVersion"):
+		secret, ok := m.secrets[strings.TrimSuffix(path, ":addVersion")]
+		if !ok {
+			notFound()
+			return
+		}
+		var req secretmanager.AddSecretVersionRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		secret.versions = append(secret.versions, fakeGCPSecretVersion{data: req.Payload.Data, state: "ENABLED"})
+		writeJSON(secretmanager.SecretVersion{Name: fmt.Sprintf("%s/versions/%d", path, len(secret.versions))})
+	case strings.HasSuffix(path, "/versions/latest:access"):
+		secret, ok := m.secrets[strings.TrimSuffix(path, "/versions/latest:access")]
+		if !ok || len(secret.versions) == 0 {
+			notFound()
+			return
+		}
+		latest := secret.versions[len(secret.versions)-1]
+		writeJSON(secretmanager.AccessSecretVersionResponse{Payload: &secretmanager.SecretPayload{Data: latest.data}})
+	case strings.HasSuffix(path, ":destroy"):
+		parts := strings.Split(strings.TrimSuffix(path, ":destroy"), "/versions/")
+		number, _ := strconv.Atoi(parts[1])
+		m.secrets[parts[0]].versions[number-1].state = "DESTROYED"
+		writeJSON(secretmanager.SecretVersion{})
+	case strings.HasSuffix(path, "/versions"):
+		name := strings.TrimSuffix(path, "/versions")
+		res := secretmanager.ListSecretVersionsResponse{}
+		for i, version := range m.secrets[name].versions {
+			if version.state == "ENABLED" {
+				res.Versions = append(res.Versions, &secretmanager.SecretVersion{Name: fmt.Sprintf("%s/versions/%d", name, i+1)})
+			}
+		}
+		writeJSON(res)
+	case r.Method == http.MethodDelete:
+		if _, ok := m.secrets[path]; !ok {
+			notFound()
+			return
+		}
+		delete(m.secrets, path)
+		writeJSON(struct{}{})
+	default:
+		notFound()
+	}
+}
+
+// matchesGCPFilter supports the `labels.key=value` and `labels.key:*` terms joined with AND
+func matchesGCPFilter(labels map[string]string, filter string) bool {
+	for _, term := range strings.Split(filter, " AND ") {
+		term = strings.TrimPrefix(term, "labels.")
+		if key := strings.TrimSuffix(term, ":*"); key != term {
+			if _, ok := labels[key]; !ok {
+				return false
+			}
+			continue
+		}
+		parts := strings.SplitN(term, "=", 2)
+		if labels[parts[0]] != parts[1] {
+			return false
+		}
+	}
+	return true
+}
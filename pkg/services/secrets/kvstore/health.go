@@ -0,0 +1,259 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/datasources"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+const (
+	// BackendPlugin is the backend reported when secrets are stored in the secrets manager plugin
+	BackendPlugin = "plugin"
+
+	HealthStatusOK          = "ok"
+	HealthStatusFailing     = "failing"
+	HealthStatusQuarantined = "quarantined"
+	HealthStatusDegraded    = "degraded"
+
+	// how long the result of a health check is reused, so that the health endpoint doesn't load the backend
+	healthCheckCacheDuration = 5 * time.Second
+)
+
+// SecretsHealth is the health of the backend the unified secrets are stored in
+type SecretsHealth struct {
+	Backend string `json:"backend"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	// LastSuccess and LastFailure are the last read or update of a secret that succeeded or failed, since startup
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+	// Migration is the state of the migration of the secrets to or from the secrets manager plugin
+	Migration *PluginMigrationStatus `json:"migration,omitempty"`
+	// Quarantine is set while the secrets manager plugin is quarantined because it crashed repeatedly
+	Quarantine *PluginQuarantine `json:"quarantine,omitempty"`
+	// Integrity is the result of the integrity check of the secrets of the database run at startup
+	Integrity *SecretsIntegrityReport `json:"integrity,omitempty"`
+}
+
+// HealthService checks the backend of the unified secrets. The secrets store registers its backend when it is
+// created, and the plugin migration its progress.
+type HealthService struct {
+	mu          sync.Mutex
+	backend     string
+	check       func(ctx context.Context) error
+	migration   func() PluginMigrationStatus
+	quarantine  func(ctx context.Context) (*PluginQuarantine, error)
+	integrity   func() *SecretsIntegrityReport
+	lastSuccess time.Time
+	lastFailure time.Time
+	// the error of the last health check, reused until checked is healthCheckCacheDuration old
+	lastCheckErr error
+	checked      time.Time
+}
+
+func ProvideHealthService() *HealthService {
+	return &HealthService{}
+}
+
+// register sets the backend of the secrets store and how to check that it can be reached
+func (h *HealthService) register(backend string, check func(ctx context.Context) error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.backend = backend
+	h.check = check
+	h.checked = time.Time{}
+}
+
+func (h *HealthService) registerMigration(status func() PluginMigrationStatus) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.migration = status
+}
+
+func (h *HealthService) registerQuarantine(quarantine func(ctx context.Context) (*PluginQuarantine, error)) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.quarantine = quarantine
+}
+
+func (h *HealthService) registerIntegrity(report func() *SecretsIntegrityReport) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.integrity = report
+}
+
+// Wrap returns a SecretsKVStore that records when the operations of store last succeeded and failed
+func (h *HealthService) Wrap(store SecretsKVStore) SecretsKVStore {
+	if h == nil {
+		return store
+	}
+	return &healthTrackedKVStore{store: store, health: h}
+}
+
+func (h *HealthService) record(err error) {
+	if errors.Is(err, ErrVersioningNotSupported) || errors.Is(err, ErrTTLNotSupported) ||
+		errors.Is(err, ErrInvalidTTL) || errors.Is(err, ErrSecretVersionNotFound) {
+		return
+	}
+	var userFriendly datasources.ErrDatasourceSecretsPluginUserFriendly
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil || errors.As(err, &userFriendly) {
+		h.lastSuccess = time.Now()
+	} else {
+		h.lastFailure = time.Now()
+	}
+}
+
+// Check returns the health of the secrets backend, the backend is checked at most every healthCheckCacheDuration
+func (h *HealthService) Check(ctx context.Context) SecretsHealth {
+	h.mu.Lock()
+	check, checked, checkErr, quarantine := h.check, h.checked, h.lastCheckErr, h.quarantine
+	h.mu.Unlock()
+
+	if check != nil && time.Since(checked) >= healthCheckCacheDuration {
+		checkErr = check(ctx)
+		h.mu.Lock()
+		h.lastCheckErr, h.checked = checkErr, time.Now()
+		h.mu.Unlock()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	health := SecretsHealth{Backend: h.backend, Status: HealthStatusOK}
+	if checkErr != nil {
+		health.Status = HealthStatusFailing
+		health.Error = checkErr.Error()
+	}
+	if !h.lastSuccess.IsZero() {
+		lastSuccess := h.lastSuccess
+		health.LastSuccess = &lastSuccess
+	}
+	if !h.lastFailure.IsZero() {
+		lastFailure := h.lastFailure
+		health.LastFailure = &lastFailure
+	}
+	if h.migration != nil {
+		migration := h.migration()
+		health.Migration = &migration
+	}
+	if h.integrity != nil {
+		if report := h.integrity(); report != nil {
+			health.Integrity = report
+			if report.Failed > 0 && health.Status == HealthStatusOK {
+				health.Status = HealthStatusDegraded
+				health.Error = "secrets of the database can't be decrypted, see the integrity report"
+			}
+		}
+	}
+	if quarantine != nil {
+		if q, err := quarantine(ctx); err == nil && q != nil {
+			health.Status = HealthStatusQuarantined
+			health.Error = "secrets manager plugin crashed repeatedly and is quarantined, secrets are read from the database and can't be updated: " +
+				"fix the plugin, then lift the quarantine with DELETE /api/admin/secrets/plugin/quarantine"
+			health.Quarantine = q
+		}
+	}
+	return health
+}
+
+func sqlHealthCheck(sqlStore sqlstore.Store) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return sqlStore.GetDBHealthQuery(ctx, &models.GetDBHealthQuery{})
+	}
+}
+
+// healthTrackedKVStore records the results of the operations of the wrapped store in the health service
+type healthTrackedKVStore struct {
+	store  SecretsKVStore
+	health *HealthService
+}
+
+func (kv *healthTrackedKVStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	value, found, err := kv.store.Get(ctx, orgId, namespace, typ)
+	kv.health.record(err)
+	return value, found, err
+}
+
+func (kv *healthTrackedKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	err := kv.store.Set(ctx, orgId, namespace, typ, value)
+	kv.health.record(err)
+	return err
+}
+
+func (kv *healthTrackedKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	err := kv.store.SetWithTTL(ctx, orgId, namespace, typ, value, ttl)
+	kv.health.record(err)
+	return err
+}
+
+func (kv *healthTrackedKVStore) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	err := kv.store.Del(ctx, orgId, namespace, typ)
+	kv.health.record(err)
+	return err
+}
+
+func (kv *healthTrackedKVStore) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	keys, err := kv.store.Keys(ctx, orgId, namespace, typ)
+	kv.health.record(err)
+	return keys, err
+}
+
+func (kv *healthTrackedKVStore) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	keys, err := kv.store.ListKeys(ctx, orgId, namespacePattern, typeFilter)
+	kv.health.record(err)
+	return keys, err
+}
+
+func (kv *healthTrackedKVStore) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	err := kv.store.Rename(ctx, orgId, namespace, typ, newNamespace)
+	kv.health.record(err)
+	return err
+}
+
+func (kv *healthTrackedKVStore) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	value, found, err := kv.store.GetVersion(ctx, orgId, namespace, typ, version)
+	kv.health.record(err)
+	return value, found, err
+}
+
+func (kv *healthTrackedKVStore) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	versions, err := kv.store.ListVersions(ctx, orgId, namespace, typ)
+	kv.health.record(err)
+	return versions, err
+}
+
+func (kv *healthTrackedKVStore) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	err := kv.store.Rollback(ctx, orgId, namespace, typ, version)
+	kv.health.record(err)
+	return err
+}
+
+func (kv *healthTrackedKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	err := kv.store.SetMultiple(ctx, items)
+	kv.health.record(err)
+	return err
+}
+
+func (kv *healthTrackedKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	err := kv.store.DelMultiple(ctx, keys)
+	kv.health.record(err)
+	return err
+}
@@ -0,0 +1,83 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestHealthService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("reports the sql backend and its last operations", func(t *testing.T) {
+		health := ProvideHealthService()
+		sqlStore := sqlstore.InitTestDB(t)
+		raw, err := ini.Load([]byte(`
+			[secrets]
+			use_plugin = false
+			`))
+		require.NoError(t, err)
+		svc, err := ProvideService(sqlStore, fakes.FakeSecretsService{}, NewFakeSecretsPluginManager(t, false), kvstore.ProvideService(sqlStore),
+			NewFakeFeatureToggles(t, false), &setting.Cfg{Raw: raw}, nil, health, nil, nil, nil, nil, nil, nil, clock.New())
+		require.NoError(t, err)
+
+		status := health.Check(ctx)
+		assert.Equal(t, BackendSQL, status.Backend)
+		assert.Equal(t, HealthStatusOK, status.Status)
+		assert.Nil(t, status.LastSuccess)
+
+		require.NoError(t, svc.Set(ctx, 1, "ds1", "datasource", "secret"))
+		_, _, err = svc.GetVersion(ctx, 1, "ds1", "datasource", 10)
+		require.NoError(t, err)
+		status = health.Check(ctx)
+		assert.NotNil(t, status.LastSuccess)
+		assert.Nil(t, status.LastFailure)
+		assert.Nil(t, status.Migration)
+
+		ProvidePluginSecretMigrationService(svc, &setting.Cfg{Raw: raw}, sqlStore, fakes.FakeSecretsService{}, nil, nil, nil, health)
+		status = health.Check(ctx)
+		require.NotNil(t, status.Migration)
+		assert.Equal(t, PluginMigrationNotStarted, status.Migration.Phase)
+	})
+
+	t.Run("reports a failing backend and reuses the result of the check", func(t *testing.T) {
+		health := ProvideHealthService()
+		checks := 0
+		health.register(BackendVault, func(ctx context.Context) error {
+			checks++
+			return errors.New("vault is sealed")
+		})
+		store := health.Wrap(&fakeFailingKVStore{FakeSecretsKVStore: NewFakeSecretsKVStore()})
+
+		_, _, err := store.Get(ctx, 1, "ds1", "datasource")
+		require.Error(t, err)
+		status := health.Check(ctx)
+		assert.Equal(t, HealthStatusFailing, status.Status)
+		assert.Equal(t, "vault is sealed", status.Error)
+		assert.NotNil(t, status.LastFailure)
+
+		health.Check(ctx)
+		assert.Equal(t, 1, checks)
+		health.checked = time.Now().Add(-healthCheckCacheDuration)
+		health.Check(ctx)
+		assert.Equal(t, 2, checks)
+	})
+}
+
+type fakeFailingKVStore struct {
+	FakeSecretsKVStore
+}
+
+func (f *fakeFailingKVStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	return "", false, errors.New("connection refused")
+}
@@ -0,0 +1,144 @@
+package kvstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// Operations of the secrets store, as reported by the operation metrics
+const (
+	operationGet          = "get"
+	operationSet          = "set"
+	operationSetWithTTL   = "set_with_ttl"
+	operationDelete       = "delete"
+	operationKeys         = "keys"
+	operationListKeys     = "list_keys"
+	operationRename       = "rename"
+	operationGetVersion   = "get_version"
+	operationListVersions = "list_versions"
+	operationRollback     = "rollback"
+	operationSetMultiple  = "set_multiple"
+	operationDelMultiple  = "del_multiple"
+
+	defaultSlowOperationThreshold = time.Second
+)
+
+// instrumentedKVStore measures the duration and errors of the operations of the wrapped store, and logs the
+// operations slower than slowThreshold
+type instrumentedKVStore struct {
+	store         SecretsKVStore
+	backend       string
+	slowThreshold time.Duration
+	log           log.Logger
+}
+
+// instrument returns a SecretsKVStore that reports the operations of store, the secrets backend, in the
+// secrets operation metrics. `secrets.slow_operation_threshold` sets which operations are logged as slow, 0
+// disables the logs.
+func instrument(store SecretsKVStore, backend string, cfg *setting.Cfg, logger log.Logger) SecretsKVStore {
+	return &instrumentedKVStore{
+		store:         store,
+		backend:       backend,
+		slowThreshold: cfg.SectionWithEnvOverrides("secrets").Key("slow_operation_threshold").MustDuration(defaultSlowOperationThreshold),
+		log:           logger,
+	}
+}
+
+// observe records an operation that started at start, the key values are only used in the slow operation log
+func (kv *instrumentedKVStore) observe(operation string, start time.Time, err error, keyValues ...interface{}) {
+	duration := time.Since(start)
+	operationDurationHistogram.WithLabelValues(operation, kv.backend).Observe(duration.Seconds())
+	if err != nil {
+		operationErrorsCounter.WithLabelValues(operation, kv.backend).Inc()
+	}
+	if kv.slowThreshold > 0 && duration > kv.slowThreshold {
+		kv.log.Warn("slow secrets operation", append([]interface{}{"operation", operation, "backend", kv.backend, "duration", duration}, keyValues...)...)
+	}
+}
+
+func (kv *instrumentedKVStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	start := time.Now()
+	value, found, err := kv.store.Get(ctx, orgId, namespace, typ)
+	kv.observe(operationGet, start, err, "orgId", orgId, "namespace", namespace, "type", typ)
+	return value, found, err
+}
+
+func (kv *instrumentedKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	start := time.Now()
+	err := kv.store.Set(ctx, orgId, namespace, typ, value)
+	kv.observe(operationSet, start, err, "orgId", orgId, "namespace", namespace, "type", typ)
+	return err
+}
+
+func (kv *instrumentedKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	start := time.Now()
+	err := kv.store.SetWithTTL(ctx, orgId, namespace, typ, value, ttl)
+	kv.observe(operationSetWithTTL, start, err, "orgId", orgId, "namespace", namespace, "type", typ)
+	return err
+}
+
+func (kv *instrumentedKVStore) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	start := time.Now()
+	err := kv.store.Del(ctx, orgId, namespace, typ)
+	kv.observe(operationDelete, start, err, "orgId", orgId, "namespace", namespace, "type", typ)
+	return err
+}
+
+func (kv *instrumentedKVStore) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	start := time.Now()
+	keys, err := kv.store.Keys(ctx, orgId, namespace, typ)
+	kv.observe(operationKeys, start, err, "orgId", orgId, "namespace", namespace, "type", typ)
+	return keys, err
+}
+
+func (kv *instrumentedKVStore) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	start := time.Now()
+	keys, err := kv.store.ListKeys(ctx, orgId, namespacePattern, typeFilter)
+	kv.observe(operationListKeys, start, err, "orgId", orgId, "namespacePattern", namespacePattern, "type", typeFilter)
+	return keys, err
+}
+
+func (kv *instrumentedKVStore) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	start := time.Now()
+	err := kv.store.Rename(ctx, orgId, namespace, typ, newNamespace)
+	kv.observe(operationRename, start, err, "orgId", orgId, "namespace", namespace, "type", typ)
+	return err
+}
+
+func (kv *instrumentedKVStore) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	start := time.Now()
+	value, found, err := kv.store.GetVersion(ctx, orgId, namespace, typ, version)
+	kv.observe(operationGetVersion, start, err, "orgId", orgId, "namespace", namespace, "type", typ)
+	return value, found, err
+}
+
+func (kv *instrumentedKVStore) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	start := time.Now()
+	versions, err := kv.store.ListVersions(ctx, orgId, namespace, typ)
+	kv.observe(operationListVersions, start, err, "orgId", orgId, "namespace", namespace, "type", typ)
+	return versions, err
+}
+
+func (kv *instrumentedKVStore) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	start := time.Now()
+	err := kv.store.Rollback(ctx, orgId, namespace, typ, version)
+	kv.observe(operationRollback, start, err, "orgId", orgId, "namespace", namespace, "type", typ)
+	return err
+}
+
+func (kv *instrumentedKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	start := time.Now()
+	err := kv.store.SetMultiple(ctx, items)
+	kv.observe(operationSetMultiple, start, err, "count", len(items))
+	return err
+}
+
+func (kv *instrumentedKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	start := time.Now()
+	err := kv.store.DelMultiple(ctx, keys)
+	kv.observe(operationDelMultiple, start, err, "count", len(keys))
+	return err
+}
@@ -0,0 +1,34 @@
+package kvstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestInstrumentedKVStore(t *testing.T) {
+	ctx := context.Background()
+	raw, err := ini.Load([]byte(`
+		[secrets]
+		slow_operation_threshold = 0
+		`))
+	require.NoError(t, err)
+	store := instrument(&fakeFailingKVStore{FakeSecretsKVStore: NewFakeSecretsKVStore()}, BackendVault, &setting.Cfg{Raw: raw}, log.New("test"))
+	require.Zero(t, store.(*instrumentedKVStore).slowThreshold)
+
+	getErrors := testutil.ToFloat64(operationErrorsCounter.WithLabelValues(operationGet, BackendVault))
+	setErrors := testutil.ToFloat64(operationErrorsCounter.WithLabelValues(operationSet, BackendVault))
+
+	_, _, err = store.Get(ctx, 1, "ds1", "datasource")
+	require.Error(t, err)
+	require.NoError(t, store.Set(ctx, 1, "ds1", "datasource", "secret"))
+
+	assert.Equal(t, getErrors+1, testutil.ToFloat64(operationErrorsCounter.WithLabelValues(operationGet, BackendVault)))
+	assert.Equal(t, setErrors, testutil.ToFloat64(operationErrorsCounter.WithLabelValues(operationSet, BackendVault)))
+}
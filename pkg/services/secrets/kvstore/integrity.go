@@ -0,0 +1,207 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	// Scopes of the integrity check run at startup, selected with `secrets.integrity_check`
+	IntegrityCheckDisabled = "disabled"
+	IntegrityCheckSample   = "sample"
+	IntegrityCheckAll      = "all"
+
+	// Reasons the values of the sql store fail the integrity check
+	IntegrityFailureMissingDataKey = "missing_data_key"
+	IntegrityFailureCorrupted      = "corrupted"
+	IntegrityFailureUndecryptable  = "undecryptable"
+
+	defaultIntegrityCheckSampleSize = 100
+	integrityCheckBatchSize         = 100
+	// the failures listed by the report, the others are only counted
+	maxIntegrityFailuresReported = 100
+)
+
+// SecretsIntegrityReport is the result of the integrity check of the values of the sql store
+type SecretsIntegrityReport struct {
+	Scope      string    `json:"scope"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Total is the number of secrets of the sql store, Checked the number of them that were decrypted
+	Total    int64                    `json:"total"`
+	Checked  int                      `json:"checked"`
+	Failed   int                      `json:"failed"`
+	Failures []SecretIntegrityFailure `json:"failures"`
+	Error    string                   `json:"error,omitempty"`
+}
+
+// SecretIntegrityFailure is a secret of the sql store that could not be decrypted
+type SecretIntegrityFailure struct {
+	SecretReference
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+}
+
+// SecretsIntegrityService decrypts, once at startup, a sample or all of the values of the sql store, so that the
+// values that can't be decrypted anymore, because their data key is missing or they are corrupted, are reported
+// by the secrets health before they fail the queries of the data sources.
+type SecretsIntegrityService struct {
+	sqlStore       sqlstore.Store
+	secretsService secrets.Service
+	scope          string
+	sampleSize     int
+	clock          clock.Clock
+	log            log.Logger
+
+	mu     sync.Mutex
+	report *SecretsIntegrityReport
+}
+
+func ProvideSecretsIntegrityService(sqlStore sqlstore.Store, secretsService secrets.Service, cfg *setting.Cfg, health *HealthService, clk clock.Clock) *SecretsIntegrityService {
+	section := cfg.SectionWithEnvOverrides("secrets")
+	s := &SecretsIntegrityService{
+		sqlStore:       sqlStore,
+		secretsService: secretsService,
+		scope:          section.Key("integrity_check").In(IntegrityCheckDisabled, []string{IntegrityCheckDisabled, IntegrityCheckSample, IntegrityCheckAll}),
+		sampleSize:     section.Key("integrity_check_sample_size").MustInt(defaultIntegrityCheckSampleSize),
+		clock:          clk,
+		log:            log.New("secrets.kvstore.integrity"),
+	}
+	health.registerIntegrity(s.Report)
+	return s
+}
+
+func (s *SecretsIntegrityService) IsDisabled() bool {
+	return s.scope == IntegrityCheckDisabled || (s.scope == IntegrityCheckSample && s.sampleSize <= 0)
+}
+
+// Run checks the integrity of the secrets once
+func (s *SecretsIntegrityService) Run(ctx context.Context) error {
+	report, err := s.Check(ctx)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		s.log.Error("failed to check the integrity of the secrets", "error", err)
+		return nil
+	}
+	if report.Failed > 0 {
+		s.log.Error("secrets of the database can't be decrypted, the data sources using them will fail",
+			"failed", report.Failed, "checked", report.Checked, "total", report.Total)
+		for _, failure := range report.Failures {
+			s.log.Warn("secret can't be decrypted", "orgId", failure.OrgId, "namespace", failure.Namespace,
+				"type", failure.Type, "reason", failure.Reason, "error", failure.Error)
+		}
+		return nil
+	}
+	s.log.Info("secrets integrity checked", "checked", report.Checked, "total", report.Total)
+	return nil
+}
+
+// Report returns the result of the last integrity check, nil when the secrets were not checked
+func (s *SecretsIntegrityService) Report() *SecretsIntegrityReport {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.report
+}
+
+// Check decrypts the values of the sql store that did not expire, every value or a sample of sampleSize values
+// spread over the table, and records the result as the report of the service
+func (s *SecretsIntegrityService) Check(ctx context.Context) (*SecretsIntegrityReport, error) {
+	report := &SecretsIntegrityReport{Scope: s.scope, StartedAt: s.clock.Now(), Failures: []SecretIntegrityFailure{}}
+	err := s.check(ctx, report)
+	report.FinishedAt = s.clock.Now()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	integrityFailuresGauge.Set(float64(report.Failed))
+
+	s.mu.Lock()
+	s.report = report
+	s.mu.Unlock()
+	return report, err
+}
+
+func (s *SecretsIntegrityService) check(ctx context.Context, report *SecretsIntegrityReport) error {
+	now := s.clock.Now()
+	err := s.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		var err error
+		report.Total, err = dbSession.Where("expires IS NULL OR expires > ?", now).Count(&Item{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// every step-th value is checked when sampling
+	step := int64(1)
+	if s.scope == IntegrityCheckSample && report.Total > int64(s.sampleSize) {
+		step = (report.Total + int64(s.sampleSize) - 1) / int64(s.sampleSize)
+	}
+
+	var lastId, position int64
+	for {
+		var items []Item
+		err := s.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+			return dbSession.Where("id > ?", lastId).And("(expires IS NULL OR expires > ?)", now).
+				Asc("id").Limit(integrityCheckBatchSize).Find(&items)
+		})
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		for _, item := range items {
+			position++
+			if (position-1)%step != 0 {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			report.Checked++
+			if reason, err := s.decrypt(ctx, item); err != nil {
+				report.Failed++
+				if len(report.Failures) < maxIntegrityFailuresReported {
+					report.Failures = append(report.Failures, SecretIntegrityFailure{
+						SecretReference: SecretReference{OrgId: *item.OrgId, Namespace: *item.Namespace, Type: *item.Type},
+						Reason:          reason,
+						Error:           err.Error(),
+					})
+				}
+			}
+		}
+		lastId = items[len(items)-1].Id
+	}
+}
+
+// decrypt decrypts the value of the item as the sql store does, it returns the reason it failed
+func (s *SecretsIntegrityService) decrypt(ctx context.Context, item Item) (string, error) {
+	decoded, err := b64.DecodeString(item.Value)
+	if err != nil {
+		return IntegrityFailureCorrupted, err
+	}
+	decrypted, err := s.secretsService.DecryptWithAAD(ctx, decoded, secrets.ContextAAD(*item.OrgId, *item.Namespace, *item.Type))
+	if err != nil {
+		if errors.Is(err, secrets.ErrDataKeyNotFound) {
+			return IntegrityFailureMissingDataKey, err
+		}
+		return IntegrityFailureUndecryptable, err
+	}
+	if _, err := decompressValue(decrypted); err != nil {
+		return IntegrityFailureCorrupted, err
+	}
+	return "", nil
+}
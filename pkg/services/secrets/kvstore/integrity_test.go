@@ -0,0 +1,106 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/services/secrets/database"
+	"github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestSecretsIntegrityService(t *testing.T) {
+	ctx := context.Background()
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := manager.SetupTestService(t, database.ProvideSecretsStore(sqlStore))
+	kv := &secretsKVStoreSQL{
+		sqlStore:        sqlStore,
+		log:             log.New("secrets.kvstore"),
+		secretsService:  secretsService,
+		decryptionCache: newDecryptionCache(defaultDecryptionCacheMaxEntries, defaultDecryptionCacheTTL),
+		clock:           clock.New(),
+	}
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, kv.Set(ctx, 1, fmt.Sprintf("ds%d", i), "datasource", "secret"))
+	}
+
+	t.Run("is disabled by default", func(t *testing.T) {
+		svc := ProvideSecretsIntegrityService(sqlStore, secretsService, setupIntegrityConfig(t, "", 0), ProvideHealthService(), clock.New())
+		assert.True(t, svc.IsDisabled())
+		assert.Nil(t, svc.Report())
+	})
+
+	t.Run("checks every secret", func(t *testing.T) {
+		svc := ProvideSecretsIntegrityService(sqlStore, secretsService, setupIntegrityConfig(t, IntegrityCheckAll, 0), ProvideHealthService(), clock.New())
+		assert.False(t, svc.IsDisabled())
+		report, err := svc.Check(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), report.Total)
+		assert.Equal(t, 10, report.Checked)
+		assert.Equal(t, 0, report.Failed)
+		assert.Same(t, report, svc.Report())
+	})
+
+	t.Run("checks a sample of the secrets", func(t *testing.T) {
+		svc := ProvideSecretsIntegrityService(sqlStore, secretsService, setupIntegrityConfig(t, IntegrityCheckSample, 4), ProvideHealthService(), clock.New())
+		report, err := svc.Check(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), report.Total)
+		assert.Equal(t, 4, report.Checked)
+	})
+
+	t.Run("reports the secrets that can't be decrypted and degrades the health", func(t *testing.T) {
+		// a value which is not base64 encoded and a value encrypted with a data key that does not exist
+		setIntegrityTestValue(t, sqlStore, "ds2", "not base64 !")
+		missingKey := append([]byte{secrets.EnvelopeV2Delimiter}, []byte(b64.EncodeToString([]byte("missing"))+"$encrypted")...)
+		setIntegrityTestValue(t, sqlStore, "ds3", b64.EncodeToString(missingKey))
+
+		health := ProvideHealthService()
+		health.register(BackendSQL, sqlHealthCheck(sqlStore))
+		svc := ProvideSecretsIntegrityService(sqlStore, secretsService, setupIntegrityConfig(t, IntegrityCheckAll, 0), health, clock.New())
+		report, err := svc.Check(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 10, report.Checked)
+		assert.Equal(t, 2, report.Failed)
+		require.Len(t, report.Failures, 2)
+		assert.Equal(t, "ds2", report.Failures[0].Namespace)
+		assert.Equal(t, IntegrityFailureCorrupted, report.Failures[0].Reason)
+		assert.Equal(t, "ds3", report.Failures[1].Namespace)
+		assert.Equal(t, IntegrityFailureMissingDataKey, report.Failures[1].Reason)
+
+		status := health.Check(ctx)
+		assert.Equal(t, HealthStatusDegraded, status.Status)
+		assert.Same(t, report, status.Integrity)
+	})
+}
+
+func setupIntegrityConfig(t *testing.T, scope string, sampleSize int) *setting.Cfg {
+	t.Helper()
+	raw := ini.Empty()
+	section := raw.Section("secrets")
+	if scope != "" {
+		_, err := section.NewKey("integrity_check", scope)
+		require.NoError(t, err)
+	}
+	if sampleSize > 0 {
+		_, err := section.NewKey("integrity_check_sample_size", fmt.Sprint(sampleSize))
+		require.NoError(t, err)
+	}
+	return &setting.Cfg{Raw: raw}
+}
+
+func setIntegrityTestValue(t *testing.T, sqlStore *sqlstore.SQLStore, namespace string, value string) {
+	t.Helper()
+	require.NoError(t, sqlStore.WithDbSession(context.Background(), func(dbSession *sqlstore.DBSession) error {
+		_, err := dbSession.Exec("UPDATE secrets SET value = ? WHERE namespace = ?", value, namespace)
+		return err
+	}))
+}
@@ -0,0 +1,425 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	kubernetesLabelManagedBy   = "app.kubernetes.io/managed-by"
+	kubernetesLabelPrefix      = "grafana.com/secret-prefix"
+	kubernetesLabelOrgId       = "grafana.com/org-id"
+	kubernetesAnnotationNS     = "grafana.com/namespace"
+	kubernetesAnnotationType   = "grafana.com/type"
+	kubernetesManagedByGrafana = "grafana"
+	kubernetesValueKey         = "value"
+
+	kubernetesListLimit = 500
+)
+
+var (
+	// projected service account tokens are rotated, the token file is read again after this duration
+	kubernetesTokenRefreshInterval = time.Minute
+
+	kubernetesNameUnsafeChars = regexp.MustCompile(`[^0-9a-z]+`)
+	kubernetesNamePrefix      = regexp.MustCompile(`^[0-9a-z]([0-9a-z-]{0,18}[0-9a-z])?$`)
+)
+
+// kubernetesSettings are read from the `secrets.kubernetes` section of the configuration. They default to the
+// in-cluster service account of the pod Grafana runs in.
+type kubernetesSettings struct {
+	APIURL     string
+	Namespace  string
+	NamePrefix string
+	TokenFile  string
+	CAFile     string
+	Timeout    time.Duration
+}
+
+func readKubernetesSettings(cfg *setting.Cfg) kubernetesSettings {
+	section := cfg.SectionWithEnvOverrides("secrets.kubernetes")
+	apiURL := ""
+	if host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"); host != "" && port != "" {
+		apiURL = "https://" + net.JoinHostPort(host, port)
+	}
+	namespace := ""
+	// nolint:gosec
+	if podNamespace, err := os.ReadFile(kubernetesServiceAccountDir + "/namespace"); err == nil {
+		namespace = strings.TrimSpace(string(podNamespace))
+	}
+	return kubernetesSettings{
+		APIURL:     strings.TrimSuffix(section.Key("api_url").MustString(apiURL), "/"),
+		Namespace:  section.Key("namespace").MustString(namespace),
+		NamePrefix: section.Key("name_prefix").MustString("grafana"),
+		TokenFile:  section.Key("token_file").MustString(kubernetesServiceAccountDir + "/token"),
+		CAFile:     section.Key("ca_file").MustString(kubernetesServiceAccountDir + "/ca.crt"),
+		Timeout:    section.Key("timeout").MustDuration(10 * time.Second),
+	}
+}
+
+func (s kubernetesSettings) validate() error {
+	if s.APIURL == "" {
+		return errors.New("kubernetes secrets require `api_url` outside of a cluster")
+	}
+	if s.Namespace == "" {
+		return errors.New("kubernetes secrets require `namespace` outside of a cluster")
+	}
+	if !kubernetesNamePrefix.MatchString(s.NamePrefix) {
+		return fmt.Errorf("kubernetes name_prefix %q must be up to 20 lowercase letters, digits and dashes", s.NamePrefix)
+	}
+	return nil
+}
+
+func (s kubernetesSettings) httpClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if s.CAFile != "" {
+		// nolint:gosec
+		ca, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubernetes ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("kubernetes ca_file %q has no certificate", s.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Transport: transport, Timeout: s.Timeout}, nil
+}
+
+// secretsKVStoreKubernetes provides a key/value store backed by Kubernetes Secrets of a namespace, so that
+// the secrets benefit from the encryption at rest and the RBAC of the cluster. Secret names are DNS subdomains,
+// so the org/namespace/type triple is mangled into the name and kept in a label and annotations of the secret.
+// The service account of Grafana needs the get, list, create, update and delete verbs on secrets.
+type secretsKVStoreKubernetes struct {
+	log                            log.Logger
+	settings                       kubernetesSettings
+	client                         *http.Client
+	kvstore                        *kvstore.NamespacedKVStore
+	backwardsCompatibilityDisabled bool
+
+	tokenMu   sync.Mutex
+	token     string
+	tokenRead time.Time
+}
+
+func newSecretsKVStoreKubernetes(settings kubernetesSettings, kv *kvstore.NamespacedKVStore, backwardsCompatibilityDisabled bool, logger log.Logger) (*secretsKVStoreKubernetes, error) {
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+	client, err := settings.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	return &secretsKVStoreKubernetes{
+		log:                            logger,
+		settings:                       settings,
+		client:                         client,
+		kvstore:                        kv,
+		backwardsCompatibilityDisabled: backwardsCompatibilityDisabled,
+	}, nil
+}
+
+type kubernetesObjectMeta struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type kubernetesSecret struct {
+	APIVersion string               `json:"apiVersion,omitempty"`
+	Kind       string               `json:"kind,omitempty"`
+	Metadata   kubernetesObjectMeta `json:"metadata"`
+	Type       string               `json:"type,omitempty"`
+	Data       map[string][]byte    `json:"data,omitempty"`
+}
+
+type kubernetesSecretList struct {
+	Items    []kubernetesSecret `json:"items"`
+	Metadata struct {
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+}
+
+// Get an item from the store
+func (kv *secretsKVStoreKubernetes) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	var secret kubernetesSecret
+	status, err := kv.do(ctx, http.MethodGet, kv.secretURL(kv.secretName(orgId, namespace, typ)), nil, &secret)
+	if status == http.StatusNotFound {
+		kv.log.Debug("secret value not found", "orgId", orgId, "type", typ, "namespace", namespace)
+		return "", false, nil
+	}
+	if err != nil {
+		kv.log.Error("error getting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return "", false, err
+	}
+
+	updateFatalFlag(ctx, kv.kvstore, kv.backwardsCompatibilityDisabled, kv.log)
+	return string(secret.Data[kubernetesValueKey]), true, nil
+}
+
+// Set an item in the store, the secret is replaced when it exists and created otherwise
+func (kv *secretsKVStoreKubernetes) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	secret := kv.newSecret(orgId, namespace, typ, value)
+	status, err := kv.do(ctx, http.MethodPut, kv.secretURL(secret.Metadata.Name), secret, nil)
+	if status == http.StatusNotFound {
+		status, err = kv.do(ctx, http.MethodPost, kv.secretURL(""), secret, nil)
+		if status == http.StatusConflict {
+			// created concurrently
+			_, err = kv.do(ctx, http.MethodPut, kv.secretURL(secret.Metadata.Name), secret, nil)
+		}
+	}
+	if err != nil {
+		kv.log.Error("error setting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return err
+	}
+
+	updateFatalFlag(ctx, kv.kvstore, kv.backwardsCompatibilityDisabled, kv.log)
+	return nil
+}
+
+// SetWithTTL is not supported, Kubernetes Secrets don't expire
+func (kv *secretsKVStoreKubernetes) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	return ErrTTLNotSupported
+}
+
+// Del deletes an item from the store
+func (kv *secretsKVStoreKubernetes) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	status, err := kv.do(ctx, http.MethodDelete, kv.secretURL(kv.secretName(orgId, namespace, typ)), nil, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		kv.log.Error("error deleting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+	}
+	return err
+}
+
+// Keys get all keys for a given namespace. To query for all
+// organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
+func (kv *secretsKVStoreKubernetes) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	return kv.listKeys(ctx, orgId, func(key Key) bool {
+		return key.Namespace == namespace && key.Type == typ
+	})
+}
+
+// ListKeys get the keys whose namespace matches namespacePattern and whose type is typeFilter, see SecretsKVStore.
+// The secrets are selected by the org label, the namespace and type are read from their annotations.
+func (kv *secretsKVStoreKubernetes) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	return kv.listKeys(ctx, orgId, func(key Key) bool {
+		return matchKey(key, orgId, namespacePattern, typeFilter)
+	})
+}
+
+// Rename an item in the store. Kubernetes objects can't be renamed, so the value is copied to its new name
+// before the old name is deleted.
+func (kv *secretsKVStoreKubernetes) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	value, exists, err := kv.Get(ctx, orgId, namespace, typ)
+	if err != nil || !exists {
+		return err
+	}
+	if err := kv.Set(ctx, orgId, newNamespace, typ, value); err != nil {
+		return err
+	}
+	return kv.Del(ctx, orgId, namespace, typ)
+}
+
+// SetMultiple sets the items one at a time, the Kubernetes API has no batch calls
+func (kv *secretsKVStoreKubernetes) SetMultiple(ctx context.Context, items []Item) error {
+	return setEach(ctx, kv, items)
+}
+
+// DelMultiple deletes the items one at a time, see SetMultiple
+func (kv *secretsKVStoreKubernetes) DelMultiple(ctx context.Context, keys []Key) error {
+	return delEach(ctx, kv, keys)
+}
+
+// GetVersion is not supported, Kubernetes only keeps the current value of secrets
+func (kv *secretsKVStoreKubernetes) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	return "", false, ErrVersioningNotSupported
+}
+
+// ListVersions is not supported, see GetVersion
+func (kv *secretsKVStoreKubernetes) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	return nil, ErrVersioningNotSupported
+}
+
+// Rollback is not supported, see GetVersion
+func (kv *secretsKVStoreKubernetes) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	return ErrVersioningNotSupported
+}
+
+// Health checks that the service account of Grafana can list the secrets of the namespace.
+func (kv *secretsKVStoreKubernetes) Health(ctx context.Context) error {
+	_, err := kv.do(ctx, http.MethodGet, kv.secretURL("")+"?limit=1", nil, nil)
+	return err
+}
+
+// secretName mangles the org/namespace/type triple into a valid secret name.
+// The readable part is lossy, the hash of the triple keeps names unique.
+func (kv *secretsKVStoreKubernetes) secretName(orgId int64, namespace string, typ string) string {
+	sanitize := func(s string) string {
+		s = strings.Trim(kubernetesNameUnsafeChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+		if len(s) > 63 {
+			s = strings.TrimRight(s[:63], "-")
+		}
+		return s
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\x00%s\x00%s", orgId, namespace, typ)))
+	parts := []string{kv.settings.NamePrefix, strconv.FormatInt(orgId, 10), sanitize(namespace), sanitize(typ), hex.EncodeToString(sum[:8])}
+	name := parts[0]
+	for _, part := range parts[1:] {
+		if part != "" {
+			name += "-" + part
+		}
+	}
+	return name
+}
+
+func (kv *secretsKVStoreKubernetes) newSecret(orgId int64, namespace string, typ string, value string) kubernetesSecret {
+	return kubernetesSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: kubernetesObjectMeta{
+			Name: kv.secretName(orgId, namespace, typ),
+			Labels: map[string]string{
+				kubernetesLabelManagedBy: kubernetesManagedByGrafana,
+				kubernetesLabelPrefix:    kv.settings.NamePrefix,
+				kubernetesLabelOrgId:     strconv.FormatInt(orgId, 10),
+			},
+			Annotations: map[string]string{
+				kubernetesAnnotationNS:   namespace,
+				kubernetesAnnotationType: typ,
+			},
+		},
+		Type: "Opaque",
+		Data: map[string][]byte{kubernetesValueKey: []byte(value)},
+	}
+}
+
+func (kv *secretsKVStoreKubernetes) secretURL(name string) string {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets", kv.settings.APIURL, url.PathEscape(kv.settings.Namespace))
+	if name != "" {
+		u += "/" + name
+	}
+	return u
+}
+
+// listKeys pages through the secrets set by Grafana in the org, or all orgs, and returns the keys that match
+func (kv *secretsKVStoreKubernetes) listKeys(ctx context.Context, orgId int64, match func(key Key) bool) ([]Key, error) {
+	selector := fmt.Sprintf("%s=%s,%s=%s", kubernetesLabelManagedBy, kubernetesManagedByGrafana, kubernetesLabelPrefix, kv.settings.NamePrefix)
+	if orgId != AllOrganizations {
+		selector += fmt.Sprintf(",%s=%d", kubernetesLabelOrgId, orgId)
+	}
+	var keys []Key
+	continueToken := ""
+	for {
+		query := url.Values{"labelSelector": {selector}, "limit": {strconv.Itoa(kubernetesListLimit)}}
+		if continueToken != "" {
+			query.Set("continue", continueToken)
+		}
+		var list kubernetesSecretList
+		if _, err := kv.do(ctx, http.MethodGet, kv.secretURL("")+"?"+query.Encode(), nil, &list); err != nil {
+			return nil, err
+		}
+		for _, secret := range list.Items {
+			id, err := strconv.ParseInt(secret.Metadata.Labels[kubernetesLabelOrgId], 10, 64)
+			if err != nil {
+				continue
+			}
+			key := Key{OrgId: id, Namespace: secret.Metadata.Annotations[kubernetesAnnotationNS], Type: secret.Metadata.Annotations[kubernetesAnnotationType]}
+			if match(key) {
+				keys = append(keys, key)
+			}
+		}
+		if continueToken = list.Metadata.Continue; continueToken == "" {
+			return keys, nil
+		}
+	}
+}
+
+func (kv *secretsKVStoreKubernetes) do(ctx context.Context, method string, reqURL string, body interface{}, out interface{}) (int, error) {
+	token, err := kv.getToken()
+	if err != nil {
+		return 0, err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := kv.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var status struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&status)
+		return res.StatusCode, fmt.Errorf("kubernetes request failed with status %d: %s %s", res.StatusCode, status.Reason, status.Message)
+	}
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return res.StatusCode, err
+		}
+	}
+	return res.StatusCode, nil
+}
+
+// getToken returns the service account token, read again from its file every kubernetesTokenRefreshInterval
+func (kv *secretsKVStoreKubernetes) getToken() (string, error) {
+	kv.tokenMu.Lock()
+	defer kv.tokenMu.Unlock()
+	if kv.token != "" && time.Since(kv.tokenRead) < kubernetesTokenRefreshInterval {
+		return kv.token, nil
+	}
+
+	// nolint:gosec
+	token, err := os.ReadFile(kv.settings.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read kubernetes service account token: %w", err)
+	}
+	kv.token, kv.tokenRead = strings.TrimSpace(string(token)), time.Now()
+	return kv.token, nil
+}
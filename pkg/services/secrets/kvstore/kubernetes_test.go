@@ -0,0 +1,217 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretsKVStoreKubernetes(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("validates the settings", func(t *testing.T) {
+		valid := kubernetesSettings{APIURL: "https://kubernetes", Namespace: "grafana", NamePrefix: "grafana"}
+		require.NoError(t, valid.validate())
+		for _, prefix := range []string{"", "Grafana", "-grafana", "grafana-", strings.Repeat("a", 21)} {
+			invalid := valid
+			invalid.NamePrefix = prefix
+			assert.Error(t, invalid.validate(), prefix)
+		}
+		invalid := valid
+		invalid.Namespace = ""
+		assert.Error(t, invalid.validate())
+	})
+
+	t.Run("mangles the org, namespace and type into a valid name", func(t *testing.T) {
+		kv := setupKubernetesStore(t, newFakeKubernetesAPI(t))
+
+		name := kv.secretName(1, "My Datasource/prod", "datasource")
+		assert.Regexp(t, `^grafana-1-my-datasource-prod-datasource-[0-9a-f]{16}$`, name)
+		assert.NotEqual(t, name, kv.secretName(1, "my datasource prod", "datasource"))
+		assert.LessOrEqual(t, len(kv.secretName(1, strings.Repeat("a", 500), strings.Repeat("b", 500))), 253)
+	})
+
+	t.Run("stores, lists and renames secrets", func(t *testing.T) {
+		api := newFakeKubernetesAPI(t)
+		kv := setupKubernetesStore(t, api)
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "one"))
+		require.NoError(t, kv.Set(ctx, 2, "ds", "datasource", "two"))
+		require.NoError(t, kv.Set(ctx, 2, "other", "datasource", "three"))
+		require.NoError(t, kv.Set(ctx, 2, "ds", "datasource", "updated"))
+		assert.Len(t, api.secrets, 3)
+
+		value, exists, err := kv.Get(ctx, 2, "ds", "datasource")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "updated", value)
+
+		keys, err := kv.Keys(ctx, AllOrganizations, "ds", "datasource")
+		require.NoError(t, err)
+		sort.Slice(keys, func(i, j int) bool { return keys[i].OrgId < keys[j].OrgId })
+		assert.Equal(t, []Key{{OrgId: 1, Namespace: "ds", Type: "datasource"}, {OrgId: 2, Namespace: "ds", Type: "datasource"}}, keys)
+
+		keys, err = kv.ListKeys(ctx, 2, "o*", "")
+		require.NoError(t, err)
+		assert.Equal(t, []Key{{OrgId: 2, Namespace: "other", Type: "datasource"}}, keys)
+
+		require.NoError(t, kv.Rename(ctx, 1, "ds", "datasource", "renamed"))
+		keys, err = kv.Keys(ctx, 1, "renamed", "datasource")
+		require.NoError(t, err)
+		assert.Len(t, keys, 1)
+		_, exists, err = kv.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		require.NoError(t, kv.Del(ctx, 1, "renamed", "datasource"))
+		require.NoError(t, kv.Del(ctx, 1, "renamed", "datasource"))
+		assert.Len(t, api.secrets, 2)
+	})
+
+	t.Run("ignores the secrets it did not set", func(t *testing.T) {
+		api := newFakeKubernetesAPI(t)
+		api.secrets["tls"] = kubernetesSecret{Metadata: kubernetesObjectMeta{Name: "tls"}}
+		kv := setupKubernetesStore(t, api)
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "one"))
+
+		keys, err := kv.ListKeys(ctx, AllOrganizations, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, []Key{{OrgId: 1, Namespace: "ds", Type: "datasource"}}, keys)
+	})
+
+	t.Run("reads the rotated service account token", func(t *testing.T) {
+		kubernetesTokenRefreshInterval = 0
+		t.Cleanup(func() {
+			kubernetesTokenRefreshInterval = time.Minute
+		})
+		api := newFakeKubernetesAPI(t)
+		kv := setupKubernetesStore(t, api)
+		require.NoError(t, kv.Health(ctx))
+
+		api.mu.Lock()
+		api.token = "rotated"
+		api.mu.Unlock()
+		require.Error(t, kv.Health(ctx))
+		require.NoError(t, os.WriteFile(kv.settings.TokenFile, []byte("rotated\n"), 0600))
+		require.NoError(t, kv.Health(ctx))
+	})
+}
+
+func setupKubernetesStore(t *testing.T, api *fakeKubernetesAPI) *secretsKVStoreKubernetes {
+	t.Helper()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte(api.token), 0600))
+	sqlStore := sqlstore.InitTestDB(t)
+	return &secretsKVStoreKubernetes{
+		log:      log.New("test"),
+		settings: kubernetesSettings{APIURL: api.server.URL, Namespace: "grafana", NamePrefix: "grafana", TokenFile: tokenFile},
+		client:   api.server.Client(),
+		kvstore:  GetNamespacedKVStore(kvstore.ProvideService(sqlStore)),
+	}
+}
+
+// fakeKubernetesAPI serves the parts of the Kubernetes secrets API used by the kubernetes store
+type fakeKubernetesAPI struct {
+	server  *httptest.Server
+	mu      sync.Mutex
+	token   string
+	secrets map[string]kubernetesSecret
+}
+
+func newFakeKubernetesAPI(t *testing.T) *fakeKubernetesAPI {
+	t.Helper()
+	api := &fakeKubernetesAPI{token: "token", secrets: map[string]kubernetesSecret{}}
+	api.server = httptest.NewServer(http.HandlerFunc(api.handle))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (a *fakeKubernetesAPI) handle(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+a.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const collection = "/api/v1/namespaces/grafana/secrets"
+	switch {
+	case r.URL.Path == collection && r.Method == http.MethodGet:
+		a.list(w, r)
+	case r.URL.Path == collection && r.Method == http.MethodPost:
+		var secret kubernetesSecret
+		_ = json.NewDecoder(r.Body).Decode(&secret)
+		if _, ok := a.secrets[secret.Metadata.Name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		a.secrets[secret.Metadata.Name] = secret
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(secret)
+	case strings.HasPrefix(r.URL.Path, collection+"/"):
+		name := strings.TrimPrefix(r.URL.Path, collection+"/")
+		secret, ok := a.secrets[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"reason": "NotFound"})
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			_ = json.NewDecoder(r.Body).Decode(&secret)
+			a.secrets[name] = secret
+		case http.MethodDelete:
+			delete(a.secrets, name)
+		}
+		_ = json.NewEncoder(w).Encode(secret)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// list returns one secret per page to follow the continue tokens
+func (a *fakeKubernetesAPI) list(w http.ResponseWriter, r *http.Request) {
+	selector := map[string]string{}
+	for _, requirement := range strings.Split(r.URL.Query().Get("labelSelector"), ",") {
+		if parts := strings.SplitN(requirement, "=", 2); len(parts) == 2 {
+			selector[parts[0]] = parts[1]
+		}
+	}
+	var names []string
+	for name, secret := range a.secrets {
+		matches := true
+		for label, value := range selector {
+			matches = matches && secret.Metadata.Labels[label] == value
+		}
+		if matches {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	skip, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+	list := kubernetesSecretList{Items: []kubernetesSecret{}}
+	if skip < len(names) {
+		list.Items = append(list.Items, a.secrets[names[skip]])
+		if skip+1 < len(names) {
+			list.Metadata.Continue = strconv.Itoa(skip + 1)
+		}
+	}
+	_ = json.NewEncoder(w).Encode(list)
+}
@@ -2,8 +2,11 @@ package kvstore
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/plugins"
@@ -17,37 +20,113 @@ import (
 const (
 	// Wildcard to query all organizations
 	AllOrganizations = -1
+
+	// Backends selected with `secrets.backend`
+	BackendSQL              = "sql"
+	BackendVault            = "vault"
+	BackendAzureKeyVault    = "azure_key_vault"
+	BackendGCPSecretManager = "gcp_secret_manager"
+	BackendKubernetes       = "kubernetes"
 )
 
 func ProvideService(
 	sqlStore sqlstore.Store,
 	secretsService secrets.Service,
 	pluginsManager plugins.SecretsPluginManager,
-	kvstore kvstore.KVStore,
+	kvStore kvstore.KVStore,
 	features featuremgmt.FeatureToggles,
 	cfg *setting.Cfg,
+	audit *AuditService,
+	health *HealthService,
+	validation *ValidationService,
+	accessControl *AccessControlService,
+	sharedCache *SharedCacheService,
+	quarantine *PluginQuarantineService,
+	reload *PluginReloadService,
+	routing *SecretsRoutingService,
+	clk clock.Clock,
 ) (SecretsKVStore, error) {
 	var logger = log.New("secrets.kvstore")
 	var store SecretsKVStore
-	store = &secretsKVStoreSQL{
-		sqlStore:       sqlStore,
-		secretsService: secretsService,
-		log:            logger,
-		decryptionCache: decryptionCache{
-			cache: make(map[int64]cachedDecrypted),
-		},
-	}
-	err := EvaluateRemoteSecretsPlugin(pluginsManager, cfg)
+	storeBackend := BackendSQL
+	sqlKVStore := &secretsKVStoreSQL{
+		sqlStore:             sqlStore,
+		secretsService:       secretsService,
+		log:                  logger,
+		decryptionCache:      newDecryptionCacheFromConfig(cfg),
+		historyVersions:      cfg.SectionWithEnvOverrides("secrets").Key("sql_history_versions").MustInt(5),
+		compressionThreshold: cfg.SectionWithEnvOverrides("secrets").Key("sql_compression_threshold").MustInt(0),
+		clock:                clk,
+	}
+	store = sqlKVStore
+	namespacedKVStore := GetNamespacedKVStore(kvStore)
+	routes, err := readSecretsRoutes(cfg)
+	if err != nil {
+		return nil, err
+	}
+	newRoutedBackend := func(name string) (SecretsKVStore, error) {
+		if name == BackendPlugin {
+			return nil, fmt.Errorf("the secrets manager plugin can only be the default backend")
+		}
+		backendStore, err := newSecretsBackend(name, cfg, sqlStore, namespacedKVStore,
+			features.IsEnabled(featuremgmt.FlagDisableSecretsCompatibility), logger)
+		if err != nil {
+			return nil, err
+		}
+		if err := backendStore.Health(context.Background()); err != nil {
+			return nil, err
+		}
+		health.register(name, backendStore.Health)
+		return backendStore, nil
+	}
+	if backend := cfg.SectionWithEnvOverrides("secrets").Key("backend").MustString(BackendSQL); backend != BackendSQL {
+		backendStore, err := newSecretsBackend(backend, cfg, sqlStore, namespacedKVStore,
+			features.IsEnabled(featuremgmt.FlagDisableSecretsCompatibility), logger)
+		if err == nil {
+			err = backendStore.Health(context.Background())
+		}
+		if err == nil {
+			logger.Debug("secrets kvstore is using a remote backend for secrets management", "backend", backend)
+			health.register(backend, backendStore.Health)
+			mirrored := withMirror(sqlKVStore, backendStore, backend, cfg, logger)
+			routed, err := withRouting(mirrored, backend, sqlKVStore, routes, newRoutedBackend, routing)
+			if err != nil {
+				return nil, err
+			}
+			return audit.Wrap(accessControl.Wrap(validation.Wrap(sharedCache.withCaches(health.Wrap(instrument(routed, backend, cfg, logger)))))), nil
+		}
+		// Same as for the plugin, an unhealthy backend is only fatal once secrets
+		// were stored in it without backwards compatibility.
+		logger.Error("secrets backend is not available", "backend", backend, "error", err)
+		if isFatal, readErr := isPluginStartupErrorFatal(context.Background(), namespacedKVStore); isFatal || readErr != nil {
+			logger.Error("secrets backend is required to start -- exiting app", "backend", backend)
+			if readErr != nil {
+				return nil, readErr
+			}
+			return nil, err
+		}
+	}
+
+	err = EvaluateRemoteSecretsPlugin(pluginsManager, cfg)
 	if err != nil {
 		logger.Debug(err.Error())
 	} else {
 		// Attempt to start the plugin
 		var secretsPlugin secretsmanagerplugin.SecretsManagerPlugin
 		secretsPlugin, err = startAndReturnPlugin(pluginsManager, context.Background())
-		namespacedKVStore := GetNamespacedKVStore(kvstore)
 		if err != nil || secretsPlugin == nil {
 			logger.Error("failed to start remote secrets management plugin", "msg", err.Error())
-			if isFatal, readErr := isPluginStartupErrorFatal(context.Background(), namespacedKVStore); isFatal || readErr != nil {
+			quarantine.recordCrash(context.Background(), err)
+			if quarantine.isQuarantined(context.Background()) {
+				// a crash-looping plugin doesn't stop Grafana from starting once it is quarantined
+				logger.Error("secrets management plugin is quarantined, secrets are read from the database and can't be updated")
+				startErr := err
+				health.register(BackendPlugin, func(context.Context) error { return startErr })
+				health.registerQuarantine(quarantine.Get)
+				store = quarantine.wrap(nil, sqlKVStore)
+				storeBackend = BackendPlugin
+				err = nil
+			} else if isFatal, readErr := isPluginStartupErrorFatal(context.Background(), namespacedKVStore); isFatal || readErr != nil {
 				// plugin error was fatal or there was an error determining if the error was fatal
 				logger.Error("secrets management plugin is required to start -- exiting app")
 				if readErr != nil {
@@ -56,30 +135,149 @@ func ProvideService(
 				return nil, err
 			}
 		} else {
-			store = &secretsKVStorePlugin{
+			readCache, err := newPluginReadCacheFromConfig(cfg)
+			if err != nil {
+				return nil, err
+			}
+			pluginStore := &secretsKVStorePlugin{
 				secretsPlugin:                  secretsPlugin,
 				secretsService:                 secretsService,
 				log:                            logger,
 				kvstore:                        namespacedKVStore,
 				backwardsCompatibilityDisabled: features.IsEnabled(featuremgmt.FlagDisableSecretsCompatibility),
+				breaker:                        newPluginCircuitBreakerFromConfig(cfg, logger),
+				readCache:                      readCache,
+				capabilities:                   negotiatePluginCapabilities(context.Background(), secretsPlugin, logger),
+				quarantine:                     quarantine,
 			}
+			reload.register(pluginStore)
+			health.register(BackendPlugin, pluginStore.Health)
+			health.registerQuarantine(quarantine.Get)
+			store = withMirror(sqlKVStore, quarantine.wrap(withPluginRetries(pluginStore, cfg, logger), sqlKVStore), BackendPlugin, cfg, logger)
+			storeBackend = BackendPlugin
 		}
 	}
 
 	if err != nil {
 		logger.Debug("secrets kvstore is using the default (SQL) implementation for secrets management")
+		health.register(BackendSQL, sqlHealthCheck(sqlStore))
 	}
 
-	return NewCachedKVStore(store, 5*time.Second, 5*time.Minute), nil
+	store, err = withRouting(store, storeBackend, sqlKVStore, routes, newRoutedBackend, routing)
+	if err != nil {
+		return nil, err
+	}
+
+	return audit.Wrap(accessControl.Wrap(validation.Wrap(sharedCache.withCaches(health.Wrap(instrument(store, storeBackend, cfg, logger)))))), nil
+}
+
+// secretsBackend is a SecretsKVStore outside of Grafana, selected with `secrets.backend`.
+type secretsBackend interface {
+	SecretsKVStore
+	// Health checks that the backend can be used at startup.
+	Health(ctx context.Context) error
+}
+
+func newSecretsBackend(backend string, cfg *setting.Cfg, sqlStore sqlstore.Store, kv *kvstore.NamespacedKVStore, backwardsCompatibilityDisabled bool, logger log.Logger) (secretsBackend, error) {
+	switch backend {
+	case BackendVault:
+		return newSecretsKVStoreVault(readVaultSettings(cfg), sqlStore, kv, backwardsCompatibilityDisabled, logger)
+	case BackendAzureKeyVault:
+		return newSecretsKVStoreAzure(readAzureKeyVaultSettings(cfg), kv, backwardsCompatibilityDisabled, logger)
+	case BackendGCPSecretManager:
+		return newSecretsKVStoreGCP(readGCPSecretManagerSettings(cfg), kv, backwardsCompatibilityDisabled, logger)
+	case BackendKubernetes:
+		return newSecretsKVStoreKubernetes(readKubernetesSettings(cfg), kv, backwardsCompatibilityDisabled, logger)
+	}
+	return nil, fmt.Errorf("unknown secrets backend %q", backend)
 }
 
 // SecretsKVStore is an interface for k/v store.
 type SecretsKVStore interface {
 	Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error)
 	Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error
+	// SetWithTTL sets an item that expires after ttl, it is not returned anymore once expired. Stores that
+	// don't support expiration return ErrTTLNotSupported.
+	SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error
 	Del(ctx context.Context, orgId int64, namespace string, typ string) error
 	Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error)
+	// ListKeys returns the keys of the secrets whose namespace matches namespacePattern, in which * matches any
+	// characters, and whose type is typeFilter, without reading their values. An empty pattern or filter matches
+	// every namespace or type. Stores that can only list the keys of a namespace and type return
+	// ErrKeyListingNotSupported for the other patterns.
+	ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error)
 	Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error
+	// GetVersion, ListVersions and Rollback give access to the previous values of a secret. Stores that don't
+	// keep them return ErrVersioningNotSupported.
+	GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error)
+	ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error)
+	Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error
+	// SetMultiple and DelMultiple set and delete several items at once, the OrgId, Namespace and Type of the
+	// items must be set. The sql store applies them in a single transaction, other stores may apply only some
+	// of them when they fail.
+	SetMultiple(ctx context.Context, items []Item) error
+	DelMultiple(ctx context.Context, keys []Key) error
+}
+
+// setEach sets the items one at a time, for the stores that can't set several items at once
+func setEach(ctx context.Context, kv SecretsKVStore, items []Item) error {
+	for _, item := range items {
+		var err error
+		if item.Expires != nil {
+			err = kv.SetWithTTL(ctx, *item.OrgId, *item.Namespace, *item.Type, item.Value, time.Until(*item.Expires))
+		} else {
+			err = kv.Set(ctx, *item.OrgId, *item.Namespace, *item.Type, item.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delEach deletes the keys one at a time, for the stores that can't delete several items at once
+func delEach(ctx context.Context, kv SecretsKVStore, keys []Key) error {
+	for _, key := range keys {
+		if err := kv.Del(ctx, key.OrgId, key.Namespace, key.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchKey returns whether the key is selected by the arguments of ListKeys
+func matchKey(key Key, orgId int64, namespacePattern string, typeFilter string) bool {
+	return (orgId == AllOrganizations || key.OrgId == orgId) &&
+		(typeFilter == "" || key.Type == typeFilter) &&
+		matchNamespace(namespacePattern, key.Namespace)
+}
+
+// matchNamespace returns whether the namespace matches the pattern, in which * matches any characters
+func matchNamespace(pattern string, namespace string) bool {
+	if pattern == "" {
+		return true
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == namespace
+	}
+	if !strings.HasPrefix(namespace, parts[0]) {
+		return false
+	}
+	rest := namespace[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return strings.HasSuffix(rest, parts[len(parts)-1])
+}
+
+// isExactKeyPattern returns whether ListKeys selects a single namespace and type, which Keys can list
+func isExactKeyPattern(namespacePattern string, typeFilter string) bool {
+	return namespacePattern != "" && typeFilter != "" && !strings.Contains(namespacePattern, "*")
 }
 
 // WithType returns a kvstore wrapper with fixed orgId and type.
@@ -108,6 +306,10 @@ func (kv *FixedKVStore) Set(ctx context.Context, value string) error {
 	return kv.kvStore.Set(ctx, kv.OrgId, kv.Namespace, kv.Type, value)
 }
 
+func (kv *FixedKVStore) SetWithTTL(ctx context.Context, value string, ttl time.Duration) error {
+	return kv.kvStore.SetWithTTL(ctx, kv.OrgId, kv.Namespace, kv.Type, value, ttl)
+}
+
 func (kv *FixedKVStore) Del(ctx context.Context) error {
 	return kv.kvStore.Del(ctx, kv.OrgId, kv.Namespace, kv.Type)
 }
@@ -116,6 +318,18 @@ func (kv *FixedKVStore) Keys(ctx context.Context) ([]Key, error) {
 	return kv.kvStore.Keys(ctx, kv.OrgId, kv.Namespace, kv.Type)
 }
 
+func (kv *FixedKVStore) GetVersion(ctx context.Context, version int64) (string, bool, error) {
+	return kv.kvStore.GetVersion(ctx, kv.OrgId, kv.Namespace, kv.Type, version)
+}
+
+func (kv *FixedKVStore) ListVersions(ctx context.Context) ([]SecretVersion, error) {
+	return kv.kvStore.ListVersions(ctx, kv.OrgId, kv.Namespace, kv.Type)
+}
+
+func (kv *FixedKVStore) Rollback(ctx context.Context, version int64) error {
+	return kv.kvStore.Rollback(ctx, kv.OrgId, kv.Namespace, kv.Type, version)
+}
+
 func (kv *FixedKVStore) Rename(ctx context.Context, newNamespace string) error {
 	err := kv.kvStore.Rename(ctx, kv.OrgId, kv.Namespace, kv.Type, newNamespace)
 	if err != nil {
@@ -0,0 +1,141 @@
+package kvstore
+
+import (
+	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	pluginMigrationSecretsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_plugin_migration_secrets",
+		Help:      "Number of secrets of the current or last plugin secret migration, by state",
+	}, []string{"state"})
+	pluginMigrationPhaseGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_plugin_migration_phase",
+		Help:      "Phase of the plugin secret migration, 1 for the current phase and 0 for the others",
+	}, []string{"phase"})
+	auditDroppedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_audit_dropped_total",
+		Help:      "Number of secrets audit entries that were dropped because the buffer was full or could not be written",
+	})
+	decryptionCacheHitsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_decryption_cache_hits_total",
+		Help:      "Number of secret values of the sql store read from the decryption cache",
+	})
+	decryptionCacheMissesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_decryption_cache_misses_total",
+		Help:      "Number of secret values of the sql store that were not cached, or whose cached value was stale or expired",
+	})
+	decryptionCacheEvictionsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_decryption_cache_evictions_total",
+		Help:      "Number of secret values evicted from the decryption cache because it was full",
+	})
+	decryptionCacheInvalidationsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_decryption_cache_invalidations_total",
+		Help:      "Number of secret values removed from the decryption cache because the secret was updated or deleted",
+	})
+	pluginCircuitBreakerOpenGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_plugin_circuit_breaker_open",
+		Help:      "1 while the circuit breaker of the secrets manager plugin is open because the plugin is unavailable, 0 otherwise",
+	})
+	pluginCircuitBreakerTripsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_plugin_circuit_breaker_trips_total",
+		Help:      "Number of times the circuit breaker of the secrets manager plugin opened",
+	})
+	pluginReadCacheFallbacksCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_plugin_read_cache_fallbacks_total",
+		Help:      "Number of secret values read from the read cache while the secrets manager plugin was unavailable",
+	})
+	pluginCrashesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_plugin_crashes_total",
+		Help:      "Number of crashes of the secrets manager plugin, including the failures to start it",
+	})
+	pluginQuarantinedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_plugin_quarantined",
+		Help:      "1 while the secrets manager plugin is quarantined because it crashed repeatedly, 0 otherwise",
+	})
+	pluginReloadsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_plugin_reloads_total",
+		Help:      "Number of reloads of the secrets manager plugin, by success",
+	}, []string{"success"})
+	pluginRetriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_plugin_retries_total",
+		Help:      "Number of operations of the secrets manager plugin retried after a transient error, by operation",
+	}, []string{"operation"})
+	mirrorWriteFailuresCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_mirror_write_failures_total",
+		Help:      "Number of writes of the secrets store that could not be mirrored to the secondary store, by operation",
+	}, []string{"operation"})
+	sharedCacheHitsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_shared_cache_hits_total",
+		Help:      "Number of secret values read from the cache shared by the Grafana instances",
+	})
+	sharedCacheMissesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_shared_cache_misses_total",
+		Help:      "Number of secret values that were not in the cache shared by the Grafana instances, or could not be read from it",
+	})
+	sharedCacheInvalidationsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_shared_cache_invalidations_received_total",
+		Help:      "Number of invalidation messages of the shared secrets cache received from the Grafana instances",
+	})
+	integrityFailuresGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_integrity_failures",
+		Help:      "Number of secrets of the database that could not be decrypted by the last integrity check",
+	})
+	operationDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_operation_duration_seconds",
+		Help:      "Duration of the operations of the secrets store, by operation and backend",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"operation", "backend"})
+	operationErrorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.ExporterName,
+		Name:      "secrets_operation_errors_total",
+		Help:      "Number of operations of the secrets store that failed, by operation and backend",
+	}, []string{"operation", "backend"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		pluginMigrationSecretsGauge,
+		pluginMigrationPhaseGauge,
+		auditDroppedCounter,
+		decryptionCacheHitsCounter,
+		decryptionCacheMissesCounter,
+		decryptionCacheEvictionsCounter,
+		decryptionCacheInvalidationsCounter,
+		pluginCircuitBreakerOpenGauge,
+		pluginCircuitBreakerTripsCounter,
+		pluginReadCacheFallbacksCounter,
+		pluginCrashesCounter,
+		pluginQuarantinedGauge,
+		pluginReloadsCounter,
+		pluginRetriesCounter,
+		mirrorWriteFailuresCounter,
+		sharedCacheHitsCounter,
+		sharedCacheMissesCounter,
+		sharedCacheInvalidationsCounter,
+		integrityFailuresGauge,
+		operationDurationHistogram,
+		operationErrorsCounter,
+	)
+}
@@ -0,0 +1,220 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	// Primary stores of the mirroring, selected with `secrets.mirror_primary`
+	MirrorPrimarySQL     = "sql"
+	MirrorPrimaryBackend = "backend"
+)
+
+var ErrSecretsMirrorDisabled = errors.New("secrets are not mirrored")
+
+// SecretsMirrorReport lists the secrets that differ between the primary and the secondary store of the mirroring
+type SecretsMirrorReport struct {
+	Primary   string `json:"primary"`
+	Secondary string `json:"secondary"`
+	// Compared is the number of secrets that are in both stores
+	Compared        int               `json:"compared"`
+	OnlyInPrimary   []SecretReference `json:"onlyInPrimary"`
+	OnlyInSecondary []SecretReference `json:"onlyInSecondary"`
+	Different       []SecretReference `json:"different"`
+}
+
+// mirroredKVStore applies the writes to both the sql store and the plugin or external backend, so that the backend
+// can be switched without downtime: the secrets are mirrored while both stores are in use, and the primary store,
+// which serves the reads, is switched once they don't diverge anymore. The writes only fail when the primary store
+// fails, the failures of the secondary store are logged and counted, and show up in the divergence report.
+type mirroredKVStore struct {
+	primary          SecretsKVStore
+	secondary        SecretsKVStore
+	primaryBackend   string
+	secondaryBackend string
+	log              log.Logger
+}
+
+func isSecretsMirrorEnabled(cfg *setting.Cfg) bool {
+	return cfg.SectionWithEnvOverrides("secrets").Key("mirror").MustBool(false)
+}
+
+// withMirror returns store mirrored with the sql store when `secrets.mirror` is set, or store as is
+func withMirror(sqlStore SecretsKVStore, store SecretsKVStore, backend string, cfg *setting.Cfg, logger log.Logger) SecretsKVStore {
+	if !isSecretsMirrorEnabled(cfg) {
+		return store
+	}
+	mirrored := &mirroredKVStore{
+		primary:          sqlStore,
+		secondary:        store,
+		primaryBackend:   BackendSQL,
+		secondaryBackend: backend,
+		log:              logger,
+	}
+	primary := cfg.SectionWithEnvOverrides("secrets").Key("mirror_primary").In(MirrorPrimarySQL, []string{MirrorPrimarySQL, MirrorPrimaryBackend})
+	if primary == MirrorPrimaryBackend {
+		mirrored.primary, mirrored.secondary = store, sqlStore
+		mirrored.primaryBackend, mirrored.secondaryBackend = backend, BackendSQL
+	}
+	logger.Info("secrets are mirrored, reads are served from the primary store", "primary", mirrored.primaryBackend, "secondary", mirrored.secondaryBackend)
+	return mirrored
+}
+
+// mirror applies the write to the secondary store once it succeeded in the primary store
+func (kv *mirroredKVStore) mirror(operation string, err error, fn func() error) error {
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		kv.log.Warn("failed to mirror secrets write to the secondary store", "operation", operation, "secondary", kv.secondaryBackend, "error", err)
+		mirrorWriteFailuresCounter.WithLabelValues(operation).Inc()
+	}
+	return nil
+}
+
+func (kv *mirroredKVStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	return kv.primary.Get(ctx, orgId, namespace, typ)
+}
+
+func (kv *mirroredKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	return kv.mirror(operationSet, kv.primary.Set(ctx, orgId, namespace, typ, value), func() error {
+		return kv.secondary.Set(ctx, orgId, namespace, typ, value)
+	})
+}
+
+func (kv *mirroredKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	return kv.mirror(operationSetWithTTL, kv.primary.SetWithTTL(ctx, orgId, namespace, typ, value, ttl), func() error {
+		return kv.secondary.SetWithTTL(ctx, orgId, namespace, typ, value, ttl)
+	})
+}
+
+func (kv *mirroredKVStore) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	return kv.mirror(operationDelete, kv.primary.Del(ctx, orgId, namespace, typ), func() error {
+		return kv.secondary.Del(ctx, orgId, namespace, typ)
+	})
+}
+
+func (kv *mirroredKVStore) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	return kv.primary.Keys(ctx, orgId, namespace, typ)
+}
+
+func (kv *mirroredKVStore) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	return kv.primary.ListKeys(ctx, orgId, namespacePattern, typeFilter)
+}
+
+func (kv *mirroredKVStore) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	return kv.mirror(operationRename, kv.primary.Rename(ctx, orgId, namespace, typ, newNamespace), func() error {
+		return kv.secondary.Rename(ctx, orgId, namespace, typ, newNamespace)
+	})
+}
+
+func (kv *mirroredKVStore) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	return kv.primary.GetVersion(ctx, orgId, namespace, typ, version)
+}
+
+func (kv *mirroredKVStore) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	return kv.primary.ListVersions(ctx, orgId, namespace, typ)
+}
+
+// Rollback rolls back the secret of the primary store, whose versions are not those of the secondary store, and
+// sets the rolled back value in the secondary store
+func (kv *mirroredKVStore) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	return kv.mirror(operationRollback, kv.primary.Rollback(ctx, orgId, namespace, typ, version), func() error {
+		value, found, err := kv.primary.Get(ctx, orgId, namespace, typ)
+		if err != nil || !found {
+			return err
+		}
+		return kv.secondary.Set(ctx, orgId, namespace, typ, value)
+	})
+}
+
+func (kv *mirroredKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	return kv.mirror(operationSetMultiple, kv.primary.SetMultiple(ctx, items), func() error {
+		return kv.secondary.SetMultiple(ctx, items)
+	})
+}
+
+func (kv *mirroredKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	return kv.mirror(operationDelMultiple, kv.primary.DelMultiple(ctx, keys), func() error {
+		return kv.secondary.DelMultiple(ctx, keys)
+	})
+}
+
+// Divergence compares the secrets of the primary and the secondary store. Both stores must support listing keys.
+func (kv *mirroredKVStore) Divergence(ctx context.Context) (*SecretsMirrorReport, error) {
+	primaryKeys, err := kv.primary.ListKeys(ctx, AllOrganizations, "", "")
+	if err != nil {
+		return nil, err
+	}
+	secondaryKeys, err := kv.secondary.ListKeys(ctx, AllOrganizations, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SecretsMirrorReport{
+		Primary:         kv.primaryBackend,
+		Secondary:       kv.secondaryBackend,
+		OnlyInPrimary:   []SecretReference{},
+		OnlyInSecondary: []SecretReference{},
+		Different:       []SecretReference{},
+	}
+	inSecondary := make(map[Key]bool, len(secondaryKeys))
+	for _, key := range secondaryKeys {
+		inSecondary[key] = true
+	}
+	for _, key := range primaryKeys {
+		if !inSecondary[key] {
+			report.OnlyInPrimary = append(report.OnlyInPrimary, keyReference(key))
+			continue
+		}
+		delete(inSecondary, key)
+		primaryValue, primaryFound, err := kv.primary.Get(ctx, key.OrgId, key.Namespace, key.Type)
+		if err != nil {
+			return nil, err
+		}
+		secondaryValue, secondaryFound, err := kv.secondary.Get(ctx, key.OrgId, key.Namespace, key.Type)
+		if err != nil {
+			return nil, err
+		}
+		if !primaryFound || !secondaryFound {
+			// deleted or expired since the keys were listed
+			continue
+		}
+		report.Compared++
+		if primaryValue != secondaryValue {
+			report.Different = append(report.Different, keyReference(key))
+		}
+	}
+	for key := range inSecondary {
+		report.OnlyInSecondary = append(report.OnlyInSecondary, keyReference(key))
+	}
+
+	sortSecretReferences(report.OnlyInPrimary)
+	sortSecretReferences(report.OnlyInSecondary)
+	sortSecretReferences(report.Different)
+	return report, nil
+}
+
+func keyReference(key Key) SecretReference {
+	return SecretReference{OrgId: key.OrgId, Namespace: key.Namespace, Type: key.Type}
+}
+
+// findMirroredKVStore returns the mirrored store wrapped by store, if any
+func findMirroredKVStore(store SecretsKVStore) (*mirroredKVStore, bool) {
+	if audited, ok := store.(*auditedKVStore); ok {
+		store = audited.store
+	}
+	if validating, ok := store.(*validatingKVStore); ok {
+		store = validating.SecretsKVStore
+	}
+	if cached, ok := store.(*CachedKVStore); ok {
+		store = cached.GetUnwrappedStore()
+	}
+	mirrored, ok := store.(*mirroredKVStore)
+	return mirrored, ok
+}
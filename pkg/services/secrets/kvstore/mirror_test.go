@@ -0,0 +1,131 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+// failingSetKVStore fails the Set calls while fail is set
+type failingSetKVStore struct {
+	SecretsKVStore
+	fail bool
+}
+
+func (kv *failingSetKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	if kv.fail {
+		return errors.New("set failed")
+	}
+	return kv.SecretsKVStore.Set(ctx, orgId, namespace, typ, value)
+}
+
+func TestSecretsKVStoreMirror(t *testing.T) {
+	ctx := context.Background()
+	sqlStore := setupTestService(t)
+	backendStore := setupPluginStore(t, &fakeBatchGRPCSecretsPlugin{fakeMemoryGRPCSecretsPlugin: newFakeMemoryGRPCSecretsPlugin()})
+	backendStore.capabilities = &pluginCapabilities{capabilities: map[string]bool{pluginCapabilityBatch: true, pluginCapabilityStreaming: true}}
+	secondary := &failingSetKVStore{SecretsKVStore: backendStore}
+
+	mirrorCfg := func(t *testing.T, primary string) *setting.Cfg {
+		t.Helper()
+		raw, err := ini.Load([]byte(`
+		[secrets]
+		mirror = true
+		mirror_primary = ` + primary))
+		require.NoError(t, err)
+		return &setting.Cfg{Raw: raw}
+	}
+
+	t.Run("is disabled by default", func(t *testing.T) {
+		store := withMirror(sqlStore, secondary, BackendPlugin, setting.NewCfg(), log.NewNopLogger())
+		assert.Equal(t, secondary, store)
+	})
+
+	t.Run("reads from the primary store selected by the configuration", func(t *testing.T) {
+		store := withMirror(sqlStore, secondary, BackendPlugin, mirrorCfg(t, MirrorPrimaryBackend), log.NewNopLogger())
+		mirrored, ok := store.(*mirroredKVStore)
+		require.True(t, ok)
+		assert.Equal(t, BackendPlugin, mirrored.primaryBackend)
+		assert.Equal(t, secondary, mirrored.primary)
+		assert.Equal(t, BackendSQL, mirrored.secondaryBackend)
+	})
+
+	store := withMirror(sqlStore, secondary, BackendPlugin, mirrorCfg(t, MirrorPrimarySQL), log.NewNopLogger())
+	mirrored, ok := store.(*mirroredKVStore)
+	require.True(t, ok)
+
+	t.Run("applies the writes to both stores", func(t *testing.T) {
+		require.NoError(t, store.Set(ctx, 1, "ds1", "datasource", "one"))
+		require.NoError(t, store.Set(ctx, 1, "ds2", "datasource", "two"))
+		require.NoError(t, store.Rename(ctx, 1, "ds2", "datasource", "ds3"))
+		require.NoError(t, store.Del(ctx, 1, "ds1", "datasource"))
+
+		for _, kv := range []SecretsKVStore{sqlStore, backendStore} {
+			_, found, err := kv.Get(ctx, 1, "ds1", "datasource")
+			require.NoError(t, err)
+			assert.False(t, found)
+			value, found, err := kv.Get(ctx, 1, "ds3", "datasource")
+			require.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, "two", value)
+		}
+
+		report, err := mirrored.Divergence(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Compared)
+		assert.Empty(t, report.OnlyInPrimary)
+		assert.Empty(t, report.OnlyInSecondary)
+		assert.Empty(t, report.Different)
+	})
+
+	t.Run("does not fail when the secondary store fails", func(t *testing.T) {
+		secondary.fail = true
+		t.Cleanup(func() { secondary.fail = false })
+
+		require.NoError(t, store.Set(ctx, 1, "ds3", "datasource", "three"))
+		require.NoError(t, store.Set(ctx, 2, "ds4", "datasource", "four"))
+		require.NoError(t, backendStore.Set(ctx, 3, "ds5", "datasource", "five"))
+
+		value, found, err := store.Get(ctx, 1, "ds3", "datasource")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "three", value)
+
+		report, err := mirrored.Divergence(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, BackendSQL, report.Primary)
+		assert.Equal(t, BackendPlugin, report.Secondary)
+		assert.Equal(t, 1, report.Compared)
+		assert.Equal(t, []SecretReference{{OrgId: 2, Namespace: "ds4", Type: "datasource"}}, report.OnlyInPrimary)
+		assert.Equal(t, []SecretReference{{OrgId: 3, Namespace: "ds5", Type: "datasource"}}, report.OnlyInSecondary)
+		assert.Equal(t, []SecretReference{{OrgId: 1, Namespace: "ds3", Type: "datasource"}}, report.Different)
+	})
+
+	t.Run("fails when the primary store fails", func(t *testing.T) {
+		failing := &mirroredKVStore{primary: secondary, secondary: sqlStore, log: log.NewNopLogger()}
+		secondary.fail = true
+		t.Cleanup(func() { secondary.fail = false })
+
+		require.Error(t, failing.Set(ctx, 1, "ds6", "datasource", "six"))
+		_, found, err := sqlStore.Get(ctx, 1, "ds6", "datasource")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("divergence is reported through the wrappers of the store", func(t *testing.T) {
+		consistencyService := ProvideSecretsConsistencyService(sqlStore.sqlStore, NewCachedKVStore(instrument(store, BackendPlugin, setting.NewCfg(), log.NewNopLogger()), 0, 0))
+		report, err := consistencyService.CheckMirror(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, BackendSQL, report.Primary)
+
+		consistencyService = ProvideSecretsConsistencyService(sqlStore.sqlStore, NewCachedKVStore(sqlStore, 0, 0))
+		_, err = consistencyService.CheckMirror(ctx)
+		assert.ErrorIs(t, err, ErrSecretsMirrorDisabled)
+	})
+}
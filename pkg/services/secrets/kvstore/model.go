@@ -1,12 +1,14 @@
 package kvstore
 
 import (
+	"errors"
 	"time"
 )
 
 const (
 	QuitOnPluginStartupFailureKey = "quit_on_secrets_plugin_startup_failure"
 	PluginNamespace               = "secretsmanagerplugin"
+	PluginMigrationCheckpointKey  = "plugin_migration_checkpoint"
 )
 
 // Item stored in k/v store.
@@ -16,6 +18,11 @@ type Item struct {
 	Namespace *string
 	Type      *string
 	Value     string
+	// Expires is when the item expires, never when nil
+	Expires *time.Time
+	// Scope is the scope of the data key encrypting the value in the sql store, empty for the values encrypted
+	// before the data keys were scoped to the organization of the item
+	Scope string
 
 	Created time.Time
 	Updated time.Time
@@ -25,6 +32,54 @@ func (i *Item) TableName() string {
 	return "secrets"
 }
 
+// ItemVersion is a previous value of an item, kept by the sql store when the item is updated.
+type ItemVersion struct {
+	Id        int64
+	OrgId     *int64
+	Namespace *string
+	Type      *string
+	Version   int64
+	Value     string
+
+	Created time.Time
+}
+
+func (i *ItemVersion) TableName() string {
+	return "secrets_history"
+}
+
+// PluginMigratedSecret marks a secret of the sql store that was set in the plugin and verified by the plugin
+// migration. The secret, and its mark, are deleted from the sql store once its whole batch is migrated.
+type PluginMigratedSecret struct {
+	Id        int64
+	SecretId  int64
+	OrgId     int64
+	Namespace string
+	Type      string
+	Migrated  time.Time
+}
+
+func (m *PluginMigratedSecret) TableName() string {
+	return "secrets_plugin_migration"
+}
+
+// SecretVersion describes a previous value of a secret, Created is when that value was set.
+type SecretVersion struct {
+	Version int64     `json:"version"`
+	Created time.Time `json:"created"`
+}
+
+var (
+	ErrVersioningNotSupported = errors.New("secrets store does not keep the previous versions of secrets")
+	ErrSecretVersionNotFound  = errors.New("secret version not found")
+	ErrTTLNotSupported        = errors.New("secrets store does not support secrets with a ttl")
+	ErrInvalidTTL             = errors.New("secret ttl must be positive")
+	ErrKeyListingNotSupported = errors.New("secrets store can only list the keys of a given namespace and type")
+	// ErrSecretChangedWhileRenamed is returned when a secret is updated while its values are encrypted again for
+	// the namespace it is renamed to
+	ErrSecretChangedWhileRenamed = errors.New("secret changed while it was renamed, rename it again")
+)
+
 type Key struct {
 	OrgId     int64
 	Namespace string
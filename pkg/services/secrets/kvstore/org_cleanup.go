@@ -0,0 +1,98 @@
+package kvstore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+)
+
+const (
+	// orgSecretsCleanupInterval is how often the cleanups of deleted organizations that failed are retried
+	orgSecretsCleanupInterval = 10 * time.Minute
+	// orgSecretsCleanupNamespace holds the organizations whose secrets are still to be deleted
+	orgSecretsCleanupNamespace = "secrets_org_cleanup"
+)
+
+// OrgSecretsCleanupService deletes the secrets of the organizations that are deleted. The secrets of the sql store
+// are deleted with the organization, this deletes the ones of the secrets manager plugin and of the other backends.
+// The organizations are recorded in the kvstore until their secrets are deleted, so that the cleanups that failed,
+// e.g. because the backend was unavailable, are retried.
+type OrgSecretsCleanupService struct {
+	store   SecretsKVStore
+	pending *kvstore.NamespacedKVStore
+	log     log.Logger
+}
+
+func ProvideOrgSecretsCleanupService(bus bus.Bus, store SecretsKVStore, kv kvstore.KVStore) *OrgSecretsCleanupService {
+	s := &OrgSecretsCleanupService{
+		store:   store,
+		pending: kvstore.WithNamespace(kv, kvstore.AllOrganizations, orgSecretsCleanupNamespace),
+		log:     log.New("secrets.kvstore.org-cleanup"),
+	}
+	bus.AddEventListener(s.handleOrgDeleted)
+	return s
+}
+
+func (s *OrgSecretsCleanupService) Run(ctx context.Context) error {
+	ticker := time.NewTicker(orgSecretsCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.retry(ctx)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *OrgSecretsCleanupService) handleOrgDeleted(ctx context.Context, e *events.OrgDeleted) error {
+	key := strconv.FormatInt(e.Id, 10)
+	if err := s.pending.Set(ctx, key, e.Timestamp.UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	if err := s.cleanup(ctx, e.Id); err != nil {
+		s.log.Warn("failed to delete the secrets of the deleted organization, the cleanup will be retried", "orgId", e.Id, "error", err)
+	}
+	return nil
+}
+
+// retry deletes the secrets of the organizations whose cleanup failed
+func (s *OrgSecretsCleanupService) retry(ctx context.Context) {
+	keys, err := s.pending.Keys(ctx, "")
+	if err != nil {
+		s.log.Error("failed to list the deleted organizations with secrets", "error", err)
+		return
+	}
+	for _, key := range keys {
+		orgID, err := strconv.ParseInt(key.Key, 10, 64)
+		if err != nil {
+			s.log.Warn("invalid deleted organization", "key", key.Key)
+			continue
+		}
+		if err := s.cleanup(ctx, orgID); err != nil {
+			s.log.Error("failed to delete the secrets of the deleted organization", "orgId", orgID, "error", err)
+		}
+	}
+}
+
+// cleanup deletes the secrets of the organization, then forgets the organization
+func (s *OrgSecretsCleanupService) cleanup(ctx context.Context, orgID int64) error {
+	keys, err := s.store.ListKeys(ctx, orgID, "", "")
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		if err := s.store.DelMultiple(ctx, keys); err != nil {
+			return err
+		}
+		s.log.Info("deleted the secrets of the deleted organization", "orgId", orgID, "count", len(keys))
+	}
+	return s.pending.Del(ctx, strconv.FormatInt(orgID, 10))
+}
@@ -0,0 +1,63 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/tracing"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgSecretsCleanupService(t *testing.T) {
+	ctx := context.Background()
+	b := bus.ProvideBus(tracing.InitializeTracerForTest())
+	store := &failingDelMultipleKVStore{FakeSecretsKVStore: NewFakeSecretsKVStore(), err: errors.New("backend unavailable")}
+	svc := ProvideOrgSecretsCleanupService(b, store, kvstore.ProvideService(sqlstore.InitTestDB(t)))
+
+	require.NoError(t, store.Set(ctx, 1, "ds1", "datasource", "kept"))
+	require.NoError(t, store.Set(ctx, 2, "ds1", "datasource", "deleted"))
+	require.NoError(t, store.Set(ctx, 2, "plugin-a", "plugin", "deleted"))
+
+	t.Run("retries the cleanup that failed", func(t *testing.T) {
+		require.NoError(t, b.Publish(ctx, &events.OrgDeleted{Timestamp: time.Now(), Id: 2}))
+		assert.Len(t, store.store, 3)
+		keys, err := svc.pending.Keys(ctx, "")
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, "2", keys[0].Key)
+
+		store.err = nil
+		svc.retry(ctx)
+		assert.Equal(t, map[Key]string{buildKey(1, "ds1", "datasource"): "kept"}, store.store)
+		keys, err = svc.pending.Keys(ctx, "")
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("deletes the secrets of the deleted organization", func(t *testing.T) {
+		require.NoError(t, b.Publish(ctx, &events.OrgDeleted{Timestamp: time.Now(), Id: 1}))
+		assert.Empty(t, store.store)
+		keys, err := svc.pending.Keys(ctx, "")
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+}
+
+type failingDelMultipleKVStore struct {
+	FakeSecretsKVStore
+	err error
+}
+
+func (f *failingDelMultipleKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.FakeSecretsKVStore.DelMultiple(ctx, keys)
+}
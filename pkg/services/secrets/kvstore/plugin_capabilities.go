@@ -0,0 +1,89 @@
+package kvstore
+
+import (
+	"context"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	smp "github.com/grafana/grafana/pkg/plugins/backendplugin/secretsmanagerplugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pluginProtocolVersion is the version of the secrets plugin protocol implemented by Grafana. Plugins answer
+// GetCapabilities with the version they speak, which is at most this one.
+const pluginProtocolVersion = 2
+
+// Optional calls of the secrets plugin protocol, a plugin that doesn't advertise one of them gets the calls it
+// falls back to
+const (
+	// SetSecrets and DeleteSecrets, or one call per secret
+	pluginCapabilityBatch = "batch"
+	// StreamAllSecrets, or GetAllSecrets
+	pluginCapabilityStreaming = "streaming"
+	// RenameSecret, or getting, setting and deleting the secret
+	pluginCapabilityRename = "rename"
+	// ListSecretKeys, or ListSecrets and GetAllSecrets
+	pluginCapabilityListKeys = "list_keys"
+)
+
+// pluginCapabilities are the protocol version and optional calls negotiated with the secrets plugin. They are nil
+// for the plugins built before the negotiation: their optional calls are tried, and fall back when the plugin
+// doesn't implement them.
+type pluginCapabilities struct {
+	protocolVersion int32
+	capabilities    map[string]bool
+}
+
+// negotiatePluginCapabilities asks the plugin for the optional calls it implements, it returns nil when they are
+// unknown
+func negotiatePluginCapabilities(ctx context.Context, secretsPlugin smp.SecretsManagerPlugin, logger log.Logger) *pluginCapabilities {
+	res, err := secretsPlugin.GetCapabilities(ctx, &smp.GetCapabilitiesRequest{ProtocolVersion: pluginProtocolVersion})
+	if status.Code(err) == codes.Unimplemented {
+		logger.Debug("secrets manager plugin does not negotiate its capabilities, its optional calls are tried until they are not implemented")
+		return nil
+	}
+	if err != nil {
+		logger.Warn("failed to negotiate the capabilities of the secrets manager plugin, its optional calls are tried until they are not implemented", "error", err)
+		return nil
+	}
+
+	c := &pluginCapabilities{protocolVersion: res.ProtocolVersion, capabilities: make(map[string]bool, len(res.Capabilities))}
+	for _, capability := range res.Capabilities {
+		c.capabilities[capability] = true
+	}
+	if c.protocolVersion > pluginProtocolVersion {
+		logger.Warn("secrets manager plugin speaks a newer protocol than Grafana, only the capabilities Grafana knows are used",
+			"pluginProtocolVersion", c.protocolVersion, "protocolVersion", pluginProtocolVersion)
+	}
+	logger.Info("negotiated the capabilities of the secrets manager plugin", "protocolVersion", c.protocolVersion, "capabilities", c.list())
+	return c
+}
+
+// supports returns whether the plugin implements the optional call, which is assumed when the capabilities are
+// unknown
+func (c *pluginCapabilities) supports(capability string) bool {
+	return c == nil || c.capabilities[capability]
+}
+
+func (c *pluginCapabilities) list() []string {
+	list := make([]string, 0, len(c.capabilities))
+	for capability := range c.capabilities {
+		list = append(list, capability)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// supports returns whether the plugin of the store implements the optional call
+func (kv *secretsKVStorePlugin) supports(capability string) bool {
+	kv.capabilitiesMu.RLock()
+	defer kv.capabilitiesMu.RUnlock()
+	return kv.capabilities.supports(capability)
+}
+
+func (kv *secretsKVStorePlugin) setCapabilities(capabilities *pluginCapabilities) {
+	kv.capabilitiesMu.Lock()
+	defer kv.capabilitiesMu.Unlock()
+	kv.capabilities = capabilities
+}
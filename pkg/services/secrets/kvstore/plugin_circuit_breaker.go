@@ -0,0 +1,225 @@
+package kvstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/datasources"
+	"github.com/grafana/grafana/pkg/setting"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPluginCircuitBreakerFailures     = 5
+	defaultPluginCircuitBreakerOpenDuration = 30 * time.Second
+	defaultPluginReadCacheTTL               = 5 * time.Minute
+)
+
+var ErrSecretsPluginUnavailable = errors.New("secrets manager plugin is unavailable")
+
+// pluginCircuitBreaker stops calling the secrets plugin after failures consecutive failed calls. Once open, the
+// breaker lets a single call through every openDuration, and closes again when that call succeeds.
+type pluginCircuitBreaker struct {
+	mu           sync.Mutex
+	failures     int
+	openDuration time.Duration
+	consecutive  int
+	open         bool
+	// when the breaker opened, or when the last call was let through while it is open
+	lastAttempt time.Time
+	log         log.Logger
+}
+
+// newPluginCircuitBreakerFromConfig returns nil, which never opens, when plugin_circuit_breaker_failures is 0
+func newPluginCircuitBreakerFromConfig(cfg *setting.Cfg, logger log.Logger) *pluginCircuitBreaker {
+	section := cfg.SectionWithEnvOverrides("secrets")
+	failures := section.Key("plugin_circuit_breaker_failures").MustInt(defaultPluginCircuitBreakerFailures)
+	if failures <= 0 {
+		return nil
+	}
+	return &pluginCircuitBreaker{
+		failures:     failures,
+		openDuration: section.Key("plugin_circuit_breaker_open_duration").MustDuration(defaultPluginCircuitBreakerOpenDuration),
+		log:          logger,
+	}
+}
+
+// allow returns whether the plugin can be called
+func (b *pluginCircuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return true
+	}
+	if time.Since(b.lastAttempt) < b.openDuration {
+		return false
+	}
+	b.lastAttempt = time.Now()
+	return true
+}
+
+// record counts the result of a call to the plugin, only unavailability errors are failures
+func (b *pluginCircuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !isPluginUnavailable(err) {
+		b.consecutive = 0
+		if b.open {
+			b.open = false
+			pluginCircuitBreakerOpenGauge.Set(0)
+			b.log.Info("secrets manager plugin is available again, closing the circuit breaker")
+		}
+		return
+	}
+
+	b.consecutive++
+	if !b.open && b.consecutive >= b.failures {
+		b.open = true
+		pluginCircuitBreakerOpenGauge.Set(1)
+		pluginCircuitBreakerTripsCounter.Inc()
+		b.log.Error("secrets manager plugin is unavailable, opening the circuit breaker", "failures", b.consecutive, "error", err)
+	}
+	if b.open {
+		b.lastAttempt = time.Now()
+	}
+}
+
+func (b *pluginCircuitBreaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+func isPluginUnavailable(err error) bool {
+	var userFriendly datasources.ErrDatasourceSecretsPluginUserFriendly
+	if err == nil || errors.As(err, &userFriendly) {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Unknown, codes.Internal, codes.Aborted, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// pluginReadCache keeps the secrets read from the plugin for ttl, to read them while the circuit breaker is open.
+// The values are encrypted in memory with a random key of the process.
+type pluginReadCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	aead    cipher.AEAD
+	entries map[Key]pluginCachedSecret
+}
+
+type pluginCachedSecret struct {
+	nonce     []byte
+	encrypted []byte
+	exists    bool
+	expires   time.Time
+}
+
+// newPluginReadCacheFromConfig returns nil, which caches nothing, when plugin_read_cache_ttl is 0
+func newPluginReadCacheFromConfig(cfg *setting.Cfg) (*pluginReadCache, error) {
+	ttl := cfg.SectionWithEnvOverrides("secrets").Key("plugin_read_cache_ttl").MustDuration(defaultPluginReadCacheTTL)
+	if ttl <= 0 {
+		return nil, nil
+	}
+	return newPluginReadCache(ttl)
+}
+
+func newPluginReadCache(ttl time.Duration) (*pluginReadCache, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginReadCache{ttl: ttl, aead: aead, entries: make(map[Key]pluginCachedSecret)}, nil
+}
+
+// get returns the cached value of the secret, whether the secret exists, and whether it was cached
+func (c *pluginReadCache) get(key Key) (string, bool, bool) {
+	if c == nil {
+		return "", false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false, false
+	}
+	if !time.Now().Before(entry.expires) {
+		delete(c.entries, key)
+		return "", false, false
+	}
+	value, err := c.aead.Open(nil, entry.nonce, entry.encrypted, nil)
+	if err != nil {
+		delete(c.entries, key)
+		return "", false, false
+	}
+	return string(value), entry.exists, true
+}
+
+func (c *pluginReadCache) set(key Key, value string, exists bool) {
+	if c == nil {
+		return
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return
+	}
+	entry := pluginCachedSecret{
+		nonce:     nonce,
+		encrypted: c.aead.Seal(nil, nonce, []byte(value), nil),
+		exists:    exists,
+		expires:   time.Now().Add(c.ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+	// expired entries are removed when the cache grows, so that secrets that are not read again don't stay in memory
+	if len(c.entries)%100 == 0 {
+		now := time.Now()
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+}
+
+func (c *pluginReadCache) delete(keys ...Key) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+}
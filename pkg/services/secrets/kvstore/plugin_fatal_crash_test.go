@@ -6,6 +6,7 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/benbjohnson/clock"
 	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/services/secrets/fakes"
 	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
@@ -96,7 +97,7 @@ func setupFatalCrashTest(
 	}
 	features := NewFakeFeatureToggles(t, isBackwardsCompatDisabled)
 	manager := NewFakeSecretsPluginManager(t, shouldFailOnStart)
-	svc, err := ProvideService(sqlStore, secretService, manager, kvstore, features, cfg)
+	svc, err := ProvideService(sqlStore, secretService, manager, kvstore, features, cfg, nil, nil, nil, nil, nil, nil, nil, nil, clock.New())
 	t.Cleanup(func() {
 		fatalFlagOnce = sync.Once{}
 	})
@@ -135,6 +136,8 @@ func setupTestMigratorServiceWithDeletionError(
 		secretsService,
 		kvstore,
 		manager,
+		nil,
+		nil,
 	)
 	// TODO refactor Migrator to allow us to override the entire sqlstore with a mock instead
 	migratorService.overrideGetAllFunc(getAllFuncOverride)
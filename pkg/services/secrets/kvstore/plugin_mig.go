@@ -2,8 +2,14 @@ package kvstore
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/plugins"
@@ -12,6 +18,37 @@ import (
 	"github.com/grafana/grafana/pkg/setting"
 )
 
+var (
+	// number of times, and delay before the first time, the migration retries to read the sql store
+	pluginMigrationRetries    = 3
+	pluginMigrationRetryDelay = time.Second
+)
+
+// PluginMigrationError lists the secrets that were kept in the sql store when migrating to the plugin
+type PluginMigrationError struct {
+	Undecryptable []Key
+	Unverified    []Key
+}
+
+func (e *PluginMigrationError) Error() string {
+	var reasons []string
+	if len(e.Undecryptable) > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d secrets could not be decrypted: %s", len(e.Undecryptable), formatKeys(e.Undecryptable)))
+	}
+	if len(e.Unverified) > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d secrets could not be verified after the migration: %s", len(e.Unverified), formatKeys(e.Unverified)))
+	}
+	return "secrets were kept in the sql store, " + strings.Join(reasons, ", ")
+}
+
+func formatKeys(keys []Key) string {
+	formatted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		formatted = append(formatted, fmt.Sprintf("{orgId: %d, namespace: %q, type: %q}", key.OrgId, key.Namespace, key.Type))
+	}
+	return strings.Join(formatted, ", ")
+}
+
 // PluginSecretMigrationService This migrator will handle migration of datasource secrets (aka Unified secrets)
 // into the plugin secrets configured
 type PluginSecretMigrationService struct {
@@ -23,6 +60,8 @@ type PluginSecretMigrationService struct {
 	kvstore        kvstore.KVStore
 	manager        plugins.SecretsPluginManager
 	getAllFunc     func(ctx context.Context) ([]Item, error)
+	progress       *pluginMigrationProgress
+	audit          *AuditService
 }
 
 func ProvidePluginSecretMigrationService(
@@ -32,8 +71,10 @@ func ProvidePluginSecretMigrationService(
 	secretsService secrets.Service,
 	kvstore kvstore.KVStore,
 	manager plugins.SecretsPluginManager,
+	audit *AuditService,
+	health *HealthService,
 ) *PluginSecretMigrationService {
-	return &PluginSecretMigrationService{
+	s := &PluginSecretMigrationService{
 		secretsStore:   secretsStore,
 		cfg:            cfg,
 		logger:         log.New("sec-plugin-mig"),
@@ -41,71 +82,335 @@ func ProvidePluginSecretMigrationService(
 		secretsService: secretsService,
 		kvstore:        kvstore,
 		manager:        manager,
+		progress:       newPluginMigrationProgress(),
+		audit:          audit,
 	}
+	health.registerMigration(s.Status)
+	return s
+}
+
+// Status returns the progress of the current, or last, migration run by this instance
+func (s *PluginSecretMigrationService) Status() PluginMigrationStatus {
+	return s.progress.get()
 }
 
 func (s *PluginSecretMigrationService) Migrate(ctx context.Context) error {
+	if isSecretsMirrorEnabled(s.cfg) {
+		s.logger.Debug("secrets are mirrored between the sql store and the plugin, they are not migrated")
+		return nil
+	}
 	// Check if we should migrate to plugin - default false
-	if err := EvaluateRemoteSecretsPlugin(s.manager, s.cfg); err == nil {
-		s.logger.Debug("starting migration of unified secrets to the plugin")
-		// we need to instantiate the secretsKVStore as this is not on wire, and in this scenario,
-		// the secrets store would be the plugin.
-		secretsSql := &secretsKVStoreSQL{
-			sqlStore:       s.sqlStore,
-			secretsService: s.secretsService,
-			log:            s.logger,
-			decryptionCache: decryptionCache{
-				cache: make(map[int64]cachedDecrypted),
-			},
-			GetAllFuncOverride: s.getAllFunc,
-		}
+	err := EvaluateRemoteSecretsPlugin(s.manager, s.cfg)
+	if errors.Is(err, errPluginDisabledByConfig) && s.manager.SecretsManager() != nil {
+		// the plugin is installed but disabled, secrets it may have are migrated back
+		return s.MigrateBack(ctx)
+	}
+	if err == nil {
+		return s.migrateToPlugin(ctx)
+	}
+	return nil
+}
+
+// migrateToPlugin migrates the unified secrets to the plugin in batches. Each secret of a batch is set in the plugin,
+// read back to verify it, and marked as migrated in the sql store. Once the whole batch is migrated, the marked
+// secrets are deleted from the sql store in a second pass. The id of the last secret of the batch is persisted as a
+// checkpoint, so that a migration that stopped midway resumes after the last batch, and deletes first the secrets it
+// had migrated but not deleted.
+func (s *PluginSecretMigrationService) migrateToPlugin(ctx context.Context) (err error) {
+	s.progress.start(PluginMigrationToPlugin)
+	defer func() {
+		s.progress.finish(err)
+	}()
 
-		// before we start migrating, check see if plugin startup failures were already fatal
-		namespacedKVStore := GetNamespacedKVStore(s.kvstore)
-		wasFatal, err := isPluginStartupErrorFatal(ctx, namespacedKVStore)
+	s.logger.Debug("starting migration of unified secrets to the plugin")
+	// we need to instantiate the secretsKVStore as this is not on wire, and in this scenario,
+	// the secrets store would be the plugin.
+	secretsSql := &secretsKVStoreSQL{
+		sqlStore:           s.sqlStore,
+		secretsService:     s.secretsService,
+		log:                s.logger,
+		decryptionCache:    newDecryptionCacheFromConfig(s.cfg),
+		GetAllFuncOverride: s.getAllFunc,
+		clock:              clock.New(),
+	}
+
+	// before we start migrating, check see if plugin startup failures were already fatal
+	namespacedKVStore := GetNamespacedKVStore(s.kvstore)
+	wasFatal, err := isPluginStartupErrorFatal(ctx, namespacedKVStore)
+	if err != nil {
+		s.logger.Warn("unable to determine whether plugin startup failures are fatal - continuing migration anyway.")
+	}
+
+	checkpoint, resumed, err := getPluginMigrationCheckpoint(ctx, namespacedKVStore)
+	if err != nil {
+		return err
+	}
+	if resumed {
+		s.logger.Info("resuming migration of unified secrets to the plugin", "checkpoint", checkpoint)
+	}
+	var deleted int
+	err = s.withRetries(ctx, "delete migrated unified secrets", func() error {
+		deleted, err = secretsSql.deleteMigrated(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		s.logger.Info("deleted the unified secrets migrated to the plugin by the previous migration", "number of secrets", deleted)
+	}
+	var total int64
+	err = s.withRetries(ctx, "count unified secrets", func() error {
+		total, err = secretsSql.Count(ctx, checkpoint)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.progress.setTotal(int(total))
+
+	batchSize := s.cfg.SectionWithEnvOverrides("secrets").Key("migration_batch_size").MustInt(100)
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	totalSec := 0
+	migrationErr := &PluginMigrationError{}
+	for {
+		var batch, undecryptable []Item
+		err := s.withRetries(ctx, "get unified secrets", func() error {
+			var err error
+			batch, undecryptable, err = secretsSql.GetBatch(ctx, checkpoint, batchSize)
+			return err
+		})
+		s.audit.Record(ctx, AuditOperationGetAll, AllOrganizations, "", "", true, err)
 		if err != nil {
-			s.logger.Warn("unable to determine whether plugin startup failures are fatal - continuing migration anyway.")
+			return err
+		}
+		if len(batch) == 0 && len(undecryptable) == 0 {
+			break
 		}
 
-		allSec, err := secretsSql.GetAll(ctx)
-		if err != nil {
-			return nil
+		// secrets that can't be decrypted are kept in the sql store, migrating them would lose their value
+		for _, sec := range undecryptable {
+			s.logger.Error("secret could not be decrypted, keeping it in the sql store",
+				"orgId", *sec.OrgId, "namespace", *sec.Namespace, "type", *sec.Type)
+			migrationErr.Undecryptable = append(migrationErr.Undecryptable, itemKey(sec))
+			s.progress.addFailed(1)
 		}
-		totalSec := len(allSec)
-		// We just set it again as the current secret store should be the plugin secret
-		s.logger.Debug(fmt.Sprintf("Total amount of secrets to migrate: %d", totalSec))
-		for i, sec := range allSec {
-			s.logger.Debug(fmt.Sprintf("Migrating secret %d of %d", i+1, totalSec), "current", i+1, "secretCount", totalSec)
-			err = s.secretsStore.Set(ctx, *sec.OrgId, *sec.Namespace, *sec.Type, sec.Value)
+
+		// We just set it again as the current secret store should be the plugin secret. The secrets that fail
+		// verification are not marked: they are kept in the sql store and migrated again on the next run
+		s.logger.Debug(fmt.Sprintf("Migrating batch of %d secrets", len(batch)), "checkpoint", checkpoint, "migrated", totalSec)
+		for _, sec := range batch {
+			ok, err := s.migrateSecret(ctx, secretsSql, sec)
 			if err != nil {
 				return err
 			}
+			if !ok {
+				s.logger.Error("secret read back from the plugin does not match, keeping it in the sql store",
+					"orgId", *sec.OrgId, "namespace", *sec.Namespace, "type", *sec.Type)
+				migrationErr.Unverified = append(migrationErr.Unverified, itemKey(sec))
+				s.progress.addFailed(1)
+			}
 		}
-		s.logger.Debug("migrated unified secrets to plugin", "number of secrets", totalSec)
-		// as no err was returned, when we delete all the secrets from the sql store
-		for index, sec := range allSec {
-			s.logger.Debug(fmt.Sprintf("Cleaning secret %d of %d", index+1, totalSec), "current", index+1, "secretCount", totalSec)
 
-			err = secretsSql.Del(ctx, *sec.OrgId, *sec.Namespace, *sec.Type)
-			if err != nil {
-				s.logger.Error("plugin migrator encountered error while deleting unified secrets")
-				if index == 0 && !wasFatal {
-					// old unified secrets still exists, so plugin startup errors are still not fatal, unless they were before we started
-					err := setPluginStartupErrorFatal(ctx, namespacedKVStore, false)
-					if err != nil {
-						s.logger.Error("error reverting plugin failure fatal status", "error", err.Error())
-					} else {
-						s.logger.Debug("application will continue to function without the secrets plugin")
-					}
+		// the whole batch is migrated, the marked secrets are deleted from the sql store
+		deleted, err := secretsSql.deleteMigrated(ctx)
+		if err != nil {
+			s.logger.Error("plugin migrator encountered error while deleting unified secrets")
+			if totalSec == 0 && !resumed && !wasFatal {
+				// old unified secrets still exists, so plugin startup errors are still not fatal, unless they were before we started
+				err := setPluginStartupErrorFatal(ctx, namespacedKVStore, false)
+				if err != nil {
+					s.logger.Error("error reverting plugin failure fatal status", "error", err.Error())
+				} else {
+					s.logger.Debug("application will continue to function without the secrets plugin")
 				}
-				return err
+			}
+			return err
+		}
+		totalSec += deleted
+		s.progress.addMigrated(deleted)
+
+		checkpoint = lastItemId(batch, undecryptable)
+		if err := setPluginMigrationCheckpoint(ctx, namespacedKVStore, checkpoint); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Debug("migrated unified secrets to plugin", "number of secrets", totalSec)
+	// the migration is complete, the next one starts from the beginning
+	if err := namespacedKVStore.Del(ctx, PluginMigrationCheckpointKey); err != nil {
+		return err
+	}
+	if len(migrationErr.Undecryptable) > 0 || len(migrationErr.Unverified) > 0 {
+		return migrationErr
+	}
+	return nil
+}
+
+// withRetries runs fn until it succeeds, retrying transient failures, e.g. a lost database connection, with an
+// exponential backoff
+func (s *PluginSecretMigrationService) withRetries(ctx context.Context, operation string, fn func() error) error {
+	delay := pluginMigrationRetryDelay
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil || attempt == pluginMigrationRetries {
+			break
+		}
+		s.logger.Warn("plugin migrator failed to "+operation+", retrying", "attempt", attempt+1, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s after %d attempts: %w", operation, pluginMigrationRetries+1, err)
+	}
+	return nil
+}
+
+// lastItemId returns the greatest id of the batch, which is the checkpoint of the migration once it is migrated
+func lastItemId(batches ...[]Item) int64 {
+	var id int64
+	for _, batch := range batches {
+		for _, item := range batch {
+			if item.Id > id {
+				id = item.Id
 			}
 		}
-		s.logger.Debug("deleted unified secrets after migration", "number of secrets", totalSec)
 	}
+	return id
+}
+
+func itemKey(item Item) Key {
+	return Key{OrgId: *item.OrgId, Namespace: *item.Namespace, Type: *item.Type}
+}
+
+// migrateSecret sets the secret in the plugin, verifies it and marks it as migrated in the sql store. It returns
+// false when the secret fails verification.
+func (s *PluginSecretMigrationService) migrateSecret(ctx context.Context, secretsSql *secretsKVStoreSQL, sec Item) (bool, error) {
+	if err := s.secretsStore.Set(ctx, *sec.OrgId, *sec.Namespace, *sec.Type, sec.Value); err != nil {
+		return false, err
+	}
+	ok, err := s.verifyMigratedSecret(ctx, sec)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, secretsSql.markMigrated(ctx, sec)
+}
+
+// verifyMigratedSecret reads the secret back from the plugin and compares the hash of its value with the one of
+// the value in the sql store.
+func (s *PluginSecretMigrationService) verifyMigratedSecret(ctx context.Context, sec Item) (bool, error) {
+	value, exists, err := s.secretsStore.Get(ctx, *sec.OrgId, *sec.Namespace, *sec.Type)
+	if err != nil || !exists {
+		return false, err
+	}
+	return sha256.Sum256([]byte(value)) == sha256.Sum256([]byte(sec.Value)), nil
+}
+
+// MigrateBack migrates the secrets stored in the plugin back to the sql store, where they are encrypted
+// with the secrets service. Secrets are streamed from the plugin in batches, and only deleted from the plugin once
+// they are all in the sql store.
+func (s *PluginSecretMigrationService) MigrateBack(ctx context.Context) (err error) {
+	s.progress.start(PluginMigrationBackToSQL)
+	defer func() {
+		s.progress.finish(err)
+	}()
+
+	secretsPlugin, err := startAndReturnPlugin(s.manager, ctx)
+	if err != nil {
+		return err
+	}
+	if secretsPlugin == nil {
+		return errPluginNotInstalled
+	}
+
+	namespacedKVStore := GetNamespacedKVStore(s.kvstore)
+	secretsPluginStore := &secretsKVStorePlugin{
+		secretsPlugin:  secretsPlugin,
+		secretsService: s.secretsService,
+		log:            s.logger,
+		kvstore:        namespacedKVStore,
+		capabilities:   negotiatePluginCapabilities(ctx, secretsPlugin, s.logger),
+	}
+	secretsSql := &secretsKVStoreSQL{
+		sqlStore:             s.sqlStore,
+		secretsService:       s.secretsService,
+		log:                  s.logger,
+		decryptionCache:      newDecryptionCacheFromConfig(s.cfg),
+		compressionThreshold: s.cfg.SectionWithEnvOverrides("secrets").Key("sql_compression_threshold").MustInt(0),
+		clock:                clock.New(),
+	}
+
+	// the secrets are set in the sql store as they are streamed by the plugin, only their keys are kept to delete
+	// them from the plugin once they are all in the sql store
+	s.logger.Debug("starting migration of plugin secrets back to unified secrets")
+	var keys []Key
+	var setErr error
+	err = secretsPluginStore.StreamAll(ctx, func(items []Item) error {
+		s.progress.setTotal(len(keys) + len(items))
+		if setErr = secretsSql.SetMultiple(ctx, items); setErr != nil {
+			s.progress.addFailed(len(items))
+			return setErr
+		}
+		s.progress.addMigrated(len(items))
+		for _, sec := range items {
+			keys = append(keys, itemKey(sec))
+		}
+		return nil
+	})
+	getAllErr := err
+	if setErr != nil {
+		getAllErr = nil
+	}
+	s.audit.Record(ctx, AuditOperationGetAll, AllOrganizations, "", "", true, getAllErr)
+	if err != nil {
+		return err
+	}
+	totalSec := len(keys)
+	if totalSec == 0 {
+		return nil
+	}
+	s.logger.Debug("migrated plugin secrets to unified secrets", "number of secrets", totalSec)
+
+	// all secrets are in the sql store again, so plugin startup errors are not fatal anymore
+	err = setPluginStartupErrorFatal(ctx, namespacedKVStore, false)
+	if err != nil {
+		s.logger.Error("error reverting plugin failure fatal status", "error", err.Error())
+		return err
+	}
+
+	// as no err was returned, when we delete all the secrets from the plugin
+	err = withPluginRetries(secretsPluginStore, s.cfg, s.logger).DelMultiple(ctx, keys)
+	if err != nil {
+		s.logger.Error("plugin migrator encountered error while deleting plugin secrets")
+		return err
+	}
+	s.logger.Debug("deleted plugin secrets after migration", "number of secrets", totalSec)
 	return nil
 }
 
+func getPluginMigrationCheckpoint(ctx context.Context, kvstore *kvstore.NamespacedKVStore) (int64, bool, error) {
+	value, exists, err := kvstore.Get(ctx, PluginMigrationCheckpointKey)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+	checkpoint, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid plugin migration checkpoint %q: %w", value, err)
+	}
+	return checkpoint, true, nil
+}
+
+func setPluginMigrationCheckpoint(ctx context.Context, kvstore *kvstore.NamespacedKVStore, checkpoint int64) error {
+	return kvstore.Set(ctx, PluginMigrationCheckpointKey, strconv.FormatInt(checkpoint, 10))
+}
+
 // This is here to support testing and should normally not be called
 // An edge case we are unit testing requires the GetAll function to return a value, but the Del function to return an error.
 // This is not possible with the code as written, so this override function is a workaround. Should be refactored.
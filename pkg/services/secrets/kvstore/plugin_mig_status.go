@@ -0,0 +1,119 @@
+package kvstore
+
+import (
+	"sync"
+	"time"
+)
+
+type PluginMigrationPhase string
+
+const (
+	PluginMigrationNotStarted PluginMigrationPhase = "not_started"
+	PluginMigrationToPlugin   PluginMigrationPhase = "migrating_to_plugin"
+	PluginMigrationBackToSQL  PluginMigrationPhase = "migrating_back_to_sql"
+	PluginMigrationCompleted  PluginMigrationPhase = "completed"
+	PluginMigrationFailed     PluginMigrationPhase = "failed"
+)
+
+// states of the secrets in the migration gauge
+const (
+	pluginMigrationStateTotal    = "total"
+	pluginMigrationStateMigrated = "migrated"
+	pluginMigrationStateFailed   = "failed"
+)
+
+var pluginMigrationPhases = []PluginMigrationPhase{
+	PluginMigrationNotStarted,
+	PluginMigrationToPlugin,
+	PluginMigrationBackToSQL,
+	PluginMigrationCompleted,
+	PluginMigrationFailed,
+}
+
+// PluginMigrationStatus is the progress of the current, or last, plugin secret migration run by this instance.
+// Total is the number of secrets left to migrate when the migration started, so it excludes the secrets
+// migrated before a resumed migration.
+type PluginMigrationStatus struct {
+	Phase      PluginMigrationPhase `json:"phase"`
+	Total      int                  `json:"total"`
+	Migrated   int                  `json:"migrated"`
+	Failed     int                  `json:"failed"`
+	StartedAt  *time.Time           `json:"startedAt,omitempty"`
+	FinishedAt *time.Time           `json:"finishedAt,omitempty"`
+	Error      string               `json:"error,omitempty"`
+}
+
+// pluginMigrationProgress tracks the status of the migration and mirrors it in the prometheus gauges
+type pluginMigrationProgress struct {
+	mu     sync.RWMutex
+	status PluginMigrationStatus
+}
+
+func newPluginMigrationProgress() *pluginMigrationProgress {
+	p := &pluginMigrationProgress{status: PluginMigrationStatus{Phase: PluginMigrationNotStarted}}
+	p.updateMetrics()
+	return p
+}
+
+func (p *pluginMigrationProgress) get() PluginMigrationStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.status
+}
+
+func (p *pluginMigrationProgress) start(phase PluginMigrationPhase) {
+	p.update(func(status *PluginMigrationStatus) {
+		now := time.Now()
+		*status = PluginMigrationStatus{Phase: phase, StartedAt: &now}
+	})
+}
+
+func (p *pluginMigrationProgress) setTotal(total int) {
+	p.update(func(status *PluginMigrationStatus) {
+		status.Total = total
+	})
+}
+
+func (p *pluginMigrationProgress) addMigrated(count int) {
+	p.update(func(status *PluginMigrationStatus) {
+		status.Migrated += count
+	})
+}
+
+func (p *pluginMigrationProgress) addFailed(count int) {
+	p.update(func(status *PluginMigrationStatus) {
+		status.Failed += count
+	})
+}
+
+func (p *pluginMigrationProgress) finish(err error) {
+	p.update(func(status *PluginMigrationStatus) {
+		now := time.Now()
+		status.FinishedAt = &now
+		status.Phase = PluginMigrationCompleted
+		if err != nil {
+			status.Phase = PluginMigrationFailed
+			status.Error = err.Error()
+		}
+	})
+}
+
+func (p *pluginMigrationProgress) update(fn func(status *PluginMigrationStatus)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn(&p.status)
+	p.updateMetrics()
+}
+
+func (p *pluginMigrationProgress) updateMetrics() {
+	pluginMigrationSecretsGauge.WithLabelValues(pluginMigrationStateTotal).Set(float64(p.status.Total))
+	pluginMigrationSecretsGauge.WithLabelValues(pluginMigrationStateMigrated).Set(float64(p.status.Migrated))
+	pluginMigrationSecretsGauge.WithLabelValues(pluginMigrationStateFailed).Set(float64(p.status.Failed))
+	for _, phase := range pluginMigrationPhases {
+		value := 0.0
+		if phase == p.status.Phase {
+			value = 1
+		}
+		pluginMigrationPhaseGauge.WithLabelValues(string(phase)).Set(value)
+	}
+}
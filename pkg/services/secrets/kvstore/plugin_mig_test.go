@@ -2,16 +2,24 @@ package kvstore
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/plugins/backendplugin/secretsmanagerplugin"
 	"github.com/grafana/grafana/pkg/services/secrets/fakes"
 	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gopkg.in/ini.v1"
 )
 
@@ -42,6 +50,205 @@ func TestPluginSecretMigrationService_Migrate(t *testing.T) {
 		validateSecretWasStoreInPlugin(t, secretsStore, ctx, orgId, namespace1, typ)
 		validateSecretWasStoreInPlugin(t, secretsStore, ctx, orgId, namespace1, typ)
 	})
+
+	t.Run("migration run in batches - checkpoint removed when done", func(t *testing.T) {
+		// --- SETUP
+		migratorService, secretsStore, sqlSecretStore := setupTestMigratorService(t)
+		migratorService.cfg.Raw.Section("secrets").Key("migration_batch_size").SetValue("2")
+		namespaces := []string{"namespace-1", "namespace-2", "namespace-3", "namespace-4", "namespace-5"}
+		for _, namespace := range namespaces {
+			addSecretToSqlStore(t, sqlSecretStore, ctx, 1, namespace, "type-test", "SUPER_SECRET")
+		}
+
+		// --- EXECUTION
+		err := migratorService.Migrate(ctx)
+		require.NoError(t, err)
+
+		// --- VALIDATIONS
+		for _, namespace := range namespaces {
+			validateSecretWasDeleted(t, sqlSecretStore, ctx, 1, namespace, "type-test")
+			validateSecretWasStoreInPlugin(t, secretsStore, ctx, 1, namespace, "type-test")
+		}
+		_, exists, err := GetNamespacedKVStore(migratorService.kvstore).Get(ctx, PluginMigrationCheckpointKey)
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		status := migratorService.Status()
+		require.Equal(t, PluginMigrationCompleted, status.Phase)
+		require.Equal(t, 5, status.Total)
+		require.Equal(t, 5, status.Migrated)
+		require.Equal(t, 0, status.Failed)
+		require.NotNil(t, status.FinishedAt)
+	})
+
+	t.Run("migration resumed from the checkpoint", func(t *testing.T) {
+		// --- SETUP
+		migratorService, secretsStore, sqlSecretStore := setupTestMigratorService(t)
+		addSecretToSqlStore(t, sqlSecretStore, ctx, 1, "namespace-migrated", "type-test", "SUPER_SECRET")
+		addSecretToSqlStore(t, sqlSecretStore, ctx, 1, "namespace-pending", "type-test", "SUPER_SECRET")
+		items, err := sqlSecretStore.GetAll(ctx)
+		require.NoError(t, err)
+		require.Len(t, items, 2)
+		// the first secret is left in the sql store as if the process stopped right before deleting it
+		require.NoError(t, secretsStore.Set(ctx, 1, "namespace-migrated", "type-test", "SUPER_SECRET"))
+		require.NoError(t, sqlSecretStore.markMigrated(ctx, items[0]))
+		require.NoError(t, setPluginMigrationCheckpoint(ctx, GetNamespacedKVStore(migratorService.kvstore), items[0].Id))
+
+		// --- EXECUTION
+		err = migratorService.Migrate(ctx)
+		require.NoError(t, err)
+
+		// --- VALIDATIONS
+		validateSecretWasDeleted(t, sqlSecretStore, ctx, 1, "namespace-pending", "type-test")
+		validateSecretWasStoreInPlugin(t, secretsStore, ctx, 1, "namespace-pending", "type-test")
+		validateSecretWasDeleted(t, sqlSecretStore, ctx, 1, "namespace-migrated", "type-test")
+		validateSecretWasStoreInPlugin(t, secretsStore, ctx, 1, "namespace-migrated", "type-test")
+		require.Equal(t, 1, migratorService.Status().Total)
+	})
+
+	t.Run("migration keeps the marked secrets that were recreated", func(t *testing.T) {
+		// --- SETUP
+		_, _, sqlSecretStore := setupTestMigratorService(t)
+		addSecretToSqlStore(t, sqlSecretStore, ctx, 1, "namespace-test", "type-test", "SUPER_SECRET")
+		items, err := sqlSecretStore.GetAll(ctx)
+		require.NoError(t, err)
+		require.NoError(t, sqlSecretStore.markMigrated(ctx, items[0]))
+		require.NoError(t, sqlSecretStore.Del(ctx, 1, "namespace-test", "type-test"))
+		addSecretToSqlStore(t, sqlSecretStore, ctx, 1, "namespace-test", "type-test", "NEW_SECRET")
+
+		// --- EXECUTION
+		deleted, err := sqlSecretStore.deleteMigrated(ctx)
+		require.NoError(t, err)
+
+		// --- VALIDATIONS
+		require.Zero(t, deleted)
+		res, err := sqlSecretStore.Keys(ctx, 1, "namespace-test", "type-test")
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+	})
+
+	t.Run("migration fails verification - only the unverified secret kept in sql", func(t *testing.T) {
+		// --- SETUP
+		migratorService, _, sqlSecretStore := setupTestMigratorService(t)
+		secretsStore := &fakeLossySecretsKVStore{SecretsKVStore: NewFakeSecretsKVStore(), lossyNamespace: "namespace-lossy"}
+		migratorService.secretsStore = secretsStore
+		addSecretToSqlStore(t, sqlSecretStore, ctx, 1, "namespace-lossy", "type-test", "SUPER_SECRET")
+		addSecretToSqlStore(t, sqlSecretStore, ctx, 1, "namespace-test", "type-test", "SUPER_SECRET")
+
+		// --- EXECUTION
+		err := migratorService.Migrate(ctx)
+		require.Error(t, err)
+
+		// --- VALIDATIONS
+		res, err := sqlSecretStore.Keys(ctx, 1, "namespace-lossy", "type-test")
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+		validateSecretWasDeleted(t, sqlSecretStore, ctx, 1, "namespace-test", "type-test")
+		validateSecretWasStoreInPlugin(t, secretsStore, ctx, 1, "namespace-test", "type-test")
+
+		status := migratorService.Status()
+		require.Equal(t, PluginMigrationFailed, status.Phase)
+		require.Equal(t, 2, status.Total)
+		require.Equal(t, 1, status.Migrated)
+		require.Equal(t, 1, status.Failed)
+		require.NotEmpty(t, status.Error)
+	})
+
+	t.Run("migration keeps the secrets that can't be decrypted", func(t *testing.T) {
+		// --- SETUP
+		migratorService, secretsStore, sqlSecretStore := setupTestMigratorService(t)
+		addSecretToSqlStore(t, sqlSecretStore, ctx, 1, "namespace-test", "type-test", "SUPER_SECRET")
+		var orgId int64 = 1
+		namespace, typ := "namespace-broken", "type-test"
+		err := sqlSecretStore.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+			_, err := dbSession.Insert(&Item{OrgId: &orgId, Namespace: &namespace, Type: &typ, Value: "not base64!", Created: time.Now(), Updated: time.Now()})
+			return err
+		})
+		require.NoError(t, err)
+
+		// --- EXECUTION
+		err = migratorService.Migrate(ctx)
+
+		// --- VALIDATIONS
+		var migrationErr *PluginMigrationError
+		require.ErrorAs(t, err, &migrationErr)
+		require.Equal(t, []Key{{OrgId: 1, Namespace: namespace, Type: typ}}, migrationErr.Undecryptable)
+		require.Empty(t, migrationErr.Unverified)
+		require.Contains(t, err.Error(), namespace)
+
+		validateSecretWasDeleted(t, sqlSecretStore, ctx, 1, "namespace-test", "type-test")
+		validateSecretWasStoreInPlugin(t, secretsStore, ctx, 1, "namespace-test", "type-test")
+		res, err := sqlSecretStore.Keys(ctx, 1, namespace, typ)
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+		res, err = secretsStore.Keys(ctx, 1, namespace, typ)
+		require.NoError(t, err)
+		require.Empty(t, res)
+	})
+
+	t.Run("migration retries transient errors reading the sql store", func(t *testing.T) {
+		// --- SETUP
+		setRetryDelay(t)
+		migratorService, secretsStore, _ := setupTestMigratorService(t)
+		calls := 0
+		migratorService.overrideGetAllFunc(func(ctx context.Context) ([]Item, error) {
+			calls++
+			if calls <= 2 {
+				return nil, errors.New("database is locked")
+			}
+			var orgId int64 = 1
+			namespace, typ := "namespace-test", "type-test"
+			return []Item{{Id: 1, OrgId: &orgId, Namespace: &namespace, Type: &typ, Value: "SUPER_SECRET"}}, nil
+		})
+
+		// --- EXECUTION
+		err := migratorService.Migrate(ctx)
+		require.NoError(t, err)
+
+		// --- VALIDATIONS
+		validateSecretWasStoreInPlugin(t, secretsStore, ctx, 1, "namespace-test", "type-test")
+	})
+
+	t.Run("migration fails when the sql store can't be read", func(t *testing.T) {
+		// --- SETUP
+		setRetryDelay(t)
+		migratorService, _, _ := setupTestMigratorService(t)
+		migratorService.overrideGetAllFunc(func(ctx context.Context) ([]Item, error) {
+			return nil, errors.New("database is locked")
+		})
+
+		// --- EXECUTION
+		err := migratorService.Migrate(ctx)
+
+		// --- VALIDATIONS
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "after 4 attempts")
+		require.Equal(t, PluginMigrationFailed, migratorService.Status().Phase)
+	})
+}
+
+func setRetryDelay(t *testing.T) {
+	pluginMigrationRetryDelay = time.Millisecond
+	t.Cleanup(func() {
+		pluginMigrationRetryDelay = time.Second
+	})
+}
+
+// fakeLossySecretsKVStore drops the values it is given for one namespace, to fail the migration verification
+type fakeLossySecretsKVStore struct {
+	SecretsKVStore
+	lossyNamespace string
+}
+
+func (f *fakeLossySecretsKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	if namespace == f.lossyNamespace {
+		value = ""
+	}
+	return f.SecretsKVStore.Set(ctx, orgId, namespace, typ, value)
+}
+
+func (f *fakeLossySecretsKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	return setEach(ctx, f, items)
 }
 
 func addSecretToSqlStore(t *testing.T, sqlSecretStore *secretsKVStoreSQL, ctx context.Context, orgId int64, namespace1 string, typ string, value string) {
@@ -89,16 +296,157 @@ func setupTestMigratorService(t *testing.T) (*PluginSecretMigrationService, Secr
 		secretsService,
 		kvstore.ProvideService(sqlStore),
 		manager,
+		nil,
+		nil,
 	)
 
 	secretsSql := &secretsKVStoreSQL{
-		sqlStore:       sqlStore,
-		secretsService: secretsService,
-		log:            log.New("test.logger"),
-		decryptionCache: decryptionCache{
-			cache: make(map[int64]cachedDecrypted),
-		},
+		sqlStore:        sqlStore,
+		secretsService:  secretsService,
+		log:             log.New("test.logger"),
+		decryptionCache: newDecryptionCache(defaultDecryptionCacheMaxEntries, defaultDecryptionCacheTTL),
+		clock:           clock.New(),
 	}
 
 	return migratorService, secretsStoreForPlugin, secretsSql
 }
+
+func TestPluginSecretMigrationService_MigrateBack(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("plugin disabled - secrets migrated back to sql", func(t *testing.T) {
+		// --- SETUP
+		secretsPlugin := newFakeMemoryGRPCSecretsPlugin()
+		secretsPlugin.secrets[buildKey(1, "namespace-test", "type-test")] = "SUPER_SECRET"
+		secretsPlugin.secrets[buildKey(2, "namespace-test2", "type-test")] = "OTHER_SECRET"
+		migratorService, sqlSecretStore, kv := setupTestMigrateBackService(t, secretsPlugin)
+		require.NoError(t, setPluginStartupErrorFatal(ctx, GetNamespacedKVStore(kv), true))
+
+		// --- EXECUTION
+		err := migratorService.Migrate(ctx)
+		require.NoError(t, err)
+
+		// --- VALIDATIONS
+		value, exists, err := sqlSecretStore.Get(ctx, 1, "namespace-test", "type-test")
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Equal(t, "SUPER_SECRET", value)
+		value, exists, err = sqlSecretStore.Get(ctx, 2, "namespace-test2", "type-test")
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Equal(t, "OTHER_SECRET", value)
+
+		require.Empty(t, secretsPlugin.secrets)
+		isFatal, err := isPluginStartupErrorFatal(ctx, GetNamespacedKVStore(kv))
+		require.NoError(t, err)
+		require.False(t, isFatal)
+
+		status := migratorService.Status()
+		require.Equal(t, PluginMigrationCompleted, status.Phase)
+		require.Equal(t, 2, status.Total)
+		require.Equal(t, 2, status.Migrated)
+	})
+
+	t.Run("plugin secrets are kept when deleting them fails", func(t *testing.T) {
+		// --- SETUP
+		secretsPlugin := newFakeMemoryGRPCSecretsPlugin()
+		secretsPlugin.secrets[buildKey(1, "namespace-test", "type-test")] = "SUPER_SECRET"
+		secretsPlugin.deleteError = "plugin is read only"
+		migratorService, sqlSecretStore, _ := setupTestMigrateBackService(t, secretsPlugin)
+
+		// --- EXECUTION
+		err := migratorService.Migrate(ctx)
+		require.Error(t, err)
+
+		// --- VALIDATIONS
+		_, exists, err := sqlSecretStore.Get(ctx, 1, "namespace-test", "type-test")
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.Len(t, secretsPlugin.secrets, 1)
+	})
+}
+
+func setupTestMigrateBackService(t *testing.T, secretsPlugin *fakeMemoryGRPCSecretsPlugin) (*PluginSecretMigrationService, *secretsKVStoreSQL, kvstore.KVStore) {
+	t.Helper()
+	startupOnce = sync.Once{}
+
+	raw, err := ini.Load([]byte(`
+		[secrets]
+		use_plugin = false
+		`))
+	require.NoError(t, err)
+	cfg := &setting.Cfg{Raw: raw}
+
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := secretsManager.SetupTestService(t, fakes.NewFakeSecretsStore())
+	kv := kvstore.ProvideService(sqlStore)
+	migratorService := ProvidePluginSecretMigrationService(
+		NewFakeSecretsKVStore(),
+		cfg,
+		sqlStore,
+		secretsService,
+		kv,
+		NewFakeSecretsPluginManagerWithPlugin(t, secretsPlugin),
+		nil,
+		nil,
+	)
+
+	secretsSql := &secretsKVStoreSQL{
+		sqlStore:        sqlStore,
+		secretsService:  secretsService,
+		log:             log.New("test.logger"),
+		decryptionCache: newDecryptionCache(defaultDecryptionCacheMaxEntries, defaultDecryptionCacheTTL),
+		clock:           clock.New(),
+	}
+
+	return migratorService, secretsSql, kv
+}
+
+// In memory grpc secrets plugin, only the methods used by the migration are implemented
+type fakeMemoryGRPCSecretsPlugin struct {
+	fakeGRPCSecretsPlugin
+	secrets     map[Key]string
+	deleteError string
+}
+
+func newFakeMemoryGRPCSecretsPlugin() *fakeMemoryGRPCSecretsPlugin {
+	return &fakeMemoryGRPCSecretsPlugin{secrets: make(map[Key]string)}
+}
+
+func (c *fakeMemoryGRPCSecretsPlugin) DeleteSecret(ctx context.Context, in *secretsmanagerplugin.DeleteSecretRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.DeleteSecretResponse, error) {
+	if c.deleteError != "" {
+		return &secretsmanagerplugin.DeleteSecretResponse{UserFriendlyError: c.deleteError}, nil
+	}
+	delete(c.secrets, buildKey(in.KeyDescriptor.OrgId, in.KeyDescriptor.Namespace, in.KeyDescriptor.Type))
+	return &secretsmanagerplugin.DeleteSecretResponse{}, nil
+}
+
+// DeleteSecrets is not implemented, as in plugins built before the batch calls, so secrets are deleted one at a time
+func (c *fakeMemoryGRPCSecretsPlugin) DeleteSecrets(ctx context.Context, in *secretsmanagerplugin.DeleteSecretsRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.DeleteSecretsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DeleteSecrets not implemented")
+}
+
+func (c *fakeMemoryGRPCSecretsPlugin) GetAllSecrets(ctx context.Context, in *secretsmanagerplugin.GetAllSecretsRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.GetAllSecretsResponse, error) {
+	items := make([]*secretsmanagerplugin.Item, 0, len(c.secrets))
+	for k, v := range c.secrets {
+		items = append(items, &secretsmanagerplugin.Item{
+			Key:   &secretsmanagerplugin.Key{OrgId: k.OrgId, Namespace: k.Namespace, Type: k.Type},
+			Value: v,
+		})
+	}
+	return &secretsmanagerplugin.GetAllSecretsResponse{Items: items}, nil
+}
+
+func (c *fakeMemoryGRPCSecretsPlugin) StreamAllSecrets(ctx context.Context, in *secretsmanagerplugin.StreamAllSecretsRequest, opts ...grpc.CallOption) (secretsmanagerplugin.SecretsManager_StreamAllSecretsClient, error) {
+	all, err := c.GetAllSecrets(ctx, &secretsmanagerplugin.GetAllSecretsRequest{}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	stream := &fakeStreamAllSecretsClient{}
+	for start := 0; start < len(all.Items); start += int(in.BatchSize) {
+		stream.responses = append(stream.responses, &secretsmanagerplugin.GetAllSecretsResponse{
+			Items: all.Items[start:minInt(start+int(in.BatchSize), len(all.Items))],
+		})
+	}
+	return stream, nil
+}
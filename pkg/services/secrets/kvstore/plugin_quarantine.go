@@ -0,0 +1,333 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	PluginQuarantineKey = "plugin_quarantine"
+	PluginCrashesKey    = "plugin_crashes"
+
+	defaultPluginQuarantineCrashes = 3
+	defaultPluginQuarantineWindow  = 10 * time.Minute
+
+	// how long the quarantine read from the kvstore is reused, so that the instances notice when another one
+	// quarantines the plugin or lifts the quarantine
+	pluginQuarantineRefreshInterval = time.Minute
+)
+
+var ErrSecretsPluginQuarantined = errors.New("secrets manager plugin is quarantined after crashing repeatedly, secrets can't be updated until the quarantine is lifted")
+
+// PluginQuarantine is the state of the secrets manager plugin once it crashed repeatedly
+type PluginQuarantine struct {
+	Since     time.Time `json:"since"`
+	Crashes   int       `json:"crashes"`
+	Window    string    `json:"window"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// PluginQuarantineService quarantines the secrets manager plugin once it crashed crashes times within window: the
+// reads of secrets switch to the sql store, the updates fail with ErrSecretsPluginQuarantined, and the quarantine
+// is reported by the secrets health until an administrator lifts it. The quarantine is recorded in the kvstore, so
+// that it survives restarts, when a crash-looping plugin would otherwise fail the startup, and is shared by the
+// instances.
+type PluginQuarantineService struct {
+	mu       sync.Mutex
+	kvstore  *kvstore.NamespacedKVStore
+	crashes  int
+	window   time.Duration
+	clock    clock.Clock
+	log      log.Logger
+	disabled bool
+	// recentCrashes are the crashes within window, the oldest first
+	recentCrashes []time.Time
+	// crashed is true from the first failure of a crash until the plugin responds again, so that the calls
+	// failing during a single crash are counted once
+	crashed    bool
+	quarantine *PluginQuarantine
+	refreshed  time.Time
+}
+
+func ProvidePluginQuarantineService(kv kvstore.KVStore, cfg *setting.Cfg, clk clock.Clock) *PluginQuarantineService {
+	section := cfg.SectionWithEnvOverrides("secrets")
+	crashes := section.Key("plugin_quarantine_crashes").MustInt(defaultPluginQuarantineCrashes)
+	return &PluginQuarantineService{
+		kvstore:  GetNamespacedKVStore(kv),
+		crashes:  crashes,
+		window:   section.Key("plugin_quarantine_window").MustDuration(defaultPluginQuarantineWindow),
+		clock:    clk,
+		log:      log.New("secrets.kvstore.quarantine"),
+		disabled: crashes <= 0,
+	}
+}
+
+// Get returns the quarantine of the plugin, nil when the plugin is not quarantined
+func (s *PluginQuarantineService) Get(ctx context.Context) (*PluginQuarantine, error) {
+	if s == nil || s.disabled {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return s.quarantine, nil
+}
+
+// isQuarantined returns whether the plugin is quarantined, the quarantine is kept when it can't be read
+func (s *PluginQuarantineService) isQuarantined(ctx context.Context) bool {
+	if s == nil || s.disabled {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(ctx); err != nil {
+		s.log.Warn("failed to read the secrets manager plugin quarantine", "error", err)
+	}
+	return s.quarantine != nil
+}
+
+// refresh reads the quarantine from the kvstore at most every pluginQuarantineRefreshInterval
+func (s *PluginQuarantineService) refresh(ctx context.Context) error {
+	if !s.refreshed.IsZero() && s.clock.Since(s.refreshed) < pluginQuarantineRefreshInterval {
+		return nil
+	}
+	value, exists, err := s.kvstore.Get(ctx, PluginQuarantineKey)
+	if err != nil {
+		return err
+	}
+	s.refreshed = s.clock.Now()
+	if !exists {
+		s.setQuarantine(nil)
+		return nil
+	}
+	var quarantine PluginQuarantine
+	if err := json.Unmarshal([]byte(value), &quarantine); err != nil {
+		return fmt.Errorf("invalid secrets manager plugin quarantine %q: %w", value, err)
+	}
+	s.setQuarantine(&quarantine)
+	return nil
+}
+
+func (s *PluginQuarantineService) setQuarantine(quarantine *PluginQuarantine) {
+	s.quarantine = quarantine
+	if quarantine != nil {
+		pluginQuarantinedGauge.Set(1)
+	} else {
+		pluginQuarantinedGauge.Set(0)
+	}
+}
+
+// recordCall counts a crash when a call to the plugin fails because the plugin process can't be reached, and
+// quarantines the plugin once it crashed too many times within the window
+func (s *PluginQuarantineService) recordCall(err error) {
+	if s == nil || s.disabled {
+		return
+	}
+	if status.Code(err) != codes.Unavailable {
+		if !isPluginUnavailable(err) {
+			// the plugin responded
+			s.mu.Lock()
+			s.crashed = false
+			s.mu.Unlock()
+		}
+		return
+	}
+
+	s.mu.Lock()
+	alreadyCrashed := s.crashed
+	s.crashed = true
+	s.mu.Unlock()
+	if !alreadyCrashed {
+		// the quarantine is recorded even when the call that failed was canceled
+		s.recordCrash(context.Background(), err)
+	}
+}
+
+// recordCrash counts a crash of the plugin, including a failure to start it
+func (s *PluginQuarantineService) recordCrash(ctx context.Context, err error) {
+	if s == nil || s.disabled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pluginCrashesCounter.Inc()
+	// the crashes are recorded in the kvstore too, so that the plugin failing to start is quarantined after
+	// as many restarts of Grafana
+	if value, exists, getErr := s.kvstore.Get(ctx, PluginCrashesKey); getErr == nil && exists {
+		var stored []time.Time
+		if json.Unmarshal([]byte(value), &stored) == nil {
+			s.recentCrashes = stored
+		}
+	}
+	now := s.clock.Now()
+	recent := make([]time.Time, 0, len(s.recentCrashes)+1)
+	for _, crash := range s.recentCrashes {
+		if now.Sub(crash) < s.window {
+			recent = append(recent, crash)
+		}
+	}
+	s.recentCrashes = append(recent, now)
+	if value, marshalErr := json.Marshal(s.recentCrashes); marshalErr == nil {
+		if setErr := s.kvstore.Set(ctx, PluginCrashesKey, string(value)); setErr != nil {
+			s.log.Warn("failed to record the secrets manager plugin crash", "error", setErr)
+		}
+	}
+	s.log.Warn("secrets manager plugin crashed", "crashes", len(s.recentCrashes), "window", s.window, "error", err)
+
+	if len(s.recentCrashes) < s.crashes || s.quarantine != nil {
+		return
+	}
+	quarantine := &PluginQuarantine{
+		Since:   now,
+		Crashes: len(s.recentCrashes),
+		Window:  s.window.String(),
+	}
+	if err != nil {
+		quarantine.LastError = err.Error()
+	}
+	value, marshalErr := json.Marshal(quarantine)
+	if marshalErr != nil {
+		s.log.Error("failed to record the secrets manager plugin quarantine", "error", marshalErr)
+		return
+	}
+	if setErr := s.kvstore.Set(ctx, PluginQuarantineKey, string(value)); setErr != nil {
+		// the plugin is quarantined by this instance only, the others will quarantine it when it crashes for them
+		s.log.Error("failed to record the secrets manager plugin quarantine", "error", setErr)
+	}
+	s.setQuarantine(quarantine)
+	s.refreshed = now
+	s.log.Error("secrets manager plugin crashed repeatedly and is quarantined: secrets are read from the database and can't be updated. "+
+		"Fix the plugin, then lift the quarantine with DELETE /api/admin/secrets/plugin/quarantine",
+		"crashes", quarantine.Crashes, "window", s.window, "error", err)
+}
+
+// Lift lifts the quarantine of the plugin, the secrets are read from and updated in the plugin again
+func (s *PluginQuarantineService) Lift(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.kvstore.Del(ctx, PluginQuarantineKey); err != nil {
+		return err
+	}
+	if err := s.kvstore.Del(ctx, PluginCrashesKey); err != nil {
+		return err
+	}
+	s.recentCrashes = nil
+	s.crashed = false
+	s.setQuarantine(nil)
+	s.refreshed = s.clock.Now()
+	s.log.Info("secrets manager plugin quarantine lifted")
+	return nil
+}
+
+// wrap returns a SecretsKVStore that uses store, or reads from fallback while the plugin is quarantined. store
+// is nil when the plugin could not be started, the secrets can only be read from fallback then.
+func (s *PluginQuarantineService) wrap(store SecretsKVStore, fallback SecretsKVStore) SecretsKVStore {
+	if store != nil && (s == nil || s.disabled) {
+		return store
+	}
+	return &quarantinedKVStore{store: store, fallback: fallback, quarantine: s}
+}
+
+// quarantinedKVStore reads the secrets from fallback and rejects the updates while the plugin is quarantined
+type quarantinedKVStore struct {
+	store      SecretsKVStore
+	fallback   SecretsKVStore
+	quarantine *PluginQuarantineService
+}
+
+func (kv *quarantinedKVStore) quarantined(ctx context.Context) bool {
+	return kv.store == nil || kv.quarantine.isQuarantined(ctx)
+}
+
+func (kv *quarantinedKVStore) reader(ctx context.Context) SecretsKVStore {
+	if kv.quarantined(ctx) {
+		return kv.fallback
+	}
+	return kv.store
+}
+
+func (kv *quarantinedKVStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	return kv.reader(ctx).Get(ctx, orgId, namespace, typ)
+}
+
+func (kv *quarantinedKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	if kv.quarantined(ctx) {
+		return ErrSecretsPluginQuarantined
+	}
+	return kv.store.Set(ctx, orgId, namespace, typ, value)
+}
+
+func (kv *quarantinedKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	if kv.quarantined(ctx) {
+		return ErrSecretsPluginQuarantined
+	}
+	return kv.store.SetWithTTL(ctx, orgId, namespace, typ, value, ttl)
+}
+
+func (kv *quarantinedKVStore) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	if kv.quarantined(ctx) {
+		return ErrSecretsPluginQuarantined
+	}
+	return kv.store.Del(ctx, orgId, namespace, typ)
+}
+
+func (kv *quarantinedKVStore) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	return kv.reader(ctx).Keys(ctx, orgId, namespace, typ)
+}
+
+func (kv *quarantinedKVStore) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	return kv.reader(ctx).ListKeys(ctx, orgId, namespacePattern, typeFilter)
+}
+
+func (kv *quarantinedKVStore) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	if kv.quarantined(ctx) {
+		return ErrSecretsPluginQuarantined
+	}
+	return kv.store.Rename(ctx, orgId, namespace, typ, newNamespace)
+}
+
+func (kv *quarantinedKVStore) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	return kv.reader(ctx).GetVersion(ctx, orgId, namespace, typ, version)
+}
+
+func (kv *quarantinedKVStore) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	return kv.reader(ctx).ListVersions(ctx, orgId, namespace, typ)
+}
+
+func (kv *quarantinedKVStore) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	if kv.quarantined(ctx) {
+		return ErrSecretsPluginQuarantined
+	}
+	return kv.store.Rollback(ctx, orgId, namespace, typ, version)
+}
+
+func (kv *quarantinedKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	if kv.quarantined(ctx) {
+		return ErrSecretsPluginQuarantined
+	}
+	return kv.store.SetMultiple(ctx, items)
+}
+
+func (kv *quarantinedKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	if kv.quarantined(ctx) {
+		return ErrSecretsPluginQuarantined
+	}
+	return kv.store.DelMultiple(ctx, keys)
+}
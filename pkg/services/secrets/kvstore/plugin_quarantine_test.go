@@ -0,0 +1,177 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestPluginQuarantine(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	kv := kvstore.ProvideService(sqlstore.InitTestDB(t))
+	quarantine := setupPluginQuarantine(t, kv, clk)
+
+	secretsPlugin := &fakeUnavailableGRPCSecretsPlugin{
+		fakeBatchGRPCSecretsPlugin: &fakeBatchGRPCSecretsPlugin{fakeMemoryGRPCSecretsPlugin: newFakeMemoryGRPCSecretsPlugin()},
+	}
+	pluginStore := setupPluginStore(t, secretsPlugin)
+	pluginStore.quarantine = quarantine
+	fallback := NewFakeSecretsKVStore()
+	store := quarantine.wrap(pluginStore, fallback)
+
+	require.NoError(t, store.Set(ctx, 1, "ds1", "datasource", "from plugin"))
+	require.NoError(t, fallback.Set(ctx, 1, "ds1", "datasource", "from database"))
+
+	t.Run("counts the failures of a single crash once", func(t *testing.T) {
+		crashes := testutil.ToFloat64(pluginCrashesCounter)
+		secretsPlugin.unavailable = true
+		for i := 0; i < 3; i++ {
+			_, _, err := store.Get(ctx, 1, "ds1", "datasource")
+			require.Error(t, err)
+		}
+		assert.Equal(t, crashes+1, testutil.ToFloat64(pluginCrashesCounter))
+
+		q, err := quarantine.Get(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, q)
+	})
+
+	t.Run("forgets the crashes out of the window", func(t *testing.T) {
+		secretsPlugin.unavailable = false
+		_, _, err := store.Get(ctx, 1, "ds1", "datasource")
+		require.NoError(t, err)
+
+		clk.Add(time.Hour)
+		secretsPlugin.unavailable = true
+		_, _, err = store.Get(ctx, 1, "ds1", "datasource")
+		require.Error(t, err)
+
+		q, err := quarantine.Get(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, q)
+	})
+
+	t.Run("quarantines the plugin once it crashed repeatedly within the window", func(t *testing.T) {
+		secretsPlugin.unavailable = false
+		_, _, err := store.Get(ctx, 1, "ds1", "datasource")
+		require.NoError(t, err)
+		secretsPlugin.unavailable = true
+		_, _, err = store.Get(ctx, 1, "ds1", "datasource")
+		require.Error(t, err)
+
+		q, err := quarantine.Get(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, q)
+		assert.Equal(t, 2, q.Crashes)
+		assert.Equal(t, "10m0s", q.Window)
+		assert.Contains(t, q.LastError, "plugin is not running")
+		assert.Equal(t, float64(1), testutil.ToFloat64(pluginQuarantinedGauge))
+
+		_, exists, err := GetNamespacedKVStore(kv).Get(ctx, PluginQuarantineKey)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("reads from the fallback and rejects the updates while quarantined", func(t *testing.T) {
+		calls := secretsPlugin.calls
+		value, found, err := store.Get(ctx, 1, "ds1", "datasource")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "from database", value)
+
+		assert.ErrorIs(t, store.Set(ctx, 1, "ds1", "datasource", "new"), ErrSecretsPluginQuarantined)
+		assert.ErrorIs(t, store.Del(ctx, 1, "ds1", "datasource"), ErrSecretsPluginQuarantined)
+		assert.ErrorIs(t, store.Rename(ctx, 1, "ds1", "datasource", "ds2"), ErrSecretsPluginQuarantined)
+		assert.Equal(t, calls, secretsPlugin.calls)
+	})
+
+	t.Run("is shared by the instances through the kvstore", func(t *testing.T) {
+		other := setupPluginQuarantine(t, kv, clk)
+		q, err := other.Get(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, q)
+		assert.Equal(t, 2, q.Crashes)
+	})
+
+	t.Run("uses the plugin again once lifted", func(t *testing.T) {
+		secretsPlugin.unavailable = false
+		require.NoError(t, quarantine.Lift(ctx))
+		assert.Equal(t, float64(0), testutil.ToFloat64(pluginQuarantinedGauge))
+
+		value, found, err := store.Get(ctx, 1, "ds1", "datasource")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "from plugin", value)
+		require.NoError(t, store.Set(ctx, 1, "ds1", "datasource", "new"))
+
+		_, exists, err := GetNamespacedKVStore(kv).Get(ctx, PluginCrashesKey)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("is disabled with no crashes", func(t *testing.T) {
+		raw, err := ini.Load([]byte(`
+			[secrets]
+			plugin_quarantine_crashes = 0
+			`))
+		require.NoError(t, err)
+		disabled := ProvidePluginQuarantineService(kv, &setting.Cfg{Raw: raw}, clk)
+		assert.Same(t, SecretsKVStore(pluginStore), disabled.wrap(pluginStore, fallback))
+	})
+}
+
+// With the fatal flag set, simulate a plugin start failure once the plugin crashed repeatedly
+// Should result in the secret store provider reading from the sql store instead of failing
+func TestPluginQuarantine_PluginFailsToStartWhileQuarantined(t *testing.T) {
+	ctx := context.Background()
+	fatalFlagOnce = sync.Once{}
+	startupOnce = sync.Once{}
+	t.Cleanup(func() {
+		fatalFlagOnce = sync.Once{}
+	})
+	clk := clock.NewMock()
+	sqlStore := sqlstore.InitTestDB(t)
+	kv := kvstore.ProvideService(sqlStore)
+	require.NoError(t, setPluginStartupErrorFatal(ctx, GetNamespacedKVStore(kv), true))
+	quarantine := setupPluginQuarantine(t, kv, clk)
+	quarantine.recordCrash(ctx, errors.New("plugin exited"))
+
+	svc, err := ProvideService(sqlStore, fakes.FakeSecretsService{}, NewFakeSecretsPluginManager(t, true), kv,
+		NewFakeFeatureToggles(t, false), setupTestConfig(t), nil, nil, nil, nil, nil, quarantine, nil, nil, clk)
+	require.NoError(t, err)
+	require.NotNil(t, svc)
+
+	q, err := quarantine.Get(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, q)
+	assert.Contains(t, q.LastError, "failed to start")
+
+	_, found, err := svc.Get(ctx, 1, "ds1", "datasource")
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.ErrorIs(t, svc.Set(ctx, 1, "ds1", "datasource", "secret"), ErrSecretsPluginQuarantined)
+}
+
+func setupPluginQuarantine(t *testing.T, kv kvstore.KVStore, clk clock.Clock) *PluginQuarantineService {
+	t.Helper()
+	raw, err := ini.Load([]byte(`
+		[secrets]
+		plugin_quarantine_crashes = 2
+		plugin_quarantine_window = 10m
+		`))
+	require.NoError(t, err)
+	return ProvidePluginQuarantineService(kv, &setting.Cfg{Raw: raw}, clk)
+}
@@ -0,0 +1,331 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/plugins"
+	smp "github.com/grafana/grafana/pkg/plugins/backendplugin/secretsmanagerplugin"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const defaultPluginReloadDrainTimeout = 30 * time.Second
+
+var (
+	ErrSecretsPluginNotReloadable    = errors.New("secrets are not stored in a secrets manager plugin that can be reloaded")
+	ErrSecretsPluginReloadInProgress = errors.New("secrets manager plugin is already being reloaded")
+)
+
+// PluginReloadService reloads the secrets manager plugin with the current plugin settings, the [plugin.<plugin id>]
+// section of the configuration including the path of the plugin, without restarting Grafana. The calls to the
+// plugin in flight complete before the plugin is stopped, for at most drainTimeout, and the calls made during the
+// reload wait for the reloaded plugin.
+type PluginReloadService struct {
+	manager      plugins.SecretsPluginManager
+	drainTimeout time.Duration
+	log          log.Logger
+	// readSettings reads the plugin settings again, from the configuration of Grafana
+	readSettings func() error
+
+	mu sync.Mutex
+	// store is the plugin store in use, nil when the secrets are not stored in the plugin
+	store *secretsKVStorePlugin
+}
+
+func ProvidePluginReloadService(cfg *setting.Cfg, pluginsManager plugins.SecretsPluginManager) *PluginReloadService {
+	return &PluginReloadService{
+		manager:      pluginsManager,
+		drainTimeout: cfg.SectionWithEnvOverrides("secrets").Key("plugin_reload_drain_timeout").MustDuration(defaultPluginReloadDrainTimeout),
+		log:          log.New("secrets.kvstore.reload"),
+		readSettings: cfg.ReloadPluginSettings,
+	}
+}
+
+// register makes the plugin of the store reloadable
+func (s *PluginReloadService) register(store *secretsKVStorePlugin) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store.secretsPlugin = &reloadableSecretsPlugin{plugin: store.secretsPlugin}
+	s.store = store
+}
+
+// Reload reads the plugin settings again and restarts the secrets manager plugin with them
+func (s *PluginReloadService) Reload(ctx context.Context) (err error) {
+	defer func() {
+		pluginReloadsCounter.With(prometheus.Labels{"success": strconv.FormatBool(err == nil)}).Inc()
+	}()
+
+	var store *secretsKVStorePlugin
+	if s != nil {
+		s.mu.Lock()
+		store = s.store
+		s.mu.Unlock()
+	}
+	if store == nil {
+		return ErrSecretsPluginNotReloadable
+	}
+	plugin, ok := store.secretsPlugin.(*reloadableSecretsPlugin)
+	if !ok {
+		return ErrSecretsPluginNotReloadable
+	}
+	reloader, ok := s.manager.(plugins.SecretsPluginReloader)
+	if !ok {
+		return ErrSecretsPluginNotReloadable
+	}
+
+	if err := s.readSettings(); err != nil {
+		return fmt.Errorf("failed to read the plugin settings: %w", err)
+	}
+
+	s.log.Info("reloading the secrets manager plugin")
+	err = plugin.reload(ctx, s.drainTimeout, s.log, func() (smp.SecretsManagerPlugin, error) {
+		p, err := reloader.ReloadSecretsManager(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// the plugin process outlives the request that reloads it
+		if err := p.Start(context.Background()); err != nil {
+			return nil, err
+		}
+		if p.SecretsManager == nil {
+			return nil, fmt.Errorf("plugin %s did not start a secrets manager", p.ID)
+		}
+		return p.SecretsManager, nil
+	})
+	if err != nil {
+		s.log.Error("failed to reload the secrets manager plugin", "error", err)
+		return err
+	}
+
+	store.setCapabilities(negotiatePluginCapabilities(ctx, plugin, s.log))
+	s.log.Info("secrets manager plugin reloaded")
+	return nil
+}
+
+// reloadableSecretsPlugin calls the secrets manager plugin until it is reloaded. The reload waits for the calls in
+// flight, and the calls made during the reload wait for the reloaded plugin.
+type reloadableSecretsPlugin struct {
+	mu       sync.Mutex
+	plugin   smp.SecretsManagerPlugin
+	inFlight int
+	// reloaded is closed once the plugin is reloaded, it is nil when the plugin is not being reloaded
+	reloaded chan struct{}
+	// drained is closed once the calls in flight when the reload started complete
+	drained chan struct{}
+}
+
+// acquire returns the plugin to call, and the function to call once the call completes
+func (p *reloadableSecretsPlugin) acquire(ctx context.Context) (smp.SecretsManagerPlugin, func(), error) {
+	for {
+		p.mu.Lock()
+		reloaded := p.reloaded
+		if reloaded == nil {
+			p.inFlight++
+			plugin := p.plugin
+			p.mu.Unlock()
+			var once sync.Once
+			return plugin, func() { once.Do(p.release) }, nil
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-reloaded:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+func (p *reloadableSecretsPlugin) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight--
+	if p.inFlight == 0 && p.drained != nil {
+		close(p.drained)
+		p.drained = nil
+	}
+}
+
+// reload replaces the plugin with the one returned by restart, once the calls in flight complete or drainTimeout
+// elapses. The plugin is kept when restart fails.
+func (p *reloadableSecretsPlugin) reload(ctx context.Context, drainTimeout time.Duration, logger log.Logger, restart func() (smp.SecretsManagerPlugin, error)) error {
+	p.mu.Lock()
+	if p.reloaded != nil {
+		p.mu.Unlock()
+		return ErrSecretsPluginReloadInProgress
+	}
+	reloaded, drained := make(chan struct{}), make(chan struct{})
+	p.reloaded = reloaded
+	if p.inFlight == 0 {
+		close(drained)
+	} else {
+		p.drained = drained
+	}
+	p.mu.Unlock()
+
+	var plugin smp.SecretsManagerPlugin
+	var err error
+	defer func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if err == nil {
+			p.plugin = plugin
+		}
+		p.drained = nil
+		p.reloaded = nil
+		close(reloaded)
+	}()
+
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+		logger.Warn("calls to the secrets manager plugin still in flight are interrupted by the reload", "drainTimeout", drainTimeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+		return err
+	}
+
+	plugin, err = restart()
+	return err
+}
+
+func (p *reloadableSecretsPlugin) GetSecret(ctx context.Context, in *smp.GetSecretRequest, opts ...grpc.CallOption) (*smp.GetSecretResponse, error) {
+	plugin, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return plugin.GetSecret(ctx, in, opts...)
+}
+
+func (p *reloadableSecretsPlugin) SetSecret(ctx context.Context, in *smp.SetSecretRequest, opts ...grpc.CallOption) (*smp.SetSecretResponse, error) {
+	plugin, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return plugin.SetSecret(ctx, in, opts...)
+}
+
+func (p *reloadableSecretsPlugin) DeleteSecret(ctx context.Context, in *smp.DeleteSecretRequest, opts ...grpc.CallOption) (*smp.DeleteSecretResponse, error) {
+	plugin, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return plugin.DeleteSecret(ctx, in, opts...)
+}
+
+func (p *reloadableSecretsPlugin) ListSecrets(ctx context.Context, in *smp.ListSecretsRequest, opts ...grpc.CallOption) (*smp.ListSecretsResponse, error) {
+	plugin, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return plugin.ListSecrets(ctx, in, opts...)
+}
+
+func (p *reloadableSecretsPlugin) RenameSecret(ctx context.Context, in *smp.RenameSecretRequest, opts ...grpc.CallOption) (*smp.RenameSecretResponse, error) {
+	plugin, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return plugin.RenameSecret(ctx, in, opts...)
+}
+
+func (p *reloadableSecretsPlugin) GetAllSecrets(ctx context.Context, in *smp.GetAllSecretsRequest, opts ...grpc.CallOption) (*smp.GetAllSecretsResponse, error) {
+	plugin, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return plugin.GetAllSecrets(ctx, in, opts...)
+}
+
+func (p *reloadableSecretsPlugin) SetSecrets(ctx context.Context, in *smp.SetSecretsRequest, opts ...grpc.CallOption) (*smp.SetSecretsResponse, error) {
+	plugin, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return plugin.SetSecrets(ctx, in, opts...)
+}
+
+func (p *reloadableSecretsPlugin) DeleteSecrets(ctx context.Context, in *smp.DeleteSecretsRequest, opts ...grpc.CallOption) (*smp.DeleteSecretsResponse, error) {
+	plugin, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return plugin.DeleteSecrets(ctx, in, opts...)
+}
+
+func (p *reloadableSecretsPlugin) ListSecretKeys(ctx context.Context, in *smp.ListSecretKeysRequest, opts ...grpc.CallOption) (*smp.ListSecretsResponse, error) {
+	plugin, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return plugin.ListSecretKeys(ctx, in, opts...)
+}
+
+// StreamAllSecrets streams the secrets of the plugin, the stream is in flight until it fails, ends or its context
+// is canceled
+func (p *reloadableSecretsPlugin) StreamAllSecrets(ctx context.Context, in *smp.StreamAllSecretsRequest, opts ...grpc.CallOption) (smp.SecretsManager_StreamAllSecretsClient, error) {
+	plugin, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := plugin.StreamAllSecrets(ctx, in, opts...)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		release()
+	}()
+	return &reloadableStreamAllSecretsClient{SecretsManager_StreamAllSecretsClient: stream, done: done}, nil
+}
+
+func (p *reloadableSecretsPlugin) GetCapabilities(ctx context.Context, in *smp.GetCapabilitiesRequest, opts ...grpc.CallOption) (*smp.GetCapabilitiesResponse, error) {
+	plugin, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return plugin.GetCapabilities(ctx, in, opts...)
+}
+
+// reloadableStreamAllSecretsClient closes done once the stream fails or ends
+type reloadableStreamAllSecretsClient struct {
+	smp.SecretsManager_StreamAllSecretsClient
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func (c *reloadableStreamAllSecretsClient) Recv() (*smp.GetAllSecretsResponse, error) {
+	res, err := c.SecretsManager_StreamAllSecretsClient.Recv()
+	if err != nil {
+		c.doneOnce.Do(func() { close(c.done) })
+	}
+	return res, err
+}
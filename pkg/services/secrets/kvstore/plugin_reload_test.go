@@ -0,0 +1,205 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/plugins/backendplugin/secretsmanagerplugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestPluginReloadService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("fails when the secrets are not stored in the plugin", func(t *testing.T) {
+		s := setupPluginReloadService(t, &fakePluginManager{})
+		assert.ErrorIs(t, s.Reload(ctx), ErrSecretsPluginNotReloadable)
+	})
+
+	t.Run("restarts the plugin with the current settings", func(t *testing.T) {
+		previous := &fakeBatchGRPCSecretsPlugin{fakeMemoryGRPCSecretsPlugin: newFakeMemoryGRPCSecretsPlugin()}
+		previous.secrets[buildKey(1, "ds1", "datasource")] = "previous"
+		reloaded := &fakeBatchGRPCSecretsPlugin{fakeMemoryGRPCSecretsPlugin: newFakeMemoryGRPCSecretsPlugin()}
+		reloaded.secrets[buildKey(1, "ds1", "datasource")] = "reloaded"
+		manager := &fakePluginManager{secretsPlugin: reloaded}
+		s := setupPluginReloadService(t, manager)
+		settingsRead := 0
+		s.readSettings = func() error {
+			settingsRead++
+			return nil
+		}
+		store := setupPluginStore(t, previous)
+		s.register(store)
+
+		value, _, err := store.Get(ctx, 1, "ds1", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "previous", value)
+
+		require.NoError(t, s.Reload(ctx))
+		assert.Equal(t, 1, settingsRead)
+		assert.Equal(t, 1, manager.reloads)
+		value, _, err = store.Get(ctx, 1, "ds1", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "reloaded", value)
+		require.NotNil(t, store.capabilities)
+		assert.True(t, store.supports(pluginCapabilityBatch))
+	})
+
+	t.Run("keeps the plugin when it can't be reloaded", func(t *testing.T) {
+		previous := &fakeBatchGRPCSecretsPlugin{fakeMemoryGRPCSecretsPlugin: newFakeMemoryGRPCSecretsPlugin()}
+		previous.secrets[buildKey(1, "ds1", "datasource")] = "previous"
+		manager := &fakePluginManager{reloadErr: errors.New("plugin not found")}
+		s := setupPluginReloadService(t, manager)
+		store := setupPluginStore(t, previous)
+		s.register(store)
+
+		require.Error(t, s.Reload(ctx))
+		value, _, err := store.Get(ctx, 1, "ds1", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "previous", value)
+	})
+
+	t.Run("fails when the plugin settings can't be read", func(t *testing.T) {
+		manager := &fakePluginManager{}
+		s := setupPluginReloadService(t, manager)
+		s.readSettings = func() error { return errors.New("invalid configuration") }
+		s.register(setupPluginStore(t, newFakeMemoryGRPCSecretsPlugin()))
+
+		require.Error(t, s.Reload(ctx))
+		assert.Equal(t, 0, manager.reloads)
+	})
+}
+
+func TestReloadableSecretsPlugin(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New("test.logger")
+	key := &secretsmanagerplugin.GetSecretRequest{KeyDescriptor: &secretsmanagerplugin.Key{OrgId: 1, Namespace: "ds1", Type: "datasource"}}
+
+	t.Run("waits for the calls in flight before restarting the plugin", func(t *testing.T) {
+		previous := newFakeBlockingGRPCSecretsPlugin("previous")
+		reloaded := newFakeBlockingGRPCSecretsPlugin("reloaded")
+		close(reloaded.unblock)
+		p := &reloadableSecretsPlugin{plugin: previous}
+
+		inFlight := make(chan string)
+		go func() {
+			res, _ := p.GetSecret(ctx, key)
+			inFlight <- res.DecryptedValue
+		}()
+		<-previous.called
+
+		restarted := make(chan struct{})
+		reloadErr := make(chan error)
+		go func() {
+			reloadErr <- p.reload(ctx, time.Minute, logger, func() (secretsmanagerplugin.SecretsManagerPlugin, error) {
+				close(restarted)
+				return reloaded, nil
+			})
+		}()
+		require.Eventually(t, func() bool {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			return p.reloaded != nil
+		}, time.Second, time.Millisecond)
+
+		waiting := make(chan string)
+		go func() {
+			res, _ := p.GetSecret(ctx, key)
+			waiting <- res.DecryptedValue
+		}()
+
+		select {
+		case <-restarted:
+			t.Fatal("plugin restarted while a call was in flight")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		close(previous.unblock)
+		assert.Equal(t, "previous", <-inFlight)
+		require.NoError(t, <-reloadErr)
+		assert.Equal(t, "reloaded", <-waiting)
+	})
+
+	t.Run("restarts the plugin once the drain timeout elapses", func(t *testing.T) {
+		previous := newFakeBlockingGRPCSecretsPlugin("previous")
+		reloaded := newFakeBlockingGRPCSecretsPlugin("reloaded")
+		close(reloaded.unblock)
+		p := &reloadableSecretsPlugin{plugin: previous}
+
+		go func() {
+			_, _ = p.GetSecret(ctx, key)
+		}()
+		<-previous.called
+
+		require.NoError(t, p.reload(ctx, time.Millisecond, logger, func() (secretsmanagerplugin.SecretsManagerPlugin, error) {
+			return reloaded, nil
+		}))
+		res, err := p.GetSecret(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, "reloaded", res.DecryptedValue)
+		close(previous.unblock)
+	})
+
+	t.Run("fails a second reload while the plugin is reloaded", func(t *testing.T) {
+		p := &reloadableSecretsPlugin{plugin: newFakeBlockingGRPCSecretsPlugin("previous")}
+		require.NoError(t, p.reload(ctx, time.Minute, logger, func() (secretsmanagerplugin.SecretsManagerPlugin, error) {
+			err := p.reload(ctx, time.Minute, logger, func() (secretsmanagerplugin.SecretsManagerPlugin, error) {
+				return nil, nil
+			})
+			assert.ErrorIs(t, err, ErrSecretsPluginReloadInProgress)
+			return p.plugin, nil
+		}))
+	})
+
+	t.Run("calls waiting for the reload fail when canceled", func(t *testing.T) {
+		p := &reloadableSecretsPlugin{plugin: newFakeBlockingGRPCSecretsPlugin("previous")}
+		require.NoError(t, p.reload(ctx, time.Minute, logger, func() (secretsmanagerplugin.SecretsManagerPlugin, error) {
+			ctx, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err := p.GetSecret(ctx, key)
+			assert.ErrorIs(t, err, context.Canceled)
+			return p.plugin, nil
+		}))
+	})
+}
+
+func setupPluginReloadService(t *testing.T, manager *fakePluginManager) *PluginReloadService {
+	t.Helper()
+	return &PluginReloadService{
+		manager:      manager,
+		drainTimeout: time.Minute,
+		log:          log.New("test.logger"),
+		readSettings: func() error { return nil },
+	}
+}
+
+// fakeBlockingGRPCSecretsPlugin returns its value from GetSecret once unblock is closed
+type fakeBlockingGRPCSecretsPlugin struct {
+	*fakeGRPCSecretsPlugin
+	value   string
+	called  chan struct{}
+	unblock chan struct{}
+}
+
+func newFakeBlockingGRPCSecretsPlugin(value string) *fakeBlockingGRPCSecretsPlugin {
+	return &fakeBlockingGRPCSecretsPlugin{
+		fakeGRPCSecretsPlugin: &fakeGRPCSecretsPlugin{},
+		value:                 value,
+		called:                make(chan struct{}, 1),
+		unblock:               make(chan struct{}),
+	}
+}
+
+func (c *fakeBlockingGRPCSecretsPlugin) GetSecret(ctx context.Context, in *secretsmanagerplugin.GetSecretRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.GetSecretResponse, error) {
+	select {
+	case c.called <- struct{}{}:
+	default:
+	}
+	<-c.unblock
+	return &secretsmanagerplugin.GetSecretResponse{DecryptedValue: c.value, Exists: true}, nil
+}
@@ -0,0 +1,154 @@
+package kvstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPluginRetries    = 3
+	defaultPluginRetryDelay = 100 * time.Millisecond
+)
+
+// retryingKVStore retries the operations of the plugin store that fail with a transient gRPC error, e.g. while the
+// plugin restarts. The first retry waits for delay, which doubles for every further retry.
+type retryingKVStore struct {
+	store   SecretsKVStore
+	retries int
+	delay   time.Duration
+	log     log.Logger
+}
+
+// withPluginRetries returns store retrying its operations `secrets.plugin_retries` times, or store as is when
+// the setting is 0
+func withPluginRetries(store SecretsKVStore, cfg *setting.Cfg, logger log.Logger) SecretsKVStore {
+	section := cfg.SectionWithEnvOverrides("secrets")
+	retries := section.Key("plugin_retries").MustInt(defaultPluginRetries)
+	if retries <= 0 {
+		return store
+	}
+	return &retryingKVStore{
+		store:   store,
+		retries: retries,
+		delay:   section.Key("plugin_retry_delay").MustDuration(defaultPluginRetryDelay),
+		log:     logger,
+	}
+}
+
+// isTransientPluginError returns whether the plugin may succeed if the call is made again. The errors of calls that
+// may have reached the plugin, like timeouts, are not transient, as the operations aren't all idempotent.
+func isTransientPluginError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// retry calls fn until it succeeds, fails with an error that is not transient, or was retried kv.retries times
+func (kv *retryingKVStore) retry(ctx context.Context, operation string, fn func() error) error {
+	delay := kv.delay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == kv.retries || !isTransientPluginError(err) {
+			return err
+		}
+		kv.log.Debug("secrets manager plugin operation failed, retrying", "operation", operation, "attempt", attempt+1, "delay", delay, "error", err)
+		pluginRetriesCounter.WithLabelValues(operation).Inc()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (kv *retryingKVStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (value string, found bool, err error) {
+	err = kv.retry(ctx, operationGet, func() (err error) {
+		value, found, err = kv.store.Get(ctx, orgId, namespace, typ)
+		return err
+	})
+	return value, found, err
+}
+
+func (kv *retryingKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	return kv.retry(ctx, operationSet, func() error {
+		return kv.store.Set(ctx, orgId, namespace, typ, value)
+	})
+}
+
+func (kv *retryingKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	return kv.retry(ctx, operationSetWithTTL, func() error {
+		return kv.store.SetWithTTL(ctx, orgId, namespace, typ, value, ttl)
+	})
+}
+
+func (kv *retryingKVStore) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	return kv.retry(ctx, operationDelete, func() error {
+		return kv.store.Del(ctx, orgId, namespace, typ)
+	})
+}
+
+func (kv *retryingKVStore) Keys(ctx context.Context, orgId int64, namespace string, typ string) (keys []Key, err error) {
+	err = kv.retry(ctx, operationKeys, func() (err error) {
+		keys, err = kv.store.Keys(ctx, orgId, namespace, typ)
+		return err
+	})
+	return keys, err
+}
+
+func (kv *retryingKVStore) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) (keys []Key, err error) {
+	err = kv.retry(ctx, operationListKeys, func() (err error) {
+		keys, err = kv.store.ListKeys(ctx, orgId, namespacePattern, typeFilter)
+		return err
+	})
+	return keys, err
+}
+
+func (kv *retryingKVStore) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	return kv.retry(ctx, operationRename, func() error {
+		return kv.store.Rename(ctx, orgId, namespace, typ, newNamespace)
+	})
+}
+
+func (kv *retryingKVStore) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (value string, found bool, err error) {
+	err = kv.retry(ctx, operationGetVersion, func() (err error) {
+		value, found, err = kv.store.GetVersion(ctx, orgId, namespace, typ, version)
+		return err
+	})
+	return value, found, err
+}
+
+func (kv *retryingKVStore) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) (versions []SecretVersion, err error) {
+	err = kv.retry(ctx, operationListVersions, func() (err error) {
+		versions, err = kv.store.ListVersions(ctx, orgId, namespace, typ)
+		return err
+	})
+	return versions, err
+}
+
+func (kv *retryingKVStore) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	return kv.retry(ctx, operationRollback, func() error {
+		return kv.store.Rollback(ctx, orgId, namespace, typ, version)
+	})
+}
+
+// SetMultiple retries the whole batch, setting again the items that were set is harmless
+func (kv *retryingKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	return kv.retry(ctx, operationSetMultiple, func() error {
+		return kv.store.SetMultiple(ctx, items)
+	})
+}
+
+// DelMultiple retries the whole batch, deleting again the items that were deleted is harmless
+func (kv *retryingKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	return kv.retry(ctx, operationDelMultiple, func() error {
+		return kv.store.DelMultiple(ctx, keys)
+	})
+}
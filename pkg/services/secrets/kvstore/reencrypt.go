@@ -0,0 +1,302 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	ReEncryptionCheckpointKey = "reencryption_checkpoint"
+
+	defaultReEncryptionInterval  = 10 * time.Minute
+	defaultReEncryptionBatchSize = 100
+)
+
+// the tables walked by the re-encryption, in order
+var reEncryptionTables = []string{"secrets", "secrets_history"}
+
+// reEncryptionCheckpoint is the progress of the re-encryption. DataKeys are the disabled data keys the
+// re-encryption retires, it starts over when another rotation disables more keys.
+type reEncryptionCheckpoint struct {
+	DataKeys  []string `json:"dataKeys"`
+	Table     string   `json:"table"`
+	LastId    int64    `json:"lastId"`
+	Completed bool     `json:"completed"`
+}
+
+// DataKeyReEncryptionService encrypts again, with the current data key of their organization, the values of the
+// sql store and their previous versions that are still encrypted with data keys disabled by a rotation, so that
+// the disabled data keys can be retired. The secrets are re-encrypted in batches, and the progress is
+// checkpointed in the kvstore so that a restarted instance resumes where it stopped.
+type DataKeyReEncryptionService struct {
+	store        *secretsKVStoreSQL
+	secretsStore secrets.Store
+	kvstore      *kvstore.NamespacedKVStore
+	serverLock   *serverlock.ServerLockService
+	interval     time.Duration
+	batchSize    int
+	log          log.Logger
+}
+
+func ProvideDataKeyReEncryptionService(
+	sqlStore sqlstore.Store,
+	secretsService secrets.Service,
+	secretsStore secrets.Store,
+	kv kvstore.KVStore,
+	serverLockService *serverlock.ServerLockService,
+	cfg *setting.Cfg,
+) *DataKeyReEncryptionService {
+	logger := log.New("secrets.kvstore.reencryption")
+	section := cfg.SectionWithEnvOverrides("secrets")
+	return &DataKeyReEncryptionService{
+		store: &secretsKVStoreSQL{
+			sqlStore:        sqlStore,
+			secretsService:  secretsService,
+			log:             logger,
+			decryptionCache: newDecryptionCache(defaultDecryptionCacheMaxEntries, defaultDecryptionCacheTTL),
+			clock:           clock.New(),
+		},
+		secretsStore: secretsStore,
+		kvstore:      GetNamespacedKVStore(kv),
+		serverLock:   serverLockService,
+		interval:     section.Key("reencryption_interval").MustDuration(defaultReEncryptionInterval),
+		batchSize:    section.Key("reencryption_batch_size").MustInt(defaultReEncryptionBatchSize),
+		log:          logger,
+	}
+}
+
+func (s *DataKeyReEncryptionService) IsDisabled() bool {
+	return s.interval <= 0
+}
+
+func (s *DataKeyReEncryptionService) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.serverLock.LockAndExecute(ctx, "re-encrypt secrets", s.interval/2, func(ctx context.Context) {
+				if err := s.ReEncrypt(ctx); err != nil {
+					s.log.Error("failed to re-encrypt secrets", "error", err)
+				}
+			})
+			if err != nil {
+				s.log.Error("failed to lock and execute secrets re-encryption", "error", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ReEncrypt re-encrypts the values encrypted with disabled data keys, from the last checkpoint. It returns
+// without reading the secrets when no data key was disabled since the last re-encryption completed.
+func (s *DataKeyReEncryptionService) ReEncrypt(ctx context.Context) error {
+	dataKeys, err := s.secretsStore.GetAllDataKeys(ctx)
+	if err != nil {
+		return err
+	}
+	disabled := make(map[string]bool)
+	for _, dataKey := range dataKeys {
+		if !dataKey.Active {
+			disabled[dataKey.Id] = true
+		}
+	}
+
+	checkpoint, err := s.getCheckpoint(ctx)
+	if err != nil {
+		return err
+	}
+	if checkpoint.Completed && containsDataKeys(checkpoint.DataKeys, disabled) {
+		return nil
+	}
+	if checkpoint.Completed || len(checkpoint.DataKeys) != len(disabled) || !containsDataKeys(checkpoint.DataKeys, disabled) {
+		checkpoint = reEncryptionCheckpoint{Table: reEncryptionTables[0]}
+		for id := range disabled {
+			checkpoint.DataKeys = append(checkpoint.DataKeys, id)
+		}
+		sort.Strings(checkpoint.DataKeys)
+	}
+	if len(disabled) == 0 {
+		checkpoint.Completed = true
+		return s.setCheckpoint(ctx, checkpoint)
+	}
+
+	s.log.Info("re-encrypting the secrets encrypted with disabled data keys", "dataKeys", len(disabled), "table", checkpoint.Table, "lastId", checkpoint.LastId)
+	var reEncrypted, failed int
+	start := 0
+	for i, table := range reEncryptionTables {
+		if table == checkpoint.Table {
+			start = i
+		}
+	}
+	for _, table := range reEncryptionTables[start:] {
+		if table != checkpoint.Table {
+			checkpoint.Table, checkpoint.LastId = table, 0
+		}
+		for {
+			count, failures, lastId, err := s.reEncryptBatch(ctx, table, checkpoint.LastId, func(payload []byte) bool {
+				id, ok := dataKeyId(payload)
+				return ok && disabled[id]
+			})
+			if err != nil {
+				return err
+			}
+			if lastId == checkpoint.LastId {
+				break
+			}
+			reEncrypted += count
+			failed += failures
+			checkpoint.LastId = lastId
+			if err := s.setCheckpoint(ctx, checkpoint); err != nil {
+				return err
+			}
+		}
+	}
+
+	if failed > 0 {
+		// the next run starts over, the values that were re-encrypted are skipped
+		s.log.Warn("secrets re-encrypted with errors", "reEncrypted", reEncrypted, "failed", failed)
+		return s.setCheckpoint(ctx, reEncryptionCheckpoint{})
+	}
+	checkpoint.Completed = true
+	if err := s.setCheckpoint(ctx, checkpoint); err != nil {
+		return err
+	}
+	s.log.Info("secrets re-encrypted, the disabled data keys are no longer used by the secrets store", "reEncrypted", reEncrypted, "dataKeys", len(disabled))
+	return nil
+}
+
+// reEncryptBatch re-encrypts the values of the batch of rows of table after lastId whose decoded payload matches.
+// It returns the number of values re-encrypted and that failed, and the id of the last row of the batch, lastId
+// when there is none.
+func (s *DataKeyReEncryptionService) reEncryptBatch(ctx context.Context, table string, lastId int64, matches func(payload []byte) bool) (int, int, int64, error) {
+	var rows []reEncryptionRow
+	err := s.store.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		return dbSession.Table(table).Cols("id", "org_id", "namespace", "type", "value").Where("id > ?", lastId).
+			OrderBy("id").Limit(s.batchSize).Find(&rows)
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, 0, lastId, err
+	}
+
+	var count, failures int
+	for _, row := range rows {
+		decoded, err := b64.DecodeString(row.Value)
+		if err != nil {
+			s.log.Warn("could not decode secret value while re-encrypting it", "table", table, "id", row.Id, "error", err)
+			failures++
+			continue
+		}
+		if !matches(decoded) {
+			continue
+		}
+		if err := s.reEncryptRow(ctx, table, row, decoded); err != nil {
+			s.log.Warn("could not re-encrypt secret value", "table", table, "id", row.Id, "error", err)
+			failures++
+			continue
+		}
+		count++
+	}
+	return count, failures, rows[len(rows)-1].Id, nil
+}
+
+// reEncryptionRow is a row of the secrets and secrets_history tables
+type reEncryptionRow struct {
+	Id        int64
+	OrgId     int64
+	Namespace string
+	Type      string
+	Value     string
+}
+
+// reEncryptRow encrypts again the value of a row with the current data key of its organization, in the v2 envelope
+// encryption format bound to the organization, namespace and type of the row
+func (s *DataKeyReEncryptionService) reEncryptRow(ctx context.Context, table string, row reEncryptionRow, decoded []byte) error {
+	aad := secrets.ContextAAD(row.OrgId, row.Namespace, row.Type)
+	decrypted, err := s.store.secretsService.DecryptWithAAD(ctx, decoded, aad)
+	if err != nil {
+		return err
+	}
+	scope := orgDataKeyScope(row.OrgId)
+	encrypted, err := s.store.secretsService.EncryptWithAAD(ctx, decrypted, aad, secrets.WithScope(scope))
+	if err != nil {
+		return err
+	}
+	return s.store.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		// the value is only replaced if it wasn't updated since it was read, the update encrypted it already
+		var err error
+		if table == "secrets" {
+			_, err = dbSession.Exec("UPDATE secrets SET value = ?, scope = ? WHERE id = ? AND value = ?",
+				b64.EncodeToString(encrypted), scope, row.Id, row.Value)
+		} else {
+			_, err = dbSession.Exec(fmt.Sprintf("UPDATE %s SET value = ? WHERE id = ? AND value = ?", table),
+				b64.EncodeToString(encrypted), row.Id, row.Value)
+		}
+		return err
+	})
+}
+
+func (s *DataKeyReEncryptionService) getCheckpoint(ctx context.Context) (reEncryptionCheckpoint, error) {
+	var checkpoint reEncryptionCheckpoint
+	value, exists, err := s.kvstore.Get(ctx, ReEncryptionCheckpointKey)
+	if err != nil || !exists {
+		return checkpoint, err
+	}
+	if err := json.Unmarshal([]byte(value), &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("invalid re-encryption checkpoint %q: %w", value, err)
+	}
+	return checkpoint, nil
+}
+
+func (s *DataKeyReEncryptionService) setCheckpoint(ctx context.Context, checkpoint reEncryptionCheckpoint) error {
+	value, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return s.kvstore.Set(ctx, ReEncryptionCheckpointKey, string(value))
+}
+
+// containsDataKeys returns whether every data key of keys is in ids
+func containsDataKeys(ids []string, keys map[string]bool) bool {
+	contained := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		contained[id] = true
+	}
+	for id := range keys {
+		if !contained[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// dataKeyId returns the id of the data key of a payload encrypted with envelope encryption: in both formats of
+// secrets.Service, the payload starts with the base64 encoded id of its data key between two delimiters
+func dataKeyId(payload []byte) (string, bool) {
+	if len(payload) == 0 || (payload[0] != secrets.EnvelopeV1Delimiter && payload[0] != secrets.EnvelopeV2Delimiter) {
+		return "", false
+	}
+	end := bytes.IndexByte(payload[1:], payload[0])
+	if end < 0 {
+		return "", false
+	}
+	id, err := b64.DecodeString(string(payload[1 : end+1]))
+	if err != nil {
+		return "", false
+	}
+	return string(id), true
+}
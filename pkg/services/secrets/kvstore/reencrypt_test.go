@@ -0,0 +1,71 @@
+package kvstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/secrets/database"
+	"github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestDataKeyReEncryptionService(t *testing.T) {
+	ctx := context.Background()
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsStore := database.ProvideSecretsStore(sqlStore)
+	secretsService := manager.SetupTestService(t, secretsStore)
+	svc := ProvideDataKeyReEncryptionService(sqlStore, secretsService, secretsStore, kvstore.ProvideService(sqlStore), nil, &setting.Cfg{Raw: ini.Empty()})
+	svc.batchSize = 1
+	kv := svc.store
+	kv.historyVersions = 2
+
+	require.NoError(t, kv.Set(ctx, 1, "ds1", "datasource", "old"))
+	require.NoError(t, kv.Set(ctx, 1, "ds1", "datasource", "secret1"))
+	require.NoError(t, kv.Set(ctx, 2, "ds2", "datasource", "secret2"))
+	require.NoError(t, svc.ReEncrypt(ctx))
+
+	rotated := dataKeysOfTables(t, sqlStore)
+	require.NotEmpty(t, rotated)
+	require.NoError(t, secretsService.RotateDataKeys(ctx))
+	require.NoError(t, svc.ReEncrypt(ctx))
+
+	for id := range dataKeysOfTables(t, sqlStore) {
+		assert.False(t, rotated[id], "value still encrypted with a disabled data key")
+	}
+	value, _, err := kv.Get(ctx, 1, "ds1", "datasource")
+	require.NoError(t, err)
+	assert.Equal(t, "secret1", value)
+	value, _, err = kv.GetVersion(ctx, 1, "ds1", "datasource", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "old", value)
+
+	checkpoint, err := svc.getCheckpoint(ctx)
+	require.NoError(t, err)
+	assert.True(t, checkpoint.Completed)
+	assert.Len(t, checkpoint.DataKeys, len(rotated))
+}
+
+// dataKeysOfTables returns the ids of the data keys encrypting the values of the re-encrypted tables
+func dataKeysOfTables(t *testing.T, sqlStore *sqlstore.SQLStore) map[string]bool {
+	t.Helper()
+	ids := make(map[string]bool)
+	for _, table := range reEncryptionTables {
+		var values []string
+		require.NoError(t, sqlStore.WithDbSession(context.Background(), func(dbSession *sqlstore.DBSession) error {
+			return dbSession.Table(table).Cols("value").Find(&values)
+		}))
+		for _, value := range values {
+			decoded, err := b64.DecodeString(value)
+			require.NoError(t, err)
+			id, ok := dataKeyId(decoded)
+			require.True(t, ok)
+			ids[id] = true
+		}
+	}
+	return ids
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/infra/log"
@@ -13,6 +15,8 @@ import (
 	"github.com/grafana/grafana/pkg/services/datasources"
 	"github.com/grafana/grafana/pkg/services/secrets"
 	"github.com/grafana/grafana/pkg/setting"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -22,6 +26,9 @@ var (
 	errPluginNotInstalled     = errors.New("remote secret managements plugin disabled because there is no installed plugin of type `secretsmanager`")
 )
 
+// pluginBatchSize is the number of secrets sent to the plugin in each SetSecrets and DeleteSecrets call
+const pluginBatchSize = 100
+
 // secretsKVStorePlugin provides a key/value store backed by the Grafana plugin gRPC interface
 type secretsKVStorePlugin struct {
 	log                            log.Logger
@@ -29,11 +36,25 @@ type secretsKVStorePlugin struct {
 	secretsService                 secrets.Service
 	kvstore                        *kvstore.NamespacedKVStore
 	backwardsCompatibilityDisabled bool
+	// breaker stops calling the plugin while it is unavailable, Get then reads from readCache
+	breaker   *pluginCircuitBreaker
+	readCache *pluginReadCache
+	// capabilities are the optional calls the plugin implements, nil when they are unknown. They are negotiated
+	// again when the plugin is reloaded.
+	capabilitiesMu sync.RWMutex
+	capabilities   *pluginCapabilities
+	// quarantine counts the crashes of the plugin, nil when it is disabled
+	quarantine *PluginQuarantineService
 }
 
-// Get an item from the store
+// Get an item from the store, or from the read cache while the plugin is unavailable
 // If it is the first time a secret has been retrieved and backwards compatibility is disabled, mark plugin startup errors fatal
 func (kv *secretsKVStorePlugin) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	key := Key{OrgId: orgId, Namespace: namespace, Type: typ}
+	if !kv.breaker.allow() {
+		return kv.getCached(key)
+	}
+
 	req := &smp.GetSecretRequest{
 		KeyDescriptor: &smp.Key{
 			OrgId:     orgId,
@@ -42,19 +63,58 @@ func (kv *secretsKVStorePlugin) Get(ctx context.Context, orgId int64, namespace
 		},
 	}
 	res, err := kv.secretsPlugin.GetSecret(ctx, req)
+	kv.breaker.record(err)
+	kv.quarantine.recordCall(err)
 	if err != nil {
+		if kv.breaker.isOpen() {
+			return kv.getCached(key)
+		}
 		return "", false, err
 	} else if res.UserFriendlyError != "" {
 		err = wrapUserFriendlySecretError(res.UserFriendlyError)
+	} else {
+		kv.readCache.set(key, res.DecryptedValue, res.Exists)
 	}
 
 	if res.Exists {
-		updateFatalFlag(ctx, *kv)
+		updateFatalFlag(ctx, kv.kvstore, kv.backwardsCompatibilityDisabled, kv.log)
 	}
 
 	return res.DecryptedValue, res.Exists, err
 }
 
+// Health checks that the plugin responds, by reading a secret that doesn't exist
+func (kv *secretsKVStorePlugin) Health(ctx context.Context) error {
+	return kv.callPlugin(func() error {
+		_, err := kv.secretsPlugin.GetSecret(ctx, &smp.GetSecretRequest{
+			KeyDescriptor: &smp.Key{OrgId: 0, Namespace: "grafana-health-check", Type: "health-check"},
+		})
+		return err
+	})
+}
+
+// getCached reads an item from the read cache while the circuit breaker is open
+func (kv *secretsKVStorePlugin) getCached(key Key) (string, bool, error) {
+	value, exists, ok := kv.readCache.get(key)
+	if !ok {
+		return "", false, ErrSecretsPluginUnavailable
+	}
+	pluginReadCacheFallbacksCounter.Inc()
+	kv.log.Debug("secrets manager plugin is unavailable, got secret value from read cache", "orgId", key.OrgId, "type", key.Type, "namespace", key.Namespace)
+	return value, exists, nil
+}
+
+// callPlugin calls the plugin unless the circuit breaker is open, and records the result of the call
+func (kv *secretsKVStorePlugin) callPlugin(call func() error) error {
+	if !kv.breaker.allow() {
+		return ErrSecretsPluginUnavailable
+	}
+	err := call()
+	kv.breaker.record(err)
+	kv.quarantine.recordCall(err)
+	return err
+}
+
 // Set an item in the store
 // If it is the first time a secret has been set and backwards compatibility is disabled, mark plugin startup errors fatal
 func (kv *secretsKVStorePlugin) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
@@ -67,16 +127,28 @@ func (kv *secretsKVStorePlugin) Set(ctx context.Context, orgId int64, namespace
 		Value: value,
 	}
 
-	res, err := kv.secretsPlugin.SetSecret(ctx, req)
+	var res *smp.SetSecretResponse
+	err := kv.callPlugin(func() (err error) {
+		res, err = kv.secretsPlugin.SetSecret(ctx, req)
+		return err
+	})
 	if err == nil && res.UserFriendlyError != "" {
 		err = wrapUserFriendlySecretError(res.UserFriendlyError)
 	}
+	if err == nil {
+		kv.readCache.set(Key{OrgId: orgId, Namespace: namespace, Type: typ}, value, true)
+	}
 
-	updateFatalFlag(ctx, *kv)
+	updateFatalFlag(ctx, kv.kvstore, kv.backwardsCompatibilityDisabled, kv.log)
 
 	return err
 }
 
+// SetWithTTL is not supported, the plugin API has no expiration
+func (kv *secretsKVStorePlugin) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	return ErrTTLNotSupported
+}
+
 // Del deletes an item from the store.
 func (kv *secretsKVStorePlugin) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
 	req := &smp.DeleteSecretRequest{
@@ -87,14 +159,116 @@ func (kv *secretsKVStorePlugin) Del(ctx context.Context, orgId int64, namespace
 		},
 	}
 
-	res, err := kv.secretsPlugin.DeleteSecret(ctx, req)
+	var res *smp.DeleteSecretResponse
+	err := kv.callPlugin(func() (err error) {
+		res, err = kv.secretsPlugin.DeleteSecret(ctx, req)
+		return err
+	})
 	if err == nil && res.UserFriendlyError != "" {
 		err = wrapUserFriendlySecretError(res.UserFriendlyError)
 	}
+	kv.readCache.delete(Key{OrgId: orgId, Namespace: namespace, Type: typ})
 
 	return err
 }
 
+// SetMultiple sets the items with one call to the plugin for every pluginBatchSize items. Plugins that don't
+// implement the batch calls get one call per item. The expiration of the items is ignored, as for SetWithTTL.
+func (kv *secretsKVStorePlugin) SetMultiple(ctx context.Context, items []Item) error {
+	if !kv.supports(pluginCapabilityBatch) {
+		return kv.setOneAtATime(ctx, items)
+	}
+	for start := 0; start < len(items); start += pluginBatchSize {
+		batch := items[start:minInt(start+pluginBatchSize, len(items))]
+		req := &smp.SetSecretsRequest{Items: make([]*smp.Item, 0, len(batch))}
+		for _, item := range batch {
+			req.Items = append(req.Items, &smp.Item{
+				Key: &smp.Key{
+					OrgId:     *item.OrgId,
+					Namespace: *item.Namespace,
+					Type:      *item.Type,
+				},
+				Value: item.Value,
+			})
+		}
+
+		var res *smp.SetSecretsResponse
+		err := kv.callPlugin(func() (err error) {
+			res, err = kv.secretsPlugin.SetSecrets(ctx, req)
+			return err
+		})
+		if status.Code(err) == codes.Unimplemented {
+			kv.log.Debug("secrets manager plugin does not implement SetSecrets, setting secrets one at a time")
+			err = kv.setOneAtATime(ctx, batch)
+		} else if err == nil && res.UserFriendlyError != "" {
+			err = wrapUserFriendlySecretError(res.UserFriendlyError)
+		}
+		if err != nil {
+			return err
+		}
+		for _, item := range batch {
+			kv.readCache.set(itemKey(item), item.Value, true)
+		}
+	}
+
+	if len(items) > 0 {
+		updateFatalFlag(ctx, kv.kvstore, kv.backwardsCompatibilityDisabled, kv.log)
+	}
+	return nil
+}
+
+// setOneAtATime sets the items with one call to the plugin per item, for the plugins that don't implement SetSecrets
+func (kv *secretsKVStorePlugin) setOneAtATime(ctx context.Context, items []Item) error {
+	for _, item := range items {
+		if err := kv.Set(ctx, *item.OrgId, *item.Namespace, *item.Type, item.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DelMultiple deletes the items with one call to the plugin for every pluginBatchSize items, see SetMultiple
+func (kv *secretsKVStorePlugin) DelMultiple(ctx context.Context, keys []Key) error {
+	if !kv.supports(pluginCapabilityBatch) {
+		return delEach(ctx, kv, keys)
+	}
+	for start := 0; start < len(keys); start += pluginBatchSize {
+		batch := keys[start:minInt(start+pluginBatchSize, len(keys))]
+		req := &smp.DeleteSecretsRequest{Keys: make([]*smp.Key, 0, len(batch))}
+		for _, key := range batch {
+			req.Keys = append(req.Keys, &smp.Key{
+				OrgId:     key.OrgId,
+				Namespace: key.Namespace,
+				Type:      key.Type,
+			})
+		}
+
+		var res *smp.DeleteSecretsResponse
+		err := kv.callPlugin(func() (err error) {
+			res, err = kv.secretsPlugin.DeleteSecrets(ctx, req)
+			return err
+		})
+		if status.Code(err) == codes.Unimplemented {
+			kv.log.Debug("secrets manager plugin does not implement DeleteSecrets, deleting secrets one at a time")
+			err = delEach(ctx, kv, batch)
+		} else if err == nil && res.UserFriendlyError != "" {
+			err = wrapUserFriendlySecretError(res.UserFriendlyError)
+		}
+		kv.readCache.delete(batch...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 // Keys get all keys for a given namespace. To query for all
 // organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
 func (kv *secretsKVStorePlugin) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
@@ -107,7 +281,11 @@ func (kv *secretsKVStorePlugin) Keys(ctx context.Context, orgId int64, namespace
 		AllOrganizations: orgId == AllOrganizations,
 	}
 
-	res, err := kv.secretsPlugin.ListSecrets(ctx, req)
+	var res *smp.ListSecretsResponse
+	err := kv.callPlugin(func() (err error) {
+		res, err = kv.secretsPlugin.ListSecrets(ctx, req)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	} else if res.UserFriendlyError != "" {
@@ -117,8 +295,65 @@ func (kv *secretsKVStorePlugin) Keys(ctx context.Context, orgId int64, namespace
 	return parseKeys(res.Keys), err
 }
 
-// Rename an item in the store
+// ListKeys get the keys whose namespace matches namespacePattern and whose type is typeFilter, see SecretsKVStore.
+// Plugins that don't implement ListSecretKeys are asked for the keys of a single namespace and type when the
+// pattern selects one, and for all their secrets otherwise.
+func (kv *secretsKVStorePlugin) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	if !kv.supports(pluginCapabilityListKeys) {
+		return kv.listKeysWithoutPattern(ctx, orgId, namespacePattern, typeFilter)
+	}
+	req := &smp.ListSecretKeysRequest{
+		OrgId:            orgId,
+		AllOrganizations: orgId == AllOrganizations,
+		NamespacePattern: namespacePattern,
+		Type:             typeFilter,
+	}
+
+	var res *smp.ListSecretsResponse
+	err := kv.callPlugin(func() (err error) {
+		res, err = kv.secretsPlugin.ListSecretKeys(ctx, req)
+		return err
+	})
+	if status.Code(err) == codes.Unimplemented {
+		kv.log.Debug("secrets manager plugin does not implement ListSecretKeys")
+		return kv.listKeysWithoutPattern(ctx, orgId, namespacePattern, typeFilter)
+	}
+	if err != nil {
+		return nil, err
+	} else if res.UserFriendlyError != "" {
+		err = wrapUserFriendlySecretError(res.UserFriendlyError)
+	}
+
+	return parseKeys(res.Keys), err
+}
+
+// listKeysWithoutPattern lists the keys for the plugins that don't implement ListSecretKeys, see ListKeys
+func (kv *secretsKVStorePlugin) listKeysWithoutPattern(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	if isExactKeyPattern(namespacePattern, typeFilter) {
+		return kv.Keys(ctx, orgId, namespacePattern, typeFilter)
+	}
+	kv.log.Debug("listing the keys of all secrets of the secrets manager plugin")
+	var keys []Key
+	err := kv.StreamAll(ctx, func(items []Item) error {
+		for _, item := range items {
+			if key := itemKey(item); matchKey(key, orgId, namespacePattern, typeFilter) {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Rename an item in the store. Plugins that don't implement RenameSecret get the item set under the new namespace,
+// then deleted.
 func (kv *secretsKVStorePlugin) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	if !kv.supports(pluginCapabilityRename) {
+		return kv.renameBySetting(ctx, orgId, namespace, typ, newNamespace)
+	}
 	req := &smp.RenameSecretRequest{
 		KeyDescriptor: &smp.Key{
 			OrgId:     orgId,
@@ -128,14 +363,116 @@ func (kv *secretsKVStorePlugin) Rename(ctx context.Context, orgId int64, namespa
 		NewNamespace: newNamespace,
 	}
 
-	res, err := kv.secretsPlugin.RenameSecret(ctx, req)
+	var res *smp.RenameSecretResponse
+	err := kv.callPlugin(func() (err error) {
+		res, err = kv.secretsPlugin.RenameSecret(ctx, req)
+		return err
+	})
+	if status.Code(err) == codes.Unimplemented {
+		kv.log.Debug("secrets manager plugin does not implement RenameSecret, setting the secret under the new namespace")
+		return kv.renameBySetting(ctx, orgId, namespace, typ, newNamespace)
+	}
 	if err == nil && res.UserFriendlyError != "" {
 		err = wrapUserFriendlySecretError(res.UserFriendlyError)
 	}
+	kv.readCache.delete(Key{OrgId: orgId, Namespace: namespace, Type: typ}, Key{OrgId: orgId, Namespace: newNamespace, Type: typ})
 
 	return err
 }
 
+// renameBySetting renames an item for the plugins that don't implement RenameSecret, see Rename
+func (kv *secretsKVStorePlugin) renameBySetting(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	value, exists, err := kv.Get(ctx, orgId, namespace, typ)
+	if err != nil || !exists {
+		return err
+	}
+	if err := kv.Set(ctx, orgId, newNamespace, typ, value); err != nil {
+		return err
+	}
+	return kv.Del(ctx, orgId, namespace, typ)
+}
+
+// GetVersion is not supported, the plugin API only keeps the current value of secrets
+func (kv *secretsKVStorePlugin) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	return "", false, ErrVersioningNotSupported
+}
+
+// ListVersions is not supported, see GetVersion
+func (kv *secretsKVStorePlugin) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	return nil, ErrVersioningNotSupported
+}
+
+// Rollback is not supported, see GetVersion
+func (kv *secretsKVStorePlugin) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	return ErrVersioningNotSupported
+}
+
+// GetAll returns all the items of the store. This is not part of the kvstore interface as we
+// only need it for migration from plugin to sql at this moment
+func (kv *secretsKVStorePlugin) GetAll(ctx context.Context) ([]Item, error) {
+	var res *smp.GetAllSecretsResponse
+	err := kv.callPlugin(func() (err error) {
+		res, err = kv.secretsPlugin.GetAllSecrets(ctx, &smp.GetAllSecretsRequest{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	} else if res.UserFriendlyError != "" {
+		err = wrapUserFriendlySecretError(res.UserFriendlyError)
+	}
+
+	return parseItems(res.Items), err
+}
+
+// StreamAll calls fn with the items of the store, in the batches of pluginBatchSize items streamed by the plugin, so
+// that the items of large stores don't have to fit in a single response. Plugins that don't implement
+// StreamAllSecrets return all their items at once, with GetAllSecrets. The stream stops when fn returns an error.
+func (kv *secretsKVStorePlugin) StreamAll(ctx context.Context, fn func(items []Item) error) error {
+	if !kv.supports(pluginCapabilityStreaming) {
+		return kv.getAllAtOnce(ctx, fn)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var stream smp.SecretsManager_StreamAllSecretsClient
+	err := kv.callPlugin(func() (err error) {
+		stream, err = kv.secretsPlugin.StreamAllSecrets(ctx, &smp.StreamAllSecretsRequest{BatchSize: pluginBatchSize})
+		return err
+	})
+	received := false
+	for err == nil {
+		var res *smp.GetAllSecretsResponse
+		if res, err = stream.Recv(); err != nil {
+			break
+		}
+		received = true
+		if res.UserFriendlyError != "" {
+			return wrapUserFriendlySecretError(res.UserFriendlyError)
+		}
+		if err := fn(parseItems(res.Items)); err != nil {
+			return err
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	// the status of a stream is only known once the first message is received
+	if !received && status.Code(err) == codes.Unimplemented {
+		kv.log.Debug("secrets manager plugin does not implement StreamAllSecrets, getting all secrets at once")
+		return kv.getAllAtOnce(ctx, fn)
+	}
+	return err
+}
+
+// getAllAtOnce calls fn with all the items of the store, for the plugins that don't implement StreamAllSecrets
+func (kv *secretsKVStorePlugin) getAllAtOnce(ctx context.Context, fn func(items []Item) error) error {
+	items, err := kv.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	return fn(items)
+}
+
 func parseKeys(keys []*smp.Key) []Key {
 	var newKeys []Key
 
@@ -147,7 +484,22 @@ func parseKeys(keys []*smp.Key) []Key {
 	return newKeys
 }
 
-func updateFatalFlag(ctx context.Context, skv secretsKVStorePlugin) {
+func parseItems(items []*smp.Item) []Item {
+	var newItems []Item
+
+	for _, i := range items {
+		key := i.Key
+		if key == nil {
+			key = &smp.Key{}
+		}
+		newItem := Item{OrgId: &key.OrgId, Namespace: &key.Namespace, Type: &key.Type, Value: i.Value}
+		newItems = append(newItems, newItem)
+	}
+
+	return newItems
+}
+
+func updateFatalFlag(ctx context.Context, kv *kvstore.NamespacedKVStore, backwardsCompatibilityDisabled bool, logger log.Logger) {
 	// This function makes the most sense in here because it handles all possible scenarios:
 	//   - User changed backwards compatibility flag, so we have to migrate secrets either to or from the plugin (get or set)
 	//   - Migration is on, so we migrate secrets to the plugin (set)
@@ -156,13 +508,13 @@ func updateFatalFlag(ctx context.Context, skv secretsKVStorePlugin) {
 	// Very early on. Once backwards compatibility to legacy secrets is gone in Grafana 10, this can go away as well
 	fatalFlagOnce.Do(func() {
 		var err error
-		if isFatal, _ := isPluginStartupErrorFatal(ctx, skv.kvstore); !isFatal && skv.backwardsCompatibilityDisabled {
-			err = setPluginStartupErrorFatal(ctx, skv.kvstore, true)
-		} else if isFatal && !skv.backwardsCompatibilityDisabled {
-			err = setPluginStartupErrorFatal(ctx, skv.kvstore, false)
+		if isFatal, _ := isPluginStartupErrorFatal(ctx, kv); !isFatal && backwardsCompatibilityDisabled {
+			err = setPluginStartupErrorFatal(ctx, kv, true)
+		} else if isFatal && !backwardsCompatibilityDisabled {
+			err = setPluginStartupErrorFatal(ctx, kv, false)
 		}
 		if err != nil {
-			skv.log.Error("failed to set plugin error fatal flag", err.Error())
+			logger.Error("failed to set plugin error fatal flag", err.Error())
 		}
 	})
 }
@@ -187,7 +539,9 @@ func setPluginStartupErrorFatal(ctx context.Context, kvstore *kvstore.Namespaced
 	if !isFatal {
 		return kvstore.Del(ctx, QuitOnPluginStartupFailureKey)
 	}
-	return kvstore.Set(ctx, QuitOnPluginStartupFailureKey, "true")
+	// set only once, so that concurrent instances don't overwrite each other's flag
+	_, err := kvstore.CAS(ctx, QuitOnPluginStartupFailureKey, "", "true")
+	return err
 }
 
 func EvaluateRemoteSecretsPlugin(mg plugins.SecretsPluginManager, cfg *setting.Cfg) error {
@@ -0,0 +1,345 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/plugins/backendplugin/secretsmanagerplugin"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/ini.v1"
+)
+
+func TestSecretsKVStorePlugin_Multiple(t *testing.T) {
+	ctx := context.Background()
+
+	items := make([]Item, 0, pluginBatchSize+1)
+	keys := make([]Key, 0, pluginBatchSize+1)
+	for i := 0; i <= pluginBatchSize; i++ {
+		orgId, namespace, typ := int64(1), fmt.Sprintf("ds%d", i), "datasource"
+		items = append(items, Item{OrgId: &orgId, Namespace: &namespace, Type: &typ, Value: "secret"})
+		keys = append(keys, Key{OrgId: orgId, Namespace: namespace, Type: typ})
+	}
+
+	t.Run("sends the items in batches", func(t *testing.T) {
+		secretsPlugin := &fakeBatchGRPCSecretsPlugin{fakeMemoryGRPCSecretsPlugin: newFakeMemoryGRPCSecretsPlugin()}
+		kv := setupPluginStore(t, secretsPlugin)
+
+		require.NoError(t, kv.SetMultiple(ctx, items))
+		assert.Equal(t, 2, secretsPlugin.setCalls)
+		assert.Len(t, secretsPlugin.secrets, pluginBatchSize+1)
+
+		require.NoError(t, kv.DelMultiple(ctx, keys))
+		assert.Empty(t, secretsPlugin.secrets)
+	})
+
+	t.Run("calls the plugin for each item when the batch calls are not implemented", func(t *testing.T) {
+		secretsPlugin := newFakeMemoryGRPCSecretsPlugin()
+		kv := setupPluginStore(t, &fakeBatchGRPCSecretsPlugin{fakeMemoryGRPCSecretsPlugin: secretsPlugin, unimplemented: true})
+
+		require.NoError(t, kv.SetMultiple(ctx, items))
+		assert.Len(t, secretsPlugin.secrets, pluginBatchSize+1)
+
+		secretsPlugin.deleteError = "plugin is read only"
+		require.Error(t, kv.DelMultiple(ctx, keys))
+		secretsPlugin.deleteError = ""
+		require.NoError(t, kv.DelMultiple(ctx, keys))
+		assert.Empty(t, secretsPlugin.secrets)
+	})
+}
+
+func TestSecretsKVStorePlugin_StreamAll(t *testing.T) {
+	ctx := context.Background()
+	secretsPlugin := &fakeBatchGRPCSecretsPlugin{fakeMemoryGRPCSecretsPlugin: newFakeMemoryGRPCSecretsPlugin()}
+	for i := 0; i <= pluginBatchSize; i++ {
+		secretsPlugin.secrets[buildKey(1, fmt.Sprintf("ds%d", i), "datasource")] = "secret"
+	}
+	kv := setupPluginStore(t, secretsPlugin)
+
+	streamAll := func(t *testing.T) []int {
+		t.Helper()
+		var batches []int
+		require.NoError(t, kv.StreamAll(ctx, func(items []Item) error {
+			batches = append(batches, len(items))
+			return nil
+		}))
+		return batches
+	}
+
+	t.Run("streams the items in batches", func(t *testing.T) {
+		assert.Equal(t, []int{pluginBatchSize, 1}, streamAll(t))
+	})
+
+	t.Run("gets all the items at once when streaming is not implemented", func(t *testing.T) {
+		secretsPlugin.unimplemented = true
+		defer func() { secretsPlugin.unimplemented = false }()
+		assert.Equal(t, []int{pluginBatchSize + 1}, streamAll(t))
+	})
+
+	t.Run("stops the stream when the callback fails", func(t *testing.T) {
+		batches := 0
+		err := kv.StreamAll(ctx, func(items []Item) error {
+			batches++
+			return errors.New("failed to store the items")
+		})
+		require.Error(t, err)
+		assert.Equal(t, 1, batches)
+	})
+}
+
+func TestSecretsKVStorePlugin_Capabilities(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New("test.logger")
+
+	t.Run("capabilities are unknown when the plugin does not negotiate them", func(t *testing.T) {
+		capabilities := negotiatePluginCapabilities(ctx, newFakeMemoryGRPCSecretsPlugin(), logger)
+		assert.Nil(t, capabilities)
+		assert.True(t, capabilities.supports(pluginCapabilityBatch))
+	})
+
+	t.Run("the optional calls the plugin does not support are not made", func(t *testing.T) {
+		secretsPlugin := &fakeBatchGRPCSecretsPlugin{fakeMemoryGRPCSecretsPlugin: newFakeMemoryGRPCSecretsPlugin(), unimplemented: true}
+		secretsPlugin.secrets[buildKey(1, "ds1", "datasource")] = "secret"
+		kv := setupPluginStore(t, secretsPlugin)
+		kv.capabilities = negotiatePluginCapabilities(ctx, secretsPlugin, logger)
+		require.NotNil(t, kv.capabilities)
+		assert.Equal(t, int32(pluginProtocolVersion), kv.capabilities.protocolVersion)
+		assert.False(t, kv.capabilities.supports(pluginCapabilityBatch))
+		assert.True(t, kv.capabilities.supports(pluginCapabilityStreaming))
+
+		orgId, namespace, typ := int64(1), "ds2", "datasource"
+		require.NoError(t, kv.SetMultiple(ctx, []Item{{OrgId: &orgId, Namespace: &namespace, Type: &typ, Value: "other"}}))
+		assert.Zero(t, secretsPlugin.setCalls)
+
+		require.NoError(t, kv.Rename(ctx, 1, "ds1", "datasource", "ds3"))
+		assert.Equal(t, map[Key]string{buildKey(1, "ds2", "datasource"): "other", buildKey(1, "ds3", "datasource"): "secret"}, secretsPlugin.secrets)
+	})
+}
+
+func TestSecretsKVStorePlugin_Retries(t *testing.T) {
+	ctx := context.Background()
+	secretsPlugin := &fakeUnavailableGRPCSecretsPlugin{
+		fakeBatchGRPCSecretsPlugin: &fakeBatchGRPCSecretsPlugin{fakeMemoryGRPCSecretsPlugin: newFakeMemoryGRPCSecretsPlugin()},
+	}
+	raw, err := ini.Load([]byte(`
+		[secrets]
+		plugin_retries = 2
+		plugin_retry_delay = 1ms
+		`))
+	require.NoError(t, err)
+	kv := withPluginRetries(setupPluginStore(t, secretsPlugin), &setting.Cfg{Raw: raw}, log.New("test.logger"))
+
+	t.Run("retries the operations while the plugin is unavailable", func(t *testing.T) {
+		secretsPlugin.unavailableCalls = 2
+		require.NoError(t, kv.Set(ctx, 1, "ds1", "datasource", "secret"))
+		assert.Equal(t, 3, secretsPlugin.calls)
+		assert.Equal(t, "secret", secretsPlugin.secrets[buildKey(1, "ds1", "datasource")])
+	})
+
+	t.Run("fails once the retries are exhausted", func(t *testing.T) {
+		secretsPlugin.calls = 0
+		secretsPlugin.unavailableCalls = 3
+		_, _, err := kv.Get(ctx, 1, "ds1", "datasource")
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Equal(t, 3, secretsPlugin.calls)
+	})
+
+	t.Run("does not retry the errors that are not transient", func(t *testing.T) {
+		secretsPlugin.calls = 0
+		secretsPlugin.deleteError = "plugin is read only"
+		defer func() { secretsPlugin.deleteError = "" }()
+		require.Error(t, kv.Del(ctx, 1, "ds1", "datasource"))
+		assert.Equal(t, 1, secretsPlugin.calls)
+	})
+
+	t.Run("is disabled with no retries", func(t *testing.T) {
+		raw, err := ini.Load([]byte(`
+			[secrets]
+			plugin_retries = 0
+			`))
+		require.NoError(t, err)
+		store := setupPluginStore(t, secretsPlugin)
+		assert.Same(t, store, withPluginRetries(store, &setting.Cfg{Raw: raw}, log.New("test.logger")))
+	})
+}
+
+func TestSecretsKVStorePlugin_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	secretsPlugin := &fakeUnavailableGRPCSecretsPlugin{
+		fakeBatchGRPCSecretsPlugin: &fakeBatchGRPCSecretsPlugin{fakeMemoryGRPCSecretsPlugin: newFakeMemoryGRPCSecretsPlugin()},
+	}
+	kv := setupPluginStore(t, secretsPlugin)
+	kv.breaker = &pluginCircuitBreaker{failures: 2, openDuration: time.Hour, log: log.New("test.logger")}
+	readCache, err := newPluginReadCache(time.Hour)
+	require.NoError(t, err)
+	kv.readCache = readCache
+
+	require.NoError(t, kv.Set(ctx, 1, "ds1", "datasource", "one"))
+	require.NoError(t, kv.Set(ctx, 1, "ds2", "datasource", "two"))
+	readCache.delete(Key{OrgId: 1, Namespace: "ds2", Type: "datasource"})
+	value, found, err := kv.Get(ctx, 1, "ds1", "datasource")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "one", value)
+
+	t.Run("fails the calls until the breaker opens", func(t *testing.T) {
+		secretsPlugin.unavailable = true
+		_, _, err := kv.Get(ctx, 1, "ds1", "datasource")
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.False(t, kv.breaker.isOpen())
+	})
+
+	t.Run("reads from the cache while the breaker is open", func(t *testing.T) {
+		trips := testutil.ToFloat64(pluginCircuitBreakerTripsCounter)
+		value, found, err := kv.Get(ctx, 1, "ds1", "datasource")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "one", value)
+		assert.True(t, kv.breaker.isOpen())
+		assert.Equal(t, float64(1), testutil.ToFloat64(pluginCircuitBreakerOpenGauge))
+		assert.Equal(t, trips+1, testutil.ToFloat64(pluginCircuitBreakerTripsCounter))
+
+		calls := secretsPlugin.calls
+		_, _, err = kv.Get(ctx, 1, "ds2", "datasource")
+		assert.ErrorIs(t, err, ErrSecretsPluginUnavailable)
+		assert.ErrorIs(t, kv.Set(ctx, 1, "ds1", "datasource", "new"), ErrSecretsPluginUnavailable)
+		assert.Equal(t, calls, secretsPlugin.calls)
+	})
+
+	t.Run("closes when the plugin responds again", func(t *testing.T) {
+		secretsPlugin.unavailable = false
+		kv.breaker.lastAttempt = time.Now().Add(-2 * time.Hour)
+
+		value, found, err := kv.Get(ctx, 1, "ds2", "datasource")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "two", value)
+		assert.False(t, kv.breaker.isOpen())
+		assert.Equal(t, float64(0), testutil.ToFloat64(pluginCircuitBreakerOpenGauge))
+	})
+
+	t.Run("user friendly errors are not failures", func(t *testing.T) {
+		secretsPlugin.deleteError = "plugin is read only"
+		for i := 0; i < 3; i++ {
+			require.Error(t, kv.Del(ctx, 1, "ds1", "datasource"))
+		}
+		secretsPlugin.deleteError = ""
+		assert.False(t, kv.breaker.isOpen())
+	})
+}
+
+func setupPluginStore(t *testing.T, secretsPlugin secretsmanagerplugin.SecretsManagerPlugin) *secretsKVStorePlugin {
+	t.Helper()
+	return &secretsKVStorePlugin{
+		secretsPlugin: secretsPlugin,
+		log:           log.New("test.logger"),
+		kvstore:       GetNamespacedKVStore(kvstore.ProvideService(sqlstore.InitTestDB(t))),
+	}
+}
+
+// fakeBatchGRPCSecretsPlugin adds the batch calls to the in memory plugin, or the per item calls they fall back to
+type fakeBatchGRPCSecretsPlugin struct {
+	*fakeMemoryGRPCSecretsPlugin
+	unimplemented bool
+	setCalls      int
+}
+
+func (c *fakeBatchGRPCSecretsPlugin) GetSecret(ctx context.Context, in *secretsmanagerplugin.GetSecretRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.GetSecretResponse, error) {
+	value, exists := c.secrets[buildKey(in.KeyDescriptor.OrgId, in.KeyDescriptor.Namespace, in.KeyDescriptor.Type)]
+	return &secretsmanagerplugin.GetSecretResponse{DecryptedValue: value, Exists: exists}, nil
+}
+
+func (c *fakeBatchGRPCSecretsPlugin) SetSecret(ctx context.Context, in *secretsmanagerplugin.SetSecretRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.SetSecretResponse, error) {
+	c.secrets[buildKey(in.KeyDescriptor.OrgId, in.KeyDescriptor.Namespace, in.KeyDescriptor.Type)] = in.Value
+	return &secretsmanagerplugin.SetSecretResponse{}, nil
+}
+
+func (c *fakeBatchGRPCSecretsPlugin) SetSecrets(ctx context.Context, in *secretsmanagerplugin.SetSecretsRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.SetSecretsResponse, error) {
+	if c.unimplemented {
+		return nil, status.Error(codes.Unimplemented, "method SetSecrets not implemented")
+	}
+	c.setCalls++
+	for _, item := range in.Items {
+		c.secrets[buildKey(item.Key.OrgId, item.Key.Namespace, item.Key.Type)] = item.Value
+	}
+	return &secretsmanagerplugin.SetSecretsResponse{}, nil
+}
+
+func (c *fakeBatchGRPCSecretsPlugin) DeleteSecrets(ctx context.Context, in *secretsmanagerplugin.DeleteSecretsRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.DeleteSecretsResponse, error) {
+	if c.unimplemented {
+		return c.fakeMemoryGRPCSecretsPlugin.DeleteSecrets(ctx, in, opts...)
+	}
+	for _, key := range in.Keys {
+		delete(c.secrets, buildKey(key.OrgId, key.Namespace, key.Type))
+	}
+	return &secretsmanagerplugin.DeleteSecretsResponse{}, nil
+}
+
+func (c *fakeBatchGRPCSecretsPlugin) StreamAllSecrets(ctx context.Context, in *secretsmanagerplugin.StreamAllSecretsRequest, opts ...grpc.CallOption) (secretsmanagerplugin.SecretsManager_StreamAllSecretsClient, error) {
+	if c.unimplemented {
+		// the status of a stream is returned with its first message
+		return &fakeStreamAllSecretsClient{err: status.Error(codes.Unimplemented, "method StreamAllSecrets not implemented")}, nil
+	}
+	return c.fakeMemoryGRPCSecretsPlugin.StreamAllSecrets(ctx, in, opts...)
+}
+
+// GetCapabilities advertises the streaming of the in memory plugin, and the batch calls unless they are unimplemented
+func (c *fakeBatchGRPCSecretsPlugin) GetCapabilities(ctx context.Context, in *secretsmanagerplugin.GetCapabilitiesRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.GetCapabilitiesResponse, error) {
+	res := &secretsmanagerplugin.GetCapabilitiesResponse{ProtocolVersion: in.ProtocolVersion, Capabilities: []string{pluginCapabilityStreaming}}
+	if !c.unimplemented {
+		res.Capabilities = append(res.Capabilities, pluginCapabilityBatch)
+	}
+	return res, nil
+}
+
+// fakeUnavailableGRPCSecretsPlugin reads the secrets of the in memory plugin, and fails every call when unavailable
+type fakeUnavailableGRPCSecretsPlugin struct {
+	*fakeBatchGRPCSecretsPlugin
+	unavailable bool
+	// the number of next calls that fail, as if the plugin was restarting
+	unavailableCalls int
+	calls            int
+}
+
+// fails records a call, and returns whether it fails because the plugin is unavailable
+func (c *fakeUnavailableGRPCSecretsPlugin) fails() bool {
+	c.calls++
+	if c.unavailableCalls > 0 {
+		c.unavailableCalls--
+		return true
+	}
+	return c.unavailable
+}
+
+func (c *fakeUnavailableGRPCSecretsPlugin) GetSecret(ctx context.Context, in *secretsmanagerplugin.GetSecretRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.GetSecretResponse, error) {
+	if c.fails() {
+		return nil, status.Error(codes.Unavailable, "plugin is not running")
+	}
+	value, exists := c.secrets[buildKey(in.KeyDescriptor.OrgId, in.KeyDescriptor.Namespace, in.KeyDescriptor.Type)]
+	return &secretsmanagerplugin.GetSecretResponse{DecryptedValue: value, Exists: exists}, nil
+}
+
+func (c *fakeUnavailableGRPCSecretsPlugin) SetSecret(ctx context.Context, in *secretsmanagerplugin.SetSecretRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.SetSecretResponse, error) {
+	if c.fails() {
+		return nil, status.Error(codes.Unavailable, "plugin is not running")
+	}
+	return c.fakeBatchGRPCSecretsPlugin.SetSecret(ctx, in, opts...)
+}
+
+func (c *fakeUnavailableGRPCSecretsPlugin) DeleteSecret(ctx context.Context, in *secretsmanagerplugin.DeleteSecretRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.DeleteSecretResponse, error) {
+	if c.fails() {
+		return nil, status.Error(codes.Unavailable, "plugin is not running")
+	}
+	return c.fakeBatchGRPCSecretsPlugin.DeleteSecret(ctx, in, opts...)
+}
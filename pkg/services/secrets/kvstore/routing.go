@@ -0,0 +1,614 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	SecretsRoutesKey = "secrets_routes"
+
+	// how long the namespace routes read from the kvstore are reused, so that the instances notice when another
+	// one moves a namespace
+	secretsRoutesRefreshInterval = 10 * time.Second
+)
+
+var (
+	ErrSecretsRoutingDisabled  = errors.New("secrets are not routed to several backends, set secrets.routes to route them")
+	ErrSecretsNamespaceMoving  = errors.New("secrets of the namespace are being moved to another backend, they can't be updated until the move completes")
+	ErrSecretsBackendNotRouted = errors.New("secrets backend is not used by the routes")
+	ErrInvalidSecretsNamespace = errors.New("a single namespace can be moved, it can't be empty or contain *")
+)
+
+// SecretsRoute routes the secrets whose namespace matches Namespace, in which * matches any characters, or whose
+// type is Type, to Backend. Routes are set with `secrets.routes`, e.g. `type:datasource=vault, namespace:plugin-*=sql`.
+type SecretsRoute struct {
+	Namespace string `json:"namespace,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Backend   string `json:"backend"`
+}
+
+// SecretsNamespaceRoute is the backend a namespace was moved to, it takes precedence over the routes of the
+// configuration
+type SecretsNamespaceRoute struct {
+	// Backend is empty until the first move of the namespace completes
+	Backend string `json:"backend,omitempty"`
+	// MovingTo is the backend the namespace is being moved to, empty when it is not being moved
+	MovingTo string    `json:"movingTo,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+// SecretsRoutes lists the backends of the secrets and how the secrets are routed to them
+type SecretsRoutes struct {
+	// Default is the backend of the secrets that no route matches
+	Default    string                           `json:"default"`
+	Backends   []string                         `json:"backends"`
+	Routes     []SecretsRoute                   `json:"routes"`
+	Namespaces map[string]SecretsNamespaceRoute `json:"namespaces"`
+}
+
+// SecretsNamespaceMove is the result of a move of a namespace to another backend
+type SecretsNamespaceMove struct {
+	Namespace string `json:"namespace"`
+	Backend   string `json:"backend"`
+	// Moved is the number of secrets moved, the secrets already in the backend are not counted
+	Moved int `json:"moved"`
+}
+
+// readSecretsRoutes parses `secrets.routes`, a comma separated list of `namespace:<pattern>=<backend>` and
+// `type:<type>=<backend>` routes, the first route matching a secret is used
+func readSecretsRoutes(cfg *setting.Cfg) ([]SecretsRoute, error) {
+	value := cfg.SectionWithEnvOverrides("secrets").Key("routes").MustString("")
+	var routes []SecretsRoute
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		selector, backend, ok := strings.Cut(part, "=")
+		backend = strings.TrimSpace(backend)
+		if !ok || backend == "" {
+			return nil, fmt.Errorf("invalid secrets route %q, expected <selector>=<backend>", part)
+		}
+		kind, match, ok := strings.Cut(strings.TrimSpace(selector), ":")
+		match = strings.TrimSpace(match)
+		if !ok || match == "" {
+			return nil, fmt.Errorf("invalid secrets route %q, expected namespace:<pattern> or type:<type> before =", part)
+		}
+		route := SecretsRoute{Backend: backend}
+		switch kind {
+		case "namespace":
+			route.Namespace = match
+		case "type":
+			route.Type = match
+		default:
+			return nil, fmt.Errorf("invalid secrets route %q, unknown selector %q", part, kind)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// SecretsRoutingService keeps the backends the namespaces were moved to, and moves the namespaces between the
+// backends of the routing. The namespace routes are recorded in the kvstore, so that they survive restarts and are
+// shared by the instances.
+type SecretsRoutingService struct {
+	kvstore   *kvstore.NamespacedKVStore
+	batchSize int
+	clock     clock.Clock
+	log       log.Logger
+
+	mu         sync.Mutex
+	namespaces map[string]SecretsNamespaceRoute
+	refreshed  time.Time
+	// store is the routing store in use, nil when the secrets are not routed
+	store *routingKVStore
+}
+
+func ProvideSecretsRoutingService(kv kvstore.KVStore, cfg *setting.Cfg, clk clock.Clock) *SecretsRoutingService {
+	batchSize := cfg.SectionWithEnvOverrides("secrets").Key("migration_batch_size").MustInt(100)
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &SecretsRoutingService{
+		kvstore:   GetNamespacedKVStore(kv),
+		batchSize: batchSize,
+		clock:     clk,
+		log:       log.New("secrets.kvstore.routing"),
+	}
+}
+
+// register makes the store the one whose namespaces are moved
+func (s *SecretsRoutingService) register(store *routingKVStore) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+}
+
+func (s *SecretsRoutingService) routingStore() *routingKVStore {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store
+}
+
+// Routes returns the backends of the secrets and how the secrets are routed to them
+func (s *SecretsRoutingService) Routes(ctx context.Context) (*SecretsRoutes, error) {
+	store := s.routingStore()
+	if store == nil {
+		return nil, ErrSecretsRoutingDisabled
+	}
+	namespaces, err := s.namespaceRoutes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	routes := &SecretsRoutes{
+		Default:    store.defaultBackend,
+		Backends:   store.backendNames(),
+		Routes:     append([]SecretsRoute{}, store.routes...),
+		Namespaces: make(map[string]SecretsNamespaceRoute, len(namespaces)),
+	}
+	for namespace, route := range namespaces {
+		routes.Namespaces[namespace] = route
+	}
+	return routes, nil
+}
+
+// namespaceRoutes returns the namespace routes, read from the kvstore at most every secretsRoutesRefreshInterval
+func (s *SecretsRoutingService) namespaceRoutes(ctx context.Context) (map[string]SecretsNamespaceRoute, error) {
+	if s == nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.refreshed.IsZero() && s.clock.Since(s.refreshed) < secretsRoutesRefreshInterval {
+		return s.namespaces, nil
+	}
+	value, exists, err := s.kvstore.Get(ctx, SecretsRoutesKey)
+	if err != nil {
+		return s.namespaces, err
+	}
+	namespaces := map[string]SecretsNamespaceRoute{}
+	if exists {
+		if err := json.Unmarshal([]byte(value), &namespaces); err != nil {
+			return s.namespaces, fmt.Errorf("invalid secrets namespace routes %q: %w", value, err)
+		}
+	}
+	s.namespaces = namespaces
+	s.refreshed = s.clock.Now()
+	return namespaces, nil
+}
+
+// namespaceRoute returns the route of the namespace, the previous routes are kept when they can't be read
+func (s *SecretsRoutingService) namespaceRoute(ctx context.Context, namespace string) (SecretsNamespaceRoute, bool) {
+	namespaces, err := s.namespaceRoutes(ctx)
+	if err != nil {
+		s.log.Warn("failed to read the secrets namespace routes", "error", err)
+	}
+	route, ok := namespaces[namespace]
+	return route, ok
+}
+
+// setNamespaceRoute records the route of the namespace in the kvstore
+func (s *SecretsRoutingService) setNamespaceRoute(ctx context.Context, namespace string, update func(route *SecretsNamespaceRoute)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	namespaces := map[string]SecretsNamespaceRoute{}
+	value, exists, err := s.kvstore.Get(ctx, SecretsRoutesKey)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := json.Unmarshal([]byte(value), &namespaces); err != nil {
+			return fmt.Errorf("invalid secrets namespace routes %q: %w", value, err)
+		}
+	}
+	route := namespaces[namespace]
+	update(&route)
+	route.Updated = s.clock.Now()
+	if route.Backend == "" && route.MovingTo == "" {
+		delete(namespaces, namespace)
+	} else {
+		namespaces[namespace] = route
+	}
+	encoded, err := json.Marshal(namespaces)
+	if err != nil {
+		return err
+	}
+	if err := s.kvstore.Set(ctx, SecretsRoutesKey, string(encoded)); err != nil {
+		return err
+	}
+	s.namespaces = namespaces
+	s.refreshed = s.clock.Now()
+	return nil
+}
+
+// Move moves the secrets of the namespace, of every organization and type, to the backend, which must be one of
+// the backends of the routing. The secrets are copied in batches of `secrets.migration_batch_size`, verified and
+// deleted from their previous backend once the namespace is routed to the new one. The secrets of the namespace
+// can't be updated during the move, the other instances notice it within secretsRoutesRefreshInterval. A move
+// interrupted by a restart is resumed by moving the namespace again.
+func (s *SecretsRoutingService) Move(ctx context.Context, namespace string, backend string) (*SecretsNamespaceMove, error) {
+	store := s.routingStore()
+	if store == nil {
+		return nil, ErrSecretsRoutingDisabled
+	}
+	if namespace == "" || strings.Contains(namespace, "*") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSecretsNamespace, namespace)
+	}
+	target, ok := store.backends[backend]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrSecretsBackendNotRouted, backend)
+	}
+	if route, ok := s.namespaceRoute(ctx, namespace); ok && route.MovingTo != "" && route.MovingTo != backend {
+		return nil, fmt.Errorf("namespace %q is being moved to %q: %w", namespace, route.MovingTo, ErrSecretsNamespaceMoving)
+	}
+
+	if err := s.setNamespaceRoute(ctx, namespace, func(route *SecretsNamespaceRoute) { route.MovingTo = backend }); err != nil {
+		return nil, err
+	}
+	s.log.Info("moving the secrets of the namespace", "namespace", namespace, "backend", backend)
+
+	moved, copied, err := s.copyNamespace(ctx, store, namespace, backend, target)
+	if err != nil {
+		s.log.Error("failed to move the secrets of the namespace", "namespace", namespace, "backend", backend, "error", err)
+		// the secrets copied so far are deleted, they would be stale once the namespace is updated again
+		if len(copied) > 0 {
+			if delErr := target.DelMultiple(ctx, copied); delErr != nil {
+				s.log.Warn("failed to delete the secrets copied by the move", "namespace", namespace, "backend", backend, "error", delErr)
+			}
+		}
+		if resetErr := s.setNamespaceRoute(ctx, namespace, func(route *SecretsNamespaceRoute) { route.MovingTo = "" }); resetErr != nil {
+			s.log.Error("failed to end the move of the namespace", "namespace", namespace, "error", resetErr)
+		}
+		return nil, err
+	}
+
+	if err := s.setNamespaceRoute(ctx, namespace, func(route *SecretsNamespaceRoute) {
+		route.Backend = backend
+		route.MovingTo = ""
+	}); err != nil {
+		return nil, err
+	}
+
+	// the namespace is routed to the backend, the secrets are deleted from their previous backends
+	for name, keys := range moved {
+		if err := store.backends[name].DelMultiple(ctx, keys); err != nil {
+			s.log.Warn("failed to delete the moved secrets from their previous backend", "namespace", namespace, "backend", name, "error", err)
+		}
+	}
+	total := 0
+	for _, keys := range moved {
+		total += len(keys)
+	}
+	s.log.Info("moved the secrets of the namespace", "namespace", namespace, "backend", backend, "number of secrets", total)
+	return &SecretsNamespaceMove{Namespace: namespace, Backend: backend, Moved: total}, nil
+}
+
+// copyNamespace copies the secrets of the namespace routed to the other backends to target, it returns the keys
+// copied from each backend, and all the keys copied to target
+func (s *SecretsRoutingService) copyNamespace(ctx context.Context, store *routingKVStore, namespace string, backend string, target SecretsKVStore) (map[string][]Key, []Key, error) {
+	moved := map[string][]Key{}
+	var copied []Key
+	for _, name := range store.backendNames() {
+		if name == backend {
+			continue
+		}
+		keys, err := store.namespaceKeys(ctx, name, namespace)
+		if err != nil {
+			return nil, copied, fmt.Errorf("failed to list the secrets of the namespace in %s: %w", name, err)
+		}
+		source := store.backends[name]
+		for start := 0; start < len(keys); start += s.batchSize {
+			end := start + s.batchSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+			batch := make([]Item, 0, end-start)
+			for _, key := range keys[start:end] {
+				key := key
+				value, found, err := source.Get(ctx, key.OrgId, key.Namespace, key.Type)
+				if err != nil {
+					return nil, copied, err
+				}
+				if !found {
+					// expired since the keys were listed
+					continue
+				}
+				batch = append(batch, Item{OrgId: &key.OrgId, Namespace: &key.Namespace, Type: &key.Type, Value: value})
+			}
+			if err := target.SetMultiple(ctx, batch); err != nil {
+				return nil, copied, err
+			}
+			for _, item := range batch {
+				copied = append(copied, itemKey(item))
+				value, found, err := target.Get(ctx, *item.OrgId, *item.Namespace, *item.Type)
+				if err != nil {
+					return nil, copied, err
+				}
+				if !found || value != item.Value {
+					return nil, copied, fmt.Errorf("secret read back from %s does not match: %s", backend, formatKeys([]Key{itemKey(item)}))
+				}
+				moved[name] = append(moved[name], itemKey(item))
+			}
+		}
+	}
+	return moved, copied, nil
+}
+
+// routingKVStore routes the secrets to several backends, by namespace or type with the routes of the
+// configuration, or by namespace once a namespace was moved to another backend. The secrets that no route matches
+// are in the default backend.
+type routingKVStore struct {
+	backends       map[string]SecretsKVStore
+	defaultBackend string
+	routes         []SecretsRoute
+	routing        *SecretsRoutingService
+}
+
+// withRouting returns store routed with the other backends of `secrets.routes` when routes are set, or store as is
+func withRouting(store SecretsKVStore, backend string, sqlStore SecretsKVStore, routes []SecretsRoute, newBackend func(name string) (SecretsKVStore, error), routing *SecretsRoutingService) (SecretsKVStore, error) {
+	if len(routes) == 0 {
+		return store, nil
+	}
+	backends := map[string]SecretsKVStore{backend: store}
+	if _, ok := backends[BackendSQL]; !ok {
+		backends[BackendSQL] = sqlStore
+	}
+	for _, route := range routes {
+		if _, ok := backends[route.Backend]; ok {
+			continue
+		}
+		routed, err := newBackend(route.Backend)
+		if err != nil {
+			return nil, fmt.Errorf("secrets backend %q of the routes is not available: %w", route.Backend, err)
+		}
+		backends[route.Backend] = routed
+	}
+	routed := &routingKVStore{
+		backends:       backends,
+		defaultBackend: backend,
+		routes:         routes,
+		routing:        routing,
+	}
+	routing.register(routed)
+	return routed, nil
+}
+
+func (kv *routingKVStore) backendNames() []string {
+	names := make([]string, 0, len(kv.backends))
+	for name := range kv.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// routeName returns the name of the backend of the secret
+func (kv *routingKVStore) routeName(ctx context.Context, namespace string, typ string) string {
+	if route, ok := kv.routing.namespaceRoute(ctx, namespace); ok && route.Backend != "" {
+		return route.Backend
+	}
+	for _, route := range kv.routes {
+		if (route.Type == "" || route.Type == typ) && (route.Namespace == "" || matchNamespace(route.Namespace, namespace)) {
+			return route.Backend
+		}
+	}
+	return kv.defaultBackend
+}
+
+// route returns the backend of the secret
+func (kv *routingKVStore) route(ctx context.Context, namespace string, typ string) (SecretsKVStore, error) {
+	name := kv.routeName(ctx, namespace, typ)
+	store, ok := kv.backends[name]
+	if !ok {
+		return nil, fmt.Errorf("namespace %q is routed to %q: %w", namespace, name, ErrSecretsBackendNotRouted)
+	}
+	return store, nil
+}
+
+// routeUpdate returns the backend of the secret to update, it fails while the namespace is moved
+func (kv *routingKVStore) routeUpdate(ctx context.Context, namespace string, typ string) (SecretsKVStore, error) {
+	if route, ok := kv.routing.namespaceRoute(ctx, namespace); ok && route.MovingTo != "" {
+		return nil, ErrSecretsNamespaceMoving
+	}
+	return kv.route(ctx, namespace, typ)
+}
+
+// namespaceKeys returns the keys of the secrets of the namespace in the backend that are routed to it. The backends
+// that can't list the keys of a namespace are listed for the types of the routes and of the data sources.
+func (kv *routingKVStore) namespaceKeys(ctx context.Context, name string, namespace string) ([]Key, error) {
+	keys, err := kv.backends[name].ListKeys(ctx, AllOrganizations, namespace, "")
+	if errors.Is(err, ErrKeyListingNotSupported) {
+		keys = nil
+		types := map[string]bool{DataSourceSecretType: true}
+		for _, route := range kv.routes {
+			if route.Type != "" {
+				types[route.Type] = true
+			}
+		}
+		for typ := range types {
+			typeKeys, err := kv.backends[name].Keys(ctx, AllOrganizations, namespace, typ)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, typeKeys...)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return kv.routedKeys(ctx, name, keys), nil
+}
+
+// routedKeys returns the keys that are routed to the backend, the others are left behind by a move
+func (kv *routingKVStore) routedKeys(ctx context.Context, name string, keys []Key) []Key {
+	routed := make([]Key, 0, len(keys))
+	for _, key := range keys {
+		if kv.routeName(ctx, key.Namespace, key.Type) == name {
+			routed = append(routed, key)
+		}
+	}
+	return routed
+}
+
+func (kv *routingKVStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	store, err := kv.route(ctx, namespace, typ)
+	if err != nil {
+		return "", false, err
+	}
+	return store.Get(ctx, orgId, namespace, typ)
+}
+
+func (kv *routingKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	store, err := kv.routeUpdate(ctx, namespace, typ)
+	if err != nil {
+		return err
+	}
+	return store.Set(ctx, orgId, namespace, typ, value)
+}
+
+func (kv *routingKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	store, err := kv.routeUpdate(ctx, namespace, typ)
+	if err != nil {
+		return err
+	}
+	return store.SetWithTTL(ctx, orgId, namespace, typ, value, ttl)
+}
+
+func (kv *routingKVStore) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	store, err := kv.routeUpdate(ctx, namespace, typ)
+	if err != nil {
+		return err
+	}
+	return store.Del(ctx, orgId, namespace, typ)
+}
+
+func (kv *routingKVStore) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	store, err := kv.route(ctx, namespace, typ)
+	if err != nil {
+		return nil, err
+	}
+	return store.Keys(ctx, orgId, namespace, typ)
+}
+
+// ListKeys lists the keys of every backend, the backends that can only list the keys of a namespace and type fail
+// the other patterns
+func (kv *routingKVStore) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	if isExactKeyPattern(namespacePattern, typeFilter) {
+		return kv.Keys(ctx, orgId, namespacePattern, typeFilter)
+	}
+	var keys []Key
+	for _, name := range kv.backendNames() {
+		backendKeys, err := kv.backends[name].ListKeys(ctx, orgId, namespacePattern, typeFilter)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, kv.routedKeys(ctx, name, backendKeys)...)
+	}
+	return keys, nil
+}
+
+// Rename renames the secret in its backend, or moves it when the new namespace is routed to another backend
+func (kv *routingKVStore) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	store, err := kv.routeUpdate(ctx, namespace, typ)
+	if err != nil {
+		return err
+	}
+	newStore, err := kv.routeUpdate(ctx, newNamespace, typ)
+	if err != nil {
+		return err
+	}
+	if kv.routeName(ctx, namespace, typ) == kv.routeName(ctx, newNamespace, typ) {
+		return store.Rename(ctx, orgId, namespace, typ, newNamespace)
+	}
+	value, found, err := store.Get(ctx, orgId, namespace, typ)
+	if err != nil || !found {
+		return err
+	}
+	if err := newStore.Set(ctx, orgId, newNamespace, typ, value); err != nil {
+		return err
+	}
+	return store.Del(ctx, orgId, namespace, typ)
+}
+
+func (kv *routingKVStore) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	store, err := kv.route(ctx, namespace, typ)
+	if err != nil {
+		return "", false, err
+	}
+	return store.GetVersion(ctx, orgId, namespace, typ, version)
+}
+
+func (kv *routingKVStore) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	store, err := kv.route(ctx, namespace, typ)
+	if err != nil {
+		return nil, err
+	}
+	return store.ListVersions(ctx, orgId, namespace, typ)
+}
+
+func (kv *routingKVStore) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	store, err := kv.routeUpdate(ctx, namespace, typ)
+	if err != nil {
+		return err
+	}
+	return store.Rollback(ctx, orgId, namespace, typ, version)
+}
+
+// SetMultiple sets the items in their backends, the items of each backend at once
+func (kv *routingKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	byBackend := map[string][]Item{}
+	for _, item := range items {
+		if _, err := kv.routeUpdate(ctx, *item.Namespace, *item.Type); err != nil {
+			return err
+		}
+		name := kv.routeName(ctx, *item.Namespace, *item.Type)
+		byBackend[name] = append(byBackend[name], item)
+	}
+	for _, name := range kv.backendNames() {
+		if len(byBackend[name]) == 0 {
+			continue
+		}
+		if err := kv.backends[name].SetMultiple(ctx, byBackend[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DelMultiple deletes the keys from their backends, the keys of each backend at once
+func (kv *routingKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	byBackend := map[string][]Key{}
+	for _, key := range keys {
+		if _, err := kv.routeUpdate(ctx, key.Namespace, key.Type); err != nil {
+			return err
+		}
+		name := kv.routeName(ctx, key.Namespace, key.Type)
+		byBackend[name] = append(byBackend[name], key)
+	}
+	for _, name := range kv.backendNames() {
+		if len(byBackend[name]) == 0 {
+			continue
+		}
+		if err := kv.backends[name].DelMultiple(ctx, byBackend[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestReadSecretsRoutes(t *testing.T) {
+	t.Run("parses the routes in order", func(t *testing.T) {
+		routes, err := readSecretsRoutes(setupRoutesConfig(t, "type:datasource=vault, namespace:plugin-*=sql"))
+		require.NoError(t, err)
+		assert.Equal(t, []SecretsRoute{
+			{Type: "datasource", Backend: "vault"},
+			{Namespace: "plugin-*", Backend: "sql"},
+		}, routes)
+	})
+
+	t.Run("has no routes by default", func(t *testing.T) {
+		routes, err := readSecretsRoutes(setupRoutesConfig(t, ""))
+		require.NoError(t, err)
+		assert.Empty(t, routes)
+	})
+
+	t.Run("fails on invalid routes", func(t *testing.T) {
+		for _, value := range []string{"vault", "type:datasource=", "datasource=vault", "org:1=vault", "namespace:=sql"} {
+			_, err := readSecretsRoutes(setupRoutesConfig(t, value))
+			assert.Error(t, err, value)
+		}
+	})
+}
+
+func TestRoutingKVStore(t *testing.T) {
+	ctx := context.Background()
+	sql, vault := NewFakeSecretsKVStore(), NewFakeSecretsKVStore()
+	store := setupRoutingKVStore(t, sql, vault, nil)
+
+	require.NoError(t, store.Set(ctx, 1, "ds1", "datasource", "datasource secret"))
+	require.NoError(t, store.Set(ctx, 1, "plugin-a", "datasource", "plugin secret"))
+	require.NoError(t, store.Set(ctx, 1, "other", "other", "other secret"))
+
+	t.Run("routes the secrets with the first matching route", func(t *testing.T) {
+		assert.Equal(t, "datasource secret", vault.store[buildKey(1, "ds1", "datasource")])
+		assert.Equal(t, "plugin secret", sql.store[buildKey(1, "plugin-a", "datasource")])
+		assert.Equal(t, "other secret", sql.store[buildKey(1, "other", "other")])
+
+		value, found, err := store.Get(ctx, 1, "ds1", "datasource")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "datasource secret", value)
+	})
+
+	t.Run("lists the keys of every backend", func(t *testing.T) {
+		keys, err := store.ListKeys(ctx, AllOrganizations, "", "")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []Key{
+			buildKey(1, "ds1", "datasource"),
+			buildKey(1, "plugin-a", "datasource"),
+			buildKey(1, "other", "other"),
+		}, keys)
+	})
+
+	t.Run("moves the secret renamed to a namespace of another backend", func(t *testing.T) {
+		require.NoError(t, store.Rename(ctx, 1, "ds1", "datasource", "plugin-b"))
+		assert.NotContains(t, vault.store, buildKey(1, "ds1", "datasource"))
+		assert.Equal(t, "datasource secret", sql.store[buildKey(1, "plugin-b", "datasource")])
+	})
+
+	t.Run("sets and deletes several items in their backends", func(t *testing.T) {
+		require.NoError(t, store.SetMultiple(ctx, []Item{
+			testItem(1, "ds2", "datasource", "vault value"),
+			testItem(1, "plugin-c", "datasource", "sql value"),
+		}))
+		assert.Equal(t, "vault value", vault.store[buildKey(1, "ds2", "datasource")])
+		assert.Equal(t, "sql value", sql.store[buildKey(1, "plugin-c", "datasource")])
+
+		require.NoError(t, store.DelMultiple(ctx, []Key{buildKey(1, "ds2", "datasource"), buildKey(1, "plugin-c", "datasource")}))
+		assert.NotContains(t, vault.store, buildKey(1, "ds2", "datasource"))
+		assert.NotContains(t, sql.store, buildKey(1, "plugin-c", "datasource"))
+	})
+}
+
+func TestSecretsRoutingService_Move(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	kv := kvstore.ProvideService(sqlstore.InitTestDB(t))
+	routing := ProvideSecretsRoutingService(kv, setupRoutesConfig(t, ""), clk)
+	routing.batchSize = 1
+	sql, vault := NewFakeSecretsKVStore(), NewFakeSecretsKVStore()
+	store := setupRoutingKVStore(t, sql, vault, routing)
+
+	require.NoError(t, store.Set(ctx, 1, "ds1", "datasource", "secret 1"))
+	require.NoError(t, store.Set(ctx, 2, "ds1", "datasource", "secret 2"))
+	require.NoError(t, store.Set(ctx, 1, "ds2", "datasource", "other"))
+
+	t.Run("moves the secrets of the namespace to the backend", func(t *testing.T) {
+		result, err := routing.Move(ctx, "ds1", BackendSQL)
+		require.NoError(t, err)
+		assert.Equal(t, &SecretsNamespaceMove{Namespace: "ds1", Backend: BackendSQL, Moved: 2}, result)
+
+		assert.Equal(t, "secret 1", sql.store[buildKey(1, "ds1", "datasource")])
+		assert.Equal(t, "secret 2", sql.store[buildKey(2, "ds1", "datasource")])
+		assert.NotContains(t, vault.store, buildKey(1, "ds1", "datasource"))
+		assert.Equal(t, "other", vault.store[buildKey(1, "ds2", "datasource")])
+
+		require.NoError(t, store.Set(ctx, 1, "ds1", "datasource", "updated"))
+		assert.Equal(t, "updated", sql.store[buildKey(1, "ds1", "datasource")])
+	})
+
+	t.Run("is shared by the instances through the kvstore", func(t *testing.T) {
+		other := ProvideSecretsRoutingService(kv, setupRoutesConfig(t, ""), clk)
+		other.register(store.(*routingKVStore))
+		routes, err := other.Routes(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, BackendSQL, routes.Namespaces["ds1"].Backend)
+		assert.Equal(t, []string{BackendSQL, BackendVault}, routes.Backends)
+	})
+
+	t.Run("rejects the updates of the namespace while it is moved", func(t *testing.T) {
+		require.NoError(t, routing.setNamespaceRoute(ctx, "ds2", func(route *SecretsNamespaceRoute) { route.MovingTo = BackendSQL }))
+		assert.ErrorIs(t, store.Set(ctx, 1, "ds2", "datasource", "new"), ErrSecretsNamespaceMoving)
+		value, _, err := store.Get(ctx, 1, "ds2", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "other", value)
+
+		_, err = routing.Move(ctx, "ds2", BackendVault)
+		assert.ErrorIs(t, err, ErrSecretsNamespaceMoving)
+
+		// moving it again resumes the move
+		_, err = routing.Move(ctx, "ds2", BackendSQL)
+		require.NoError(t, err)
+		assert.Equal(t, "other", sql.store[buildKey(1, "ds2", "datasource")])
+	})
+
+	t.Run("keeps the secrets in their backend when the move fails", func(t *testing.T) {
+		require.NoError(t, store.Set(ctx, 1, "ds3", "datasource", "kept"))
+		failing := &failingSetMultipleKVStore{FakeSecretsKVStore: sql, failAfter: 1}
+		routed := store.(*routingKVStore)
+		routed.backends[BackendSQL] = failing
+		t.Cleanup(func() { routed.backends[BackendSQL] = sql })
+		require.NoError(t, store.Set(ctx, 2, "ds3", "datasource", "kept too"))
+
+		_, err := routing.Move(ctx, "ds3", BackendSQL)
+		require.Error(t, err)
+		assert.NotContains(t, sql.store, buildKey(1, "ds3", "datasource"))
+		assert.Equal(t, "kept", vault.store[buildKey(1, "ds3", "datasource")])
+
+		route, _ := routing.namespaceRoute(ctx, "ds3")
+		assert.Empty(t, route.MovingTo)
+		require.NoError(t, store.Set(ctx, 1, "ds3", "datasource", "updated"))
+	})
+
+	t.Run("fails on invalid moves", func(t *testing.T) {
+		_, err := routing.Move(ctx, "ds*", BackendSQL)
+		assert.ErrorIs(t, err, ErrInvalidSecretsNamespace)
+		_, err = routing.Move(ctx, "ds1", BackendAzureKeyVault)
+		assert.ErrorIs(t, err, ErrSecretsBackendNotRouted)
+		_, err = ProvideSecretsRoutingService(kv, setupRoutesConfig(t, ""), clk).Move(ctx, "ds1", BackendSQL)
+		assert.ErrorIs(t, err, ErrSecretsRoutingDisabled)
+	})
+}
+
+func setupRoutesConfig(t *testing.T, routes string) *setting.Cfg {
+	t.Helper()
+	raw := ini.Empty()
+	_, err := raw.Section("secrets").NewKey("routes", routes)
+	require.NoError(t, err)
+	return &setting.Cfg{Raw: raw}
+}
+
+// setupRoutingKVStore routes the data source secrets to vault, except the plugin-* namespaces
+func setupRoutingKVStore(t *testing.T, sql SecretsKVStore, vault SecretsKVStore, routing *SecretsRoutingService) SecretsKVStore {
+	t.Helper()
+	routes, err := readSecretsRoutes(setupRoutesConfig(t, "namespace:plugin-*=sql, type:datasource=vault"))
+	require.NoError(t, err)
+	store, err := withRouting(sql, BackendSQL, sql, routes, func(name string) (SecretsKVStore, error) {
+		if name != BackendVault {
+			return nil, errors.New("unknown backend")
+		}
+		return vault, nil
+	}, routing)
+	require.NoError(t, err)
+	return store
+}
+
+func testItem(orgId int64, namespace string, typ string, value string) Item {
+	return Item{OrgId: &orgId, Namespace: &namespace, Type: &typ, Value: value, Created: time.Now()}
+}
+
+// failingSetMultipleKVStore fails SetMultiple once it set failAfter batches
+type failingSetMultipleKVStore struct {
+	FakeSecretsKVStore
+	failAfter int
+}
+
+func (f *failingSetMultipleKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	if f.failAfter == 0 {
+		return errors.New("backend unavailable")
+	}
+	f.failAfter--
+	return f.FakeSecretsKVStore.SetMultiple(ctx, items)
+}
@@ -0,0 +1,305 @@
+package kvstore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/remotecache"
+	"github.com/grafana/grafana/pkg/services/encryption"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	// SharedCacheInvalidationOp is the operation of the Grafana Live notifications that invalidate cached secrets
+	SharedCacheInvalidationOp = "secrets_cache_invalidation"
+
+	sharedCacheKeyPrefix = "secrets-shared-cache-"
+	// the salt of the key encrypting the cached values, derived from the secret key of the instances
+	sharedCacheKeySalt = "secrets-shared-cache"
+)
+
+// SharedCacheService caches the decrypted secrets in the remote cache shared by the Grafana instances of an HA
+// cluster, so that a secret read by one instance is neither decrypted nor read from the plugin or backend again by
+// the others. It is disabled unless `secrets.shared_cache_ttl` is set.
+//
+// The values are encrypted with a key derived from the secret key before they are cached, and the entries expire
+// after the ttl. The instance that changes a secret removes it from the shared cache and publishes an invalidation
+// over Grafana Live, so that every instance removes it from its in-process cache too.
+type SharedCacheService struct {
+	ttl     time.Duration
+	storage remotecache.CacheStorage
+	aead    cipher.AEAD
+	log     log.Logger
+
+	mu          sync.RWMutex
+	publish     func(data []byte) error
+	localCaches []*CachedKVStore
+}
+
+func ProvideSharedCacheService(cfg *setting.Cfg, remoteCache *remotecache.RemoteCache) (*SharedCacheService, error) {
+	return newSharedCacheService(cfg, remoteCache)
+}
+
+func newSharedCacheService(cfg *setting.Cfg, storage remotecache.CacheStorage) (*SharedCacheService, error) {
+	s := &SharedCacheService{
+		ttl:     cfg.SectionWithEnvOverrides("secrets").Key("shared_cache_ttl").MustDuration(0),
+		storage: storage,
+		log:     log.New("secrets.kvstore.shared_cache"),
+	}
+	if s.IsDisabled() {
+		return s, nil
+	}
+
+	key, err := encryption.KeyToBytes(cfg.SecretKey, sharedCacheKeySalt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	s.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SharedCacheService) IsDisabled() bool {
+	return s == nil || s.ttl <= 0 || s.storage == nil
+}
+
+// Wrap returns a SecretsKVStore that reads through the shared cache, or store itself when the cache is disabled
+func (s *SharedCacheService) Wrap(store SecretsKVStore) SecretsKVStore {
+	if s.IsDisabled() {
+		return store
+	}
+	return &sharedCachedKVStore{store: store, cache: s}
+}
+
+// withCaches returns store cached in process, and in the shared cache when it is enabled. The in-process cache is
+// invalidated by the invalidations received from the other instances.
+func (s *SharedCacheService) withCaches(store SecretsKVStore) *CachedKVStore {
+	cached := NewCachedKVStore(s.Wrap(store), 5*time.Second, 5*time.Minute)
+	if !s.IsDisabled() {
+		s.mu.Lock()
+		s.localCaches = append(s.localCaches, cached)
+		s.mu.Unlock()
+	}
+	return cached
+}
+
+// SetInvalidationPublisher sets the function publishing the invalidations to every instance, the invalidations are
+// only applied to the local instance until it is set
+func (s *SharedCacheService) SetInvalidationPublisher(publish func(data []byte) error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publish = publish
+}
+
+// HandleInvalidation removes the secrets of an invalidation published by an instance from the in-process caches
+func (s *SharedCacheService) HandleInvalidation(data []byte) {
+	if s.IsDisabled() {
+		return
+	}
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		s.log.Warn("failed to decode secrets cache invalidation", "error", err)
+		return
+	}
+	sharedCacheInvalidationsCounter.Inc()
+	s.invalidateLocal(keys)
+}
+
+func (s *SharedCacheService) invalidateLocal(keys []Key) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, cached := range s.localCaches {
+		for _, key := range keys {
+			cached.invalidate(key.OrgId, key.Namespace, key.Type)
+		}
+	}
+}
+
+// invalidate removes the secrets from the shared cache and notifies the other instances. The failures are only
+// logged, as the secrets were changed already and the cached values expire after the ttl anyway.
+func (s *SharedCacheService) invalidate(ctx context.Context, keys ...Key) {
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, s.cacheKey(key)); err != nil && !errors.Is(err, remotecache.ErrCacheItemNotFound) {
+			s.log.Warn("failed to remove secret from the shared cache", "orgId", key.OrgId, "namespace", key.Namespace, "type", key.Type, "error", err)
+		}
+	}
+
+	s.mu.RLock()
+	publish := s.publish
+	s.mu.RUnlock()
+	if publish == nil {
+		return
+	}
+	data, err := json.Marshal(keys)
+	if err == nil {
+		err = publish(data)
+	}
+	if err != nil {
+		s.log.Warn("failed to publish secrets cache invalidation", "error", err)
+	}
+}
+
+// cacheKey hashes the key of the secret, so that the names of the secrets are not in the shared cache
+func (s *SharedCacheService) cacheKey(key Key) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d\x00%s\x00%s", key.OrgId, key.Namespace, key.Type)))
+	return sharedCacheKeyPrefix + hex.EncodeToString(hash[:])
+}
+
+func (s *SharedCacheService) get(ctx context.Context, key Key) (string, bool) {
+	cacheKey := s.cacheKey(key)
+	cached, err := s.storage.Get(ctx, cacheKey)
+	if err != nil {
+		if !errors.Is(err, remotecache.ErrCacheItemNotFound) {
+			s.log.Warn("failed to read secret from the shared cache", "orgId", key.OrgId, "namespace", key.Namespace, "type", key.Type, "error", err)
+		}
+		return "", false
+	}
+	sealed, ok := cached.([]byte)
+	nonceSize := s.aead.NonceSize()
+	if !ok || len(sealed) < nonceSize {
+		return "", false
+	}
+	// the cache key is authenticated too, so that an entry can't be read as the value of another secret
+	value, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(cacheKey))
+	if err != nil {
+		s.log.Warn("failed to decrypt secret from the shared cache", "orgId", key.OrgId, "namespace", key.Namespace, "type", key.Type, "error", err)
+		return "", false
+	}
+	return string(value), true
+}
+
+func (s *SharedCacheService) set(ctx context.Context, key Key, value string) {
+	cacheKey := s.cacheKey(key)
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		s.log.Warn("failed to encrypt secret for the shared cache", "error", err)
+		return
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(value), []byte(cacheKey))
+	if err := s.storage.Set(ctx, cacheKey, sealed, s.ttl); err != nil {
+		s.log.Warn("failed to cache secret in the shared cache", "orgId", key.OrgId, "namespace", key.Namespace, "type", key.Type, "error", err)
+	}
+}
+
+// sharedCachedKVStore reads the secrets through the shared cache, and invalidates them when they are changed. The
+// cache never fails the operations, the secrets are read from the store when the cache is not available.
+type sharedCachedKVStore struct {
+	store SecretsKVStore
+	cache *SharedCacheService
+}
+
+func (kv *sharedCachedKVStore) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	key := Key{OrgId: orgId, Namespace: namespace, Type: typ}
+	if value, ok := kv.cache.get(ctx, key); ok {
+		sharedCacheHitsCounter.Inc()
+		return value, true, nil
+	}
+	sharedCacheMissesCounter.Inc()
+	value, ok, err := kv.store.Get(ctx, orgId, namespace, typ)
+	if err != nil {
+		return "", false, err
+	}
+	if ok {
+		kv.cache.set(ctx, key, value)
+	}
+	return value, ok, nil
+}
+
+func (kv *sharedCachedKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	err := kv.store.Set(ctx, orgId, namespace, typ, value)
+	if err != nil {
+		return err
+	}
+	kv.cache.invalidate(ctx, Key{OrgId: orgId, Namespace: namespace, Type: typ})
+	return nil
+}
+
+func (kv *sharedCachedKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	err := kv.store.SetWithTTL(ctx, orgId, namespace, typ, value, ttl)
+	if err != nil {
+		return err
+	}
+	kv.cache.invalidate(ctx, Key{OrgId: orgId, Namespace: namespace, Type: typ})
+	return nil
+}
+
+func (kv *sharedCachedKVStore) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	err := kv.store.Del(ctx, orgId, namespace, typ)
+	if err != nil {
+		return err
+	}
+	kv.cache.invalidate(ctx, Key{OrgId: orgId, Namespace: namespace, Type: typ})
+	return nil
+}
+
+func (kv *sharedCachedKVStore) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	return kv.store.Keys(ctx, orgId, namespace, typ)
+}
+
+func (kv *sharedCachedKVStore) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	return kv.store.ListKeys(ctx, orgId, namespacePattern, typeFilter)
+}
+
+func (kv *sharedCachedKVStore) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	err := kv.store.Rename(ctx, orgId, namespace, typ, newNamespace)
+	if err != nil {
+		return err
+	}
+	kv.cache.invalidate(ctx, Key{OrgId: orgId, Namespace: namespace, Type: typ}, Key{OrgId: orgId, Namespace: newNamespace, Type: typ})
+	return nil
+}
+
+func (kv *sharedCachedKVStore) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	return kv.store.GetVersion(ctx, orgId, namespace, typ, version)
+}
+
+func (kv *sharedCachedKVStore) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	return kv.store.ListVersions(ctx, orgId, namespace, typ)
+}
+
+func (kv *sharedCachedKVStore) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	err := kv.store.Rollback(ctx, orgId, namespace, typ, version)
+	if err != nil {
+		return err
+	}
+	kv.cache.invalidate(ctx, Key{OrgId: orgId, Namespace: namespace, Type: typ})
+	return nil
+}
+
+// SetMultiple invalidates the items even when it fails, as some of them may have been set
+func (kv *sharedCachedKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	err := kv.store.SetMultiple(ctx, items)
+	keys := make([]Key, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, Key{OrgId: *item.OrgId, Namespace: *item.Namespace, Type: *item.Type})
+	}
+	kv.cache.invalidate(ctx, keys...)
+	return err
+}
+
+// DelMultiple invalidates the keys even when it fails, see SetMultiple
+func (kv *sharedCachedKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	err := kv.store.DelMultiple(ctx, keys)
+	kv.cache.invalidate(ctx, keys...)
+	return err
+}
@@ -0,0 +1,92 @@
+package kvstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/infra/remotecache"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestSharedCacheService(t *testing.T) {
+	ctx := context.Background()
+	remoteCache := remotecache.NewFakeStore(t)
+
+	sharedCache := func(t *testing.T, secretKey string) *SharedCacheService {
+		t.Helper()
+		raw, err := ini.Load([]byte(`
+		[secrets]
+		shared_cache_ttl = 1m`))
+		require.NoError(t, err)
+		s, err := newSharedCacheService(&setting.Cfg{Raw: raw, SecretKey: secretKey}, remoteCache)
+		require.NoError(t, err)
+		return s
+	}
+
+	t.Run("is disabled by default", func(t *testing.T) {
+		s, err := newSharedCacheService(setting.NewCfg(), remoteCache)
+		require.NoError(t, err)
+		store := NewFakeSecretsKVStore()
+		assert.Equal(t, store, s.Wrap(store))
+	})
+
+	// two instances sharing the same store and remote cache, whose invalidations are delivered to each other
+	store := NewFakeSecretsKVStore()
+	first, second := sharedCache(t, "secret"), sharedCache(t, "secret")
+	for _, s := range []*SharedCacheService{first, second} {
+		s.SetInvalidationPublisher(func(data []byte) error {
+			first.HandleInvalidation(data)
+			second.HandleInvalidation(data)
+			return nil
+		})
+	}
+	firstStore, secondStore := first.withCaches(store), second.withCaches(store)
+
+	t.Run("reads the values cached by another instance", func(t *testing.T) {
+		require.NoError(t, store.Set(ctx, 1, "ds", "datasource", "cached"))
+		value, found, err := firstStore.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "cached", value)
+
+		// changed behind the caches
+		require.NoError(t, store.Set(ctx, 1, "ds", "datasource", "changed"))
+		value, _, err = secondStore.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "cached", value)
+	})
+
+	t.Run("invalidates the values changed by another instance", func(t *testing.T) {
+		require.NoError(t, firstStore.Set(ctx, 1, "ds", "datasource", "updated"))
+		value, _, err := secondStore.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "updated", value)
+
+		require.NoError(t, secondStore.Del(ctx, 1, "ds", "datasource"))
+		_, found, err := firstStore.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("encrypts the cached values", func(t *testing.T) {
+		require.NoError(t, store.Set(ctx, 2, "ds", "datasource", "plaintext"))
+		_, _, err := firstStore.Get(ctx, 2, "ds", "datasource")
+		require.NoError(t, err)
+
+		cached, err := remoteCache.Get(ctx, first.cacheKey(Key{OrgId: 2, Namespace: "ds", Type: "datasource"}))
+		require.NoError(t, err)
+		sealed, ok := cached.([]byte)
+		require.True(t, ok)
+		assert.False(t, strings.Contains(string(sealed), "plaintext"))
+
+		// an instance with another secret key can't read the value, and reads it from the store
+		require.NoError(t, store.Set(ctx, 2, "ds", "datasource", "changed"))
+		value, _, err := sharedCache(t, "other").Wrap(store).Get(ctx, 2, "ds", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "changed", value)
+	})
+}
@@ -3,9 +3,13 @@ package kvstore
 import (
 	"context"
 	"encoding/base64"
-	"sync"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/services/secrets"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
@@ -16,22 +20,29 @@ type secretsKVStoreSQL struct {
 	log             log.Logger
 	sqlStore        sqlstore.Store
 	secretsService  secrets.Service
-	decryptionCache decryptionCache
+	decryptionCache *decryptionCache
+	// number of previous values kept for each item, none when 0
+	historyVersions int
+	// values of at least this many bytes are compressed before they are encrypted, none when 0
+	compressionThreshold int
+	// clock is the source of the updated and expiration times of the items
+	clock clock.Clock
 	// This is here to support testing and should normally not be set
 	GetAllFuncOverride func(ctx context.Context) ([]Item, error)
 }
 
-type decryptionCache struct {
-	cache map[int64]cachedDecrypted
-	sync.Mutex
-}
+var b64 = base64.RawStdEncoding
 
-type cachedDecrypted struct {
-	updated time.Time
-	value   string
+// dbContext tags the queries of the store in the per-service database metrics
+func dbContext(ctx context.Context) context.Context {
+	return sqlstore.WithServiceName(ctx, "secrets")
 }
 
-var b64 = base64.RawStdEncoding
+// orgDataKeyScope is the scope of the data keys encrypting the secrets of an organization, so that a data key
+// never decrypts the secrets of another organization
+func orgDataKeyScope(orgId int64) string {
+	return fmt.Sprintf("org:%d", orgId)
+}
 
 // Get an item from the store
 func (kv *secretsKVStoreSQL) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
@@ -43,7 +54,7 @@ func (kv *secretsKVStoreSQL) Get(ctx context.Context, orgId int64, namespace str
 	var isFound bool
 	var decryptedValue []byte
 
-	err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
 		has, err := dbSession.Get(&item)
 		if err != nil {
 			kv.log.Error("error getting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
@@ -53,17 +64,19 @@ func (kv *secretsKVStoreSQL) Get(ctx context.Context, orgId int64, namespace str
 			kv.log.Debug("secret value not found", "orgId", orgId, "type", typ, "namespace", namespace)
 			return nil
 		}
+		if item.Expires != nil && !item.Expires.After(kv.clock.Now()) {
+			kv.log.Debug("secret value expired", "orgId", orgId, "type", typ, "namespace", namespace)
+			return nil
+		}
 		isFound = true
 		return nil
 	})
 
 	if err == nil && isFound {
-		kv.decryptionCache.Lock()
-		defer kv.decryptionCache.Unlock()
-
-		if cache, ok := kv.decryptionCache.cache[item.Id]; ok && item.Updated.Equal(cache.updated) {
+		if value, ok := kv.decryptionCache.get(item.Id, item.Updated); ok {
 			kv.log.Debug("got secret value from decryption cache", "orgId", orgId, "type", typ, "namespace", namespace)
-			return cache.value, isFound, err
+			kv.reEncryptInOrgScope(ctx, item, value)
+			return value, isFound, err
 		}
 
 		decodedValue, err := b64.DecodeString(item.Value)
@@ -72,16 +85,17 @@ func (kv *secretsKVStoreSQL) Get(ctx context.Context, orgId int64, namespace str
 			return string(decryptedValue), isFound, err
 		}
 
-		decryptedValue, err = kv.secretsService.Decrypt(ctx, decodedValue)
+		decryptedValue, err = kv.secretsService.DecryptWithAAD(ctx, decodedValue, secrets.ContextAAD(orgId, namespace, typ))
+		if err == nil {
+			decryptedValue, err = decompressValue(decryptedValue)
+		}
 		if err != nil {
 			kv.log.Error("error decrypting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
 			return string(decryptedValue), isFound, err
 		}
 
-		kv.decryptionCache.cache[item.Id] = cachedDecrypted{
-			updated: item.Updated,
-			value:   string(decryptedValue),
-		}
+		kv.decryptionCache.set(item.Id, item.Updated, string(decryptedValue))
+		kv.reEncryptInOrgScope(ctx, item, string(decryptedValue))
 	}
 
 	kv.log.Debug("got secret value", "orgId", orgId, "type", typ, "namespace", namespace)
@@ -90,101 +104,229 @@ func (kv *secretsKVStoreSQL) Get(ctx context.Context, orgId int64, namespace str
 
 // Set an item in the store
 func (kv *secretsKVStoreSQL) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
-	encryptedValue, err := kv.secretsService.Encrypt(ctx, []byte(value), secrets.WithoutScope())
+	return kv.set(ctx, orgId, namespace, typ, value, nil)
+}
+
+// SetWithTTL sets an item in the store that expires after ttl
+func (kv *secretsKVStoreSQL) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	expires := kv.clock.Now().Add(ttl)
+	return kv.set(ctx, orgId, namespace, typ, value, &expires)
+}
+
+func (kv *secretsKVStoreSQL) set(ctx context.Context, orgId int64, namespace string, typ string, value string, expires *time.Time) error {
+	encryptedValue, err := kv.secretsService.EncryptWithAAD(ctx, compressValue([]byte(value), kv.compressionThreshold), secrets.ContextAAD(orgId, namespace, typ), secrets.WithScope(orgDataKeyScope(orgId)))
 	if err != nil {
 		kv.log.Error("error encrypting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
 		return err
 	}
 	encodedValue := b64.EncodeToString(encryptedValue)
-	return kv.sqlStore.WithTransactionalDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
-		item := Item{
-			OrgId:     &orgId,
-			Namespace: &namespace,
-			Type:      &typ,
-		}
+	return kv.sqlStore.WithTransactionalDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		return kv.setEncoded(dbSession, orgId, namespace, typ, encodedValue, expires)
+	})
+}
 
-		has, err := dbSession.Get(&item)
-		if err != nil {
-			kv.log.Error("error checking secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
-			return err
-		}
+// setEncoded sets an item to a value encrypted with a data key of its organization and encoded, in the session
+func (kv *secretsKVStoreSQL) setEncoded(dbSession *sqlstore.DBSession, orgId int64, namespace string, typ string, encodedValue string, expires *time.Time) error {
+	item, err := kv.updateEncoded(dbSession, orgId, namespace, typ, encodedValue, expires)
+	if err != nil || item == nil {
+		return err
+	}
 
-		if has && item.Value == encodedValue {
-			kv.log.Debug("secret value not changed", "orgId", orgId, "type", typ, "namespace", namespace)
-			return nil
-		}
+	// if item doesn't exist we create it
+	_, err = dbSession.Insert(item)
+	if err != nil {
+		kv.log.Error("error inserting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+	} else {
+		kv.log.Debug("secret value inserted", "orgId", orgId, "type", typ, "namespace", namespace)
+	}
+	return err
+}
 
-		item.Value = encodedValue
-		item.Updated = time.Now()
+// updateEncoded updates an item to a value encrypted with a data key of its organization and encoded, in the
+// session. When the item doesn't exist, it returns the item to insert instead.
+func (kv *secretsKVStoreSQL) updateEncoded(dbSession *sqlstore.DBSession, orgId int64, namespace string, typ string, encodedValue string, expires *time.Time) (*Item, error) {
+	item := Item{
+		OrgId:     &orgId,
+		Namespace: &namespace,
+		Type:      &typ,
+	}
 
-		if has {
-			// if item already exists we update it
-			_, err = dbSession.ID(item.Id).Update(&item)
-			if err != nil {
-				kv.log.Error("error updating secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
-			} else {
-				kv.decryptionCache.Lock()
-				defer kv.decryptionCache.Unlock()
-				kv.decryptionCache.cache[item.Id] = cachedDecrypted{
-					updated: item.Updated,
-					value:   value,
-				}
-				kv.log.Debug("secret value updated", "orgId", orgId, "type", typ, "namespace", namespace)
-			}
-			return err
+	has, err := dbSession.Get(&item)
+	if err != nil {
+		kv.log.Error("error checking secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return nil, err
+	}
+
+	if has && item.Value == encodedValue && expires == nil && item.Expires == nil {
+		kv.log.Debug("secret value not changed", "orgId", orgId, "type", typ, "namespace", namespace)
+		return nil, nil
+	}
+
+	if has {
+		if err := kv.archiveVersion(dbSession, item); err != nil {
+			kv.log.Error("error archiving secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+			return nil, err
 		}
+	}
 
-		// if item doesn't exist we create it
-		item.Created = item.Updated
-		_, err = dbSession.Insert(&item)
+	hadExpiration := item.Expires != nil
+	item.Value = encodedValue
+	item.Scope = orgDataKeyScope(orgId)
+	item.Expires = expires
+	item.Updated = kv.clock.Now()
+
+	if has {
+		// if item already exists we update it
+		_, err = dbSession.ID(item.Id).Update(&item)
+		if err == nil && hadExpiration && expires == nil {
+			// the update skips nil columns, so the expiration is removed separately
+			_, err = dbSession.Exec("UPDATE secrets SET expires = NULL WHERE id = ?", item.Id)
+		}
 		if err != nil {
-			kv.log.Error("error inserting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+			kv.log.Error("error updating secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
 		} else {
-			kv.log.Debug("secret value inserted", "orgId", orgId, "type", typ, "namespace", namespace)
+			kv.decryptionCache.invalidate(item.Id)
+			kv.log.Debug("secret value updated", "orgId", orgId, "type", typ, "namespace", namespace)
 		}
+		return nil, err
+	}
+
+	item.Created = item.Updated
+	return &item, nil
+}
+
+// reEncryptInOrgScope encrypts again the value of an item that was encrypted before the data keys were scoped to
+// organizations, with a data key of its organization. It doesn't fail the read, the value is encrypted again the
+// next time it is read instead.
+func (kv *secretsKVStoreSQL) reEncryptInOrgScope(ctx context.Context, item Item, value string) {
+	scope := orgDataKeyScope(*item.OrgId)
+	if item.Scope == scope {
+		return
+	}
+
+	encryptedValue, err := kv.secretsService.EncryptWithAAD(ctx, compressValue([]byte(value), kv.compressionThreshold), secrets.ContextAAD(*item.OrgId, *item.Namespace, *item.Type), secrets.WithScope(scope))
+	if err != nil {
+		kv.log.Warn("error encrypting secret value with an organization data key", "orgId", *item.OrgId, "type", *item.Type, "namespace", *item.Namespace, "err", err)
+		return
+	}
+	err = kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		// the value is only replaced if it wasn't updated since it was read, the update encrypted it already
+		_, err := dbSession.Exec("UPDATE secrets SET value = ?, scope = ? WHERE id = ? AND value = ?",
+			b64.EncodeToString(encryptedValue), scope, item.Id, item.Value)
 		return err
 	})
+	if err != nil {
+		kv.log.Warn("error updating secret value encrypted with an organization data key", "orgId", *item.OrgId, "type", *item.Type, "namespace", *item.Namespace, "err", err)
+		return
+	}
+	kv.log.Debug("secret value encrypted with an organization data key", "orgId", *item.OrgId, "type", *item.Type, "namespace", *item.Namespace)
 }
 
-// Del deletes an item from the store.
+// Del deletes an item, and its previous versions, from the store.
 func (kv *secretsKVStoreSQL) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
-	err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
-		item := Item{
-			OrgId:     &orgId,
-			Namespace: &namespace,
-			Type:      &typ,
-		}
+	return kv.sqlStore.WithTransactionalDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		return kv.del(dbSession, orgId, namespace, typ)
+	})
+}
 
-		has, err := dbSession.Get(&item)
+// SetMultiple sets the items in a single transaction, none of them is set when one fails
+func (kv *secretsKVStoreSQL) SetMultiple(ctx context.Context, items []Item) error {
+	encodedValues := make([]string, len(items))
+	for i, item := range items {
+		encryptedValue, err := kv.secretsService.EncryptWithAAD(ctx, compressValue([]byte(item.Value), kv.compressionThreshold), secrets.ContextAAD(*item.OrgId, *item.Namespace, *item.Type), secrets.WithScope(orgDataKeyScope(*item.OrgId)))
 		if err != nil {
-			kv.log.Error("error checking secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+			kv.log.Error("error encrypting secret value", "orgId", *item.OrgId, "type", *item.Type, "namespace", *item.Namespace, "err", err)
 			return err
 		}
-
-		if has {
-			// if item exists we delete it
-			_, err = dbSession.ID(item.Id).Delete(&item)
+		encodedValues[i] = b64.EncodeToString(encryptedValue)
+	}
+	return kv.sqlStore.WithTransactionalDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		// the items that don't exist yet are inserted together once the existing ones are updated
+		newItems := make([]*Item, 0)
+		pending := make(map[Key]*Item)
+		for i, item := range items {
+			key := Key{OrgId: *item.OrgId, Namespace: *item.Namespace, Type: *item.Type}
+			if newItem, ok := pending[key]; ok {
+				// an item set twice is inserted with its last value
+				newItem.Value = encodedValues[i]
+				newItem.Expires = item.Expires
+				continue
+			}
+			newItem, err := kv.updateEncoded(dbSession, key.OrgId, key.Namespace, key.Type, encodedValues[i], item.Expires)
 			if err != nil {
-				kv.log.Error("error deleting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
-			} else {
-				kv.decryptionCache.Lock()
-				defer kv.decryptionCache.Unlock()
-				delete(kv.decryptionCache.cache, item.Id)
-				kv.log.Debug("secret value deleted", "orgId", orgId, "type", typ, "namespace", namespace)
+				return err
+			}
+			if newItem != nil {
+				pending[key] = newItem
+				newItems = append(newItems, newItem)
 			}
+		}
+
+		if _, err := dbSession.BulkInsert("secrets", newItems); err != nil {
+			kv.log.Error("error inserting secret values", "count", len(newItems), "err", err)
 			return err
 		}
+		kv.log.Debug("secret values inserted", "count", len(newItems))
+		return nil
+	})
+}
+
+// DelMultiple deletes the items, and their previous versions, in a single transaction
+func (kv *secretsKVStoreSQL) DelMultiple(ctx context.Context, keys []Key) error {
+	return kv.sqlStore.WithTransactionalDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		for _, key := range keys {
+			if err := kv.del(dbSession, key.OrgId, key.Namespace, key.Type); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
-	return err
+}
+
+// del deletes an item, and its previous versions, in the session
+func (kv *secretsKVStoreSQL) del(dbSession *sqlstore.DBSession, orgId int64, namespace string, typ string) error {
+	item := Item{
+		OrgId:     &orgId,
+		Namespace: &namespace,
+		Type:      &typ,
+	}
+
+	has, err := dbSession.Get(&item)
+	if err != nil {
+		kv.log.Error("error checking secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return err
+	}
+
+	_, err = dbSession.Delete(&ItemVersion{OrgId: &orgId, Namespace: &namespace, Type: &typ})
+	if err != nil {
+		kv.log.Error("error deleting secret versions", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return err
+	}
+
+	if has {
+		// if item exists we delete it
+		_, err = dbSession.ID(item.Id).Delete(&item)
+		if err != nil {
+			kv.log.Error("error deleting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		} else {
+			kv.decryptionCache.invalidate(item.Id)
+			kv.log.Debug("secret value deleted", "orgId", orgId, "type", typ, "namespace", namespace)
+		}
+		return err
+	}
+	return nil
 }
 
 // Keys get all keys for a given namespace. To query for all
 // organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
 func (kv *secretsKVStoreSQL) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
 	var keys []Key
-	err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
-		query := dbSession.Where("namespace = ?", namespace).And("type = ?", typ)
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		query := dbSession.Where("namespace = ?", namespace).And("type = ?", typ).
+			And("(expires IS NULL OR expires > ?)", kv.clock.Now())
 		if orgId != AllOrganizations {
 			query.And("org_id = ?", orgId)
 		}
@@ -193,30 +335,135 @@ func (kv *secretsKVStoreSQL) Keys(ctx context.Context, orgId int64, namespace st
 	return keys, err
 }
 
-// Rename an item in the store
+// ListKeys get the keys whose namespace matches namespacePattern and whose type is typeFilter, see SecretsKVStore.
+// The values are not read, so nothing is decrypted.
+func (kv *secretsKVStoreSQL) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	var keys []Key
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		query := dbSession.Where("(expires IS NULL OR expires > ?)", kv.clock.Now())
+		if orgId != AllOrganizations {
+			query.And("org_id = ?", orgId)
+		}
+		if typeFilter != "" {
+			query.And("type = ?", typeFilter)
+		}
+		if strings.Contains(namespacePattern, "*") {
+			// % and _ of the pattern are wildcards of LIKE too, the keys they match are filtered out below
+			query.And("namespace LIKE ?", strings.ReplaceAll(namespacePattern, "*", "%"))
+		} else if namespacePattern != "" {
+			query.And("namespace = ?", namespacePattern)
+		}
+		if filter, ok := readFilterFromContext(ctx); ok {
+			query.And(filter.Where, filter.Args...)
+		}
+		return query.OrderBy("org_id, namespace, type").Find(&keys)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matching := make([]Key, 0, len(keys))
+	for _, key := range keys {
+		if matchKey(key, orgId, namespacePattern, typeFilter) {
+			matching = append(matching, key)
+		}
+	}
+	return matching, nil
+}
+
+// Rename an item in the store. The values of the item and of its previous versions are bound to the namespace,
+// so they are encrypted again for the new namespace before it is renamed.
 func (kv *secretsKVStoreSQL) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
-	return kv.sqlStore.WithTransactionalDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
-		item := Item{
+	item := Item{
+		OrgId:     &orgId,
+		Namespace: &namespace,
+		Type:      &typ,
+	}
+	var itemVersions []ItemVersion
+	var has bool
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		var err error
+		if has, err = dbSession.Get(&item); err != nil {
+			return err
+		}
+		return dbSession.Where("org_id = ? AND namespace = ? AND type = ?", orgId, namespace, typ).Find(&itemVersions)
+	})
+	if err != nil {
+		kv.log.Error("error checking secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return err
+	}
+
+	// the values are encrypted outside of the transaction, see secrets.Service
+	renamedValues := make(map[string]string, len(itemVersions)+1)
+	if newNamespace != namespace {
+		values := make([]string, 0, len(itemVersions)+1)
+		if has {
+			values = append(values, item.Value)
+		}
+		for _, itemVersion := range itemVersions {
+			values = append(values, itemVersion.Value)
+		}
+		for _, value := range values {
+			renamed, err := kv.reEncryptForNamespace(ctx, orgId, namespace, typ, newNamespace, value)
+			if err != nil {
+				kv.log.Error("error encrypting secret value for the new namespace", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+				return err
+			}
+			renamedValues[value] = renamed
+		}
+	}
+
+	return kv.sqlStore.WithTransactionalDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		current := Item{
 			OrgId:     &orgId,
 			Namespace: &namespace,
 			Type:      &typ,
 		}
-
-		has, err := dbSession.Get(&item)
+		hasCurrent, err := dbSession.Get(&current)
 		if err != nil {
 			kv.log.Error("error checking secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
 			return err
 		}
+		if hasCurrent != has || (has && current.Value != item.Value) {
+			return ErrSecretChangedWhileRenamed
+		}
 
-		item.Namespace = &newNamespace
-		item.Updated = time.Now()
+		if has && newNamespace != namespace {
+			// secrets left under the new namespace, by a deleted datasource with the same name for example, are
+			// replaced with their previous values
+			if err := kv.del(dbSession, orgId, newNamespace, typ); err != nil {
+				return err
+			}
+		}
+
+		for _, itemVersion := range itemVersions {
+			if renamed, ok := renamedValues[itemVersion.Value]; ok {
+				// the versions deleted since they were read are skipped
+				if _, err := dbSession.Exec("UPDATE secrets_history SET value = ? WHERE id = ? AND value = ?", renamed, itemVersion.Id, itemVersion.Value); err != nil {
+					kv.log.Error("error updating secret version value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+					return err
+				}
+			}
+		}
+		_, err = dbSession.Table(&ItemVersion{}).Where("org_id = ? AND namespace = ? AND type = ?", orgId, namespace, typ).
+			Update(map[string]interface{}{"namespace": newNamespace})
+		if err != nil {
+			kv.log.Error("error updating secret versions namespace", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+			return err
+		}
 
 		if has {
 			// if item already exists we update it
-			_, err = dbSession.ID(item.Id).Update(&item)
+			current.Namespace = &newNamespace
+			current.Updated = kv.clock.Now()
+			if renamed, ok := renamedValues[current.Value]; ok {
+				current.Value = renamed
+			}
+			_, err = dbSession.ID(current.Id).Update(&current)
 			if err != nil {
 				kv.log.Error("error updating secret namespace", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
 			} else {
+				kv.decryptionCache.invalidate(current.Id)
 				kv.log.Debug("secret namespace updated", "orgId", orgId, "type", typ, "namespace", namespace)
 			}
 			return err
@@ -226,6 +473,166 @@ func (kv *secretsKVStoreSQL) Rename(ctx context.Context, orgId int64, namespace
 	})
 }
 
+// reEncryptForNamespace encrypts again an encoded value of an item, for the namespace the item is renamed to
+func (kv *secretsKVStoreSQL) reEncryptForNamespace(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string, value string) (string, error) {
+	decodedValue, err := b64.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	// the value is kept compressed, it doesn't need to be decompressed to be encrypted again
+	decryptedValue, err := kv.secretsService.DecryptWithAAD(ctx, decodedValue, secrets.ContextAAD(orgId, namespace, typ))
+	if err != nil {
+		return "", err
+	}
+	encryptedValue, err := kv.secretsService.EncryptWithAAD(ctx, decryptedValue, secrets.ContextAAD(orgId, newNamespace, typ), secrets.WithScope(orgDataKeyScope(orgId)))
+	if err != nil {
+		return "", err
+	}
+	return b64.EncodeToString(encryptedValue), nil
+}
+
+// DeleteExpired deletes the items, and their previous versions, that expired at now, and publishes a
+// SecretExpired event for each of them
+func (kv *secretsKVStoreSQL) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	var items []Item
+	err := kv.sqlStore.WithTransactionalDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		if err := dbSession.Where("expires <= ?", now).Cols("id", "org_id", "namespace", "type", "expires").Find(&items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			if _, err := dbSession.Delete(&ItemVersion{OrgId: item.OrgId, Namespace: item.Namespace, Type: item.Type}); err != nil {
+				return err
+			}
+			if _, err := dbSession.ID(item.Id).Delete(&Item{}); err != nil {
+				return err
+			}
+			dbSession.PublishAfterCommit(&events.SecretExpired{
+				Timestamp: *item.Expires,
+				OrgID:     *item.OrgId,
+				Namespace: *item.Namespace,
+				Type:      *item.Type,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range items {
+		kv.decryptionCache.invalidate(item.Id)
+	}
+	return len(items), nil
+}
+
+// GetVersion gets a previous value of an item from the store
+func (kv *secretsKVStoreSQL) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	itemVersion := ItemVersion{
+		OrgId:     &orgId,
+		Namespace: &namespace,
+		Type:      &typ,
+		Version:   version,
+	}
+	var isFound bool
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		var err error
+		isFound, err = dbSession.Get(&itemVersion)
+		return err
+	})
+	if err != nil {
+		kv.log.Error("error getting secret version", "orgId", orgId, "type", typ, "namespace", namespace, "version", version, "err", err)
+		return "", false, err
+	}
+	if !isFound {
+		return "", false, nil
+	}
+
+	decodedValue, err := b64.DecodeString(itemVersion.Value)
+	if err != nil {
+		kv.log.Error("error decoding secret version", "orgId", orgId, "type", typ, "namespace", namespace, "version", version, "err", err)
+		return "", true, err
+	}
+	decryptedValue, err := kv.secretsService.DecryptWithAAD(ctx, decodedValue, secrets.ContextAAD(orgId, namespace, typ))
+	if err == nil {
+		decryptedValue, err = decompressValue(decryptedValue)
+	}
+	if err != nil {
+		kv.log.Error("error decrypting secret version", "orgId", orgId, "type", typ, "namespace", namespace, "version", version, "err", err)
+		return "", true, err
+	}
+	return string(decryptedValue), true, nil
+}
+
+// ListVersions lists the previous values of an item, the most recent first
+func (kv *secretsKVStoreSQL) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	var itemVersions []ItemVersion
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		return dbSession.Where("org_id = ? AND namespace = ? AND type = ?", orgId, namespace, typ).Desc("version").Find(&itemVersions)
+	})
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]SecretVersion, 0, len(itemVersions))
+	for _, itemVersion := range itemVersions {
+		versions = append(versions, SecretVersion{Version: itemVersion.Version, Created: itemVersion.Created})
+	}
+	return versions, nil
+}
+
+// Rollback sets an item back to one of its previous values. The current value becomes a previous version, so
+// the rollback itself can be undone.
+func (kv *secretsKVStoreSQL) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	value, found, err := kv.GetVersion(ctx, orgId, namespace, typ, version)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrSecretVersionNotFound
+	}
+	return kv.Set(ctx, orgId, namespace, typ, value)
+}
+
+// archiveVersion keeps the current value of an item before it is updated, and deletes the versions past
+// historyVersions
+func (kv *secretsKVStoreSQL) archiveVersion(dbSession *sqlstore.DBSession, item Item) error {
+	if kv.historyVersions <= 0 {
+		return nil
+	}
+
+	var itemVersions []ItemVersion
+	err := dbSession.Where("org_id = ? AND namespace = ? AND type = ?", *item.OrgId, *item.Namespace, *item.Type).
+		Desc("version").Find(&itemVersions)
+	if err != nil {
+		return err
+	}
+
+	next := int64(1)
+	if len(itemVersions) > 0 {
+		next = itemVersions[0].Version + 1
+	}
+	_, err = dbSession.Insert(&ItemVersion{
+		OrgId:     item.OrgId,
+		Namespace: item.Namespace,
+		Type:      item.Type,
+		Version:   next,
+		Value:     item.Value,
+		Created:   item.Updated,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(itemVersions) < kv.historyVersions {
+		return nil
+	}
+	expired := make([]int64, 0, len(itemVersions)-kv.historyVersions+1)
+	for _, itemVersion := range itemVersions[kv.historyVersions-1:] {
+		expired = append(expired, itemVersion.Id)
+	}
+	_, err = dbSession.In("id", expired).Delete(&ItemVersion{})
+	return err
+}
+
 // GetAll this returns all the secrets stored in the database. This is not part of the kvstore interface as we
 // only need it for migration from sql to plugin at this moment
 func (kv *secretsKVStoreSQL) GetAll(ctx context.Context) ([]Item, error) {
@@ -233,7 +640,7 @@ func (kv *secretsKVStoreSQL) GetAll(ctx context.Context) ([]Item, error) {
 		return kv.GetAllFuncOverride(ctx)
 	}
 	var items []Item
-	err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
 		return dbSession.Find(&items)
 	})
 	if err != nil {
@@ -241,14 +648,136 @@ func (kv *secretsKVStoreSQL) GetAll(ctx context.Context) ([]Item, error) {
 		return nil, err
 	}
 
-	// decrypting value
-	kv.decryptionCache.Lock()
-	defer kv.decryptionCache.Unlock()
+	kv.decryptItems(ctx, items)
+	return items, nil
+}
+
+// GetBatch returns up to limit secrets with an id greater than afterId, ordered by id, and separately the secrets
+// of the batch whose value could not be decrypted. Like GetAll, it is only used to migrate the secrets from sql to
+// the plugin.
+func (kv *secretsKVStoreSQL) GetBatch(ctx context.Context, afterId int64, limit int) ([]Item, []Item, error) {
+	if kv.GetAllFuncOverride != nil {
+		all, err := kv.GetAllFuncOverride(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		items := make([]Item, 0, len(all))
+		for _, item := range all {
+			if item.Id > afterId {
+				items = append(items, item)
+			}
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Id < items[j].Id })
+		if len(items) > limit {
+			items = items[:limit]
+		}
+		return items, nil, nil
+	}
+	var items []Item
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		return dbSession.Where("id > ?", afterId).Asc("id").Limit(limit).Find(&items)
+	})
+	if err != nil {
+		kv.log.Error("error getting a batch of items", "afterId", afterId, "err", err)
+		return nil, nil, err
+	}
+
+	undecryptable := kv.decryptItems(ctx, items)
+	if len(undecryptable) == 0 {
+		return items, nil, nil
+	}
+	decrypted := make([]Item, 0, len(items)-len(undecryptable))
+	for _, item := range items {
+		if _, ok := undecryptable[item.Id]; !ok {
+			decrypted = append(decrypted, item)
+		}
+	}
+	failed := make([]Item, 0, len(undecryptable))
+	for _, item := range undecryptable {
+		failed = append(failed, item)
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Id < failed[j].Id })
+	return decrypted, failed, nil
+}
+
+// markMigrated records that the item was migrated to the plugin, see deleteMigrated
+func (kv *secretsKVStoreSQL) markMigrated(ctx context.Context, item Item) error {
+	return kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		_, err := dbSession.Insert(&PluginMigratedSecret{
+			SecretId:  item.Id,
+			OrgId:     *item.OrgId,
+			Namespace: *item.Namespace,
+			Type:      *item.Type,
+			Migrated:  kv.clock.Now(),
+		})
+		return err
+	})
+}
+
+// deleteMigrated deletes the items marked as migrated to the plugin, with their previous versions and their marks,
+// in a single transaction. A marked item that was recreated since it was migrated is kept. It returns the number
+// of items deleted.
+func (kv *secretsKVStoreSQL) deleteMigrated(ctx context.Context) (int, error) {
+	var count int
+	err := kv.sqlStore.WithTransactionalDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		count = 0
+		var migrated []PluginMigratedSecret
+		if err := dbSession.Find(&migrated); err != nil {
+			return err
+		}
+		for _, m := range migrated {
+			item := Item{OrgId: &m.OrgId, Namespace: &m.Namespace, Type: &m.Type}
+			has, err := dbSession.Get(&item)
+			if err != nil {
+				return err
+			}
+			if !has || item.Id != m.SecretId {
+				continue
+			}
+			if err := kv.del(dbSession, m.OrgId, m.Namespace, m.Type); err != nil {
+				return err
+			}
+			count++
+		}
+		_, err := dbSession.Exec("DELETE FROM secrets_plugin_migration")
+		return err
+	})
+	return count, err
+}
+
+// Count returns the number of secrets with an id greater than afterId
+func (kv *secretsKVStoreSQL) Count(ctx context.Context, afterId int64) (int64, error) {
+	if kv.GetAllFuncOverride != nil {
+		all, err := kv.GetAllFuncOverride(ctx)
+		if err != nil {
+			return 0, err
+		}
+		var count int64
+		for _, item := range all {
+			if item.Id > afterId {
+				count++
+			}
+		}
+		return count, nil
+	}
+	var count int64
+	err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+		var err error
+		count, err = dbSession.Where("id > ?", afterId).Count(&Item{})
+		return err
+	})
+	return count, err
+}
+
+// decryptItems replaces the encrypted values of the items with the decrypted ones. The items that can't be
+// decoded or decrypted get an empty value, and are returned by id.
+func (kv *secretsKVStoreSQL) decryptItems(ctx context.Context, items []Item) map[int64]Item {
+	undecryptable := map[int64]Item{}
 	for i := range items {
 		var decryptedValue []byte
-		if cache, ok := kv.decryptionCache.cache[items[i].Id]; ok && items[i].Updated.Equal(cache.updated) {
+		if value, ok := kv.decryptionCache.get(items[i].Id, items[i].Updated); ok {
 			kv.log.Debug("got secret value from decryption cache", "orgId", items[i].OrgId, "type", items[i].Type, "namespace", items[i].Namespace)
-			items[i].Value = cache.value
+			items[i].Value = value
 			continue
 		}
 
@@ -256,22 +785,24 @@ func (kv *secretsKVStoreSQL) GetAll(ctx context.Context) ([]Item, error) {
 		if err != nil {
 			kv.log.Error("error decoding secret value", "orgId", items[i].OrgId, "type", items[i].Type, "namespace", items[i].Namespace, "err", err)
 			items[i].Value = string(decryptedValue)
+			undecryptable[items[i].Id] = items[i]
 			continue
 		}
 
-		decryptedValue, err = kv.secretsService.Decrypt(ctx, decodedValue)
+		decryptedValue, err = kv.secretsService.DecryptWithAAD(ctx, decodedValue, secrets.ContextAAD(*items[i].OrgId, *items[i].Namespace, *items[i].Type))
+		if err == nil {
+			decryptedValue, err = decompressValue(decryptedValue)
+		}
 		if err != nil {
 			kv.log.Error("error decrypting secret value", "orgId", items[i].OrgId, "type", items[i].Type, "namespace", items[i].Namespace, "err", err)
 			items[i].Value = string(decryptedValue)
+			undecryptable[items[i].Id] = items[i]
 			continue
 		}
 
 		items[i].Value = string(decryptedValue)
-		kv.decryptionCache.cache[items[i].Id] = cachedDecrypted{
-			updated: items[i].Updated,
-			value:   string(decryptedValue),
-		}
+		kv.decryptionCache.set(items[i].Id, items[i].Updated, string(decryptedValue))
 	}
 
-	return items, err
+	return undecryptable
 }
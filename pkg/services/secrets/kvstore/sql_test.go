@@ -1,11 +1,17 @@
 package kvstore
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/secrets"
 	"github.com/grafana/grafana/pkg/services/secrets/database"
 	"github.com/grafana/grafana/pkg/services/secrets/manager"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
@@ -32,12 +38,11 @@ func setupTestService(t *testing.T) *secretsKVStoreSQL {
 	secretsService := manager.SetupTestService(t, store)
 
 	kv := &secretsKVStoreSQL{
-		sqlStore:       sqlStore,
-		log:            log.New("secrets.kvstore"),
-		secretsService: secretsService,
-		decryptionCache: decryptionCache{
-			cache: make(map[int64]cachedDecrypted),
-		},
+		sqlStore:        sqlStore,
+		log:             log.New("secrets.kvstore"),
+		secretsService:  secretsService,
+		decryptionCache: newDecryptionCache(defaultDecryptionCacheMaxEntries, defaultDecryptionCacheTTL),
+		clock:           clock.New(),
 	}
 
 	return kv
@@ -314,3 +319,443 @@ func TestSecretsKVStoreSQL(t *testing.T) {
 		require.Equal(t, 6, found, "querying for all secrets should return 6 records")
 	})
 }
+
+func TestSecretsKVStoreSQL_Versions(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) *secretsKVStoreSQL {
+		kv := setupTestService(t)
+		kv.historyVersions = 2
+		return kv
+	}
+
+	t.Run("keeps the previous values up to the limit", func(t *testing.T) {
+		kv := setup(t)
+		for _, value := range []string{"one", "two", "three", "four"} {
+			require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", value))
+		}
+
+		versions, err := kv.ListVersions(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+		assert.Equal(t, int64(3), versions[0].Version)
+		assert.Equal(t, int64(2), versions[1].Version)
+
+		value, found, err := kv.GetVersion(ctx, 1, "ds", "datasource", 3)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "three", value)
+		value, found, err = kv.GetVersion(ctx, 1, "ds", "datasource", 2)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "two", value)
+		_, found, err = kv.GetVersion(ctx, 1, "ds", "datasource", 1)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("rolls back to a previous value", func(t *testing.T) {
+		kv := setup(t)
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "good"))
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "fat-fingered"))
+
+		require.NoError(t, kv.Rollback(ctx, 1, "ds", "datasource", 1))
+		value, _, err := kv.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "good", value)
+
+		// the rolled back value is kept too
+		value, found, err := kv.GetVersion(ctx, 1, "ds", "datasource", 2)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "fat-fingered", value)
+
+		err = kv.Rollback(ctx, 1, "ds", "datasource", 10)
+		require.ErrorIs(t, err, ErrSecretVersionNotFound)
+	})
+
+	t.Run("renames and deletes the previous values with the item", func(t *testing.T) {
+		kv := setup(t)
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "one"))
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "two"))
+
+		require.NoError(t, kv.Rename(ctx, 1, "ds", "datasource", "renamed"))
+		versions, err := kv.ListVersions(ctx, 1, "renamed", "datasource")
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+
+		require.NoError(t, kv.Del(ctx, 1, "renamed", "datasource"))
+		versions, err = kv.ListVersions(ctx, 1, "renamed", "datasource")
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("keeps no previous values when disabled", func(t *testing.T) {
+		kv := setupTestService(t)
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "one"))
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "two"))
+
+		versions, err := kv.ListVersions(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+}
+
+func TestSecretsKVStoreSQL_Rename(t *testing.T) {
+	ctx := context.Background()
+	kv := setupTestService(t)
+	kv.historyVersions = 2
+	require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "one"))
+	require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "two"))
+	require.NoError(t, kv.Set(ctx, 1, "renamed", "datasource", "left"))
+	require.NoError(t, kv.Set(ctx, 1, "renamed", "datasource", "behind"))
+
+	require.NoError(t, kv.Rename(ctx, 1, "ds", "datasource", "renamed"))
+	value, found, err := kv.Get(ctx, 1, "renamed", "datasource")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "two", value)
+	value, _, err = kv.GetVersion(ctx, 1, "renamed", "datasource", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "one", value)
+	_, found, err = kv.Get(ctx, 1, "ds", "datasource")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestSecretsKVStoreSQL_TTL(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("expired secrets are not returned", func(t *testing.T) {
+		kv := setupTestService(t)
+		clk := clock.NewMock()
+		clk.Set(time.Now())
+		kv.clock = clk
+		require.NoError(t, kv.SetWithTTL(ctx, 1, "token", "plugin", "short-lived", 2*time.Hour))
+		require.NoError(t, kv.SetWithTTL(ctx, 1, "expired", "plugin", "short-lived", time.Hour))
+		clk.Add(time.Hour + time.Minute)
+
+		value, found, err := kv.Get(ctx, 1, "token", "plugin")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "short-lived", value)
+		_, found, err = kv.Get(ctx, 1, "expired", "plugin")
+		require.NoError(t, err)
+		assert.False(t, found)
+		keys, err := kv.Keys(ctx, 1, "expired", "plugin")
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("setting without ttl removes the expiration", func(t *testing.T) {
+		kv := setupTestService(t)
+		require.NoError(t, kv.SetWithTTL(ctx, 1, "token", "plugin", "short-lived", time.Hour))
+		require.NoError(t, kv.Set(ctx, 1, "token", "plugin", "long-lived"))
+
+		count, err := kv.DeleteExpired(ctx, time.Now().Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+		_, found, err := kv.Get(ctx, 1, "token", "plugin")
+		require.NoError(t, err)
+		assert.True(t, found)
+
+		require.ErrorIs(t, kv.SetWithTTL(ctx, 1, "token", "plugin", "value", 0), ErrInvalidTTL)
+	})
+
+	t.Run("deletes expired secrets and publishes an event", func(t *testing.T) {
+		kv := setupTestService(t)
+		var expired []*events.SecretExpired
+		kv.sqlStore.(*sqlstore.SQLStore).Bus().AddEventListener(func(ctx context.Context, e *events.SecretExpired) error {
+			expired = append(expired, e)
+			return nil
+		})
+		require.NoError(t, kv.SetWithTTL(ctx, 1, "token", "plugin", "short-lived", time.Minute))
+		require.NoError(t, kv.SetWithTTL(ctx, 1, "other-token", "plugin", "long-lived", 3*time.Hour))
+
+		count, err := kv.DeleteExpired(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		require.Len(t, expired, 1)
+		assert.Equal(t, "token", expired[0].Namespace)
+		assert.Equal(t, int64(1), expired[0].OrgID)
+
+		keys, err := kv.Keys(ctx, 1, "other-token", "plugin")
+		require.NoError(t, err)
+		assert.Len(t, keys, 1)
+	})
+}
+
+func TestSecretsKVStoreSQL_Multiple(t *testing.T) {
+	ctx := context.Background()
+	kv := setupTestService(t)
+	kv.historyVersions = 2
+	require.NoError(t, kv.Set(ctx, 1, "ds1", "datasource", "old"))
+
+	var items []Item
+	for _, namespace := range []string{"ds1", "ds2", "ds3"} {
+		orgId, namespace, typ := int64(1), namespace, "datasource"
+		items = append(items, Item{OrgId: &orgId, Namespace: &namespace, Type: &typ, Value: namespace + "-secret"})
+	}
+	orgId, namespace, typ := int64(1), "ds4", "datasource"
+	items = append(items,
+		Item{OrgId: &orgId, Namespace: &namespace, Type: &typ, Value: "first"},
+		Item{OrgId: &orgId, Namespace: &namespace, Type: &typ, Value: "last"},
+	)
+	require.NoError(t, kv.SetMultiple(ctx, items))
+
+	value, found, err := kv.Get(ctx, 1, "ds4", "datasource")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "last", value, "an item set twice should keep its last value")
+
+	keys, err := kv.Keys(ctx, 1, "ds1", "datasource")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	for _, namespace := range []string{"ds1", "ds2", "ds3"} {
+		value, found, err := kv.Get(ctx, 1, namespace, "datasource")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, namespace+"-secret", value)
+	}
+	versions, err := kv.ListVersions(ctx, 1, "ds1", "datasource")
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+
+	require.NoError(t, kv.DelMultiple(ctx, []Key{
+		{OrgId: 1, Namespace: "ds1", Type: "datasource"},
+		{OrgId: 1, Namespace: "ds2", Type: "datasource"},
+		{OrgId: 1, Namespace: "missing", Type: "datasource"},
+	}))
+
+	for namespace, exists := range map[string]bool{"ds1": false, "ds2": false, "ds3": true} {
+		_, found, err := kv.Get(ctx, 1, namespace, "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, exists, found, namespace)
+	}
+	versions, err = kv.ListVersions(ctx, 1, "ds1", "datasource")
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+}
+
+func TestSecretsKVStoreSQL_ListKeys(t *testing.T) {
+	ctx := context.Background()
+	kv := setupTestService(t)
+	require.NoError(t, kv.Set(ctx, 1, "prometheus-1", "datasource", "secret"))
+	require.NoError(t, kv.Set(ctx, 1, "prometheus-2", "datasource", "secret"))
+	require.NoError(t, kv.Set(ctx, 1, "loki", "datasource", "secret"))
+	require.NoError(t, kv.Set(ctx, 1, "prometheus-3", "plugin", "secret"))
+	require.NoError(t, kv.Set(ctx, 2, "prometheus_4", "datasource", "secret"))
+
+	namespaces := func(keys []Key) []string {
+		res := make([]string, 0, len(keys))
+		for _, key := range keys {
+			res = append(res, key.Namespace)
+		}
+		return res
+	}
+
+	keys, err := kv.ListKeys(ctx, 1, "prometheus-*", "datasource")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prometheus-1", "prometheus-2"}, namespaces(keys))
+
+	keys, err = kv.ListKeys(ctx, 1, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"loki", "prometheus-1", "prometheus-2", "prometheus-3"}, namespaces(keys))
+
+	keys, err = kv.ListKeys(ctx, AllOrganizations, "*-*", "datasource")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prometheus-1", "prometheus-2"}, namespaces(keys), "_ is not a wildcard of the pattern")
+
+	keys, err = kv.ListKeys(ctx, AllOrganizations, "loki", "datasource")
+	require.NoError(t, err)
+	assert.Equal(t, []Key{{OrgId: 1, Namespace: "loki", Type: "datasource"}}, keys)
+}
+
+func TestSecretsKVStoreSQL_Compression(t *testing.T) {
+	ctx := context.Background()
+	kv := setupTestService(t)
+	kv.compressionThreshold = 64
+
+	// storedValue returns the decrypted value of the secret as it is stored
+	storedValue := func(t *testing.T, namespace string) []byte {
+		t.Helper()
+		orgId, typ := int64(1), "datasource"
+		item := Item{OrgId: &orgId, Namespace: &namespace, Type: &typ}
+		err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+			has, err := dbSession.Get(&item)
+			require.True(t, has)
+			return err
+		})
+		require.NoError(t, err)
+		decoded, err := b64.DecodeString(item.Value)
+		require.NoError(t, err)
+		decrypted, err := kv.secretsService.DecryptWithAAD(ctx, decoded, secrets.ContextAAD(orgId, namespace, typ))
+		require.NoError(t, err)
+		return decrypted
+	}
+
+	large := strings.Repeat("-----BEGIN CERTIFICATE-----", 100)
+	require.NoError(t, kv.Set(ctx, 1, "large", "datasource", large))
+	require.NoError(t, kv.Set(ctx, 1, "small", "datasource", "secret"))
+	prefixed := string(compressedValuePrefix) + "secret"
+	require.NoError(t, kv.Set(ctx, 1, "prefixed", "datasource", prefixed))
+
+	t.Run("compresses the values longer than the threshold", func(t *testing.T) {
+		stored := storedValue(t, "large")
+		assert.True(t, bytes.HasPrefix(stored, compressedValuePrefix))
+		assert.Less(t, len(stored), len(large))
+		assert.Equal(t, "secret", string(storedValue(t, "small")))
+		assert.True(t, bytes.HasPrefix(storedValue(t, "prefixed"), compressedValuePrefix))
+	})
+
+	t.Run("reads the compressed values when the compression is disabled", func(t *testing.T) {
+		kv.compressionThreshold = 0
+		kv.decryptionCache = newDecryptionCache(defaultDecryptionCacheMaxEntries, defaultDecryptionCacheTTL)
+		for namespace, expected := range map[string]string{"large": large, "small": "secret", "prefixed": prefixed} {
+			value, found, err := kv.Get(ctx, 1, namespace, "datasource")
+			require.NoError(t, err)
+			require.True(t, found)
+			assert.Equal(t, expected, value, namespace)
+		}
+		values, err := kv.GetAll(ctx)
+		require.NoError(t, err)
+		require.Len(t, values, 3)
+	})
+}
+
+func TestSecretsKVStoreSQL_OrgDataKeys(t *testing.T) {
+	ctx := context.Background()
+	kv := setupTestService(t)
+
+	getItem := func(t *testing.T, orgId int64, namespace string) Item {
+		t.Helper()
+		item := Item{OrgId: &orgId, Namespace: &namespace, Type: stringPtr("datasource")}
+		err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+			has, err := dbSession.Get(&item)
+			require.True(t, has)
+			return err
+		})
+		require.NoError(t, err)
+		return item
+	}
+	// dataKeyId returns the id of the data key that encrypted the value, which prefixes the encrypted value
+	dataKeyId := func(t *testing.T, item Item) string {
+		t.Helper()
+		decoded, err := b64.DecodeString(item.Value)
+		require.NoError(t, err)
+		id, ok := dataKeyId(decoded)
+		require.True(t, ok)
+		return id
+	}
+
+	t.Run("encrypts the secrets of each organization with its data key", func(t *testing.T) {
+		require.NoError(t, kv.Set(ctx, 1, "ds1", "datasource", "one"))
+		require.NoError(t, kv.Set(ctx, 2, "ds2", "datasource", "two"))
+
+		first, second := getItem(t, 1, "ds1"), getItem(t, 2, "ds2")
+		assert.Equal(t, "org:1", first.Scope)
+		assert.Equal(t, "org:2", second.Scope)
+		assert.NotEqual(t, dataKeyId(t, first), dataKeyId(t, second))
+	})
+
+	t.Run("encrypts again the secrets encrypted with the root data key when they are read", func(t *testing.T) {
+		encrypted, err := kv.secretsService.Encrypt(ctx, []byte("legacy"), secrets.WithoutScope())
+		require.NoError(t, err)
+		orgId, namespace, typ := int64(1), "ds3", "datasource"
+		err = kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+			_, err := dbSession.Insert(&Item{OrgId: &orgId, Namespace: &namespace, Type: &typ, Value: b64.EncodeToString(encrypted),
+				Created: time.Now(), Updated: time.Now()})
+			return err
+		})
+		require.NoError(t, err)
+		legacy := getItem(t, 1, "ds3")
+		assert.Empty(t, legacy.Scope)
+
+		value, found, err := kv.Get(ctx, 1, "ds3", "datasource")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "legacy", value)
+
+		migrated := getItem(t, 1, "ds3")
+		assert.Equal(t, "org:1", migrated.Scope)
+		assert.Equal(t, dataKeyId(t, getItem(t, 1, "ds1")), dataKeyId(t, migrated))
+		value, _, err = kv.Get(ctx, 1, "ds3", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "legacy", value)
+	})
+}
+
+func TestSecretsKVStoreSQL_AAD(t *testing.T) {
+	ctx := context.Background()
+	kv := setupTestService(t)
+	kv.historyVersions = 2
+
+	getValue := func(t *testing.T, orgId int64, namespace string) string {
+		t.Helper()
+		item := Item{OrgId: &orgId, Namespace: &namespace, Type: stringPtr("datasource")}
+		err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+			has, err := dbSession.Get(&item)
+			require.True(t, has)
+			return err
+		})
+		require.NoError(t, err)
+		return item.Value
+	}
+	setValue := func(t *testing.T, orgId int64, namespace string, value string) {
+		t.Helper()
+		err := kv.sqlStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+			_, err := dbSession.Exec("UPDATE secrets SET value = ?, updated = ? WHERE org_id = ? AND namespace = ?", value, time.Now(), orgId, namespace)
+			return err
+		})
+		require.NoError(t, err)
+	}
+
+	t.Run("secret copied to another row can't be decrypted", func(t *testing.T) {
+		require.NoError(t, kv.Set(ctx, 1, "source", "datasource", "secret"))
+		require.NoError(t, kv.Set(ctx, 1, "target", "datasource", "target secret"))
+		require.NoError(t, kv.Set(ctx, 2, "source", "datasource", "other org secret"))
+
+		decoded, err := b64.DecodeString(getValue(t, 1, "source"))
+		require.NoError(t, err)
+		assert.Equal(t, byte(secrets.EnvelopeV2Delimiter), decoded[0])
+
+		setValue(t, 1, "target", getValue(t, 1, "source"))
+		_, _, err = kv.Get(ctx, 1, "target", "datasource")
+		assert.Error(t, err)
+
+		setValue(t, 2, "source", getValue(t, 1, "source"))
+		_, _, err = kv.Get(ctx, 2, "source", "datasource")
+		assert.Error(t, err)
+	})
+
+	t.Run("renamed secret and its versions can be decrypted", func(t *testing.T) {
+		require.NoError(t, kv.Set(ctx, 1, "old-name", "datasource", "first"))
+		require.NoError(t, kv.Set(ctx, 1, "old-name", "datasource", "second"))
+		require.NoError(t, kv.Rename(ctx, 1, "old-name", "datasource", "new-name"))
+
+		value, found, err := kv.Get(ctx, 1, "new-name", "datasource")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "second", value)
+
+		value, found, err = kv.GetVersion(ctx, 1, "new-name", "datasource", 1)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "first", value)
+	})
+
+	t.Run("secret encrypted in the v1 format can be decrypted", func(t *testing.T) {
+		require.NoError(t, kv.Set(ctx, 1, "v1", "datasource", "placeholder"))
+		encrypted, err := kv.secretsService.Encrypt(ctx, []byte("v1 secret"), secrets.WithScope(orgDataKeyScope(1)))
+		require.NoError(t, err)
+		setValue(t, 1, "v1", b64.EncodeToString(encrypted))
+
+		value, _, err := kv.Get(ctx, 1, "v1", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, "v1 secret", value)
+	})
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -3,8 +3,11 @@ package kvstore
 import (
 	"context"
 	"errors"
+	"io"
 	"testing"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/plugins"
 	"github.com/grafana/grafana/pkg/plugins/backendplugin"
@@ -14,6 +17,8 @@ import (
 	"github.com/grafana/grafana/pkg/services/secrets/manager"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func SetupTestService(t *testing.T) SecretsKVStore {
@@ -24,12 +29,11 @@ func SetupTestService(t *testing.T) SecretsKVStore {
 	secretsService := manager.SetupTestService(t, store)
 
 	kv := &secretsKVStoreSQL{
-		sqlStore:       sqlStore,
-		log:            log.New("secrets.kvstore"),
-		secretsService: secretsService,
-		decryptionCache: decryptionCache{
-			cache: make(map[int64]cachedDecrypted),
-		},
+		sqlStore:        sqlStore,
+		log:             log.New("secrets.kvstore"),
+		secretsService:  secretsService,
+		decryptionCache: newDecryptionCache(defaultDecryptionCacheMaxEntries, defaultDecryptionCacheTTL),
+		clock:           clock.New(),
 	}
 
 	return kv
@@ -55,6 +59,10 @@ func (f FakeSecretsKVStore) Set(ctx context.Context, orgId int64, namespace stri
 	return nil
 }
 
+func (f FakeSecretsKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	return f.Set(ctx, orgId, namespace, typ, value)
+}
+
 func (f FakeSecretsKVStore) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
 	delete(f.store, buildKey(orgId, namespace, typ))
 	return nil
@@ -70,12 +78,42 @@ func (f FakeSecretsKVStore) Keys(ctx context.Context, orgId int64, namespace str
 	return res, nil
 }
 
+func (f FakeSecretsKVStore) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	res := make([]Key, 0)
+	for k := range f.store {
+		if matchKey(k, orgId, namespacePattern, typeFilter) {
+			res = append(res, k)
+		}
+	}
+	return res, nil
+}
+
 func (f FakeSecretsKVStore) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
 	f.store[buildKey(orgId, newNamespace, typ)] = f.store[buildKey(orgId, namespace, typ)]
 	delete(f.store, buildKey(orgId, namespace, typ))
 	return nil
 }
 
+func (f FakeSecretsKVStore) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	return "", false, ErrVersioningNotSupported
+}
+
+func (f FakeSecretsKVStore) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	return nil, ErrVersioningNotSupported
+}
+
+func (f FakeSecretsKVStore) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	return ErrVersioningNotSupported
+}
+
+func (f FakeSecretsKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	return setEach(ctx, f, items)
+}
+
+func (f FakeSecretsKVStore) DelMultiple(ctx context.Context, keys []Key) error {
+	return delEach(ctx, f, keys)
+}
+
 func buildKey(orgId int64, namespace string, typ string) Key {
 	return Key{
 		OrgId:     orgId,
@@ -128,17 +166,72 @@ func (c *fakeGRPCSecretsPlugin) RenameSecret(ctx context.Context, in *secretsman
 	return &secretsmanagerplugin.RenameSecretResponse{}, nil
 }
 
+func (c *fakeGRPCSecretsPlugin) GetAllSecrets(ctx context.Context, in *secretsmanagerplugin.GetAllSecretsRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.GetAllSecretsResponse, error) {
+	return &secretsmanagerplugin.GetAllSecretsResponse{
+		Items: make([]*secretsmanagerplugin.Item, 0),
+	}, nil
+}
+
+func (c *fakeGRPCSecretsPlugin) SetSecrets(ctx context.Context, in *secretsmanagerplugin.SetSecretsRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.SetSecretsResponse, error) {
+	return &secretsmanagerplugin.SetSecretsResponse{}, nil
+}
+
+func (c *fakeGRPCSecretsPlugin) DeleteSecrets(ctx context.Context, in *secretsmanagerplugin.DeleteSecretsRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.DeleteSecretsResponse, error) {
+	return &secretsmanagerplugin.DeleteSecretsResponse{}, nil
+}
+
+func (c *fakeGRPCSecretsPlugin) ListSecretKeys(ctx context.Context, in *secretsmanagerplugin.ListSecretKeysRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.ListSecretsResponse, error) {
+	return &secretsmanagerplugin.ListSecretsResponse{
+		Keys: make([]*secretsmanagerplugin.Key, 0),
+	}, nil
+}
+
+func (c *fakeGRPCSecretsPlugin) StreamAllSecrets(ctx context.Context, in *secretsmanagerplugin.StreamAllSecretsRequest, opts ...grpc.CallOption) (secretsmanagerplugin.SecretsManager_StreamAllSecretsClient, error) {
+	return &fakeStreamAllSecretsClient{}, nil
+}
+
+// GetCapabilities is not implemented, as in plugins built before the negotiation
+func (c *fakeGRPCSecretsPlugin) GetCapabilities(ctx context.Context, in *secretsmanagerplugin.GetCapabilitiesRequest, opts ...grpc.CallOption) (*secretsmanagerplugin.GetCapabilitiesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetCapabilities not implemented")
+}
+
+// fakeStreamAllSecretsClient sends the responses, then fails with err, or ends the stream when err is nil
+type fakeStreamAllSecretsClient struct {
+	grpc.ClientStream
+	responses []*secretsmanagerplugin.GetAllSecretsResponse
+	err       error
+}
+
+func (c *fakeStreamAllSecretsClient) Recv() (*secretsmanagerplugin.GetAllSecretsResponse, error) {
+	if len(c.responses) == 0 {
+		if c.err != nil {
+			return nil, c.err
+		}
+		return nil, io.EOF
+	}
+	res := c.responses[0]
+	c.responses = c.responses[1:]
+	return res, nil
+}
+
 var _ SecretsKVStore = FakeSecretsKVStore{}
 var _ secretsmanagerplugin.SecretsManagerPlugin = &fakeGRPCSecretsPlugin{}
 
 // Fake plugin manager
 type fakePluginManager struct {
 	shouldFailOnStart bool
+	secretsPlugin     secretsmanagerplugin.SecretsManagerPlugin
+	reloads           int
+	reloadErr         error
 }
 
 func (mg *fakePluginManager) SecretsManager() *plugins.Plugin {
+	var secretsPlugin secretsmanagerplugin.SecretsManagerPlugin = &fakeGRPCSecretsPlugin{}
+	if mg.secretsPlugin != nil {
+		secretsPlugin = mg.secretsPlugin
+	}
 	p := &plugins.Plugin{
-		SecretsManager: &fakeGRPCSecretsPlugin{},
+		SecretsManager: secretsPlugin,
 	}
 	p.RegisterClient(&fakePluginClient{
 		shouldFailOnStart: mg.shouldFailOnStart,
@@ -146,6 +239,14 @@ func (mg *fakePluginManager) SecretsManager() *plugins.Plugin {
 	return p
 }
 
+func (mg *fakePluginManager) ReloadSecretsManager(_ context.Context) (*plugins.Plugin, error) {
+	mg.reloads++
+	if mg.reloadErr != nil {
+		return nil, mg.reloadErr
+	}
+	return mg.SecretsManager(), nil
+}
+
 func NewFakeSecretsPluginManager(t *testing.T, shouldFailOnStart bool) plugins.SecretsPluginManager {
 	t.Helper()
 	return &fakePluginManager{
@@ -153,6 +254,14 @@ func NewFakeSecretsPluginManager(t *testing.T, shouldFailOnStart bool) plugins.S
 	}
 }
 
+// NewFakeSecretsPluginManagerWithPlugin returns a plugin manager whose secrets manager is the given plugin
+func NewFakeSecretsPluginManagerWithPlugin(t *testing.T, secretsPlugin secretsmanagerplugin.SecretsManagerPlugin) plugins.SecretsPluginManager {
+	t.Helper()
+	return &fakePluginManager{
+		secretsPlugin: secretsPlugin,
+	}
+}
+
 // Fake plugin client
 type fakePluginClient struct {
 	shouldFailOnStart bool
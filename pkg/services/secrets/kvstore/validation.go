@@ -0,0 +1,141 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+var (
+	ErrSecretValueTooLarge = errors.New("secret value is too large")
+	ErrInvalidSecretValue  = errors.New("invalid secret value")
+)
+
+// SecretValidationError is returned when a validator rejects the value of a secret, before it is encrypted and
+// stored. Err is the error of the validator, e.g. ErrSecretValueTooLarge or ErrInvalidSecretValue.
+type SecretValidationError struct {
+	OrgId     int64
+	Namespace string
+	Type      string
+	Err       error
+}
+
+func (e *SecretValidationError) Error() string {
+	return fmt.Sprintf("secret {orgId: %d, namespace: %q, type: %q} was rejected: %s", e.OrgId, e.Namespace, e.Type, e.Err)
+}
+
+func (e *SecretValidationError) Unwrap() error {
+	return e.Err
+}
+
+// SecretValidator checks the value of a secret before it is set, it returns an error to reject it
+type SecretValidator interface {
+	Validate(ctx context.Context, orgId int64, namespace string, typ string, value string) error
+}
+
+// SecretValidatorFunc is a SecretValidator function
+type SecretValidatorFunc func(ctx context.Context, orgId int64, namespace string, typ string, value string) error
+
+func (f SecretValidatorFunc) Validate(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	return f(ctx, orgId, namespace, typ, value)
+}
+
+// UTF8SecretValidator rejects the values that are not valid UTF-8
+var UTF8SecretValidator = SecretValidatorFunc(func(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	if !utf8.ValidString(value) {
+		return fmt.Errorf("%w: not valid UTF-8", ErrInvalidSecretValue)
+	}
+	return nil
+})
+
+// ValidationService checks the values set in the secrets store with the validators registered by the integrations,
+// and rejects the values larger than `secrets.max_value_size`
+type ValidationService struct {
+	maxValueSize int
+	mu           sync.RWMutex
+	// validators by secret type, the validators of the empty type check every secret
+	validators map[string][]SecretValidator
+}
+
+func ProvideValidationService(cfg *setting.Cfg) *ValidationService {
+	section := cfg.SectionWithEnvOverrides("secrets")
+	v := &ValidationService{
+		maxValueSize: section.Key("max_value_size").MustInt(0),
+		validators:   map[string][]SecretValidator{},
+	}
+	if section.Key("require_utf8").MustBool(false) {
+		v.Register("", UTF8SecretValidator)
+	}
+	return v
+}
+
+// Register adds a validator of the secrets of type typ, or of every secret when typ is empty
+func (v *ValidationService) Register(typ string, validator SecretValidator) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.validators[typ] = append(v.validators[typ], validator)
+}
+
+// Validate returns a *SecretValidationError when the value is rejected
+func (v *ValidationService) Validate(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	if v == nil {
+		return nil
+	}
+	if v.maxValueSize > 0 && len(value) > v.maxValueSize {
+		return &SecretValidationError{OrgId: orgId, Namespace: namespace, Type: typ,
+			Err: fmt.Errorf("%w: %d bytes, the maximum is %d", ErrSecretValueTooLarge, len(value), v.maxValueSize)}
+	}
+
+	v.mu.RLock()
+	validators := append(append([]SecretValidator{}, v.validators[""]...), v.validators[typ]...)
+	v.mu.RUnlock()
+	for _, validator := range validators {
+		if err := validator.Validate(ctx, orgId, namespace, typ, value); err != nil {
+			return &SecretValidationError{OrgId: orgId, Namespace: namespace, Type: typ, Err: err}
+		}
+	}
+	return nil
+}
+
+// Wrap returns a SecretsKVStore that validates the values before setting them in store
+func (v *ValidationService) Wrap(store SecretsKVStore) SecretsKVStore {
+	if v == nil {
+		return store
+	}
+	return &validatingKVStore{SecretsKVStore: store, validation: v}
+}
+
+// validatingKVStore rejects the values of the wrapped store that are not valid
+type validatingKVStore struct {
+	SecretsKVStore
+	validation *ValidationService
+}
+
+func (kv *validatingKVStore) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	if err := kv.validation.Validate(ctx, orgId, namespace, typ, value); err != nil {
+		return err
+	}
+	return kv.SecretsKVStore.Set(ctx, orgId, namespace, typ, value)
+}
+
+func (kv *validatingKVStore) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	if err := kv.validation.Validate(ctx, orgId, namespace, typ, value); err != nil {
+		return err
+	}
+	return kv.SecretsKVStore.SetWithTTL(ctx, orgId, namespace, typ, value, ttl)
+}
+
+// SetMultiple sets none of the items when one of them is rejected
+func (kv *validatingKVStore) SetMultiple(ctx context.Context, items []Item) error {
+	for _, item := range items {
+		if err := kv.validation.Validate(ctx, *item.OrgId, *item.Namespace, *item.Type, item.Value); err != nil {
+			return err
+		}
+	}
+	return kv.SecretsKVStore.SetMultiple(ctx, items)
+}
@@ -0,0 +1,83 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestValidationService(t *testing.T) {
+	ctx := context.Background()
+	raw, err := ini.Load([]byte(`
+	[secrets]
+	max_value_size = 8
+	require_utf8 = true`))
+	require.NoError(t, err)
+	validation := ProvideValidationService(&setting.Cfg{Raw: raw})
+	errNotJSON := errors.New("not json")
+	validation.Register("datasource", SecretValidatorFunc(func(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+		if value[0] != '{' {
+			return errNotJSON
+		}
+		return nil
+	}))
+
+	kv := setupTestService(t)
+	store := validation.Wrap(kv)
+
+	t.Run("sets the valid values", func(t *testing.T) {
+		require.NoError(t, store.Set(ctx, 1, "ds", "datasource", "{}"))
+		require.NoError(t, store.Set(ctx, 1, "other", "plugin", "plain"))
+	})
+
+	t.Run("rejects the invalid values before they are set", func(t *testing.T) {
+		testCases := []struct {
+			desc      string
+			namespace string
+			typ       string
+			value     string
+			err       error
+		}{
+			{desc: "too large", namespace: "large", typ: "plugin", value: "123456789", err: ErrSecretValueTooLarge},
+			{desc: "not UTF-8", namespace: "binary", typ: "plugin", value: "\xff", err: ErrInvalidSecretValue},
+			{desc: "rejected by the validator of the type", namespace: "plain", typ: "datasource", value: "plain", err: errNotJSON},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.desc, func(t *testing.T) {
+				err := store.Set(ctx, 1, tc.namespace, tc.typ, tc.value)
+				var validationErr *SecretValidationError
+				require.ErrorAs(t, err, &validationErr)
+				assert.Equal(t, tc.namespace, validationErr.Namespace)
+				assert.ErrorIs(t, err, tc.err)
+
+				_, found, err := kv.Get(ctx, 1, tc.namespace, tc.typ)
+				require.NoError(t, err)
+				assert.False(t, found)
+			})
+		}
+	})
+
+	t.Run("sets none of the items when one of them is rejected", func(t *testing.T) {
+		orgId, typ := int64(1), "plugin"
+		first, second := "first", "second"
+		err := store.SetMultiple(ctx, []Item{
+			{OrgId: &orgId, Namespace: &first, Type: &typ, Value: "valid"},
+			{OrgId: &orgId, Namespace: &second, Type: &typ, Value: "too large value"},
+		})
+		assert.ErrorIs(t, err, ErrSecretValueTooLarge)
+
+		_, found, err := kv.Get(ctx, 1, first, typ)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("accepts every value without limit nor validators", func(t *testing.T) {
+		store := ProvideValidationService(setting.NewCfg()).Wrap(kv)
+		require.NoError(t, store.Set(ctx, 1, "binary", "plugin", "\xff large value"))
+	})
+}
@@ -0,0 +1,408 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+const (
+	VaultAuthToken      = "token"
+	VaultAuthAppRole    = "approle"
+	VaultAuthKubernetes = "kubernetes"
+
+	defaultVaultPathTemplate = "grafana/{{orgId}}/{{namespace}}/{{type}}"
+)
+
+var errVaultTokenRejected = errors.New("vault rejected the token")
+
+// vaultSettings are read from the `secrets.vault` section of the configuration.
+type vaultSettings struct {
+	Address      string
+	Mount        string
+	PathTemplate string
+	Timeout      time.Duration
+
+	AuthMethod string
+	// token auth
+	Token string
+	// approle auth
+	AppRoleMount string
+	RoleID       string
+	SecretID     string
+	// kubernetes auth
+	KubernetesMount     string
+	KubernetesRole      string
+	KubernetesTokenPath string
+}
+
+func readVaultSettings(cfg *setting.Cfg) vaultSettings {
+	section := cfg.SectionWithEnvOverrides("secrets.vault")
+	return vaultSettings{
+		Address:             strings.TrimSuffix(section.Key("address").MustString("http://127.0.0.1:8200"), "/"),
+		Mount:               strings.Trim(section.Key("mount").MustString("secret"), "/"),
+		PathTemplate:        strings.Trim(section.Key("path_template").MustString(defaultVaultPathTemplate), "/"),
+		Timeout:             section.Key("timeout").MustDuration(10 * time.Second),
+		AuthMethod:          section.Key("auth_method").MustString(VaultAuthToken),
+		Token:               section.Key("token").MustString(""),
+		AppRoleMount:        strings.Trim(section.Key("approle_mount").MustString("approle"), "/"),
+		RoleID:              section.Key("role_id").MustString(""),
+		SecretID:            section.Key("secret_id").MustString(""),
+		KubernetesMount:     strings.Trim(section.Key("kubernetes_mount").MustString("kubernetes"), "/"),
+		KubernetesRole:      section.Key("kubernetes_role").MustString(""),
+		KubernetesTokenPath: section.Key("kubernetes_token_path").MustString("/var/run/secrets/kubernetes.io/serviceaccount/token"),
+	}
+}
+
+func (s vaultSettings) validate() error {
+	for _, placeholder := range []string{"{{orgId}}", "{{namespace}}", "{{type}}"} {
+		if !strings.Contains(s.PathTemplate, placeholder) {
+			return fmt.Errorf("vault path_template %q must contain %s", s.PathTemplate, placeholder)
+		}
+	}
+	switch s.AuthMethod {
+	case VaultAuthToken:
+		if s.Token == "" {
+			return errors.New("vault token auth requires `token`")
+		}
+	case VaultAuthAppRole:
+		if s.RoleID == "" || s.SecretID == "" {
+			return errors.New("vault approle auth requires `role_id` and `secret_id`")
+		}
+	case VaultAuthKubernetes:
+		if s.KubernetesRole == "" {
+			return errors.New("vault kubernetes auth requires `kubernetes_role`")
+		}
+	default:
+		return fmt.Errorf("unknown vault auth_method %q", s.AuthMethod)
+	}
+	return nil
+}
+
+// secretsKVStoreVault provides a key/value store backed by a HashiCorp Vault KV v2 secrets engine.
+// Each secret is stored as the `value` field of the path built from the path template.
+type secretsKVStoreVault struct {
+	log                            log.Logger
+	settings                       vaultSettings
+	client                         *http.Client
+	sqlStore                       sqlstore.Store
+	kvstore                        *kvstore.NamespacedKVStore
+	backwardsCompatibilityDisabled bool
+
+	tokenMu      sync.Mutex
+	token        string
+	tokenExpires time.Time
+}
+
+func newSecretsKVStoreVault(settings vaultSettings, sqlStore sqlstore.Store, kv *kvstore.NamespacedKVStore, backwardsCompatibilityDisabled bool, logger log.Logger) (*secretsKVStoreVault, error) {
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+	return &secretsKVStoreVault{
+		log:                            logger,
+		settings:                       settings,
+		client:                         &http.Client{Timeout: settings.Timeout},
+		sqlStore:                       sqlStore,
+		kvstore:                        kv,
+		backwardsCompatibilityDisabled: backwardsCompatibilityDisabled,
+	}, nil
+}
+
+type vaultSecretData struct {
+	Value string `json:"value"`
+}
+
+type vaultSecret struct {
+	Data struct {
+		Data vaultSecretData `json:"data"`
+	} `json:"data"`
+}
+
+// Get an item from the store
+func (kv *secretsKVStoreVault) Get(ctx context.Context, orgId int64, namespace string, typ string) (string, bool, error) {
+	var secret vaultSecret
+	status, err := kv.do(ctx, http.MethodGet, kv.secretURL("data", orgId, namespace, typ), nil, &secret)
+	if status == http.StatusNotFound {
+		kv.log.Debug("secret value not found", "orgId", orgId, "type", typ, "namespace", namespace)
+		return "", false, nil
+	}
+	if err != nil {
+		kv.log.Error("error getting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return "", false, err
+	}
+
+	updateFatalFlag(ctx, kv.kvstore, kv.backwardsCompatibilityDisabled, kv.log)
+	return secret.Data.Data.Value, true, nil
+}
+
+// Set an item in the store
+func (kv *secretsKVStoreVault) Set(ctx context.Context, orgId int64, namespace string, typ string, value string) error {
+	body := map[string]interface{}{"data": vaultSecretData{Value: value}}
+	if _, err := kv.do(ctx, http.MethodPost, kv.secretURL("data", orgId, namespace, typ), body, nil); err != nil {
+		kv.log.Error("error setting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return err
+	}
+
+	updateFatalFlag(ctx, kv.kvstore, kv.backwardsCompatibilityDisabled, kv.log)
+	return nil
+}
+
+// SetWithTTL is not supported, vault secrets don't expire
+func (kv *secretsKVStoreVault) SetWithTTL(ctx context.Context, orgId int64, namespace string, typ string, value string, ttl time.Duration) error {
+	return ErrTTLNotSupported
+}
+
+// Del deletes an item from the store, including all its versions.
+func (kv *secretsKVStoreVault) Del(ctx context.Context, orgId int64, namespace string, typ string) error {
+	status, err := kv.do(ctx, http.MethodDelete, kv.secretURL("metadata", orgId, namespace, typ), nil, nil)
+	if err != nil && status != http.StatusNotFound {
+		kv.log.Error("error deleting secret value", "orgId", orgId, "type", typ, "namespace", namespace, "err", err)
+		return err
+	}
+	return nil
+}
+
+// Keys get all keys for a given namespace. To query for all
+// organizations the constant 'kvstore.AllOrganizations' can be passed as orgId.
+func (kv *secretsKVStoreVault) Keys(ctx context.Context, orgId int64, namespace string, typ string) ([]Key, error) {
+	orgIds := []int64{orgId}
+	if orgId == AllOrganizations {
+		// The path template can put the organization anywhere, so rather than
+		// walking the mount the existing organizations are looked up.
+		orgIds = nil
+		err := kv.sqlStore.WithDbSession(dbContext(ctx), func(dbSession *sqlstore.DBSession) error {
+			return dbSession.Table("org").Cols("id").Find(&orgIds)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var keys []Key
+	for _, id := range orgIds {
+		status, err := kv.do(ctx, http.MethodGet, kv.secretURL("metadata", id, namespace, typ), nil, nil)
+		if status == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, Key{OrgId: id, Namespace: namespace, Type: typ})
+	}
+	return keys, nil
+}
+
+// ListKeys only supports listing the keys of a namespace and type, the path template doesn't
+// give a way to enumerate the secrets of Grafana in the mount.
+func (kv *secretsKVStoreVault) ListKeys(ctx context.Context, orgId int64, namespacePattern string, typeFilter string) ([]Key, error) {
+	if !isExactKeyPattern(namespacePattern, typeFilter) {
+		return nil, ErrKeyListingNotSupported
+	}
+	return kv.Keys(ctx, orgId, namespacePattern, typeFilter)
+}
+
+// Rename an item in the store. Vault has no rename, so the value is copied to
+// its new path before the old path is deleted.
+func (kv *secretsKVStoreVault) Rename(ctx context.Context, orgId int64, namespace string, typ string, newNamespace string) error {
+	value, exists, err := kv.Get(ctx, orgId, namespace, typ)
+	if err != nil || !exists {
+		return err
+	}
+	if err := kv.Set(ctx, orgId, newNamespace, typ, value); err != nil {
+		return err
+	}
+	return kv.Del(ctx, orgId, namespace, typ)
+}
+
+// SetMultiple sets the items one at a time, the Vault API has no batch calls
+func (kv *secretsKVStoreVault) SetMultiple(ctx context.Context, items []Item) error {
+	return setEach(ctx, kv, items)
+}
+
+// DelMultiple deletes the items one at a time, see SetMultiple
+func (kv *secretsKVStoreVault) DelMultiple(ctx context.Context, keys []Key) error {
+	return delEach(ctx, kv, keys)
+}
+
+// GetVersion is not supported, the vault store only keeps the current value of secrets
+func (kv *secretsKVStoreVault) GetVersion(ctx context.Context, orgId int64, namespace string, typ string, version int64) (string, bool, error) {
+	return "", false, ErrVersioningNotSupported
+}
+
+// ListVersions is not supported, see GetVersion
+func (kv *secretsKVStoreVault) ListVersions(ctx context.Context, orgId int64, namespace string, typ string) ([]SecretVersion, error) {
+	return nil, ErrVersioningNotSupported
+}
+
+// Rollback is not supported, see GetVersion
+func (kv *secretsKVStoreVault) Rollback(ctx context.Context, orgId int64, namespace string, typ string, version int64) error {
+	return ErrVersioningNotSupported
+}
+
+// Health checks that Vault is initialized and unsealed, and that Grafana can
+// authenticate with it.
+func (kv *secretsKVStoreVault) Health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, kv.settings.Address+"/v1/sys/health?standbyok=true", nil)
+	if err != nil {
+		return err
+	}
+	res, err := kv.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault is not healthy: status %d", res.StatusCode)
+	}
+
+	_, err = kv.do(ctx, http.MethodGet, kv.settings.Address+"/v1/auth/token/lookup-self", nil, nil)
+	return err
+}
+
+func (kv *secretsKVStoreVault) secretURL(kind string, orgId int64, namespace string, typ string) string {
+	path := strings.NewReplacer(
+		"{{orgId}}", strconv.FormatInt(orgId, 10),
+		"{{namespace}}", url.PathEscape(namespace),
+		"{{type}}", url.PathEscape(typ),
+	).Replace(kv.settings.PathTemplate)
+	return fmt.Sprintf("%s/v1/%s/%s/%s", kv.settings.Address, kv.settings.Mount, kind, path)
+}
+
+// do sends an authenticated request to Vault and decodes the response into
+// out. When a token obtained by logging in is rejected, it logs in again once.
+func (kv *secretsKVStoreVault) do(ctx context.Context, method string, reqURL string, body interface{}, out interface{}) (int, error) {
+	status, err := kv.doWithToken(ctx, method, reqURL, body, out)
+	if errors.Is(err, errVaultTokenRejected) && kv.settings.AuthMethod != VaultAuthToken {
+		kv.resetToken()
+		status, err = kv.doWithToken(ctx, method, reqURL, body, out)
+	}
+	return status, err
+}
+
+func (kv *secretsKVStoreVault) doWithToken(ctx context.Context, method string, reqURL string, body interface{}, out interface{}) (int, error) {
+	token, err := kv.getToken(ctx)
+	if err != nil {
+		return 0, err
+	}
+	status, err := kv.send(ctx, method, reqURL, token, body, out)
+	if status == http.StatusForbidden {
+		return status, fmt.Errorf("%w: %v", errVaultTokenRejected, err)
+	}
+	return status, err
+}
+
+func (kv *secretsKVStoreVault) send(ctx context.Context, method string, reqURL string, token string, body interface{}, out interface{}) (int, error) {
+	var reqBody io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := kv.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&vaultErr)
+		return res.StatusCode, fmt.Errorf("vault request failed with status %d: %s", res.StatusCode, strings.Join(vaultErr.Errors, ", "))
+	}
+	if out != nil && res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return res.StatusCode, err
+		}
+	}
+	return res.StatusCode, nil
+}
+
+// getToken returns the static token, or the token of the last login while it
+// has not expired.
+func (kv *secretsKVStoreVault) getToken(ctx context.Context) (string, error) {
+	if kv.settings.AuthMethod == VaultAuthToken {
+		return kv.settings.Token, nil
+	}
+
+	kv.tokenMu.Lock()
+	defer kv.tokenMu.Unlock()
+	if kv.token != "" && (kv.tokenExpires.IsZero() || time.Now().Before(kv.tokenExpires)) {
+		return kv.token, nil
+	}
+
+	var mount string
+	var credentials map[string]string
+	switch kv.settings.AuthMethod {
+	case VaultAuthAppRole:
+		mount = kv.settings.AppRoleMount
+		credentials = map[string]string{"role_id": kv.settings.RoleID, "secret_id": kv.settings.SecretID}
+	case VaultAuthKubernetes:
+		// Projected service account tokens are rotated, so the token is read on every login.
+		jwt, err := os.ReadFile(kv.settings.KubernetesTokenPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read kubernetes service account token: %w", err)
+		}
+		mount = kv.settings.KubernetesMount
+		credentials = map[string]string{"role": kv.settings.KubernetesRole, "jwt": strings.TrimSpace(string(jwt))}
+	}
+
+	var login struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	loginURL := fmt.Sprintf("%s/v1/auth/%s/login", kv.settings.Address, mount)
+	if _, err := kv.send(ctx, http.MethodPost, loginURL, "", credentials, &login); err != nil {
+		return "", fmt.Errorf("vault %s login failed: %w", kv.settings.AuthMethod, err)
+	}
+	if login.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault %s login returned no token", kv.settings.AuthMethod)
+	}
+
+	kv.token = login.Auth.ClientToken
+	kv.tokenExpires = time.Time{}
+	if login.Auth.LeaseDuration > 0 {
+		// log in again a bit before the token expires
+		lease := time.Duration(login.Auth.LeaseDuration) * time.Second
+		kv.tokenExpires = time.Now().Add(lease - lease/10)
+	}
+	kv.log.Debug("logged in to vault", "method", kv.settings.AuthMethod)
+	return kv.token, nil
+}
+
+func (kv *secretsKVStoreVault) resetToken() {
+	kv.tokenMu.Lock()
+	defer kv.tokenMu.Unlock()
+	kv.token = ""
+}
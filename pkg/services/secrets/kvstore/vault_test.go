@@ -0,0 +1,255 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestSecretsKVStoreVault(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("stores secrets at the templated path", func(t *testing.T) {
+		vault := newFakeVault(t)
+		kv := setupVaultStore(t, vault, vaultSettings{PathTemplate: "grafana/{{namespace}}/org-{{orgId}}/{{type}}"})
+
+		require.NoError(t, kv.Set(ctx, 1, "my datasource", "datasource", "secret"))
+		assert.Contains(t, vault.secrets, "grafana/my%20datasource/org-1/datasource")
+
+		value, exists, err := kv.Get(ctx, 1, "my datasource", "datasource")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "secret", value)
+
+		_, exists, err = kv.Get(ctx, 2, "my datasource", "datasource")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("lists, renames and deletes secrets", func(t *testing.T) {
+		vault := newFakeVault(t)
+		kv := setupVaultStore(t, vault, vaultSettings{})
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "one"))
+		require.NoError(t, kv.Set(ctx, 2, "ds", "datasource", "two"))
+
+		keys, err := kv.Keys(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.Equal(t, []Key{{OrgId: 1, Namespace: "ds", Type: "datasource"}}, keys)
+
+		require.NoError(t, kv.Rename(ctx, 1, "ds", "datasource", "renamed"))
+		_, exists, err := kv.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.False(t, exists)
+		value, exists, err := kv.Get(ctx, 1, "renamed", "datasource")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "one", value)
+
+		require.NoError(t, kv.Del(ctx, 2, "ds", "datasource"))
+		require.NoError(t, kv.Del(ctx, 2, "ds", "datasource"))
+		keys, err = kv.Keys(ctx, 2, "ds", "datasource")
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("logs in again with approle when the token is rejected", func(t *testing.T) {
+		vault := newFakeVault(t)
+		kv := setupVaultStore(t, vault, vaultSettings{AuthMethod: VaultAuthAppRole, RoleID: "role", SecretID: "secret"})
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "secret"))
+		assert.Equal(t, 1, vault.logins)
+
+		vault.revokeTokens()
+		_, exists, err := kv.Get(ctx, 1, "ds", "datasource")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, 2, vault.logins)
+	})
+
+	t.Run("logs in with the kubernetes service account token", func(t *testing.T) {
+		vault := newFakeVault(t)
+		tokenPath := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(tokenPath, []byte("jwt\n"), 0600))
+		kv := setupVaultStore(t, vault, vaultSettings{AuthMethod: VaultAuthKubernetes, KubernetesRole: "grafana", KubernetesTokenPath: tokenPath})
+
+		require.NoError(t, kv.Set(ctx, 1, "ds", "datasource", "secret"))
+		assert.Equal(t, 1, vault.logins)
+	})
+
+	t.Run("rejects templates that do not identify secrets", func(t *testing.T) {
+		_, err := newSecretsKVStoreVault(vaultSettings{PathTemplate: "grafana/{{orgId}}/{{type}}", AuthMethod: VaultAuthToken, Token: "root"}, nil, nil, false, log.New("test"))
+		require.Error(t, err)
+	})
+}
+
+func TestProvideService_Vault(t *testing.T) {
+	setup := func(t *testing.T, vault *fakeVault, isFatal bool) (SecretsKVStore, error) {
+		t.Helper()
+		fatalFlagOnce = sync.Once{}
+		sqlStore := sqlstore.InitTestDB(t)
+		kv := kvstore.ProvideService(sqlStore)
+		if isFatal {
+			require.NoError(t, setPluginStartupErrorFatal(context.Background(), GetNamespacedKVStore(kv), true))
+		}
+		raw, err := ini.Load([]byte(`
+			[secrets]
+			backend = vault
+			[secrets.vault]
+			address = ` + vault.server.URL + `
+			token = root
+			`))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			fatalFlagOnce = sync.Once{}
+		})
+		return ProvideService(sqlStore, fakes.FakeSecretsService{}, NewFakeSecretsPluginManager(t, false), kv, NewFakeFeatureToggles(t, false), &setting.Cfg{Raw: raw}, nil, nil, nil, nil, nil, nil, nil, nil, clock.New())
+	}
+
+	t.Run("uses vault when it is healthy", func(t *testing.T) {
+		svc, err := setup(t, newFakeVault(t), false)
+		require.NoError(t, err)
+		require.IsType(t, &secretsKVStoreVault{}, svc.(*CachedKVStore).GetUnwrappedStore())
+	})
+
+	t.Run("falls back to sql when vault is sealed", func(t *testing.T) {
+		vault := newFakeVault(t)
+		vault.sealed = true
+		svc, err := setup(t, vault, false)
+		require.NoError(t, err)
+		require.IsType(t, &secretsKVStoreSQL{}, svc.(*CachedKVStore).GetUnwrappedStore())
+	})
+
+	t.Run("fails when vault is sealed and secrets are only in vault", func(t *testing.T) {
+		vault := newFakeVault(t)
+		vault.sealed = true
+		svc, err := setup(t, vault, true)
+		require.Error(t, err)
+		require.Nil(t, svc)
+	})
+}
+
+func setupVaultStore(t *testing.T, vault *fakeVault, settings vaultSettings) *secretsKVStoreVault {
+	t.Helper()
+	settings.Address = vault.server.URL
+	settings.Mount = "secret"
+	if settings.PathTemplate == "" {
+		settings.PathTemplate = defaultVaultPathTemplate
+	}
+	if settings.AuthMethod == "" {
+		settings.AuthMethod = VaultAuthToken
+		settings.Token = "root"
+	}
+	settings.AppRoleMount = "approle"
+	settings.KubernetesMount = "kubernetes"
+
+	sqlStore := sqlstore.InitTestDB(t)
+	kv, err := newSecretsKVStoreVault(settings, sqlStore, GetNamespacedKVStore(kvstore.ProvideService(sqlStore)), false, log.New("test"))
+	require.NoError(t, err)
+	return kv
+}
+
+// fakeVault serves the parts of the Vault HTTP API used by the vault store
+type fakeVault struct {
+	server  *httptest.Server
+	mu      sync.Mutex
+	secrets map[string]string
+	tokens  map[string]bool
+	logins  int
+	sealed  bool
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	t.Helper()
+	vault := &fakeVault{
+		secrets: map[string]string{},
+		tokens:  map[string]bool{"root": true},
+	}
+	vault.server = httptest.NewServer(http.HandlerFunc(vault.handle))
+	t.Cleanup(vault.server.Close)
+	return vault
+}
+
+func (v *fakeVault) revokeTokens() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.tokens = map[string]bool{"root": true}
+}
+
+func (v *fakeVault) handle(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/v1/")
+	switch {
+	case path == "sys/health":
+		if v.sealed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		return
+	case path == "auth/approle/login" || path == "auth/kubernetes/login":
+		v.logins++
+		token := "token-" + string(rune('a'+v.logins))
+		v.tokens[token] = true
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": token, "lease_duration": 3600}})
+		return
+	}
+
+	if !v.tokens[r.Header.Get("X-Vault-Token")] {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+
+	switch {
+	case path == "auth/token/lookup-self":
+		_, _ = w.Write([]byte(`{}`))
+	case strings.HasPrefix(path, "secret/data/"):
+		key := strings.TrimPrefix(path, "secret/data/")
+		if r.Method == http.MethodPost {
+			var body struct {
+				Data vaultSecretData `json:"data"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			v.secrets[key] = body.Data.Value
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		value, ok := v.secrets[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": vaultSecretData{Value: value}}})
+	case strings.HasPrefix(path, "secret/metadata/"):
+		key := strings.TrimPrefix(path, "secret/metadata/")
+		if _, ok := v.secrets[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(v.secrets, key)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,152 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/grafana/pkg/services/encryption"
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/util"
+)
+
+// EncryptWithAAD encrypts the payload with the current data key of the scope, in the v2 envelope encryption
+// format: the base64 encoded id of the data key between two secrets.EnvelopeV2Delimiter, followed by the salt of
+// the key derived from the data key, the nonce and the AES-GCM ciphertext authenticating the aad. When envelope
+// encryption is disabled, the payload is encrypted with the legacy encryption, which can't bind the aad.
+func (s *SecretsService) EncryptWithAAD(ctx context.Context, payload []byte, aad []byte, opt secrets.EncryptionOptions) ([]byte, error) {
+	if s.features.IsEnabled(featuremgmt.FlagDisableEnvelopeEncryption) {
+		return s.Encrypt(ctx, payload, opt)
+	}
+
+	var err error
+	defer func() {
+		opsCounter.With(prometheus.Labels{
+			"success":   strconv.FormatBool(err == nil),
+			"operation": OpEncrypt,
+		}).Inc()
+	}()
+
+	scope := opt()
+	label := secrets.KeyLabel(scope, s.currentProviderID)
+
+	var id string
+	var dataKey []byte
+	id, dataKey, err = s.currentDataKey(ctx, label, scope, nil)
+	if err != nil {
+		s.log.Error("Failed to get current data key", "error", err, "label", label)
+		return nil, err
+	}
+
+	var sealed []byte
+	sealed, err = sealWithAAD(dataKey, payload, aad)
+	if err != nil {
+		s.log.Error("Failed to encrypt secret", "error", err)
+		return nil, err
+	}
+
+	prefix := make([]byte, b64.EncodedLen(len(id))+2)
+	b64.Encode(prefix[1:], []byte(id))
+	prefix[0] = secrets.EnvelopeV2Delimiter
+	prefix[len(prefix)-1] = secrets.EnvelopeV2Delimiter
+
+	return append(prefix, sealed...), nil
+}
+
+// DecryptWithAAD decrypts the payloads in the v2 envelope encryption format, which only succeeds with the aad
+// they were encrypted with. The other payloads are decrypted by Decrypt, regardless of the aad.
+func (s *SecretsService) DecryptWithAAD(ctx context.Context, payload []byte, aad []byte) ([]byte, error) {
+	if len(payload) == 0 || payload[0] != secrets.EnvelopeV2Delimiter {
+		return s.Decrypt(ctx, payload)
+	}
+
+	var err error
+	defer func() {
+		opsCounter.With(prometheus.Labels{
+			"success":   strconv.FormatBool(err == nil),
+			"operation": OpDecrypt,
+		}).Inc()
+
+		if err != nil {
+			s.log.Error("Failed to decrypt secret", "error", err)
+		}
+	}()
+
+	payload = payload[1:]
+	endOfKey := bytes.IndexByte(payload, secrets.EnvelopeV2Delimiter)
+	if endOfKey == -1 {
+		err = fmt.Errorf("could not find valid key id in encrypted payload")
+		return nil, err
+	}
+	var keyId []byte
+	keyId, err = b64.DecodeString(string(payload[:endOfKey]))
+	if err != nil {
+		return nil, err
+	}
+
+	var dataKey []byte
+	dataKey, err = s.dataKeyById(ctx, string(keyId))
+	if err != nil {
+		s.log.Error("Failed to lookup data key by id", "id", string(keyId), "error", err)
+		return nil, err
+	}
+
+	var decrypted []byte
+	decrypted, err = openWithAAD(dataKey, payload[endOfKey+1:], aad)
+	return decrypted, err
+}
+
+func aeadWithSalt(dataKey []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := encryption.KeyToBytes(string(dataKey), string(salt))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealWithAAD(dataKey []byte, payload []byte, aad []byte) ([]byte, error) {
+	salt, err := util.GetRandomString(encryption.SaltLength)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := aeadWithSalt(dataKey, []byte(salt))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := append([]byte(salt), nonce...)
+	return aead.Seal(sealed, nonce, payload, aad), nil
+}
+
+func openWithAAD(dataKey []byte, sealed []byte, aad []byte) ([]byte, error) {
+	if len(sealed) < encryption.SaltLength {
+		return nil, errors.New("unable to compute salt")
+	}
+	aead, err := aeadWithSalt(dataKey, sealed[:encryption.SaltLength])
+	if err != nil {
+		return nil, err
+	}
+
+	sealed = sealed[encryption.SaltLength:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("payload too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+}
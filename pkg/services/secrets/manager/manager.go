@@ -328,6 +328,11 @@ func newRandomDataKey() ([]byte, error) {
 }
 
 func (s *SecretsService) Decrypt(ctx context.Context, payload []byte) ([]byte, error) {
+	// payloads in the v2 format were encrypted without additional authenticated data when it is nil
+	if len(payload) > 0 && payload[0] == secrets.EnvelopeV2Delimiter {
+		return s.DecryptWithAAD(ctx, payload, nil)
+	}
+
 	var err error
 	defer func() {
 		opsCounter.With(prometheus.Labels{
@@ -513,7 +518,34 @@ func (s *SecretsService) ReEncryptDataKeys(ctx context.Context) error {
 	return nil
 }
 
+// reEncryptDataKeysOnProviderChange re-encrypts the data keys with the current provider when some of them were
+// encrypted with another one, e.g. after the encryption provider was changed from secretKey.v1 to awskms.
+func (s *SecretsService) reEncryptDataKeysOnProviderChange(ctx context.Context) error {
+	if s.features.IsEnabled(featuremgmt.FlagDisableEnvelopeEncryption) ||
+		!s.settings.KeyValue("security.encryption", "re_encrypt_data_keys_on_provider_change").MustBool(true) {
+		return nil
+	}
+
+	keys, err := s.store.GetAllDataKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if kmsproviders.NormalizeProviderID(k.Provider) != s.currentProviderID {
+			s.log.Info("Encryption provider changed, re-encrypting data keys", "previous provider", k.Provider, "current provider", s.currentProviderID)
+			return s.ReEncryptDataKeys(ctx)
+		}
+	}
+
+	return nil
+}
+
 func (s *SecretsService) Run(ctx context.Context) error {
+	if err := s.reEncryptDataKeysOnProviderChange(ctx); err != nil {
+		s.log.Error("Failed to re-encrypt data keys with the current encryption provider", "error", err)
+	}
+
 	gc := time.NewTicker(
 		s.settings.KeyValue("security.encryption", "data_keys_cache_cleanup_interval").
 			MustDuration(time.Minute),
@@ -9,6 +9,7 @@ import (
 	encryptionprovider "github.com/grafana/grafana/pkg/services/encryption/provider"
 	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/grafana/grafana/pkg/services/kmsproviders"
 	"github.com/grafana/grafana/pkg/services/kmsproviders/osskmsproviders"
 	"github.com/grafana/grafana/pkg/services/secrets"
 	"github.com/grafana/grafana/pkg/services/secrets/database"
@@ -400,3 +401,116 @@ func TestSecretsService_Decrypt(t *testing.T) {
 		assert.Equal(t, []byte("grafana"), decrypted)
 	})
 }
+
+func TestSecretsService_ReEncryptDataKeysOnProviderChange(t *testing.T) {
+	setup := func(t *testing.T, store secrets.Store, rawCfg string) (*SecretsService, *fakeKMS) {
+		t.Helper()
+		raw, err := ini.Load([]byte(rawCfg))
+		require.NoError(t, err)
+		settings := &setting.OSSImpl{Cfg: &setting.Cfg{Raw: raw}}
+
+		encryptionService, err := encryptionservice.ProvideEncryptionService(encryptionprovider.Provider{}, &usagestats.UsageStatsMock{}, settings)
+		require.NoError(t, err)
+
+		features := featuremgmt.WithFeatures()
+		kms := newFakeKMS(osskmsproviders.ProvideService(encryptionService, settings, features))
+		svc, err := ProvideSecretsService(store, &kms, encryptionService, settings, features, &usagestats.UsageStatsMock{T: t})
+		require.NoError(t, err)
+		return svc, &kms
+	}
+
+	t.Run("data keys are re-encrypted with the new current provider", func(t *testing.T) {
+		store := database.ProvideSecretsStore(sqlstore.InitTestDB(t))
+		_, err := SetupTestService(t, store).Encrypt(context.Background(), []byte("grafana"), secrets.WithoutScope())
+		require.NoError(t, err)
+
+		svc, kms := setup(t, store, `
+		[security]
+		secret_key = SdlklWklckeLS
+		encryption_provider = fakeProvider.v1
+		`)
+		require.NoError(t, svc.reEncryptDataKeysOnProviderChange(context.Background()))
+		assert.True(t, kms.fake.encryptCalled)
+
+		keys, err := store.GetAllDataKeys(context.Background())
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, secrets.ProviderID("fakeProvider.v1"), keys[0].Provider)
+	})
+
+	t.Run("data keys are not re-encrypted when disabled", func(t *testing.T) {
+		store := database.ProvideSecretsStore(sqlstore.InitTestDB(t))
+		_, err := SetupTestService(t, store).Encrypt(context.Background(), []byte("grafana"), secrets.WithoutScope())
+		require.NoError(t, err)
+
+		svc, kms := setup(t, store, `
+		[security]
+		secret_key = SdlklWklckeLS
+		encryption_provider = fakeProvider.v1
+
+		[security.encryption]
+		re_encrypt_data_keys_on_provider_change = false
+		`)
+		require.NoError(t, svc.reEncryptDataKeysOnProviderChange(context.Background()))
+		assert.False(t, kms.fake.encryptCalled)
+
+		keys, err := store.GetAllDataKeys(context.Background())
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, secrets.ProviderID(kmsproviders.Default), keys[0].Provider)
+	})
+}
+
+func TestSecretsService_AAD(t *testing.T) {
+	ctx := context.Background()
+	store := database.ProvideSecretsStore(sqlstore.InitTestDB(t))
+	svc := SetupTestService(t, store)
+	aad := secrets.ContextAAD(1, "datasource-uid", "datasource")
+
+	t.Run("payload encrypted with aad should be decrypted with the same aad", func(t *testing.T) {
+		ciphertext, err := svc.EncryptWithAAD(ctx, []byte("grafana"), aad, secrets.WithScope("org:1"))
+		require.NoError(t, err)
+		assert.Equal(t, byte(secrets.EnvelopeV2Delimiter), ciphertext[0])
+
+		plaintext, err := svc.DecryptWithAAD(ctx, ciphertext, aad)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("grafana"), plaintext)
+	})
+
+	t.Run("payload encrypted with aad should not be decrypted in another context", func(t *testing.T) {
+		ciphertext, err := svc.EncryptWithAAD(ctx, []byte("grafana"), aad, secrets.WithScope("org:1"))
+		require.NoError(t, err)
+
+		_, err = svc.DecryptWithAAD(ctx, ciphertext, secrets.ContextAAD(1, "another-uid", "datasource"))
+		assert.Error(t, err)
+		_, err = svc.DecryptWithAAD(ctx, ciphertext, secrets.ContextAAD(2, "datasource-uid", "datasource"))
+		assert.Error(t, err)
+		_, err = svc.Decrypt(ctx, ciphertext)
+		assert.Error(t, err)
+	})
+
+	t.Run("payload encrypted without aad should be decrypted by Decrypt", func(t *testing.T) {
+		ciphertext, err := svc.EncryptWithAAD(ctx, []byte("grafana"), nil, secrets.WithoutScope())
+		require.NoError(t, err)
+
+		plaintext, err := svc.Decrypt(ctx, ciphertext)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("grafana"), plaintext)
+	})
+
+	t.Run("v1 payload should be decrypted regardless of the aad", func(t *testing.T) {
+		ciphertext, err := svc.Encrypt(ctx, []byte("grafana"), secrets.WithoutScope())
+		require.NoError(t, err)
+
+		plaintext, err := svc.DecryptWithAAD(ctx, ciphertext, aad)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("grafana"), plaintext)
+	})
+
+	t.Run("payload encrypted with aad with ee disabled should use the legacy encryption", func(t *testing.T) {
+		disabled := SetupDisabledTestService(t, store)
+		ciphertext, err := disabled.EncryptWithAAD(ctx, []byte("grafana"), aad, secrets.WithoutScope())
+		require.NoError(t, err)
+		assert.NotEqual(t, byte(secrets.EnvelopeV2Delimiter), ciphertext[0])
+	})
+}
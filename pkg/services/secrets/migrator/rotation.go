@@ -0,0 +1,114 @@
+package migrator
+
+import (
+	"context"
+	"time"
+
+	"github.com/benbjohnson/clock"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// how often the age of the active data keys is checked
+const dataKeyRotationCheckInterval = time.Hour
+
+// DataKeyRotationService rotates the data keys once the oldest active data key is older than the rotation
+// period, then re-encrypts the secrets so that the retired data keys are no longer referenced and can
+// eventually be deleted, see SecretsMigrator.DataKeyUsage.
+type DataKeyRotationService struct {
+	secretsSrv *manager.SecretsService
+	migrator   *SecretsMigrator
+	serverLock *serverlock.ServerLockService
+	features   featuremgmt.FeatureToggles
+	clock      clock.Clock
+	period     time.Duration
+	log        log.Logger
+}
+
+func ProvideDataKeyRotationService(
+	service *manager.SecretsService,
+	migrator *SecretsMigrator,
+	serverLock *serverlock.ServerLockService,
+	features featuremgmt.FeatureToggles,
+	cfg *setting.Cfg,
+	clk clock.Clock,
+) *DataKeyRotationService {
+	days := cfg.SectionWithEnvOverrides("security.encryption").Key("data_keys_rotation_days").MustInt(0)
+	return &DataKeyRotationService{
+		secretsSrv: service,
+		migrator:   migrator,
+		serverLock: serverLock,
+		features:   features,
+		clock:      clk,
+		period:     time.Duration(days) * 24 * time.Hour,
+		log:        log.New("secrets.rotation"),
+	}
+}
+
+// IsDisabled returns true when the rotation period is not positive or envelope encryption is disabled
+func (s *DataKeyRotationService) IsDisabled() bool {
+	return s.period <= 0 || s.features.IsEnabled(featuremgmt.FlagDisableEnvelopeEncryption)
+}
+
+func (s *DataKeyRotationService) Run(ctx context.Context) error {
+	ticker := s.clock.Ticker(dataKeyRotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		// a single instance rotates the data keys and re-encrypts the secrets
+		err := s.serverLock.LockAndExecute(ctx, "rotate data keys", dataKeyRotationCheckInterval, func(ctx context.Context) {
+			if _, err := s.RotateIfDue(ctx); err != nil {
+				s.log.Error("Failed to rotate data keys", "error", err)
+			}
+		})
+		if err != nil {
+			s.log.Error("Failed to lock and execute data keys rotation", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RotateIfDue rotates the data keys and re-encrypts the secrets when the oldest active data key is older
+// than the rotation period. It returns whether the data keys were rotated.
+func (s *DataKeyRotationService) RotateIfDue(ctx context.Context) (bool, error) {
+	var dataKeys []*secrets.DataKey
+	if err := s.migrator.sqlStore.NewSession(ctx).Table("data_keys").Where("active = ?", s.migrator.sqlStore.Dialect.BooleanStr(true)).Find(&dataKeys); err != nil {
+		return false, err
+	}
+
+	var oldest *secrets.DataKey
+	for _, k := range dataKeys {
+		if oldest == nil || k.Created.Before(oldest.Created) {
+			oldest = k
+		}
+	}
+	if oldest == nil || s.clock.Since(oldest.Created) < s.period {
+		return false, nil
+	}
+
+	s.log.Info("Rotating data keys", "oldest data key", oldest.Id, "created", oldest.Created, "period", s.period)
+	if err := s.secretsSrv.RotateDataKeys(ctx); err != nil {
+		return false, err
+	}
+
+	success, err := s.migrator.ReEncryptSecrets(ctx)
+	if err != nil {
+		return true, err
+	}
+	if !success {
+		s.log.Warn("Secrets re-encrypted with errors after data keys rotation, the retired data keys are still referenced")
+		return true, nil
+	}
+	s.log.Info("Secrets re-encrypted after data keys rotation")
+	return true, nil
+}
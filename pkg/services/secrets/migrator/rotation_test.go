@@ -0,0 +1,74 @@
+package migrator
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/infra/usagestats"
+	encryptionprovider "github.com/grafana/grafana/pkg/services/encryption/provider"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/services/secrets/database"
+	"github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestDataKeyRotationService(t *testing.T) {
+	ctx := context.Background()
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := manager.SetupTestService(t, database.ProvideSecretsStore(sqlStore))
+	settings := setting.ProvideProvider(sqlStore.Cfg)
+	encryptionService, err := encryptionservice.ProvideEncryptionService(encryptionprovider.ProvideEncryptionProvider(), &usagestats.UsageStatsMock{T: t}, settings)
+	require.NoError(t, err)
+	features := featuremgmt.WithFeatures()
+	migrator := ProvideSecretsMigrator(encryptionService, secretsService, sqlStore, settings, features)
+
+	encrypted, err := secretsService.Encrypt(ctx, []byte("secret"), secrets.WithoutScope())
+	require.NoError(t, err)
+	_, err = sqlStore.NewSession(ctx).Exec("INSERT INTO secrets (org_id, namespace, type, value, created, updated) VALUES (?, ?, ?, ?, ?, ?)",
+		1, "ds", "datasource", base64.RawStdEncoding.EncodeToString(encrypted), time.Now(), time.Now())
+	require.NoError(t, err)
+
+	usage, err := migrator.DataKeyUsage(ctx)
+	require.NoError(t, err)
+	require.Len(t, usage, 1)
+	assert.True(t, usage[0].Active)
+	assert.Equal(t, map[string]int{"secrets.value": 1}, usage[0].References)
+	assert.Equal(t, 1, usage[0].Total)
+
+	sqlStore.Cfg.Raw.Section("security.encryption").Key("data_keys_rotation_days").SetValue("1")
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	s := ProvideDataKeyRotationService(secretsService, migrator, serverlock.ProvideService(sqlStore), features, sqlStore.Cfg, clk)
+	require.False(t, s.IsDisabled())
+
+	t.Run("data keys younger than the rotation period are not rotated", func(t *testing.T) {
+		rotated, err := s.RotateIfDue(ctx)
+		require.NoError(t, err)
+		assert.False(t, rotated)
+	})
+
+	t.Run("data keys older than the rotation period are retired and the secrets re-encrypted", func(t *testing.T) {
+		clk.Add(25 * time.Hour)
+		rotated, err := s.RotateIfDue(ctx)
+		require.NoError(t, err)
+		assert.True(t, rotated)
+
+		usage, err := migrator.DataKeyUsage(ctx)
+		require.NoError(t, err)
+		require.Len(t, usage, 2)
+		assert.False(t, usage[0].Active)
+		assert.Equal(t, 0, usage[0].Total)
+		assert.True(t, usage[1].Active)
+		assert.Equal(t, map[string]int{"secrets.value": 1}, usage[1].References)
+	})
+}
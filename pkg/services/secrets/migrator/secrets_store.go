@@ -0,0 +1,137 @@
+package migrator
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/grafana/grafana/pkg/services/encryption"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// secretsStoreSecret is the value column of the secrets table, whose values are bound to their organization,
+// namespace and type as additional authenticated data, see secrets.ContextAAD
+type secretsStoreSecret struct{}
+
+type secretsStoreRow struct {
+	Id        int64
+	OrgId     int64
+	Namespace string
+	Type      string
+	Value     string
+}
+
+func (s secretsStoreSecret) rows(ctx context.Context, sqlStore *sqlstore.SQLStore) ([]secretsStoreRow, error) {
+	var rows []secretsStoreRow
+	err := sqlStore.NewSession(ctx).Table("secrets").Cols("id", "org_id", "namespace", "type", "value").Find(&rows)
+	return rows, err
+}
+
+// update replaces the value of a row, unless it was updated since it was read
+func (s secretsStoreSecret) update(ctx context.Context, sqlStore *sqlstore.SQLStore, row secretsStoreRow, encrypted []byte) error {
+	return sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("UPDATE secrets SET value = ?, updated = ? WHERE id = ? AND value = ?",
+			base64.RawStdEncoding.EncodeToString(encrypted), nowInUTC(), row.Id, row.Value)
+		return err
+	})
+}
+
+func (s secretsStoreSecret) decrypt(ctx context.Context, secretsSrv *manager.SecretsService, row secretsStoreRow) ([]byte, error) {
+	decoded, err := base64.RawStdEncoding.DecodeString(row.Value)
+	if err != nil {
+		return nil, err
+	}
+	return secretsSrv.DecryptWithAAD(ctx, decoded, secrets.ContextAAD(row.OrgId, row.Namespace, row.Type))
+}
+
+// reencrypt re-encrypts the values outside of a transaction, EncryptWithAAD must not be used within one
+func (s secretsStoreSecret) reencrypt(ctx context.Context, secretsSrv *manager.SecretsService, sqlStore *sqlstore.SQLStore) bool {
+	rows, err := s.rows(ctx, sqlStore)
+	if err != nil {
+		logger.Warn("Could not find any secret to re-encrypt", "table", "secrets")
+		return false
+	}
+
+	var anyFailure bool
+
+	for _, row := range rows {
+		if len(row.Value) == 0 {
+			continue
+		}
+
+		decrypted, err := s.decrypt(ctx, secretsSrv, row)
+		if err != nil {
+			logger.Warn("Could not decrypt secret while re-encrypting it", "table", "secrets", "id", row.Id, "error", err)
+			anyFailure = true
+			continue
+		}
+
+		encrypted, err := secretsSrv.EncryptWithAAD(ctx, decrypted, secrets.ContextAAD(row.OrgId, row.Namespace, row.Type), secrets.WithoutScope())
+		if err != nil {
+			logger.Warn("Could not encrypt secret while re-encrypting it", "table", "secrets", "id", row.Id, "error", err)
+			anyFailure = true
+			continue
+		}
+
+		if err := s.update(ctx, sqlStore, row, encrypted); err != nil {
+			logger.Warn("Could not update secret while re-encrypting it", "table", "secrets", "id", row.Id, "error", err)
+			anyFailure = true
+		}
+	}
+
+	if anyFailure {
+		logger.Warn("Column value from secrets has been re-encrypted with errors")
+	} else {
+		logger.Info("Column value from secrets has been re-encrypted successfully")
+	}
+
+	return !anyFailure
+}
+
+func (s secretsStoreSecret) rollback(
+	ctx context.Context,
+	secretsSrv *manager.SecretsService,
+	encryptionSrv encryption.Internal,
+	sqlStore *sqlstore.SQLStore,
+	secretKey string,
+) (anyFailure bool) {
+	rows, err := s.rows(ctx, sqlStore)
+	if err != nil {
+		logger.Warn("Could not find any secret to roll back", "table", "secrets")
+		return true
+	}
+
+	for _, row := range rows {
+		if len(row.Value) == 0 {
+			continue
+		}
+
+		decrypted, err := s.decrypt(ctx, secretsSrv, row)
+		if err != nil {
+			logger.Warn("Could not decrypt secret while rolling it back", "table", "secrets", "id", row.Id, "error", err)
+			anyFailure = true
+			continue
+		}
+
+		encrypted, err := encryptionSrv.Encrypt(ctx, decrypted, secretKey)
+		if err != nil {
+			logger.Warn("Could not encrypt secret while rolling it back", "table", "secrets", "id", row.Id, "error", err)
+			anyFailure = true
+			continue
+		}
+
+		if err := s.update(ctx, sqlStore, row, encrypted); err != nil {
+			logger.Warn("Could not update secret while rolling it back", "table", "secrets", "id", row.Id, "error", err)
+			anyFailure = true
+		}
+	}
+
+	if anyFailure {
+		logger.Warn("Column value from secrets has been rolled back with errors")
+	} else {
+		logger.Info("Column value from secrets has been rolled back successfully")
+	}
+
+	return anyFailure
+}
@@ -0,0 +1,161 @@
+package migrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/services/ngalert/notifier"
+	"github.com/grafana/grafana/pkg/services/secrets"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// usageCounter counts the values encrypted with each data key, by table and column
+type usageCounter map[string]map[string]int
+
+func (u usageCounter) add(column string, payload []byte) {
+	// the envelope encryption formats of the secrets service: the payload starts with
+	// the base64 encoded id of its data key between two delimiters
+	if len(payload) == 0 || (payload[0] != secrets.EnvelopeV1Delimiter && payload[0] != secrets.EnvelopeV2Delimiter) {
+		return
+	}
+	end := bytes.IndexByte(payload[1:], payload[0])
+	if end < 0 {
+		return
+	}
+	id, err := base64.RawStdEncoding.DecodeString(string(payload[1 : end+1]))
+	if err != nil {
+		return
+	}
+	if u[string(id)] == nil {
+		u[string(id)] = make(map[string]int)
+	}
+	u[string(id)][column]++
+}
+
+// DataKeyUsage counts the secrets encrypted with each data key, in the tables
+// re-encrypted by ReEncryptSecrets and in the history of the secrets store.
+func (m *SecretsMigrator) DataKeyUsage(ctx context.Context) ([]*secrets.DataKeyUsage, error) {
+	toCount := []interface {
+		countUsage(context.Context, *sqlstore.SQLStore, usageCounter) error
+	}{
+		simpleSecret{tableName: "dashboard_snapshot", columnName: "dashboard_encrypted"},
+		b64Secret{simpleSecret: simpleSecret{tableName: "user_auth", columnName: "o_auth_access_token"}, encoding: base64.StdEncoding},
+		b64Secret{simpleSecret: simpleSecret{tableName: "user_auth", columnName: "o_auth_refresh_token"}, encoding: base64.StdEncoding},
+		b64Secret{simpleSecret: simpleSecret{tableName: "user_auth", columnName: "o_auth_token_type"}, encoding: base64.StdEncoding},
+		b64Secret{simpleSecret: simpleSecret{tableName: "secrets", columnName: "value"}, encoding: base64.RawStdEncoding},
+		b64Secret{simpleSecret: simpleSecret{tableName: "secrets_history", columnName: "value"}, encoding: base64.RawStdEncoding},
+		jsonSecret{tableName: "data_source"},
+		jsonSecret{tableName: "plugin_setting"},
+		alertingSecret{},
+	}
+
+	usage := usageCounter{}
+	for _, c := range toCount {
+		if err := c.countUsage(ctx, m.sqlStore, usage); err != nil {
+			return nil, err
+		}
+	}
+
+	var dataKeys []*secrets.DataKey
+	if err := m.sqlStore.NewSession(ctx).Table("data_keys").Find(&dataKeys); err != nil {
+		return nil, err
+	}
+
+	result := make([]*secrets.DataKeyUsage, 0, len(dataKeys))
+	for _, k := range dataKeys {
+		dataKeyUsage := &secrets.DataKeyUsage{
+			Id:         k.Id,
+			Label:      k.Label,
+			Scope:      k.Scope,
+			Provider:   k.Provider,
+			Active:     k.Active,
+			Created:    k.Created,
+			Updated:    k.Updated,
+			References: map[string]int{},
+		}
+		for column, count := range usage[k.Id] {
+			dataKeyUsage.References[column] = count
+			dataKeyUsage.Total += count
+		}
+		result = append(result, dataKeyUsage)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Created.Before(result[j].Created) })
+
+	return result, nil
+}
+
+func (s simpleSecret) countUsage(ctx context.Context, sqlStore *sqlstore.SQLStore, usage usageCounter) error {
+	var rows []struct {
+		Secret []byte
+	}
+	if err := sqlStore.NewSession(ctx).Table(s.tableName).Select(fmt.Sprintf("%s as secret", s.columnName)).Find(&rows); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		usage.add(s.tableName+"."+s.columnName, row.Secret)
+	}
+	return nil
+}
+
+func (s b64Secret) countUsage(ctx context.Context, sqlStore *sqlstore.SQLStore, usage usageCounter) error {
+	var rows []struct {
+		Secret string
+	}
+	if err := sqlStore.NewSession(ctx).Table(s.tableName).Select(fmt.Sprintf("%s as secret", s.columnName)).Find(&rows); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		decoded, err := s.encoding.DecodeString(row.Secret)
+		if err != nil {
+			continue
+		}
+		usage.add(s.tableName+"."+s.columnName, decoded)
+	}
+	return nil
+}
+
+func (s jsonSecret) countUsage(ctx context.Context, sqlStore *sqlstore.SQLStore, usage usageCounter) error {
+	var rows []struct {
+		SecureJsonData map[string][]byte
+	}
+	if err := sqlStore.NewSession(ctx).Table(s.tableName).Cols("secure_json_data").Find(&rows); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		for _, value := range row.SecureJsonData {
+			usage.add(s.tableName+".secure_json_data", value)
+		}
+	}
+	return nil
+}
+
+func (s alertingSecret) countUsage(ctx context.Context, sqlStore *sqlstore.SQLStore, usage usageCounter) error {
+	var results []struct {
+		AlertmanagerConfiguration string
+	}
+	if err := sqlStore.NewSession(ctx).SQL("SELECT alertmanager_configuration FROM alert_configuration").Find(&results); err != nil {
+		return err
+	}
+	for _, result := range results {
+		postableUserConfig, err := notifier.Load([]byte(result.AlertmanagerConfiguration))
+		if err != nil {
+			logger.Warn("Could not load alert_configuration while counting the data keys usage", "error", err)
+			continue
+		}
+		for _, receiver := range postableUserConfig.AlertmanagerConfig.Receivers {
+			for _, gmr := range receiver.GrafanaManagedReceivers {
+				for _, v := range gmr.SecureSettings {
+					decoded, err := base64.StdEncoding.DecodeString(v)
+					if err != nil {
+						continue
+					}
+					usage.add("alert_configuration.alertmanager_configuration", decoded)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -19,6 +19,15 @@ type Service interface {
 	Encrypt(ctx context.Context, payload []byte, opt EncryptionOptions) ([]byte, error)
 	Decrypt(ctx context.Context, payload []byte) ([]byte, error)
 
+	// EncryptWithAAD encrypts the payload in the v2 envelope encryption format, which binds the
+	// ciphertext to the additional authenticated data, e.g. the context the payload is stored in,
+	// so that it can only be decrypted with the same additional authenticated data.
+	// Like Encrypt, it MUST NOT be used within database transactions.
+	EncryptWithAAD(ctx context.Context, payload []byte, aad []byte, opt EncryptionOptions) ([]byte, error)
+	// DecryptWithAAD decrypts payloads of every format, the additional authenticated data is
+	// only checked for the payloads in the v2 envelope encryption format.
+	DecryptWithAAD(ctx context.Context, payload []byte, aad []byte) ([]byte, error)
+
 	// EncryptJsonData MUST NOT be used within database transactions.
 	// Look at Encrypt method comment for further details.
 	EncryptJsonData(ctx context.Context, kv map[string]string, opt EncryptionOptions) (map[string][]byte, error)
@@ -82,4 +91,8 @@ type Migrator interface {
 	// does not stop, but returns false as the first return (success or not)
 	// at the end of the process.
 	RollBackSecrets(ctx context.Context) (bool, error)
+	// DataKeyUsage returns the data keys along with the number of secrets
+	// encrypted with each of them, so that the retired data keys that are
+	// still referenced can be told apart from the ones that can be deleted.
+	DataKeyUsage(ctx context.Context) ([]*DataKeyUsage, error)
 }
@@ -1,6 +1,7 @@
 package secrets
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 )
@@ -18,6 +19,37 @@ type DataKey struct {
 	Updated       time.Time
 }
 
+// DataKeyUsage is a data key along with the number of values encrypted with it, by table and column. Retired
+// data keys, disabled by a rotation, can be deleted once no value references them anymore.
+type DataKeyUsage struct {
+	Id         string         `json:"id"`
+	Label      string         `json:"label"`
+	Scope      string         `json:"scope"`
+	Provider   ProviderID     `json:"provider"`
+	Active     bool           `json:"active"`
+	Created    time.Time      `json:"created"`
+	Updated    time.Time      `json:"updated"`
+	References map[string]int `json:"references"`
+	Total      int            `json:"total"`
+}
+
+const (
+	// EnvelopeV1Delimiter surrounds the id of the data key at the beginning of the payloads
+	// encrypted with envelope encryption
+	EnvelopeV1Delimiter = '#'
+	// EnvelopeV2Delimiter surrounds the id of the data key at the beginning of the payloads
+	// encrypted with envelope encryption and bound to additional authenticated data
+	EnvelopeV2Delimiter = '$'
+)
+
+// ContextAAD is the additional authenticated data binding a secret to the organization,
+// namespace and type it is stored with, see Service.EncryptWithAAD.
+func ContextAAD(orgId int64, namespace string, typ string) []byte {
+	// a JSON array, so that the namespace and the type can't be shifted into each other
+	aad, _ := json.Marshal([]interface{}{orgId, namespace, typ})
+	return aad
+}
+
 type EncryptionOptions func() string
 
 // WithoutScope uses a root level data key for encryption (DEK),
@@ -68,14 +68,24 @@ func (api *ServiceAccountsAPI) RegisterAPIEndpoints() {
 			accesscontrol.EvalPermission(serviceaccounts.ActionWrite, serviceaccounts.ScopeID)), routing.Wrap(api.CreateToken))
 		serviceAccountsRoute.Delete("/:serviceAccountId/tokens/:tokenId", auth(middleware.ReqOrgAdmin,
 			accesscontrol.EvalPermission(serviceaccounts.ActionWrite, serviceaccounts.ScopeID)), routing.Wrap(api.DeleteToken))
+		serviceAccountsRoute.Get("/:serviceAccountId/rotation-policy", auth(middleware.ReqOrgAdmin,
+			accesscontrol.EvalPermission(serviceaccounts.ActionRead, serviceaccounts.ScopeID)), routing.Wrap(api.GetTokenRotationPolicy))
+		serviceAccountsRoute.Put("/:serviceAccountId/rotation-policy", auth(middleware.ReqOrgAdmin,
+			accesscontrol.EvalPermission(serviceaccounts.ActionWrite, serviceaccounts.ScopeID)), routing.Wrap(api.SetTokenRotationPolicy))
+		serviceAccountsRoute.Delete("/:serviceAccountId/rotation-policy", auth(middleware.ReqOrgAdmin,
+			accesscontrol.EvalPermission(serviceaccounts.ActionWrite, serviceaccounts.ScopeID)), routing.Wrap(api.DeleteTokenRotationPolicy))
 		serviceAccountsRoute.Get("/migrationstatus", auth(middleware.ReqOrgAdmin,
 			accesscontrol.EvalPermission(serviceaccounts.ActionRead)), routing.Wrap(api.GetAPIKeysMigrationStatus))
+		serviceAccountsRoute.Get("/migrationreport", auth(middleware.ReqOrgAdmin,
+			accesscontrol.EvalPermission(serviceaccounts.ActionRead)), routing.Wrap(api.GetAPIKeysMigrationReport))
 		serviceAccountsRoute.Post("/hideApiKeys", auth(middleware.ReqOrgAdmin,
 			accesscontrol.EvalPermission(serviceaccounts.ActionCreate)), routing.Wrap(api.HideApiKeysTab))
 		serviceAccountsRoute.Post("/migrate", auth(middleware.ReqOrgAdmin,
 			accesscontrol.EvalPermission(serviceaccounts.ActionCreate)), routing.Wrap(api.MigrateApiKeysToServiceAccounts))
 		serviceAccountsRoute.Post("/migrate/:keyId", auth(middleware.ReqOrgAdmin,
 			accesscontrol.EvalPermission(serviceaccounts.ActionCreate)), routing.Wrap(api.ConvertToServiceAccount))
+		serviceAccountsRoute.Post("/revertmigration", auth(middleware.ReqOrgAdmin,
+			accesscontrol.EvalPermission(serviceaccounts.ActionDelete, serviceaccounts.ScopeAll)), routing.Wrap(api.RevertApiKeysMigration))
 		serviceAccountsRoute.Post("/:serviceAccountId/revert/:keyId", auth(middleware.ReqOrgAdmin,
 			accesscontrol.EvalPermission(serviceaccounts.ActionDelete, serviceaccounts.ScopeID)), routing.Wrap(api.RevertApiKey))
 	})
@@ -335,6 +345,15 @@ func (api *ServiceAccountsAPI) GetAPIKeysMigrationStatus(ctx *models.ReqContext)
 	return response.JSON(http.StatusOK, upgradeStatus)
 }
 
+// GET /api/serviceaccounts/migrationreport
+func (api *ServiceAccountsAPI) GetAPIKeysMigrationReport(ctx *models.ReqContext) response.Response {
+	report, err := api.store.GetAPIKeysMigrationReport(ctx.Req.Context(), ctx.OrgID)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Internal server error", err)
+	}
+	return response.JSON(http.StatusOK, report)
+}
+
 // POST /api/serviceaccounts/hideapikeys
 func (api *ServiceAccountsAPI) HideApiKeysTab(ctx *models.ReqContext) response.Response {
 	if err := api.store.HideApiKeysTab(ctx.Req.Context(), ctx.OrgID); err != nil {
@@ -383,6 +402,15 @@ func (api *ServiceAccountsAPI) RevertApiKey(ctx *models.ReqContext) response.Res
 	return response.Success("reverted service account to API key")
 }
 
+// POST /api/serviceaccounts/revertmigration
+func (api *ServiceAccountsAPI) RevertApiKeysMigration(ctx *models.ReqContext) response.Response {
+	report, err := api.store.RevertApiKeysMigration(ctx.Req.Context(), ctx.OrgID)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "error reverting service accounts to API keys", err)
+	}
+	return response.JSON(http.StatusOK, report)
+}
+
 func (api *ServiceAccountsAPI) getAccessControlMetadata(c *models.ReqContext, saIDs map[string]bool) map[string]accesscontrol.Metadata {
 	if api.accesscontrol.IsDisabled() || !c.QueryBool("accesscontrol") {
 		return map[string]accesscontrol.Metadata{}
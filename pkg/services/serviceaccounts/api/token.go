@@ -157,6 +157,7 @@ func (api *ServiceAccountsAPI) CreateToken(c *models.ReqContext) response.Respon
 	}
 
 	cmd.Key = newKeyInfo.HashedKey
+	cmd.Fingerprint = apikey.LeakHash(newKeyInfo.ClientSecret)
 
 	if err := api.store.AddServiceAccountToken(c.Req.Context(), saID, &cmd); err != nil {
 		if errors.Is(err, database.ErrInvalidTokenExpiration) {
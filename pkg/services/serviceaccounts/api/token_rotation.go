@@ -0,0 +1,149 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/grafana/grafana/pkg/api/response"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts"
+	"github.com/grafana/grafana/pkg/web"
+)
+
+// swagger:route GET /serviceaccounts/{serviceAccountId}/rotation-policy service_accounts getTokenRotationPolicy
+//
+// Get the token rotation policy of a service account
+//
+// Required permissions (See note in the [introduction](https://grafana.com/docs/grafana/latest/developers/http_api/serviceaccount/#service-account-api) for an explanation):
+// action: `serviceaccounts:read` scope: `serviceaccounts:id:1` (single service account)
+//
+// Responses:
+// 200: tokenRotationPolicyResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (api *ServiceAccountsAPI) GetTokenRotationPolicy(c *models.ReqContext) response.Response {
+	saID, err := strconv.ParseInt(web.Params(c.Req)[":serviceAccountId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Service Account ID is invalid", err)
+	}
+
+	policy, err := api.store.GetTokenRotationPolicy(c.Req.Context(), c.OrgID, saID)
+	if err != nil {
+		if errors.Is(err, serviceaccounts.ErrTokenRotationPolicyNotFound) {
+			return response.Error(http.StatusNotFound, err.Error(), nil)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to get token rotation policy", err)
+	}
+
+	return response.JSON(http.StatusOK, policy)
+}
+
+// swagger:route PUT /serviceaccounts/{serviceAccountId}/rotation-policy service_accounts setTokenRotationPolicy
+//
+// Set the token rotation policy of a service account
+//
+// The tokens of the service account are replaced once the newest one is older than the maximum age. The replaced
+// tokens remain valid during the overlap window.
+//
+// Required permissions (See note in the [introduction](https://grafana.com/docs/grafana/latest/developers/http_api/serviceaccount/#service-account-api) for an explanation):
+// action: `serviceaccounts:write` scope: `serviceaccounts:id:1` (single service account)
+//
+// Responses:
+// 200: tokenRotationPolicyResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (api *ServiceAccountsAPI) SetTokenRotationPolicy(c *models.ReqContext) response.Response {
+	saID, err := strconv.ParseInt(web.Params(c.Req)[":serviceAccountId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Service Account ID is invalid", err)
+	}
+
+	// confirm service account exists
+	if _, err := api.store.RetrieveServiceAccount(c.Req.Context(), c.OrgID, saID); err != nil {
+		switch {
+		case errors.Is(err, serviceaccounts.ErrServiceAccountNotFound):
+			return response.Error(http.StatusNotFound, "Failed to retrieve service account", err)
+		default:
+			return response.Error(http.StatusInternalServerError, "Failed to retrieve service account", err)
+		}
+	}
+
+	policy := serviceaccounts.TokenRotationPolicy{}
+	if err := web.Bind(c.Req, &policy); err != nil {
+		return response.Error(http.StatusBadRequest, "Bad request data", err)
+	}
+	policy.OrgId = c.OrgID
+	policy.ServiceAccountId = saID
+
+	if policy.MaxTokenAgeSeconds <= 0 {
+		return response.Error(http.StatusBadRequest, "Maximum token age should be greater than 0", nil)
+	}
+	if policy.OverlapSeconds < 0 {
+		return response.Error(http.StatusBadRequest, "Overlap should not be negative", nil)
+	}
+	// the rotated tokens live for the maximum age and the overlap
+	if api.cfg.ApiKeyMaxSecondsToLive != -1 && policy.MaxTokenAgeSeconds+policy.OverlapSeconds > api.cfg.ApiKeyMaxSecondsToLive {
+		return response.Error(http.StatusBadRequest, "Maximum token age and overlap are greater than the global limit", nil)
+	}
+
+	if err := api.store.SetTokenRotationPolicy(c.Req.Context(), &policy); err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to set token rotation policy", err)
+	}
+
+	return response.JSON(http.StatusOK, policy)
+}
+
+// swagger:route DELETE /serviceaccounts/{serviceAccountId}/rotation-policy service_accounts deleteTokenRotationPolicy
+//
+// Delete the token rotation policy of a service account
+//
+// The tokens of the service account are not rotated anymore, the existing tokens are kept.
+//
+// Required permissions (See note in the [introduction](https://grafana.com/docs/grafana/latest/developers/http_api/serviceaccount/#service-account-api) for an explanation):
+// action: `serviceaccounts:write` scope: `serviceaccounts:id:1` (single service account)
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (api *ServiceAccountsAPI) DeleteTokenRotationPolicy(c *models.ReqContext) response.Response {
+	saID, err := strconv.ParseInt(web.Params(c.Req)[":serviceAccountId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "Service Account ID is invalid", err)
+	}
+
+	if err := api.store.DeleteTokenRotationPolicy(c.Req.Context(), c.OrgID, saID); err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to delete token rotation policy", err)
+	}
+
+	return response.Success("Token rotation policy deleted")
+}
+
+// swagger:parameters getTokenRotationPolicy deleteTokenRotationPolicy
+type TokenRotationPolicyParams struct {
+	// in:path
+	ServiceAccountId int64 `json:"serviceAccountId"`
+}
+
+// swagger:parameters setTokenRotationPolicy
+type SetTokenRotationPolicyParams struct {
+	// in:path
+	ServiceAccountId int64 `json:"serviceAccountId"`
+	// in:body
+	Body serviceaccounts.TokenRotationPolicy
+}
+
+// swagger:response tokenRotationPolicyResponse
+type TokenRotationPolicyResponse struct {
+	// in:body
+	Body *serviceaccounts.TokenRotationPolicy
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/api/routing"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey/apikeyimpl"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts/database"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts/tests"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const serviceaccountIDRotationPolicyPath = "/api/serviceaccounts/%v/rotation-policy"
+
+func TestServiceAccountsAPI_TokenRotationPolicy(t *testing.T) {
+	store := sqlstore.InitTestDB(t)
+	apiKeyService := apikeyimpl.ProvideService(store, store.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(store), accesscontrolmock.New(), clock.New())
+	saStore := database.ProvideServiceAccountsStore(store, apiKeyService, kvstore.ProvideService(store))
+	svcmock := tests.ServiceAccountMock{}
+	sa := tests.SetupUserServiceAccount(t, store, tests.TestUser{Login: "sa", IsServiceAccount: true})
+
+	acmock := tests.SetupMockAccesscontrol(
+		t,
+		func(c context.Context, siu *user.SignedInUser, _ accesscontrol.Options) ([]accesscontrol.Permission, error) {
+			return []accesscontrol.Permission{
+				{Action: serviceaccounts.ActionRead, Scope: serviceaccounts.ScopeAll},
+				{Action: serviceaccounts.ActionWrite, Scope: fmt.Sprintf("serviceaccounts:id:%d", sa.ID)},
+			}, nil
+		},
+		false,
+	)
+	server, api := setupTestServer(t, &svcmock, routing.NewRouteRegister(), acmock, store, saStore)
+
+	request := func(method string, saID int64, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, fmt.Sprintf(serviceaccountIDRotationPolicyPath, saID), strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Add("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("should return not found without policy", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, request(http.MethodGet, sa.ID, "").Code)
+	})
+
+	t.Run("should reject invalid policies", func(t *testing.T) {
+		for _, body := range []string{`{"maxTokenAgeSeconds": 0}`, `{"maxTokenAgeSeconds": 60, "overlapSeconds": -1}`} {
+			assert.Equal(t, http.StatusBadRequest, request(http.MethodPut, sa.ID, body).Code, body)
+		}
+
+		api.cfg.ApiKeyMaxSecondsToLive = 100
+		t.Cleanup(func() { api.cfg.ApiKeyMaxSecondsToLive = -1 })
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPut, sa.ID, `{"maxTokenAgeSeconds": 60, "overlapSeconds": 60}`).Code)
+	})
+
+	t.Run("should be forbidden to set the policy of another service account", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request(http.MethodPut, sa.ID+1, `{"maxTokenAgeSeconds": 60}`).Code)
+	})
+
+	t.Run("should set, get and delete the policy", func(t *testing.T) {
+		resp := request(http.MethodPut, sa.ID, `{"maxTokenAgeSeconds": 3600, "overlapSeconds": 60, "secretNamespace": "ci"}`)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+		resp = request(http.MethodGet, sa.ID, "")
+		require.Equal(t, http.StatusOK, resp.Code)
+		policy := serviceaccounts.TokenRotationPolicy{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &policy))
+		assert.Equal(t, serviceaccounts.TokenRotationPolicy{MaxTokenAgeSeconds: 3600, OverlapSeconds: 60, SecretNamespace: "ci"}, policy)
+
+		require.Equal(t, http.StatusOK, request(http.MethodDelete, sa.ID, "").Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodGet, sa.ID, "").Code)
+	})
+}
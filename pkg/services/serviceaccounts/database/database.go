@@ -154,6 +154,7 @@ func (s *ServiceAccountsStoreImpl) UpdateServiceAccount(ctx context.Context,
 
 func ServiceAccountDeletions() []string {
 	deletes := []string{
+		"DELETE FROM api_key_fingerprint WHERE api_key_id IN (SELECT id FROM api_key WHERE service_account_id = ?)",
 		"DELETE FROM api_key WHERE service_account_id = ?",
 	}
 	deletes = append(deletes, sqlstore.UserDeletions()...)
@@ -440,11 +441,88 @@ func (s *ServiceAccountsStoreImpl) MigrateApiKey(ctx context.Context, orgId int6
 	return nil
 }
 
-func (s *ServiceAccountsStoreImpl) CreateServiceAccountFromApikey(ctx context.Context, key *apikey.APIKey) error {
+// GetAPIKeysMigrationReport reports which API keys of the organization
+// MigrateApiKeysToServiceAccounts would convert, which would fail because
+// the login of their service account is taken and which have a role that
+// does not map to a service account role. Nothing is migrated.
+func (s *ServiceAccountsStoreImpl) GetAPIKeysMigrationReport(ctx context.Context, orgId int64) (*serviceaccounts.APIKeysMigrationReport, error) {
+	report := &serviceaccounts.APIKeysMigrationReport{
+		Converted:     []serviceaccounts.APIKeyMigrationItem{},
+		Collisions:    []serviceaccounts.APIKeyMigrationItem{},
+		UnmappedRoles: []serviceaccounts.APIKeyMigrationItem{},
+	}
+
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		where := "email=? OR login=?"
+		if s.sqlStore.Cfg.CaseInsensitiveLogin {
+			where = "LOWER(email)=LOWER(?) OR LOWER(login)=LOWER(?)"
+		}
+
+		// logins of the service accounts created earlier in the migration
+		logins := map[string]string{}
+		for _, key := range s.apiKeyService.GetAllAPIKeys(ctx, orgId) {
+			login, name := serviceAccountFromApiKey(key)
+			item := serviceaccounts.APIKeyMigrationItem{
+				KeyId:               key.Id,
+				KeyName:             key.Name,
+				Role:                key.Role,
+				ServiceAccountLogin: login,
+				ServiceAccountName:  name,
+			}
+
+			loginKey := login
+			if s.sqlStore.Cfg.CaseInsensitiveLogin {
+				loginKey = strings.ToLower(login)
+			}
+			existing := user.User{}
+			exists, err := sess.Where(where, login, login).Get(&existing)
+			if err != nil {
+				return err
+			}
+
+			collides := true
+			switch {
+			case exists && existing.IsServiceAccount:
+				item.Reason = fmt.Sprintf("service account %q already exists", existing.Login)
+			case exists:
+				item.Reason = fmt.Sprintf("user %q already exists", existing.Login)
+			case logins[loginKey] != "":
+				item.Reason = fmt.Sprintf("API key %q migrates to the same service account", logins[loginKey])
+			default:
+				collides = false
+				logins[loginKey] = key.Name
+			}
+
+			if collides {
+				report.Collisions = append(report.Collisions, item)
+			}
+			if !key.Role.IsValid() {
+				report.UnmappedRoles = append(report.UnmappedRoles, item)
+			}
+			if !collides && key.Role.IsValid() {
+				report.Converted = append(report.Converted, item)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// serviceAccountFromApiKey returns the login and name of the service account
+// an API key is migrated to.
+func serviceAccountFromApiKey(key *apikey.APIKey) (login string, name string) {
 	prefix := "sa-autogen"
+	return fmt.Sprintf("%v-%v-%v", prefix, key.OrgId, key.Name), fmt.Sprintf("%v-%v", prefix, key.Name)
+}
+
+func (s *ServiceAccountsStoreImpl) CreateServiceAccountFromApikey(ctx context.Context, key *apikey.APIKey) error {
+	login, name := serviceAccountFromApiKey(key)
 	cmd := user.CreateUserCommand{
-		Login:            fmt.Sprintf("%v-%v-%v", prefix, key.OrgId, key.Name),
-		Name:             fmt.Sprintf("%v-%v", prefix, key.Name),
+		Login:            login,
+		Name:             name,
 		OrgID:            key.OrgId,
 		DefaultOrgRole:   string(key.Role),
 		IsServiceAccount: true,
@@ -456,7 +534,7 @@ func (s *ServiceAccountsStoreImpl) CreateServiceAccountFromApikey(ctx context.Co
 			return fmt.Errorf("failed to create service account: %w", errCreateSA)
 		}
 
-		if err := s.assignApiKeyToServiceAccount(sess, key.Id, newSA.ID); err != nil {
+		if err := s.assignApiKeyToServiceAccount(ctx, sess, key.Id, newSA.ID); err != nil {
 			if err := s.userService.Delete(ctx, &user.DeleteUserCommand{UserID: newSA.ID}); err != nil {
 				s.log.Error("Error deleting service account", "error", err)
 			}
@@ -467,6 +545,70 @@ func (s *ServiceAccountsStoreImpl) CreateServiceAccountFromApikey(ctx context.Co
 	})
 }
 
+// RevertApiKeysMigration converts the tokens of the service accounts created
+// by MigrateApiKeysToServiceAccounts back to API keys, keeping their hash and
+// expiration, and deletes these service accounts. Service accounts that were
+// renamed or given other tokens since the migration are left untouched.
+func (s *ServiceAccountsStoreImpl) RevertApiKeysMigration(ctx context.Context, orgId int64) (*serviceaccounts.APIKeysMigrationRevertReport, error) {
+	report := &serviceaccounts.APIKeysMigrationRevertReport{
+		Reverted: []serviceaccounts.APIKeyMigrationItem{},
+		Skipped:  []serviceaccounts.APIKeyMigrationItem{},
+	}
+
+	var accounts []*user.User
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		return sess.Where("org_id = ? AND is_service_account = ? AND login "+s.sqlStore.Dialect.LikeStr()+" ?",
+			orgId, s.sqlStore.Dialect.BooleanStr(true), fmt.Sprintf("sa-autogen-%d-%%", orgId)).Asc("id").Find(&accounts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sa := range accounts {
+		tokens, err := s.ListTokens(ctx, orgId, sa.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			login, name := serviceAccountFromApiKey(token)
+			item := serviceaccounts.APIKeyMigrationItem{
+				KeyId:               token.Id,
+				KeyName:             token.Name,
+				Role:                token.Role,
+				ServiceAccountLogin: sa.Login,
+				ServiceAccountName:  sa.Name,
+			}
+			switch {
+			case len(tokens) > 1:
+				item.Reason = "service account contains more than one token"
+			case sa.Login != login || sa.Name != name:
+				item.Reason = "service account or token was renamed"
+			}
+			if item.Reason != "" {
+				report.Skipped = append(report.Skipped, item)
+				continue
+			}
+
+			err := s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+				if err := s.detachApiKeyFromServiceAccount(ctx, sess, token.Id); err != nil {
+					return err
+				}
+				return s.deleteServiceAccount(sess, orgId, sa.ID)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("cannot revert token %d to API key: %w", token.Id, err)
+			}
+			s.log.Debug("Service account token reverted to API key", "keyId", token.Id)
+			report.Reverted = append(report.Reverted, item)
+		}
+	}
+
+	if err := s.kvStore.Del(ctx, orgId, "serviceaccounts", "migrationStatus"); err != nil {
+		s.log.Error("Failed to delete API keys migration status", err)
+	}
+	return report, nil
+}
+
 // RevertApiKey converts service account token to old API key
 func (s *ServiceAccountsStoreImpl) RevertApiKey(ctx context.Context, saId int64, keyId int64) error {
 	query := apikey.GetByIDQuery{ApiKeyId: keyId}
@@ -502,7 +644,7 @@ func (s *ServiceAccountsStoreImpl) RevertApiKey(ctx context.Context, saId int64,
 			return serviceaccounts.ErrServiceAccountNotFound
 		}
 		// Detach API key from service account
-		if err := s.detachApiKeyFromServiceAccount(sess, key.Id); err != nil {
+		if err := s.detachApiKeyFromServiceAccount(ctx, sess, key.Id); err != nil {
 			return err
 		}
 		// Delete service account
@@ -5,10 +5,16 @@ import (
 	"math/rand"
 	"testing"
 
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/components/apikeygen"
 	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/models"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/services/apikey/apikeyimpl"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
 	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
 	"github.com/grafana/grafana/pkg/services/serviceaccounts"
 	"github.com/grafana/grafana/pkg/services/serviceaccounts/tests"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
@@ -105,10 +111,36 @@ func TestStore_DeleteServiceAccount(t *testing.T) {
 	}
 }
 
+func TestStore_DeleteServiceAccountDeletesTokenFingerprints(t *testing.T) {
+	db, store := setupTestDatabase(t)
+	sa := tests.SetupUserServiceAccount(t, db, tests.TestUser{Login: "servicetest1@admin", IsServiceAccount: true})
+
+	key, err := apikeygen.New(sa.OrgID, t.Name())
+	require.NoError(t, err)
+	cmd := serviceaccounts.AddServiceAccountTokenCommand{
+		Name:        t.Name(),
+		OrgId:       sa.OrgID,
+		Key:         key.HashedKey,
+		Fingerprint: apikey.LeakHash(key.ClientSecret),
+		Result:      &apikey.APIKey{},
+	}
+	require.NoError(t, store.AddServiceAccountToken(context.Background(), sa.ID, &cmd))
+
+	require.NoError(t, store.DeleteServiceAccount(context.Background(), sa.OrgID, sa.ID))
+
+	var fingerprints int64
+	err = db.WithDbSession(context.Background(), func(sess *sqlstore.DBSession) error {
+		fingerprints, err = sess.Where("api_key_id = ?", cmd.Result.Id).Count(&apikey.Fingerprint{})
+		return err
+	})
+	require.NoError(t, err)
+	require.Zero(t, fingerprints)
+}
+
 func setupTestDatabase(t *testing.T) (*sqlstore.SQLStore, *ServiceAccountsStoreImpl) {
 	t.Helper()
 	db := sqlstore.InitTestDB(t)
-	apiKeyService := apikeyimpl.ProvideService(db, db.Cfg)
+	apiKeyService := apikeyimpl.ProvideService(db, db.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clock.New())
 	kvStore := kvstore.ProvideService(db)
 	return db, ProvideServiceAccountsStore(db, apiKeyService, kvStore)
 }
@@ -273,6 +305,97 @@ func TestStore_MigrateAllApiKeys(t *testing.T) {
 	}
 }
 
+func TestStore_GetAPIKeysMigrationReport(t *testing.T) {
+	db, store := setupTestDatabase(t)
+	ctx := context.Background()
+	store.sqlStore.Cfg.AutoAssignOrg = true
+	store.sqlStore.Cfg.AutoAssignOrgId = 1
+	store.sqlStore.Cfg.AutoAssignOrgRole = "Viewer"
+	err := store.sqlStore.CreateOrg(ctx, &models.CreateOrgCommand{Name: "main"})
+	require.NoError(t, err)
+
+	tests.SetupApiKey(t, db, tests.TestApiKey{Name: "converted", Role: org.RoleEditor, Key: "secret1", OrgId: 1})
+	tests.SetupApiKey(t, db, tests.TestApiKey{Name: "taken", Role: org.RoleViewer, Key: "secret2", OrgId: 1})
+	legacy := tests.SetupApiKey(t, db, tests.TestApiKey{Name: "legacy", Role: org.RoleViewer, Key: "secret3", OrgId: 1})
+	tests.SetupApiKey(t, db, tests.TestApiKey{Name: "other-org", Role: org.RoleViewer, Key: "secret4", OrgId: 2})
+	tests.SetupUserServiceAccount(t, db, tests.TestUser{Login: "sa-autogen-1-taken", IsServiceAccount: true})
+	err = db.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("UPDATE api_key SET role = ? WHERE id = ?", "Read Only Editor", legacy.Id)
+		return err
+	})
+	require.NoError(t, err)
+
+	keyNames := func(items []serviceaccounts.APIKeyMigrationItem) []string {
+		names := []string{}
+		for _, item := range items {
+			names = append(names, item.KeyName)
+		}
+		return names
+	}
+
+	report, err := store.GetAPIKeysMigrationReport(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"converted"}, keyNames(report.Converted))
+	assert.Equal(t, []string{"taken"}, keyNames(report.Collisions))
+	assert.Equal(t, []string{"legacy"}, keyNames(report.UnmappedRoles))
+	assert.Equal(t, "sa-autogen-1-converted", report.Converted[0].ServiceAccountLogin)
+	assert.Equal(t, "sa-autogen-converted", report.Converted[0].ServiceAccountName)
+	assert.Contains(t, report.Collisions[0].Reason, "sa-autogen-1-taken")
+
+	t.Run("does not migrate the API keys", func(t *testing.T) {
+		status, err := store.GetAPIKeysMigrationStatus(ctx, 1)
+		require.NoError(t, err)
+		assert.False(t, status.Migrated)
+		assert.Len(t, store.apiKeyService.GetAllAPIKeys(ctx, 1), 3)
+	})
+}
+
+func TestStore_RevertApiKeysMigration(t *testing.T) {
+	db, store := setupTestDatabase(t)
+	ctx := context.Background()
+	store.sqlStore.Cfg.AutoAssignOrg = true
+	store.sqlStore.Cfg.AutoAssignOrgId = 1
+	store.sqlStore.Cfg.AutoAssignOrgRole = "Viewer"
+	err := store.sqlStore.CreateOrg(ctx, &models.CreateOrgCommand{Name: "main"})
+	require.NoError(t, err)
+
+	tests.SetupApiKey(t, db, tests.TestApiKey{Name: "reverted", Role: org.RoleEditor, Key: "secret1", OrgId: 1})
+	tests.SetupApiKey(t, db, tests.TestApiKey{Name: "expired", Role: org.RoleViewer, Key: "secret2", OrgId: 1, IsExpired: true})
+	tests.SetupApiKey(t, db, tests.TestApiKey{Name: "extended", Role: org.RoleViewer, Key: "secret3", OrgId: 1})
+	before := map[string]*apikey.APIKey{}
+	for _, key := range store.apiKeyService.GetAllAPIKeys(ctx, 1) {
+		before[key.Name] = key
+	}
+	require.NoError(t, store.MigrateApiKeysToServiceAccounts(ctx, 1))
+	require.Empty(t, store.apiKeyService.GetAllAPIKeys(ctx, 1))
+
+	// a token added after the migration keeps its service account
+	saID, err := store.RetrieveServiceAccountIdByName(ctx, 1, "sa-autogen-extended")
+	require.NoError(t, err)
+	require.NoError(t, store.AddServiceAccountToken(ctx, saID, &serviceaccounts.AddServiceAccountTokenCommand{Name: "added", OrgId: 1, Key: "secret4"}))
+
+	report, err := store.RevertApiKeysMigration(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, report.Reverted, 2)
+	require.Len(t, report.Skipped, 2)
+	assert.Equal(t, "service account contains more than one token", report.Skipped[0].Reason)
+
+	after := store.apiKeyService.GetAllAPIKeys(ctx, 1)
+	require.Len(t, after, 2)
+	for _, key := range after {
+		require.Contains(t, before, key.Name)
+		assert.Equal(t, before[key.Name].Key, key.Key)
+		assert.Equal(t, before[key.Name].Expires, key.Expires)
+		assert.Equal(t, before[key.Name].Role, key.Role)
+	}
+
+	_, err = store.RetrieveServiceAccountIdByName(ctx, 1, "sa-autogen-reverted")
+	require.ErrorIs(t, err, serviceaccounts.ErrServiceAccountNotFound)
+	status, err := store.GetAPIKeysMigrationStatus(ctx, 1)
+	require.NoError(t, err)
+	assert.False(t, status.Migrated)
+}
+
 func TestStore_RevertApiKey(t *testing.T) {
 	cases := []struct {
 		desc                        string
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// the token rotation policies are stored by service account id in the kvstore
+const tokenRotationNamespace = "serviceaccounts.rotation"
+
+func (s *ServiceAccountsStoreImpl) GetTokenRotationPolicy(ctx context.Context, orgId, serviceAccountId int64) (*serviceaccounts.TokenRotationPolicy, error) {
+	value, exists, err := s.kvStore.Get(ctx, orgId, tokenRotationNamespace, strconv.FormatInt(serviceAccountId, 10))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, serviceaccounts.ErrTokenRotationPolicyNotFound
+	}
+	return decodeTokenRotationPolicy(orgId, serviceAccountId, value)
+}
+
+func (s *ServiceAccountsStoreImpl) SetTokenRotationPolicy(ctx context.Context, policy *serviceaccounts.TokenRotationPolicy) error {
+	value, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return s.kvStore.Set(ctx, policy.OrgId, tokenRotationNamespace, strconv.FormatInt(policy.ServiceAccountId, 10), string(value))
+}
+
+func (s *ServiceAccountsStoreImpl) DeleteTokenRotationPolicy(ctx context.Context, orgId, serviceAccountId int64) error {
+	return s.kvStore.Del(ctx, orgId, tokenRotationNamespace, strconv.FormatInt(serviceAccountId, 10))
+}
+
+// ListTokenRotationPolicies returns the token rotation policies of every organization
+func (s *ServiceAccountsStoreImpl) ListTokenRotationPolicies(ctx context.Context) ([]*serviceaccounts.TokenRotationPolicy, error) {
+	all, err := s.kvStore.GetAll(ctx, kvstore.AllOrganizations, tokenRotationNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make([]*serviceaccounts.TokenRotationPolicy, 0)
+	for orgId, values := range all {
+		for key, value := range values {
+			serviceAccountId, err := strconv.ParseInt(key, 10, 64)
+			if err != nil {
+				s.log.Warn("Ignoring token rotation policy with invalid service account id", "orgId", orgId, "key", key)
+				continue
+			}
+			policy, err := decodeTokenRotationPolicy(orgId, serviceAccountId, value)
+			if err != nil {
+				s.log.Warn("Ignoring invalid token rotation policy", "orgId", orgId, "serviceAccountId", serviceAccountId, "err", err)
+				continue
+			}
+			policies = append(policies, policy)
+		}
+	}
+	return policies, nil
+}
+
+// SetServiceAccountTokenExpiry changes the expiration of a service account token
+func (s *ServiceAccountsStoreImpl) SetServiceAccountTokenExpiry(ctx context.Context, orgId, serviceAccountId, tokenId int64, expires time.Time) error {
+	rawSQL := "UPDATE api_key SET expires=?, updated=? WHERE id=? and org_id=? and service_account_id=?"
+
+	return s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		result, err := sess.Exec(rawSQL, expires.Unix(), time.Now(), tokenId, orgId, serviceAccountId)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if affected == 0 {
+			return ErrServiceAccountTokenNotFound
+		}
+
+		return err
+	})
+}
+
+func decodeTokenRotationPolicy(orgId, serviceAccountId int64, value string) (*serviceaccounts.TokenRotationPolicy, error) {
+	policy := &serviceaccounts.TokenRotationPolicy{}
+	if err := json.Unmarshal([]byte(value), policy); err != nil {
+		return nil, err
+	}
+	policy.OrgId = orgId
+	policy.ServiceAccountId = serviceAccountId
+	return policy, nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/components/apikeygen"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts/tests"
+)
+
+func TestStore_TokenRotationPolicies(t *testing.T) {
+	_, store := setupTestDatabase(t)
+	ctx := context.Background()
+
+	_, err := store.GetTokenRotationPolicy(ctx, 1, 2)
+	require.ErrorIs(t, err, serviceaccounts.ErrTokenRotationPolicyNotFound)
+
+	policies := []*serviceaccounts.TokenRotationPolicy{
+		{OrgId: 1, ServiceAccountId: 2, MaxTokenAgeSeconds: 3600, OverlapSeconds: 60, SecretNamespace: "ci"},
+		{OrgId: 2, ServiceAccountId: 3, MaxTokenAgeSeconds: 7200},
+	}
+	for _, policy := range policies {
+		require.NoError(t, store.SetTokenRotationPolicy(ctx, policy))
+	}
+
+	policy, err := store.GetTokenRotationPolicy(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, policies[0], policy)
+
+	listed, err := store.ListTokenRotationPolicies(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, policies, listed)
+
+	require.NoError(t, store.DeleteTokenRotationPolicy(ctx, 1, 2))
+	_, err = store.GetTokenRotationPolicy(ctx, 1, 2)
+	require.ErrorIs(t, err, serviceaccounts.ErrTokenRotationPolicyNotFound)
+	listed, err = store.ListTokenRotationPolicies(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, policies[1:], listed)
+}
+
+func TestStore_SetServiceAccountTokenExpiry(t *testing.T) {
+	db, store := setupTestDatabase(t)
+	sa := tests.SetupUserServiceAccount(t, db, tests.TestUser{Login: "sa-rotated", IsServiceAccount: true})
+	key, err := apikeygen.New(sa.OrgID, t.Name())
+	require.NoError(t, err)
+	cmd := serviceaccounts.AddServiceAccountTokenCommand{Name: t.Name(), OrgId: sa.OrgID, Key: key.HashedKey}
+	require.NoError(t, store.AddServiceAccountToken(context.Background(), sa.ID, &cmd))
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	require.NoError(t, store.SetServiceAccountTokenExpiry(context.Background(), sa.OrgID, sa.ID, cmd.Result.Id, expires))
+
+	tokens, err := store.ListTokens(context.Background(), sa.OrgID, sa.ID)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	require.NotNil(t, tokens[0].Expires)
+	assert.Equal(t, expires.Unix(), *tokens[0].Expires)
+
+	err = store.SetServiceAccountTokenExpiry(context.Background(), sa.OrgID, sa.ID+1, cmd.Result.Id, expires)
+	require.ErrorIs(t, err, ErrServiceAccountTokenNotFound)
+}
@@ -58,11 +58,17 @@ func (s *ServiceAccountsStoreImpl) AddServiceAccountToken(ctx context.Context, s
 			Expires:          expires,
 			LastUsedAt:       nil,
 			ServiceAccountId: &serviceAccountId,
+			Fingerprinted:    cmd.Fingerprint != "",
 		}
 
 		if _, err := sess.Insert(&token); err != nil {
 			return err
 		}
+		if cmd.Fingerprint != "" {
+			if _, err := sess.Insert(&apikey.Fingerprint{ApiKeyId: token.Id, Hash: cmd.Fingerprint, Created: updated}); err != nil {
+				return err
+			}
+		}
 		cmd.Result = &token
 		return nil
 	})
@@ -71,22 +77,27 @@ func (s *ServiceAccountsStoreImpl) AddServiceAccountToken(ctx context.Context, s
 func (s *ServiceAccountsStoreImpl) DeleteServiceAccountToken(ctx context.Context, orgId, serviceAccountId, tokenId int64) error {
 	rawSQL := "DELETE FROM api_key WHERE id=? and org_id=? and service_account_id=?"
 
-	return s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
 		result, err := sess.Exec(rawSQL, tokenId, orgId, serviceAccountId)
 		if err != nil {
 			return err
 		}
 		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
 		if affected == 0 {
 			return ErrServiceAccountTokenNotFound
 		}
 
+		_, err = sess.Exec("DELETE FROM api_key_fingerprint WHERE api_key_id = ?", tokenId)
 		return err
 	})
 }
 
-// assignApiKeyToServiceAccount sets the API key service account ID
-func (s *ServiceAccountsStoreImpl) assignApiKeyToServiceAccount(sess *sqlstore.DBSession, apiKeyId int64, serviceAccountId int64) error {
+// assignApiKeyToServiceAccount sets the API key service account ID, and encrypts the labels and access windows the
+// token keeps
+func (s *ServiceAccountsStoreImpl) assignApiKeyToServiceAccount(ctx context.Context, sess *sqlstore.DBSession, apiKeyId int64, serviceAccountId int64) error {
 	key := apikey.APIKey{Id: apiKeyId}
 	exists, err := sess.Get(&key)
 	if err != nil {
@@ -98,8 +109,11 @@ func (s *ServiceAccountsStoreImpl) assignApiKeyToServiceAccount(sess *sqlstore.D
 		return apikey.ErrNotFound
 	}
 	key.ServiceAccountId = &serviceAccountId
+	if err := s.apiKeyService.ProtectMetadata(ctx, &key); err != nil {
+		return err
+	}
 
-	if _, err := sess.ID(key.Id).Update(&key); err != nil {
+	if _, err := sess.ID(key.Id).Cols("service_account_id", "labels", "access_windows", "encrypted_metadata").Update(&key); err != nil {
 		s.log.Warn("Could not update api key", "err", err)
 		return err
 	}
@@ -107,8 +121,9 @@ func (s *ServiceAccountsStoreImpl) assignApiKeyToServiceAccount(sess *sqlstore.D
 	return nil
 }
 
-// detachApiKeyFromServiceAccount converts service account token to old API key
-func (s *ServiceAccountsStoreImpl) detachApiKeyFromServiceAccount(sess *sqlstore.DBSession, apiKeyId int64) error {
+// detachApiKeyFromServiceAccount converts service account token to old API key, and restores the plaintext labels and
+// access windows of the key
+func (s *ServiceAccountsStoreImpl) detachApiKeyFromServiceAccount(ctx context.Context, sess *sqlstore.DBSession, apiKeyId int64) error {
 	key := apikey.APIKey{Id: apiKeyId}
 	exists, err := sess.Get(&key)
 	if err != nil {
@@ -120,6 +135,9 @@ func (s *ServiceAccountsStoreImpl) detachApiKeyFromServiceAccount(sess *sqlstore
 		return apikey.ErrNotFound
 	}
 	key.ServiceAccountId = nil
+	if err := s.apiKeyService.ProtectMetadata(ctx, &key); err != nil {
+		return err
+	}
 
 	if _, err := sess.ID(key.Id).AllCols().Update(&key); err != nil {
 		s.log.Error("Could not update api key", "err", err)
@@ -8,6 +8,7 @@ import (
 	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/services/serviceaccounts"
 	"github.com/grafana/grafana/pkg/services/serviceaccounts/tests"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/stretchr/testify/require"
 )
 
@@ -105,6 +106,7 @@ func TestStore_DeleteServiceAccountToken(t *testing.T) {
 		OrgId:         sa.OrgID,
 		Key:           key.HashedKey,
 		SecondsToLive: 0,
+		Fingerprint:   apikey.LeakHash(key.ClientSecret),
 		Result:        &apikey.APIKey{},
 	}
 
@@ -132,4 +134,12 @@ func TestStore_DeleteServiceAccountToken(t *testing.T) {
 			require.Fail(t, "Key not deleted")
 		}
 	}
+
+	var fingerprints int64
+	err = db.WithDbSession(context.Background(), func(sess *sqlstore.DBSession) error {
+		fingerprints, err = sess.Where("api_key_id = ?", newKey.Id).Count(&apikey.Fingerprint{})
+		return err
+	})
+	require.NoError(t, err)
+	require.Zero(t, fingerprints, "Fingerprint not deleted")
 }
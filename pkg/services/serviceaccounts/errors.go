@@ -6,4 +6,5 @@ var (
 	ErrServiceAccountNotFound            = errors.New("service account not found")
 	ErrServiceAccountInvalidRole         = errors.New("invalid role specified")
 	ErrServiceAccountRolePrivilegeDenied = errors.New("can not assign a role higher than user's role")
+	ErrTokenRotationPolicyNotFound       = errors.New("token rotation policy not found")
 )
@@ -0,0 +1,176 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/benbjohnson/clock"
+
+	apikeygenprefix "github.com/grafana/grafana/pkg/components/apikeygenprefixed"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/secrets/kvstore"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts/api"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// TokenRotationService enforces the token rotation policies of the service accounts. Once the newest token of a
+// service account is older than the maximum age of its policy, a new token is created and its secret is published
+// in the secrets store, then the other tokens of the service account are expired at the end of the overlap window.
+type TokenRotationService struct {
+	interval   time.Duration
+	store      serviceaccounts.Store
+	secrets    kvstore.SecretsKVStore
+	serverLock *serverlock.ServerLockService
+	clock      clock.Clock
+	log        log.Logger
+}
+
+func ProvideTokenRotationService(
+	cfg *setting.Cfg,
+	store serviceaccounts.Store,
+	secrets kvstore.SecretsKVStore,
+	serverLock *serverlock.ServerLockService,
+	clk clock.Clock,
+) *TokenRotationService {
+	return &TokenRotationService{
+		interval:   cfg.SectionWithEnvOverrides("auth").Key("service_account_token_rotation_interval").MustDuration(10 * time.Minute),
+		store:      store,
+		secrets:    secrets,
+		serverLock: serverLock,
+		clock:      clk,
+		log:        log.New("serviceaccounts.token-rotation"),
+	}
+}
+
+// IsDisabled returns true when the rotation interval is not positive
+func (s *TokenRotationService) IsDisabled() bool {
+	return s.interval <= 0
+}
+
+func (s *TokenRotationService) Run(ctx context.Context) error {
+	ticker := s.clock.Ticker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		// a single instance rotates the tokens, otherwise each instance would create its own token
+		err := s.serverLock.LockAndExecute(ctx, "rotate service account tokens", s.interval, func(ctx context.Context) {
+			if err := s.RotateTokens(ctx); err != nil {
+				s.log.Error("failed to rotate service account tokens", "error", err)
+			}
+		})
+		if err != nil {
+			s.log.Error("failed to lock and execute service account token rotation", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RotateTokens applies every token rotation policy, the failure to rotate the tokens of a service account doesn't
+// prevent the rotation of the others
+func (s *TokenRotationService) RotateTokens(ctx context.Context) error {
+	policies, err := s.store.ListTokenRotationPolicies(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range policies {
+		if err := s.rotate(ctx, policy); err != nil {
+			s.log.Error("failed to rotate service account tokens", "orgId", policy.OrgId, "serviceAccountId", policy.ServiceAccountId, "error", err)
+		}
+	}
+	return nil
+}
+
+func (s *TokenRotationService) rotate(ctx context.Context, policy *serviceaccounts.TokenRotationPolicy) error {
+	sa, err := s.store.RetrieveServiceAccount(ctx, policy.OrgId, policy.ServiceAccountId)
+	if errors.Is(err, serviceaccounts.ErrServiceAccountNotFound) {
+		s.log.Info("deleting token rotation policy of deleted service account", "orgId", policy.OrgId, "serviceAccountId", policy.ServiceAccountId)
+		return s.store.DeleteTokenRotationPolicy(ctx, policy.OrgId, policy.ServiceAccountId)
+	}
+	if err != nil {
+		return err
+	}
+	if sa.IsDisabled {
+		return nil
+	}
+
+	tokens, err := s.store.ListTokens(ctx, policy.OrgId, policy.ServiceAccountId)
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	var newest *apikey.APIKey
+	for _, token := range tokens {
+		if token.Expires != nil && *token.Expires <= now.Unix() {
+			continue
+		}
+		if newest == nil || token.Created.After(newest.Created) {
+			newest = token
+		}
+	}
+	maxAge := time.Duration(policy.MaxTokenAgeSeconds) * time.Second
+	if newest != nil && now.Sub(newest.Created) < maxAge {
+		return nil
+	}
+
+	rotated, err := s.createToken(ctx, sa, policy, now)
+	if err != nil {
+		return err
+	}
+
+	overlapEnd := now.Add(time.Duration(policy.OverlapSeconds) * time.Second)
+	for _, token := range tokens {
+		if token.Expires != nil && *token.Expires <= overlapEnd.Unix() {
+			continue
+		}
+		if err := s.store.SetServiceAccountTokenExpiry(ctx, policy.OrgId, policy.ServiceAccountId, token.Id, overlapEnd); err != nil {
+			return err
+		}
+	}
+
+	s.log.Info("rotated service account token", "orgId", policy.OrgId, "serviceAccountId", policy.ServiceAccountId, "tokenId", rotated.Id, "expiredTokens", len(tokens))
+	return nil
+}
+
+// createToken adds a token living for the maximum age and the overlap to the service account and publishes its
+// secret, the token is deleted if its secret can't be published
+func (s *TokenRotationService) createToken(ctx context.Context, sa *serviceaccounts.ServiceAccountProfileDTO, policy *serviceaccounts.TokenRotationPolicy, now time.Time) (*apikey.APIKey, error) {
+	newKeyInfo, err := apikeygenprefix.New(api.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := serviceaccounts.AddServiceAccountTokenCommand{
+		Name:          fmt.Sprintf("%s-rotated-%d", sa.Login, now.Unix()),
+		OrgId:         policy.OrgId,
+		Key:           newKeyInfo.HashedKey,
+		SecondsToLive: policy.MaxTokenAgeSeconds + policy.OverlapSeconds,
+		Fingerprint:   apikey.LeakHash(newKeyInfo.ClientSecret),
+	}
+	if err := s.store.AddServiceAccountToken(ctx, policy.ServiceAccountId, &cmd); err != nil {
+		return nil, err
+	}
+
+	namespace := policy.SecretNamespace
+	if namespace == "" {
+		namespace = sa.Login
+	}
+	if err := s.secrets.Set(ctx, policy.OrgId, namespace, serviceaccounts.RotatedTokenSecretType, newKeyInfo.ClientSecret); err != nil {
+		if delErr := s.store.DeleteServiceAccountToken(ctx, policy.OrgId, policy.ServiceAccountId, cmd.Result.Id); delErr != nil {
+			s.log.Error("failed to delete unpublished service account token", "orgId", policy.OrgId, "serviceAccountId", policy.ServiceAccountId, "tokenId", cmd.Result.Id, "error", delErr)
+		}
+		return nil, fmt.Errorf("failed to publish service account token: %w", err)
+	}
+	return cmd.Result, nil
+}
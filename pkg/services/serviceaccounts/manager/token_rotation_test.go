@@ -0,0 +1,143 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	apikeygenprefix "github.com/grafana/grafana/pkg/components/apikeygenprefixed"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/apikey/apikeyimpl"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	secretskvs "github.com/grafana/grafana/pkg/services/secrets/kvstore"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts/database"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts/tests"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+type failingSecretsKVStore struct {
+	secretskvs.FakeSecretsKVStore
+}
+
+func (f failingSecretsKVStore) Set(_ context.Context, _ int64, _ string, _ string, _ string) error {
+	return errors.New("secrets backend unavailable")
+}
+
+func TestTokenRotationService(t *testing.T) {
+	ctx := context.Background()
+	setup := func(t *testing.T, secrets secretskvs.SecretsKVStore) (*TokenRotationService, *sqlstore.SQLStore, serviceaccounts.Store, *clock.Mock) {
+		t.Helper()
+		db := sqlstore.InitTestDB(t)
+		apiKeyService := apikeyimpl.ProvideService(db, db.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(db), accesscontrolmock.New(), clock.New())
+		store := database.ProvideServiceAccountsStore(db, apiKeyService, kvstore.ProvideService(db))
+		clk := clock.NewMock()
+		clk.Set(time.Now())
+		return ProvideTokenRotationService(setting.NewCfg(), store, secrets, serverlock.ProvideService(db), clk), db, store, clk
+	}
+	addToken := func(t *testing.T, store serviceaccounts.Store, sa int64, name string, secondsToLive int64) {
+		t.Helper()
+		cmd := serviceaccounts.AddServiceAccountTokenCommand{Name: name, OrgId: 1, Key: name, SecondsToLive: secondsToLive}
+		require.NoError(t, store.AddServiceAccountToken(ctx, sa, &cmd))
+	}
+	tokensByName := func(t *testing.T, store serviceaccounts.Store, sa int64) map[string]*apikey.APIKey {
+		t.Helper()
+		tokens, err := store.ListTokens(ctx, 1, sa)
+		require.NoError(t, err)
+		byName := map[string]*apikey.APIKey{}
+		for _, token := range tokens {
+			byName[token.Name] = token
+		}
+		return byName
+	}
+
+	t.Run("tokens younger than the maximum age are not rotated", func(t *testing.T) {
+		s, db, store, clk := setup(t, secretskvs.NewFakeSecretsKVStore())
+		sa := tests.SetupUserServiceAccount(t, db, tests.TestUser{Login: "sa-ci", IsServiceAccount: true})
+		addToken(t, store, sa.ID, "initial", 0)
+		require.NoError(t, store.SetTokenRotationPolicy(ctx, &serviceaccounts.TokenRotationPolicy{OrgId: 1, ServiceAccountId: sa.ID, MaxTokenAgeSeconds: 3600}))
+
+		clk.Add(30 * time.Minute)
+		require.NoError(t, s.RotateTokens(ctx))
+		assert.Len(t, tokensByName(t, store, sa.ID), 1)
+	})
+
+	t.Run("old tokens are replaced and expired after the overlap", func(t *testing.T) {
+		secrets := secretskvs.NewFakeSecretsKVStore()
+		s, db, store, clk := setup(t, secrets)
+		sa := tests.SetupUserServiceAccount(t, db, tests.TestUser{Login: "sa-ci", IsServiceAccount: true})
+		addToken(t, store, sa.ID, "initial", 0)
+		addToken(t, store, sa.ID, "short-lived", 3660)
+		require.NoError(t, store.SetTokenRotationPolicy(ctx, &serviceaccounts.TokenRotationPolicy{OrgId: 1, ServiceAccountId: sa.ID, MaxTokenAgeSeconds: 3600, OverlapSeconds: 600}))
+
+		clk.Add(time.Hour)
+		require.NoError(t, s.RotateTokens(ctx))
+
+		tokens := tokensByName(t, store, sa.ID)
+		require.Len(t, tokens, 3)
+		overlapEnd := clk.Now().Add(10 * time.Minute).Unix()
+		require.NotNil(t, tokens["initial"].Expires)
+		assert.Equal(t, overlapEnd, *tokens["initial"].Expires)
+		// tokens expiring before the end of the overlap keep their expiration
+		assert.Less(t, *tokens["short-lived"].Expires, overlapEnd)
+
+		rotated := tokens["sa-ci-rotated-"+strconv.FormatInt(clk.Now().Unix(), 10)]
+		require.NotNil(t, rotated)
+		require.NotNil(t, rotated.Expires)
+
+		secret, ok, err := secrets.Get(ctx, 1, "sa-ci", serviceaccounts.RotatedTokenSecretType)
+		require.NoError(t, err)
+		require.True(t, ok)
+		keyInfo, err := apikeygenprefix.Decode(secret)
+		require.NoError(t, err)
+		hash, err := keyInfo.Hash()
+		require.NoError(t, err)
+		assert.Equal(t, rotated.Key, hash)
+	})
+
+	t.Run("the token is deleted when it can't be published", func(t *testing.T) {
+		s, db, store, clk := setup(t, failingSecretsKVStore{secretskvs.NewFakeSecretsKVStore()})
+		sa := tests.SetupUserServiceAccount(t, db, tests.TestUser{Login: "sa-ci", IsServiceAccount: true})
+		addToken(t, store, sa.ID, "initial", 0)
+		require.NoError(t, store.SetTokenRotationPolicy(ctx, &serviceaccounts.TokenRotationPolicy{OrgId: 1, ServiceAccountId: sa.ID, MaxTokenAgeSeconds: 60}))
+
+		clk.Add(time.Hour)
+		require.NoError(t, s.RotateTokens(ctx))
+		tokens := tokensByName(t, store, sa.ID)
+		require.Len(t, tokens, 1)
+		assert.Nil(t, tokens["initial"].Expires)
+	})
+
+	t.Run("service accounts without token get one in the configured namespace", func(t *testing.T) {
+		secrets := secretskvs.NewFakeSecretsKVStore()
+		s, db, store, _ := setup(t, secrets)
+		sa := tests.SetupUserServiceAccount(t, db, tests.TestUser{Login: "sa-ci", IsServiceAccount: true})
+		require.NoError(t, store.SetTokenRotationPolicy(ctx, &serviceaccounts.TokenRotationPolicy{OrgId: 1, ServiceAccountId: sa.ID, MaxTokenAgeSeconds: 60, SecretNamespace: "ci"}))
+
+		require.NoError(t, s.RotateTokens(ctx))
+		assert.Len(t, tokensByName(t, store, sa.ID), 1)
+		_, ok, err := secrets.Get(ctx, 1, "ci", serviceaccounts.RotatedTokenSecretType)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("the policies of deleted service accounts are deleted", func(t *testing.T) {
+		s, _, store, _ := setup(t, secretskvs.NewFakeSecretsKVStore())
+		require.NoError(t, store.SetTokenRotationPolicy(ctx, &serviceaccounts.TokenRotationPolicy{OrgId: 1, ServiceAccountId: 42, MaxTokenAgeSeconds: 60}))
+
+		require.NoError(t, s.RotateTokens(ctx))
+		_, err := store.GetTokenRotationPolicy(ctx, 1, 42)
+		assert.ErrorIs(t, err, serviceaccounts.ErrTokenRotationPolicyNotFound)
+	})
+}
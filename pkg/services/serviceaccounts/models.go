@@ -22,6 +22,9 @@ const (
 	ActionPermissionsWrite = "serviceaccounts.permissions:write"
 )
 
+// RotatedTokenSecretType is the type of the secrets the rotated service account tokens are published with
+const RotatedTokenSecretType = "serviceaccount-token"
+
 type ServiceAccount struct {
 	Id int64
 }
@@ -69,9 +72,25 @@ type AddServiceAccountTokenCommand struct {
 	OrgId         int64          `json:"-"`
 	Key           string         `json:"-"`
 	SecondsToLive int64          `json:"secondsToLive"`
+	Fingerprint   string         `json:"-"`
 	Result        *apikey.APIKey `json:"-"`
 }
 
+// TokenRotationPolicy makes the token rotation service replace the tokens of a service account once the newest one
+// is older than MaxTokenAgeSeconds. The replaced tokens remain valid for OverlapSeconds, and the secret of the new
+// token is published in the secrets store under SecretNamespace, the login of the service account when empty.
+// swagger:model
+type TokenRotationPolicy struct {
+	// example: 2592000
+	MaxTokenAgeSeconds int64 `json:"maxTokenAgeSeconds"`
+	// example: 86400
+	OverlapSeconds int64 `json:"overlapSeconds"`
+	// example: sa-grafana
+	SecretNamespace  string `json:"secretNamespace"`
+	OrgId            int64  `json:"-"`
+	ServiceAccountId int64  `json:"-"`
+}
+
 // swagger: model
 type SearchServiceAccountsResult struct {
 	// It can be used for pagination of the user list
@@ -115,6 +134,41 @@ type APIKeysMigrationStatus struct {
 	Migrated bool `json:"migrated"`
 }
 
+// APIKeysMigrationReport describes what migrating the API keys of an
+// organization to service accounts would do, without migrating them.
+type APIKeysMigrationReport struct {
+	// API keys that would be converted to service account tokens
+	Converted []APIKeyMigrationItem `json:"converted"`
+	// API keys whose service account login is already taken
+	Collisions []APIKeyMigrationItem `json:"collisions"`
+	// API keys whose role is not a valid service account role
+	UnmappedRoles []APIKeyMigrationItem `json:"unmappedRoles"`
+}
+
+type APIKeyMigrationItem struct {
+	// example: 42
+	KeyId int64 `json:"keyId"`
+	// example: grafana-agent
+	KeyName string `json:"keyName"`
+	// example: Editor
+	Role org.RoleType `json:"role"`
+	// example: sa-autogen-1-grafana-agent
+	ServiceAccountLogin string `json:"serviceAccountLogin"`
+	// example: sa-autogen-grafana-agent
+	ServiceAccountName string `json:"serviceAccountName"`
+	// Why the API key is in the collisions or was not reverted, empty otherwise
+	Reason string `json:"reason,omitempty"`
+}
+
+// APIKeysMigrationRevertReport is the outcome of reverting the migration of
+// the API keys of an organization to service accounts.
+type APIKeysMigrationRevertReport struct {
+	// Service account tokens converted back to API keys
+	Reverted []APIKeyMigrationItem `json:"reverted"`
+	// Tokens of migrated service accounts that were changed since the migration
+	Skipped []APIKeyMigrationItem `json:"skipped"`
+}
+
 const (
 	FilterOnlyExpiredTokens ServiceAccountFilter = "expiredTokens"
 	FilterOnlyDisabled      ServiceAccountFilter = "disabled"
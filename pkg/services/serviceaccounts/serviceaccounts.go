@@ -2,6 +2,7 @@ package serviceaccounts
 
 import (
 	"context"
+	"time"
 
 	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/services/user"
@@ -25,12 +26,19 @@ type Store interface {
 	DeleteServiceAccount(ctx context.Context, orgID, serviceAccountID int64) error
 	GetAPIKeysMigrationStatus(ctx context.Context, orgID int64) (*APIKeysMigrationStatus, error)
 	HideApiKeysTab(ctx context.Context, orgID int64) error
+	GetAPIKeysMigrationReport(ctx context.Context, orgID int64) (*APIKeysMigrationReport, error)
 	MigrateApiKeysToServiceAccounts(ctx context.Context, orgID int64) error
 	MigrateApiKey(ctx context.Context, orgID int64, keyId int64) error
 	RevertApiKey(ctx context.Context, saId int64, keyId int64) error
+	RevertApiKeysMigration(ctx context.Context, orgID int64) (*APIKeysMigrationRevertReport, error)
 	ListTokens(ctx context.Context, orgID int64, serviceAccount int64) ([]*apikey.APIKey, error)
 	DeleteServiceAccountToken(ctx context.Context, orgID, serviceAccountID, tokenID int64) error
 	AddServiceAccountToken(ctx context.Context, serviceAccountID int64, cmd *AddServiceAccountTokenCommand) error
+	SetServiceAccountTokenExpiry(ctx context.Context, orgID, serviceAccountID, tokenID int64, expires time.Time) error
+	GetTokenRotationPolicy(ctx context.Context, orgID, serviceAccountID int64) (*TokenRotationPolicy, error)
+	SetTokenRotationPolicy(ctx context.Context, policy *TokenRotationPolicy) error
+	DeleteTokenRotationPolicy(ctx context.Context, orgID, serviceAccountID int64) error
+	ListTokenRotationPolicies(ctx context.Context) ([]*TokenRotationPolicy, error)
 	GetUsageMetrics(ctx context.Context) (map[string]interface{}, error)
 	RunMetricsCollection(ctx context.Context) error
 }
@@ -4,11 +4,15 @@ import (
 	"context"
 	"testing"
 
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/kvstore"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
 	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/services/apikey/apikeyimpl"
+	encryptionservice "github.com/grafana/grafana/pkg/services/encryption/service"
 	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
 	"github.com/grafana/grafana/pkg/services/serviceaccounts"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/grafana/grafana/pkg/services/user"
@@ -64,7 +68,7 @@ func SetupApiKey(t *testing.T, sqlStore *sqlstore.SQLStore, testKey TestApiKey)
 		addKeyCmd.Key = "secret"
 	}
 
-	apiKeyService := apikeyimpl.ProvideService(sqlStore, sqlStore.Cfg)
+	apiKeyService := apikeyimpl.ProvideService(sqlStore, sqlStore.Cfg, fakes.NewFakeSecretsService(), encryptionservice.SetupTestService(t), kvstore.ProvideService(sqlStore), accesscontrolmock.New(), clock.New())
 	err := apiKeyService.AddAPIKey(context.Background(), addKeyCmd)
 	require.NoError(t, err)
 
@@ -124,10 +128,12 @@ type Calls struct {
 	RetrieveServiceAccount          []interface{}
 	DeleteServiceAccount            []interface{}
 	GetAPIKeysMigrationStatus       []interface{}
+	GetAPIKeysMigrationReport       []interface{}
 	HideApiKeysTab                  []interface{}
 	MigrateApiKeysToServiceAccounts []interface{}
 	MigrateApiKey                   []interface{}
 	RevertApiKey                    []interface{}
+	RevertApiKeysMigration          []interface{}
 	ListTokens                      []interface{}
 	DeleteServiceAccountToken       []interface{}
 	UpdateServiceAccount            []interface{}
@@ -168,6 +174,11 @@ func (s *ServiceAccountsStoreMock) GetAPIKeysMigrationStatus(ctx context.Context
 	return nil, nil
 }
 
+func (s *ServiceAccountsStoreMock) GetAPIKeysMigrationReport(ctx context.Context, orgID int64) (*serviceaccounts.APIKeysMigrationReport, error) {
+	s.Calls.GetAPIKeysMigrationReport = append(s.Calls.GetAPIKeysMigrationReport, []interface{}{ctx, orgID})
+	return &serviceaccounts.APIKeysMigrationReport{}, nil
+}
+
 func (s *ServiceAccountsStoreMock) MigrateApiKeysToServiceAccounts(ctx context.Context, orgID int64) error {
 	s.Calls.MigrateApiKeysToServiceAccounts = append(s.Calls.MigrateApiKeysToServiceAccounts, []interface{}{ctx})
 	return nil
@@ -183,6 +194,11 @@ func (s *ServiceAccountsStoreMock) RevertApiKey(ctx context.Context, saId int64,
 	return nil
 }
 
+func (s *ServiceAccountsStoreMock) RevertApiKeysMigration(ctx context.Context, orgID int64) (*serviceaccounts.APIKeysMigrationRevertReport, error) {
+	s.Calls.RevertApiKeysMigration = append(s.Calls.RevertApiKeysMigration, []interface{}{ctx, orgID})
+	return &serviceaccounts.APIKeysMigrationRevertReport{}, nil
+}
+
 func (s *ServiceAccountsStoreMock) ListTokens(ctx context.Context, orgID int64, serviceAccount int64) ([]*apikey.APIKey, error) {
 	s.Calls.ListTokens = append(s.Calls.ListTokens, []interface{}{ctx, orgID, serviceAccount})
 	return nil, nil
@@ -0,0 +1,69 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+)
+
+// bulkInsertMaxRows caps the rows of a statement, so that a table with a few columns doesn't build huge statements.
+const bulkInsertMaxRows = 1000
+
+// BulkInsert inserts the rows, a slice of structs or of pointers to structs, in the table within a single
+// transaction. It returns the number of inserted rows.
+func (ss *SQLStore) BulkInsert(ctx context.Context, table string, rows interface{}) (int64, error) {
+	var inserted int64
+	err := ss.WithTransactionalDbSession(ctx, func(sess *DBSession) error {
+		var err error
+		inserted, err = sess.BulkInsert(table, rows)
+		return err
+	})
+	return inserted, err
+}
+
+// BulkInsert inserts the rows, a slice of structs or of pointers to structs, in the table with as few statements as
+// the number of parameters a statement can bind in the dialect allows. The IDs of the inserted rows are not set. Use
+// a transactional session so that either all or none of the rows are inserted.
+func (sess *DBSession) BulkInsert(table string, rows interface{}) (int64, error) {
+	value := reflect.Indirect(reflect.ValueOf(rows))
+	if value.Kind() != reflect.Slice {
+		return 0, fmt.Errorf("bulk insert needs a slice of rows, got %T", rows)
+	}
+	if value.Len() == 0 {
+		return 0, nil
+	}
+
+	columns := len(sess.engine.TableInfo(value.Index(0).Interface()).Columns())
+	chunkSize := bulkInsertChunkSize(migrator.NewDialect(sess.engine), columns)
+
+	var inserted int64
+	for start := 0; start < value.Len(); start += chunkSize {
+		end := start + chunkSize
+		if end > value.Len() {
+			end = value.Len()
+		}
+		affected, err := sess.Table(table).InsertMulti(value.Slice(start, end).Interface())
+		if err != nil {
+			return inserted, err
+		}
+		inserted += affected
+	}
+	return inserted, nil
+}
+
+// bulkInsertChunkSize returns the number of rows of the statements inserting rows with the number of columns
+func bulkInsertChunkSize(dialect migrator.Dialect, columns int) int {
+	if columns < 1 {
+		columns = 1
+	}
+	size := dialect.MaxBindParameters() / columns
+	if size > bulkInsertMaxRows {
+		size = bulkInsertMaxRows
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
@@ -0,0 +1,64 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+)
+
+func TestBulkInsertChunkSize(t *testing.T) {
+	assert.Equal(t, 333, bulkInsertChunkSize(migrator.NewSQLite3Dialect(nil), 3))
+	assert.Equal(t, bulkInsertMaxRows, bulkInsertChunkSize(migrator.NewPostgresDialect(nil), 3))
+	assert.Equal(t, 655, bulkInsertChunkSize(migrator.NewMysqlDialect(nil), 100))
+	assert.Equal(t, 1, bulkInsertChunkSize(migrator.NewSQLite3Dialect(nil), 2000))
+}
+
+func TestIntegrationBulkInsert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	ss := InitTestDB(t)
+	ctx := context.Background()
+
+	// more rows than a statement can bind parameters for on every dialect
+	tags := make([]*models.Tag, 0, 2500)
+	for i := 0; i < 2500; i++ {
+		tags = append(tags, &models.Tag{Key: "bulk", Value: fmt.Sprint(i)})
+	}
+	inserted, err := ss.BulkInsert(ctx, "tag", tags)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2500), inserted)
+
+	inserted, err = ss.BulkInsert(ctx, "tag", []models.Tag{})
+	require.NoError(t, err)
+	assert.Zero(t, inserted)
+
+	err = ss.WithDbSession(ctx, func(sess *DBSession) error {
+		count, err := sess.Table("tag").Where(dialect.Quote("key")+" = ?", "bulk").Count()
+		assert.Equal(t, int64(2500), count)
+		return err
+	})
+	require.NoError(t, err)
+
+	t.Run("rolls back all the rows on error", func(t *testing.T) {
+		rows := []*models.Tag{{Key: "rollback", Value: "1"}, {Key: "bulk", Value: "0"}}
+		_, err := ss.BulkInsert(ctx, "tag", rows)
+		require.Error(t, err)
+
+		err = ss.WithDbSession(ctx, func(sess *DBSession) error {
+			count, err := sess.Table("tag").Where(dialect.Quote("key")+" = ?", "rollback").Count()
+			assert.Zero(t, count)
+			return err
+		})
+		require.NoError(t, err)
+	})
+
+	_, err = ss.BulkInsert(ctx, "tag", "not a slice")
+	require.Error(t, err)
+}
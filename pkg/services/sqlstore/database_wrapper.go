@@ -6,6 +6,8 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/gchaincl/sqlhooks"
@@ -16,6 +18,8 @@ import (
 	"github.com/lib/pq"
 	"github.com/mattn/go-sqlite3"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"xorm.io/core"
 )
 
@@ -36,8 +40,9 @@ func init() {
 
 // WrapDatabaseDriverWithHooks creates a fake database driver that
 // executes pre and post functions which we use to gather metrics about
-// database queries. It also registers the metrics.
-func WrapDatabaseDriverWithHooks(dbType string, tracer tracing.Tracer) string {
+// database queries. It also registers the metrics. The queries that take
+// longer than slowQueryThreshold are logged, unless it is 0.
+func WrapDatabaseDriverWithHooks(dbType string, tracer tracing.Tracer, slowQueryThreshold time.Duration) string {
 	drivers := map[string]driver.Driver{
 		migrator.SQLite:   &sqlite3.SQLiteDriver{},
 		migrator.MySQL:    &mysql.MySQLDriver{},
@@ -50,7 +55,12 @@ func WrapDatabaseDriverWithHooks(dbType string, tracer tracing.Tracer) string {
 	}
 
 	driverWithHooks := dbType + "WithHooks"
-	sql.Register(driverWithHooks, sqlhooks.Wrap(d, &databaseQueryWrapper{log: log.New("sqlstore.metrics"), tracer: tracer}))
+	sql.Register(driverWithHooks, sqlhooks.Wrap(d, &databaseQueryWrapper{
+		log:                log.New("sqlstore.metrics"),
+		tracer:             tracer,
+		dbType:             dbType,
+		slowQueryThreshold: slowQueryThreshold,
+	}))
 	core.RegisterDriver(driverWithHooks, &databaseQueryWrapperDriver{dbType: dbType})
 	return driverWithHooks
 }
@@ -58,16 +68,26 @@ func WrapDatabaseDriverWithHooks(dbType string, tracer tracing.Tracer) string {
 // databaseQueryWrapper satisfies the sqlhook.databaseQueryWrapper interface
 // which allow us to wrap all SQL queries with a `Before` & `After` hook.
 type databaseQueryWrapper struct {
-	log    log.Logger
-	tracer tracing.Tracer
+	log                log.Logger
+	tracer             tracing.Tracer
+	dbType             string
+	slowQueryThreshold time.Duration
 }
 
 // databaseQueryWrapperKey is used as key to save values in `context.Context`
 type databaseQueryWrapperKey struct{}
 
-// Before hook will print the query with its args and return the context with the timestamp
+// databaseQueryWrapperValue is the start of a query and its span
+type databaseQueryWrapperValue struct {
+	begin time.Time
+	span  tracing.Span
+}
+
+// Before hook will start the span of the query, which is a child of the span of the request, and return the context
+// with the timestamp
 func (h *databaseQueryWrapper) Before(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
-	return context.WithValue(ctx, databaseQueryWrapperKey{}, time.Now()), nil
+	_, span := h.tracer.Start(ctx, "database query")
+	return context.WithValue(ctx, databaseQueryWrapperKey{}, databaseQueryWrapperValue{begin: time.Now(), span: span}), nil
 }
 
 // After hook will get the timestamp registered on the Before hook and print the elapsed time
@@ -78,11 +98,12 @@ func (h *databaseQueryWrapper) After(ctx context.Context, query string, args ...
 }
 
 func (h *databaseQueryWrapper) instrument(ctx context.Context, status string, query string, err error) {
-	begin := ctx.Value(databaseQueryWrapperKey{}).(time.Time)
-	elapsed := time.Since(begin)
+	value := ctx.Value(databaseQueryWrapperKey{}).(databaseQueryWrapperValue)
+	elapsed := time.Since(value.begin)
 
 	histogram := databaseQueryHistogram.WithLabelValues(status)
-	if traceID := tracing.TraceIDFromContext(ctx, true); traceID != "" {
+	traceID := tracing.TraceIDFromContext(ctx, true)
+	if traceID != "" {
 		// Need to type-convert the Observer to an
 		// ExemplarObserver. This will always work for a
 		// HistogramVec.
@@ -92,17 +113,43 @@ func (h *databaseQueryWrapper) instrument(ctx context.Context, status string, qu
 	} else {
 		histogram.Observe(elapsed.Seconds())
 	}
-
-	_, span := h.tracer.Start(ctx, "database query")
-	defer span.End()
-
-	span.AddEvents([]string{"query", "status"}, []tracing.EventValue{{Str: query}, {Str: status}})
-
+	observeServiceQuery(ctx, status, elapsed)
+
+	// the arguments of the queries are never recorded, and the literals of their SQL are removed
+	sanitized := sanitizeSQL(query)
+	span := value.span
+	span.SetAttributes("db.system", h.dbType, attribute.String("db.system", h.dbType))
+	span.SetAttributes("db.statement", sanitized, attribute.String("db.statement", sanitized))
+	span.AddEvents([]string{"status"}, []tracing.EventValue{{Str: status}})
 	if err != nil {
-		span.AddEvents([]string{"error"}, []tracing.EventValue{{Str: err.Error()}})
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+	span.End()
+
+	if h.slowQueryThreshold > 0 && elapsed >= h.slowQueryThreshold {
+		h.log.Warn("slow query", "status", status, "elapsed time", elapsed, "sql", sanitized, "traceID", traceID)
+	}
+	h.log.Debug("query finished", "status", status, "elapsed time", elapsed, "sql", sanitized, "error", err)
+}
+
+var (
+	sqlStringLiteral  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlNumericLiteral = regexp.MustCompile(`\$?\b\d+(?:\.\d+)?\b`)
+	sqlWhitespace     = regexp.MustCompile(`\s+`)
+)
 
-	h.log.Debug("query finished", "status", status, "elapsed time", elapsed, "sql", query, "error", err)
+// sanitizeSQL replaces the string and numeric literals of a query with `?`, so that the values that were built into
+// the SQL rather than passed as arguments are not logged. The Postgres placeholders are kept.
+func sanitizeSQL(query string) string {
+	query = sqlStringLiteral.ReplaceAllString(query, "?")
+	query = sqlNumericLiteral.ReplaceAllStringFunc(query, func(literal string) string {
+		if strings.HasPrefix(literal, "$") {
+			return literal
+		}
+		return "?"
+	})
+	return strings.TrimSpace(sqlWhitespace.ReplaceAllString(query, " "))
 }
 
 // OnError will be called if any error happens
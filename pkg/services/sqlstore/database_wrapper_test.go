@@ -0,0 +1,99 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/log/logtest"
+	"github.com/grafana/grafana/pkg/infra/tracing"
+)
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := map[string]string{
+		"SELECT * FROM api_key WHERE org_id = ? AND name = ?":                  "SELECT * FROM api_key WHERE org_id = ? AND name = ?",
+		"SELECT * FROM api_key WHERE org_id=1 AND name='it''s a secret'":       "SELECT * FROM api_key WHERE org_id=? AND name=?",
+		"SELECT * FROM table1\n\tWHERE value > 1.5 LIMIT 10":                   "SELECT * FROM table1 WHERE value > ? LIMIT ?",
+		`INSERT INTO "kv_store" ("key", "value") VALUES ($1, $2)`:              `INSERT INTO "kv_store" ("key", "value") VALUES ($1, $2)`,
+		"UPDATE secrets SET value = 'c2VjcmV0' WHERE id IN (1, 2) AND v2 = $3": "UPDATE secrets SET value = ? WHERE id IN (?, ?) AND v2 = $3",
+	}
+	for query, expected := range tests {
+		assert.Equal(t, expected, sanitizeSQL(query))
+	}
+}
+
+func TestDatabaseQueryWrapper(t *testing.T) {
+	newWrapper := func(threshold time.Duration) (*databaseQueryWrapper, *logtest.Fake) {
+		logger := &logtest.Fake{}
+		return &databaseQueryWrapper{
+			log:                logger,
+			tracer:             tracing.InitializeTracerForTest(),
+			dbType:             "sqlite3",
+			slowQueryThreshold: threshold,
+		}, logger
+	}
+
+	t.Run("logs the queries slower than the threshold", func(t *testing.T) {
+		h, logger := newWrapper(time.Nanosecond)
+		ctx, err := h.Before(context.Background(), "SELECT * FROM api_key WHERE key = 'secret'")
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+		_, err = h.After(ctx, "SELECT * FROM api_key WHERE key = 'secret'")
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, logger.WarnLogs.Calls)
+		assert.Equal(t, "slow query", logger.WarnLogs.Message)
+		assert.Contains(t, logger.WarnLogs.Ctx, "SELECT * FROM api_key WHERE key = ?")
+		assert.NotContains(t, logger.DebugLogs.Ctx, "SELECT * FROM api_key WHERE key = 'secret'")
+	})
+
+	t.Run("does not log the fast queries", func(t *testing.T) {
+		h, logger := newWrapper(time.Hour)
+		ctx, err := h.Before(context.Background(), "SELECT 1")
+		require.NoError(t, err)
+		err = h.OnError(ctx, errors.New("failed"), "SELECT 1")
+		require.Error(t, err)
+
+		assert.Equal(t, 0, logger.WarnLogs.Calls)
+		assert.Equal(t, 1, logger.DebugLogs.Calls)
+	})
+
+	t.Run("does not log the queries when the threshold is not set", func(t *testing.T) {
+		h, logger := newWrapper(0)
+		ctx, err := h.Before(context.Background(), "SELECT 1")
+		require.NoError(t, err)
+		_, err = h.After(ctx, "SELECT 1")
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, logger.WarnLogs.Calls)
+	})
+
+	t.Run("counts the queries of the service the context was tagged with", func(t *testing.T) {
+		h, _ := newWrapper(0)
+		succeeded := databaseServiceQueriesCounter.WithLabelValues("test-service", "success")
+		failed := databaseServiceQueriesCounter.WithLabelValues("test-service", "error")
+		untagged := databaseServiceQueriesCounter.WithLabelValues(unknownService, "success")
+		before := testutil.ToFloat64(untagged)
+
+		ctx, err := h.Before(WithServiceName(context.Background(), "test-service"), "SELECT 1")
+		require.NoError(t, err)
+		_, err = h.After(ctx, "SELECT 1")
+		require.NoError(t, err)
+		ctx, err = h.Before(WithServiceName(context.Background(), "test-service"), "SELECT 1")
+		require.NoError(t, err)
+		require.Error(t, h.OnError(ctx, errors.New("failed"), "SELECT 1"))
+		ctx, err = h.Before(context.Background(), "SELECT 1")
+		require.NoError(t, err)
+		_, err = h.After(ctx, "SELECT 1")
+		require.NoError(t, err)
+
+		assert.Equal(t, float64(1), testutil.ToFloat64(succeeded))
+		assert.Equal(t, float64(1), testutil.ToFloat64(failed))
+		assert.Equal(t, before+1, testutil.ToFloat64(untagged))
+	})
+}
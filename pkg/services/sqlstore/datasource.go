@@ -47,6 +47,14 @@ func (ss *SQLStore) getDataSource(ctx context.Context, query *datasources.GetDat
 	return nil
 }
 
+// datasourcesReadFilterBuilder restricts a query of the data_source table to the data sources the user can read
+var datasourcesReadFilterBuilder = ac.NewSQLFilterBuilder(datasources.ScopeRoot,
+	ac.SQLFilterID("id"),
+	ac.SQLFilterUID("uid"),
+	ac.SQLFilterName("name"),
+)
+
+// GetDataSources returns the data sources of the organization, only the ones query.User can read when it is set
 func (ss *SQLStore) GetDataSources(ctx context.Context, query *datasources.GetDataSourcesQuery) error {
 	var sess *xorm.Session
 	return ss.WithDbSession(ctx, func(dbSess *DBSession) error {
@@ -56,6 +64,14 @@ func (ss *SQLStore) GetDataSources(ctx context.Context, query *datasources.GetDa
 			sess = dbSess.Limit(query.DataSourceLimit, 0).Where("org_id=?", query.OrgId).Asc("name")
 		}
 
+		if query.User != nil && !ac.IsDisabled(ss.Cfg) {
+			filter, err := datasourcesReadFilterBuilder.Build(query.User, datasources.ActionRead)
+			if err != nil {
+				return err
+			}
+			sess.And(filter.Where, filter.Args...)
+		}
+
 		query.Result = make([]*datasources.DataSource, 0)
 		return sess.Find(&query.Result)
 	})
@@ -124,6 +140,7 @@ func (ss *SQLStore) DeleteDataSource(ctx context.Context, cmd *datasources.Delet
 				ac.Scope("datasources", "id", fmt.Sprint(dsQuery.Result.Id))); errDeletingPerms != nil {
 				return errDeletingPerms
 			}
+			sess.PublishAfterCommit(&events.AccessControlPermissionsChanged{Timestamp: time.Now(), OrgID: ds.OrgId, UserID: 0})
 		}
 
 		if cmd.UpdateSecretFn != nil {
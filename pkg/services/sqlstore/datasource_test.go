@@ -14,6 +14,7 @@ import (
 	"github.com/grafana/grafana/pkg/events"
 	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/datasources"
+	"github.com/grafana/grafana/pkg/services/user"
 )
 
 func TestIntegrationDataAccess(t *testing.T) {
@@ -401,6 +402,32 @@ func TestIntegrationDataAccess(t *testing.T) {
 			require.NoError(t, err)
 			require.Equal(t, numberOfDatasource, len(query.Result))
 		})
+
+		t.Run("Only the data sources the user can read are returned", func(t *testing.T) {
+			sqlStore := InitTestDB(t)
+			for i := 0; i < 4; i++ {
+				err := sqlStore.AddDataSource(context.Background(), &datasources.AddDataSourceCommand{
+					OrgId:  10,
+					Name:   "laban" + strconv.Itoa(i),
+					Uid:    "uid" + strconv.Itoa(i),
+					Type:   datasources.DS_GRAPHITE,
+					Access: datasources.DS_ACCESS_DIRECT,
+					Url:    "http://test",
+				})
+				require.NoError(t, err)
+			}
+			usr := &user.SignedInUser{OrgID: 10, Permissions: map[int64]map[string][]string{10: {
+				datasources.ActionRead: {"datasources:uid:uid1", "datasources:name:laban3"},
+			}}}
+			query := datasources.GetDataSourcesQuery{OrgId: 10, User: usr}
+
+			err := sqlStore.GetDataSources(context.Background(), &query)
+
+			require.NoError(t, err)
+			require.Len(t, query.Result, 2)
+			require.Equal(t, "laban1", query.Result[0].Name)
+			require.Equal(t, "laban3", query.Result[1].Name)
+		})
 	})
 
 	t.Run("GetDataSourcesByType", func(t *testing.T) {
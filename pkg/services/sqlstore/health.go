@@ -14,3 +14,45 @@ func (ss *SQLStore) GetDBHealthQuery(ctx context.Context, query *models.GetDBHea
 		return err
 	})
 }
+
+// ConnectionPoolHealth is the state of the connection pool of a database.
+type ConnectionPoolHealth struct {
+	Database            string  `json:"database"`
+	MaxOpenConnections  int     `json:"maxOpenConnections"`
+	OpenConnections     int     `json:"openConnections"`
+	InUse               int     `json:"inUse"`
+	Idle                int     `json:"idle"`
+	WaitCount           int64   `json:"waitCount"`
+	WaitDurationSeconds float64 `json:"waitDurationSeconds"`
+	MaxLifetimeClosed   int64   `json:"maxLifetimeClosed"`
+	// Exhausted is set when all the connections allowed by max_open_conn are in use, or when a query had to
+	// wait for a connection since the previous check.
+	Exhausted bool `json:"exhausted"`
+}
+
+// GetConnectionPoolHealth returns the state of the connection pool of the database.
+func (ss *SQLStore) GetConnectionPoolHealth() ConnectionPoolHealth {
+	dbstats := ss.engine.DB().Stats()
+
+	ss.poolHealth.Lock()
+	waited := dbstats.WaitCount > ss.poolHealth.lastWaitCount
+	ss.poolHealth.lastWaitCount = dbstats.WaitCount
+	ss.poolHealth.Unlock()
+
+	health := ConnectionPoolHealth{
+		Database:            ss.databaseName(),
+		MaxOpenConnections:  dbstats.MaxOpenConnections,
+		OpenConnections:     dbstats.OpenConnections,
+		InUse:               dbstats.InUse,
+		Idle:                dbstats.Idle,
+		WaitCount:           dbstats.WaitCount,
+		WaitDurationSeconds: dbstats.WaitDuration.Seconds(),
+		MaxLifetimeClosed:   dbstats.MaxLifetimeClosed,
+	}
+	health.Exhausted = waited || (dbstats.MaxOpenConnections > 0 && dbstats.InUse >= dbstats.MaxOpenConnections)
+	if health.Exhausted {
+		ss.log.Warn("Database connection pool is exhausted, consider increasing max_open_conn",
+			"database", health.Database, "maxOpenConnections", health.MaxOpenConnections, "inUse", health.InUse, "waitCount", health.WaitCount)
+	}
+	return health
+}
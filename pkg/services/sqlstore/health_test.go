@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/grafana/grafana/pkg/models"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -18,3 +19,59 @@ func TestIntegrationGetDBHealthQuery(t *testing.T) {
 	err := store.GetDBHealthQuery(context.Background(), &query)
 	require.NoError(t, err)
 }
+
+func TestIntegrationGetConnectionPoolHealth(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	store := InitTestDB(t)
+	db := store.engine.DB().DB
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.SetMaxOpenConns(store.dbCfg.MaxOpenConn)
+	})
+
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+
+	health := store.GetConnectionPoolHealth()
+	require.Equal(t, 1, health.MaxOpenConnections)
+	require.Equal(t, 1, health.InUse)
+	require.True(t, health.Exhausted)
+
+	require.NoError(t, conn.Close())
+	health = store.GetConnectionPoolHealth()
+	require.Equal(t, 0, health.InUse)
+	require.False(t, health.Exhausted)
+}
+
+func TestIntegrationConnectionPoolMetrics(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	store := InitTestDB(t)
+	store.initMetrics()
+
+	require.NoError(t, store.GetDBHealthQuery(context.Background(), &models.GetDBHealthQuery{}))
+	expected := store.engine.DB().Stats().OpenConnections
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(store))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 9)
+
+	for _, family := range families {
+		require.Equal(t, "database", family.GetMetric()[0].GetLabel()[0].GetName())
+		if family.GetName() == "grafana_database_conn_open" {
+			require.Equal(t, float64(expected), family.GetMetric()[0].GetGauge().GetValue())
+		}
+	}
+
+	// the cumulative statistics must not be added up on every scrape
+	first, err := registry.Gather()
+	require.NoError(t, err)
+	second, err := registry.Gather()
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+}
@@ -0,0 +1,67 @@
+package accesscontrol
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+
+	"xorm.io/xorm"
+)
+
+const APIKeyCreateScopeMigrationID = "RBAC apikeys:create scope migrator"
+
+// AddAPIKeyCreateScopeMigrator scopes the apikeys:create permissions granted without a scope to all API keys. The
+// creation of API keys is scoped since keys can be owned by teams, and permissions without a scope never match a
+// scoped evaluation.
+func AddAPIKeyCreateScopeMigrator(mg *migrator.Migrator) {
+	mg.AddMigration(APIKeyCreateScopeMigrationID, &apiKeyCreateScopeMigrator{})
+}
+
+type apiKeyCreateScopeMigrator struct {
+	migrator.MigrationBase
+}
+
+var _ migrator.CodeMigration = new(apiKeyCreateScopeMigrator)
+
+func (m *apiKeyCreateScopeMigrator) SQL(migrator.Dialect) string {
+	return CodeMigrationSQL
+}
+
+func (m *apiKeyCreateScopeMigrator) Exec(sess *xorm.Session, mg *migrator.Migrator) error {
+	unscoped := make([]*accesscontrol.Permission, 0)
+	if err := sess.Where("action = ? AND scope = ?", accesscontrol.ActionAPIKeyCreate, "").Find(&unscoped); err != nil {
+		return fmt.Errorf("failed to list unscoped %s permissions: %w", accesscontrol.ActionAPIKeyCreate, err)
+	}
+	if len(unscoped) == 0 {
+		return nil
+	}
+
+	scoped := make([]*accesscontrol.Permission, 0)
+	if err := sess.Where("action = ? AND scope = ?", accesscontrol.ActionAPIKeyCreate, accesscontrol.ScopeAPIKeysAll).Find(&scoped); err != nil {
+		return fmt.Errorf("failed to list scoped %s permissions: %w", accesscontrol.ActionAPIKeyCreate, err)
+	}
+	// roles already granted the scoped permission only lose the unscoped one, the uniqueness constraint of the
+	// permission table forbids a second scoped permission
+	alreadyScoped := make(map[int64]bool, len(scoped))
+	for _, permission := range scoped {
+		alreadyScoped[permission.RoleID] = true
+	}
+
+	now := time.Now()
+	for _, permission := range unscoped {
+		if alreadyScoped[permission.RoleID] {
+			if _, err := sess.Exec("DELETE FROM permission WHERE id = ?", permission.ID); err != nil {
+				return fmt.Errorf("failed to delete unscoped %s permission: %w", accesscontrol.ActionAPIKeyCreate, err)
+			}
+			continue
+		}
+		if _, err := sess.Exec("UPDATE permission SET scope = ?, updated = ? WHERE id = ?", accesscontrol.ScopeAPIKeysAll, now, permission.ID); err != nil {
+			return fmt.Errorf("failed to scope %s permission: %w", accesscontrol.ActionAPIKeyCreate, err)
+		}
+	}
+
+	mg.Logger.Info("scoped apikeys:create permissions", "count", len(unscoped))
+	return nil
+}
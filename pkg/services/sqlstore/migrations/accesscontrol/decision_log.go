@@ -0,0 +1,29 @@
+package accesscontrol
+
+import "github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+
+// AddDecisionLogMigration creates the table of the access control decisions recorded by the decision log
+func AddDecisionLogMigration(mg *migrator.Migrator) {
+	decisionV1 := migrator.Table{
+		Name: "accesscontrol_decision",
+		Columns: []*migrator.Column{
+			{Name: "id", Type: migrator.DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "user_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "api_key_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "action", Type: migrator.DB_Varchar, Length: 190, Nullable: false},
+			{Name: "scopes", Type: migrator.DB_Text, Nullable: false},
+			{Name: "allowed", Type: migrator.DB_Bool, Nullable: false},
+			{Name: "matched_scope", Type: migrator.DB_Varchar, Length: 190, Nullable: false},
+			{Name: "created", Type: migrator.DB_DateTime, Nullable: false},
+		},
+		Indices: []*migrator.Index{
+			{Cols: []string{"created"}},
+			{Cols: []string{"org_id", "user_id"}},
+		},
+	}
+
+	mg.AddMigration("create accesscontrol_decision table", migrator.NewAddTableMigration(decisionV1))
+	mg.AddMigration("add index accesscontrol_decision.created", migrator.NewAddIndexMigration(decisionV1, decisionV1.Indices[0]))
+	mg.AddMigration("add index accesscontrol_decision.org_id_user_id", migrator.NewAddIndexMigration(decisionV1, decisionV1.Indices[1]))
+}
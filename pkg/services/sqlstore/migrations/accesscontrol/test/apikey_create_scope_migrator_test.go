@@ -0,0 +1,58 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	acmig "github.com/grafana/grafana/pkg/services/sqlstore/migrations/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestAPIKeyCreateScopeMigration(t *testing.T) {
+	// Run initial migration to have a working DB
+	x := setupTestDB(t)
+
+	seed := []*accesscontrol.Permission{
+		// unscoped grant of a custom role
+		{RoleID: 1, Action: accesscontrol.ActionAPIKeyCreate, Scope: "", Created: now, Updated: now},
+		// role granted both the unscoped and the scoped permission
+		{RoleID: 2, Action: accesscontrol.ActionAPIKeyCreate, Scope: "", Created: now, Updated: now},
+		{RoleID: 2, Action: accesscontrol.ActionAPIKeyCreate, Scope: accesscontrol.ScopeAPIKeysAll, Created: now, Updated: now},
+		// permissions that are left untouched
+		{RoleID: 3, Action: accesscontrol.ActionAPIKeyCreate, Scope: "apikeys:team:1", Created: now, Updated: now},
+		{RoleID: 3, Action: accesscontrol.ActionAPIKeyApprove, Scope: "", Created: now, Updated: now},
+	}
+	_, err := x.Exec(`DELETE FROM migration_log WHERE migration_id = ?`, acmig.APIKeyCreateScopeMigrationID)
+	require.NoError(t, err)
+	_, err = x.Exec(`DELETE FROM permission`)
+	require.NoError(t, err)
+	_, err = x.Insert(seed)
+	require.NoError(t, err)
+
+	acmigrator := migrator.NewMigrator(x, &setting.Cfg{Logger: log.New("acmigration.test")})
+	acmig.AddAPIKeyCreateScopeMigrator(acmigrator)
+	require.NoError(t, acmigrator.Start(false, 0))
+
+	var permissions []accesscontrol.Permission
+	require.NoError(t, x.Table("permission").Asc("role_id", "action", "scope").Find(&permissions))
+
+	type rolePermission struct {
+		RoleID        int64
+		Action, Scope string
+	}
+	got := make([]rolePermission, 0, len(permissions))
+	for _, p := range permissions {
+		got = append(got, rolePermission{p.RoleID, p.Action, p.Scope})
+	}
+	assert.Equal(t, []rolePermission{
+		{1, accesscontrol.ActionAPIKeyCreate, accesscontrol.ScopeAPIKeysAll},
+		{2, accesscontrol.ActionAPIKeyCreate, accesscontrol.ScopeAPIKeysAll},
+		{3, accesscontrol.ActionAPIKeyApprove, ""},
+		{3, accesscontrol.ActionAPIKeyCreate, "apikeys:team:1"},
+	}, got)
+}
@@ -95,4 +95,137 @@ func addApiKeyMigrations(mg *Migrator) {
 	mg.AddMigration("Add last_used_at to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
 		Name: "last_used_at", Type: DB_DateTime, Nullable: true,
 	}))
+
+	mg.AddMigration("Add previous_key to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "previous_key", Type: DB_Varchar, Length: 190, Nullable: true,
+	}))
+
+	mg.AddMigration("Add previous_key_expires to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "previous_key_expires", Type: DB_BigInt, Nullable: true,
+	}))
+
+	mg.AddMigration("Add created_by to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "created_by", Type: DB_BigInt, Nullable: true,
+	}))
+
+	mg.AddMigration("Add allowed_ip_ranges to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "allowed_ip_ranges", Type: DB_Text, Nullable: true,
+	}))
+
+	mg.AddMigration("Add last_used_ip to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "last_used_ip", Type: DB_Varchar, Length: 45, Nullable: true,
+	}))
+
+	mg.AddMigration("Add last_used_user_agent to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "last_used_user_agent", Type: DB_Varchar, Length: 255, Nullable: true,
+	}))
+
+	mg.AddMigration("Add labels to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "labels", Type: DB_Text, Nullable: true,
+	}))
+
+	mg.AddMigration("Add rate_limit_rps to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "rate_limit_rps", Type: DB_BigInt, Nullable: true,
+	}))
+
+	mg.AddMigration("Add status to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "status", Type: DB_NVarchar, Length: 20, Nullable: false, Default: "'active'",
+	}))
+
+	apiKeyUsage := Table{
+		Name: "api_key_usage",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "api_key_id", Type: DB_BigInt, Nullable: false},
+			{Name: "org_id", Type: DB_BigInt, Nullable: false},
+			{Name: "route", Type: DB_NVarchar, Length: 255, Nullable: false},
+			{Name: "period_start", Type: DB_BigInt, Nullable: false},
+			{Name: "period_seconds", Type: DB_Int, Nullable: false},
+			{Name: "requests", Type: DB_BigInt, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"api_key_id", "period_start"}},
+			{Cols: []string{"period_start"}},
+		},
+	}
+
+	mg.AddMigration("create api_key_usage table", NewAddTableMigration(apiKeyUsage))
+	mg.AddMigration("add index api_key_usage.api_key_id_period_start", NewAddIndexMigration(apiKeyUsage, apiKeyUsage.Indices[0]))
+	mg.AddMigration("add index api_key_usage.period_start", NewAddIndexMigration(apiKeyUsage, apiKeyUsage.Indices[1]))
+
+	mg.AddMigration("Add key_type to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "key_type", Type: DB_NVarchar, Length: 20, Nullable: false, Default: "'bearer'",
+	}))
+
+	mg.AddMigration("Add signing_secret to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "signing_secret", Type: DB_Text, Nullable: true,
+	}))
+
+	mg.AddMigration("Add secondary_key to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "secondary_key", Type: DB_Varchar, Length: 190, Nullable: true,
+	}))
+
+	mg.AddMigration("Add datasource_uids to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "datasource_uids", Type: DB_Text, Nullable: true,
+	}))
+
+	mg.AddMigration("Add owner_team_id to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "owner_team_id", Type: DB_BigInt, Nullable: true,
+	}))
+
+	mg.AddMigration("add index api_key.owner_team_id", NewAddIndexMigration(apiKeyV2, &Index{
+		Cols: []string{"owner_team_id"},
+	}))
+
+	mg.AddMigration("Add access_windows to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "access_windows", Type: DB_Text, Nullable: true,
+	}))
+
+	apiKeyLeak := Table{
+		Name: "api_key_leak",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "hash", Type: DB_NVarchar, Length: 64, Nullable: false},
+			{Name: "api_key_id", Type: DB_BigInt, Nullable: true},
+			{Name: "source", Type: DB_NVarchar, Length: 190, Nullable: false},
+			{Name: "url", Type: DB_Text, Nullable: true},
+			{Name: "created", Type: DB_DateTime, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"hash"}, Type: UniqueIndex},
+		},
+	}
+
+	mg.AddMigration("create api_key_leak table", NewAddTableMigration(apiKeyLeak))
+	mg.AddMigration("add unique index api_key_leak.hash", NewAddIndexMigration(apiKeyLeak, apiKeyLeak.Indices[0]))
+
+	mg.AddMigration("Add encrypted_metadata to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "encrypted_metadata", Type: DB_Text, Nullable: true,
+	}))
+
+	apiKeyFingerprint := Table{
+		Name: "api_key_fingerprint",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "api_key_id", Type: DB_BigInt, Nullable: false},
+			{Name: "hash", Type: DB_NVarchar, Length: 64, Nullable: false},
+			{Name: "created", Type: DB_DateTime, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"hash"}, Type: UniqueIndex},
+			{Cols: []string{"api_key_id"}},
+		},
+	}
+
+	mg.AddMigration("create api_key_fingerprint table", NewAddTableMigration(apiKeyFingerprint))
+	mg.AddMigration("add unique index api_key_fingerprint.hash", NewAddIndexMigration(apiKeyFingerprint, apiKeyFingerprint.Indices[0]))
+	mg.AddMigration("add index api_key_fingerprint.api_key_id", NewAddIndexMigration(apiKeyFingerprint, apiKeyFingerprint.Indices[1]))
+
+	mg.AddMigration("Add previous_signing_secret to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "previous_signing_secret", Type: DB_Text, Nullable: true,
+	}))
+
+	mg.AddMigration("Add fingerprinted to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "fingerprinted", Type: DB_Bool, Nullable: false, Default: "0",
+	}))
 }
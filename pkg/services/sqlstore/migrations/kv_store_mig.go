@@ -24,4 +24,14 @@ func addKVStoreMigrations(mg *Migrator) {
 	mg.AddMigration("create kv_store table v1", NewAddTableMigration(kvStoreV1))
 
 	mg.AddMigration("add index kv_store.org_id-namespace-key", NewAddIndexMigration(kvStoreV1, kvStoreV1.Indices[0]))
+
+	mg.AddMigration("add expires column to kv_store", NewAddColumnMigration(kvStoreV1, &Column{
+		Name: "expires", Type: DB_DateTime, Nullable: true,
+	}))
+	mg.AddMigration("add index kv_store.expires", NewAddIndexMigration(kvStoreV1, &Index{
+		Cols: []string{"expires"},
+	}))
+	mg.AddMigration("add index kv_store.namespace", NewAddIndexMigration(kvStoreV1, &Index{
+		Cols: []string{"namespace"},
+	}))
 }
@@ -237,6 +237,43 @@ func TestDatabaseLocking(t *testing.T) {
 	assert.Equal(t, int64(1), errorNum)
 }
 
+func TestAddIndexOnlineMigration(t *testing.T) {
+	dbType := getDBType()
+	testDB := getTestDB(t, dbType)
+
+	x, err := xorm.NewEngine(testDB.DriverName, testDB.ConnStr)
+	require.NoError(t, err)
+
+	err = NewDialect(x).CleanDB()
+	require.NoError(t, err)
+
+	table := Table{
+		Name: "online_index_test",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: DB_BigInt, Nullable: false},
+		},
+	}
+	index := &Index{Cols: []string{"org_id"}}
+
+	for _, locking := range []bool{false, true} {
+		mg := NewMigrator(x, &setting.Cfg{})
+		addMigrationLogMigrations(mg)
+		mg.AddMigration("create online_index_test table", NewAddTableMigration(table))
+		mg.AddMigration("add index online_index_test.org_id", NewAddIndexOnlineMigration(table, index))
+		require.NoError(t, mg.Start(locking, 0))
+
+		sql, args := mg.Dialect.IndexCheckSQL(table.Name, index.XName(table.Name))
+		results, err := x.SQL(sql, args...).Query()
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+
+		logs, err := mg.GetMigrationLog()
+		require.NoError(t, err)
+		require.True(t, logs["add index online_index_test.org_id"].Success)
+	}
+}
+
 func checkStepsAndDatabaseMatch(t *testing.T, mg *Migrator, expected []string) {
 	t.Helper()
 	log, err := mg.GetMigrationLog()
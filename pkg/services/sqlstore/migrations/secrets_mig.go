@@ -73,4 +73,80 @@ func addSecretsMigration(mg *migrator.Migrator) {
 	))
 
 	// --------------------
+
+	secretsHistoryV1 := migrator.Table{
+		Name: "secrets_history",
+		Columns: []*migrator.Column{
+			{Name: "id", Type: migrator.DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "namespace", Type: migrator.DB_NVarchar, Length: 255, Nullable: false},
+			{Name: "type", Type: migrator.DB_NVarchar, Length: 255, Nullable: false},
+			{Name: "version", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "value", Type: migrator.DB_Text, Nullable: true},
+			{Name: "created", Type: migrator.DB_DateTime, Nullable: false},
+		},
+		Indices: []*migrator.Index{
+			{Cols: []string{"org_id", "namespace", "type"}},
+			{Cols: []string{"org_id", "namespace", "type", "version"}, Type: migrator.UniqueIndex},
+		},
+	}
+
+	mg.AddMigration("create secrets_history table", migrator.NewAddTableMigration(secretsHistoryV1))
+	mg.AddMigration("add index secrets_history.org_id_namespace_type", migrator.NewAddIndexMigration(secretsHistoryV1, secretsHistoryV1.Indices[0]))
+	mg.AddMigration("add unique index secrets_history.org_id_namespace_type_version", migrator.NewAddIndexMigration(secretsHistoryV1, secretsHistoryV1.Indices[1]))
+
+	mg.AddMigration("add expires column to secrets", migrator.NewAddColumnMigration(secretsV1, &migrator.Column{
+		Name: "expires", Type: migrator.DB_DateTime, Nullable: true,
+	}))
+	mg.AddMigration("add index secrets.expires", migrator.NewAddIndexMigration(secretsV1, &migrator.Index{
+		Cols: []string{"expires"},
+	}))
+	mg.AddMigration("add scope column to secrets", migrator.NewAddColumnMigration(secretsV1, &migrator.Column{
+		Name: "scope", Type: migrator.DB_NVarchar, Length: 30, Nullable: true,
+	}))
+
+	// --------------------
+
+	secretsAuditV1 := migrator.Table{
+		Name: "secrets_audit",
+		Columns: []*migrator.Column{
+			{Name: "id", Type: migrator.DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "namespace", Type: migrator.DB_NVarchar, Length: 255, Nullable: false},
+			{Name: "type", Type: migrator.DB_NVarchar, Length: 255, Nullable: false},
+			{Name: "operation", Type: migrator.DB_NVarchar, Length: 40, Nullable: false},
+			{Name: "caller", Type: migrator.DB_NVarchar, Length: 255, Nullable: false},
+			{Name: "user_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "outcome", Type: migrator.DB_NVarchar, Length: 40, Nullable: false},
+			{Name: "created", Type: migrator.DB_DateTime, Nullable: false},
+		},
+		Indices: []*migrator.Index{
+			{Cols: []string{"created"}},
+			{Cols: []string{"org_id", "namespace"}},
+		},
+	}
+
+	mg.AddMigration("create secrets_audit table", migrator.NewAddTableMigration(secretsAuditV1))
+	mg.AddMigration("add index secrets_audit.created", migrator.NewAddIndexMigration(secretsAuditV1, secretsAuditV1.Indices[0]))
+	mg.AddMigration("add index secrets_audit.org_id_namespace", migrator.NewAddIndexMigration(secretsAuditV1, secretsAuditV1.Indices[1]))
+
+	// --------------------
+
+	secretsPluginMigrationV1 := migrator.Table{
+		Name: "secrets_plugin_migration",
+		Columns: []*migrator.Column{
+			{Name: "id", Type: migrator.DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "secret_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "org_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "namespace", Type: migrator.DB_NVarchar, Length: 255, Nullable: false},
+			{Name: "type", Type: migrator.DB_NVarchar, Length: 255, Nullable: false},
+			{Name: "migrated", Type: migrator.DB_DateTime, Nullable: false},
+		},
+		Indices: []*migrator.Index{
+			{Cols: []string{"secret_id"}, Type: migrator.UniqueIndex},
+		},
+	}
+
+	mg.AddMigration("create secrets_plugin_migration table", migrator.NewAddTableMigration(secretsPluginMigrationV1))
+	mg.AddMigration("add unique index secrets_plugin_migration.secret_id", migrator.NewAddIndexMigration(secretsPluginMigrationV1, secretsPluginMigrationV1.Indices[0]))
 }
@@ -37,6 +37,21 @@ func (c *IfIndexNotExistsCondition) SQL(dialect Dialect) (string, []interface{})
 	return dialect.IndexCheckSQL(c.TableName, c.IndexName)
 }
 
+// IfValidIndexNotExistsCondition is fulfilled when the index does not exist, or when it is left invalid on Postgres
+// by a concurrent build that failed.
+type IfValidIndexNotExistsCondition struct {
+	NotExistsMigrationCondition
+	TableName string
+	IndexName string
+}
+
+func (c *IfValidIndexNotExistsCondition) SQL(dialect Dialect) (string, []interface{}) {
+	if dialect.DriverName() == Postgres {
+		return `SELECT 1 FROM "pg_index" i JOIN "pg_class" c ON c.oid = i.indexrelid JOIN "pg_class" t ON t.oid = i.indrelid WHERE t.relname=? AND c.relname=? AND i.indisvalid`, []interface{}{c.TableName, c.IndexName}
+	}
+	return dialect.IndexCheckSQL(c.TableName, c.IndexName)
+}
+
 type IfColumnNotExistsCondition struct {
 	NotExistsMigrationCondition
 	TableName  string
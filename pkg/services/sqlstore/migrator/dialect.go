@@ -30,6 +30,9 @@ type Dialect interface {
 	OrderBy(order string) string
 
 	CreateIndexSQL(tableName string, index *Index) string
+	// CreateIndexOnlineSQL returns the statement creating the index without locking the table for writes. It must
+	// run outside a transaction.
+	CreateIndexOnlineSQL(tableName string, index *Index) string
 	CreateTableSQL(table *Table) string
 	AddColumnSQL(tableName string, col *Column) string
 	CopyTableData(sourceTable string, targetTable string, sourceCols []string, targetCols []string) string
@@ -50,6 +53,8 @@ type Dialect interface {
 	// UpsertSQL returns the upsert sql statement for a dialect
 	UpsertSQL(tableName string, keyCols, updateCols []string) string
 	UpsertMultipleSQL(tableName string, keyCols, updateCols []string, count int) (string, error)
+	// MaxBindParameters returns the number of parameters a statement can bind
+	MaxBindParameters() int
 
 	ColString(*Column) string
 	ColStringNoPk(*Column) string
@@ -96,6 +101,16 @@ func NewDialect(engine *xorm.Engine) Dialect {
 	panic("Unsupported database type: " + name)
 }
 
+// NewDialectForDriver returns the dialect of the database driver name, which does not have to be the driver of the
+// engine. It is used to render the SQL of the migrations for another database.
+func NewDialectForDriver(name string, engine *xorm.Engine) (Dialect, error) {
+	if fn, exist := supportedDialects[name]; exist {
+		return fn(engine), nil
+	}
+
+	return nil, fmt.Errorf("unsupported database type: %s", name)
+}
+
 type BaseDialect struct {
 	dialect    Dialect
 	engine     *xorm.Engine
@@ -173,6 +188,16 @@ func (b *BaseDialect) AddColumnSQL(tableName string, col *Column) string {
 }
 
 func (b *BaseDialect) CreateIndexSQL(tableName string, index *Index) string {
+	return b.createIndexSQL(tableName, index, "", "")
+}
+
+// CreateIndexOnlineSQL defaults to the regular creation of the index for the databases that do not lock the table
+// while building it, or cannot avoid it.
+func (b *BaseDialect) CreateIndexOnlineSQL(tableName string, index *Index) string {
+	return b.dialect.CreateIndexSQL(tableName, index)
+}
+
+func (b *BaseDialect) createIndexSQL(tableName string, index *Index, modifier string, options string) string {
 	quote := b.dialect.Quote
 	var unique string
 	if index.Type == UniqueIndex {
@@ -186,7 +211,7 @@ func (b *BaseDialect) CreateIndexSQL(tableName string, index *Index) string {
 		quotedCols = append(quotedCols, b.dialect.Quote(col))
 	}
 
-	return fmt.Sprintf("CREATE%s INDEX %v ON %v (%v);", unique, quote(idxName), quote(tableName), strings.Join(quotedCols, ","))
+	return fmt.Sprintf("CREATE%s INDEX%s %v ON %v (%v)%s;", unique, modifier, quote(idxName), quote(tableName), strings.Join(quotedCols, ","), options)
 }
 
 func (b *BaseDialect) QuoteColList(cols []string) string {
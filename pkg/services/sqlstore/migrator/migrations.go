@@ -164,6 +164,31 @@ func (m *AddIndexMigration) SQL(dialect Dialect) string {
 	return dialect.CreateIndexSQL(m.tableName, m.index)
 }
 
+// AddIndexOnlineMigration creates an index without locking the table for writes, for the large tables of busy
+// instances. It runs outside a transaction.
+type AddIndexOnlineMigration struct {
+	MigrationBase
+	tableName string
+	index     *Index
+}
+
+// NewAddIndexOnlineMigration creates the index concurrently on Postgres and in place on MySQL. When a concurrent build
+// fails on Postgres, the invalid index it leaves behind is not considered to exist and must be dropped before the
+// migration can succeed.
+func NewAddIndexOnlineMigration(table Table, index *Index) *AddIndexOnlineMigration {
+	m := &AddIndexOnlineMigration{tableName: table.Name, index: index}
+	m.Condition = &IfValidIndexNotExistsCondition{TableName: table.Name, IndexName: index.XName(table.Name)}
+	return m
+}
+
+func (m *AddIndexOnlineMigration) SQL(dialect Dialect) string {
+	return dialect.CreateIndexOnlineSQL(m.tableName, m.index)
+}
+
+func (m *AddIndexOnlineMigration) NoTransaction() bool {
+	return true
+}
+
 type DropIndexMigration struct {
 	MigrationBase
 	tableName string
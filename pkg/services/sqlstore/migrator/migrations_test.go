@@ -0,0 +1,22 @@
+package migrator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddIndexOnlineMigration(t *testing.T) {
+	table := Table{Name: "api_key"}
+	index := &Index{Cols: []string{"org_id", "last_used_at"}}
+	m := NewAddIndexOnlineMigration(table, index)
+
+	require.Equal(t, `CREATE INDEX CONCURRENTLY "IDX_api_key_org_id_last_used_at" ON "api_key" ("org_id","last_used_at");`, m.SQL(NewPostgresDialect(nil)))
+	require.Equal(t, "CREATE INDEX `IDX_api_key_org_id_last_used_at` ON `api_key` (`org_id`,`last_used_at`) ALGORITHM=INPLACE LOCK=NONE;", m.SQL(NewMysqlDialect(nil)))
+	require.Equal(t, "CREATE INDEX `IDX_api_key_org_id_last_used_at` ON `api_key` (`org_id`,`last_used_at`);", m.SQL(NewSQLite3Dialect(nil)))
+	require.True(t, m.NoTransaction())
+
+	sql, args := m.GetCondition().SQL(NewPostgresDialect(nil))
+	require.Contains(t, sql, "i.indisvalid")
+	require.Equal(t, []interface{}{"api_key", "IDX_api_key_org_id_last_used_at"}, args)
+}
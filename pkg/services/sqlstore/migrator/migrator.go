@@ -100,6 +100,55 @@ func (mg *Migrator) GetMigrationLog() (map[string]MigrationLog, error) {
 	return logMap, nil
 }
 
+// PlannedMigration is a migration that has not been executed yet.
+type PlannedMigration struct {
+	ID string `json:"id"`
+	// SQL is the statement executed by the migration, empty for the code migrations.
+	SQL           string `json:"sql,omitempty"`
+	CodeMigration bool   `json:"codeMigration"`
+	// Skipped is set when the condition of the migration shows that it was already executed without being recorded
+	// in the migration log. It is only evaluated for the dialect of the database.
+	Skipped bool `json:"skipped"`
+}
+
+// Plan returns the migrations that have not been executed yet, in the order they would be executed, with the SQL
+// they would execute for the dialect. Nothing is written to the database.
+func (mg *Migrator) Plan(dialect Dialect) ([]PlannedMigration, error) {
+	logMap, err := mg.GetMigrationLog()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := make([]PlannedMigration, 0)
+	for _, m := range mg.migrations {
+		if _, exists := logMap[m.Id()]; exists {
+			continue
+		}
+
+		planned := PlannedMigration{ID: m.Id()}
+		if _, ok := m.(CodeMigration); ok {
+			planned.CodeMigration = true
+		} else {
+			planned.SQL = m.SQL(dialect)
+		}
+
+		if condition := m.GetCondition(); condition != nil && dialect.DriverName() == mg.Dialect.DriverName() {
+			sql, args := condition.SQL(dialect)
+			if sql != "" {
+				results, err := mg.DBEngine.SQL(sql, args...).Query()
+				if err != nil {
+					return nil, fmt.Errorf("failed to check the condition of migration %s: %w", m.Id(), err)
+				}
+				planned.Skipped = !condition.IsFulfilled(results)
+			}
+		}
+
+		plan = append(plan, planned)
+	}
+
+	return plan, nil
+}
+
 func (mg *Migrator) Start(isDatabaseLockingEnabled bool, lockAttemptTimeout int) (err error) {
 	if !isDatabaseLockingEnabled {
 		return mg.run()
@@ -153,7 +202,12 @@ func (mg *Migrator) run() (err error) {
 			Timestamp:   time.Now(),
 		}
 
-		err := mg.InTransaction(func(sess *xorm.Session) error {
+		inTransaction := mg.InTransaction
+		if noTransaction, ok := m.(NoTransactionMigration); ok && noTransaction.NoTransaction() {
+			inTransaction = mg.withoutTransaction
+		}
+
+		err := inTransaction(func(sess *xorm.Session) error {
 			err := mg.exec(m, sess)
 			if err != nil {
 				mg.Logger.Error("Exec failed", "error", err, "sql", sql)
@@ -227,6 +281,15 @@ func (mg *Migrator) exec(m Migration, sess *xorm.Session) error {
 
 type dbTransactionFunc func(sess *xorm.Session) error
 
+// withoutTransaction runs the callback with a session in autocommit mode, for the statements that cannot run in a
+// transaction.
+func (mg *Migrator) withoutTransaction(callback dbTransactionFunc) error {
+	sess := mg.DBEngine.NewSession()
+	defer sess.Close()
+
+	return callback(sess)
+}
+
 func (mg *Migrator) InTransaction(callback dbTransactionFunc) error {
 	sess := mg.DBEngine.NewSession()
 	defer sess.Close()
@@ -111,6 +111,12 @@ func (db *MySQLDialect) IndexCheckSQL(tableName, indexName string) (string, []in
 	return sql, args
 }
 
+// CreateIndexOnlineSQL builds the index in place, failing instead of falling back to locking the table when the
+// index cannot be built online.
+func (db *MySQLDialect) CreateIndexOnlineSQL(tableName string, index *Index) string {
+	return db.createIndexSQL(tableName, index, "", " ALGORITHM=INPLACE LOCK=NONE")
+}
+
 func (db *MySQLDialect) ColumnCheckSQL(tableName, columnName string) (string, []interface{}) {
 	args := []interface{}{tableName, columnName}
 	sql := "SELECT 1 FROM " + db.Quote("INFORMATION_SCHEMA") + "." + db.Quote("COLUMNS") + " WHERE " + db.Quote("TABLE_SCHEMA") + " = DATABASE() AND " + db.Quote("TABLE_NAME") + "=? AND " + db.Quote("COLUMN_NAME") + "=?"
@@ -315,3 +321,8 @@ func (db *MySQLDialect) getLockName() (string, error) {
 
 	return s, nil
 }
+
+// MaxBindParameters returns the limit of the placeholders of a prepared statement
+func (db *MySQLDialect) MaxBindParameters() int {
+	return 65535
+}
@@ -111,6 +111,11 @@ func (db *PostgresDialect) IndexCheckSQL(tableName, indexName string) (string, [
 	return sql, args
 }
 
+// CreateIndexOnlineSQL builds the index concurrently so that writes to the table are not blocked.
+func (db *PostgresDialect) CreateIndexOnlineSQL(tableName string, index *Index) string {
+	return db.createIndexSQL(tableName, index, " CONCURRENTLY", "")
+}
+
 func (db *PostgresDialect) DropIndexSQL(tableName string, index *Index) string {
 	quote := db.Quote
 	idxName := index.XName(tableName)
@@ -363,3 +368,8 @@ func (db *PostgresDialect) getLockKey() (string, error) {
 	}
 	return key, nil
 }
+
+// MaxBindParameters is limited by the wire protocol, which counts the parameters of a statement on 16 bits
+func (db *PostgresDialect) MaxBindParameters() int {
+	return 65535
+}
@@ -204,3 +204,8 @@ func (db *SQLite3) UpsertMultipleSQL(tableName string, keyCols, updateCols []str
 	)
 	return s, nil
 }
+
+// MaxBindParameters returns the default SQLITE_MAX_VARIABLE_NUMBER of the versions before 3.32.0
+func (db *SQLite3) MaxBindParameters() int {
+	return 999
+}
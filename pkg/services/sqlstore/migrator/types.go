@@ -30,6 +30,13 @@ type CodeMigration interface {
 	Exec(sess *xorm.Session, migrator *Migrator) error
 }
 
+// NoTransactionMigration is implemented by the migrations that cannot run in a transaction, like the creation of an
+// index concurrently on Postgres. They are recorded in the migration log once their statement succeeded.
+type NoTransactionMigration interface {
+	Migration
+	NoTransaction() bool
+}
+
 type SQLType string
 
 type ColumnType string
@@ -109,7 +109,7 @@ func (ss *SQLStore) createOrg(ctx context.Context, name string, userID int64, en
 		Created: time.Now(),
 		Updated: time.Now(),
 	}
-	if err := inTransactionWithRetryCtx(ctx, engine, ss.bus, func(sess *DBSession) error {
+	if err := inTransactionWithRetryCtx(ctx, engine, ss.bus, ss.sessionRetries(), func(sess *DBSession) error {
 		if isNameTaken, err := isOrgNameTaken(name, 0, sess); err != nil {
 			return err
 		} else if isNameTaken {
@@ -231,7 +231,11 @@ func (ss *SQLStore) DeleteOrg(ctx context.Context, cmd *models.DeleteOrgCommand)
 			"DELETE FROM star WHERE EXISTS (SELECT 1 FROM dashboard WHERE org_id = ? AND star.dashboard_id = dashboard.id)",
 			"DELETE FROM dashboard_tag WHERE EXISTS (SELECT 1 FROM dashboard WHERE org_id = ? AND dashboard_tag.dashboard_id = dashboard.id)",
 			"DELETE FROM dashboard WHERE org_id = ?",
+			"DELETE FROM api_key_fingerprint WHERE api_key_id IN (SELECT id FROM api_key WHERE org_id = ?)",
+			"DELETE FROM api_key_leak WHERE api_key_id IN (SELECT id FROM api_key WHERE org_id = ?)",
 			"DELETE FROM api_key WHERE org_id = ?",
+			"DELETE FROM api_key_usage WHERE org_id = ?",
+			"DELETE FROM accesscontrol_decision WHERE org_id = ?",
 			"DELETE FROM data_source WHERE org_id = ?",
 			"DELETE FROM org_user WHERE org_id = ?",
 			"DELETE FROM org WHERE id = ?",
@@ -247,6 +251,10 @@ func (ss *SQLStore) DeleteOrg(ctx context.Context, cmd *models.DeleteOrgCommand)
 			"DELETE FROM alert WHERE org_id = ?",
 			"DELETE FROM annotation WHERE org_id = ?",
 			"DELETE FROM kv_store WHERE org_id = ?",
+			"DELETE FROM secrets WHERE org_id = ?",
+			"DELETE FROM secrets_history WHERE org_id = ?",
+			"DELETE FROM secrets_audit WHERE org_id = ?",
+			"DELETE FROM secrets_plugin_migration WHERE org_id = ?",
 		}
 
 		for _, sql := range deletes {
@@ -256,6 +264,12 @@ func (ss *SQLStore) DeleteOrg(ctx context.Context, cmd *models.DeleteOrgCommand)
 			}
 		}
 
+		// the secrets stored outside of the database are deleted by the listeners of the event
+		sess.publishAfterCommit(&events.OrgDeleted{
+			Timestamp: time.Now(),
+			Id:        cmd.Id,
+		})
+
 		return nil
 	})
 }
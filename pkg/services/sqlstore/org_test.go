@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/dashboards"
@@ -592,3 +593,88 @@ func getDashboardACLInfoList(s *SQLStore, query *models.GetDashboardACLInfoListQ
 
 	return nil
 }
+
+func TestIntegrationDeleteOrgCascade(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	ctx := context.Background()
+	sqlStore := InitTestDB(t)
+
+	var deleted []int64
+	sqlStore.Bus().AddEventListener(func(_ context.Context, e *events.OrgDeleted) error {
+		deleted = append(deleted, e.Id)
+		return nil
+	})
+
+	orgIDs := make([]int64, 0, 2)
+	for _, name := range []string{"deleted org", "kept org"} {
+		cmd := &models.CreateOrgCommand{Name: name}
+		require.NoError(t, sqlStore.CreateOrg(ctx, cmd))
+		orgIDs = append(orgIDs, cmd.Result.Id)
+	}
+	now := time.Now()
+	keyIDs := make([]int64, 0, len(orgIDs))
+	require.NoError(t, sqlStore.WithDbSession(ctx, func(sess *DBSession) error {
+		for _, orgID := range orgIDs {
+			if _, err := sess.Exec("INSERT INTO api_key (org_id, name, "+sqlStore.Dialect.Quote("key")+", role, created, updated) VALUES (?, 'key', ?, 'Viewer', ?, ?)",
+				orgID, fmt.Sprint("hash", orgID), now, now); err != nil {
+				return err
+			}
+			var keyID int64
+			if _, err := sess.Table("api_key").Where("org_id = ?", orgID).Cols("id").Get(&keyID); err != nil {
+				return err
+			}
+			keyIDs = append(keyIDs, keyID)
+
+			inserts := [][]interface{}{
+				{"INSERT INTO api_key_usage (api_key_id, org_id, route, period_start, period_seconds, requests) VALUES (?, ?, '/api/search/', ?, 3600, 1)",
+					keyID, orgID, now.Unix()},
+				{"INSERT INTO api_key_fingerprint (api_key_id, hash, created) VALUES (?, ?, ?)",
+					keyID, fmt.Sprint("fingerprint", orgID), now},
+				{"INSERT INTO api_key_leak (hash, api_key_id, source, created) VALUES (?, ?, 'github', ?)",
+					fmt.Sprint("leak", orgID), keyID, now},
+				{"INSERT INTO accesscontrol_decision (org_id, user_id, api_key_id, action, scopes, allowed, matched_scope, created) VALUES (?, 1, ?, 'dashboards:read', '[]', ?, '', ?)",
+					orgID, keyID, sqlStore.Dialect.BooleanStr(true), now},
+				{"INSERT INTO secrets (org_id, namespace, type, value, created, updated) VALUES (?, 'ds', 'datasource', 'value', ?, ?)",
+					orgID, now, now},
+				{"INSERT INTO secrets_history (org_id, namespace, type, version, value, created) VALUES (?, 'ds', 'datasource', 1, 'value', ?)",
+					orgID, now},
+				{"INSERT INTO secrets_audit (org_id, namespace, type, operation, caller, user_id, outcome, created) VALUES (?, 'ds', 'datasource', 'read', 'test', 1, 'success', ?)",
+					orgID, now},
+				{"INSERT INTO secrets_plugin_migration (secret_id, org_id, namespace, type, migrated) VALUES (?, ?, 'ds', 'datasource', ?)",
+					orgID, orgID, now},
+			}
+			for _, insert := range inserts {
+				if _, err := sess.Exec(insert...); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+
+	require.NoError(t, sqlStore.DeleteOrg(ctx, &models.DeleteOrgCommand{Id: orgIDs[0]}))
+	require.Equal(t, []int64{orgIDs[0]}, deleted)
+
+	count := func(t *testing.T, table, where string, arg int64) int64 {
+		t.Helper()
+		var count int64
+		require.NoError(t, sqlStore.WithDbSession(ctx, func(sess *DBSession) error {
+			var err error
+			count, err = sess.Table(table).Where(where, arg).Count()
+			return err
+		}))
+		return count
+	}
+	for _, table := range []string{"api_key", "api_key_usage", "accesscontrol_decision", "secrets", "secrets_history", "secrets_audit", "secrets_plugin_migration"} {
+		for i, orgID := range orgIDs {
+			require.Equal(t, int64(i), count(t, table, "org_id = ?", orgID), "rows of org %d in %s", orgID, table)
+		}
+	}
+	for _, table := range []string{"api_key_fingerprint", "api_key_leak"} {
+		for i, keyID := range keyIDs {
+			require.Equal(t, int64(i), count(t, table, "api_key_id = ?", keyID), "rows of key %d in %s", keyID, table)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package sqlstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unknownService is the service label of the queries of the contexts that were not tagged with WithServiceName
+const unknownService = "unknown"
+
+var (
+	databaseServiceQueriesCounter   *prometheus.CounterVec
+	databaseServiceQueriesHistogram *prometheus.HistogramVec
+)
+
+func init() {
+	databaseServiceQueriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana",
+		Name:      "database_service_queries_total",
+		Help:      "Number of database queries by calling service and status",
+	}, []string{"service", "status"})
+
+	databaseServiceQueriesHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "grafana",
+		Name:      "database_service_queries_duration_seconds",
+		Help:      "Database query histogram by calling service",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"service"})
+
+	prometheus.MustRegister(databaseServiceQueriesCounter, databaseServiceQueriesHistogram)
+}
+
+// serviceNameKey is used as key to save the name of the calling service in `context.Context`
+type serviceNameKey struct{}
+
+// WithServiceName returns a context tagging the database queries run with it with the name of the calling service,
+// so that the database metrics can be attributed to it. The name must be a constant, as it is used as a metric label.
+func WithServiceName(ctx context.Context, service string) context.Context {
+	return context.WithValue(ctx, serviceNameKey{}, service)
+}
+
+// ServiceNameFromContext returns the name of the service the context was tagged with, or "unknown".
+func ServiceNameFromContext(ctx context.Context) string {
+	if service, ok := ctx.Value(serviceNameKey{}).(string); ok && service != "" {
+		return service
+	}
+	return unknownService
+}
+
+// observeServiceQuery records a query of the service the context was tagged with
+func observeServiceQuery(ctx context.Context, status string, elapsed time.Duration) {
+	service := ServiceNameFromContext(ctx)
+	databaseServiceQueriesCounter.WithLabelValues(service, status).Inc()
+	databaseServiceQueriesHistogram.WithLabelValues(service).Observe(elapsed.Seconds())
+}
@@ -13,6 +13,7 @@ var sessionLogger = log.New("sqlstore.session")
 
 type DBSession struct {
 	*xorm.Session
+	engine          *xorm.Engine
 	transactionOpen bool
 	events          []interface{}
 }
@@ -29,13 +30,13 @@ func (sess *DBSession) PublishAfterCommit(msg interface{}) {
 
 // NewSession returns a new DBSession
 func (ss *SQLStore) NewSession(ctx context.Context) *DBSession {
-	sess := &DBSession{Session: ss.engine.NewSession()}
+	sess := &DBSession{Session: ss.engine.NewSession(), engine: ss.engine}
 	sess.Session = sess.Session.Context(ctx)
 	return sess
 }
 
 func (ss *SQLStore) newSession(ctx context.Context) *DBSession {
-	sess := &DBSession{Session: ss.engine.NewSession()}
+	sess := &DBSession{Session: ss.engine.NewSession(), engine: ss.engine}
 	sess.Session = sess.Session.Context(ctx)
 
 	return sess
@@ -52,7 +53,7 @@ func startSessionOrUseExisting(ctx context.Context, engine *xorm.Engine, beginTr
 		return sess, false, nil
 	}
 
-	newSess := &DBSession{Session: engine.NewSession(), transactionOpen: beginTran}
+	newSess := &DBSession{Session: engine.NewSession(), engine: engine, transactionOpen: beginTran}
 	if beginTran {
 		err := newSess.Begin()
 		if err != nil {
@@ -64,7 +65,9 @@ func startSessionOrUseExisting(ctx context.Context, engine *xorm.Engine, beginTr
 	return newSess, true, nil
 }
 
-// WithDbSession calls the callback with a session.
+// WithDbSession calls the callback with a session. The statements of the session are committed one by one, so
+// unlike transactions the session is not retried when a statement fails because of a deadlock or a serialization
+// failure: the statements committed before it would run twice.
 func (ss *SQLStore) WithDbSession(ctx context.Context, callback DBTransactionFunc) error {
 	return withDbSession(ctx, ss.engine, callback)
 }
@@ -58,15 +58,19 @@ type SQLStore struct {
 	migrations                  registry.DatabaseMigrator
 	tracer                      tracing.Tracer
 	metrics                     struct {
-		maxOpenConnections prometheus.Gauge
-		openConnections    prometheus.Gauge
-		inUse              prometheus.Gauge
-		idle               prometheus.Gauge
-		waitCount          prometheus.Counter
-		waitDuration       prometheus.Counter
-		maxIdleClosed      prometheus.Counter
-		maxIdleTimeClosed  prometheus.Counter
-		maxLifetimeClosed  prometheus.Counter
+		maxOpenConnections *prometheus.Desc
+		openConnections    *prometheus.Desc
+		inUse              *prometheus.Desc
+		idle               *prometheus.Desc
+		waitCount          *prometheus.Desc
+		waitDuration       *prometheus.Desc
+		maxIdleClosed      *prometheus.Desc
+		maxIdleTimeClosed  *prometheus.Desc
+		maxLifetimeClosed  *prometheus.Desc
+	}
+	poolHealth struct {
+		sync.Mutex
+		lastWaitCount int64
 	}
 }
 
@@ -96,6 +100,13 @@ func ProvideService(cfg *setting.Cfg, cacheService *localcache.CacheService, mig
 	return s, nil
 }
 
+// ProvideServiceWithoutMigrations connects to the database without running the migrations nor creating the default
+// organization and user. It is used by the commands that inspect the database before it is migrated.
+func ProvideServiceWithoutMigrations(cfg *setting.Cfg, migrations registry.DatabaseMigrator, bus bus.Bus, tracer tracing.Tracer) (*SQLStore, error) {
+	xorm.DefaultPostgresSchema = ""
+	return newSQLStore(cfg, nil, nil, migrations, bus, tracer, InitTestDBOpt{EnsureDefaultOrgAndUser: false})
+}
+
 func ProvideServiceForTests(migrations registry.DatabaseMigrator) (*SQLStore, error) {
 	return initTestDB(migrations, InitTestDBOpt{EnsureDefaultOrgAndUser: true})
 }
@@ -157,6 +168,23 @@ func (ss *SQLStore) Migrate(isDatabaseLockingEnabled bool) error {
 	return migrator.Start(isDatabaseLockingEnabled, ss.dbCfg.MigrationLockAttemptTimeout)
 }
 
+// PlanMigrations returns the migrations that have not been executed yet, with the SQL they would execute for the
+// database type, or for the database the store is connected to when it is empty.
+func (ss *SQLStore) PlanMigrations(dbType string) ([]migrator.PlannedMigration, error) {
+	mg := migrator.NewMigrator(ss.engine, ss.Cfg)
+	ss.migrations.AddMigration(mg)
+
+	dialect := ss.Dialect
+	if dbType != "" {
+		var err error
+		if dialect, err = migrator.NewDialectForDriver(dbType, ss.engine); err != nil {
+			return nil, err
+		}
+	}
+
+	return mg.Plan(dialect)
+}
+
 // Sync syncs changes to the database.
 func (ss *SQLStore) Sync() error {
 	return ss.engine.Sync2()
@@ -331,6 +359,7 @@ func (ss *SQLStore) buildConnectionString() (string, error) {
 		}
 
 		cnnstr = fmt.Sprintf("file:%s?cache=%s&mode=rwc", ss.dbCfg.Path, ss.dbCfg.CacheMode)
+		cnnstr += ss.buildSQLitePragmas()
 		cnnstr += ss.buildExtraConnectionString('&')
 	default:
 		return "", fmt.Errorf("unknown database type: %s", ss.dbCfg.Type)
@@ -339,6 +368,19 @@ func (ss *SQLStore) buildConnectionString() (string, error) {
 	return cnnstr, nil
 }
 
+// buildSQLitePragmas returns the parameters of the connection string setting the pragmas of the SQLite database,
+// the driver applies them to every connection it opens.
+func (ss *SQLStore) buildSQLitePragmas() string {
+	pragmas := fmt.Sprintf("&_busy_timeout=%d", ss.dbCfg.SQLiteBusyTimeout.Milliseconds())
+	if ss.dbCfg.SQLiteJournalMode != "" {
+		pragmas += "&_journal_mode=" + ss.dbCfg.SQLiteJournalMode
+	}
+	if ss.dbCfg.SQLiteSynchronous != "" {
+		pragmas += "&_synchronous=" + ss.dbCfg.SQLiteSynchronous
+	}
+	return pragmas
+}
+
 // initEngine initializes ss.engine.
 func (ss *SQLStore) initEngine(engine *xorm.Engine) error {
 	if ss.engine != nil {
@@ -351,8 +393,8 @@ func (ss *SQLStore) initEngine(engine *xorm.Engine) error {
 		return err
 	}
 
-	if ss.Cfg.IsFeatureToggleEnabled(featuremgmt.FlagDatabaseMetrics) {
-		ss.dbCfg.Type = WrapDatabaseDriverWithHooks(ss.dbCfg.Type, ss.tracer)
+	if ss.Cfg.IsFeatureToggleEnabled(featuremgmt.FlagDatabaseMetrics) || ss.dbCfg.InstrumentQueries || ss.dbCfg.SlowQueryThreshold > 0 {
+		ss.dbCfg.Type = WrapDatabaseDriverWithHooks(ss.dbCfg.Type, ss.tracer, ss.dbCfg.SlowQueryThreshold)
 	}
 
 	sqlog.Info("Connecting to DB", "dbtype", ss.dbCfg.Type)
@@ -459,121 +501,94 @@ func (ss *SQLStore) readConfig() error {
 	ss.dbCfg.IsolationLevel = sec.Key("isolation_level").String()
 
 	ss.dbCfg.CacheMode = sec.Key("cache_mode").MustString("private")
+	ss.dbCfg.SQLiteJournalMode = strings.ToUpper(sec.Key("journal_mode").String())
+	if err := validateSQLitePragma("journal_mode", ss.dbCfg.SQLiteJournalMode, "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"); err != nil {
+		return err
+	}
+	ss.dbCfg.SQLiteBusyTimeout = sec.Key("busy_timeout").MustDuration(5 * time.Second)
+	ss.dbCfg.SQLiteSynchronous = strings.ToUpper(sec.Key("synchronous").String())
+	if err := validateSQLitePragma("synchronous", ss.dbCfg.SQLiteSynchronous, "OFF", "NORMAL", "FULL", "EXTRA"); err != nil {
+		return err
+	}
 	ss.dbCfg.SkipMigrations = sec.Key("skip_migrations").MustBool()
 	ss.dbCfg.MigrationLockAttemptTimeout = sec.Key("locking_attempt_timeout_sec").MustInt()
+	ss.dbCfg.DeadlockRetries = sec.Key("deadlock_retries").MustInt(5)
+	ss.dbCfg.DeadlockRetryInterval = sec.Key("deadlock_retry_interval").MustDuration(10 * time.Millisecond)
+	ss.dbCfg.InstrumentQueries = sec.Key("instrument_queries").MustBool(false)
+	ss.dbCfg.SlowQueryThreshold = sec.Key("slow_query_threshold").MustDuration(0)
 	return nil
 }
 
-// initMetrics initializes the database connection metrics
+// validateSQLitePragma checks that the value of a pragma is empty, to keep the default of SQLite, or one of the
+// allowed values.
+func validateSQLitePragma(name string, value string, allowed ...string) error {
+	if value == "" {
+		return nil
+	}
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid database %s %q, expected one of %s", name, value, strings.Join(allowed, ", "))
+}
+
+// initMetrics initializes the database connection metrics. They are labelled with the name of the database so that
+// the connection pools of several databases can be told apart.
 func (ss *SQLStore) initMetrics() {
 	namespace := "grafana"
 	subsystem := "database"
-
-	ss.metrics.maxOpenConnections = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Subsystem: subsystem,
-		Name:      "conn_max_open",
-		Help:      "Maximum number of open connections to the database",
-	})
-
-	ss.metrics.openConnections = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Subsystem: subsystem,
-		Name:      "conn_open",
-		Help:      "The number of established connections both in use and idle",
-	})
-
-	ss.metrics.inUse = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Subsystem: subsystem,
-		Name:      "conn_in_use",
-		Help:      "The number of connections currently in use",
-	})
-
-	ss.metrics.idle = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Subsystem: subsystem,
-		Name:      "conn_idle",
-		Help:      "The number of idle connections",
-	})
-
-	ss.metrics.waitCount = prometheus.NewCounter(prometheus.CounterOpts{
-		Namespace: namespace,
-		Subsystem: subsystem,
-		Name:      "conn_wait_count_total",
-		Help:      "The total number of connections waited for",
-	})
-
-	ss.metrics.waitDuration = prometheus.NewCounter(prometheus.CounterOpts{
-		Namespace: namespace,
-		Subsystem: subsystem,
-		Name:      "conn_wait_duration_seconds",
-		Help:      "The total time blocked waiting for a new connection",
-	})
-
-	ss.metrics.maxIdleClosed = prometheus.NewCounter(prometheus.CounterOpts{
-		Namespace: namespace,
-		Subsystem: subsystem,
-		Name:      "conn_max_idle_closed_total",
-		Help:      "The total number of connections closed due to SetMaxIdleConns",
-	})
-
-	ss.metrics.maxIdleTimeClosed = prometheus.NewCounter(prometheus.CounterOpts{
-		Namespace: namespace,
-		Subsystem: subsystem,
-		Name:      "conn_max_idle_closed_seconds",
-		Help:      "The total number of connections closed due to SetConnMaxIdleTime",
-	})
-
-	ss.metrics.maxLifetimeClosed = prometheus.NewCounter(prometheus.CounterOpts{
-		Namespace: namespace,
-		Subsystem: subsystem,
-		Name:      "conn_max_lifetime_closed_total",
-		Help:      "The total number of connections closed due to SetConnMaxLifetime",
-	})
+	labels := prometheus.Labels{"database": ss.databaseName()}
+	newDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, nil, labels)
+	}
+
+	ss.metrics.maxOpenConnections = newDesc("conn_max_open", "Maximum number of open connections to the database")
+	ss.metrics.openConnections = newDesc("conn_open", "The number of established connections both in use and idle")
+	ss.metrics.inUse = newDesc("conn_in_use", "The number of connections currently in use")
+	ss.metrics.idle = newDesc("conn_idle", "The number of idle connections")
+	ss.metrics.waitCount = newDesc("conn_wait_count_total", "The total number of connections waited for")
+	ss.metrics.waitDuration = newDesc("conn_wait_duration_seconds", "The total time blocked waiting for a new connection")
+	ss.metrics.maxIdleClosed = newDesc("conn_max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns")
+	ss.metrics.maxIdleTimeClosed = newDesc("conn_max_idle_closed_seconds", "The total number of connections closed due to SetConnMaxIdleTime")
+	ss.metrics.maxLifetimeClosed = newDesc("conn_max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime")
 }
 
-// collectDBStats instruments connections stats from the database.
-func (ss *SQLStore) collectDBstats() {
-	dbstats := ss.engine.DB().Stats()
-	ss.metrics.maxOpenConnections.Set(float64(dbstats.MaxOpenConnections))
-	ss.metrics.openConnections.Set(float64(dbstats.MaxOpenConnections))
-	ss.metrics.inUse.Set(float64(dbstats.InUse))
-	ss.metrics.idle.Set(float64(dbstats.Idle))
-
-	ss.metrics.waitCount.Add(float64(dbstats.WaitCount))
-	ss.metrics.waitDuration.Add(float64(dbstats.WaitDuration / time.Second))
-	ss.metrics.maxIdleClosed.Add(float64(dbstats.MaxIdleClosed))
-	ss.metrics.maxIdleTimeClosed.Add(float64(dbstats.MaxIdleTimeClosed))
-	ss.metrics.maxLifetimeClosed.Add(float64(dbstats.MaxLifetimeClosed))
+// databaseName returns the name the database is known by in the metrics and the health checks.
+func (ss *SQLStore) databaseName() string {
+	if ss.dbCfg.Name != "" {
+		return ss.dbCfg.Name
+	}
+	return ss.dbCfg.Type
 }
 
-// Collect implements Prometheus.Collector.
+// Collect implements Prometheus.Collector. The statistics of the connection pool are already cumulative, so they
+// are reported as they are rather than added to counters on every scrape.
 func (ss *SQLStore) Collect(ch chan<- prometheus.Metric) {
-	ss.collectDBstats()
+	dbstats := ss.engine.DB().Stats()
 
-	ss.metrics.maxOpenConnections.Collect(ch)
-	ss.metrics.openConnections.Collect(ch)
-	ss.metrics.inUse.Collect(ch)
-	ss.metrics.idle.Collect(ch)
-	ss.metrics.waitCount.Collect(ch)
-	ss.metrics.waitDuration.Collect(ch)
-	ss.metrics.maxIdleClosed.Collect(ch)
-	ss.metrics.maxIdleTimeClosed.Collect(ch)
-	ss.metrics.maxLifetimeClosed.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(ss.metrics.maxOpenConnections, prometheus.GaugeValue, float64(dbstats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(ss.metrics.openConnections, prometheus.GaugeValue, float64(dbstats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(ss.metrics.inUse, prometheus.GaugeValue, float64(dbstats.InUse))
+	ch <- prometheus.MustNewConstMetric(ss.metrics.idle, prometheus.GaugeValue, float64(dbstats.Idle))
+	ch <- prometheus.MustNewConstMetric(ss.metrics.waitCount, prometheus.CounterValue, float64(dbstats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(ss.metrics.waitDuration, prometheus.CounterValue, dbstats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(ss.metrics.maxIdleClosed, prometheus.CounterValue, float64(dbstats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(ss.metrics.maxIdleTimeClosed, prometheus.CounterValue, float64(dbstats.MaxIdleTimeClosed))
+	ch <- prometheus.MustNewConstMetric(ss.metrics.maxLifetimeClosed, prometheus.CounterValue, float64(dbstats.MaxLifetimeClosed))
 }
 
 // Describe implements Prometheus.Collector.
 func (ss *SQLStore) Describe(ch chan<- *prometheus.Desc) {
-	ss.metrics.maxOpenConnections.Describe(ch)
-	ss.metrics.openConnections.Describe(ch)
-	ss.metrics.inUse.Describe(ch)
-	ss.metrics.idle.Describe(ch)
-	ss.metrics.waitCount.Describe(ch)
-	ss.metrics.waitDuration.Describe(ch)
-	ss.metrics.maxIdleClosed.Describe(ch)
-	ss.metrics.maxIdleTimeClosed.Describe(ch)
-	ss.metrics.maxLifetimeClosed.Describe(ch)
+	ch <- ss.metrics.maxOpenConnections
+	ch <- ss.metrics.openConnections
+	ch <- ss.metrics.inUse
+	ch <- ss.metrics.idle
+	ch <- ss.metrics.waitCount
+	ch <- ss.metrics.waitDuration
+	ch <- ss.metrics.maxIdleClosed
+	ch <- ss.metrics.maxIdleTimeClosed
+	ch <- ss.metrics.maxLifetimeClosed
 }
 
 // ITestDB is an interface of arguments for testing db
@@ -786,7 +801,14 @@ type DatabaseConfig struct {
 	MaxIdleConn                 int
 	ConnMaxLifetime             int
 	CacheMode                   string
+	SQLiteJournalMode           string
+	SQLiteBusyTimeout           time.Duration
+	SQLiteSynchronous           string
 	UrlQueryParams              map[string][]string
 	SkipMigrations              bool
 	MigrationLockAttemptTimeout int
+	DeadlockRetries             int
+	DeadlockRetryInterval       time.Duration
+	InstrumentQueries           bool
+	SlowQueryThreshold          time.Duration
 }
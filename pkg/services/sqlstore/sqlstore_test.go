@@ -3,10 +3,12 @@ package sqlstore
 import (
 	"errors"
 	"net/url"
+	"path/filepath"
 	"testing"
 
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/stretchr/testify/require"
+	"xorm.io/xorm"
 )
 
 type sqlStoreTest struct {
@@ -122,3 +124,54 @@ func makeSQLStoreTestConfig(t *testing.T, dbType, host, dbURL string) *setting.C
 
 	return cfg
 }
+
+func TestIntegrationSQLitePragmas(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	newStore := func(t *testing.T, keys map[string]string) *SQLStore {
+		cfg := setting.NewCfg()
+		sec, err := cfg.Raw.NewSection("database")
+		require.NoError(t, err)
+		_, err = sec.NewKey("type", "sqlite3")
+		require.NoError(t, err)
+		_, err = sec.NewKey("path", filepath.Join(t.TempDir(), "grafana.db"))
+		require.NoError(t, err)
+		for key, value := range keys {
+			_, err = sec.NewKey(key, value)
+			require.NoError(t, err)
+		}
+		cfg.IsFeatureToggleEnabled = func(key string) bool { return false }
+		return &SQLStore{Cfg: cfg}
+	}
+
+	t.Run("sets the pragmas in the connection string", func(t *testing.T) {
+		ss := newStore(t, map[string]string{"journal_mode": "wal", "busy_timeout": "10s", "synchronous": "normal"})
+		connStr, err := ss.buildConnectionString()
+		require.NoError(t, err)
+		require.Contains(t, connStr, "&_busy_timeout=10000&_journal_mode=WAL&_synchronous=NORMAL")
+
+		engine, err := xorm.NewEngine("sqlite3", connStr)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, engine.Close()) })
+		results, err := engine.QueryString("PRAGMA journal_mode")
+		require.NoError(t, err)
+		require.Equal(t, "wal", results[0]["journal_mode"])
+	})
+
+	t.Run("keeps the defaults of SQLite", func(t *testing.T) {
+		connStr, err := newStore(t, nil).buildConnectionString()
+		require.NoError(t, err)
+		require.Contains(t, connStr, "&_busy_timeout=5000")
+		require.NotContains(t, connStr, "_journal_mode")
+		require.NotContains(t, connStr, "_synchronous")
+	})
+
+	t.Run("rejects the invalid values", func(t *testing.T) {
+		_, err := newStore(t, map[string]string{"journal_mode": "fast"}).buildConnectionString()
+		require.Error(t, err)
+		_, err = newStore(t, map[string]string{"synchronous": "sometimes"}).buildConnectionString()
+		require.Error(t, err)
+	})
+}
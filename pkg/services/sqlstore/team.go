@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/models"
 	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/user"
@@ -145,9 +146,18 @@ func (ss *SQLStore) DeleteTeam(ctx context.Context, cmd *models.DeleteTeamComman
 			}
 		}
 
-		_, err := sess.Exec("DELETE FROM permission WHERE scope=?", ac.Scope("teams", "id", fmt.Sprint(cmd.Id)))
+		// the API keys of the team are kept, the organization owns them
+		if _, err := sess.Exec("UPDATE api_key SET owner_team_id = NULL WHERE org_id=? and owner_team_id = ?", cmd.OrgId, cmd.Id); err != nil {
+			return err
+		}
 
-		return err
+		if _, err := sess.Exec("DELETE FROM permission WHERE scope=?", ac.Scope("teams", "id", fmt.Sprint(cmd.Id))); err != nil {
+			return err
+		}
+
+		// the permissions of the members and the permissions on the team are removed
+		sess.PublishAfterCommit(&events.AccessControlPermissionsChanged{Timestamp: time.Now(), OrgID: cmd.OrgId, UserID: 0})
+		return nil
 	})
 }
 
@@ -421,8 +431,12 @@ func addTeamMember(sess *DBSession, orgID, teamID, userID int64, isExternal bool
 		Permission: permission,
 	}
 
-	_, err := sess.Insert(&entity)
-	return err
+	if _, err := sess.Insert(&entity); err != nil {
+		return err
+	}
+
+	sess.PublishAfterCommit(&events.TeamMemberChanged{Timestamp: entity.Created, OrgID: orgID, TeamID: teamID, UserID: userID})
+	return nil
 }
 
 func updateTeamMember(sess *DBSession, orgID, teamID, userID int64, permission models.PermissionType) error {
@@ -442,8 +456,12 @@ func updateTeamMember(sess *DBSession, orgID, teamID, userID int64, permission m
 	}
 
 	member.Permission = permission
-	_, err = sess.Cols("permission").Where("org_id=? and team_id=? and user_id=?", orgID, teamID, userID).Update(member)
-	return err
+	if _, err := sess.Cols("permission").Where("org_id=? and team_id=? and user_id=?", orgID, teamID, userID).Update(member); err != nil {
+		return err
+	}
+
+	sess.PublishAfterCommit(&events.TeamMemberChanged{Timestamp: time.Now(), OrgID: orgID, TeamID: teamID, UserID: userID})
+	return nil
 }
 
 // RemoveTeamMember removes a member from a team
@@ -478,8 +496,12 @@ func removeTeamMember(sess *DBSession, cmd *models.RemoveTeamMemberCommand) erro
 	if rows == 0 {
 		return models.ErrTeamMemberNotFound
 	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	sess.PublishAfterCommit(&events.TeamMemberChanged{Timestamp: time.Now(), OrgID: cmd.OrgId, TeamID: cmd.TeamId, UserID: cmd.UserId})
+	return nil
 }
 
 func isLastAdmin(sess *DBSession, orgId int64, teamId int64, userId int64) (bool, error) {
@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/models"
 	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/serviceaccounts"
@@ -585,3 +586,54 @@ func TestIntegrationSQLStore_GetTeamMembers_ACFilter(t *testing.T) {
 		})
 	}
 }
+
+func TestIntegrationTeamMemberChangedEvents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	sqlStore := InitTestDB(t)
+	var changed []events.TeamMemberChanged
+	sqlStore.Bus().AddEventListener(func(_ context.Context, e *events.TeamMemberChanged) error {
+		changed = append(changed, *e)
+		return nil
+	})
+
+	team, err := sqlStore.CreateTeam("group", "group@test.com", 1)
+	require.NoError(t, err)
+	usr, err := sqlStore.CreateUser(context.Background(), user.CreateUserCommand{Login: "member"})
+	require.NoError(t, err)
+
+	require.NoError(t, sqlStore.AddTeamMember(usr.ID, 1, team.Id, false, 0))
+	require.NoError(t, sqlStore.UpdateTeamMember(context.Background(), &models.UpdateTeamMemberCommand{
+		UserId: usr.ID, OrgId: 1, TeamId: team.Id, Permission: models.PERMISSION_VIEW,
+	}))
+	require.NoError(t, sqlStore.RemoveTeamMember(context.Background(), &models.RemoveTeamMemberCommand{
+		UserId: usr.ID, OrgId: 1, TeamId: team.Id,
+	}))
+	err = sqlStore.RemoveTeamMember(context.Background(), &models.RemoveTeamMemberCommand{UserId: usr.ID, OrgId: 1, TeamId: team.Id})
+	require.ErrorIs(t, err, models.ErrTeamMemberNotFound)
+
+	require.Len(t, changed, 3)
+	for _, e := range changed {
+		assert.Equal(t, events.TeamMemberChanged{Timestamp: e.Timestamp, OrgID: 1, TeamID: team.Id, UserID: usr.ID}, e)
+	}
+}
+
+func TestIntegrationDeleteTeamPermissionsChangedEvent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	sqlStore := InitTestDB(t)
+	var changed []events.AccessControlPermissionsChanged
+	sqlStore.Bus().AddEventListener(func(_ context.Context, e *events.AccessControlPermissionsChanged) error {
+		changed = append(changed, *e)
+		return nil
+	})
+
+	team, err := sqlStore.CreateTeam("group", "group@test.com", 1)
+	require.NoError(t, err)
+	require.NoError(t, sqlStore.DeleteTeam(context.Background(), &models.DeleteTeamCommand{OrgId: 1, Id: team.Id}))
+
+	require.Len(t, changed, 1)
+	assert.Equal(t, events.AccessControlPermissionsChanged{Timestamp: changed[0].Timestamp, OrgID: 1, UserID: 0}, changed[0])
+}
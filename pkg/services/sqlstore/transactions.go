@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/VividCortex/mysqlerr"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 	"github.com/mattn/go-sqlite3"
 	"xorm.io/xorm"
 
@@ -15,9 +18,51 @@ import (
 
 var tsclogger = log.New("sqlstore.transactions")
 
+// maxRetryBackoff caps the time to wait before a retry, however many retries are configured
+const maxRetryBackoff = time.Second
+
+// sessionRetries is how many times and how soon the transactions that fail because of a deadlock or a serialization
+// failure are retried, configured with `database.deadlock_retries` and `database.deadlock_retry_interval`
+type sessionRetries struct {
+	max      int
+	interval time.Duration
+}
+
+func (ss *SQLStore) sessionRetries() sessionRetries {
+	return sessionRetries{max: ss.dbCfg.DeadlockRetries, interval: ss.dbCfg.DeadlockRetryInterval}
+}
+
+// backoff returns how long to wait before the given retry, the interval doubles with each retry up to
+// maxRetryBackoff
+func (r sessionRetries) backoff(retry int) time.Duration {
+	backoff := r.interval
+	for i := 0; i < retry && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
+
+// isRetryableError returns whether the error is a deadlock or a serialization failure, which the database resolves
+// by aborting one of the conflicting transactions so that it succeeds once retried
+func isRetryableError(err error) bool {
+	var mysqlError *mysql.MySQLError
+	if errors.As(err, &mysqlError) {
+		return mysqlError.Number == mysqlerr.ER_LOCK_DEADLOCK
+	}
+	var pqError *pq.Error
+	if errors.As(err, &pqError) {
+		// serialization_failure and deadlock_detected
+		return pqError.Code == "40001" || pqError.Code == "40P01"
+	}
+	return false
+}
+
 // WithTransactionalDbSession calls the callback with a session within a transaction.
 func (ss *SQLStore) WithTransactionalDbSession(ctx context.Context, callback DBTransactionFunc) error {
-	return inTransactionWithRetryCtx(ctx, ss.engine, ss.bus, callback, 0)
+	return inTransactionWithRetryCtx(ctx, ss.engine, ss.bus, ss.sessionRetries(), callback, 0)
 }
 
 func (ss *SQLStore) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
@@ -25,13 +70,13 @@ func (ss *SQLStore) InTransaction(ctx context.Context, fn func(ctx context.Conte
 }
 
 func (ss *SQLStore) inTransactionWithRetry(ctx context.Context, fn func(ctx context.Context) error, retry int) error {
-	return inTransactionWithRetryCtx(ctx, ss.engine, ss.bus, func(sess *DBSession) error {
+	return inTransactionWithRetryCtx(ctx, ss.engine, ss.bus, ss.sessionRetries(), func(sess *DBSession) error {
 		withValue := context.WithValue(ctx, ContextSessionKey{}, sess)
 		return fn(withValue)
 	}, retry)
 }
 
-func inTransactionWithRetryCtx(ctx context.Context, engine *xorm.Engine, bus bus.Bus, callback DBTransactionFunc, retry int) error {
+func inTransactionWithRetryCtx(ctx context.Context, engine *xorm.Engine, bus bus.Bus, retries sessionRetries, callback DBTransactionFunc, retry int) error {
 	sess, isNew, err := startSessionOrUseExisting(ctx, engine, true)
 	if err != nil {
 		return err
@@ -63,7 +108,22 @@ func inTransactionWithRetryCtx(ctx context.Context, engine *xorm.Engine, bus bus
 
 		time.Sleep(time.Millisecond * time.Duration(10))
 		sqlog.Info("Database locked, sleeping then retrying", "error", err, "retry", retry)
-		return inTransactionWithRetryCtx(ctx, engine, bus, callback, retry+1)
+		return inTransactionWithRetryCtx(ctx, engine, bus, retries, callback, retry+1)
+	}
+
+	// the transactions aborted because of a deadlock or a serialization failure are retried from the beginning, the
+	// transactions of a reused session are retried by the outer scope
+	if isRetryableError(err) && retry < retries.max {
+		if rollErr := sess.Rollback(); rollErr != nil {
+			return fmt.Errorf("rolling back transaction due to error failed: %s: %w", rollErr, err)
+		}
+
+		backoff := retries.backoff(retry)
+		tsclogger.Info("Transaction deadlocked, sleeping then retrying", "error", err, "retry", retry, "backoff", backoff)
+		if err := sleepWithContext(ctx, backoff); err != nil {
+			return err
+		}
+		return inTransactionWithRetryCtx(ctx, engine, bus, retries, callback, retry+1)
 	}
 
 	if err != nil {
@@ -86,3 +146,14 @@ func inTransactionWithRetryCtx(ctx context.Context, engine *xorm.Engine, bus bus
 
 	return nil
 }
+
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
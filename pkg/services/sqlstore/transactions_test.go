@@ -2,8 +2,12 @@ package sqlstore
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/require"
 )
 
@@ -65,3 +69,78 @@ func TestIntegrationReuseSessionWithTransaction(t *testing.T) {
 		}))
 	})
 }
+
+func TestIntegrationRetryOnDeadlock(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	ss := InitTestDB(t)
+	ss.dbCfg.DeadlockRetries = 3
+	ss.dbCfg.DeadlockRetryInterval = time.Millisecond
+
+	// failing returns a callback that fails with err the given number of times
+	failing := func(times int, err error, calls *int) DBTransactionFunc {
+		return func(sess *DBSession) error {
+			*calls++
+			if *calls <= times {
+				return err
+			}
+			return nil
+		}
+	}
+
+	for name, err := range map[string]error{
+		"mysql deadlock":                 &mysql.MySQLError{Number: 1213},
+		"postgres deadlock":              &pq.Error{Code: "40P01"},
+		"postgres serialization failure": &pq.Error{Code: "40001"},
+	} {
+		t.Run("retries a transaction that failed with a "+name, func(t *testing.T) {
+			calls := 0
+			require.NoError(t, ss.WithTransactionalDbSession(context.Background(), failing(2, err, &calls)))
+			require.Equal(t, 3, calls)
+		})
+
+		t.Run("does not retry a session outside of a transaction that failed with a "+name, func(t *testing.T) {
+			calls := 0
+			require.ErrorIs(t, ss.WithDbSession(context.Background(), failing(2, err, &calls)), err)
+			require.Equal(t, 1, calls)
+		})
+	}
+
+	t.Run("returns the error once the retries are exhausted", func(t *testing.T) {
+		calls := 0
+		err := ss.WithTransactionalDbSession(context.Background(), failing(10, &pq.Error{Code: "40P01"}, &calls))
+		var pqErr *pq.Error
+		require.True(t, errors.As(err, &pqErr))
+		require.Equal(t, 4, calls)
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		calls := 0
+		err := ss.WithTransactionalDbSession(context.Background(), failing(10, &pq.Error{Code: "23505"}, &calls))
+		require.Error(t, err)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("retries the outer transaction of a reused session", func(t *testing.T) {
+		outer, inner := 0, 0
+		err := ss.InTransaction(context.Background(), func(ctx context.Context) error {
+			outer++
+			return ss.WithDbSession(ctx, failing(1, &mysql.MySQLError{Number: 1213}, &inner))
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, outer)
+		require.Equal(t, 2, inner)
+	})
+}
+
+func TestSessionRetriesBackoff(t *testing.T) {
+	retries := sessionRetries{max: 100, interval: 10 * time.Millisecond}
+	require.Equal(t, 10*time.Millisecond, retries.backoff(0))
+	require.Equal(t, 40*time.Millisecond, retries.backoff(2))
+	require.Equal(t, maxRetryBackoff, retries.backoff(10))
+	require.Equal(t, maxRetryBackoff, retries.backoff(99))
+
+	retries.interval = time.Minute
+	require.Equal(t, maxRetryBackoff, retries.backoff(0))
+}
@@ -774,6 +774,8 @@ func deleteUserAccessControl(sess *DBSession, userID int64) error {
 	if _, err := sess.Exec("DELETE FROM permission WHERE scope = ?", ac.Scope("users", "id", strconv.FormatInt(userID, 10))); err != nil {
 		return err
 	}
+	// the permissions scoped to the user were granted to other users, in any organization
+	sess.PublishAfterCommit(&events.AccessControlPermissionsChanged{Timestamp: time.Now(), OrgID: ac.GlobalOrgID, UserID: 0})
 
 	var roleIDs []int64
 	if err := sess.SQL("SELECT id FROM role WHERE name = ?", ac.ManagedUserRoleName(userID)).Find(&roleIDs); err != nil {
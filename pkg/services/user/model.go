@@ -212,6 +212,10 @@ type SignedInUser struct {
 	Teams              []int64
 	// Permissions grouped by orgID and actions
 	Permissions map[int64]map[string][]string `json:"-"`
+	// DatasourceUIDs restricts the data sources the user can query when it is
+	// signed in with an API key scoped to some data sources, the user can
+	// query any data source its permissions allow when it is nil.
+	DatasourceUIDs []string `json:"-" xorm:"-"`
 }
 
 func (u *User) NameOrFallback() string {
@@ -284,6 +288,20 @@ func (u *SignedInUser) IsApiKeyUser() bool {
 	return u.ApiKeyID > 0
 }
 
+// CanQueryDatasource returns true if the data source is not excluded by the
+// data sources the user is restricted to.
+func (u *SignedInUser) CanQueryDatasource(uid string) bool {
+	if u.DatasourceUIDs == nil {
+		return true
+	}
+	for _, allowed := range u.DatasourceUIDs {
+		if allowed == uid {
+			return true
+		}
+	}
+	return false
+}
+
 func (u *SignedInUser) HasUniqueId() bool {
 	return u.IsRealUser() || u.IsApiKeyUser()
 }
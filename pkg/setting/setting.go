@@ -49,6 +49,19 @@ const (
 	ApplicationName  = "Grafana"
 )
 
+// Algorithms used to hash the secrets of API keys
+const (
+	ApiKeyHashingPBKDF2   = "pbkdf2"
+	ApiKeyHashingArgon2id = "argon2id"
+)
+
+// Modes of the enforcement of api_key_max_seconds_to_live on existing API keys
+const (
+	ApiKeyLifetimeEnforcementDisabled = "disabled"
+	ApiKeyLifetimeEnforcementDryRun   = "dry_run"
+	ApiKeyLifetimeEnforcementEnforce  = "enforce"
+)
+
 // zoneInfo names environment variable for setting the path to look for the timezone database in go
 const zoneInfo = "ZONEINFO"
 
@@ -190,6 +203,9 @@ type Cfg struct {
 	Raw    *ini.File
 	Logger log.Logger
 
+	// args are the command line arguments the configuration was loaded with, to read it again
+	args CommandLineArgs
+
 	// HTTP Server Settings
 	CertFile         string
 	KeyFile          string
@@ -347,7 +363,31 @@ type Cfg struct {
 
 	EditorsCanAdmin bool
 
-	ApiKeyMaxSecondsToLive int64
+	ApiKeyMaxSecondsToLive    int64
+	ApiKeyLifetimeEnforcement string
+	ApiKeyMaxActivePerOrg     int64
+	ApiKeyRotationGracePeriod time.Duration
+	// ApiKeySignedRequestMaxBodyBytes is the largest body of a request
+	// signed with a signing key, which is read in memory to be hashed.
+	ApiKeySignedRequestMaxBodyBytes int64
+	ApiKeyLastUsedFlushInterval     time.Duration
+	ApiKeyHashingAlgorithm          string
+	ApiKeyArgon2idTime              int
+	ApiKeyArgon2idMemory            int
+	ApiKeyArgon2idThreads           int
+
+	ApiKeyExpiryNotificationWindow     time.Duration
+	ApiKeyExpiryNotificationEmails     []string
+	ApiKeyExpiryNotificationWebhookURL string
+
+	ApiKeyUsageInsightsEnabled bool
+
+	ApiKeyIdleRevocationPeriod        time.Duration
+	ApiKeyIdleRevocationWarningPeriod time.Duration
+	ApiKeyIdleRevocationExemptLabel   string
+
+	ApiKeySecretScanningEnabled       bool
+	ApiKeySecretScanningPublicKeysURL string
 
 	// Check if a feature toggle is enabled
 	// @deprecated
@@ -799,6 +839,36 @@ func (cfg *Cfg) loadConfiguration(args CommandLineArgs) (*ini.File, error) {
 	return parsedFile, err
 }
 
+// readConfiguration reads the configuration as loadConfiguration does, without logging it nor applying it
+func (cfg *Cfg) readConfiguration(args CommandLineArgs) (*ini.File, error) {
+	// the sources of the configuration that is applied are kept for logging purposes
+	files, properties, overrides := configFiles, appliedCommandLineProperties, appliedEnvOverrides
+	defer func() {
+		configFiles, appliedCommandLineProperties, appliedEnvOverrides = files, properties, overrides
+	}()
+
+	parsedFile, err := ini.Load(path.Join(HomePath, "conf/defaults.ini"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse defaults.ini: %w", err)
+	}
+	parsedFile.BlockMode = false
+
+	commandLineProps := cfg.getCommandLineProperties(args.Args)
+	applyCommandLineDefaultProperties(commandLineProps, parsedFile)
+	if err := cfg.loadSpecifiedConfigFile(args.Config, parsedFile); err != nil {
+		return nil, err
+	}
+	if err := applyEnvVariableOverrides(parsedFile); err != nil {
+		return nil, err
+	}
+	applyCommandLineProperties(commandLineProps, parsedFile)
+	if err := expandConfig(parsedFile); err != nil {
+		return nil, err
+	}
+
+	return parsedFile, nil
+}
+
 func pathExists(path string) bool {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -870,6 +940,7 @@ func (cfg *Cfg) validateStaticRootPath() error {
 
 func (cfg *Cfg) Load(args CommandLineArgs) error {
 	cfg.setHomePath(args)
+	cfg.args = args
 
 	// Fix for missing IANA db on Windows
 	_, zoneInfoSet := os.LookupEnv(zoneInfo)
@@ -1275,6 +1346,49 @@ func readAuthSettings(iniFile *ini.File, cfg *Cfg) (err error) {
 	}
 
 	cfg.ApiKeyMaxSecondsToLive = auth.Key("api_key_max_seconds_to_live").MustInt64(-1)
+	cfg.ApiKeyLifetimeEnforcement = valueAsString(auth, "api_key_lifetime_enforcement", ApiKeyLifetimeEnforcementDisabled)
+	switch cfg.ApiKeyLifetimeEnforcement {
+	case ApiKeyLifetimeEnforcementDisabled, ApiKeyLifetimeEnforcementDryRun, ApiKeyLifetimeEnforcementEnforce:
+	default:
+		return fmt.Errorf("unsupported api_key_lifetime_enforcement %q, expected %q, %q or %q", cfg.ApiKeyLifetimeEnforcement,
+			ApiKeyLifetimeEnforcementDisabled, ApiKeyLifetimeEnforcementDryRun, ApiKeyLifetimeEnforcementEnforce)
+	}
+	cfg.ApiKeyMaxActivePerOrg = auth.Key("api_key_max_active_per_org").MustInt64(-1)
+	cfg.ApiKeyRotationGracePeriod, err = gtime.ParseDuration(valueAsString(auth, "api_key_rotation_grace_period", "0s"))
+	if err != nil {
+		return err
+	}
+	cfg.ApiKeySignedRequestMaxBodyBytes = auth.Key("api_key_signed_request_max_body_bytes").MustInt64(10485760)
+	cfg.ApiKeyLastUsedFlushInterval, err = gtime.ParseDuration(valueAsString(auth, "api_key_last_used_flush_interval", "0s"))
+	if err != nil {
+		return err
+	}
+	cfg.ApiKeyHashingAlgorithm = valueAsString(auth, "api_key_hashing_algorithm", ApiKeyHashingPBKDF2)
+	if cfg.ApiKeyHashingAlgorithm != ApiKeyHashingPBKDF2 && cfg.ApiKeyHashingAlgorithm != ApiKeyHashingArgon2id {
+		return fmt.Errorf("unsupported api_key_hashing_algorithm %q, expected %q or %q",
+			cfg.ApiKeyHashingAlgorithm, ApiKeyHashingPBKDF2, ApiKeyHashingArgon2id)
+	}
+	cfg.ApiKeyArgon2idTime = auth.Key("api_key_argon2id_time").MustInt(1)
+	cfg.ApiKeyArgon2idMemory = auth.Key("api_key_argon2id_memory").MustInt(65536)
+	cfg.ApiKeyArgon2idThreads = auth.Key("api_key_argon2id_threads").MustInt(4)
+	cfg.ApiKeyExpiryNotificationWindow, err = gtime.ParseDuration(valueAsString(auth, "api_key_expiry_notification_window", "0s"))
+	if err != nil {
+		return err
+	}
+	cfg.ApiKeyExpiryNotificationEmails = util.SplitString(valueAsString(auth, "api_key_expiry_notification_emails", ""))
+	cfg.ApiKeyExpiryNotificationWebhookURL = valueAsString(auth, "api_key_expiry_notification_webhook_url", "")
+	cfg.ApiKeyUsageInsightsEnabled = auth.Key("api_key_usage_insights_enabled").MustBool(false)
+	cfg.ApiKeyIdleRevocationPeriod, err = gtime.ParseDuration(valueAsString(auth, "api_key_idle_revocation_period", "0s"))
+	if err != nil {
+		return err
+	}
+	cfg.ApiKeyIdleRevocationWarningPeriod, err = gtime.ParseDuration(valueAsString(auth, "api_key_idle_revocation_warning_period", "7d"))
+	if err != nil {
+		return err
+	}
+	cfg.ApiKeyIdleRevocationExemptLabel = valueAsString(auth, "api_key_idle_revocation_exempt_label", "idle-revocation-exempt")
+	cfg.ApiKeySecretScanningEnabled = auth.Key("api_key_secret_scanning_enabled").MustBool(false)
+	cfg.ApiKeySecretScanningPublicKeysURL = valueAsString(auth, "api_key_secret_scanning_public_keys_url", "https://api.github.com/meta/public_keys/secret_scanning")
 
 	cfg.TokenRotationIntervalMinutes = auth.Key("token_rotation_interval_minutes").MustInt(10)
 	if cfg.TokenRotationIntervalMinutes < 2 {
@@ -1355,7 +1469,7 @@ func readAuthSettings(iniFile *ini.File, cfg *Cfg) (err error) {
 func readAccessControlSettings(iniFile *ini.File, cfg *Cfg) {
 	rbac := iniFile.Section("rbac")
 	cfg.RBACEnabled = rbac.Key("enabled").MustBool(true)
-	cfg.RBACPermissionCache = rbac.Key("permission_cache").MustBool(true)
+	cfg.RBACPermissionCache = rbac.Key("permission_cache").MustBool(false)
 	cfg.RBACBuiltInRoleAssignmentEnabled = rbac.Key("builtin_role_assignment_enabled").MustBool(false)
 }
 
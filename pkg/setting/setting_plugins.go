@@ -45,3 +45,28 @@ func (cfg *Cfg) readPluginSettings(iniFile *ini.File) error {
 	}
 	return nil
 }
+
+// ReloadPluginSettings reads the plugin settings again from the configuration files, the environment variables and
+// the command line, so that the plugins loaded from now on get the current settings. The settings are updated in
+// place, as the map is shared with the configuration of the plugins.
+func (cfg *Cfg) ReloadPluginSettings() error {
+	iniFile, err := cfg.readConfiguration(cfg.args)
+	if err != nil {
+		return err
+	}
+
+	settings := extractPluginSettings(iniFile.Sections())
+	if cfg.PluginSettings == nil {
+		cfg.PluginSettings = settings
+		return nil
+	}
+	for pluginID := range cfg.PluginSettings {
+		if _, exists := settings[pluginID]; !exists {
+			delete(cfg.PluginSettings, pluginID)
+		}
+	}
+	for pluginID, pluginSettings := range settings {
+		cfg.PluginSettings[pluginID] = pluginSettings
+	}
+	return nil
+}
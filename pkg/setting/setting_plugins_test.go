@@ -1,6 +1,8 @@
 package setting
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -41,3 +43,19 @@ func TestPluginSettings(t *testing.T) {
 	require.Equal(t, ps["plugin2"]["key3"], "value3")
 	require.Equal(t, ps["plugin2"]["key4"], "value4")
 }
+
+func TestReloadPluginSettings(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "custom.ini")
+	require.NoError(t, os.WriteFile(configFile, []byte("[plugin.secrets]\npath = /var/lib/first\nregion = eu\n\n[plugin.other]\nkey = value\n"), 0600))
+
+	cfg := NewCfg()
+	require.NoError(t, cfg.Load(CommandLineArgs{HomePath: "../../", Config: configFile}))
+	require.Equal(t, map[string]string{"path": "/var/lib/first", "region": "eu"}, cfg.PluginSettings["secrets"])
+	settings, loadedFiles := cfg.PluginSettings, len(configFiles)
+
+	require.NoError(t, os.WriteFile(configFile, []byte("[plugin.secrets]\npath = /var/lib/second\nregion = us\n"), 0600))
+	require.NoError(t, cfg.ReloadPluginSettings())
+	require.Equal(t, map[string]string{"path": "/var/lib/second", "region": "us"}, settings["secrets"])
+	require.NotContains(t, settings, "other")
+	require.Len(t, configFiles, loadedFiles)
+}
@@ -0,0 +1,40 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/tests/testinfra"
+)
+
+func TestKVStoreSensitiveNamespaces(t *testing.T) {
+	dir, path := testinfra.CreateGrafDir(t, testinfra.GrafanaOpts{
+		KVStoreSensitiveNamespaces: []string{"sensitive"},
+	})
+	_, env := testinfra.StartGrafanaEnv(t, dir, path)
+	ctx := context.Background()
+
+	require.NoError(t, env.KVStore.Set(ctx, 1, "sensitive", "token", "secret"))
+
+	var item kvstore.Item
+	err := env.SQLStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		where := fmt.Sprintf("org_id = ? AND namespace = ? AND %s = ?", env.SQLStore.Quote("key"))
+		_, err := sess.Where(where, 1, "sensitive", "token").Get(&item)
+		return err
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(item.Value, "$encrypted$"))
+	assert.NotContains(t, item.Value, "secret")
+
+	value, ok, err := env.KVStore.Get(ctx, 1, "sensitive", "token")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "secret", value)
+}
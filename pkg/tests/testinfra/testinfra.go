@@ -291,6 +291,12 @@ func CreateGrafDir(t *testing.T, opts ...GrafanaOpts) (string, string) {
 			_, err = unifiedAlertingSection.NewKey("enabled", "true")
 			require.NoError(t, err)
 		}
+		if len(o.KVStoreSensitiveNamespaces) > 0 {
+			kvstoreSection, err := getOrCreateSection("kvstore")
+			require.NoError(t, err)
+			_, err = kvstoreSection.NewKey("sensitive_namespaces", strings.Join(o.KVStoreSensitiveNamespaces, ","))
+			require.NoError(t, err)
+		}
 		if len(o.UnifiedAlertingDisabledOrgs) > 0 {
 			unifiedAlertingSection, err := getOrCreateSection("unified_alerting")
 			require.NoError(t, err)
@@ -334,4 +340,5 @@ type GrafanaOpts struct {
 	EnableUnifiedAlerting                 bool
 	UnifiedAlertingDisabledOrgs           []int64
 	EnableLog                             bool
+	KVStoreSensitiveNamespaces            []string
 }